@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const adminUsage = `usage: depot admin <command>
+
+Commands call the API of the running server at DEPOT_EXTERNAL_URL, or
+https://localhost:DEPOT_PORT if that is unset:
+  reindex-docker [-image name]... <repo>
+            rebuild a Docker repository's manifest index from storage
+  import-oci [-tag tag] <repo> <image> <layout>
+            import an OCI image layout, a directory or tar archive, into
+            an image of a Docker repository
+  export-oci [-o file] <repo> <image> <reference>
+            write an image of a Docker repository as an OCI image layout
+            tar archive, to standard output by default
+  sync-images [-config file] <repo>
+            copy the images a Docker repository syncs from other
+            registries, after setting its sync configuration from a file
+`
+
+// stringsFlag collects the values of a repeated flag
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runAdmin runs an administrative command against the running server and
+// returns the exit code
+func runAdmin(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "reindex-docker":
+			return runReindexDocker(args[1:])
+		case "import-oci":
+			return runImportOCI(args[1:])
+		case "export-oci":
+			return runExportOCI(args[1:])
+		case "sync-images":
+			return runSyncImages(args[1:])
+		}
+	}
+	fmt.Fprint(os.Stderr, adminUsage)
+	return 2
+}
+
+// runReindexDocker rebuilds the manifest index of a Docker repository
+func runReindexDocker(args []string) int {
+	flags := flag.NewFlagSet("reindex-docker", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+	var images stringsFlag
+	flags.Var(&images, "image", "image to reindex (repeatable); default every image no other repository serves")
+	server := flags.String("server", "", "URL of the server")
+	insecure := flags.Bool("insecure", false, "skip verification of the server's certificate")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	query := url.Values{"image": images}
+	target := fmt.Sprintf("%s/api/v1/repositories/%s/reindex?%s", adminServer(*server), url.PathEscape(flags.Arg(0)), query.Encode())
+	resp, err := adminClient(*insecure).Post(target, "application/json", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reach the server: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "server responded %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var report struct {
+		Problems []string `json:"problems"`
+	}
+	json.Unmarshal(body, &report)
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	os.Stdout.Write(body)
+	if len(report.Problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(report.Problems))
+		return 1
+	}
+	return 0
+}
+
+// adminServer returns the URL of the server to call
+func adminServer(server string) string {
+	if server == "" {
+		server = getEnv("DEPOT_EXTERNAL_URL", "https://localhost:"+getEnv("DEPOT_PORT", "8443"))
+	}
+	return strings.TrimSuffix(server, "/")
+}
+
+// adminClient trusts the certificates the system trusts and the server's
+// own certificate, which is often self-signed
+func adminClient(insecure bool) *http.Client {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if pem, err := os.ReadFile(getEnv("DEPOT_CERT_FILE", "/var/depot/certs/server.crt")); err == nil {
+		roots.AppendCertsFromPEM(pem)
+	}
+	return &http.Client{
+		Timeout: 5 * time.Minute,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, InsecureSkipVerify: insecure, MinVersion: tls.VersionTLS12},
+		},
+	}
+}
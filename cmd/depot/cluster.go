@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/cluster"
+	"github.com/depot/depot/internal/handoff"
+	"github.com/depot/depot/internal/server"
+)
+
+// clusterNode returns this instance's cluster node if DEPOT_CLUSTER_NODE_ID
+// is set, or nil when running alone
+func clusterNode(config *server.Config, logger *logrus.Logger) (*cluster.Node, error) {
+	id := getEnv("DEPOT_CLUSTER_NODE_ID", "")
+	if id == "" {
+		return nil, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	address := getEnv("DEPOT_CLUSTER_ADDRESS", fmt.Sprintf("https://%s:%s", hostname, config.Port))
+	dir := getEnv("DEPOT_CLUSTER_DIR", filepath.Join(config.DataDir, "cluster"))
+
+	ttl, err := time.ParseDuration(getEnv("DEPOT_CLUSTER_LEASE", "15s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEPOT_CLUSTER_LEASE: %w", err)
+	}
+	return cluster.NewNode(dir, id, address, ttl, logger)
+}
+
+// standby serves the main port by forwarding requests to the cluster
+// leader, until this node becomes the leader or ctx is cancelled
+func standby(ctx context.Context, node *cluster.Node, config *server.Config, listeners *handoff.Handoff, logger *logrus.Logger) error {
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificates: %w", err)
+	}
+
+	// Trust the leader if it presents a certificate the system trusts or
+	// the same certificate as this node
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if pem, err := os.ReadFile(config.CertFile); err == nil {
+		roots.AppendCertsFromPEM(pem)
+	}
+
+	listener, err := listeners.Listen("tcp", fmt.Sprintf("%s:%s", config.Host, config.Port))
+	if err != nil {
+		return fmt.Errorf("failed to create listener: %w", err)
+	}
+
+	proxy := &http.Server{
+		Handler:     node.Proxy(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}),
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+		ReadTimeout: 15 * time.Second,
+		IdleTimeout: 60 * time.Second,
+	}
+	go func() {
+		if err := proxy.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			logger.WithError(err).Error("Cluster standby proxy failed")
+		}
+	}()
+
+	logger.WithField("node", node.ID()).Info("Waiting for cluster leadership, forwarding requests to the leader")
+	err = node.WaitForLeadership(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if shutdownErr := proxy.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.WithError(shutdownErr).Error("Failed to stop cluster standby proxy")
+	}
+	return err
+}
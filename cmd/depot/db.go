@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/dbmaint"
+	"github.com/depot/depot/internal/migrate"
+)
+
+const dbUsage = `usage: depot db <command>
+
+Commands operate on DEPOT_DB_PATH:
+  stats     report size, free pages, fragmentation and per-bucket usage
+  check     verify the integrity of every page
+  compact   rewrite the database without free pages
+
+The server must be stopped; while it runs, use /api/v1/admin/database.
+`
+
+// runDB runs a database maintenance command and returns the exit code
+func runDB(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return 2
+	}
+	path := getEnv("DEPOT_DB_PATH", "/var/depot/data/depot.db")
+
+	if args[0] == "compact" {
+		result, err := dbmaint.Compact(path, time.Second)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Compacted %s from %d to %d bytes in %s\n", path, result.SizeBefore, result.SizeAfter, result.Duration.Round(time.Millisecond))
+		return 0
+	}
+
+	// A running server holds the database exclusively; use the admin API then
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database (is the server running?): %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "stats":
+		report, err := dbmaint.Inspect(db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+		return 0
+	case "check":
+		problems, err := dbmaint.Check(db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "%d problems found\n", len(problems))
+			return 1
+		}
+		fmt.Println("OK")
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, dbUsage)
+	return 2
+}
+
+// runCheckMigrations reports the schema version of DEPOT_DB_PATH and the
+// migrations the server would apply on startup. It exits 0 if none are
+// pending, 1 if some are or the database is newer than this server, so
+// upgrades can be checked before they are rolled out.
+func runCheckMigrations() int {
+	path := getEnv("DEPOT_DB_PATH", "/var/depot/data/depot.db")
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database (is the server running?): %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	status, err := migrate.New(logrus.New()).Status(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Schema version %d, this server migrates to %d\n", status.Current, status.Latest)
+	if status.UpToDate() {
+		fmt.Println("No migrations pending")
+		return 0
+	}
+	for _, migration := range status.Pending {
+		fmt.Printf("Pending: %d %s\n", migration.Version, migration.Description)
+	}
+	return 1
+}
@@ -0,0 +1,9 @@
+package main
+
+// Extensions compiled into the server register themselves when imported,
+// and are enabled by name with DEPOT_EXTENSIONS. Add a fork's own
+// extension packages here.
+import (
+	_ "github.com/depot/depot/internal/extensions/bearer"
+	_ "github.com/depot/depot/internal/extensions/denypaths"
+)
@@ -2,17 +2,50 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/depot/depot/internal/cluster"
+	"github.com/depot/depot/internal/dbmaint"
+	"github.com/depot/depot/internal/handoff"
+	"github.com/depot/depot/internal/logging"
 	"github.com/depot/depot/internal/server"
 	"github.com/sirupsen/logrus"
 )
 
+// upgradeTimeout is how long a process started by an upgrade waits for its
+// predecessor to finish in-flight requests and release the database
+const upgradeTimeout = 45 * time.Second
+
 func main() {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "db":
+			os.Exit(runDB(os.Args[2:]))
+		case "reindex":
+			os.Exit(runReindex(os.Args[2:]))
+		case "admin":
+			os.Exit(runAdmin(os.Args[2:]))
+		case "--check-migrations":
+			os.Exit(runCheckMigrations())
+		}
+	}
+
+	logOptions, err := loggingOptions()
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid logging configuration")
+	}
+	logger, logCloser, err := logging.New(logOptions)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to set up logging")
+	}
+	defer logCloser.Close()
 
 	config := &server.Config{
 		Host:         getEnv("DEPOT_HOST", "0.0.0.0"),
@@ -21,11 +54,166 @@ func main() {
 		CertFile:     getEnv("DEPOT_CERT_FILE", "/var/depot/certs/server.crt"),
 		KeyFile:      getEnv("DEPOT_KEY_FILE", "/var/depot/certs/server.key"),
 		DatabasePath: getEnv("DEPOT_DB_PATH", "/var/depot/data/depot.db"),
+
+		CleanupSchedule:     getEnv("DEPOT_CLEANUP_SCHEDULE", "@hourly"),
+		GCSchedule:          getEnv("DEPOT_GC_SCHEDULE", "@daily"),
+		ScrubSchedule:       getEnv("DEPOT_SCRUB_SCHEDULE", "@weekly"),
+		BackupSchedule:      getEnv("DEPOT_BACKUP_SCHEDULE", "off"),
+		BackupDir:           getEnv("DEPOT_BACKUP_DIR", ""),
+		ClamdAddress:        getEnv("DEPOT_CLAMD_ADDRESS", ""),
+		ScanCommand:         getEnv("DEPOT_SCAN_COMMAND", ""),
+		ValidationHooksFile: getEnv("DEPOT_VALIDATION_HOOKS", ""),
+		URLSigningKey:       getEnv("DEPOT_URL_SIGNING_KEY", ""),
+		KeyEncryptionKey:    getEnv("DEPOT_KEY_ENCRYPTION_KEY", ""),
+		DebugAddress:        getEnv("DEPOT_DEBUG_ADDRESS", ""),
+		CertificatesDir:     getEnv("DEPOT_TLS_CERTS_DIR", ""),
+		ArchiveDir:          getEnv("DEPOT_ARCHIVE_DIR", ""),
+
+		RedirectAddress:  getEnv("DEPOT_HTTP_REDIRECT_ADDRESS", ""),
+		ACMEChallengeDir: getEnv("DEPOT_ACME_CHALLENGE_DIR", ""),
+		TrustedProxies:   getEnv("DEPOT_TRUSTED_PROXIES", ""),
+		ExternalURL:      getEnv("DEPOT_EXTERNAL_URL", ""),
+
+		SMTPAddress:  getEnv("DEPOT_SMTP_ADDRESS", ""),
+		SMTPFrom:     getEnv("DEPOT_SMTP_FROM", ""),
+		SMTPUsername: getEnv("DEPOT_SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("DEPOT_SMTP_PASSWORD", ""),
+
+		PolicyURL:      getEnv("DEPOT_OPA_URL", ""),
+		PolicyDecision: getEnv("DEPOT_OPA_DECISION", ""),
+		PolicyDir:      getEnv("DEPOT_OPA_POLICY_DIR", ""),
+
+		Extensions:        getEnv("DEPOT_EXTENSIONS", ""),
+		ExtensionSettings: extensionSettings(os.Environ()),
 	}
 
-	srv, err := server.New(config, logger)
+	dockerPathRouting, err := strconv.ParseBool(getEnv("DEPOT_DOCKER_PATH_ROUTING", "false"))
 	if err != nil {
-		logger.WithError(err).Fatal("Failed to create server")
+		logger.WithError(err).Fatal("Invalid DEPOT_DOCKER_PATH_ROUTING")
+	}
+	config.DockerPathRouting = dockerPathRouting
+
+	dockerPortMin, dockerPortMax, err := parsePortRange(getEnv("DEPOT_DOCKER_PORT_RANGE", "5000-5999"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_DOCKER_PORT_RANGE")
+	}
+	config.DockerPortMin, config.DockerPortMax = dockerPortMin, dockerPortMax
+
+	hstsMaxAge, err := time.ParseDuration(getEnv("DEPOT_HSTS_MAX_AGE", "0"))
+	if err != nil || hstsMaxAge < 0 {
+		logger.Fatalf("Invalid DEPOT_HSTS_MAX_AGE %q", os.Getenv("DEPOT_HSTS_MAX_AGE"))
+	}
+	config.HSTSMaxAge = hstsMaxAge
+
+	// DEPOT_CLEANUP_INTERVAL predates cleanup schedules
+	if interval := getEnvDuration(logger, "DEPOT_CLEANUP_INTERVAL", 0); interval > 0 && os.Getenv("DEPOT_CLEANUP_SCHEDULE") == "" {
+		config.CleanupSchedule = "@every " + interval.String()
+	}
+
+	for _, timeout := range []struct {
+		env   string
+		value *time.Duration
+	}{
+		{"DEPOT_READ_TIMEOUT", &config.Timeouts.Read},
+		{"DEPOT_WRITE_TIMEOUT", &config.Timeouts.Write},
+		{"DEPOT_IDLE_TIMEOUT", &config.Timeouts.Idle},
+		{"DEPOT_TRANSFER_TIMEOUT", &config.Timeouts.Transfer},
+	} {
+		if value := os.Getenv(timeout.env); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				logger.Fatalf("Invalid %s %q", timeout.env, value)
+			}
+			*timeout.value = d
+		}
+	}
+
+	http2, err := strconv.ParseBool(getEnv("DEPOT_HTTP2", "true"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_HTTP2")
+	}
+	config.Connections.DisableHTTP2 = !http2
+
+	if value := os.Getenv("DEPOT_HTTP2_MAX_STREAMS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			logger.Fatalf("Invalid DEPOT_HTTP2_MAX_STREAMS %q", value)
+		}
+		config.Connections.MaxConcurrentStreams = n
+	}
+
+	switch value := os.Getenv("DEPOT_TCP_KEEPALIVE"); value {
+	case "":
+	case "off":
+		config.Connections.KeepAlive = -1
+	default:
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			logger.Fatalf("Invalid DEPOT_TCP_KEEPALIVE %q", value)
+		}
+		config.Connections.KeepAlive = d
+	}
+
+	maxHeaderSize, err := parseSize(getEnv("DEPOT_MAX_HEADER_SIZE", "0"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_MAX_HEADER_SIZE")
+	}
+	config.Connections.MaxHeaderBytes = int(maxHeaderSize)
+
+	proxyProtocol, err := strconv.ParseBool(getEnv("DEPOT_PROXY_PROTOCOL", "false"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_PROXY_PROTOCOL")
+	}
+	config.ProxyProtocol = proxyProtocol
+
+	selfRepair, err := strconv.ParseBool(getEnv("DEPOT_SELF_REPAIR", "false"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_SELF_REPAIR")
+	}
+	config.SelfRepair = selfRepair
+
+	maxUploadSize, err := parseSize(getEnv("DEPOT_MAX_UPLOAD_SIZE", "0"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_MAX_UPLOAD_SIZE")
+	}
+	config.MaxUploadSize = maxUploadSize
+
+	minFreeSpace, err := parseSize(getEnv("DEPOT_MIN_FREE_SPACE", "0"))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid DEPOT_MIN_FREE_SPACE")
+	}
+	config.MinFreeSpace = minFreeSpace
+
+	for _, limit := range []struct {
+		env   string
+		value *int
+	}{
+		{"DEPOT_MAX_CONCURRENT_UPLOADS", &config.UploadConcurrency},
+		{"DEPOT_MAX_CONCURRENT_PROXY_FETCHES", &config.ProxyFetchConcurrency},
+		{"DEPOT_MAX_CONCURRENT_TASKS", &config.TaskConcurrency},
+	} {
+		n, err := strconv.Atoi(getEnv(limit.env, "0"))
+		if err != nil || n < 0 {
+			logger.Fatalf("Invalid %s", limit.env)
+		}
+		*limit.value = n
+	}
+	if value := os.Getenv("DEPOT_QUEUE_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			logger.Fatalf("Invalid DEPOT_QUEUE_TIMEOUT %q", value)
+		}
+		config.QueueTimeout = d
+	}
+
+	listeners, err := handoff.New()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to take over listeners")
+	}
+	if listeners.Inherited() {
+		// Wait for the previous process to drain and release the database
+		config.DatabaseTimeout = upgradeTimeout
+		logger.Info("Took over listeners from previous process")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -33,13 +221,103 @@ func main() {
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	upgradeChan := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgradeChan, upgradeSignals...)
+	}
 
 	go func() {
-		<-sigChan
-		logger.Info("Received shutdown signal")
-		cancel()
+		for {
+			select {
+			case <-sigChan:
+				logger.Info("Received shutdown signal")
+				cancel()
+				return
+			case <-upgradeChan:
+				process, err := listeners.Upgrade()
+				if err != nil {
+					logger.WithError(err).Error("Failed to start upgraded process")
+					continue
+				}
+				logger.WithField("pid", process.Pid).Info("Handed listeners to upgraded process, shutting down")
+				cancel()
+				return
+			}
+		}
 	}()
 
+	node, err := clusterNode(config, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid cluster configuration")
+	}
+	if node != nil {
+		// Only the leader opens the database; until then forward requests
+		if err := standby(ctx, node, config, listeners, logger); err != nil {
+			if ctx.Err() != nil {
+				logger.Info("Server shutdown complete")
+				return
+			}
+			logger.WithError(err).Fatal("Cluster standby failed")
+		}
+		config.DatabaseTimeout = node.LeaseDuration()
+	}
+
+	if compact, _ := strconv.ParseBool(getEnv("DEPOT_DB_COMPACT_ON_START", "false")); compact {
+		if _, err := os.Stat(config.DatabasePath); err == nil {
+			result, err := dbmaint.Compact(config.DatabasePath, config.DatabaseTimeout+time.Second)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to compact database")
+			}
+			logger.WithFields(logrus.Fields{
+				"size_before": result.SizeBefore,
+				"size_after":  result.SizeAfter,
+				"duration":    result.Duration,
+			}).Info("Compacted database")
+		}
+	}
+
+	srv, err := server.New(config, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create server")
+	}
+	srv.SetHandoff(listeners)
+
+	if node != nil {
+		srv.SetCluster(node)
+		defer node.Release()
+		go func() {
+			if err := node.Hold(ctx); errors.Is(err, cluster.ErrLeaseLost) {
+				logger.WithError(err).Error("Lost cluster leadership, stopping at once")
+				srv.Abort()
+				cancel()
+			}
+		}()
+	}
+
+	if output := getEnv("DEPOT_ACCESS_LOG", ""); output != "" {
+		accessOptions := logOptions
+		accessOptions.Output = output
+		accessOptions.Level = "info"
+		accessLogger, accessCloser, err := logging.New(accessOptions)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to set up access logging")
+		}
+		defer accessCloser.Close()
+		srv.SetAccessLogger(accessLogger)
+	}
+
+	if output := getEnv("DEPOT_AUDIT_LOG", ""); output != "" {
+		auditOptions := logOptions
+		auditOptions.Output = output
+		auditOptions.Level = "info"
+		auditLogger, auditCloser, err := logging.New(auditOptions)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to set up audit logging")
+		}
+		defer auditCloser.Close()
+		srv.SetAuditLogger(auditLogger)
+	}
+
 	if err := srv.Start(ctx); err != nil {
 		logger.WithError(err).Fatal("Server failed")
 	}
@@ -47,9 +325,111 @@ func main() {
 	logger.Info("Server shutdown complete")
 }
 
+// loggingOptions reads the application log destination and the rotation
+// settings shared with the access log
+func loggingOptions() (logging.Options, error) {
+	opts := logging.Options{
+		Output: getEnv("DEPOT_LOG_OUTPUT", "stdout"),
+		Format: getEnv("DEPOT_LOG_FORMAT", "json"),
+		Level:  getEnv("DEPOT_LOG_LEVEL", "info"),
+	}
+
+	maxSize, err := parseSize(getEnv("DEPOT_LOG_MAX_SIZE", "0"))
+	if err != nil {
+		return opts, fmt.Errorf("invalid DEPOT_LOG_MAX_SIZE: %w", err)
+	}
+	opts.MaxSize = maxSize
+
+	if opts.MaxAge, err = time.ParseDuration(getEnv("DEPOT_LOG_MAX_AGE", "0")); err != nil || opts.MaxAge < 0 {
+		return opts, fmt.Errorf("invalid DEPOT_LOG_MAX_AGE %q", os.Getenv("DEPOT_LOG_MAX_AGE"))
+	}
+
+	if opts.MaxBackups, err = strconv.Atoi(getEnv("DEPOT_LOG_MAX_BACKUPS", "0")); err != nil || opts.MaxBackups < 0 {
+		return opts, fmt.Errorf("invalid DEPOT_LOG_MAX_BACKUPS %q", os.Getenv("DEPOT_LOG_MAX_BACKUPS"))
+	}
+	return opts, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvDuration(logger *logrus.Logger, key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.WithError(err).Fatalf("Invalid duration for %s", key)
+	}
+	return duration
+}
+
+// extensionSettings collects the settings of extensions from environment
+// variables named DEPOT_EXT_<NAME>_<KEY>, keyed by lowercase extension
+// name and setting
+func extensionSettings(environ []string) map[string]map[string]string {
+	settings := make(map[string]map[string]string)
+	for _, entry := range environ {
+		variable, value, _ := strings.Cut(entry, "=")
+		rest, found := strings.CutPrefix(variable, "DEPOT_EXT_")
+		if !found {
+			continue
+		}
+		name, key, found := strings.Cut(strings.ToLower(rest), "_")
+		if !found || name == "" || key == "" {
+			continue
+		}
+		if settings[name] == nil {
+			settings[name] = make(map[string]string)
+		}
+		settings[name][key] = value
+	}
+	return settings
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024), e.g. "512M" or "10G"
+func parseSize(input string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(input))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			value = value[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", input)
+	}
+	return n * multiplier, nil
+}
+
+// parsePortRange parses an inclusive port range such as "5000-5999"
+func parsePortRange(input string) (int, int, error) {
+	first, last, ok := strings.Cut(strings.TrimSpace(input), "-")
+	min, err1 := strconv.Atoi(strings.TrimSpace(first))
+	max, err2 := strconv.Atoi(strings.TrimSpace(last))
+	if !ok || err1 != nil || err2 != nil || min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", input)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runImportOCI uploads an OCI image layout, a directory or a tar archive,
+// into an image of a Docker repository
+func runImportOCI(args []string) int {
+	flags := flag.NewFlagSet("import-oci", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+	tag := flags.String("tag", "", "tag of the manifest when index.json names none")
+	server := flags.String("server", "", "URL of the server")
+	insecure := flags.Bool("insecure", false, "skip verification of the server's certificate")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 3 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	layout := flags.Arg(2)
+	info, err := os.Stat(layout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var body io.Reader
+	if info.IsDir() {
+		reader, writer := io.Pipe()
+		go func() { writer.CloseWithError(tarDirectory(layout, writer)) }()
+		body = reader
+	} else {
+		file, err := os.Open(layout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		body = file
+	}
+
+	query := url.Values{}
+	if *tag != "" {
+		query.Set("tag", *tag)
+	}
+	target := fmt.Sprintf("%s/api/v1/repositories/%s/images/%s/oci-layout?%s", adminServer(*server), url.PathEscape(flags.Arg(0)), flags.Arg(1), query.Encode())
+	resp, err := transferClient(*insecure).Post(target, "application/x-tar", body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reach the server: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if resp.StatusCode != http.StatusCreated {
+		fmt.Fprintf(os.Stderr, "server responded %s: %s\n", resp.Status, strings.TrimSpace(string(response)))
+		return 1
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, response, "", "  ") == nil {
+		response = indented.Bytes()
+	}
+	os.Stdout.Write(response)
+	return 0
+}
+
+// runExportOCI downloads an image of a Docker repository as an OCI image
+// layout tar archive
+func runExportOCI(args []string) int {
+	flags := flag.NewFlagSet("export-oci", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+	output := flags.String("o", "", "file to write the archive to instead of standard output")
+	server := flags.String("server", "", "URL of the server")
+	insecure := flags.Bool("insecure", false, "skip verification of the server's certificate")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 3 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	target := fmt.Sprintf("%s/api/v1/repositories/%s/images/%s/oci-layout/%s", adminServer(*server), url.PathEscape(flags.Arg(0)), flags.Arg(1), url.PathEscape(flags.Arg(2)))
+	resp, err := transferClient(*insecure).Get(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reach the server: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "server responded %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to download layout: %v\n", err)
+		if *output != "" {
+			os.Remove(*output)
+		}
+		return 1
+	}
+	return 0
+}
+
+// transferClient is an admin client without an overall timeout, for
+// archives that take longer than it to move
+func transferClient(insecure bool) *http.Client {
+	client := adminClient(insecure)
+	client.Timeout = 0
+	return client
+}
+
+// tarDirectory writes the regular files under a directory as a tar archive
+// with paths relative to it
+func tarDirectory(dir string, w io.Writer) error {
+	archive := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/reindex"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+)
+
+const reindexUsage = `usage: depot reindex [-dry-run] [-verify-blobs] [-docker-repo name]
+
+Rebuilds repository and artifact metadata in DEPOT_DB_PATH from the
+storage tree under DEPOT_DATA_DIR, creating the database if it is missing.
+Existing records are kept. The server must be stopped.
+`
+
+// runReindex rebuilds the database from storage and returns the exit code
+func runReindex(args []string) int {
+	flags := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, reindexUsage) }
+	var opts reindex.Options
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "report what would be recreated without writing")
+	flags.BoolVar(&opts.VerifyBlobs, "verify-blobs", false, "hash every Docker blob")
+	flags.StringVar(&opts.DockerRepository, "docker-repo", reindex.DefaultDockerRepository, "repository to create for Docker images")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	dataDir := getEnv("DEPOT_DATA_DIR", "/var/depot/data")
+	path := getEnv("DEPOT_DB_PATH", "/var/depot/data/depot.db")
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database (is the server running?): %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+
+	root := filepath.Join(dataDir, "artifacts")
+	fileStorage := storage.NewFileStorage(root)
+	rebuilder := reindex.New(root, fileStorage, repository.NewManager(db, fileStorage, logger), metadata.NewStore(db), logger)
+
+	report, err := rebuilder.Rebuild(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+	if len(report.Problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(report.Problems))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runSyncImages copies the images a Docker repository syncs from other
+// registries, first replacing its sync configuration with -config if given
+func runSyncImages(args []string) int {
+	flags := flag.NewFlagSet("sync-images", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, adminUsage) }
+	configFile := flags.String("config", "", "JSON file with the images to sync, replacing the repository's configuration")
+	server := flags.String("server", "", "URL of the server")
+	insecure := flags.Bool("insecure", false, "skip verification of the server's certificate")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return 2
+	}
+
+	target := fmt.Sprintf("%s/api/v1/repositories/%s/image-sync", adminServer(*server), url.PathEscape(flags.Arg(0)))
+	client := transferClient(*insecure)
+	if *configFile != "" {
+		config, err := os.ReadFile(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(config))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if _, code := adminCall(client, req, http.StatusOK); code != 0 {
+			return code
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target+"/run", nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	body, code := adminCall(client, req, http.StatusOK)
+	if code != 0 {
+		return code
+	}
+
+	var report struct {
+		Problems []string `json:"problems"`
+	}
+	json.Unmarshal(body, &report)
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = indented.Bytes()
+	}
+	os.Stdout.Write(body)
+	if len(report.Problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(report.Problems))
+		return 1
+	}
+	return 0
+}
+
+// adminCall sends a request and returns the response body, or a non-zero
+// exit code if the server could not be reached or did not answer with
+// status
+func adminCall(client *http.Client, req *http.Request, status int) ([]byte, int) {
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to reach the server: %v\n", err)
+		return nil, 1
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 1
+	}
+	if resp.StatusCode != status {
+		fmt.Fprintf(os.Stderr, "server responded %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return nil, 1
+	}
+	return body, 0
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals ask a running server to hand its listeners to a freshly
+// started copy of its binary and exit
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
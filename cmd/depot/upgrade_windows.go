@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// upgradeSignals is empty: listeners cannot be handed over on Windows
+var upgradeSignals []os.Signal
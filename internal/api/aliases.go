@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+)
+
+type aliasRequest struct {
+	Target string `json:"target"`
+}
+
+// ListAliases returns the aliases defined in a raw repository
+func (h *Handler) ListAliases(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Aliases")
+	if !ok {
+		return
+	}
+
+	aliases, err := h.metadata.ListAliases(repo.Name)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list aliases")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(aliases)
+}
+
+// GetAlias returns a single alias
+func (h *Handler) GetAlias(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Aliases")
+	if !ok {
+		return
+	}
+
+	alias, err := h.metadata.GetAlias(repo.Name, mux.Vars(r)["path"])
+	if err != nil {
+		h.writeAliasError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alias)
+}
+
+// PutAlias creates or repoints an alias. The target must be an existing
+// artifact, and the alias may not shadow one.
+func (h *Handler) PutAlias(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Aliases")
+	if !ok {
+		return
+	}
+
+	aliasPath, ok := cleanArtifactPath(mux.Vars(r)["path"])
+	if !ok || aliasPath == "" {
+		h.writeError(w, http.StatusBadRequest, "Invalid alias path")
+		return
+	}
+
+	var req aliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	target, ok := cleanArtifactPath(req.Target)
+	if !ok || target == "" || target == aliasPath {
+		h.writeError(w, http.StatusBadRequest, "Invalid alias target")
+		return
+	}
+
+	if _, err := h.storage.Stat(repo.Name, aliasPath); err == nil {
+		h.writeError(w, http.StatusConflict, "An artifact already exists at the alias path")
+		return
+	}
+	if !h.checkWrite(w, repo, aliasPath, true) {
+		return
+	}
+	if _, err := h.storage.Stat(repo.Name, target); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Target artifact %s does not exist", target))
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to check alias target")
+		return
+	}
+
+	alias := &metadata.Alias{
+		Repository: repo.Name,
+		Path:       aliasPath,
+		Target:     target,
+	}
+	if err := h.metadata.PutAlias(alias); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to save alias")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alias)
+}
+
+// DeleteAlias removes an alias, leaving its target in place
+func (h *Handler) DeleteAlias(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Aliases")
+	if !ok {
+		return
+	}
+
+	if !h.checkDelete(w, repo, mux.Vars(r)["path"]) {
+		return
+	}
+
+	if err := h.metadata.DeleteAlias(repo.Name, mux.Vars(r)["path"]); err != nil {
+		h.writeAliasError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// statArtifact returns the file served for an artifact path, following an
+// alias if no file exists at the path itself. The returned FileInfo's Path
+// is that of the file actually served.
+func (h *Handler) statArtifact(repo, artifactPath string) (*storage.FileInfo, error) {
+	info, err := h.storage.Stat(repo, artifactPath)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return info, err
+	}
+
+	alias, aliasErr := h.metadata.GetAlias(repo, artifactPath)
+	if aliasErr != nil {
+		return nil, err
+	}
+	return h.storage.Stat(repo, alias.Target)
+}
+
+// setAliasHeaders points clients at the versioned artifact when a download
+// was served through an alias
+func (h *Handler) setAliasHeaders(w http.ResponseWriter, repo, artifactPath string, info *storage.FileInfo) {
+	if info.Path != artifactPath {
+		w.Header().Set("Content-Location", fmt.Sprintf("/repository/%s/%s", repo, info.Path))
+	}
+}
+
+func (h *Handler) writeAliasError(w http.ResponseWriter, err error) {
+	if errors.Is(err, metadata.ErrAliasNotFound) {
+		h.writeError(w, http.StatusNotFound, "Alias not found")
+		return
+	}
+	h.writeError(w, http.StatusInternalServerError, "Failed to access alias")
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/archival"
+	"github.com/depot/depot/internal/tasks"
+)
+
+// ArchiveRequest archives a repository
+type ArchiveRequest struct {
+	// Remove deletes the repository's content from hot storage once its
+	// bundle has been written and checked
+	Remove bool `json:"remove"`
+}
+
+// SetArchives sets the manager archiving repositories to cold storage
+func (h *Handler) SetArchives(archives *archival.Manager) {
+	h.archives = archives
+}
+
+// ListArchives lists the archived repositories
+func (h *Handler) ListArchives(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.archives.List())
+}
+
+// GetArchive reports whether a repository is archived, and where its
+// bundle is kept
+func (h *Handler) GetArchive(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	archive, err := h.archives.Get(name)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %s is not archived", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archive)
+}
+
+// GetArchiveManifest returns the manifest of an archived repository's
+// bundle, listing its artifacts with their checksums
+func (h *Handler) GetArchiveManifest(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	manifest, err := h.archives.Manifest(name)
+	if err != nil {
+		switch {
+		case errors.Is(err, archival.ErrNotArchived):
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %s is not archived", name))
+		case errors.Is(err, archival.ErrBusy):
+			h.writeError(w, http.StatusConflict, "Archive is still being exported")
+		default:
+			h.requestLogger(r).WithError(err).Errorf("Failed to read archive manifest of %s", name)
+			h.writeError(w, http.StatusInternalServerError, "Failed to read archive manifest")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// ArchiveRepository makes a raw repository read-only and starts a task
+// exporting its artifacts to a bundle in cold storage, optionally removing
+// them from hot storage afterwards
+func (h *Handler) ArchiveRepository(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Archives")
+	if !ok {
+		return
+	}
+
+	var req ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if _, err := h.archives.Freeze(repo.Name); err != nil {
+		if errors.Is(err, archival.ErrArchived) {
+			h.writeError(w, http.StatusConflict, fmt.Sprintf("Repository %s is already archived", repo.Name))
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to archive repository")
+		return
+	}
+
+	task, err := h.taskManager.Submit("archive", repo.Name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+		return h.archives.Export(repo, req.Remove, run.SetProgress)
+	})
+	if err != nil {
+		h.archives.Thaw(repo.Name)
+		h.writeError(w, http.StatusInternalServerError, "Failed to submit archive task")
+		return
+	}
+	h.requestLogger(r).WithField("repository", repo.Name).Info("Repository archived, exporting it to cold storage")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+// RestoreArchive starts a task copying an archived repository's artifacts
+// back from cold storage, if they were removed, and making it writable
+func (h *Handler) RestoreArchive(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	archive, err := h.archives.Get(name)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %s is not archived", name))
+		return
+	}
+	if archive.State == archival.StateExporting || archive.State == archival.StateRestoring {
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Repository %s is still %s", name, archive.State))
+		return
+	}
+
+	task, err := h.taskManager.Submit("restore", name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+		return h.archives.Restore(name, run.SetProgress)
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to submit restore task")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+// repositoryArchived refuses changing the artifacts of an archived
+// repository
+func (h *Handler) repositoryArchived(w http.ResponseWriter, repo string) bool {
+	if h.archives == nil || !h.archives.Frozen(repo) {
+		return false
+	}
+	h.writeError(w, http.StatusConflict, fmt.Sprintf("Repository %s is archived and read-only", repo))
+	return true
+}
@@ -0,0 +1,398 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/depot/depot/internal/disconnect"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Archive formats accepted for extraction and offered for download
+const (
+	formatZip   = "zip"
+	formatTarGz = "tar.gz"
+	formatTar   = "tar"
+)
+
+type extractResponse struct {
+	Repository string   `json:"repository"`
+	Path       string   `json:"path"`
+	Artifacts  []string `json:"artifacts"`
+}
+
+// serveArchive streams every artifact below prefix as a zip or tar.gz
+// archive, with paths relative to the prefix
+func (h *Handler) serveArchive(w http.ResponseWriter, r *http.Request, repoName, prefix, format string) {
+	if format != formatZip && format != formatTarGz {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported archive format %q, expected zip or tar.gz", format))
+		return
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	files, err := h.storage.List(repoName, prefix)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list artifacts")
+		return
+	}
+	if len(files) == 0 {
+		h.writeError(w, http.StatusNotFound, "No artifacts found below "+prefix)
+		return
+	}
+
+	name := repoName
+	if prefix != "" {
+		name = path.Base(prefix)
+	}
+	if format == formatZip {
+		w.Header().Set("Content-Type", "application/zip")
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the archive short
+	if err := h.writeArchive(w, repoName, prefix, files, format); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to write archive")
+	}
+}
+
+func (h *Handler) writeArchive(w io.Writer, repoName, prefix string, files []storage.FileInfo, format string) error {
+	var zw *zip.Writer
+	var tw *tar.Writer
+	if format == formatZip {
+		zw = zip.NewWriter(w)
+	} else {
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		tw = tar.NewWriter(gw)
+	}
+
+	for _, file := range files {
+		name := strings.TrimPrefix(strings.TrimPrefix(file.Path, prefix), "/")
+
+		var entry io.Writer
+		var err error
+		if zw != nil {
+			entry, err = zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: file.ModTime})
+		} else {
+			err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: file.Size, ModTime: file.ModTime, Typeflag: tar.TypeReg})
+			entry = tw
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := h.copyArtifactTo(entry, repoName, file.Path); err != nil {
+			return err
+		}
+	}
+
+	if zw != nil {
+		return zw.Close()
+	}
+	return tw.Close()
+}
+
+func (h *Handler) copyArtifactTo(w io.Writer, repoName, artifactPath string) error {
+	reader, err := h.storage.Retrieve(repoName, artifactPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+// extractArchive publishes every file in an uploaded zip, tar.gz or tar
+// archive below prefix. All entries are checked against the repository's
+// upload rules before anything is written.
+func (h *Handler) extractArchive(w http.ResponseWriter, r *http.Request, repo *models.Repository, prefix string) {
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	limit, err := h.uploadLimit(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	properties, err := uploadProperties(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	class, err := uploadStorageClass(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	allowOverwrite, err := overwriteAllowed(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Zip archives need random access, so the upload is spooled to disk
+	spool, err := os.CreateTemp("", "depot-extract-*")
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to stage archive")
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	watched := disconnect.Watch(r)
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeSizeError(w, limit)
+			return
+		}
+		if h.uploadAborted(w, r, watched, repo.Name, abortedRaw) {
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, "Failed to read archive")
+		return
+	}
+
+	format, err := detectArchiveFormat(spool)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	var targets []string
+	err = walkArchive(spool, size, format, func(name string, _ io.Reader) error {
+		entryPath, ok := cleanArtifactPath(name)
+		if !ok || entryPath == "" {
+			return fmt.Errorf("archive entry %q has an invalid path", name)
+		}
+		targets = append(targets, path.Join(prefix, entryPath))
+		return nil
+	})
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(targets) == 0 {
+		h.writeError(w, http.StatusBadRequest, "Archive contains no files")
+		return
+	}
+
+	for _, target := range targets {
+		if !config.AllowsPath(target) {
+			h.writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("File extension of %s not allowed", target))
+			return
+		}
+		// Entries carry no content type, so it is told by the extension
+		if !config.AllowsContentType(mime.TypeByExtension(path.Ext(target))) {
+			h.writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Content type of %s not allowed", target))
+			return
+		}
+		if _, err := h.metadata.GetAlias(repo.Name, target); err == nil {
+			h.writeError(w, http.StatusConflict, fmt.Sprintf("Path %s is an alias", target))
+			return
+		}
+		if !h.checkOverwrite(w, repo, target) {
+			return
+		}
+		if _, err := uploadExpiry(r, config, target, class); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	stored := []string{}
+	var created []string
+	i := 0
+	err = walkArchive(spool, size, format, func(_ string, data io.Reader) error {
+		target := targets[i]
+		i++
+
+		if limit > 0 {
+			data = &entryLimitReader{r: data, remaining: limit}
+		}
+
+		existing, err := h.storage.Stat(repo.Name, target)
+		if err == nil && !allowOverwrite {
+			if _, err := h.compareExisting(repo.Name, existing, data, -1, nil); err != nil {
+				return &entryError{path: target, err: err}
+			}
+			return nil
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			created = append(created, target)
+		}
+
+		if _, err := h.storeUpload(repo.Name, target, data, nil); err != nil {
+			return &entryError{path: target, err: err}
+		}
+		stored = append(stored, target)
+
+		if err := h.metadata.SetProperties(repo.Name, target, properties); err != nil {
+			h.requestLogger(r).WithError(err).Warnf("Failed to record properties for %s/%s", repo.Name, target)
+		}
+		expiresAt, _ := uploadExpiry(r, config, target, class)
+		h.setExpiry(h.requestLogger(r), repo.Name, target, expiresAt)
+		h.setStorageClass(h.requestLogger(r), repo.Name, target, class)
+		return nil
+	})
+	if err != nil {
+		// Remove what was published so a failed extraction is not half
+		// done. Artifacts it overwrote cannot be put back, and are kept.
+		for _, p := range created {
+			h.deleteArtifact(repo.Name, p)
+		}
+		if h.writeValidationError(w, r, err) || h.writeScanError(w, r, err) {
+			return
+		}
+		var entryErr *entryError
+		errors.As(err, &entryErr)
+		switch {
+		case errors.Is(err, errEntryTooLarge):
+			h.writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Archive entry %s exceeds maximum upload size of %d bytes", entryErr.path, limit))
+		case errors.Is(err, errArtifactExists):
+			h.writeError(w, http.StatusConflict, "An artifact with different content already exists at "+entryErr.path)
+		default:
+			h.requestLogger(r).WithError(err).Error("Failed to extract archive")
+			h.writeError(w, http.StatusInternalServerError, "Failed to extract archive")
+		}
+		return
+	}
+
+	if len(stored) > 0 {
+		h.notifyPushed(repo.Name, stored...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(extractResponse{
+		Repository: repo.Name,
+		Path:       prefix,
+		Artifacts:  stored,
+	})
+}
+
+var errEntryTooLarge = errors.New("archive entry too large")
+
+// entryError attributes an extraction failure to an archive entry
+type entryError struct {
+	path string
+	err  error
+}
+
+func (e *entryError) Error() string {
+	return e.path + ": " + e.err.Error()
+}
+
+func (e *entryError) Unwrap() error {
+	return e.err
+}
+
+// entryLimitReader fails with errEntryTooLarge once more than the allowed
+// number of bytes has been read, so an oversized entry is never stored
+type entryLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *entryLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errEntryTooLarge
+	}
+	return n, err
+}
+
+// detectArchiveFormat identifies a zip, gzip-compressed tar or plain tar
+// archive by its leading bytes
+func detectArchiveFormat(file *os.File) (string, error) {
+	header := make([]byte, 262)
+	n, _ := file.ReadAt(header, 0)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+		return formatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatTarGz, nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return formatTar, nil
+	}
+	return "", errors.New("unsupported archive format, expected zip, tar.gz or tar")
+}
+
+// walkArchive calls fn for every regular file in the archive, in order
+func walkArchive(file *os.File, size int64, format string, fn func(name string, data io.Reader) error) error {
+	if format == formatZip {
+		zr, err := zip.NewReader(file, size)
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+		for _, entry := range zr.File {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+			data, err := entry.Open()
+			if err != nil {
+				return fmt.Errorf("invalid zip entry %s: %w", entry.Name, err)
+			}
+			err = fn(entry.Name, data)
+			data.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var reader io.Reader = io.NewSectionReader(file, 0, size)
+	if format == formatTarGz {
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("invalid gzip archive: %w", err)
+		}
+		defer gr.Close()
+		reader = gr
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
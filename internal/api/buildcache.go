@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/depot/depot/internal/buildcache"
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/disconnect"
+	"github.com/depot/depot/pkg/models"
+)
+
+// SetBuildCache sets the store of build cache repositories
+func (h *Handler) SetBuildCache(cache *buildcache.Cache) {
+	h.buildCache = cache
+}
+
+func buildCacheConfig(repo *models.Repository) (*models.BuildCacheConfig, error) {
+	var config models.BuildCacheConfig
+	if repo.Config != nil {
+		if err := json.Unmarshal(repo.Config, &config); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+func validateBuildCacheConfig(data json.RawMessage) error {
+	var config models.BuildCacheConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	if config.MaxEntrySize < 0 {
+		return fmt.Errorf("max_entry_size cannot be negative")
+	}
+	if config.MaxCacheSize < 0 {
+		return fmt.Errorf("max_cache_size cannot be negative")
+	}
+	if config.Protocol != "" && config.Protocol != models.BuildCacheObjects {
+		return fmt.Errorf("unknown protocol %q", config.Protocol)
+	}
+	if config.TTL != "" {
+		if _, err := models.ParseTTL(config.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildCacheProtocolChanged reports whether an update changes the protocol
+// of a build cache repository, which would strand its entries
+func buildCacheProtocolChanged(current, updated json.RawMessage) bool {
+	var before, after models.BuildCacheConfig
+	json.Unmarshal(current, &before)
+	json.Unmarshal(updated, &after)
+	return before.Protocol != after.Protocol
+}
+
+// handleBuildCacheRepository serves the Bazel remote cache and Gradle HTTP
+// build cache protocols: entries are read with GET, checked for with HEAD
+// and written with PUT. Content written to Bazel's CAS must have the
+// digest it is addressed by. Object caches also delete entries with DELETE
+// and accept MKCOL, which WebDAV clients such as sccache send before
+// writing, without effect.
+func (h *Handler) handleBuildCacheRepository(w http.ResponseWriter, r *http.Request, repo *models.Repository) {
+	config, err := buildCacheConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid build cache repository configuration")
+		return
+	}
+
+	pathParts := strings.SplitN(r.URL.Path, "/", 4)
+	if len(pathParts) < 4 {
+		h.writeError(w, http.StatusBadRequest, "Invalid cache entry path")
+		return
+	}
+	objects := config.Protocol == models.BuildCacheObjects
+	if objects && r.Method == "MKCOL" {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	var key, digest string
+	var ok bool
+	if objects {
+		key, ok = buildcache.ResolveObject(pathParts[3])
+	} else {
+		key, digest, ok = buildcache.Resolve(pathParts[3])
+	}
+	if !ok {
+		if objects {
+			h.writeError(w, http.StatusBadRequest, "Invalid cache entry path")
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, "Invalid cache entry path: expected [<instance>/]ac/<sha256>, [<instance>/]cas/<sha256> or a Gradle cache key")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet:
+		reader, info, err := h.buildCache.Get(repo.Name, key)
+		if errors.Is(err, buildcache.ErrNotFound) {
+			h.recordCacheLookup(repo.Name, false)
+			h.writeError(w, http.StatusNotFound, "Cache entry not found")
+			return
+		}
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to read cache entry")
+			return
+		}
+		defer reader.Close()
+		h.recordCacheLookup(repo.Name, true)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		io.Copy(w, reader)
+	case r.Method == http.MethodHead:
+		info, err := h.buildCache.Stat(repo.Name, key)
+		if errors.Is(err, buildcache.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		h.putCacheEntry(w, r, repo, config, key, digest)
+	case r.Method == http.MethodDelete && objects:
+		if err := h.buildCache.Delete(repo.Name, key); err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to delete cache entry")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// putCacheEntry stores an entry of a build cache repository, answering 201
+// for new entries and 200 for replaced ones. Entries over the size limit
+// are refused with 413, which build tools treat as not cacheable.
+func (h *Handler) putCacheEntry(w http.ResponseWriter, r *http.Request, repo *models.Repository, config *models.BuildCacheConfig, key, digest string) {
+	limit := models.SizeLimit(h.maxUploadSize, config.MaxEntrySize)
+	if limit > 0 && r.ContentLength > limit {
+		h.writeSizeError(w, limit)
+		return
+	}
+	watched := disconnect.Watch(r)
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	_, statErr := h.buildCache.Stat(repo.Name, key)
+	if err := h.buildCache.Put(repo.Name, key, body, digest); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			h.writeSizeError(w, limit)
+		case h.uploadAborted(w, r, watched, repo.Name, abortedRaw):
+		case errors.Is(err, checksum.ErrMismatch):
+			h.writeError(w, http.StatusBadRequest, "Content does not match the digest it is addressed by")
+		default:
+			h.requestLogger(r).WithError(err).Errorf("Failed to store cache entry %s in %s", key, repo.Name)
+			h.writeError(w, http.StatusInternalServerError, "Failed to store cache entry")
+		}
+		return
+	}
+
+	if statErr == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// evictBuildCache evicts the expired entries of a build cache repository,
+// then the least recently used ones until it fits its maximum cache size
+func (h *Handler) evictBuildCache(w http.ResponseWriter, repo *models.Repository) {
+	config, err := buildCacheConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid build cache repository configuration")
+		return
+	}
+	report, err := h.buildCache.Evict(repo.Name, config.MaxCacheSize, config.EntryTTL())
+	if err != nil {
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to evict from repository: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// recordCacheLookup counts a read of a build cache repository for its hit
+// rate
+func (h *Handler) recordCacheLookup(repoName string, hit bool) {
+	if h.metrics != nil {
+		h.metrics.RecordCacheLookup(repoName, hit)
+	}
+}
+
+// BuildCacheStats reports the entries and size of a build cache
+// repository and its hit rate since startup
+func (h *Handler) BuildCacheStats(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.lookupRepository(w, r)
+	if !ok {
+		return
+	}
+	if repo.Type != models.RepositoryTypeBuildCache {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a build cache repository")
+		return
+	}
+
+	entries, err := h.buildCache.Entries(repo.Name)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list cache entries")
+		return
+	}
+	stats := struct {
+		Repository string  `json:"repository"`
+		Entries    int     `json:"entries"`
+		Size       int64   `json:"size"`
+		Hits       int64   `json:"hits"`
+		Misses     int64   `json:"misses"`
+		HitRate    float64 `json:"hit_rate"`
+	}{Repository: repo.Name, Entries: len(entries)}
+	for _, entry := range entries {
+		stats.Size += entry.Size
+	}
+	if h.metrics != nil {
+		lookups := h.metrics.CacheLookups(repo.Name)
+		stats.Hits, stats.Misses = lookups.Hits, lookups.Misses
+		if total := lookups.Hits + lookups.Misses; total > 0 {
+			stats.HitRate = float64(lookups.Hits) / float64(total)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/httpcache"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// byDigestPrefix is the first path segment of digest URLs, which name an
+// artifact together with its SHA-256:
+// /repository/{repo}/_by-digest/sha256:{hex}/{path}. The content of such a
+// URL never changes, so CDNs and browsers may cache it for good, and a
+// new upload to the path gets a new URL.
+const byDigestPrefix = "_by-digest"
+
+// immutableURLHeader carries the digest URL of a downloaded artifact
+const immutableURLHeader = "X-Immutable-Url"
+
+// digestPath returns the digest URL path of an artifact
+func digestPath(repo, artifactPath, sha256 string) string {
+	return fmt.Sprintf("/repository/%s/%s/sha256:%s/%s", repo, byDigestPrefix, sha256, artifactPath)
+}
+
+// setImmutableURLHeader tells a client the digest URL of a downloaded
+// artifact whose SHA-256 is known
+func (h *Handler) setImmutableURLHeader(w http.ResponseWriter, repo, artifactPath string, info *storage.FileInfo) {
+	artifact, err := h.metadata.Get(repo, info.Path)
+	if err != nil || !artifact.Matches(info.Size, info.ModTime) || artifact.SHA256 == "" {
+		return
+	}
+	w.Header().Set(immutableURLHeader, h.location(digestPath(repo, artifactPath, artifact.SHA256)))
+}
+
+// serveByDigest answers a GET or HEAD of a digest URL, given the path
+// segments after _by-digest. The artifact at the path is served if it has
+// the digest. Otherwise, as the URL names content rather than a path, any
+// artifact of the repository with that digest is served, so URLs handed out
+// before the path was overwritten keep working while the content is
+// stored elsewhere in the repository.
+func (h *Handler) serveByDigest(w http.ResponseWriter, r *http.Request, repo *models.Repository, config *models.RawRepositoryConfig, segments []string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.writeError(w, http.StatusMethodNotAllowed, "Digest URLs are read-only")
+		return
+	}
+	if len(segments) < 2 || segments[len(segments)-1] == "" {
+		h.writeError(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	digest := strings.ToLower(segments[0])
+	sha256, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || !checksum.ValidSHA256(sha256) {
+		h.writeError(w, http.StatusBadRequest, "Invalid digest, expected sha256:<hex>")
+		return
+	}
+	artifactPath := strings.Join(segments[1:], "/")
+
+	info, err := h.findByDigest(repo.Name, artifactPath, sha256)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to find artifact")
+		return
+	}
+	if info == nil {
+		h.writeError(w, http.StatusNotFound, "No artifact with digest "+digest)
+		return
+	}
+	if !h.checkArtifactProvenance(w, r, repo.Name, info, config.ProvenancePolicy) {
+		return
+	}
+
+	setResponseHeaders(w, config, artifactPath)
+	w.Header().Set("Cache-Control", models.ImmutableCacheControl)
+	if httpcache.NotModified(w, r, httpcache.ETag(digest), info.ModTime) {
+		return
+	}
+	h.setChecksumHeaders(w, repo.Name, info)
+
+	reader, err := h.storage.Retrieve(repo.Name, info.Path)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if httpcache.ServeContent(w, r, info.ModTime, reader, info.Size) {
+		h.recordDownload(r, repo.Name, info.Path)
+	}
+}
+
+// findByDigest returns the stored file of the artifact at a path, or
+// failing that of any artifact in the repository, whose recorded SHA-256
+// is sha256 and still describes the file. It returns nil if there is none.
+func (h *Handler) findByDigest(repo, artifactPath, sha256 string) (*storage.FileInfo, error) {
+	if info, err := h.statArtifact(repo, artifactPath); err == nil {
+		if artifact, err := h.metadata.Get(repo, info.Path); err == nil && artifact.SHA256 == sha256 && artifact.Matches(info.Size, info.ModTime) {
+			return info, nil
+		}
+	}
+
+	artifacts, err := h.metadata.FindByChecksum("sha256", sha256)
+	if err != nil {
+		return nil, err
+	}
+	for _, artifact := range artifacts {
+		if artifact.Repository != repo {
+			continue
+		}
+		info, err := h.storage.Stat(repo, artifact.Path)
+		if err == nil && artifact.Matches(info.Size, info.ModTime) {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// maxRepositoryNotes bounds the free-form notes of a repository
+const maxRepositoryNotes = 64 << 10
+
+// validateCatalog checks the metadata and notes that catalog a repository
+func validateCatalog(repo *models.Repository) error {
+	for name := range repo.Metadata {
+		if !propertyNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid metadata name %q", name)
+		}
+	}
+	if len(repo.Notes) > maxRepositoryNotes {
+		return fmt.Errorf("notes may be at most %d bytes", maxRepositoryNotes)
+	}
+	return nil
+}
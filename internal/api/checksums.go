@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/requestid"
+	"github.com/depot/depot/internal/scan"
+	"github.com/depot/depot/internal/storage"
+)
+
+// checksumHeader carries the SHA-256 of an artifact in upload and download
+// responses
+const checksumHeader = "X-Checksum-Sha256"
+
+// checksumHeaderFor returns the header that carries a digest of algorithm,
+// such as X-Checksum-Sha512
+func checksumHeaderFor(algorithm string) string {
+	return http.CanonicalHeaderKey("X-Checksum-" + algorithm)
+}
+
+// expectedChecksums returns the digests a client expects its upload to
+// have, from X-Checksum-Md5, -Sha1, -Sha256 and -Sha512 headers. A
+// malformed header is answered with 400.
+func (h *Handler) expectedChecksums(w http.ResponseWriter, r *http.Request) (checksum.Expected, bool) {
+	expected := checksum.Expected{}
+	for _, algorithm := range checksum.Verifiable {
+		header := checksumHeaderFor(algorithm)
+		value := strings.ToLower(strings.TrimSpace(r.Header.Get(header)))
+		if value == "" {
+			continue
+		}
+		if !checksum.Valid(algorithm, value) {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s header", header))
+			return nil, false
+		}
+		expected[algorithm] = value
+	}
+	return expected, true
+}
+
+// writeChecksumError answers an upload that did not match a digest the
+// client sent, naming the algorithm and both digests so the client can
+// tell corruption in transit from a wrong checksum. It returns false if
+// err is not a mismatch.
+func (h *Handler) writeChecksumError(w http.ResponseWriter, err error) bool {
+	var mismatch *checksum.MismatchError
+	if !errors.As(err, &mismatch) {
+		return false
+	}
+
+	response := map[string]string{
+		"error":     fmt.Sprintf("Checksum mismatch: upload does not match %s", checksumHeaderFor(mismatch.Algorithm)),
+		"algorithm": mismatch.Algorithm,
+		"expected":  mismatch.Expected,
+		"actual":    mismatch.Actual,
+	}
+	if id := w.Header().Get(requestid.Header); id != "" {
+		response["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+	return true
+}
+
+// storeArtifact stores a raw artifact and records its checksums. If
+// expected digests are given and the data does not match, nothing is
+// stored and a *checksum.MismatchError is returned.
+func (h *Handler) storeArtifact(repo, artifactPath string, data io.Reader, expected checksum.Expected) (*metadata.Artifact, error) {
+	return h.writeArtifact(repo, artifactPath, data, expected, nil)
+}
+
+// writeArtifact stores a raw artifact and records its metadata, including
+// the digests the client verified and the scan result if it was virus
+// scanned
+func (h *Handler) writeArtifact(repo, artifactPath string, data io.Reader, expected checksum.Expected, result *scan.Result) (*metadata.Artifact, error) {
+	reader := checksum.NewReader(data, h.contentChecksums(repo, artifactPath, expected))
+	if err := h.storage.Store(repo, artifactPath, reader); err != nil {
+		return nil, err
+	}
+
+	info, err := h.storage.Stat(repo, artifactPath)
+	if err != nil {
+		return nil, err
+	}
+
+	artifact := &metadata.Artifact{
+		Repository: repo,
+		Path:       artifactPath,
+		Size:       info.Size,
+		ModTime:    info.ModTime,
+		Scan:       result,
+		Verified:   reader.Verified(),
+		Sums:       reader.Sums(),
+	}
+	if err := h.metadata.Put(artifact); err != nil {
+		// The checksums are recomputed on demand, so the upload still counts
+		h.logger.WithError(err).Warnf("Failed to record metadata for %s/%s", repo, artifactPath)
+	}
+	return artifact, nil
+}
+
+// deleteArtifact removes a raw artifact and its metadata
+func (h *Handler) deleteArtifact(repo, artifactPath string) error {
+	if err := h.storage.Delete(repo, artifactPath); err != nil {
+		return err
+	}
+	return h.metadata.Delete(repo, artifactPath)
+}
+
+// artifactMetadata returns the recorded metadata for a stored artifact,
+// computing its checksums first if they are missing or describe an older
+// version of the file
+func (h *Handler) artifactMetadata(repo string, info *storage.FileInfo) (*metadata.Artifact, error) {
+	if artifact, err := h.metadata.Get(repo, info.Path); err == nil && artifact.Matches(info.Size, info.ModTime) {
+		return artifact, nil
+	}
+
+	file, err := h.storage.Retrieve(repo, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := checksum.NewReader(file, nil)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, fmt.Errorf("failed to read artifact: %w", err)
+	}
+
+	artifact := &metadata.Artifact{
+		Repository: repo,
+		Path:       info.Path,
+		Size:       info.Size,
+		ModTime:    info.ModTime,
+		Sums:       reader.Sums(),
+	}
+	if err := h.metadata.Put(artifact); err != nil {
+		h.logger.WithError(err).Warnf("Failed to record metadata for %s/%s", repo, info.Path)
+	}
+	return artifact, nil
+}
+
+// setChecksumHeaders adds the artifact's known checksums and scan verdict
+// to a download response. Checksums are not computed here, to avoid reading
+// large files twice.
+func (h *Handler) setChecksumHeaders(w http.ResponseWriter, repo string, info *storage.FileInfo) {
+	artifact, err := h.metadata.Get(repo, info.Path)
+	if err != nil || !artifact.Matches(info.Size, info.ModTime) {
+		return
+	}
+
+	w.Header().Set("X-Checksum-Md5", artifact.MD5)
+	w.Header().Set("X-Checksum-Sha1", artifact.SHA1)
+	w.Header().Set("X-Checksum-Sha256", artifact.SHA256)
+	if artifact.SHA512 != "" {
+		w.Header().Set("X-Checksum-Sha512", artifact.SHA512)
+	}
+	if artifact.Scan != nil && artifact.Scan.Clean {
+		w.Header().Set(scanHeader, fmt.Sprintf("clean; scanner=%s; scanned=%s", artifact.Scan.Scanner, artifact.Scan.ScannedAt.UTC().Format(http.TimeFormat)))
+	}
+}
+
+// serveChecksum answers a request for <artifact>.md5, .sha1 or .sha256 with
+// the digest of the artifact, as Maven, Gradle and download scripts expect.
+// It returns false if the path is not a checksum of a stored artifact.
+func (h *Handler) serveChecksum(w http.ResponseWriter, r *http.Request, repo, artifactPath string) bool {
+	algorithm := strings.TrimPrefix(path.Ext(artifactPath), ".")
+	supported := false
+	for _, a := range checksum.Algorithms {
+		if a == algorithm {
+			supported = true
+		}
+	}
+	if !supported {
+		return false
+	}
+
+	info, err := h.statArtifact(repo, strings.TrimSuffix(artifactPath, "."+algorithm))
+	if err != nil {
+		return false
+	}
+
+	artifact, err := h.artifactMetadata(repo, info)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to compute checksum")
+		h.writeError(w, http.StatusInternalServerError, "Failed to compute checksum")
+		return true
+	}
+
+	digest := artifact.Get(algorithm)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(digest)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, digest)
+	}
+	return true
+}
+
+type checksumSearchResponse struct {
+	Algorithm string                `json:"algorithm"`
+	Checksum  string                `json:"checksum"`
+	Artifacts []*metadata.Artifact  `json:"artifacts"`
+	Images    []*docker.DigestMatch `json:"images"`
+}
+
+// SearchChecksum finds every raw artifact, and for SHA-256 every Docker
+// manifest and blob, with the checksum given as one sha256, sha1 or md5
+// parameter
+func (h *Handler) SearchChecksum(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var algorithm, value string
+	for _, a := range checksum.Algorithms {
+		if !query.Has(a) {
+			continue
+		}
+		if algorithm != "" {
+			h.writeError(w, http.StatusBadRequest, "Only one checksum can be searched for")
+			return
+		}
+		algorithm, value = a, strings.ToLower(strings.TrimSpace(query.Get(a)))
+	}
+	if algorithm == "" {
+		h.writeError(w, http.StatusBadRequest, "A sha256, sha1 or md5 parameter is required")
+		return
+	}
+	// Docker digests are accepted as they are
+	if algorithm == "sha256" {
+		value = strings.TrimPrefix(value, "sha256:")
+	}
+	if !checksum.Valid(algorithm, value) {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s checksum", algorithm))
+		return
+	}
+
+	found, err := h.metadata.FindByChecksum(algorithm, value)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to search checksums")
+		h.writeError(w, http.StatusInternalServerError, "Failed to search checksums")
+		return
+	}
+	response := checksumSearchResponse{
+		Algorithm: algorithm,
+		Checksum:  value,
+		Artifacts: []*metadata.Artifact{},
+		Images:    []*docker.DigestMatch{},
+	}
+	for _, artifact := range found {
+		// Skip metadata of artifacts since removed or overwritten without
+		// going through the API
+		info, err := h.storage.Stat(artifact.Repository, artifact.Path)
+		if err != nil || !artifact.Matches(info.Size, info.ModTime) {
+			continue
+		}
+		response.Artifacts = append(response.Artifacts, artifact)
+	}
+	if algorithm == "sha256" {
+		response.Images = h.dockerManager.FindDigest("sha256:" + value)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/provenance"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/tasks"
+	"github.com/depot/depot/pkg/models"
+)
+
+// PreviewCleanup reports what every cleanup policy of a repository would
+// delete, including policies that are not yet enabled.
+func (h *Handler) PreviewCleanup(w http.ResponseWriter, r *http.Request) {
+	h.runCleanup(w, r, true)
+}
+
+// RunCleanup enforces the enabled cleanup policies of a repository now, or
+// submits a background task when called with async=true.
+func (h *Handler) RunCleanup(w http.ResponseWriter, r *http.Request) {
+	h.runCleanup(w, r, r.URL.Query().Get("dry_run") == "true")
+}
+
+func (h *Handler) runCleanup(w http.ResponseWriter, r *http.Request, dryRun bool) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+
+	if !h.cleanupEngine.Supports(repo) {
+		h.writeError(w, http.StatusBadRequest, "Cleanup policies are only supported for raw and Docker repositories")
+		return
+	}
+
+	if !dryRun && r.URL.Query().Get("async") == "true" {
+		task, err := h.taskManager.Submit("cleanup", repo.Name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			return h.cleanupEngine.Run(ctx, repo, false)
+		})
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to submit cleanup task")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(task)
+		return
+	}
+
+	result, err := h.cleanupEngine.Run(context.Background(), repo, dryRun)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to evaluate cleanup policies: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// validateRawConfig checks a raw repository configuration before it is stored
+func validateRawConfig(data json.RawMessage) error {
+	var config models.RawRepositoryConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	if config.MaxArtifactSize < 0 {
+		return fmt.Errorf("max_artifact_size cannot be negative")
+	}
+	if config.TrashRetentionDays < 0 {
+		return fmt.Errorf("trash_retention_days cannot be negative")
+	}
+	for _, pattern := range config.ImmutablePaths {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid immutable path pattern %q", pattern)
+		}
+	}
+	for _, rule := range config.ExpiryRules {
+		if _, err := path.Match(rule.PathPattern, ""); err != nil || rule.PathPattern == "" {
+			return fmt.Errorf("invalid expiry rule pattern %q", rule.PathPattern)
+		}
+		if _, err := models.ParseTTL(rule.TTL); err != nil {
+			return fmt.Errorf("expiry rule %s: %w", rule.PathPattern, err)
+		}
+	}
+
+	if err := validateUploadPolicy(&config); err != nil {
+		return err
+	}
+	if config.ContentAddressed && len(config.AllowedExtensions) > 0 {
+		return fmt.Errorf("allowed_extensions cannot apply to a content-addressed repository, whose paths have no extension")
+	}
+	if err := validateStorageClasses(&config); err != nil {
+		return err
+	}
+	if err := validateResponseHeaders(&config); err != nil {
+		return err
+	}
+	if err := provenance.ValidatePolicy(config.ProvenancePolicy); err != nil {
+		return err
+	}
+
+	if err := validateCleanupPolicies(config.CleanupPolicies); err != nil {
+		return err
+	}
+	for _, policy := range config.CleanupPolicies {
+		if len(policy.Labels) > 0 || len(policy.KeepLabels) > 0 {
+			return fmt.Errorf("cleanup policy %s: labels and keep_labels only apply to Docker repositories", policy.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateCleanupPolicies checks the cleanup policies of a raw or Docker
+// repository
+func validateCleanupPolicies(policies []models.CleanupPolicy) error {
+	names := make(map[string]bool)
+	for _, policy := range policies {
+		if policy.Name == "" {
+			return fmt.Errorf("cleanup policy name is required")
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("duplicate cleanup policy %s", policy.Name)
+		}
+		names[policy.Name] = true
+
+		if policy.MaxAgeDays < 0 || policy.KeepLatest < 0 {
+			return fmt.Errorf("cleanup policy %s has negative limits", policy.Name)
+		}
+		if policy.MaxAgeDays == 0 && policy.KeepLatest == 0 {
+			return fmt.Errorf("cleanup policy %s must set max_age_days or keep_latest", policy.Name)
+		}
+
+		for _, pattern := range append([]string{policy.PathPattern}, policy.Exclude...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("cleanup policy %s has invalid pattern %q", policy.Name, pattern)
+			}
+		}
+	}
+
+	return nil
+}
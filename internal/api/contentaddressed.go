@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/disconnect"
+	"github.com/depot/depot/pkg/models"
+)
+
+// contentPrefix is the directory holding the artifacts of a
+// content-addressed repository, each named by the hex SHA-256 of its
+// content
+const contentPrefix = "sha256/"
+
+// contentDigest returns the SHA-256 an artifact path of a content-addressed
+// repository names, and whether the path is sha256/<lowercase hex digest>
+func contentDigest(artifactPath string) (string, bool) {
+	digest, found := strings.CutPrefix(artifactPath, contentPrefix)
+	return digest, found && checksum.ValidSHA256(digest) && strings.ToLower(digest) == digest
+}
+
+// checkContentPath rejects with 400 an artifact written to a
+// content-addressed repository anywhere but sha256/<digest>, or an alias
+// written there
+func (h *Handler) checkContentPath(w http.ResponseWriter, config *models.RawRepositoryConfig, artifactPath string, alias bool) bool {
+	if err := contentPathRefused(config, artifactPath, alias); err != nil {
+		h.writeError(w, err.status, err.message)
+		return false
+	}
+	return true
+}
+
+// contentPathRefused refuses what checkContentPath rejects
+func contentPathRefused(config *models.RawRepositoryConfig, artifactPath string, alias bool) *writeRefusal {
+	if !config.ContentAddressed {
+		return nil
+	}
+	_, isContent := contentDigest(artifactPath)
+	switch {
+	case alias && isContent:
+		return &writeRefusal{http.StatusBadRequest, "Aliases in a content-addressed repository cannot be at " + contentPrefix + " paths"}
+	case !alias && !isContent:
+		return &writeRefusal{http.StatusBadRequest, "Repository is content-addressed: upload with POST, or to " + contentPrefix + "<SHA-256 of the content>"}
+	}
+	return nil
+}
+
+// contentChecksums adds the digest its path names to the checksums an
+// artifact written to a content-addressed repository must match, so no
+// path ever holds other content than it names
+func (h *Handler) contentChecksums(repoName, artifactPath string, expected checksum.Expected) checksum.Expected {
+	digest, ok := contentDigest(artifactPath)
+	if !ok {
+		return expected
+	}
+	repo, err := h.repoMgr.Get(repoName)
+	if err != nil {
+		return expected
+	}
+	if config, err := rawConfig(repo); err != nil || !config.ContentAddressed {
+		return expected
+	}
+
+	withDigest := checksum.Expected{"sha256": digest}
+	for algorithm, value := range expected {
+		if algorithm != "sha256" {
+			withDigest[algorithm] = value
+		}
+	}
+	return withDigest
+}
+
+// postContentAddressed stores an upload to a content-addressed repository
+// at the path its SHA-256 names, answering like a PUT there. The upload is
+// spooled to disk while its digest is computed. Content already stored is
+// not written again.
+func (h *Handler) postContentAddressed(w http.ResponseWriter, r *http.Request, repo *models.Repository) {
+	limit, err := h.uploadLimit(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	if limit > 0 && r.ContentLength > limit {
+		h.writeSizeError(w, limit)
+		return
+	}
+
+	spool, err := os.CreateTemp("", "depot-upload-*")
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to stage upload")
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	watched := disconnect.Watch(r)
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeSizeError(w, limit)
+			return
+		}
+		if h.uploadAborted(w, r, watched, repo.Name, abortedRaw) {
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, "Failed to read upload")
+		return
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to stage upload")
+		return
+	}
+
+	r.Body = io.NopCloser(spool)
+	r.ContentLength = size
+	h.putRawArtifact(w, r, repo, contentPrefix+hex.EncodeToString(hash.Sum(nil)))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/depot/depot/internal/dbmaint"
+)
+
+// GetDatabaseStats reports the database's size, free pages, fragmentation
+// and per-bucket usage
+func (h *Handler) GetDatabaseStats(w http.ResponseWriter, r *http.Request) {
+	report, err := dbmaint.Inspect(h.db)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to inspect database")
+		h.writeError(w, http.StatusInternalServerError, "Failed to inspect database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// CheckDatabase runs an integrity check of every database page. It responds
+// 200 with ok=false and the problems found if the check fails.
+func (h *Handler) CheckDatabase(w http.ResponseWriter, r *http.Request) {
+	problems, err := dbmaint.Check(h.db)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to check database")
+		h.writeError(w, http.StatusInternalServerError, "Failed to check database")
+		return
+	}
+	if len(problems) > 0 {
+		h.requestLogger(r).WithField("problems", len(problems)).Error("Database integrity check failed")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":       len(problems) == 0,
+		"problems": problems,
+	})
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.etcd.io/bbolt"
+)
+
+// diagnosticsRepo is the storage namespace used for probe objects
+const diagnosticsRepo = ".diagnostics"
+
+type diagnosticCheck struct {
+	Component string                 `json:"component"`
+	Name      string                 `json:"name,omitempty"`
+	Status    string                 `json:"status"`
+	LatencyMS float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+type diagnosticsResponse struct {
+	Status string             `json:"status"`
+	Time   time.Time          `json:"time"`
+	Checks []*diagnosticCheck `json:"checks"`
+}
+
+// Diagnostics performs deeper health checks than Health: a storage round
+// trip, database statistics and a connection to every registry listener.
+// It responds 503 if any check fails.
+func (h *Handler) Diagnostics(w http.ResponseWriter, r *http.Request) {
+	checks := []*diagnosticCheck{
+		h.checkStorage(),
+		h.checkDatabase(),
+	}
+	checks = append(checks, h.checkRegistries()...)
+
+	response := diagnosticsResponse{
+		Status: "healthy",
+		Time:   time.Now().UTC(),
+		Checks: checks,
+	}
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			response.Status = "unhealthy"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *Handler) checkStorage() *diagnosticCheck {
+	check := &diagnosticCheck{Component: "storage", Status: "ok"}
+	start := time.Now()
+	defer func() { check.LatencyMS = milliseconds(time.Since(start)) }()
+
+	probePath := "probe-" + uuid.New().String()
+	payload := []byte("depot diagnostics probe " + probePath)
+	defer h.storage.Delete(diagnosticsRepo, probePath)
+
+	if err := h.storage.Store(diagnosticsRepo, probePath, bytes.NewReader(payload)); err != nil {
+		check.fail(fmt.Errorf("write probe: %w", err))
+		return check
+	}
+
+	reader, err := h.storage.Retrieve(diagnosticsRepo, probePath)
+	if err != nil {
+		check.fail(fmt.Errorf("read probe: %w", err))
+		return check
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		check.fail(fmt.Errorf("read probe: %w", err))
+		return check
+	}
+	if !bytes.Equal(data, payload) {
+		check.fail(fmt.Errorf("probe content mismatch"))
+	}
+
+	return check
+}
+
+func (h *Handler) checkDatabase() *diagnosticCheck {
+	check := &diagnosticCheck{Component: "database", Name: h.db.Path(), Status: "ok"}
+	start := time.Now()
+	defer func() { check.LatencyMS = milliseconds(time.Since(start)) }()
+
+	buckets := 0
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			buckets++
+			return nil
+		})
+	})
+	if err != nil {
+		check.fail(fmt.Errorf("read transaction: %w", err))
+		return check
+	}
+
+	stats := h.db.Stats()
+	check.Details = map[string]interface{}{
+		"buckets":         buckets,
+		"free_pages":      stats.FreePageN,
+		"pending_pages":   stats.PendingPageN,
+		"free_alloc":      stats.FreeAlloc,
+		"freelist_inuse":  stats.FreelistInuse,
+		"open_read_txns":  stats.OpenTxN,
+		"total_read_txns": stats.TxN,
+	}
+	if info, err := os.Stat(h.db.Path()); err == nil {
+		check.Details["file_size"] = info.Size()
+	}
+
+	return check
+}
+
+func (h *Handler) checkRegistries() []*diagnosticCheck {
+	addrs := h.dockerManager.Addresses()
+
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checks := make([]*diagnosticCheck, 0, len(names))
+	for _, name := range names {
+		addr := addrs[name]
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+
+		check := &diagnosticCheck{
+			Component: "registry",
+			Name:      name,
+			Status:    "ok",
+			Details:   map[string]interface{}{"address": addr},
+		}
+
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		check.LatencyMS = milliseconds(time.Since(start))
+		if err != nil {
+			check.fail(fmt.Errorf("listener not reachable: %w", err))
+		} else {
+			conn.Close()
+		}
+
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func (c *diagnosticCheck) fail(err error) {
+	c.Status = "failed"
+	c.Error = err.Error()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
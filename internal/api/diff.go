@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/pkg/models"
+)
+
+// DiffRepository compares two states of a repository for release review.
+// With image references such as app:1.0 or app@sha256:..., from and to
+// name two images of a Docker repository, compared layer by layer along
+// with their config, labels and size. Otherwise they name two snapshots,
+// to defaulting to the repository as it is now.
+func (h *Handler) DiffRepository(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.snapshotRepository(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" {
+		h.writeError(w, http.StatusBadRequest, "The from parameter is required")
+		return
+	}
+
+	if !isImageReference(from) && !isImageReference(to) {
+		diff, ok := h.diffSnapshots(w, r, repo, from, to)
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+		return
+	}
+
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Images can only be compared in Docker repositories")
+		return
+	}
+	fromImage, fromReference, err := docker.ParseImageReference(from)
+	if err != nil || !isImageReference(to) {
+		h.writeError(w, http.StatusBadRequest, "Compare two images given as image:tag or image@digest")
+		return
+	}
+	toImage, toReference, err := docker.ParseImageReference(to)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Compare two images given as image:tag or image@digest")
+		return
+	}
+
+	var platform *docker.Platform
+	if value := query.Get("platform"); value != "" {
+		if platform, err = docker.ParsePlatform(value); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid platform: %v", err))
+			return
+		}
+	}
+
+	diff, err := h.dockerManager.CompareImages(repo.Name, fromImage, fromReference, toImage, toReference, platform)
+	if err != nil {
+		switch {
+		case errors.Is(err, docker.ErrManifestNotFound) || errors.Is(err, docker.ErrPlatformNotFound):
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("Image not found: %v", err))
+		case errors.Is(err, docker.ErrPlatformRequired):
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Choose a platform with ?platform=: %v", err))
+		default:
+			h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to compare images: %v", err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// isImageReference tells an image reference from a snapshot name, which
+// cannot contain ':' or '@'
+func isImageReference(ref string) bool {
+	return strings.ContainsAny(ref, ":@")
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/depot/depot/internal/disconnect"
+)
+
+// Kinds of uploads counted when their client gives up on them
+const (
+	abortedRaw       = "raw"
+	abortedResumable = "resumable"
+)
+
+// uploadAborted answers an upload whose client went away before sending
+// all of it, and counts it. What was received has been discarded by then,
+// except by resumable uploads, which keep it for the client to resume. It
+// returns false if the client did not go away.
+func (h *Handler) uploadAborted(w http.ResponseWriter, r *http.Request, body *disconnect.Body, repo, kind string) bool {
+	if !body.Gone() {
+		return false
+	}
+	h.requestLogger(r).WithField("repository", repo).Info("Client disconnected during upload")
+	if h.metrics != nil {
+		h.metrics.RecordAbortedUpload(repo, kind)
+	}
+	h.writeError(w, http.StatusBadRequest, "Upload cut short by the client")
+	return true
+}
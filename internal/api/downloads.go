@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/pkg/models"
+)
+
+// defaultTopDownloads is how many entries the top downloads report lists
+// unless ?limit= says otherwise
+const defaultTopDownloads = 20
+
+// TopDownloads reports the most downloaded raw artifacts or image tags of a
+// repository. ?limit= sets the number of entries, 0 for all.
+func (h *Handler) TopDownloads(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.lookupRepository(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultTopDownloads
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			h.writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	top, err := h.metadata.TopDownloads(repo.Name, limit)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get download statistics")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get download statistics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(top)
+}
+
+// GetDownloads returns the download counter of a raw artifact, or of an
+// image given as name:tag or name@digest
+func (h *Handler) GetDownloads(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.lookupRepository(w, r)
+	if !ok {
+		return
+	}
+
+	artifactPath := mux.Vars(r)["path"]
+	if repo.Type == models.RepositoryTypeRaw {
+		if artifactPath, ok = cleanArtifactPath(artifactPath); !ok {
+			h.writeError(w, http.StatusBadRequest, "Invalid artifact path")
+			return
+		}
+	}
+
+	downloads, err := h.metadata.GetDownloads(repo.Name, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get download statistics")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get download statistics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downloads)
+}
+
+// recordDownload counts a completed download of a raw artifact
+func (h *Handler) recordDownload(r *http.Request, repoName, artifactPath string) {
+	if err := h.metadata.RecordDownload(repoName, artifactPath, time.Now()); err != nil {
+		h.requestLogger(r).WithError(err).Warnf("Failed to record download of %s/%s", repoName, artifactPath)
+	}
+}
+
+// lookupRepository returns the repository of any type named in the request
+// path
+func (h *Handler) lookupRepository(w http.ResponseWriter, r *http.Request) (*models.Repository, bool) {
+	repo, err := h.repoMgr.Get(mux.Vars(r)["name"])
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return nil, false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return nil, false
+	}
+	return repo, true
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+const (
+	// ttlHeader sets an upload's time to live, like the ttl query parameter
+	ttlHeader = "X-Artifact-Ttl"
+	// expiresHeader reports when a downloaded artifact will be removed
+	expiresHeader = "X-Artifact-Expires"
+)
+
+// uploadExpiry returns when an upload expires: after the TTL the client
+// asked for, else after the TTL of the first matching expiry rule, else
+// after the TTL of its storage class. The zero time means the artifact
+// does not expire.
+func uploadExpiry(r *http.Request, config *models.RawRepositoryConfig, artifactPath, class string) (time.Time, error) {
+	return artifactExpiry(requestedTTL(r), config, artifactPath, class)
+}
+
+// requestedTTL returns the TTL a client asked for with an upload, if any
+func requestedTTL(r *http.Request) string {
+	if requested := r.Header.Get(ttlHeader); requested != "" {
+		return requested
+	}
+	return r.URL.Query().Get("ttl")
+}
+
+// artifactExpiry is uploadExpiry given the TTL requested
+func artifactExpiry(requested string, config *models.RawRepositoryConfig, artifactPath, class string) (time.Time, error) {
+	if requested != "" {
+		if isImmutable(config, artifactPath) {
+			return time.Time{}, errors.New("artifacts at immutable paths cannot expire")
+		}
+		ttl, err := models.ParseTTL(requested)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().Add(ttl), nil
+	}
+
+	if isImmutable(config, artifactPath) {
+		return time.Time{}, nil
+	}
+	for _, rule := range config.ExpiryRules {
+		if glob.Match(rule.PathPattern, artifactPath) {
+			ttl, err := models.ParseTTL(rule.TTL)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Now().Add(ttl), nil
+		}
+	}
+	if classTTL := config.StorageClassTTL(class); classTTL != "" {
+		ttl, err := models.ParseTTL(classTTL)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Now().Add(ttl), nil
+	}
+	return time.Time{}, nil
+}
+
+// setExpiry records when a newly stored artifact expires, replacing the
+// expiry of any artifact it overwrote
+func (h *Handler) setExpiry(logger *logrus.Entry, repoName, artifactPath string, expiresAt time.Time) {
+	if err := h.metadata.SetExpiry(repoName, artifactPath, expiresAt); err != nil {
+		logger.WithError(err).Warnf("Failed to record expiry for %s/%s", repoName, artifactPath)
+	}
+}
+
+// setExpiresHeader tells a client when a downloaded artifact expires
+func (h *Handler) setExpiresHeader(w http.ResponseWriter, repoName, artifactPath string) {
+	expiresAt, err := h.metadata.GetExpiry(repoName, artifactPath)
+	if err == nil && !expiresAt.IsZero() {
+		w.Header().Set(expiresHeader, expiresAt.UTC().Format(http.TimeFormat))
+	}
+}
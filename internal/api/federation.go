@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Lifetimes of federation tokens: the default, and the longest that may be
+// asked for
+const (
+	defaultFederationTokenTTL = 30 * 24 * time.Hour
+	maxFederationTokenTTL     = 365 * 24 * time.Hour
+)
+
+// CreateFederationToken mints a token for another depot to mirror every
+// image of a Docker repository and follow its change feed
+func (h *Handler) CreateFederationToken(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	var req struct {
+		ExpiresIn string `json:"expires_in,omitempty"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	ttl := defaultFederationTokenTTL
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = models.ParseTTL(req.ExpiresIn); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid expires_in: %v", err))
+			return
+		}
+		if ttl > maxFederationTokenTTL {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in may be at most %s", maxFederationTokenTTL))
+			return
+		}
+	}
+
+	token, err := h.dockerManager.MintFederationToken(name, time.Now().Add(ttl))
+	if err != nil {
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to create federation token: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// GetChanges lists the tags of a Docker repository changed since ?since=,
+// an RFC 3339 time, for depots federating from it. Repositories that
+// require pull tokens only answer requests with a federation token.
+func (h *Handler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	var config models.DockerRepositoryConfig
+	json.Unmarshal(repo.Config, &config)
+	if config.RequirePullToken {
+		if err := h.dockerManager.CheckFederationToken(name, r); err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="depot"`)
+			h.writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid since, expected an RFC 3339 time")
+			return
+		}
+	}
+
+	feed, err := h.dockerManager.Changes(name, since)
+	if err != nil {
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to list changes: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feed)
+}
+
+// SyncFederation fetches into a Docker proxy repository the tags changed
+// on the depot repositories its upstreams federate from
+func (h *Handler) SyncFederation(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	report, err := h.dockerManager.SyncFederation(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, docker.ErrNotProxy) {
+			h.writeError(w, http.StatusBadRequest, "Repository is not a proxy repository")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to sync repository: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -1,17 +1,42 @@
 package api
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/depot/depot/internal/archival"
+	"github.com/depot/depot/internal/buildcache"
+	"github.com/depot/depot/internal/cleanup"
+	"github.com/depot/depot/internal/disconnect"
 	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/httpcache"
+	"github.com/depot/depot/internal/logging"
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/metrics"
+	"github.com/depot/depot/internal/notify"
+	"github.com/depot/depot/internal/presign"
+	"github.com/depot/depot/internal/provenance"
 	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/requestid"
+	"github.com/depot/depot/internal/scan"
+	"github.com/depot/depot/internal/scheduler"
+	"github.com/depot/depot/internal/signing"
+	"github.com/depot/depot/internal/snapshots"
 	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/tasks"
+	"github.com/depot/depot/internal/trash"
+	"github.com/depot/depot/internal/uploads"
+	"github.com/depot/depot/internal/validation"
 	"github.com/depot/depot/pkg/models"
 	"github.com/sirupsen/logrus"
 	"go.etcd.io/bbolt"
@@ -23,18 +48,54 @@ type Handler struct {
 	logger        *logrus.Logger
 	repoMgr       *repository.Manager
 	dockerManager *docker.Manager
+	cleanupEngine *cleanup.Engine
+	taskManager   *tasks.Manager
+	scheduler     *scheduler.Scheduler
+	uploads       *uploads.Manager
+	trash         *trash.Manager
+	scanner       *scan.Manager
+	validator     *validation.Manager
+	maintenance   *maintenance.Manager
+	archives      *archival.Manager
+	snapshots     *snapshots.Store
+	notifier      *notify.Manager
+	signer        *presign.Signer
+	metrics       *metrics.Recorder
+	metadata      *metadata.Store
+	sampling      *logging.AccessSampling
+	buildCache    *buildcache.Cache
+	signingKeys   *signing.Manager
+	maxUploadSize int64
+	externalURL   string
+	stagingMu     sync.Mutex // serializes staging repository lifecycle changes
 }
 
-func NewHandler(db *bbolt.DB, storage storage.Storage, dockerManager *docker.Manager, logger *logrus.Logger) *Handler {
+func NewHandler(db *bbolt.DB, storage storage.Storage, dockerManager *docker.Manager, taskManager *tasks.Manager, scheduler *scheduler.Scheduler, uploads *uploads.Manager, trash *trash.Manager, logger *logrus.Logger) *Handler {
+	repoMgr := repository.NewManager(db, storage, logger)
+	cleanupEngine := cleanup.NewEngine(repoMgr, storage, logger)
+	cleanupEngine.SetDockerManager(dockerManager)
+
 	return &Handler{
 		db:            db,
 		storage:       storage,
 		logger:        logger,
-		repoMgr:       repository.NewManager(db, storage, logger),
+		repoMgr:       repoMgr,
 		dockerManager: dockerManager,
+		cleanupEngine: cleanupEngine,
+		taskManager:   taskManager,
+		scheduler:     scheduler,
+		uploads:       uploads,
+		trash:         trash,
+		metadata:      metadata.NewStore(db),
 	}
 }
 
+// SetMaxUploadSize sets the server-wide artifact size limit in bytes; 0
+// means unlimited. A repository's own max_artifact_size applies if lower.
+func (h *Handler) SetMaxUploadSize(size int64) {
+	h.maxUploadSize = size
+}
+
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -43,13 +104,38 @@ func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ListRepositories lists every repository, or with metadata=name=value
+// parameters only those whose metadata matches all of them
 func (h *Handler) ListRepositories(w http.ResponseWriter, r *http.Request) {
+	filter := make(map[string]string)
+	for _, param := range r.URL.Query()["metadata"] {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid metadata filter %q, expected name=value", param))
+			return
+		}
+		filter[name] = value
+	}
+
+	namespace, inNamespace := r.URL.Query()["namespace"]
+
 	repos, err := h.repoMgr.List()
 	if err != nil {
 		h.writeError(w, http.StatusInternalServerError, "Failed to list repositories")
 		return
 	}
 
+	matching := repos[:0]
+	for _, repo := range repos {
+		if inNamespace && repo.Namespace != namespace[0] {
+			continue
+		}
+		if models.HasLabels(repo.Metadata, filter) {
+			matching = append(matching, redactRepository(repo))
+		}
+	}
+	repos = matching
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(repos)
 }
@@ -66,11 +152,51 @@ func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if repo.Type != models.RepositoryTypeDocker && repo.Type != models.RepositoryTypeRaw {
+	if repo.Type != models.RepositoryTypeDocker && repo.Type != models.RepositoryTypeRaw && repo.Type != models.RepositoryTypeBuildCache {
 		h.writeError(w, http.StatusBadRequest, "Invalid repository type")
 		return
 	}
 
+	if err := validateCatalog(&repo); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid repository: %v", err))
+		return
+	}
+
+	// A repository starts from the defaults of its namespace
+	ns, ok := h.repositoryNamespace(w, repo.Namespace)
+	if !ok {
+		return
+	}
+	if ns != nil {
+		config, err := withDefaults(ns.Defaults[repo.Type], repo.Config)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid repository configuration: %v", err))
+			return
+		}
+		repo.Config = config
+	}
+
+	if repo.Type == models.RepositoryTypeRaw && repo.Config != nil {
+		if err := validateRawConfig(repo.Config); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid raw repository configuration: %v", err))
+			return
+		}
+		if !h.checkSigningKey(w, repo.Config) {
+			return
+		}
+		if stagingConfigChanged(nil, repo.Config) {
+			h.writeError(w, http.StatusBadRequest, "Staging repositories are created with the staging API")
+			return
+		}
+	}
+
+	if repo.Type == models.RepositoryTypeBuildCache && repo.Config != nil {
+		if err := validateBuildCacheConfig(repo.Config); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid build cache repository configuration: %v", err))
+			return
+		}
+	}
+
 	// For Docker repositories, validate and parse configuration
 	if repo.Type == models.RepositoryTypeDocker {
 		var config models.DockerRepositoryConfig
@@ -79,6 +205,11 @@ func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 				h.writeError(w, http.StatusBadRequest, "Invalid Docker repository configuration")
 				return
 			}
+			// Use the default port if none is given; the main port is
+			// chosen with "http_port": 0
+			if !portsGiven(repo.Config) {
+				config.HTTPPort = 5000
+			}
 		} else {
 			// Set default configuration
 			config = models.DockerRepositoryConfig{
@@ -88,14 +219,54 @@ func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		
-		// Validate port configuration
-		if config.HTTPPort == 0 && config.HTTPSPort == 0 {
-			// Use default port if none specified
-			config.HTTPPort = 5000
+		if config.HTTPPort == models.PortAuto && config.HTTPSPort > 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", docker.ErrAutoPortWithTLS))
+			return
 		}
-		
+		if config.MaxLayerSize < 0 {
+			h.writeError(w, http.StatusBadRequest, "Invalid Docker repository configuration: max_layer_size cannot be negative")
+			return
+		}
+		if config.SignaturePolicy != nil {
+			if _, err := docker.NewSignatureVerifier(config.SignaturePolicy); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+				return
+			}
+		}
+		if config.Proxy != nil {
+			if err := docker.ValidateProxy(config.Proxy); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+				return
+			}
+		}
+		if config.PromotionPolicy != nil {
+			if err := docker.ValidatePromotionPolicy(&config); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+				return
+			}
+		}
+		if err := provenance.ValidatePolicy(config.ProvenancePolicy); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+			return
+		}
+		if err := validateCleanupPolicies(config.CleanupPolicies); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+			return
+		}
+
+		if err := docker.ValidateBindAddress(config.BindAddress); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+			return
+		}
+		if config.TLS != nil {
+			if err := h.dockerManager.ValidateTLS(&config); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+				return
+			}
+		}
+
 		// Check for port conflicts
-		if inUse, conflictRepo := h.dockerManager.IsPortInUse(config.HTTPPort, config.HTTPSPort); inUse {
+		if inUse, conflictRepo := h.dockerManager.IsPortInUse(&config); inUse {
 			h.writeError(w, http.StatusConflict, fmt.Sprintf("Port already in use by repository %s", conflictRepo))
 			return
 		}
@@ -110,14 +281,24 @@ func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusConflict, "Repository already exists")
 			return
 		}
+		if err == repository.ErrNamespaceNotFound {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Namespace %s not found", repo.Namespace))
+			return
+		}
 		h.writeError(w, http.StatusInternalServerError, "Failed to create repository")
 		return
 	}
 	
 	// Start Docker registry if it's a Docker repository
-	if repo.Type == models.RepositoryTypeDocker {
+	if repo.Type == models.RepositoryTypeDocker && !repo.IsEnabled() {
+		h.dockerManager.DisableRegistry(repo.Name)
+		var config models.DockerRepositoryConfig
+		json.Unmarshal(repo.Config, &config)
+		h.dockerManager.ReservePorts(repo.Name, &config)
+	} else if repo.Type == models.RepositoryTypeDocker {
 		var config models.DockerRepositoryConfig
 		json.Unmarshal(repo.Config, &config)
+		auto := config.HTTPPort == models.PortAuto
 		
 		if err := h.dockerManager.StartRegistry(&repo, &config); err != nil {
 			// Rollback repository creation
@@ -125,11 +306,34 @@ func (h *Handler) CreateRepository(w http.ResponseWriter, r *http.Request) {
 			h.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start Docker registry: %v", err))
 			return
 		}
+
+		// Keep the port picked for "http_port": "auto"
+		if auto {
+			repo.Config, _ = json.Marshal(config)
+			if err := h.repoMgr.Update(&repo); err != nil {
+				h.dockerManager.StopRegistry(repo.Name)
+				h.repoMgr.Delete(repo.Name)
+				h.writeError(w, http.StatusInternalServerError, "Failed to save Docker registry port")
+				return
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(repo)
+	json.NewEncoder(w).Encode(redactRepository(&repo))
+}
+
+// portsGiven reports whether a Docker repository configuration names
+// either of its ports
+func portsGiven(config json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(config, &fields); err != nil {
+		return false
+	}
+	_, plain := fields["http_port"]
+	_, https := fields["https_port"]
+	return plain || https
 }
 
 func (h *Handler) GetRepository(w http.ResponseWriter, r *http.Request) {
@@ -146,8 +350,181 @@ func (h *Handler) GetRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data, err := json.Marshal(redactRepository(repo))
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to encode repository")
+		return
+	}
+
+	etag := httpcache.ETag(fmt.Sprintf("%x", sha256.Sum256(data)))
+	if httpcache.NotModified(w, r, etag, repo.UpdatedAt) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(repo)
+	w.Write(append(data, '\n'))
+}
+
+func (h *Handler) UpdateRepository(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+
+	var update models.Repository
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if update.Name != "" && update.Name != repo.Name {
+		h.writeError(w, http.StatusBadRequest, "Repository name cannot be changed")
+		return
+	}
+	if update.Type != "" && update.Type != repo.Type {
+		h.writeError(w, http.StatusBadRequest, "Repository type cannot be changed")
+		return
+	}
+
+	// A changed Docker configuration is applied once it is saved
+	var reconfigured *models.DockerRepositoryConfig
+	if update.Config != nil {
+		switch repo.Type {
+		case models.RepositoryTypeRaw:
+			if err := validateRawConfig(update.Config); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid raw repository configuration: %v", err))
+				return
+			}
+			if !h.checkSigningKey(w, update.Config) {
+				return
+			}
+			if stagingConfigChanged(repo.Config, update.Config) {
+				h.writeError(w, http.StatusBadRequest, "Staging state can only be changed with the staging API")
+				return
+			}
+		case models.RepositoryTypeDocker:
+			var current, updated models.DockerRepositoryConfig
+			json.Unmarshal(repo.Config, &current)
+			if err := json.Unmarshal(update.Config, &updated); err != nil {
+				h.writeError(w, http.StatusBadRequest, "Invalid Docker repository configuration")
+				return
+			}
+			// Passwords are returned redacted, so a config read back is unchanged
+			updated.RestoreSecrets(&current)
+			if err := docker.CheckReconfigure(&current, &updated); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Docker repository configuration cannot be changed: %v", err))
+				return
+			}
+			if err := validateCleanupPolicies(updated.CleanupPolicies); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+				return
+			}
+			if updated.Proxy != nil {
+				if err := docker.ValidateProxy(updated.Proxy); err != nil {
+					h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Docker repository configuration: %v", err))
+					return
+				}
+			}
+			reconfigured = &updated
+			update.Config, _ = json.Marshal(updated)
+		case models.RepositoryTypeBuildCache:
+			if err := validateBuildCacheConfig(update.Config); err != nil {
+				h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid build cache repository configuration: %v", err))
+				return
+			}
+			if buildCacheProtocolChanged(repo.Config, update.Config) {
+				h.writeError(w, http.StatusBadRequest, "Build cache protocol cannot be changed")
+				return
+			}
+		}
+		repo.Config = update.Config
+	}
+	repo.Description = update.Description
+	repo.Notes = update.Notes
+	if update.Metadata != nil {
+		repo.Metadata = update.Metadata
+	}
+	if err := validateCatalog(repo); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid repository: %v", err))
+		return
+	}
+	// Moving a repository to another namespace keeps its configuration
+	if _, ok := h.repositoryNamespace(w, update.Namespace); !ok {
+		return
+	}
+	repo.Namespace = update.Namespace
+
+	// Taking a Docker repository offline stops its registry and bringing it
+	// back online starts it again; nothing stored is deleted
+	wasEnabled := repo.IsEnabled()
+	if update.Enabled != nil {
+		repo.Enabled = update.Enabled
+	}
+	if repo.Type == models.RepositoryTypeDocker && repo.IsEnabled() && !wasEnabled {
+		var config models.DockerRepositoryConfig
+		json.Unmarshal(repo.Config, &config)
+		auto := config.HTTPPort == models.PortAuto
+		if err := h.dockerManager.StartRegistry(repo, &config); err != nil {
+			h.writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start Docker registry: %v", err))
+			return
+		}
+		if auto {
+			repo.Config, _ = json.Marshal(config)
+		}
+	}
+
+	if err := h.repoMgr.Update(repo); err != nil {
+		// A registry started for the update goes back offline with it
+		if repo.Type == models.RepositoryTypeDocker && repo.IsEnabled() && !wasEnabled {
+			if err := h.dockerManager.DisableRegistry(repo.Name); err != nil {
+				h.requestLogger(r).WithError(err).Errorf("Failed to stop Docker registry for %s", repo.Name)
+			}
+		}
+		if err == repository.ErrNamespaceNotFound {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Namespace %s not found", repo.Namespace))
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to update repository")
+		return
+	}
+
+	if reconfigured != nil {
+		if err := h.dockerManager.Reconfigure(repo.Name, reconfigured); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to reconfigure Docker registry for %s", repo.Name)
+		}
+	}
+	if repo.Type == models.RepositoryTypeDocker && !repo.IsEnabled() && wasEnabled {
+		if err := h.dockerManager.DisableRegistry(repo.Name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to stop Docker registry for %s", repo.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactRepository(repo))
+}
+
+// redactRepository returns a copy of a repository without secrets, such as
+// the passwords of a Docker proxy's upstreams, for API responses
+func redactRepository(repo *models.Repository) *models.Repository {
+	if repo.Type != models.RepositoryTypeDocker || repo.Config == nil {
+		return repo
+	}
+	var config models.DockerRepositoryConfig
+	if err := json.Unmarshal(repo.Config, &config); err != nil || config.Proxy == nil {
+		return repo
+	}
+	config.RedactSecrets()
+	redacted := *repo
+	redacted.Config, _ = json.Marshal(config)
+	return &redacted
 }
 
 func (h *Handler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
@@ -168,7 +545,7 @@ func (h *Handler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
 	// Stop Docker registry if it's running
 	if repo.Type == models.RepositoryTypeDocker {
 		if err := h.dockerManager.StopRegistry(name); err != nil {
-			h.logger.WithError(err).Errorf("Failed to stop Docker registry for %s", name)
+			h.requestLogger(r).WithError(err).Errorf("Failed to stop Docker registry for %s", name)
 			// Continue with deletion even if registry stop fails
 		}
 	}
@@ -182,6 +559,39 @@ func (h *Handler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if repo.Type == models.RepositoryTypeDocker {
+		if err := h.dockerManager.ForgetImages(name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to remove Docker image records for %s", name)
+		}
+	}
+	if repo.Type == models.RepositoryTypeBuildCache {
+		if err := h.buildCache.Forget(name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to remove build cache entries for %s", name)
+		}
+	}
+	if err := h.metadata.DeleteRepository(name); err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to remove artifact metadata for %s", name)
+	}
+	if err := h.trash.DeleteRepository(name); err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to empty trash for %s", name)
+	}
+	if h.snapshots != nil {
+		if err := h.snapshots.DeleteRepository(name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to remove snapshots of %s", name)
+		}
+	}
+	// The bundle of an archived repository stays in cold storage
+	if h.archives != nil && h.archives.Frozen(name) {
+		if err := h.archives.Thaw(name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to remove archive record for %s", name)
+		}
+	}
+	if h.metrics != nil {
+		if err := h.metrics.DeleteRepository(name); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to remove usage metrics for %s", name)
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -202,18 +612,27 @@ func (h *Handler) HandleRepository(w http.ResponseWriter, r *http.Request) {
 		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
 		return
 	}
+	if !h.repositoryEnabled(w, repo) {
+		return
+	}
 
 	switch repo.Type {
 	case models.RepositoryTypeDocker:
 		h.handleDockerRepository(w, r, repo)
 	case models.RepositoryTypeRaw:
 		h.handleRawRepository(w, r, repo)
+	case models.RepositoryTypeBuildCache:
+		h.handleBuildCacheRepository(w, r, repo)
 	default:
 		h.writeError(w, http.StatusBadRequest, "Unsupported repository type")
 	}
 }
 
 func (h *Handler) handleDockerRepository(w http.ResponseWriter, r *http.Request, repo *models.Repository) {
+	if h.serveSignedBlob(w, r, strings.Split(r.URL.Path, "/")) {
+		return
+	}
+
 	// Docker repositories should be accessed via their dedicated ports
 	var config models.DockerRepositoryConfig
 	if err := json.Unmarshal(repo.Config, &config); err != nil {
@@ -231,39 +650,106 @@ func (h *Handler) handleDockerRepository(w http.ResponseWriter, r *http.Request,
 	
 	response := map[string]interface{}{
 		"message": "Docker repository should be accessed via Docker Registry API",
-		"endpoint": fmt.Sprintf("%s://localhost:%d/v2/", scheme, port),
 		"repository": repo.Name,
 	}
+	if !docker.OnMainPort(&config) {
+		host := "localhost"
+		if ip := net.ParseIP(config.BindAddress); config.BindAddress != "" && (ip == nil || !ip.IsUnspecified()) {
+			host = config.BindAddress
+		}
+		response["endpoint"] = fmt.Sprintf("%s://%s/v2/", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	if h.dockerManager.RoutedOnMainPort(repo.Name) {
+		response["main_port_endpoint"] = fmt.Sprintf("%s/v2/%s/", h.baseURL(r), repo.Name)
+	} else if docker.OnMainPort(&config) {
+		response["endpoint"] = h.baseURL(r) + "/v2/"
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func (h *Handler) handleRawRepository(w http.ResponseWriter, r *http.Request, repo *models.Repository) {
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	if !h.checkSignature(w, r, config.RequireSignedURLs) {
+		return
+	}
+
 	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) == 3 && h.serveDirectoryListing(w, r, repo, "") {
+		return
+	}
 	if len(pathParts) < 4 {
 		h.writeError(w, http.StatusBadRequest, "Invalid artifact path")
 		return
 	}
 	
+	if pathParts[3] == byDigestPrefix {
+		h.serveByDigest(w, r, repo, config, pathParts[4:])
+		return
+	}
 	artifactPath := strings.Join(pathParts[3:], "/")
 
 	switch r.Method {
 	case http.MethodGet:
-		h.getRawArtifact(w, r, repo.Name, artifactPath)
+		if format := r.URL.Query().Get("archive"); format != "" {
+			if config.ProvenancePolicy != nil {
+				h.writeError(w, http.StatusForbidden, "Archive downloads are not available in repositories with a provenance policy")
+				return
+			}
+			h.serveArchive(w, r, repo.Name, artifactPath, format)
+			return
+		}
+		h.getRawArtifact(w, r, repo, config, artifactPath)
 	case http.MethodPut:
-		h.putRawArtifact(w, r, repo.Name, artifactPath)
+		if r.URL.Query().Get("extract") == "true" {
+			h.extractArchive(w, r, repo, artifactPath)
+			return
+		}
+		h.putRawArtifact(w, r, repo, artifactPath)
+	case http.MethodPost:
+		if !config.ContentAddressed || artifactPath != "" {
+			h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.postContentAddressed(w, r, repo)
 	case http.MethodDelete:
-		h.deleteRawArtifact(w, r, repo.Name, artifactPath)
+		h.deleteRawArtifact(w, r, repo, artifactPath)
 	case http.MethodHead:
-		h.headRawArtifact(w, r, repo.Name, artifactPath)
+		h.headRawArtifact(w, r, repo.Name, config, artifactPath)
 	default:
 		h.writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 	}
 }
 
-func (h *Handler) getRawArtifact(w http.ResponseWriter, r *http.Request, repoName, artifactPath string) {
-	reader, err := h.storage.Retrieve(repoName, artifactPath)
+func (h *Handler) getRawArtifact(w http.ResponseWriter, r *http.Request, repo *models.Repository, config *models.RawRepositoryConfig, artifactPath string) {
+	repoName := repo.Name
+	info, err := h.statArtifact(repoName, artifactPath)
+	if err != nil {
+		if !h.serveChecksum(w, r, repoName, artifactPath) && !h.serveSignature(w, r, repoName, artifactPath, config.SigningKey) && !h.serveDirectoryListing(w, r, repo, artifactPath) {
+			h.writeError(w, http.StatusNotFound, "Artifact not found")
+		}
+		return
+	}
+	if !h.checkArtifactProvenance(w, r, repoName, info, config.ProvenancePolicy) {
+		return
+	}
+	h.setAliasHeaders(w, repoName, artifactPath, info)
+	setResponseHeaders(w, config, artifactPath)
+
+	if httpcache.NotModified(w, r, httpcache.FileETag(info.Size, info.ModTime), info.ModTime) {
+		return
+	}
+	h.setChecksumHeaders(w, repoName, info)
+	h.setExpiresHeader(w, repoName, info.Path)
+	h.setStorageClassHeader(w, repoName, info.Path)
+	h.setImmutableURLHeader(w, repoName, artifactPath, info)
+
+	reader, err := h.storage.Retrieve(repoName, info.Path)
 	if err != nil {
 		h.writeError(w, http.StatusNotFound, "Artifact not found")
 		return
@@ -271,20 +757,139 @@ func (h *Handler) getRawArtifact(w http.ResponseWriter, r *http.Request, repoNam
 	defer reader.Close()
 
 	w.Header().Set("Content-Type", "application/octet-stream")
-	io.Copy(w, reader)
+	if httpcache.ServeContent(w, r, info.ModTime, reader, info.Size) {
+		h.recordDownload(r, repoName, info.Path)
+	}
 }
 
-func (h *Handler) putRawArtifact(w http.ResponseWriter, r *http.Request, repoName, artifactPath string) {
-	if err := h.storage.Store(repoName, artifactPath, r.Body); err != nil {
-		h.writeError(w, http.StatusInternalServerError, "Failed to store artifact")
+func (h *Handler) putRawArtifact(w http.ResponseWriter, r *http.Request, repo *models.Repository, artifactPath string) {
+	limit, err := h.uploadLimit(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	expected, ok := h.expectedChecksums(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.checkUploadPolicy(w, repo, artifactPath, r.Header.Get("Content-Type")) {
+		return
+	}
+
+	properties, err := uploadProperties(r)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.checkOverwrite(w, repo, artifactPath) {
+		return
+	}
+
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	class, err := uploadStorageClass(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt, err := uploadExpiry(r, config, artifactPath, class)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	allowOverwrite, err := overwriteAllowed(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.metadata.GetAlias(repo.Name, artifactPath); err == nil {
+		h.writeError(w, http.StatusConflict, "Path is an alias; delete the alias before uploading an artifact here")
+		return
+	}
+
+	if limit > 0 {
+		if r.ContentLength > limit {
+			h.writeSizeError(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body := disconnect.Watch(r)
+
+	var artifact *metadata.Artifact
+	existing, err := h.storage.Stat(repo.Name, artifactPath)
+	identical := false
+	if err == nil && !allowOverwrite {
+		artifact, err = h.compareExisting(repo.Name, existing, r.Body, r.ContentLength, expected)
+		identical = err == nil
+	} else {
+		artifact, err = h.storeUpload(repo.Name, artifactPath, r.Body, expected)
+	}
+	if err != nil {
+		if h.uploadAborted(w, r, body, repo.Name, abortedRaw) || h.writeValidationError(w, r, err) || h.writeScanError(w, r, err) || h.writeChecksumError(w, err) {
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			h.writeSizeError(w, limit)
+		case errors.Is(err, errArtifactExists):
+			h.writeError(w, http.StatusConflict, "An artifact with different content already exists at "+artifactPath)
+		default:
+			h.writeError(w, http.StatusInternalServerError, "Failed to store artifact")
+		}
+		return
+	}
+
+	// Re-uploading identical content is a no-op that succeeds
+	if identical {
+		h.writeUploadResult(w, artifact, false)
+		return
+	}
+
+	// A new upload replaces the properties and expiry of the artifact it
+	// overwrites
+	if err := h.metadata.SetProperties(repo.Name, artifactPath, properties); err != nil {
+		h.requestLogger(r).WithError(err).Warnf("Failed to record properties for %s/%s", repo.Name, artifactPath)
+	}
+	h.setExpiry(h.requestLogger(r), repo.Name, artifactPath, expiresAt)
+	h.setStorageClass(h.requestLogger(r), repo.Name, artifactPath, class)
+	h.signUpload(h.requestLogger(r), repo.Name, config, artifactPath)
+	h.notifyPushed(repo.Name, artifactPath)
+	h.writeUploadResult(w, artifact, existing == nil)
 }
 
-func (h *Handler) deleteRawArtifact(w http.ResponseWriter, r *http.Request, repoName, artifactPath string) {
-	if err := h.storage.Delete(repoName, artifactPath); err != nil {
+func (h *Handler) deleteRawArtifact(w http.ResponseWriter, r *http.Request, repo *models.Repository, artifactPath string) {
+	if !h.checkDelete(w, repo, artifactPath) {
+		return
+	}
+
+	// Deleting an alias path removes the alias, like removing a symlink
+	if _, err := h.storage.Stat(repo.Name, artifactPath); errors.Is(err, storage.ErrNotFound) {
+		if err := h.metadata.DeleteAlias(repo.Name, artifactPath); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	if config.TrashRetentionDays > 0 {
+		h.trashArtifact(w, r, repo.Name, artifactPath, config.TrashRetention())
+		return
+	}
+
+	if err := h.deleteArtifact(repo.Name, artifactPath); err != nil {
 		h.writeError(w, http.StatusInternalServerError, "Failed to delete artifact")
 		return
 	}
@@ -292,25 +897,107 @@ func (h *Handler) deleteRawArtifact(w http.ResponseWriter, r *http.Request, repo
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *Handler) headRawArtifact(w http.ResponseWriter, r *http.Request, repoName, artifactPath string) {
-	exists, err := h.storage.Exists(repoName, artifactPath)
+func (h *Handler) headRawArtifact(w http.ResponseWriter, r *http.Request, repoName string, config *models.RawRepositoryConfig, artifactPath string) {
+	info, err := h.statArtifact(repoName, artifactPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		if !h.serveChecksum(w, r, repoName, artifactPath) && !h.serveSignature(w, r, repoName, artifactPath, config.SigningKey) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
 	if err != nil {
 		h.writeError(w, http.StatusInternalServerError, "Failed to check artifact")
 		return
 	}
+	h.setAliasHeaders(w, repoName, artifactPath, info)
+	setResponseHeaders(w, config, artifactPath)
 
-	if !exists {
-		w.WriteHeader(http.StatusNotFound)
+	if httpcache.NotModified(w, r, httpcache.FileETag(info.Size, info.ModTime), info.ModTime) {
 		return
 	}
+	h.setChecksumHeaders(w, repoName, info)
+	h.setExpiresHeader(w, repoName, info.Path)
+	h.setStorageClassHeader(w, repoName, info.Path)
+	h.setImmutableURLHeader(w, repoName, artifactPath, info)
 
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
 	w.WriteHeader(http.StatusOK)
 }
 
+// rawRepository looks up the repository named in the request path and
+// checks that it is a raw repository, which feature requires
+func (h *Handler) rawRepository(w http.ResponseWriter, r *http.Request, feature string) (*models.Repository, bool) {
+	repo, err := h.repoMgr.Get(mux.Vars(r)["name"])
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return nil, false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return nil, false
+	}
+
+	if repo.Type != models.RepositoryTypeRaw {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("%s are only supported for raw repositories", feature))
+		return nil, false
+	}
+	// A repository taken offline can still be looked at, not changed
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !h.repositoryEnabled(w, repo) {
+		return nil, false
+	}
+	return repo, true
+}
+
+// repositoryEnabled refuses operations on a repository taken offline
+func (h *Handler) repositoryEnabled(w http.ResponseWriter, repo *models.Repository) bool {
+	if !repo.IsEnabled() {
+		h.writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("Repository %s is disabled", repo.Name))
+		return false
+	}
+	return true
+}
+
+// uploadLimit returns the effective artifact size limit for a raw
+// repository, or 0 if uploads are unlimited
+func (h *Handler) uploadLimit(repo *models.Repository) (int64, error) {
+	config, err := rawConfig(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	return models.SizeLimit(h.maxUploadSize, config.MaxArtifactSize), nil
+}
+
+func rawConfig(repo *models.Repository) (*models.RawRepositoryConfig, error) {
+	var config models.RawRepositoryConfig
+	if repo.Config != nil {
+		if err := json.Unmarshal(repo.Config, &config); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+func (h *Handler) writeSizeError(w http.ResponseWriter, limit int64) {
+	h.writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Artifact exceeds maximum upload size of %d bytes", limit))
+}
+
 func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	response := map[string]string{
+		"error": message,
+	}
+	if id := w.Header().Get(requestid.Header); id != "" {
+		response["request_id"] = id
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error": message,
-	})
+	json.NewEncoder(w).Encode(response)
+}
+
+// requestLogger returns a log entry tagged with the request's ID
+func (h *Handler) requestLogger(r *http.Request) *logrus.Entry {
+	return h.logger.WithField("request_id", requestid.FromContext(r.Context()))
 }
\ No newline at end of file
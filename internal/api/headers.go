@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+// managedHeaders are set by depot itself on downloads and cannot be
+// configured as response headers
+var managedHeaders = map[string]bool{
+	"Accept-Ranges":       true,
+	"Cache-Control":       true,
+	"Connection":          true,
+	"Content-Disposition": true,
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Content-Range":       true,
+	"Content-Type":        true,
+	"Etag":                true,
+	"Keep-Alive":          true,
+	"Last-Modified":       true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// validateResponseHeaders checks the response headers of a raw repository
+func validateResponseHeaders(config *models.RawRepositoryConfig) error {
+	for _, rule := range config.ResponseHeaders {
+		if _, err := path.Match(rule.PathPattern, ""); err != nil {
+			return fmt.Errorf("invalid response headers pattern %q", rule.PathPattern)
+		}
+		if strings.ContainsAny(rule.CacheControl, "\r\n") {
+			return fmt.Errorf("invalid cache_control %q", rule.CacheControl)
+		}
+		switch rule.ContentDisposition {
+		case "", "attachment", "inline":
+		default:
+			return fmt.Errorf("content_disposition must be attachment or inline, not %q", rule.ContentDisposition)
+		}
+		for name, value := range rule.Headers {
+			if !validHeaderName(name) {
+				return fmt.Errorf("invalid response header name %q", name)
+			}
+			if managedHeaders[http.CanonicalHeaderKey(name)] {
+				return fmt.Errorf("response header %s cannot be configured", name)
+			}
+			if strings.ContainsAny(value, "\r\n\x00") {
+				return fmt.Errorf("invalid value for response header %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an HTTP token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// setResponseHeaders sets the configured response headers of a download.
+// Every rule matching the path applies in order, so a later rule overrides
+// a header an earlier one set.
+func setResponseHeaders(w http.ResponseWriter, config *models.RawRepositoryConfig, artifactPath string) {
+	for _, rule := range config.ResponseHeaders {
+		if rule.PathPattern != "" && !glob.Match(rule.PathPattern, artifactPath) {
+			continue
+		}
+		switch rule.CacheControl {
+		case "":
+		case "immutable":
+			w.Header().Set("Cache-Control", models.ImmutableCacheControl)
+		default:
+			w.Header().Set("Cache-Control", rule.CacheControl)
+		}
+		if rule.ContentDisposition != "" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType(rule.ContentDisposition, map[string]string{
+				"filename": path.Base(artifactPath),
+			}))
+		}
+		for name, value := range rule.Headers {
+			w.Header().Set(name, value)
+		}
+	}
+}
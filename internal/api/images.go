@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/pkg/models"
+)
+
+// InspectImage describes an image manifest or manifest list, with the size
+// of each platform's image. ?platform=os/arch[/variant] limits a manifest
+// list to matching platforms.
+func (h *Handler) InspectImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a Docker repository")
+		return
+	}
+
+	var platform *docker.Platform
+	if value := r.URL.Query().Get("platform"); value != "" {
+		if platform, err = docker.ParsePlatform(value); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid platform: %v", err))
+			return
+		}
+	}
+
+	info, err := h.dockerManager.InspectImage(name, vars["image"], vars["reference"], platform)
+	if err != nil {
+		if errors.Is(err, docker.ErrManifestNotFound) || errors.Is(err, docker.ErrPlatformNotFound) {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("Image not found: %v", err))
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to inspect image: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// ListArtifacts lists the manifests in a Docker repository that are not
+// container images, such as ORAS artifacts, signatures and SBOMs.
+// ?artifact_type= limits the list to one artifact type.
+func (h *Handler) ListArtifacts(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a Docker repository")
+		return
+	}
+
+	artifacts, err := h.dockerManager.ListArtifacts(name, r.URL.Query().Get("artifact_type"))
+	if err != nil {
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to list artifacts: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
+
+// ListUpstreams reports the health of the upstreams of a Docker proxy
+// repository, in the order they are tried
+func (h *Handler) ListUpstreams(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a Docker repository")
+		return
+	}
+
+	upstreams, err := h.dockerManager.UpstreamStatus(name)
+	if err != nil {
+		if errors.Is(err, docker.ErrNotProxy) {
+			h.writeError(w, http.StatusBadRequest, "Repository is not a proxy repository")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to get upstreams: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upstreams)
+}
+
+// ListUpstreamQuarantine lists the upstream content a proxy repository
+// refused to cache because it failed verification
+func (h *Handler) ListUpstreamQuarantine(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	items, err := h.dockerManager.UpstreamQuarantine(name)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to list upstream quarantine")
+		h.writeError(w, http.StatusInternalServerError, "Failed to list upstream quarantine")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// DeleteUpstreamQuarantine removes an item of a proxy repository's
+// upstream quarantine
+func (h *Handler) DeleteUpstreamQuarantine(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.dockerRepository(w, vars["name"]) {
+		return
+	}
+
+	err := h.dockerManager.DeleteUpstreamQuarantine(vars["name"], vars["id"])
+	if errors.Is(err, docker.ErrQuarantineItemNotFound) {
+		h.writeError(w, http.StatusNotFound, "Quarantine item not found")
+		return
+	}
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to delete quarantine item")
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete quarantine item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SearchImages finds tagged Docker images by label. Each label=name=value
+// parameter must match an annotation of the manifest or a label of its
+// image config; repository narrows the search.
+func (h *Handler) SearchImages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := make(map[string]string)
+	for _, param := range query["label"] {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid label filter %q, expected name=value", param))
+			return
+		}
+		filter[name] = value
+	}
+	if len(filter) == 0 {
+		h.writeError(w, http.StatusBadRequest, "At least one label filter is required")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.dockerManager.FindImages(query.Get("repository"), filter))
+}
+
+// ReindexDocker rebuilds the manifest index of a Docker repository from
+// the manifests and tags in storage, for every image no other repository
+// serves or only the images named by ?image= parameters
+func (h *Handler) ReindexDocker(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	var images []string
+	for _, image := range r.URL.Query()["image"] {
+		clean, ok := cleanArtifactPath(image)
+		if !ok || clean == "" || strings.HasPrefix(clean, ".") {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid image name %q", image))
+			return
+		}
+		images = append(images, clean)
+	}
+
+	report, err := h.dockerManager.ReindexRegistry(name, images)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to reindex Docker repository")
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to reindex repository: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// isImmutable reports whether a path matches one of the repository's
+// immutable_paths patterns
+func isImmutable(config *models.RawRepositoryConfig, artifactPath string) bool {
+	for _, pattern := range config.ImmutablePaths {
+		if glob.Match(pattern, artifactPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOverwrite rejects a write with 409 if the path is immutable and
+// already holds an artifact or alias, or the repository is a closed
+// staging repository, and with 400 if the repository is content-addressed
+// and the path is not sha256/<digest>
+func (h *Handler) checkOverwrite(w http.ResponseWriter, repo *models.Repository, artifactPath string) bool {
+	return h.checkWrite(w, repo, artifactPath, false)
+}
+
+// checkWrite is checkOverwrite for an artifact or, if alias is set, an
+// alias. In content-addressed repositories aliases name content by other
+// paths than sha256/<digest>.
+func (h *Handler) checkWrite(w http.ResponseWriter, repo *models.Repository, artifactPath string, alias bool) bool {
+	if err := h.writeRefused(repo, artifactPath, alias); err != nil {
+		h.writeError(w, err.status, err.message)
+		return false
+	}
+	return true
+}
+
+// writeRefusal is a write checkWrite refuses, with the status it is
+// answered with
+type writeRefusal struct {
+	status  int
+	message string
+}
+
+func (e *writeRefusal) Error() string {
+	return e.message
+}
+
+// writeRefused returns why a write is refused, or nil if it is allowed.
+// Tasks, which have no response to write, check with it directly.
+func (h *Handler) writeRefused(repo *models.Repository, artifactPath string, alias bool) *writeRefusal {
+	config, err := rawConfig(repo)
+	if err != nil {
+		return &writeRefusal{http.StatusInternalServerError, "Invalid raw repository configuration"}
+	}
+	if refusal := stagingClosed(config); refusal != nil {
+		return refusal
+	}
+	if refusal := contentPathRefused(config, artifactPath, alias); refusal != nil {
+		return refusal
+	}
+	if !isImmutable(config, artifactPath) {
+		return nil
+	}
+
+	_, err = h.storage.Stat(repo.Name, artifactPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		if _, aliasErr := h.metadata.GetAlias(repo.Name, artifactPath); aliasErr != nil {
+			return nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return &writeRefusal{http.StatusInternalServerError, "Failed to check artifact"}
+	}
+	return &writeRefusal{http.StatusConflict, "Path " + artifactPath + " is immutable and cannot be overwritten"}
+}
+
+// checkDelete rejects a delete with 403 if the path is immutable, or 409 if
+// the repository is a closed staging repository
+func (h *Handler) checkDelete(w http.ResponseWriter, repo *models.Repository, artifactPath string) bool {
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return false
+	}
+	if !h.checkStagingOpen(w, config) {
+		return false
+	}
+	if isImmutable(config, artifactPath) {
+		h.writeError(w, http.StatusForbidden, "Path "+artifactPath+" is immutable and cannot be deleted")
+		return false
+	}
+	return true
+}
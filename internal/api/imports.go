@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/importer"
+	"github.com/depot/depot/internal/tasks"
+	"github.com/depot/depot/pkg/models"
+)
+
+// errImportSkipped is returned for an artifact the repository already has
+var errImportSkipped = errors.New("artifact already exists")
+
+// importResult is the result of an import task
+type importResult struct {
+	Source   string          `json:"source"`
+	Imported int             `json:"imported"`
+	Skipped  int             `json:"skipped"`
+	Bytes    int64           `json:"bytes"`
+	Failed   []importFailure `json:"failed"`
+}
+
+type importFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// importOptions are what an import takes from the request that started
+// it, read before the request is answered
+type importOptions struct {
+	class  string // storage class
+	ttl    string // requested time to live
+	logger *logrus.Entry
+}
+
+// ImportRepository starts a task migrating the artifacts of a Nexus or
+// Artifactory repository into a raw repository. Paths the repository
+// already has are skipped, so an interrupted import can be run again.
+func (h *Handler) ImportRepository(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Imports")
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return
+	}
+
+	var config importer.Config
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := config.Validate(); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rawCfg, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	class, err := uploadStorageClass(r, rawCfg)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := &importOptions{class: class, ttl: requestedTTL(r), logger: h.requestLogger(r)}
+	if opts.ttl != "" {
+		if _, err := models.ParseTTL(opts.ttl); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	source := importer.NewSource(config)
+	task, err := h.taskManager.Submit("import", repo.Name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+		return h.importArtifacts(ctx, run, opts, repo.Name, rawCfg, source)
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to submit import task")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+// importArtifacts copies every artifact of a source into a raw repository,
+// reporting progress as artifacts done out of those listed. An artifact
+// that fails is recorded and the import goes on.
+func (h *Handler) importArtifacts(ctx context.Context, run *tasks.Run, opts *importOptions, repoName string, config *models.RawRepositoryConfig, source *importer.Source) (*importResult, error) {
+	run.SetMessage("Listing " + source.Name())
+	artifacts, err := source.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &importResult{Source: source.Name(), Failed: []importFailure{}}
+	total := int64(len(artifacts))
+	run.SetMessage(fmt.Sprintf("Importing %d artifacts from %s", total, source.Name()))
+	for i, artifact := range artifacts {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		run.SetProgress(int64(i), total)
+
+		size, err := h.importArtifact(ctx, opts, repoName, config, source, artifact)
+		switch {
+		case errors.Is(err, errImportSkipped):
+			result.Skipped++
+		case err != nil:
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			opts.logger.WithError(err).WithField("source", source.Name()).Warnf("Failed to import %s into %s", artifact.Path, repoName)
+			result.Failed = append(result.Failed, importFailure{Path: artifact.Path, Error: err.Error()})
+		default:
+			result.Imported++
+			result.Bytes += size
+		}
+	}
+	run.SetProgress(total, total)
+	return result, nil
+}
+
+// importArtifact stores one artifact of a source as if it was uploaded,
+// verifying the checksums the source has for it. Its properties and last
+// modification time on the source are kept as properties, with the source
+// it came from. The repository's write rules are checked for each artifact,
+// as they may change while the import runs.
+func (h *Handler) importArtifact(ctx context.Context, opts *importOptions, repoName string, config *models.RawRepositoryConfig, source *importer.Source, artifact *importer.Artifact) (int64, error) {
+	artifactPath, ok := cleanArtifactPath(artifact.Path)
+	if !ok || artifactPath == "" {
+		return 0, errors.New("invalid path")
+	}
+	if _, err := h.storage.Stat(repoName, artifactPath); err == nil {
+		return 0, errImportSkipped
+	}
+	if _, err := h.metadata.GetAlias(repoName, artifactPath); err == nil {
+		return 0, errors.New("path is an alias")
+	}
+	repo, err := h.repoMgr.Get(repoName)
+	if err != nil {
+		return 0, err
+	}
+	if refusal := h.writeRefused(repo, artifactPath, false); refusal != nil {
+		return 0, refusal
+	}
+	// Sizes are not always known, so the content is limited as it is read
+	limit := models.SizeLimit(h.maxUploadSize, config.MaxArtifactSize)
+	tooLarge := fmt.Errorf("artifact exceeds the maximum upload size of %d bytes", limit)
+	if limit > 0 && artifact.Size > limit {
+		return 0, tooLarge
+	}
+	if !config.AllowsPath(artifactPath) {
+		return 0, errors.New("file extension not allowed")
+	}
+	if !config.AllowsContentType(artifact.ContentType) {
+		return 0, errors.New("content type not allowed")
+	}
+
+	expected := checksum.Expected{}
+	for algorithm, value := range artifact.Checksums {
+		if value = strings.ToLower(value); checksum.Valid(algorithm, value) {
+			expected[algorithm] = value
+		}
+	}
+	properties, err := source.Properties(ctx, artifact)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get properties: %w", err)
+	}
+	imported := map[string]string{"import.source": source.Name()}
+	for name, value := range properties {
+		if propertyNamePattern.MatchString(name) {
+			imported[name] = value
+		}
+	}
+	if !artifact.LastModified.IsZero() {
+		imported["import.last_modified"] = artifact.LastModified.UTC().Format(time.RFC3339)
+	}
+
+	reader, err := source.Open(ctx, artifact)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var data io.Reader = reader
+	if limit > 0 {
+		data = &entryLimitReader{r: reader, remaining: limit}
+	}
+	stored, err := h.storeUpload(repoName, artifactPath, data, expected)
+	if errors.Is(err, errEntryTooLarge) {
+		return 0, tooLarge
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := h.metadata.SetProperties(repoName, artifactPath, imported); err != nil {
+		opts.logger.WithError(err).Warnf("Failed to record properties for %s/%s", repoName, artifactPath)
+	}
+	h.setStorageClass(opts.logger, repoName, artifactPath, opts.class)
+	if expiresAt, err := artifactExpiry(opts.ttl, config, artifactPath, opts.class); err == nil {
+		h.setExpiry(opts.logger, repoName, artifactPath, expiresAt)
+	}
+	h.signUpload(opts.logger, repoName, config, artifactPath)
+	h.notifyPushed(repoName, artifactPath)
+	return stored.Size, nil
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/depot/depot/internal/forwarded"
+)
+
+// SetExternalURL sets the URL clients reach the server at, such as
+// https://depot.example.com, used for the links in responses instead of
+// the address a request arrived at
+func (h *Handler) SetExternalURL(externalURL string) {
+	h.externalURL = externalURL
+}
+
+// baseURL returns the scheme, host and any path prefix of absolute links
+// in the response to r
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.externalURL != "" {
+		return h.externalURL
+	}
+	return forwarded.BaseURL(r)
+}
+
+// location returns the Location header for a server path: relative, so it
+// is correct behind any proxy, unless an external URL is set
+func (h *Handler) location(path string) string {
+	return h.externalURL + path
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// listingEntry is a file or subdirectory shown in a directory listing
+type listingEntry struct {
+	Name    string
+	Href    string
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+}
+
+type listingPage struct {
+	Title   string
+	Parent  bool
+	Entries []listingEntry
+	Sort    string
+	Order   string
+}
+
+// SortLink returns the query string that sorts by column, reversing the
+// order if the listing is already sorted by it
+func (p listingPage) SortLink(column string) string {
+	order := "asc"
+	if p.Sort == column && p.Order == "asc" {
+		order = "desc"
+	}
+	return "?sort=" + column + "&order=" + order
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1.5em 0.2em 0; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<tr><th><a href="{{.SortLink "name"}}">Name</a></th><th><a href="{{.SortLink "size"}}">Size</a></th><th><a href="{{.SortLink "modified"}}">Modified</a></th></tr>
+{{if .Parent}}<tr><td><a href="../">../</a></td><td class="size">-</td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td class="size">{{if .Dir}}-{{else}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveDirectoryListing answers a browser's GET of a directory in a raw
+// repository with an HTML index, like nginx's autoindex. It returns false
+// if the request is not for a listable directory.
+func (h *Handler) serveDirectoryListing(w http.ResponseWriter, r *http.Request, repo *models.Repository, dir string) bool {
+	if r.Method != http.MethodGet || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+
+	config, err := rawConfig(repo)
+	if err != nil || config.DisableDirectoryListing {
+		return false
+	}
+
+	dir = strings.Trim(dir, "/")
+	files, err := h.storage.List(repo.Name, dir)
+	if err != nil || (len(files) == 0 && dir != "") {
+		return false
+	}
+
+	// Relative links only resolve correctly below a trailing slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return true
+	}
+
+	page := listingPage{
+		Title:   "Index of /repository/" + repo.Name + "/" + dir,
+		Parent:  dir != "",
+		Entries: listingEntries(files, dir),
+		Sort:    r.URL.Query().Get("sort"),
+		Order:   r.URL.Query().Get("order"),
+	}
+	if dir != "" {
+		page.Title += "/"
+	}
+	sortListing(page.Entries, page.Sort, page.Order == "desc")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listingTemplate.Execute(w, page); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to render directory listing")
+	}
+	return true
+}
+
+// listingEntries returns the immediate children of dir. A subdirectory's
+// modification time is that of the newest file below it.
+func listingEntries(files []storage.FileInfo, dir string) []listingEntry {
+	var entries []listingEntry
+	dirs := make(map[string]int)
+
+	for _, file := range files {
+		rel := file.Path
+		if dir != "" {
+			rel = strings.TrimPrefix(rel, dir+"/")
+		}
+
+		name, _, isDir := strings.Cut(rel, "/")
+		if !isDir {
+			entries = append(entries, listingEntry{
+				Name:    name,
+				Href:    url.PathEscape(name),
+				Size:    file.Size,
+				ModTime: file.ModTime,
+			})
+			continue
+		}
+
+		if i, ok := dirs[name]; ok {
+			if file.ModTime.After(entries[i].ModTime) {
+				entries[i].ModTime = file.ModTime
+			}
+			continue
+		}
+		dirs[name] = len(entries)
+		entries = append(entries, listingEntry{
+			Name:    name,
+			Href:    url.PathEscape(name) + "/",
+			Dir:     true,
+			ModTime: file.ModTime,
+		})
+	}
+
+	return entries
+}
+
+// sortListing orders entries by name, size or modified time, always
+// listing directories first
+func sortListing(entries []listingEntry, column string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		if desc {
+			a, b = b, a
+		}
+
+		switch column {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return a.Name < b.Name
+	})
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/logging"
+	"github.com/depot/depot/internal/repository"
+)
+
+// LogLevelRequest changes the level of the application log
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// GetLogLevel reports the level of the application log
+func (h *Handler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelRequest{Level: h.logger.GetLevel().String()})
+}
+
+// SetLogLevel changes the level of the application log until the server
+// restarts, e.g. to turn on debug logging while chasing a problem
+func (h *Handler) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid log level")
+		return
+	}
+
+	previous := h.logger.GetLevel()
+	h.logger.SetLevel(level)
+	h.requestLogger(r).WithField("previous", previous.String()).Warnf("Log level changed to %s", level)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelRequest{Level: level.String()})
+}
+
+// SetAccessSampling sets the per-repository access log settings the admin
+// API changes
+func (h *Handler) SetAccessSampling(sampling *logging.AccessSampling) {
+	h.sampling = sampling
+}
+
+// ListAccessLogSettings lists the repositories whose access log is
+// disabled or sampled
+func (h *Handler) ListAccessLogSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sampling.List())
+}
+
+// GetAccessLogSetting reports the access log setting of a repository
+func (h *Handler) GetAccessLogSetting(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.sampling.Get(mux.Vars(r)["name"]))
+}
+
+// SetAccessLogSetting disables or samples the access log of a repository
+// until the server restarts. Omitted fields keep their defaults, so
+// {"sample_rate": 0.01} logs one request in a hundred and {"enabled":
+// false} none. Request metrics still count every request.
+func (h *Handler) SetAccessLogSetting(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := h.repoMgr.Get(name); err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+
+	setting := logging.DefaultAccessSetting
+	if err := json.NewDecoder(r.Body).Decode(&setting); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := h.sampling.Set(name, setting); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.requestLogger(r).WithFields(logrus.Fields{
+		"repository":  name,
+		"enabled":     setting.Enabled,
+		"sample_rate": setting.SampleRate,
+	}).Warn("Access log setting changed")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(setting)
+}
+
+// ResetAccessLogSetting returns a repository to logging every request
+func (h *Handler) ResetAccessLogSetting(w http.ResponseWriter, r *http.Request) {
+	h.sampling.Reset(mux.Vars(r)["name"])
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/depot/depot/internal/maintenance"
+)
+
+// MaintenanceRequest turns maintenance on
+type MaintenanceRequest struct {
+	Mode    string `json:"mode"`
+	Message string `json:"message"`
+}
+
+// maintenanceStatus reports whether the server is in maintenance
+type maintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+	*maintenance.State
+}
+
+// SetMaintenance sets the maintenance switch the admin API changes
+func (h *Handler) SetMaintenance(switcher *maintenance.Manager) {
+	h.maintenance = switcher
+}
+
+// GetMaintenance reports whether the server is in maintenance, and in
+// which mode
+func (h *Handler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	state := h.maintenance.Get()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceStatus{Enabled: state != nil, State: state})
+}
+
+// StartMaintenance puts the server into read-only or offline maintenance
+// with a message for clients, e.g. while a backup runs. It stays in
+// maintenance, across restarts, until EndMaintenance.
+func (h *Handler) StartMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	state, err := h.maintenance.Enable(req.Mode, req.Message)
+	if err != nil {
+		if errors.Is(err, maintenance.ErrInvalidMode) {
+			h.writeError(w, http.StatusBadRequest, "Mode must be read-only or offline")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to start maintenance")
+		return
+	}
+	h.requestLogger(r).WithField("message", state.Message).Warnf("Maintenance started, server is %s", state.Mode)
+
+	w.Header().Set(maintenance.Header, state.Mode)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenanceStatus{Enabled: true, State: state})
+}
+
+// EndMaintenance takes the server out of maintenance
+func (h *Handler) EndMaintenance(w http.ResponseWriter, r *http.Request) {
+	if err := h.maintenance.Disable(); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to end maintenance")
+		return
+	}
+	h.requestLogger(r).Warn("Maintenance ended")
+	w.Header().Del(maintenance.Header)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/pkg/models"
+)
+
+// namespaceView is a namespace with the names of its repositories
+type namespaceView struct {
+	*models.Namespace
+	Repositories []string `json:"repositories"`
+}
+
+// newNamespaceView returns the view of a namespace, listing no
+// repositories as an empty list rather than null
+func newNamespaceView(ns *models.Namespace, repositories []string) namespaceView {
+	if repositories == nil {
+		repositories = []string{}
+	}
+	return namespaceView{ns, repositories}
+}
+
+// ListNamespaces lists the namespaces with their repositories
+func (h *Handler) ListNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := h.repoMgr.ListNamespaces()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list namespaces")
+		return
+	}
+	members, err := h.namespaceMembers()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list repositories")
+		return
+	}
+
+	views := make([]namespaceView, len(namespaces))
+	for i, ns := range namespaces {
+		views[i] = newNamespaceView(ns, members[ns.Name])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(views)
+}
+
+// CreateNamespace creates a namespace to group repositories in
+func (h *Handler) CreateNamespace(w http.ResponseWriter, r *http.Request) {
+	var ns models.Namespace
+	if err := json.NewDecoder(r.Body).Decode(&ns); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !propertyNamePattern.MatchString(ns.Name) {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid namespace name %q", ns.Name))
+		return
+	}
+	if err := validateNamespace(&ns); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid namespace: %v", err))
+		return
+	}
+
+	if err := h.repoMgr.CreateNamespace(&ns); err != nil {
+		if err == repository.ErrNamespaceExists {
+			h.writeError(w, http.StatusConflict, "Namespace already exists")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to create namespace")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newNamespaceView(&ns, nil))
+}
+
+// GetNamespace returns a namespace with its repositories
+func (h *Handler) GetNamespace(w http.ResponseWriter, r *http.Request) {
+	ns, ok := h.namespace(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+	members, err := h.namespaceMembers()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list repositories")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newNamespaceView(ns, members[ns.Name]))
+}
+
+// UpdateNamespace replaces the description of a namespace and, when given,
+// its metadata and defaults. Changed defaults apply to repositories created
+// afterwards.
+func (h *Handler) UpdateNamespace(w http.ResponseWriter, r *http.Request) {
+	ns, ok := h.namespace(w, mux.Vars(r)["name"])
+	if !ok {
+		return
+	}
+
+	var update models.Namespace
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if update.Name != "" && update.Name != ns.Name {
+		h.writeError(w, http.StatusBadRequest, "Namespace name cannot be changed")
+		return
+	}
+
+	ns.Description = update.Description
+	if update.Metadata != nil {
+		ns.Metadata = update.Metadata
+	}
+	if update.Defaults != nil {
+		ns.Defaults = update.Defaults
+	}
+	if err := validateNamespace(ns); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid namespace: %v", err))
+		return
+	}
+
+	if err := h.repoMgr.UpdateNamespace(ns); err != nil {
+		if err == repository.ErrNamespaceNotFound {
+			h.writeError(w, http.StatusNotFound, "Namespace not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to update namespace")
+		return
+	}
+
+	members, err := h.namespaceMembers()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list repositories")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newNamespaceView(ns, members[ns.Name]))
+}
+
+// DeleteNamespace deletes a namespace that no repository belongs to
+func (h *Handler) DeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	if err := h.repoMgr.DeleteNamespace(mux.Vars(r)["name"]); err != nil {
+		switch err {
+		case repository.ErrNamespaceNotFound:
+			h.writeError(w, http.StatusNotFound, "Namespace not found")
+		case repository.ErrNamespaceNotEmpty:
+			h.writeError(w, http.StatusConflict, "Namespace has repositories; move or delete them first")
+		default:
+			h.writeError(w, http.StatusInternalServerError, "Failed to delete namespace")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// namespace looks up a namespace, writing a 404 if there is none
+func (h *Handler) namespace(w http.ResponseWriter, name string) (*models.Namespace, bool) {
+	ns, err := h.repoMgr.GetNamespace(name)
+	if err != nil {
+		if err == repository.ErrNamespaceNotFound {
+			h.writeError(w, http.StatusNotFound, "Namespace not found")
+			return nil, false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get namespace")
+		return nil, false
+	}
+	return ns, true
+}
+
+// namespaceMembers returns the names of the repositories in each namespace
+func (h *Handler) namespaceMembers() (map[string][]string, error) {
+	repos, err := h.repoMgr.List()
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]string)
+	for _, repo := range repos {
+		if repo.Namespace != "" {
+			members[repo.Namespace] = append(members[repo.Namespace], repo.Name)
+		}
+	}
+	return members, nil
+}
+
+// repositoryNamespace checks that the namespace a repository is put in
+// exists, writing a 400 if not. A repository in no namespace has a nil
+// namespace.
+func (h *Handler) repositoryNamespace(w http.ResponseWriter, name string) (*models.Namespace, bool) {
+	if name == "" {
+		return nil, true
+	}
+	ns, err := h.repoMgr.GetNamespace(name)
+	if err != nil {
+		if err == repository.ErrNamespaceNotFound {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Namespace %s not found", name))
+			return nil, false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get namespace")
+		return nil, false
+	}
+	return ns, true
+}
+
+// validateNamespace checks the metadata of a namespace and that its
+// defaults are configuration of the repository type they are given for.
+// The settings they lead to are validated with the rest of a repository's
+// configuration when it is created.
+func validateNamespace(ns *models.Namespace) error {
+	for name := range ns.Metadata {
+		if !propertyNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid metadata name %q", name)
+		}
+	}
+	for repoType, defaults := range ns.Defaults {
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(defaults, &settings); err != nil || settings == nil {
+			return fmt.Errorf("defaults for %s repositories must be a JSON object", repoType)
+		}
+		switch repoType {
+		case models.RepositoryTypeRaw:
+			if err := validateRawConfig(defaults); err != nil {
+				return fmt.Errorf("invalid defaults for raw repositories: %v", err)
+			}
+			if stagingConfigChanged(nil, defaults) {
+				return fmt.Errorf("defaults for raw repositories cannot set staging")
+			}
+		case models.RepositoryTypeDocker:
+			var config models.DockerRepositoryConfig
+			if err := json.Unmarshal(defaults, &config); err != nil {
+				return fmt.Errorf("invalid defaults for docker repositories: %v", err)
+			}
+		case models.RepositoryTypeBuildCache:
+			if err := validateBuildCacheConfig(defaults); err != nil {
+				return fmt.Errorf("invalid defaults for build cache repositories: %v", err)
+			}
+		default:
+			return fmt.Errorf("defaults for unknown repository type %q", repoType)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a repository's configuration completed with the
+// settings of its namespace's defaults that it does not set itself
+func withDefaults(defaults, config json.RawMessage) (json.RawMessage, error) {
+	if len(defaults) == 0 {
+		return config, nil
+	}
+	merged := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(defaults, &merged); err != nil {
+		return nil, err
+	}
+	if len(config) > 0 {
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(config, &settings); err != nil {
+			return nil, err
+		}
+		for name, value := range settings {
+			merged[name] = value
+		}
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/notify"
+	"github.com/depot/depot/internal/repository"
+)
+
+// SetNotifier enables notification subscriptions and notifies subscribers
+// of uploads and of cleanup runs started through the API
+func (h *Handler) SetNotifier(notifier *notify.Manager) {
+	h.notifier = notifier
+	h.cleanupEngine.SetOnDeleted(notifier.CleanupDeleted)
+}
+
+// ListSubscriptions lists notification subscriptions, with ?repository=
+// only those covering that repository. Webhook URLs are redacted.
+func (h *Handler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.notifier.List(r.URL.Query().Get("repository"))
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list subscriptions")
+		return
+	}
+	for i, sub := range subs {
+		subs[i] = sub.Redacted()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+// CreateSubscription subscribes an email address or webhook to events of
+// one repository or, without a repository, of all of them
+func (h *Handler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var sub notify.Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if sub.Repository != "" {
+		if _, err := h.repoMgr.Get(sub.Repository); err != nil {
+			if err == repository.ErrRepositoryNotFound {
+				h.writeError(w, http.StatusBadRequest, "Repository not found")
+				return
+			}
+			h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+			return
+		}
+	}
+
+	if err := h.notifier.Subscribe(&sub); err != nil {
+		if errors.Is(err, notify.ErrInvalidSubscription) {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to save subscription")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub.Redacted())
+}
+
+// DeleteSubscription removes a notification subscription
+func (h *Handler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := h.notifier.Unsubscribe(mux.Vars(r)["id"]); err != nil {
+		if errors.Is(err, notify.ErrSubscriptionNotFound) {
+			h.writeError(w, http.StatusNotFound, "Subscription not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete subscription")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notifyPushed tells subscribers of raw artifacts stored by an upload
+func (h *Handler) notifyPushed(repo string, artifacts ...string) {
+	if h.notifier != nil {
+		h.notifier.ArtifactsPushed(repo, artifacts...)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+)
+
+// ImportOCILayout imports an OCI image layout, uploaded as a tar or tar.gz
+// archive, into an image of a Docker repository. ?tag= names the manifest
+// of a layout whose index.json does not.
+func (h *Handler) ImportOCILayout(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	report, err := h.dockerManager.ImportOCILayout(name, vars["image"], r.URL.Query().Get("tag"), r.Body)
+	if err != nil {
+		if errors.Is(err, docker.ErrReadOnly) {
+			h.writeError(w, http.StatusBadRequest, "Proxy repositories are read-only")
+			return
+		}
+		if errors.Is(err, docker.ErrInvalidLayout) {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to import layout: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// ExportOCILayout downloads an image of a Docker repository, by tag or
+// digest, as an OCI image layout tar archive
+func (h *Handler) ExportOCILayout(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	export, err := h.dockerManager.ExportOCILayout(name, vars["image"], vars["reference"])
+	if err != nil {
+		if errors.Is(err, docker.ErrManifestNotFound) {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("Image not found: %v", err))
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to export image: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(vars["image"])+".tar"))
+	if err := export.Write(w); err != nil {
+		// The archive is already on its way; all that can be done is to
+		// cut it short
+		h.logger.WithError(err).WithField("repository", name).Error("Failed to export OCI layout")
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+var errArtifactExists = errors.New("artifact already exists with different content")
+
+// overwriteAllowed reports whether an upload may replace an existing
+// artifact: the overwrite query parameter if given, else the repository
+// default. Content-addressed repositories never overwrite, as an upload to
+// an existing path can only be identical.
+func overwriteAllowed(r *http.Request, config *models.RawRepositoryConfig) (bool, error) {
+	if config.ContentAddressed {
+		return false, nil
+	}
+	value := r.URL.Query().Get("overwrite")
+	if value == "" {
+		return !config.DisableOverwrite, nil
+	}
+
+	allowed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid overwrite parameter %q", value)
+	}
+	return allowed, nil
+}
+
+// compareExisting consumes an upload to an existing artifact that may not
+// be overwritten. If the upload is byte-identical it returns the existing
+// artifact's metadata, so the upload can succeed without writing anything;
+// otherwise it returns errArtifactExists. A size of -1 means unknown.
+func (h *Handler) compareExisting(repo string, info *storage.FileInfo, data io.Reader, size int64, expected checksum.Expected) (*metadata.Artifact, error) {
+	if size >= 0 && size != info.Size {
+		return nil, errArtifactExists
+	}
+
+	existing, err := h.artifactMetadata(repo, info)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := checksum.NewReader(data, expected)
+	n, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		return nil, err
+	}
+	if n != info.Size || reader.Sums().SHA256 != existing.SHA256 {
+		return nil, errArtifactExists
+	}
+	return existing, nil
+}
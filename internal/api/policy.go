@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// uploadPolicy is the effective set of upload restrictions of a raw
+// repository, combining its own configuration with server-wide limits
+type uploadPolicy struct {
+	ContentTypes      []string `json:"content_types"`
+	AllowedExtensions []string `json:"allowed_extensions"`
+	MaxArtifactSize   int64    `json:"max_artifact_size,omitempty"`
+}
+
+// GetUploadPolicy reports which uploads a raw repository accepts
+func (h *Handler) GetUploadPolicy(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Upload policies")
+	if !ok {
+		return
+	}
+
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+
+	policy := uploadPolicy{
+		ContentTypes:      config.ContentTypes,
+		AllowedExtensions: config.AllowedExtensions,
+		MaxArtifactSize:   models.SizeLimit(h.maxUploadSize, config.MaxArtifactSize),
+	}
+	if policy.ContentTypes == nil {
+		policy.ContentTypes = []string{}
+	}
+	if policy.AllowedExtensions == nil {
+		policy.AllowedExtensions = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// checkUploadPolicy rejects an upload with 415 if the repository does not
+// accept its content type or file extension
+func (h *Handler) checkUploadPolicy(w http.ResponseWriter, repo *models.Repository, artifactPath, contentType string) bool {
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return false
+	}
+
+	if !config.AllowsPath(artifactPath) {
+		h.writeError(w, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("File extension not allowed; allowed extensions: %s", strings.Join(config.AllowedExtensions, ", ")))
+		return false
+	}
+	if !config.AllowsContentType(contentType) {
+		h.writeError(w, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Content type not allowed; allowed content types: %s", strings.Join(config.ContentTypes, ", ")))
+		return false
+	}
+	return true
+}
+
+func validateUploadPolicy(config *models.RawRepositoryConfig) error {
+	for _, contentType := range config.ContentTypes {
+		if !validContentTypePattern(contentType) {
+			return fmt.Errorf("invalid content type %q", contentType)
+		}
+	}
+	for _, ext := range config.AllowedExtensions {
+		if strings.Trim(ext, ". ") == "" || strings.ContainsAny(ext, "/*") {
+			return fmt.Errorf("invalid file extension %q", ext)
+		}
+	}
+	return nil
+}
+
+// validContentTypePattern accepts a media type without parameters, or a
+// wildcard such as "image/*" or "*/*"
+func validContentTypePattern(pattern string) bool {
+	if pattern == "*/*" {
+		return true
+	}
+	if major, ok := strings.CutSuffix(pattern, "/*"); ok {
+		pattern = major + "/x"
+	}
+	mediaType, params, err := mime.ParseMediaType(pattern)
+	return err == nil && len(params) == 0 && strings.Contains(mediaType, "/")
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/httpcache"
+	"github.com/depot/depot/internal/presign"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Lifetimes of signed URLs: the default, and the longest that may be asked for
+const (
+	defaultSignedURLTTL = time.Hour
+	maxSignedURLTTL     = 7 * 24 * time.Hour
+)
+
+type presignRequest struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path,omitempty"`
+	Image      string `json:"image,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	ExpiresIn  string `json:"expires_in,omitempty"`
+}
+
+type presignResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SetURLSigner enables signed download URLs
+func (h *Handler) SetURLSigner(signer *presign.Signer) {
+	h.signer = signer
+}
+
+// PresignURL creates a time-limited URL for downloading a raw artifact, or
+// a Docker blob given by image and digest, without further credentials
+func (h *Handler) PresignURL(w http.ResponseWriter, r *http.Request) {
+	if h.signer == nil {
+		h.writeError(w, http.StatusNotFound, "Signed URLs are not enabled")
+		return
+	}
+
+	var req presignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ttl := defaultSignedURLTTL
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = models.ParseTTL(req.ExpiresIn); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid expires_in: %v", err))
+			return
+		}
+		if ttl > maxSignedURLTTL {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in may be at most %s", maxSignedURLTTL))
+			return
+		}
+	}
+
+	repo, err := h.repoMgr.Get(req.Repository)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+
+	var urlPath string
+	switch repo.Type {
+	case models.RepositoryTypeRaw:
+		artifactPath, ok := cleanArtifactPath(req.Path)
+		if !ok || artifactPath == "" {
+			h.writeError(w, http.StatusBadRequest, "Invalid artifact path")
+			return
+		}
+		if _, err := h.statArtifact(repo.Name, artifactPath); err != nil {
+			h.writeError(w, http.StatusNotFound, "Artifact not found")
+			return
+		}
+		urlPath = fmt.Sprintf("/repository/%s/%s", repo.Name, artifactPath)
+	case models.RepositoryTypeDocker:
+		if req.Image == "" || req.Digest == "" {
+			h.writeError(w, http.StatusBadRequest, "Image and digest are required")
+			return
+		}
+		image, ok := cleanArtifactPath(req.Image)
+		if !ok || image == "" || strings.Contains(req.Digest, "/") {
+			h.writeError(w, http.StatusBadRequest, "Invalid image or digest")
+			return
+		}
+		if exists, err := h.storage.Exists(image, path.Join("blobs", req.Digest)); err != nil || !exists {
+			h.writeError(w, http.StatusNotFound, "Blob not found")
+			return
+		}
+		urlPath = fmt.Sprintf("/repository/%s/%s/blobs/%s", repo.Name, image, req.Digest)
+	default:
+		h.writeError(w, http.StatusBadRequest, "Unsupported repository type")
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presignResponse{
+		URL:       h.baseURL(r) + h.signer.Sign(urlPath, expiresAt),
+		ExpiresAt: expiresAt.UTC(),
+	})
+}
+
+// checkSignature enforces signed URLs on downloads. A download with an
+// invalid or expired signature is always refused with 403; an unsigned one
+// only if required is set.
+func (h *Handler) checkSignature(w http.ResponseWriter, r *http.Request, required bool) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+
+	err := presign.FromContext(r.Context())
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, presign.ErrNotSigned):
+		if !required {
+			return true
+		}
+		h.writeError(w, http.StatusForbidden, "A signed URL is required to download from this repository")
+	case errors.Is(err, presign.ErrExpired):
+		h.writeError(w, http.StatusForbidden, "Signed URL has expired")
+	default:
+		h.writeError(w, http.StatusForbidden, "Invalid URL signature")
+	}
+	return false
+}
+
+// serveSignedBlob serves a Docker blob through a signed URL of the form
+// /repository/{repo}/{image}/blobs/{digest}. It returns false if the path
+// is not a blob URL.
+func (h *Handler) serveSignedBlob(w http.ResponseWriter, r *http.Request, pathParts []string) bool {
+	n := len(pathParts)
+	if n < 6 || pathParts[n-2] != "blobs" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if !h.checkSignature(w, r, true) {
+		return true
+	}
+
+	image, digest := strings.Join(pathParts[3:n-2], "/"), pathParts[n-1]
+	blobPath := path.Join("blobs", digest)
+	info, err := h.storage.Stat(image, blobPath)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			h.writeError(w, http.StatusNotFound, "Blob not found")
+		} else {
+			h.writeError(w, http.StatusInternalServerError, "Failed to read blob")
+		}
+		return true
+	}
+	reader, err := h.storage.Retrieve(image, blobPath)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to read blob")
+		return true
+	}
+	defer reader.Close()
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", httpcache.ETag(digest))
+	httpcache.ServeContent(w, r, time.Time{}, reader, info.Size)
+	return true
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+)
+
+// propertyHeader sets an artifact property at upload time, as name=value.
+// It may be repeated.
+const propertyHeader = "X-Artifact-Property"
+
+var propertyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// GetProperties returns the properties of a raw artifact
+func (h *Handler) GetProperties(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Properties")
+	if !ok {
+		return
+	}
+
+	properties, err := h.metadata.GetProperties(repoName, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get properties")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get properties")
+		return
+	}
+
+	h.writeProperties(w, properties)
+}
+
+// SetProperties replaces the properties of a raw artifact
+func (h *Handler) SetProperties(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Properties")
+	if !ok {
+		return
+	}
+
+	var properties map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&properties); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateProperties(properties); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.saveProperties(w, r, repoName, artifactPath, properties)
+}
+
+// UpdateProperties merges properties into those of a raw artifact. A null
+// value removes a property.
+func (h *Handler) UpdateProperties(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Properties")
+	if !ok {
+		return
+	}
+
+	var changes map[string]*string
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	properties, err := h.metadata.GetProperties(repoName, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get properties")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get properties")
+		return
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(properties, name)
+		} else {
+			properties[name] = *value
+		}
+	}
+	if err := validateProperties(properties); err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.saveProperties(w, r, repoName, artifactPath, properties)
+}
+
+// DeleteProperties removes all properties of a raw artifact
+func (h *Handler) DeleteProperties(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Properties")
+	if !ok {
+		return
+	}
+
+	if err := h.metadata.SetProperties(repoName, artifactPath, nil); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to delete properties")
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete properties")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SearchArtifacts finds raw artifacts by property. Each property=name=value
+// parameter must match; repository and path (a glob) narrow the search.
+func (h *Handler) SearchArtifacts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := make(map[string]string)
+	for _, param := range query["property"] {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid property filter %q, expected name=value", param))
+			return
+		}
+		filter[name] = value
+	}
+	if len(filter) == 0 {
+		h.writeError(w, http.StatusBadRequest, "At least one property filter is required")
+		return
+	}
+
+	found, err := h.metadata.FindByProperties(query.Get("repository"), filter)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to search artifacts")
+		h.writeError(w, http.StatusInternalServerError, "Failed to search artifacts")
+		return
+	}
+
+	pattern := query.Get("path")
+	results := []*metadata.ArtifactProperties{}
+	for _, artifact := range found {
+		if pattern != "" && !glob.Match(pattern, artifact.Path) {
+			continue
+		}
+		// Skip artifacts removed without going through the API, such as by
+		// cleanup policies
+		if exists, err := h.storage.Exists(artifact.Repository, artifact.Path); err != nil || !exists {
+			continue
+		}
+		results = append(results, artifact)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// uploadProperties parses the properties set with X-Artifact-Property
+// headers on an upload
+func uploadProperties(r *http.Request) (map[string]string, error) {
+	properties := make(map[string]string)
+	for _, header := range r.Header.Values(propertyHeader) {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s header %q, expected name=value", propertyHeader, header)
+		}
+		properties[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return properties, validateProperties(properties)
+}
+
+func validateProperties(properties map[string]string) error {
+	for name := range properties {
+		if !propertyNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid property name %q", name)
+		}
+	}
+	return nil
+}
+
+// artifactTarget resolves the raw artifact named in the request path for a
+// feature of raw repositories, answering 404 if it does not exist
+func (h *Handler) artifactTarget(w http.ResponseWriter, r *http.Request, feature string) (string, string, bool) {
+	repo, ok := h.rawRepository(w, r, feature)
+	if !ok {
+		return "", "", false
+	}
+
+	artifactPath, ok := cleanArtifactPath(mux.Vars(r)["path"])
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, "Invalid artifact path")
+		return "", "", false
+	}
+
+	if _, err := h.storage.Stat(repo.Name, artifactPath); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			h.writeError(w, http.StatusNotFound, "Artifact not found")
+		} else {
+			h.writeError(w, http.StatusInternalServerError, "Failed to get artifact")
+		}
+		return "", "", false
+	}
+	return repo.Name, artifactPath, true
+}
+
+func (h *Handler) saveProperties(w http.ResponseWriter, r *http.Request, repoName, artifactPath string, properties map[string]string) {
+	if err := h.metadata.SetProperties(repoName, artifactPath, properties); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to save properties")
+		h.writeError(w, http.StatusInternalServerError, "Failed to save properties")
+		return
+	}
+	h.writeProperties(w, properties)
+}
+
+func (h *Handler) writeProperties(w http.ResponseWriter, properties map[string]string) {
+	if properties == nil {
+		properties = map[string]string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(properties)
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/provenance"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// maxProvenanceSize bounds provenance documents, which embed attestations
+const maxProvenanceSize = 4 << 20
+
+// GetProvenance returns the build provenance of a raw artifact
+func (h *Handler) GetProvenance(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Provenance")
+	if !ok {
+		return
+	}
+
+	p, err := h.metadata.GetProvenance(repoName, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get provenance")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get provenance")
+		return
+	}
+	if p == nil {
+		h.writeError(w, http.StatusNotFound, "Artifact has no provenance")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// SetProvenance attaches build provenance to a raw artifact, replacing any
+// attached before. An attestation must have the artifact as its subject.
+func (h *Handler) SetProvenance(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Provenance")
+	if !ok {
+		return
+	}
+
+	p, ok := h.decodeProvenance(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := h.storage.Stat(repoName, artifactPath)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to get artifact")
+		return
+	}
+	artifact, err := h.artifactMetadata(repoName, info)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to compute checksum")
+		h.writeError(w, http.StatusInternalServerError, "Failed to compute checksum")
+		return
+	}
+	if err := p.Complete("sha256:" + artifact.SHA256); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid provenance: %v", err))
+		return
+	}
+
+	if err := h.metadata.SetProvenance(repoName, artifactPath, p); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to save provenance")
+		h.writeError(w, http.StatusInternalServerError, "Failed to save provenance")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteProvenance removes the build provenance of a raw artifact
+func (h *Handler) DeleteProvenance(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Provenance")
+	if !ok {
+		return
+	}
+
+	if err := h.metadata.SetProvenance(repoName, artifactPath, nil); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to delete provenance")
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete provenance")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetImageProvenance lists the build provenance of an image: provenance
+// attached through the API and in-toto attestations pushed with it
+func (h *Handler) GetImageProvenance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.dockerRepository(w, vars["name"]) {
+		return
+	}
+
+	provenances, err := h.dockerManager.ImageProvenance(vars["name"], vars["image"], vars["reference"])
+	if err != nil {
+		if errors.Is(err, docker.ErrManifestNotFound) {
+			h.writeError(w, http.StatusNotFound, "Image not found")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to get provenance: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(provenances)
+}
+
+// AttachImageProvenance attaches build provenance to an image as an OCI
+// referrer of its manifest, so it is served by the referrers API and
+// promoted along with the image
+func (h *Handler) AttachImageProvenance(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.dockerRepository(w, vars["name"]) {
+		return
+	}
+
+	p, ok := h.decodeProvenance(w, r)
+	if !ok {
+		return
+	}
+
+	p, err := h.dockerManager.AttachProvenance(vars["name"], vars["image"], vars["reference"], p)
+	if err != nil {
+		switch {
+		case errors.Is(err, docker.ErrManifestNotFound):
+			h.writeError(w, http.StatusNotFound, "Image not found")
+		case errors.Is(err, docker.ErrInvalidProvenance):
+			h.writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to attach provenance: %v", err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// checkArtifactProvenance refuses the download of a raw artifact without
+// provenance satisfying the repository's provenance policy
+func (h *Handler) checkArtifactProvenance(w http.ResponseWriter, r *http.Request, repoName string, info *storage.FileInfo, policy *models.ProvenancePolicy) bool {
+	if policy == nil {
+		return true
+	}
+
+	artifact, err := h.artifactMetadata(repoName, info)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to compute checksum")
+		h.writeError(w, http.StatusInternalServerError, "Failed to compute checksum")
+		return false
+	}
+	p, err := h.metadata.GetProvenance(repoName, info.Path)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get provenance")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get provenance")
+		return false
+	}
+
+	if p == nil {
+		err = provenance.ErrNoProvenance
+	} else {
+		err = p.Check(policy, "sha256:"+artifact.SHA256)
+	}
+	if err != nil {
+		h.requestLogger(r).WithError(err).Warnf("Refused download of %s/%s without acceptable build provenance", repoName, info.Path)
+		h.writeError(w, http.StatusForbidden, fmt.Sprintf("Artifact has no acceptable build provenance: %v", err))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) decodeProvenance(w http.ResponseWriter, r *http.Request) (*provenance.Provenance, bool) {
+	var p provenance.Provenance
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProvenanceSize)).Decode(&p); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	if p.Builder == "" && p.SourceRepository == "" && p.Commit == "" && p.WorkflowRunURL == "" && len(p.Attestation) == 0 {
+		h.writeError(w, http.StatusBadRequest, "Provenance must describe the build or include an attestation")
+		return nil, false
+	}
+	// The time is recorded when the provenance is attached
+	p.CreatedAt = time.Now().UTC()
+	return &p, true
+}
+
+// dockerRepository checks that the named repository exists and is a
+// Docker repository
+func (h *Handler) dockerRepository(w http.ResponseWriter, name string) bool {
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return false
+	}
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a Docker repository")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/pkg/models"
+)
+
+// WarmProxy fetches into a Docker proxy repository the images its
+// configuration lists to warm
+func (h *Handler) WarmProxy(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	report, err := h.dockerManager.WarmProxy(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, docker.ErrNotProxy) {
+			h.writeError(w, http.StatusBadRequest, "Repository is not a proxy repository")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to warm repository: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// EvictProxyCache evicts the least recently pulled images of a Docker
+// proxy repository, or the least recently used entries of a build cache
+// repository, until its cache fits its maximum size
+func (h *Handler) EvictProxyCache(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if repo, err := h.repoMgr.Get(name); err == nil && repo.Type == models.RepositoryTypeBuildCache {
+		h.evictBuildCache(w, repo)
+		return
+	}
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	report, err := h.dockerManager.EvictProxyCache(name)
+	if err != nil {
+		if errors.Is(err, docker.ErrNotProxy) {
+			h.writeError(w, http.StatusBadRequest, "Repository is not a proxy repository")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to evict from repository: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
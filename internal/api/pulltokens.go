@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Lifetimes of pull tokens: the default, and the longest that may be asked for
+const (
+	defaultPullTokenTTL = 15 * time.Minute
+	maxPullTokenTTL     = 24 * time.Hour
+)
+
+type pullTokenRequest struct {
+	Image     string `json:"image"`
+	Tag       string `json:"tag,omitempty"`
+	SingleUse bool   `json:"single_use,omitempty"`
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// CreatePullToken mints a short-lived token to pull one image, or one tag
+// of it, from a Docker repository, for automation that should not hold
+// real credentials. Anyone who can reach the API can mint one, so tokens
+// only protect a repository whose API is closed to its pullers.
+func (h *Handler) CreatePullToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !h.dockerRepository(w, vars["name"]) {
+		return
+	}
+
+	var req pullTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	image, ok := cleanArtifactPath(req.Image)
+	if !ok || image == "" || strings.ContainsAny(req.Tag, "/:@") {
+		h.writeError(w, http.StatusBadRequest, "Invalid image or tag")
+		return
+	}
+
+	ttl := defaultPullTokenTTL
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = models.ParseTTL(req.ExpiresIn); err != nil {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid expires_in: %v", err))
+			return
+		}
+		if ttl > maxPullTokenTTL {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("expires_in may be at most %s", maxPullTokenTTL))
+			return
+		}
+	}
+
+	token, err := h.dockerManager.MintPullToken(vars["name"], image, req.Tag, req.SingleUse, time.Now().Add(ttl))
+	if err != nil {
+		if errors.Is(err, docker.ErrManifestNotFound) {
+			h.writeError(w, http.StatusNotFound, "Image not found")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to create pull token: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
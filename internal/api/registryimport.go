@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/tasks"
+)
+
+// ImportRegistry starts a task copying the images of an existing Docker
+// distribution registry, through its API or from its data directory, into
+// a Docker repository
+func (h *Handler) ImportRegistry(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	var source docker.RegistryImport
+	if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	job, err := h.dockerManager.PrepareRegistryImport(name, &source)
+	if err != nil {
+		if errors.Is(err, docker.ErrReadOnly) {
+			h.writeError(w, http.StatusBadRequest, "Proxy repositories are read-only")
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task, err := h.taskManager.Submit("registry-import", name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+		return job.Run(ctx, run.SetProgress)
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to submit registry import task")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
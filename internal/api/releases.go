@@ -0,0 +1,302 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/releases"
+)
+
+// latestRelease is the version that names a project's latest release
+const latestRelease = "latest"
+
+type releaseRequest struct {
+	Title      string `json:"title"`
+	Notes      string `json:"notes"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// releaseAsset is an artifact of a release
+type releaseAsset struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256,omitempty"`
+	URL      string    `json:"url"`
+}
+
+// releaseInfo is a release of a project: the artifacts stored below
+// <project>/<version>/ with the notes recorded for it. PublishedAt is the
+// time the newest artifact was stored.
+type releaseInfo struct {
+	Project     string         `json:"project"`
+	Version     string         `json:"version"`
+	Title       string         `json:"title,omitempty"`
+	Notes       string         `json:"notes,omitempty"`
+	Prerelease  bool           `json:"prerelease"`
+	PublishedAt *time.Time     `json:"published_at,omitempty"`
+	Assets      []releaseAsset `json:"assets"`
+}
+
+// releaseProject summarizes the releases of a project, newest first
+type releaseProject struct {
+	Project  string   `json:"project"`
+	Latest   string   `json:"latest,omitempty"`
+	Versions []string `json:"versions"`
+}
+
+// ListReleaseProjects lists the projects with releases in a raw repository,
+// each with its versions and latest release
+func (h *Handler) ListReleaseProjects(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok {
+		return
+	}
+
+	all, err := h.collectReleases(r, repo.Name, "")
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list releases")
+		return
+	}
+	projects := []*releaseProject{}
+	for _, release := range all {
+		if len(projects) == 0 || projects[len(projects)-1].Project != release.Project {
+			projects = append(projects, &releaseProject{Project: release.Project, Versions: []string{}})
+		}
+		project := projects[len(projects)-1]
+		project.Versions = append(project.Versions, release.Version)
+		if project.Latest == "" && !release.Prerelease && len(release.Assets) > 0 {
+			project.Latest = release.Version
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+// ListReleases lists the releases of a project, newest first
+func (h *Handler) ListReleases(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok {
+		return
+	}
+	project := mux.Vars(r)["project"]
+	if !validReleaseSegment(project) {
+		h.writeError(w, http.StatusBadRequest, "Invalid project name")
+		return
+	}
+
+	list, err := h.collectReleases(r, repo.Name, project)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list releases")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetRelease returns a release of a project by version, or its latest
+// release: the newest version with artifacts that is not a prerelease
+func (h *Handler) GetRelease(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok {
+		return
+	}
+
+	release, ok := h.findRelease(w, r, repo.Name)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(release)
+}
+
+// DownloadLatestAsset redirects to an artifact of a project's latest
+// release, giving tools a stable URL for the newest build
+func (h *Handler) DownloadLatestAsset(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok {
+		return
+	}
+
+	release, ok := h.findRelease(w, r, repo.Name)
+	if !ok {
+		return
+	}
+	asset := mux.Vars(r)["asset"]
+	for _, a := range release.Assets {
+		if a.Name == asset {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.Redirect(w, r, h.location(fmt.Sprintf("/repository/%s/%s", repo.Name, a.Path)), http.StatusFound)
+			return
+		}
+	}
+	h.writeError(w, http.StatusNotFound, fmt.Sprintf("Release %s has no asset %s", release.Version, asset))
+}
+
+// PutRelease records the title, notes and prerelease flag of a release. The
+// artifacts of a release are uploaded to <project>/<version>/ as usual,
+// before or after its notes are recorded.
+func (h *Handler) PutRelease(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return
+	}
+	vars := mux.Vars(r)
+	project, version := vars["project"], vars["version"]
+	if !validReleaseSegment(project) || !validReleaseSegment(version) || version == latestRelease {
+		h.writeError(w, http.StatusBadRequest, "Invalid project or version")
+		return
+	}
+
+	var req releaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	release := &metadata.Release{
+		Repository: repo.Name,
+		Project:    project,
+		Version:    version,
+		Title:      req.Title,
+		Notes:      req.Notes,
+		Prerelease: req.Prerelease,
+	}
+	if err := h.metadata.PutRelease(release); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to store release")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(release)
+}
+
+// DeleteRelease removes the notes recorded for a release. Its artifacts
+// are deleted like any other.
+func (h *Handler) DeleteRelease(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Releases")
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return
+	}
+	vars := mux.Vars(r)
+
+	if err := h.metadata.DeleteRelease(repo.Name, vars["project"], vars["version"]); err != nil {
+		if errors.Is(err, metadata.ErrReleaseNotFound) {
+			h.writeError(w, http.StatusNotFound, "Release not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete release")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findRelease looks up the release a request names by project and version,
+// answering the request if there is none
+func (h *Handler) findRelease(w http.ResponseWriter, r *http.Request, repoName string) (*releaseInfo, bool) {
+	vars := mux.Vars(r)
+	project, version := vars["project"], vars["version"]
+	if !validReleaseSegment(project) || !validReleaseSegment(version) {
+		h.writeError(w, http.StatusBadRequest, "Invalid project or version")
+		return nil, false
+	}
+
+	list, err := h.collectReleases(r, repoName, project)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list releases")
+		return nil, false
+	}
+	for _, release := range list {
+		if release.Version == version || (version == latestRelease && !release.Prerelease && len(release.Assets) > 0) {
+			return release, true
+		}
+	}
+	h.writeError(w, http.StatusNotFound, "Release not found")
+	return nil, false
+}
+
+// collectReleases groups the artifacts of a repository, or of one project
+// if given, into releases by their <project>/<version>/ directories and
+// adds the releases recorded without artifacts yet. Releases are ordered by
+// project, then newest version first.
+func (h *Handler) collectReleases(r *http.Request, repoName, project string) ([]*releaseInfo, error) {
+	files, err := h.storage.List(repoName, project)
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := h.metadata.ListReleases(repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*releaseInfo)
+	release := func(project, version string) *releaseInfo {
+		k := project + "/" + version
+		if byKey[k] == nil {
+			byKey[k] = &releaseInfo{Project: project, Version: version, Prerelease: releases.IsPrerelease(version), Assets: []releaseAsset{}}
+		}
+		return byKey[k]
+	}
+
+	base := h.baseURL(r)
+	for _, file := range files {
+		segments := strings.SplitN(file.Path, "/", 3)
+		if len(segments) < 3 || !validReleaseSegment(segments[0]) || !validReleaseSegment(segments[1]) || segments[1] == latestRelease {
+			continue
+		}
+		info := release(segments[0], segments[1])
+		asset := releaseAsset{
+			Name:     segments[2],
+			Path:     file.Path,
+			Size:     file.Size,
+			Modified: file.ModTime,
+			URL:      fmt.Sprintf("%s/repository/%s/%s", base, repoName, file.Path),
+		}
+		if artifact, err := h.metadata.Get(repoName, file.Path); err == nil && artifact.Matches(file.Size, file.ModTime) {
+			asset.SHA256 = artifact.SHA256
+		}
+		info.Assets = append(info.Assets, asset)
+		if info.PublishedAt == nil || file.ModTime.After(*info.PublishedAt) {
+			modified := file.ModTime
+			info.PublishedAt = &modified
+		}
+	}
+	for _, notes := range recorded {
+		if project != "" && notes.Project != project {
+			continue
+		}
+		info := release(notes.Project, notes.Version)
+		info.Title, info.Notes = notes.Title, notes.Notes
+		info.Prerelease = info.Prerelease || notes.Prerelease
+	}
+
+	list := make([]*releaseInfo, 0, len(byKey))
+	for _, info := range byKey {
+		sort.Slice(info.Assets, func(i, j int) bool { return info.Assets[i].Name < info.Assets[j].Name })
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Project != list[j].Project {
+			return list[i].Project < list[j].Project
+		}
+		return releases.Compare(list[i].Version, list[j].Version) > 0
+	})
+	return list, nil
+}
+
+// validReleaseSegment reports whether a project name or version can be a
+// single directory of a raw repository
+func validReleaseSegment(s string) bool {
+	return s != "" && !strings.HasPrefix(s, ".") && !strings.ContainsAny(s, "/\\")
+}
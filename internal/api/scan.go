@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/scan"
+)
+
+// scanHeader reports the virus scan verdict of a downloaded artifact
+const scanHeader = "X-Artifact-Scan"
+
+// SetScanner enables virus scanning of raw uploads
+func (h *Handler) SetScanner(scanner *scan.Manager) {
+	h.scanner = scanner
+}
+
+// storeUpload stores a raw artifact uploaded by a client. Validation hooks
+// see the content first and may refuse it. If scanning is enabled the
+// content is then scanned, and an infected upload is quarantined instead of
+// stored.
+func (h *Handler) storeUpload(repo, artifactPath string, data io.Reader, expected checksum.Expected) (*metadata.Artifact, error) {
+	if h.validator != nil {
+		accepted, err := h.validator.Check(repo, artifactPath, data)
+		if err != nil {
+			return nil, err
+		}
+		defer accepted.Close()
+		data = accepted
+	}
+
+	if h.scanner == nil {
+		return h.storeArtifact(repo, artifactPath, data, expected)
+	}
+
+	clean, result, err := h.scanner.Check(repo, artifactPath, data)
+	if err != nil {
+		return nil, err
+	}
+	defer clean.Close()
+
+	return h.writeArtifact(repo, artifactPath, clean, expected, result)
+}
+
+// writeScanError answers an upload rejected by the virus scanner. It
+// returns false if err is not a scan failure.
+func (h *Handler) writeScanError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var infected *scan.InfectedError
+	switch {
+	case errors.As(err, &infected):
+		h.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Upload rejected: %s is infected with %s", infected.Item.Path, infected.Item.Signature))
+	case errors.Is(err, scan.ErrUnavailable):
+		h.requestLogger(r).WithError(err).Error("Virus scan failed")
+		h.writeError(w, http.StatusServiceUnavailable, "Virus scanner unavailable, try again later")
+	default:
+		return false
+	}
+	return true
+}
+
+// ListQuarantine lists uploads rejected as infected, optionally filtered by
+// ?repository=
+func (h *Handler) ListQuarantine(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		h.writeError(w, http.StatusNotFound, "Virus scanning is not enabled")
+		return
+	}
+
+	items, err := h.scanner.List(r.URL.Query().Get("repository"))
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to list quarantine")
+		h.writeError(w, http.StatusInternalServerError, "Failed to list quarantine")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// DeleteQuarantine permanently removes a quarantined upload
+func (h *Handler) DeleteQuarantine(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		h.writeError(w, http.StatusNotFound, "Virus scanning is not enabled")
+		return
+	}
+
+	err := h.scanner.Delete(mux.Vars(r)["id"])
+	if errors.Is(err, scan.ErrItemNotFound) {
+		h.writeError(w, http.StatusNotFound, "Quarantine item not found")
+		return
+	}
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to delete quarantine item")
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete quarantine item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/scheduler"
+)
+
+func (h *Handler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.scheduler.List()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list schedules")
+		return
+	}
+
+	repo := r.URL.Query().Get("repository")
+	result := []*scheduler.Entry{}
+	for _, entry := range entries {
+		if repo != "" && entry.Repository != repo {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	entry, err := h.scheduler.Get(vars["name"])
+	if err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (h *Handler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var entry scheduler.Entry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !h.checkScheduleRepository(w, &entry) {
+		return
+	}
+
+	if err := h.scheduler.Create(&entry); err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	created, err := h.scheduler.Get(entry.Name)
+	if err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func (h *Handler) UpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var entry scheduler.Entry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	entry.Name = vars["name"]
+
+	if !h.checkScheduleRepository(w, &entry) {
+		return
+	}
+
+	if err := h.scheduler.Update(&entry); err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	updated, err := h.scheduler.Get(entry.Name)
+	if err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *Handler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.scheduler.Delete(vars["name"]); err != nil {
+		h.writeScheduleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkScheduleRepository verifies that a repository-scoped schedule refers
+// to an existing repository
+func (h *Handler) checkScheduleRepository(w http.ResponseWriter, entry *scheduler.Entry) bool {
+	if entry.Repository == "" {
+		return true
+	}
+
+	if _, err := h.repoMgr.Get(entry.Repository); err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusBadRequest, "Repository not found")
+			return false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) writeScheduleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrScheduleNotFound):
+		h.writeError(w, http.StatusNotFound, "Schedule not found")
+	case errors.Is(err, scheduler.ErrScheduleExists):
+		h.writeError(w, http.StatusConflict, "Schedule already exists")
+	case errors.Is(err, scheduler.ErrScheduleBuiltin):
+		h.writeError(w, http.StatusForbidden, "Built-in schedules are managed by server configuration")
+	case errors.Is(err, scheduler.ErrUnknownTaskType):
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("%v (supported: %v)", err, h.scheduler.TaskTypes()))
+	case errors.Is(err, scheduler.ErrInvalidSchedule):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		h.writeError(w, http.StatusInternalServerError, "Failed to manage schedule")
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/signing"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// GetSignature returns the signature the server made for a raw artifact,
+// with the key version that made it
+func (h *Handler) GetSignature(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Signatures")
+	if !ok {
+		return
+	}
+
+	info, err := h.storage.Stat(repoName, artifactPath)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to get artifact")
+		return
+	}
+	sig, err := h.metadata.GetSignature(repoName, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get signature")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get signature")
+		return
+	}
+	if sig == nil || !sig.Matches(info.Size, info.ModTime) {
+		h.writeError(w, http.StatusNotFound, "Artifact is not signed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sig)
+}
+
+// SignArtifact signs a raw artifact now, with the key given as the key
+// parameter or else the repository's signing key, replacing any signature
+// made before
+func (h *Handler) SignArtifact(w http.ResponseWriter, r *http.Request) {
+	repoName, artifactPath, ok := h.artifactTarget(w, r, "Signatures")
+	if !ok {
+		return
+	}
+
+	keyName := r.URL.Query().Get("key")
+	if keyName == "" {
+		repo, err := h.repoMgr.Get(repoName)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+			return
+		}
+		config, err := rawConfig(repo)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+			return
+		}
+		keyName = config.SigningKey
+	}
+	if keyName == "" {
+		h.writeError(w, http.StatusBadRequest, "A key parameter is required, as the repository has no signing key")
+		return
+	}
+
+	info, err := h.storage.Stat(repoName, artifactPath)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to get artifact")
+		return
+	}
+	sig, err := h.signArtifact(repoName, info, keyName)
+	if err != nil {
+		if errors.Is(err, signing.ErrKeyNotFound) {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Signing key %s not found", keyName))
+			return
+		}
+		h.requestLogger(r).WithError(err).Errorf("Failed to sign %s/%s", repoName, artifactPath)
+		h.writeError(w, http.StatusInternalServerError, "Failed to sign artifact")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sig)
+}
+
+// serveSignature answers a request for <artifact>.asc or <artifact>.sig
+// with the detached signature of the artifact: .asc for GPG keys, .sig for
+// RSA and cosign keys. Artifacts of repositories with a signing key that
+// were not signed yet with its active version, or changed since, are
+// signed on demand, except during maintenance. It returns false if the
+// path is not a signature of a stored artifact.
+func (h *Handler) serveSignature(w http.ResponseWriter, r *http.Request, repo, artifactPath, signingKey string) bool {
+	ext := path.Ext(artifactPath)
+	if ext != ".asc" && ext != ".sig" {
+		return false
+	}
+
+	info, err := h.statArtifact(repo, strings.TrimSuffix(artifactPath, ext))
+	if err != nil {
+		return false
+	}
+
+	sig, err := h.metadata.GetSignature(repo, info.Path)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get signature")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get signature")
+		return true
+	}
+	// A signature by another key, or another version of it, is made again
+	// with the key the repository signs with now
+	if sig != nil && signingKey != "" && h.signingKeys != nil {
+		if key, err := h.signingKeys.Active(signingKey); err == nil && (sig.Key != key.Name || sig.Fingerprint != key.Fingerprint) {
+			sig = nil
+		}
+	}
+	if sig == nil || !sig.Matches(info.Size, info.ModTime) {
+		if signingKey == "" {
+			return false
+		}
+		// Nothing is signed on demand while maintenance keeps storage as it is
+		if h.maintenance != nil {
+			if state := h.maintenance.Get(); state != nil {
+				h.writeError(w, http.StatusServiceUnavailable, state.Describe())
+				return true
+			}
+		}
+		sig, err = h.signArtifact(repo, info, signingKey)
+		if errors.Is(err, signing.ErrKeyNotFound) {
+			return false
+		}
+		if err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to sign %s/%s", repo, info.Path)
+			h.writeError(w, http.StatusInternalServerError, "Failed to sign artifact")
+			return true
+		}
+	}
+	if signing.SignatureExtension(sig.KeyType) != ext {
+		return false
+	}
+
+	contentType := "text/plain"
+	if ext == ".asc" {
+		contentType = "application/pgp-signature"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(sig.Signature)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, sig.Signature)
+	}
+	return true
+}
+
+// signUpload signs a new upload with the repository's signing key. A
+// failure does not fail the upload; the signature is made on demand when
+// it is first requested instead.
+func (h *Handler) signUpload(logger *logrus.Entry, repo string, config *models.RawRepositoryConfig, artifactPath string) {
+	if config.SigningKey == "" {
+		return
+	}
+	info, err := h.storage.Stat(repo, artifactPath)
+	if err == nil {
+		_, err = h.signArtifact(repo, info, config.SigningKey)
+	}
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to sign %s/%s", repo, artifactPath)
+	}
+}
+
+// signArtifact signs the stored content of an artifact with the active
+// version of a key and records the signature
+func (h *Handler) signArtifact(repo string, info *storage.FileInfo, keyName string) (*metadata.Signature, error) {
+	if h.signingKeys == nil {
+		return nil, signing.ErrKeyNotFound
+	}
+
+	reader, err := h.storage.Retrieve(repo, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	signature, key, err := h.signingKeys.SignReader(keyName, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &metadata.Signature{
+		Repository:  repo,
+		Path:        info.Path,
+		Size:        info.Size,
+		ModTime:     info.ModTime,
+		Key:         key.Name,
+		KeyVersion:  key.Version,
+		KeyType:     key.Type,
+		Fingerprint: key.Fingerprint,
+		Signature:   signature,
+		SignedAt:    time.Now().UTC(),
+	}
+	if err := h.metadata.PutSignature(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// checkSigningKey checks that the signing key a raw repository
+// configuration names exists
+func (h *Handler) checkSigningKey(w http.ResponseWriter, data json.RawMessage) bool {
+	var config models.RawRepositoryConfig
+	if err := json.Unmarshal(data, &config); err != nil || config.SigningKey == "" {
+		return true
+	}
+	if h.signingKeys != nil {
+		if _, err := h.signingKeys.Active(config.SigningKey); err == nil {
+			return true
+		}
+	}
+	h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid raw repository configuration: signing key %s not found", config.SigningKey))
+	return false
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/signing"
+)
+
+// SetSigningKeys sets the keys metadata and artifacts are signed with
+func (h *Handler) SetSigningKeys(keys *signing.Manager) {
+	h.signingKeys = keys
+}
+
+// ListSigningKeys lists the current version of every signing key
+func (h *Handler) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.signingKeys.List()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list signing keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateSigningKey generates a signing key, or imports the PEM-encoded
+// private key given as private_key. Private keys are never returned.
+func (h *Handler) CreateSigningKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		signing.KeyRequest
+		PrivateKey string `json:"private_key,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var key *signing.Key
+	var err error
+	if req.PrivateKey != "" {
+		key, err = h.signingKeys.Import(req.KeyRequest, []byte(req.PrivateKey))
+	} else {
+		key, err = h.signingKeys.Generate(req.KeyRequest)
+	}
+	if err != nil {
+		h.writeSigningKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// GetSigningKey returns every version of a signing key, newest first
+func (h *Handler) GetSigningKey(w http.ResponseWriter, r *http.Request) {
+	versions, err := h.signingKeys.Versions(mux.Vars(r)["name"])
+	if err != nil {
+		h.writeSigningKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// RotateSigningKey replaces a signing key with a new version, retiring
+// the current one
+func (h *Handler) RotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.signingKeys.Rotate(mux.Vars(r)["name"])
+	if err != nil {
+		h.writeSigningKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// DeleteSigningKey removes every version of a signing key
+func (h *Handler) DeleteSigningKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.signingKeys.Delete(mux.Vars(r)["name"]); err != nil {
+		h.writeSigningKeyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PublicSigningKey serves the public keys of every version of a signing
+// key at /keys/{name}, for clients to import
+func (h *Handler) PublicSigningKey(w http.ResponseWriter, r *http.Request) {
+	contentType, data, err := h.signingKeys.PublicKeys(mux.Vars(r)["name"])
+	if err != nil {
+		h.writeSigningKeyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+func (h *Handler) writeSigningKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, signing.ErrKeyNotFound):
+		h.writeError(w, http.StatusNotFound, "Signing key not found")
+	case errors.Is(err, signing.ErrKeyExists):
+		h.writeError(w, http.StatusConflict, "Signing key already exists; rotate it to replace it")
+	case errors.Is(err, signing.ErrInvalidKey):
+		h.writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		h.writeError(w, http.StatusInternalServerError, "Failed to manage signing key")
+	}
+}
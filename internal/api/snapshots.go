@@ -0,0 +1,454 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/snapshots"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/tasks"
+	"github.com/depot/depot/internal/trash"
+	"github.com/depot/depot/pkg/models"
+)
+
+// currentSnapshot names the live state of a repository in diffs
+const currentSnapshot = "current"
+
+// SnapshotRequest takes a snapshot of a repository
+type SnapshotRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// SnapshotRestore reports what restoring a snapshot of a raw repository
+// changed. Missing artifacts have no content left in any repository or
+// trash to restore them from, and skipped ones are immutable.
+type SnapshotRestore struct {
+	Snapshot string   `json:"snapshot"`
+	Restored []string `json:"restored"`
+	Deleted  []string `json:"deleted"`
+	Aliases  []string `json:"aliases"`
+	Missing  []string `json:"missing"`
+	Skipped  []string `json:"skipped"`
+}
+
+// SetSnapshots sets the store keeping repository snapshots
+func (h *Handler) SetSnapshots(store *snapshots.Store) {
+	h.snapshots = store
+}
+
+// ListSnapshots lists the snapshots of a repository, oldest first
+func (h *Handler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.snapshotRepository(w, r)
+	if !ok {
+		return
+	}
+
+	summaries, err := h.snapshots.List(repo.Name)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to list snapshots of %s", repo.Name)
+		h.writeError(w, http.StatusInternalServerError, "Failed to list snapshots")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// CreateSnapshot records the tags or artifacts and aliases a repository
+// holds now under a name
+func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.snapshotRepository(w, r)
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return
+	}
+
+	var req SnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		req.Name = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	snapshot, err := h.captureSnapshot(repo)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to capture snapshot of %s", repo.Name)
+		h.writeError(w, http.StatusInternalServerError, "Failed to capture snapshot")
+		return
+	}
+	snapshot.Name = req.Name
+	snapshot.Description = req.Description
+
+	if err := h.snapshots.Save(snapshot); err != nil {
+		h.writeSnapshotError(w, r, err, snapshot.Name)
+		return
+	}
+	h.requestLogger(r).WithField("repository", repo.Name).Infof("Snapshot %s taken", snapshot.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(snapshot.Summary())
+}
+
+// GetSnapshot returns a snapshot with everything it recorded
+func (h *Handler) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, ok := h.namedSnapshot(w, r, mux.Vars(r)["snapshot"])
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// DeleteSnapshot removes a snapshot. The repository is left as it is.
+func (h *Handler) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.snapshots.Delete(vars["name"], vars["snapshot"]); err != nil {
+		h.writeSnapshotError(w, r, err, vars["snapshot"])
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DiffSnapshot lists what changed from a snapshot to the one named by the
+// to parameter, or to the repository as it is now
+func (h *Handler) DiffSnapshot(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.snapshotRepository(w, r)
+	if !ok {
+		return
+	}
+	diff, ok := h.diffSnapshots(w, r, repo, mux.Vars(r)["snapshot"], r.URL.Query().Get("to"))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// diffSnapshots compares two snapshots of a repository, an empty or
+// "current" to being the repository as it is now
+func (h *Handler) diffSnapshots(w http.ResponseWriter, r *http.Request, repo *models.Repository, fromName, toName string) (*snapshots.Diff, bool) {
+	from, ok := h.namedSnapshot(w, r, fromName)
+	if !ok {
+		return nil, false
+	}
+
+	if toName != "" && toName != currentSnapshot {
+		to, ok := h.namedSnapshot(w, r, toName)
+		if !ok {
+			return nil, false
+		}
+		return snapshots.Compare(from, to), true
+	}
+
+	current, err := h.captureSnapshot(repo)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to capture current state of %s", repo.Name)
+		h.writeError(w, http.StatusInternalServerError, "Failed to capture current state")
+		return nil, false
+	}
+	return snapshots.Compare(from, current), true
+}
+
+// RestoreSnapshot starts a task bringing a raw repository back to a
+// snapshot: artifacts added or changed since are restored from content
+// with the same checksum still held anywhere in the server, artifacts
+// added since are deleted, and aliases are set as they were
+func (h *Handler) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Snapshot restores")
+	if !ok || !h.repositoryEnabled(w, repo) || h.repositoryArchived(w, repo.Name) {
+		return
+	}
+	snapshot, ok := h.namedSnapshot(w, r, mux.Vars(r)["snapshot"])
+	if !ok {
+		return
+	}
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+
+	task, err := h.taskManager.Submit("snapshot-restore", repo.Name, func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+		return h.restoreSnapshot(ctx, run, repo, config, snapshot)
+	})
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to submit snapshot restore task")
+		return
+	}
+	h.requestLogger(r).WithField("repository", repo.Name).Infof("Restoring snapshot %s", snapshot.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
+func (h *Handler) restoreSnapshot(ctx context.Context, run *tasks.Run, repo *models.Repository, config *models.RawRepositoryConfig, snapshot *snapshots.Snapshot) (*SnapshotRestore, error) {
+	current, err := h.captureSnapshot(repo)
+	if err != nil {
+		return nil, err
+	}
+	current.Name = currentSnapshot
+	diff := snapshots.Compare(current, snapshot)
+
+	result := &SnapshotRestore{
+		Snapshot: snapshot.Name,
+		Restored: []string{},
+		Deleted:  []string{},
+		Aliases:  []string{},
+		Missing:  []string{},
+		Skipped:  []string{},
+	}
+	total := int64(len(diff.Added) + len(diff.Removed) + len(diff.Changed))
+	var completed int64
+	step := func() {
+		completed++
+		run.SetProgress(completed, total)
+	}
+	sources, err := h.contentSources()
+	if err != nil {
+		return nil, err
+	}
+
+	// Content is restored before anything is deleted, so an artifact moved
+	// since the snapshot can still be found at its new path
+	for _, changes := range [][]snapshots.Change{diff.Added, diff.Changed} {
+		for _, change := range changes {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			switch {
+			case change.Kind == snapshots.KindAlias:
+				alias := &metadata.Alias{Repository: repo.Name, Path: change.Name, Target: change.To}
+				if err := h.metadata.PutAlias(alias); err != nil {
+					return nil, fmt.Errorf("failed to restore alias %s: %w", change.Name, err)
+				}
+				result.Aliases = append(result.Aliases, change.Name)
+			case change.From != "" && isImmutable(config, change.Name):
+				result.Skipped = append(result.Skipped, change.Name)
+			default:
+				restored, err := h.restoreContent(sources, repo.Name, change.Name, change.To)
+				if err != nil {
+					return nil, fmt.Errorf("failed to restore %s: %w", change.Name, err)
+				}
+				if restored {
+					result.Restored = append(result.Restored, change.Name)
+				} else {
+					result.Missing = append(result.Missing, change.Name)
+				}
+			}
+			step()
+		}
+	}
+
+	for _, change := range diff.Removed {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch {
+		case change.Kind == snapshots.KindAlias:
+			if err := h.metadata.DeleteAlias(repo.Name, change.Name); err != nil && !errors.Is(err, metadata.ErrAliasNotFound) {
+				return nil, fmt.Errorf("failed to remove alias %s: %w", change.Name, err)
+			}
+			result.Aliases = append(result.Aliases, change.Name)
+		case isImmutable(config, change.Name):
+			result.Skipped = append(result.Skipped, change.Name)
+		default:
+			if err := h.removeArtifact(repo.Name, change.Name, config); err != nil {
+				return nil, fmt.Errorf("failed to delete %s: %w", change.Name, err)
+			}
+			result.Deleted = append(result.Deleted, change.Name)
+		}
+		step()
+	}
+
+	h.logger.WithField("repository", repo.Name).Infof("Restored snapshot %s: %d artifacts restored, %d deleted, %d missing",
+		snapshot.Name, len(result.Restored), len(result.Deleted), len(result.Missing))
+	return result, nil
+}
+
+// contentSources indexes the stored and trashed content a restore can copy
+// by SHA-256
+type contentSources struct {
+	artifacts map[string][]*metadata.Artifact
+	trashed   map[string][]*trash.Item
+}
+
+// contentSources indexes every artifact and trash item once, rather than
+// searching the artifacts for each change a restore makes
+func (h *Handler) contentSources() (*contentSources, error) {
+	sources := &contentSources{
+		artifacts: make(map[string][]*metadata.Artifact),
+		trashed:   make(map[string][]*trash.Item),
+	}
+	err := h.metadata.ForEach(func(artifact *metadata.Artifact) error {
+		if sum := artifact.Get("sha256"); sum != "" {
+			sources.artifacts[sum] = append(sources.artifacts[sum], artifact)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := h.trash.List("")
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.SHA256 != "" {
+			sources.trashed[item.SHA256] = append(sources.trashed[item.SHA256], item)
+		}
+	}
+	return sources, nil
+}
+
+// restoreContent writes an artifact from a stored copy of the content with
+// the given SHA-256, or from a trashed one, reporting false if there is
+// none left. Trashed copies stay in the trash.
+func (h *Handler) restoreContent(sources *contentSources, repo, artifactPath, sha256 string) (bool, error) {
+	for _, candidate := range sources.artifacts[sha256] {
+		info, err := h.storage.Stat(candidate.Repository, candidate.Path)
+		if err != nil || !candidate.Matches(info.Size, info.ModTime) {
+			continue
+		}
+		file, err := h.storage.Retrieve(candidate.Repository, candidate.Path)
+		if err != nil {
+			continue
+		}
+		_, err = h.writeArtifact(repo, artifactPath, file, checksum.Expected{"sha256": sha256}, nil)
+		file.Close()
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	for _, item := range sources.trashed[sha256] {
+		file, err := h.trash.Open(item.ID)
+		if err != nil {
+			continue
+		}
+		_, err = h.writeArtifact(repo, artifactPath, file, checksum.Expected{"sha256": sha256}, nil)
+		file.Close()
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// removeArtifact deletes an artifact, moving it to the trash if the
+// repository keeps one
+func (h *Handler) removeArtifact(repo, artifactPath string, config *models.RawRepositoryConfig) error {
+	if config.TrashRetentionDays == 0 {
+		return h.deleteArtifact(repo, artifactPath)
+	}
+	if _, err := h.trash.Trash(repo, artifactPath, config.TrashRetention()); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return h.metadata.Delete(repo, artifactPath)
+}
+
+// captureSnapshot records what a repository holds now: the tags of a Docker
+// repository, or the artifacts and aliases of a raw one
+func (h *Handler) captureSnapshot(repo *models.Repository) (*snapshots.Snapshot, error) {
+	snapshot := &snapshots.Snapshot{
+		Repository: repo.Name,
+		Name:       currentSnapshot,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if repo.Type == models.RepositoryTypeDocker {
+		tags, err := h.dockerManager.ListTags(repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Tags = make([]snapshots.Tag, 0, len(tags))
+		for _, tag := range tags {
+			snapshot.Tags = append(snapshot.Tags, snapshots.Tag{Image: tag.Image, Tag: tag.Tag, Digest: tag.Digest})
+		}
+		return snapshot, nil
+	}
+
+	files, err := h.storage.List(repo.Name, "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Artifacts = make([]snapshots.Artifact, 0, len(files))
+	for i := range files {
+		artifact, err := h.artifactMetadata(repo.Name, &files[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to checksum %s: %w", files[i].Path, err)
+		}
+		snapshot.Artifacts = append(snapshot.Artifacts, snapshots.Artifact{
+			Path:     files[i].Path,
+			Size:     files[i].Size,
+			SHA256:   artifact.SHA256,
+			Modified: files[i].ModTime,
+		})
+	}
+
+	aliases, err := h.metadata.ListAliases(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Aliases = make(map[string]string, len(aliases))
+	for _, alias := range aliases {
+		snapshot.Aliases[alias.Path] = alias.Target
+	}
+	return snapshot, nil
+}
+
+// snapshotRepository returns the repository named in the request path if
+// it is one snapshots can be taken of
+func (h *Handler) snapshotRepository(w http.ResponseWriter, r *http.Request) (*models.Repository, bool) {
+	repo, ok := h.lookupRepository(w, r)
+	if !ok {
+		return nil, false
+	}
+	if repo.Type != models.RepositoryTypeRaw && repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Snapshots are only supported for raw and Docker repositories")
+		return nil, false
+	}
+	return repo, true
+}
+
+// namedSnapshot returns a snapshot of the repository named in the request
+// path
+func (h *Handler) namedSnapshot(w http.ResponseWriter, r *http.Request, name string) (*snapshots.Snapshot, bool) {
+	snapshot, err := h.snapshots.Get(mux.Vars(r)["name"], name)
+	if err != nil {
+		h.writeSnapshotError(w, r, err, name)
+		return nil, false
+	}
+	return snapshot, true
+}
+
+func (h *Handler) writeSnapshotError(w http.ResponseWriter, r *http.Request, err error, name string) {
+	switch {
+	case errors.Is(err, snapshots.ErrNotFound):
+		h.writeError(w, http.StatusNotFound, fmt.Sprintf("Snapshot %s not found", name))
+	case errors.Is(err, snapshots.ErrExists):
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Snapshot %s already exists", name))
+	case errors.Is(err, snapshots.ErrInvalidName):
+		h.writeError(w, http.StatusBadRequest, "Snapshot names must start with a letter or digit and contain only letters, digits, '.', '_' and '-'")
+	default:
+		h.requestLogger(r).WithError(err).Error("Snapshot operation failed")
+		h.writeError(w, http.StatusInternalServerError, "Failed to access snapshots")
+	}
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/staging"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// maxStagingFileSize bounds the checksum, signature and POM files read to
+// validate a staging repository
+const maxStagingFileSize = 1 << 20
+
+// CreateStaging creates an open staging repository for a raw release
+// repository. It is named after the release repository with a sequence
+// number, inherits its upload policy and accepts uploads like any raw
+// repository until it is closed.
+func (h *Handler) CreateStaging(w http.ResponseWriter, r *http.Request) {
+	release, ok := h.rawRepository(w, r, "Staging repositories")
+	if !ok {
+		return
+	}
+	config, err := rawConfig(release)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	if config.Staging != nil {
+		h.writeError(w, http.StatusBadRequest, "Repository is itself a staging repository")
+		return
+	}
+
+	var req struct {
+		Description string `json:"description"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			h.writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	stagingConfig, _ := json.Marshal(models.RawRepositoryConfig{
+		ContentTypes:      config.ContentTypes,
+		AllowedExtensions: config.AllowedExtensions,
+		MaxArtifactSize:   config.MaxArtifactSize,
+		Staging: &models.Staging{
+			Release:   release.Name,
+			State:     models.StagingOpen,
+			CreatedAt: time.Now().UTC(),
+		},
+	})
+	repo := &models.Repository{
+		Type:        models.RepositoryTypeRaw,
+		Namespace:   release.Namespace,
+		Description: req.Description,
+		Config:      stagingConfig,
+	}
+	for n := 1; ; n++ {
+		repo.Name = fmt.Sprintf("%s-staging-%d", release.Name, n)
+		err = h.repoMgr.Create(repo)
+		if err != repository.ErrRepositoryExists {
+			break
+		}
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to create staging repository")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(repo)
+}
+
+// ListStaging lists the staging repositories of a raw release repository
+func (h *Handler) ListStaging(w http.ResponseWriter, r *http.Request) {
+	release, ok := h.rawRepository(w, r, "Staging repositories")
+	if !ok {
+		return
+	}
+
+	repos, err := h.repoMgr.List()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list repositories")
+		return
+	}
+	stagingRepos := []*models.Repository{}
+	for _, repo := range repos {
+		if repo.Type != models.RepositoryTypeRaw {
+			continue
+		}
+		if config, err := rawConfig(repo); err == nil && config.Staging != nil && config.Staging.Release == release.Name {
+			stagingRepos = append(stagingRepos, repo)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stagingRepos)
+}
+
+// CloseStaging validates an open staging repository against its release
+// repository's staging rules and closes it to further uploads. A staging
+// repository that fails validation stays open and the problems are
+// returned with 422.
+func (h *Handler) CloseStaging(w http.ResponseWriter, r *http.Request) {
+	h.stagingMu.Lock()
+	defer h.stagingMu.Unlock()
+
+	repo, config, ok := h.stagingRepository(w, r)
+	if !ok {
+		return
+	}
+	if config.Staging.State != models.StagingOpen {
+		h.writeError(w, http.StatusConflict, "Staging repository is already closed")
+		return
+	}
+
+	var rules *models.StagingRules
+	if release, err := h.repoMgr.Get(config.Staging.Release); err == nil {
+		if releaseConfig, err := rawConfig(release); err == nil {
+			rules = releaseConfig.StagingRules
+		}
+	}
+
+	files, err := h.storage.List(repo.Name, "")
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list staged artifacts")
+		return
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	if problems := staging.Validate(paths, &stagedContent{h: h, repo: repo.Name}, rules); len(problems) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "Staging repository failed validation",
+			"problems": problems,
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	config.Staging.State = models.StagingClosed
+	config.Staging.ClosedAt = &now
+	repo.Config, _ = json.Marshal(config)
+	if err := h.repoMgr.Update(repo); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to update repository")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(repo)
+}
+
+// PromoteStaging copies every artifact of a closed staging repository into
+// its release repository and drops the staging repository. Nothing is
+// copied if any artifact may not be written to the release repository, and
+// partial copies are rolled back.
+func (h *Handler) PromoteStaging(w http.ResponseWriter, r *http.Request) {
+	h.stagingMu.Lock()
+	defer h.stagingMu.Unlock()
+
+	repo, config, ok := h.stagingRepository(w, r)
+	if !ok {
+		return
+	}
+	if config.Staging.State != models.StagingClosed {
+		h.writeError(w, http.StatusConflict, "Staging repository must be closed before it is promoted")
+		return
+	}
+	release, err := h.repoMgr.Get(config.Staging.Release)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("Release repository %s not found", config.Staging.Release))
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	if !h.repositoryEnabled(w, release) {
+		return
+	}
+	releaseConfig, err := rawConfig(release)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+
+	files, err := h.storage.List(repo.Name, "")
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list staged artifacts")
+		return
+	}
+
+	// Check every target up front so a promotion is never half done
+	for _, file := range files {
+		if !h.checkOverwrite(w, release, file.Path) {
+			return
+		}
+		if !releaseConfig.DisableOverwrite {
+			continue
+		}
+		existing, err := h.storage.Stat(release.Name, file.Path)
+		if err != nil {
+			continue
+		}
+		same, err := h.sameContent(repo.Name, file.Path, release.Name, existing)
+		if err != nil {
+			h.writeError(w, http.StatusInternalServerError, "Failed to check artifact")
+			return
+		}
+		if !same {
+			h.writeError(w, http.StatusConflict, "An artifact with different content already exists at "+file.Path)
+			return
+		}
+	}
+
+	promoted := make([]string, 0, len(files))
+	var created []string // rolled back on failure; replaced artifacts are not
+	for _, file := range files {
+		_, statErr := h.storage.Stat(release.Name, file.Path)
+		if err := h.copyArtifact(repo.Name, file.Path, release.Name, file.Path); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to promote %s/%s to %s", repo.Name, file.Path, release.Name)
+			for _, p := range created {
+				h.deleteArtifact(release.Name, p)
+			}
+			h.writeError(w, http.StatusInternalServerError, "Failed to promote artifact")
+			return
+		}
+		promoted = append(promoted, file.Path)
+		if errors.Is(statErr, storage.ErrNotFound) {
+			created = append(created, file.Path)
+		}
+	}
+
+	if err := h.dropStaging(r, repo.Name); err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to drop promoted staging repository %s", repo.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transferResponse{
+		Source:      repo.Name,
+		Destination: release.Name,
+		Artifacts:   promoted,
+	})
+}
+
+// DropStaging deletes a staging repository and everything uploaded to it,
+// whether it is open or closed
+func (h *Handler) DropStaging(w http.ResponseWriter, r *http.Request) {
+	h.stagingMu.Lock()
+	defer h.stagingMu.Unlock()
+
+	repo, _, ok := h.stagingRepository(w, r)
+	if !ok {
+		return
+	}
+	if err := h.dropStaging(r, repo.Name); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete staging repository")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dropStaging removes a staging repository with its artifacts and their
+// metadata
+func (h *Handler) dropStaging(r *http.Request, name string) error {
+	files, err := h.storage.List(name, "")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := h.storage.Delete(name, file.Path); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to delete staged artifact %s/%s", name, file.Path)
+		}
+	}
+	if err := h.repoMgr.Delete(name); err != nil {
+		return err
+	}
+	if err := h.metadata.DeleteRepository(name); err != nil {
+		h.requestLogger(r).WithError(err).Errorf("Failed to remove artifact metadata for %s", name)
+	}
+	return nil
+}
+
+// stagingRepository returns the staging repository named in the request
+func (h *Handler) stagingRepository(w http.ResponseWriter, r *http.Request) (*models.Repository, *models.RawRepositoryConfig, bool) {
+	repo, err := h.repoMgr.Get(mux.Vars(r)["name"])
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return nil, nil, false
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return nil, nil, false
+	}
+	config, err := rawConfig(repo)
+	if repo.Type != models.RepositoryTypeRaw || err != nil || config.Staging == nil {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a staging repository")
+		return nil, nil, false
+	}
+	return repo, config, true
+}
+
+// checkStagingOpen rejects a write with 409 if the repository is a closed
+// staging repository
+func (h *Handler) checkStagingOpen(w http.ResponseWriter, config *models.RawRepositoryConfig) bool {
+	if err := stagingClosed(config); err != nil {
+		h.writeError(w, err.status, err.message)
+		return false
+	}
+	return true
+}
+
+// stagingClosed refuses a write to a closed staging repository
+func stagingClosed(config *models.RawRepositoryConfig) *writeRefusal {
+	if config.Staging != nil && config.Staging.State != models.StagingOpen {
+		return &writeRefusal{http.StatusConflict, "Staging repository is closed"}
+	}
+	return nil
+}
+
+// sameContent reports whether an artifact matches an existing one in
+// another repository
+func (h *Handler) sameContent(srcRepo, srcPath, dstRepo string, existing *storage.FileInfo) (bool, error) {
+	source, err := h.storage.Stat(srcRepo, srcPath)
+	if err != nil {
+		return false, err
+	}
+	if source.Size != existing.Size {
+		return false, nil
+	}
+	sourceMetadata, err := h.artifactMetadata(srcRepo, source)
+	if err != nil {
+		return false, err
+	}
+	existingMetadata, err := h.artifactMetadata(dstRepo, existing)
+	if err != nil {
+		return false, err
+	}
+	return sourceMetadata.SHA256 == existingMetadata.SHA256, nil
+}
+
+// stagedContent reads the files of a staging repository for validation
+type stagedContent struct {
+	h    *Handler
+	repo string
+}
+
+func (c *stagedContent) Read(artifactPath string) ([]byte, error) {
+	reader, err := c.h.storage.Retrieve(c.repo, artifactPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxStagingFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxStagingFileSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxStagingFileSize)
+	}
+	return data, nil
+}
+
+func (c *stagedContent) Sums(artifactPath string) (checksum.Sums, error) {
+	info, err := c.h.storage.Stat(c.repo, artifactPath)
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	artifact, err := c.h.artifactMetadata(c.repo, info)
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	return artifact.Sums, nil
+}
+
+// stagingConfigChanged reports whether an update would change the staging
+// state of a raw repository, which only the staging API may do
+func stagingConfigChanged(current, updated json.RawMessage) bool {
+	var before, after models.RawRepositoryConfig
+	json.Unmarshal(current, &before)
+	json.Unmarshal(updated, &after)
+	return !reflect.DeepEqual(before.Staging, after.Staging)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// storageClassHeader names the storage class of an upload, like the
+// storage_class query parameter, and of a downloaded artifact
+const storageClassHeader = "X-Storage-Class"
+
+// uploadStorageClass returns the storage class an upload asked for, else
+// the repository's default class
+func uploadStorageClass(r *http.Request, config *models.RawRepositoryConfig) (string, error) {
+	class := r.Header.Get(storageClassHeader)
+	if class == "" {
+		class = r.URL.Query().Get("storage_class")
+	}
+	class = strings.ToLower(strings.TrimSpace(class))
+	if class == "" {
+		class = config.StorageClass
+	}
+	if class == "" {
+		return models.StorageClassStandard, nil
+	}
+	if !models.ValidStorageClass(class) {
+		return "", fmt.Errorf("unknown storage class %q, expected one of %s", class, strings.Join(models.StorageClassNames, ", "))
+	}
+	return class, nil
+}
+
+// validateStorageClasses checks the default storage class of a raw
+// repository and the classes it configures
+func validateStorageClasses(config *models.RawRepositoryConfig) error {
+	if config.StorageClass != "" && !models.ValidStorageClass(config.StorageClass) {
+		return fmt.Errorf("unknown storage_class %q", config.StorageClass)
+	}
+	for class, classConfig := range config.StorageClasses {
+		if !models.ValidStorageClass(class) {
+			return fmt.Errorf("unknown storage class %q in storage_classes", class)
+		}
+		if classConfig.TTL == "" {
+			continue
+		}
+		if _, err := models.ParseTTL(classConfig.TTL); err != nil {
+			return fmt.Errorf("storage class %s: %w", class, err)
+		}
+	}
+	return nil
+}
+
+// setStorageClass records the storage class of a newly stored artifact,
+// replacing that of any artifact it overwrote. The standard class is not
+// recorded.
+func (h *Handler) setStorageClass(logger *logrus.Entry, repoName, artifactPath, class string) {
+	if class == models.StorageClassStandard {
+		class = ""
+	}
+	if err := h.metadata.SetStorageClass(repoName, artifactPath, class); err != nil {
+		logger.WithError(err).Warnf("Failed to record storage class for %s/%s", repoName, artifactPath)
+	}
+}
+
+// setStorageClassHeader tells a client the storage class of a downloaded
+// artifact uploaded with a class other than standard
+func (h *Handler) setStorageClassHeader(w http.ResponseWriter, repoName, artifactPath string) {
+	if class, err := h.metadata.GetStorageClass(repoName, artifactPath); err == nil && class != "" {
+		w.Header().Set(storageClassHeader, class)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+)
+
+// GetImageSync returns the images a Docker repository syncs from other
+// registries. Passwords are redacted.
+func (h *Handler) GetImageSync(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	config, err := h.dockerManager.SyncConfig(name)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to read sync configuration")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config.Redacted())
+}
+
+// SetImageSync replaces the images a Docker repository syncs from other
+// registries. Redacted passwords keep their current value.
+func (h *Handler) SetImageSync(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	var config docker.SyncConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := docker.ValidateSyncConfig(&config); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid sync configuration: %v", err))
+		return
+	}
+	current, err := h.dockerManager.SyncConfig(name)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to read sync configuration")
+		return
+	}
+	config.RestoreSecrets(current)
+	if config.Images == nil {
+		config.Images = []docker.SyncImage{}
+	}
+	if err := h.dockerManager.SetSyncConfig(name, &config); err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to store sync configuration")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config.Redacted())
+}
+
+// RunImageSync copies the images a Docker repository syncs from other
+// registries into it
+func (h *Handler) RunImageSync(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.dockerRepository(w, name) {
+		return
+	}
+
+	report, err := h.dockerManager.SyncImages(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, docker.ErrReadOnly) {
+			h.writeError(w, http.StatusBadRequest, "Proxy repositories are read-only")
+			return
+		}
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to sync images: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
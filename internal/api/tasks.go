@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/tasks"
+)
+
+func (h *Handler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	all, err := h.taskManager.List()
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list tasks")
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	taskType := query.Get("type")
+	repo := query.Get("repository")
+
+	result := []*tasks.Task{}
+	for _, task := range all {
+		if status != "" && string(task.Status) != status {
+			continue
+		}
+		if taskType != "" && task.Type != taskType {
+			continue
+		}
+		if repo != "" && task.Repository != repo {
+			continue
+		}
+		result = append(result, task)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	task, err := h.taskManager.Get(vars["id"])
+	if err != nil {
+		if err == tasks.ErrTaskNotFound {
+			h.writeError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get task")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+func (h *Handler) CancelTask(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if err := h.taskManager.Cancel(vars["id"]); err != nil {
+		switch err {
+		case tasks.ErrTaskNotFound:
+			h.writeError(w, http.StatusNotFound, "Task not found")
+		case tasks.ErrTaskNotRunning:
+			h.writeError(w, http.StatusConflict, "Task is not running")
+		default:
+			h.writeError(w, http.StatusInternalServerError, "Failed to cancel task")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/forwarded"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+type transferRequest struct {
+	Source          string `json:"source"`
+	SourcePath      string `json:"source_path"`
+	Destination     string `json:"destination"`
+	DestinationPath string `json:"destination_path"`
+}
+
+type transferResponse struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Artifacts   []string `json:"artifacts"`
+}
+
+func (h *Handler) CopyArtifacts(w http.ResponseWriter, r *http.Request) {
+	h.transferArtifacts(w, r, false)
+}
+
+func (h *Handler) MoveArtifacts(w http.ResponseWriter, r *http.Request) {
+	h.transferArtifacts(w, r, true)
+}
+
+// transferArtifacts copies a single raw artifact, or every artifact below a
+// path prefix, into another raw repository. A move deletes the sources only
+// once every copy has succeeded.
+func (h *Handler) transferArtifacts(w http.ResponseWriter, r *http.Request, move bool) {
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Source == "" || req.Destination == "" {
+		h.writeError(w, http.StatusBadRequest, "Source and destination repositories are required")
+		return
+	}
+
+	sourcePath, ok := cleanArtifactPath(req.SourcePath)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, "Invalid source path")
+		return
+	}
+	destinationPath, ok := cleanArtifactPath(req.DestinationPath)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, "Invalid destination path")
+		return
+	}
+
+	if req.Source == req.Destination && sourcePath == destinationPath {
+		h.writeError(w, http.StatusBadRequest, "Source and destination are identical")
+		return
+	}
+
+	repos := make(map[string]*models.Repository)
+	for _, name := range []string{req.Source, req.Destination} {
+		repo, err := h.repoMgr.Get(name)
+		if err != nil {
+			if err == repository.ErrRepositoryNotFound {
+				h.writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %s not found", name))
+				return
+			}
+			h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+			return
+		}
+		if repo.Type != models.RepositoryTypeRaw {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Repository %s is not a raw repository", name))
+			return
+		}
+		if !h.repositoryEnabled(w, repo) {
+			return
+		}
+		repos[name] = repo
+	}
+	if h.repositoryArchived(w, req.Destination) || (move && h.repositoryArchived(w, req.Source)) {
+		return
+	}
+
+	files, err := h.storage.List(req.Source, sourcePath)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list source artifacts")
+		return
+	}
+	if len(files) == 0 {
+		h.writeError(w, http.StatusNotFound, "Artifact not found")
+		return
+	}
+
+	// Check immutable paths up front so a transfer is never half done
+	for _, file := range files {
+		if move && !h.checkDelete(w, repos[req.Source], file.Path) {
+			return
+		}
+		if !h.checkOverwrite(w, repos[req.Destination], transferTarget(file.Path, sourcePath, destinationPath)) {
+			return
+		}
+	}
+
+	copied := make([]string, 0, len(files))
+	var created []string
+	for _, file := range files {
+		target := transferTarget(file.Path, sourcePath, destinationPath)
+		if _, err := h.storage.Stat(req.Destination, target); errors.Is(err, storage.ErrNotFound) {
+			created = append(created, target)
+		}
+
+		if err := h.copyArtifact(req.Source, file.Path, req.Destination, target); err != nil {
+			h.requestLogger(r).WithError(err).Errorf("Failed to copy %s/%s to %s/%s", req.Source, file.Path, req.Destination, target)
+			// Roll back the artifacts the transfer created. Those it
+			// overwrote cannot be put back, and are not deleted either.
+			for _, p := range created {
+				h.deleteArtifact(req.Destination, p)
+			}
+			h.writeError(w, http.StatusInternalServerError, "Failed to copy artifact")
+			return
+		}
+		copied = append(copied, target)
+	}
+
+	if move {
+		for _, file := range files {
+			if err := h.deleteArtifact(req.Source, file.Path); err != nil {
+				h.requestLogger(r).WithError(err).Errorf("Failed to remove moved artifact %s/%s", req.Source, file.Path)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transferResponse{
+		Source:      req.Source,
+		Destination: req.Destination,
+		Artifacts:   copied,
+	})
+}
+
+func (h *Handler) copyArtifact(srcRepo, srcPath, dstRepo, dstPath string) error {
+	reader, err := h.storage.Retrieve(srcRepo, srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if _, err := h.storeArtifact(dstRepo, dstPath, reader, nil); err != nil {
+		return err
+	}
+
+	properties, err := h.metadata.GetProperties(srcRepo, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := h.metadata.SetProperties(dstRepo, dstPath, properties); err != nil {
+		return err
+	}
+
+	// The content is identical, so its provenance still applies
+	p, err := h.metadata.GetProvenance(srcRepo, srcPath)
+	if err != nil {
+		return err
+	}
+	if err := h.metadata.SetProvenance(dstRepo, dstPath, p); err != nil {
+		return err
+	}
+
+	expiresAt, err := h.metadata.GetExpiry(srcRepo, srcPath)
+	if err != nil {
+		return err
+	}
+	return h.metadata.SetExpiry(dstRepo, dstPath, expiresAt)
+}
+
+func (h *Handler) PromoteImage(w http.ResponseWriter, r *http.Request) {
+	var req docker.PromoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Source == "" || req.Target == "" || req.Image == "" || (req.Reference == "" && req.Digest == "") {
+		h.writeError(w, http.StatusBadRequest, "Source, target, image and reference or digest are required")
+		return
+	}
+
+	for _, name := range []string{req.Source, req.Target} {
+		repo, err := h.repoMgr.Get(name)
+		if err != nil {
+			if err == repository.ErrRepositoryNotFound {
+				h.writeError(w, http.StatusNotFound, fmt.Sprintf("Repository %s not found", name))
+				return
+			}
+			h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+			return
+		}
+		if repo.Type != models.RepositoryTypeDocker {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("Repository %s is not a Docker repository", name))
+			return
+		}
+		if !h.repositoryEnabled(w, repo) {
+			return
+		}
+	}
+
+	result, err := h.dockerManager.PromoteImage(&req)
+	if err != nil {
+		switch {
+		case errors.Is(err, docker.ErrPromotionDenied):
+			h.writeError(w, http.StatusForbidden, fmt.Sprintf("Failed to promote image: %v", err))
+		case errors.Is(err, docker.ErrDigestMismatch):
+			h.writeError(w, http.StatusPreconditionFailed, fmt.Sprintf("Failed to promote image: %v", err))
+		default:
+			h.writeError(w, http.StatusConflict, fmt.Sprintf("Failed to promote image: %v", err))
+		}
+		return
+	}
+
+	// Without authentication the client address stands in for the user
+	promotedBy := req.PromotedBy
+	if promotedBy == "" {
+		promotedBy = forwarded.ClientIP(r)
+	}
+	reference := req.Reference
+	if reference == "" {
+		reference = req.Digest
+	}
+	targetImage := req.TargetImage
+	if targetImage == "" {
+		targetImage = req.Image
+	}
+	targetTag := req.TargetTag
+	if targetTag == "" && !strings.HasPrefix(reference, "sha256:") {
+		targetTag = reference
+	}
+	promotion := &metadata.Promotion{
+		Source:      req.Source,
+		Target:      req.Target,
+		Image:       req.Image,
+		Reference:   reference,
+		TargetImage: targetImage,
+		TargetTag:   targetTag,
+		Digest:      result.Digest,
+		PromotedBy:  promotedBy,
+		Signed:      result.Signed,
+		Scanned:     result.Scanned,
+	}
+	if err := h.metadata.RecordPromotion(promotion); err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to record promotion")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promotion)
+}
+
+// ListPromotions lists the images promoted into a Docker repository, oldest
+// first. ?image= limits the list to one image.
+func (h *Handler) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	repo, err := h.repoMgr.Get(name)
+	if err != nil {
+		if err == repository.ErrRepositoryNotFound {
+			h.writeError(w, http.StatusNotFound, "Repository not found")
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, "Failed to get repository")
+		return
+	}
+	if repo.Type != models.RepositoryTypeDocker {
+		h.writeError(w, http.StatusBadRequest, "Repository is not a Docker repository")
+		return
+	}
+
+	promotions, err := h.metadata.ListPromotions(name, r.URL.Query().Get("image"))
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Failed to list promotions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promotions)
+}
+
+// transferTarget maps a source artifact path below sourcePath to its path
+// below destinationPath
+func transferTarget(artifactPath, sourcePath, destinationPath string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(artifactPath, sourcePath), "/")
+	return path.Join(destinationPath, rel)
+}
+
+// cleanArtifactPath normalizes a repository-relative path and rejects paths
+// that would escape the repository root.
+func cleanArtifactPath(p string) (string, bool) {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	return strings.Trim(path.Clean("/"+p), "/"), true
+}
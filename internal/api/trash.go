@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/trash"
+)
+
+// ListTrash lists the deleted artifacts of a raw repository that can still
+// be restored
+func (h *Handler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Trash")
+	if !ok {
+		return
+	}
+
+	items, err := h.trash.List(repo.Name)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to list trash")
+		h.writeError(w, http.StatusInternalServerError, "Failed to list trash")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// RestoreTrash moves a deleted artifact back to its original path. It is
+// refused if the path has since been reused.
+func (h *Handler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	item, ok := h.trashItem(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.storage.Stat(item.Repository, item.Path); !errors.Is(err, storage.ErrNotFound) {
+		h.writeError(w, http.StatusConflict, "An artifact already exists at "+item.Path)
+		return
+	}
+	if _, err := h.metadata.GetAlias(item.Repository, item.Path); err == nil {
+		h.writeError(w, http.StatusConflict, "An alias already exists at "+item.Path)
+		return
+	}
+
+	item, err := h.trash.Restore(item.ID, func(item *trash.Item, data io.Reader) error {
+		_, err := h.storeArtifact(item.Repository, item.Path, data, nil)
+		return err
+	})
+	if err != nil {
+		h.writeTrashError(w, r, err, "Failed to restore artifact")
+		return
+	}
+
+	w.Header().Set("Location", h.location(fmt.Sprintf("/repository/%s/%s", item.Repository, item.Path)))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// DeleteTrash permanently removes an item from the trash
+func (h *Handler) DeleteTrash(w http.ResponseWriter, r *http.Request) {
+	item, ok := h.trashItem(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.trash.Delete(item.ID); err != nil {
+		h.writeTrashError(w, r, err, "Failed to delete trash item")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trashArtifact answers a DELETE in a repository with a trash by moving
+// the artifact there instead of removing it
+func (h *Handler) trashArtifact(w http.ResponseWriter, r *http.Request, repoName, artifactPath string, retention time.Duration) {
+	item, err := h.trash.Trash(repoName, artifactPath, retention)
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to move artifact to trash")
+		h.writeError(w, http.StatusInternalServerError, "Failed to delete artifact")
+		return
+	}
+
+	if err := h.metadata.Delete(repoName, artifactPath); err != nil {
+		h.requestLogger(r).WithError(err).Warnf("Failed to remove metadata for %s/%s", repoName, artifactPath)
+	}
+
+	w.Header().Set("X-Trash-Id", item.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trashItem looks up the trash item named in the request path, which must
+// belong to the repository in the path
+func (h *Handler) trashItem(w http.ResponseWriter, r *http.Request) (*trash.Item, bool) {
+	repo, ok := h.rawRepository(w, r, "Trash")
+	if !ok {
+		return nil, false
+	}
+
+	item, err := h.trash.Get(mux.Vars(r)["id"])
+	if err == nil && item.Repository != repo.Name {
+		err = trash.ErrItemNotFound
+	}
+	if err != nil {
+		h.writeTrashError(w, r, err, "Failed to get trash item")
+		return nil, false
+	}
+	return item, true
+}
+
+func (h *Handler) writeTrashError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, trash.ErrItemNotFound) {
+		h.writeError(w, http.StatusNotFound, "Trash item not found")
+		return
+	}
+	h.requestLogger(r).WithError(err).Error(message)
+	h.writeError(w, http.StatusInternalServerError, message)
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/disconnect"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/scan"
+	"github.com/depot/depot/internal/uploads"
+	"github.com/depot/depot/internal/validation"
+	"github.com/depot/depot/pkg/models"
+)
+
+// uploadOffsetHeader carries the number of bytes a session has received,
+// following the tus.io convention
+const uploadOffsetHeader = "Upload-Offset"
+
+type createUploadRequest struct {
+	Path        string `json:"path"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// uploadResult identifies the artifact stored by an upload, so clients
+// need no follow-up request to learn its checksums or digest URL
+type uploadResult struct {
+	Repository   string        `json:"repository"`
+	Path         string        `json:"path"`
+	Size         int64         `json:"size"`
+	Checksums    checksum.Sums `json:"checksums"`
+	Verified     []string      `json:"verified,omitempty"`
+	ImmutableURL string        `json:"immutable_url,omitempty"`
+}
+
+// CreateUpload starts a resumable upload session for a raw artifact
+func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.rawRepository(w, r, "Resumable uploads")
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return
+	}
+
+	var req createUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	artifactPath, ok := cleanArtifactPath(req.Path)
+	if !ok || artifactPath == "" {
+		h.writeError(w, http.StatusBadRequest, "Invalid artifact path")
+		return
+	}
+
+	if !h.checkUploadPolicy(w, repo, artifactPath, req.ContentType) || !h.checkOverwrite(w, repo, artifactPath) {
+		return
+	}
+
+	session, err := h.uploads.Create(repo.Name, artifactPath)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to create upload session")
+		h.writeError(w, http.StatusInternalServerError, "Failed to create upload session")
+		return
+	}
+
+	w.Header().Set("Location", h.location(fmt.Sprintf("/api/v1/repositories/%s/uploads/%s", repo.Name, session.ID)))
+	h.writeSession(w, http.StatusCreated, session)
+}
+
+// GetUpload reports how much of an upload has been received. HEAD returns
+// only the Upload-Offset header.
+func (h *Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	_, session, ok := h.uploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.writeSession(w, http.StatusOK, session)
+}
+
+// AppendUpload appends the request body to an upload. The Upload-Offset
+// header must match the session's current offset, so a client retrying
+// after an interruption cannot write the same data twice.
+func (h *Handler) AppendUpload(w http.ResponseWriter, r *http.Request) {
+	repo, session, ok := h.uploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		h.writeError(w, http.StatusBadRequest, "A valid Upload-Offset header is required")
+		return
+	}
+
+	limit, err := h.uploadLimit(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	if limit > 0 {
+		remaining := limit - offset
+		if r.ContentLength > remaining {
+			h.writeSizeError(w, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, remaining)
+	}
+	body := disconnect.Watch(r)
+
+	session, err = h.uploads.Append(session.ID, offset, r.Body)
+	if session != nil {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	}
+
+	var mismatch *uploads.OffsetMismatchError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &mismatch):
+		h.writeError(w, http.StatusConflict, fmt.Sprintf("Upload offset mismatch, expected %d", mismatch.Offset))
+	case errors.As(err, &maxBytesErr):
+		h.writeSizeError(w, limit)
+	case h.uploadAborted(w, r, body, repo.Name, abortedResumable):
+	default:
+		h.writeUploadError(w, r, err, "Failed to write upload data")
+	}
+}
+
+// CompleteUpload stores the received data as the session's artifact
+func (h *Handler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	repo, session, ok := h.uploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	// The path may have been written since the session was created
+	if !h.checkOverwrite(w, repo, session.Path) {
+		return
+	}
+
+	config, err := rawConfig(repo)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, "Invalid raw repository configuration")
+		return
+	}
+	class, err := uploadStorageClass(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expiresAt, err := uploadExpiry(r, config, session.Path, class)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	allowOverwrite, err := overwriteAllowed(r, config)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	expected, ok := h.expectedChecksums(w, r)
+	if !ok {
+		return
+	}
+
+	var artifact *metadata.Artifact
+	identical, replaced := false, false
+	artifactPath, sessionID := session.Path, session.ID
+	session, err = h.uploads.Complete(session.ID, func(s *uploads.Session, data io.Reader) error {
+		var err error
+		existing, statErr := h.storage.Stat(s.Repository, s.Path)
+		if statErr == nil && !allowOverwrite {
+			artifact, err = h.compareExisting(s.Repository, existing, data, s.Offset, expected)
+			identical = err == nil
+			return err
+		}
+		replaced = statErr == nil
+		artifact, err = h.storeUpload(s.Repository, s.Path, data, expected)
+		return err
+	})
+	if err != nil && h.writeValidationError(w, r, err) {
+		// A refused upload would be refused again, so the session is of no
+		// further use
+		var rejected *validation.RejectedError
+		if errors.As(err, &rejected) {
+			h.uploads.Abort(sessionID)
+		}
+		return
+	}
+	if err != nil && h.writeScanError(w, r, err) {
+		// An infected upload is already quarantined, so the session is of no
+		// further use
+		var infected *scan.InfectedError
+		if errors.As(err, &infected) {
+			h.uploads.Abort(sessionID)
+		}
+		return
+	}
+	if err != nil && h.writeChecksumError(w, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, errArtifactExists):
+		h.writeError(w, http.StatusConflict, "An artifact with different content already exists at "+artifactPath)
+		return
+	case err != nil:
+		h.writeUploadError(w, r, err, "Failed to store artifact")
+		return
+	}
+
+	if !identical {
+		h.setExpiry(h.requestLogger(r), session.Repository, session.Path, expiresAt)
+		h.setStorageClass(h.requestLogger(r), session.Repository, session.Path, class)
+		h.notifyPushed(session.Repository, session.Path)
+	}
+	h.writeUploadResult(w, artifact, !identical && !replaced)
+}
+
+// writeUploadResult answers a completed upload with the stored artifact's
+// location and checksums: 201 if it created the artifact, or 200 if it
+// replaced an existing one or matched it exactly
+func (h *Handler) writeUploadResult(w http.ResponseWriter, artifact *metadata.Artifact, created bool) {
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Location", h.location(fmt.Sprintf("/repository/%s/%s", artifact.Repository, artifact.Path)))
+	w.Header().Set(checksumHeader, artifact.SHA256)
+	result := uploadResult{
+		Repository: artifact.Repository,
+		Path:       artifact.Path,
+		Size:       artifact.Size,
+		Checksums:  artifact.Sums,
+		Verified:   artifact.Verified,
+	}
+	if artifact.SHA256 != "" {
+		result.ImmutableURL = h.location(digestPath(artifact.Repository, artifact.Path, artifact.SHA256))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// AbortUpload discards an upload session and its data
+func (h *Handler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	_, session, ok := h.uploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.uploads.Abort(session.ID); err != nil {
+		h.writeUploadError(w, r, err, "Failed to abort upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadSession looks up the upload session named in the request path,
+// which must belong to the repository in the path
+func (h *Handler) uploadSession(w http.ResponseWriter, r *http.Request) (*models.Repository, *uploads.Session, bool) {
+	repo, ok := h.rawRepository(w, r, "Resumable uploads")
+	if !ok || !h.repositoryEnabled(w, repo) {
+		return nil, nil, false
+	}
+
+	session, err := h.uploads.Get(mux.Vars(r)["id"])
+	if err == nil && session.Repository != repo.Name {
+		err = uploads.ErrUploadNotFound
+	}
+	if err != nil {
+		h.writeUploadError(w, r, err, "Failed to get upload session")
+		return nil, nil, false
+	}
+	return repo, session, true
+}
+
+func (h *Handler) writeSession(w http.ResponseWriter, status int, session *uploads.Session) {
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(session)
+}
+
+func (h *Handler) writeUploadError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		h.writeError(w, http.StatusNotFound, "Upload session not found")
+	case errors.Is(err, uploads.ErrUploadBusy):
+		h.writeError(w, http.StatusConflict, "Upload session is in use by another request")
+	default:
+		h.requestLogger(r).WithError(err).Error(message)
+		h.writeError(w, http.StatusInternalServerError, message)
+	}
+}
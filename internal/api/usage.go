@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/depot/depot/internal/metrics"
+)
+
+// defaultUsageWindow is the period the usage report covers unless ?from=
+// says otherwise
+const defaultUsageWindow = 24 * time.Hour
+
+// SetMetrics enables the usage report and forgets a repository's usage
+// when it is deleted
+func (h *Handler) SetMetrics(recorder *metrics.Recorder) {
+	h.metrics = recorder
+}
+
+// GetUsage reports a repository's requests and bytes transferred per
+// operation, in hourly or daily buckets between ?from= and ?to= (RFC 3339)
+func (h *Handler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if h.metrics == nil {
+		h.writeError(w, http.StatusNotFound, "Usage metrics are not enabled")
+		return
+	}
+
+	repo, ok := h.lookupRepository(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid to, expected an RFC 3339 time")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultUsageWindow)
+	if value := query.Get("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "Invalid from, expected an RFC 3339 time")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		h.writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	var interval time.Duration
+	switch query.Get("interval") {
+	case "", "hour":
+		interval = time.Hour
+	case "day":
+		interval = 24 * time.Hour
+	default:
+		h.writeError(w, http.StatusBadRequest, "Invalid interval, expected hour or day")
+		return
+	}
+
+	usage, err := h.metrics.Usage(repo.Name, from, to, interval)
+	if err != nil {
+		h.requestLogger(r).WithError(err).Error("Failed to get usage")
+		h.writeError(w, http.StatusInternalServerError, "Failed to get usage")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
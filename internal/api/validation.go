@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/depot/depot/internal/validation"
+)
+
+// SetValidator enables validation hooks on raw uploads
+func (h *Handler) SetValidator(validator *validation.Manager) {
+	h.validator = validator
+}
+
+// writeValidationError answers an upload refused by a validation hook. It
+// returns false if err is not a validation failure.
+func (h *Handler) writeValidationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var rejected *validation.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		h.writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Upload rejected by %s: %s", rejected.Hook, rejected.Message))
+	case errors.Is(err, validation.ErrUnavailable):
+		h.requestLogger(r).WithError(err).Error("Upload validation failed")
+		h.writeError(w, http.StatusServiceUnavailable, "Upload validation unavailable, try again later")
+	default:
+		return false
+	}
+	return true
+}
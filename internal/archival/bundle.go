@@ -0,0 +1,211 @@
+package archival
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// A bundle holds each artifact below filesDir, followed by the manifest.
+// The manifest is also stored next to the bundle so it can be read without
+// fetching the whole bundle back from cold storage.
+const (
+	filesDir      = "files/"
+	manifestEntry = "manifest.json"
+)
+
+// Manifest lists the artifacts of a bundle with their checksums, along
+// with the repository's definition at the time it was archived
+type Manifest struct {
+	Repository *models.Repository `json:"repository"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Files      []File             `json:"files"`
+}
+
+// File is an artifact in a bundle
+type File struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// writeBundle writes the bundle and manifest of a repository to the
+// backend, naming them in archive
+func (m *Manager) writeBundle(archive *Archive, repo *models.Repository, progress func(completed, total int64)) (*Manifest, error) {
+	files, err := m.storage.List(repo.Name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	stamp := now.Format("20060102T150405Z")
+	archive.Bundle = stamp + ".tar"
+	archive.Manifest = stamp + ".manifest.json"
+	manifest := &Manifest{Repository: repo, CreatedAt: now, Files: make([]File, 0, len(files))}
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := m.writeTar(writer, repo.Name, files, manifest, progress)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+	err = m.backend.Store(repo.Name, archive.Bundle, reader)
+	reader.Close()
+	if writeErr := <-done; writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		m.backend.Delete(repo.Name, archive.Bundle)
+		return nil, fmt.Errorf("failed to write archive bundle: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := m.backend.Store(repo.Name, archive.Manifest, bytes.NewReader(data)); err != nil {
+		m.backend.Delete(repo.Name, archive.Bundle)
+		return nil, fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func (m *Manager) writeTar(w io.Writer, repo string, files []storage.FileInfo, manifest *Manifest, progress func(completed, total int64)) error {
+	tw := tar.NewWriter(w)
+	for i, info := range files {
+		file, err := m.storage.Retrieve(repo, info.Path)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:     filesDir + info.Path,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     info.Size,
+			ModTime:  info.ModTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			file.Close()
+			return err
+		}
+		digest := sha256.New()
+		_, err = io.Copy(io.MultiWriter(tw, digest), file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", info.Path, err)
+		}
+		manifest.Files = append(manifest.Files, File{
+			Path:     info.Path,
+			Size:     info.Size,
+			SHA256:   hex.EncodeToString(digest.Sum(nil)),
+			Modified: info.ModTime,
+		})
+		if progress != nil {
+			progress(int64(i+1), int64(len(files)))
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: manifestEntry, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: manifest.CreatedAt}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// readBundle reads back the bundle of archive, handing each artifact to fn
+// through a reader that fails with ErrCorrupt, instead of ending, if the
+// artifact does not match its checksum. A nil fn only checks the bundle.
+func (m *Manager) readBundle(archive *Archive, manifest *Manifest, fn func(file File, data io.Reader) error) error {
+	expected := make(map[string]File, len(manifest.Files))
+	for _, file := range manifest.Files {
+		if !validPath(file.Path) {
+			return fmt.Errorf("%w: invalid path %q", ErrCorrupt, file.Path)
+		}
+		expected[file.Path] = file
+	}
+
+	bundle, err := m.backend.Retrieve(archive.Repository, archive.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to open archive bundle: %w", err)
+	}
+	defer bundle.Close()
+
+	tr := tar.NewReader(bundle)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if header.Name == manifestEntry {
+			continue
+		}
+		p, _ := strings.CutPrefix(header.Name, filesDir)
+		file, ok := expected[p]
+		if !ok || !strings.HasPrefix(header.Name, filesDir) {
+			return fmt.Errorf("%w: unexpected entry %s", ErrCorrupt, header.Name)
+		}
+		delete(expected, p)
+
+		data := &checkedReader{reader: tr, digest: sha256.New(), file: file}
+		if fn == nil {
+			_, err = io.Copy(io.Discard, data)
+		} else {
+			err = fn(file, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for p := range expected {
+		return fmt.Errorf("%w: %s is missing", ErrCorrupt, p)
+	}
+	return nil
+}
+
+// checkedReader reads an artifact from a bundle, failing at its end if it
+// does not match the manifest
+type checkedReader struct {
+	reader io.Reader
+	digest hash.Hash
+	file   File
+	read   int64
+}
+
+func (r *checkedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.digest.Write(p[:n])
+	r.read += int64(n)
+	if errors.Is(err, io.EOF) && (r.read != r.file.Size || hex.EncodeToString(r.digest.Sum(nil)) != r.file.SHA256) {
+		return n, fmt.Errorf("%w: %s does not match its checksum", ErrCorrupt, r.file.Path)
+	}
+	return n, err
+}
+
+// validPath reports whether a manifest path stays inside its repository
+func validPath(p string) bool {
+	return p != "" && path.Clean(p) == p && !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
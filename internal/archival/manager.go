@@ -0,0 +1,434 @@
+// Package archival freezes repositories no longer in active use and
+// exports them to cold storage. An archived repository is read-only. Its
+// artifacts are written to a bundle, a tar archive with a manifest of their
+// checksums, kept on a storage backend of its own, and may then be removed
+// from hot storage until the repository is restored.
+package archival
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+var (
+	bucketArchives = []byte("archives")
+	ErrArchived    = errors.New("repository is archived")
+	ErrNotArchived = errors.New("repository is not archived")
+	ErrBusy        = errors.New("repository is being exported or restored")
+	ErrCorrupt     = errors.New("archive bundle does not match its manifest")
+)
+
+// States of an archived repository
+const (
+	// StateExporting is a frozen repository whose bundle is being written
+	StateExporting = "exporting"
+	// StateArchived is a frozen repository whose content is both in its
+	// bundle and in hot storage
+	StateArchived = "archived"
+	// StateCold is a repository whose content is only in its bundle
+	StateCold = "cold"
+	// StateRestoring is a cold repository being copied back to hot storage
+	StateRestoring = "restoring"
+)
+
+// Archive records an archived repository and where its bundle is kept
+type Archive struct {
+	Repository string    `json:"repository"`
+	State      string    `json:"state"`
+	Bundle     string    `json:"bundle,omitempty"`
+	Manifest   string    `json:"manifest,omitempty"`
+	Files      int       `json:"files"`
+	Size       int64     `json:"size"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// Manager keeps track of archived repositories and moves their content
+// between hot storage and the backend holding bundles
+type Manager struct {
+	db       *bbolt.DB
+	storage  storage.Storage
+	backend  storage.Storage
+	logger   *logrus.Logger
+	mu       sync.RWMutex
+	archives map[string]*Archive
+}
+
+// NewManager creates a manager archiving the repositories of store to
+// bundles on backend. Exports cut short by a restart before their bundle
+// was written are dropped, leaving their repository as it was; those cut
+// short later are kept, cold if they had started removing content.
+// Restores cut short are left cold to be started again.
+func NewManager(db *bbolt.DB, store, backend storage.Storage, logger *logrus.Logger) (*Manager, error) {
+	m := &Manager{
+		db:       db,
+		storage:  store,
+		backend:  backend,
+		logger:   logger,
+		archives: make(map[string]*Archive),
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketArchives)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var archive Archive
+			if err := json.Unmarshal(v, &archive); err != nil {
+				return fmt.Errorf("failed to unmarshal archive %s: %w", k, err)
+			}
+			m.archives[archive.Repository] = &archive
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archives: %w", err)
+	}
+
+	for name, archive := range m.archives {
+		switch archive.State {
+		case StateExporting:
+			if archive.Manifest != "" {
+				state, err := m.interruptedExport(archive)
+				if err == nil {
+					logger.WithField("repository", name).Warnf("Archive export was interrupted after its bundle was written, repository is %s", state)
+					archive.State = state
+					if err := m.save(archive); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				logger.WithField("repository", name).WithError(err).Warn("Bundle of an interrupted archive export is unreadable")
+			}
+			logger.WithField("repository", name).Warn("Archive export was interrupted, repository is no longer archived")
+			if err := m.remove(name); err != nil {
+				return nil, err
+			}
+		case StateRestoring:
+			logger.WithField("repository", name).Warn("Archive restore was interrupted, restore it again")
+			archive.State = StateCold
+			if err := m.save(archive); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// Guard wraps store so that storing or deleting artifacts of an archived
+// repository fails with ErrArchived
+func (m *Manager) Guard(store storage.Storage) storage.Storage {
+	return &frozenStorage{Storage: store, frozen: m.Frozen}
+}
+
+// Frozen reports whether a repository is archived, and so read-only
+func (m *Manager) Frozen(repo string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.archives[repo]
+	return ok
+}
+
+// Cold reports whether a repository's content has been removed from hot
+// storage, or is not back in it yet
+func (m *Manager) Cold(repo string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	archive, ok := m.archives[repo]
+	return ok && (archive.State == StateCold || archive.State == StateRestoring)
+}
+
+// Get returns the archive of a repository
+func (m *Manager) Get(repo string) (*Archive, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	archive, ok := m.archives[repo]
+	if !ok {
+		return nil, ErrNotArchived
+	}
+	copied := *archive
+	return &copied, nil
+}
+
+// List returns every archived repository, by name
+func (m *Manager) List() []*Archive {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	archives := make([]*Archive, 0, len(m.archives))
+	for _, archive := range m.archives {
+		copied := *archive
+		archives = append(archives, &copied)
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Repository < archives[j].Repository
+	})
+	return archives
+}
+
+// Freeze makes a repository read-only ahead of exporting it
+func (m *Manager) Freeze(repo string) (*Archive, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.archives[repo]; ok {
+		return nil, ErrArchived
+	}
+	archive := &Archive{Repository: repo, State: StateExporting, ArchivedAt: time.Now().UTC()}
+	if err := m.save(archive); err != nil {
+		return nil, err
+	}
+	m.archives[repo] = archive
+	copied := *archive
+	return &copied, nil
+}
+
+// Export writes the bundle of a repository frozen by Freeze and, with
+// remove, deletes its content from hot storage once the bundle has been
+// read back and checked. The repository stays in StateExporting until
+// then, so it cannot be restored while its content is being removed. If
+// the bundle cannot be written the repository is thawed again.
+func (m *Manager) Export(repo *models.Repository, remove bool, progress func(completed, total int64)) (*Archive, error) {
+	archive, err := m.Get(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	if archive.State != StateExporting {
+		return nil, ErrBusy
+	}
+	log := m.logger.WithField("repository", repo.Name)
+
+	manifest, err := m.writeBundle(archive, repo, progress)
+	if err != nil {
+		if removeErr := m.Thaw(repo.Name); removeErr != nil {
+			log.WithError(removeErr).Error("Failed to thaw repository after a failed export")
+		}
+		return nil, err
+	}
+	archive.Files = len(manifest.Files)
+	archive.Size = 0
+	for _, file := range manifest.Files {
+		archive.Size += file.Size
+	}
+	if !remove {
+		archive.State = StateArchived
+	}
+	if err := m.update(archive); err != nil {
+		return nil, err
+	}
+	log.WithField("bundle", archive.Bundle).Infof("Archived %d artifacts", archive.Files)
+	if !remove {
+		return archive, nil
+	}
+
+	if err := m.readBundle(archive, manifest, nil); err != nil {
+		archive.State = StateArchived
+		if updateErr := m.update(archive); updateErr != nil {
+			log.WithError(updateErr).Error("Failed to record archive after a failed verification")
+		}
+		return nil, fmt.Errorf("bundle failed verification, content was kept: %w", err)
+	}
+	for _, file := range manifest.Files {
+		if err := m.storage.Delete(repo.Name, file.Path); err != nil {
+			// Some content may be gone, so it is restored from the bundle
+			archive.State = StateCold
+			if updateErr := m.update(archive); updateErr != nil {
+				log.WithError(updateErr).Error("Failed to record archive after a failed removal")
+			}
+			return nil, err
+		}
+	}
+	archive.State = StateCold
+	if err := m.update(archive); err != nil {
+		return nil, err
+	}
+	log.Infof("Removed %d archived artifacts from hot storage", archive.Files)
+	return archive, nil
+}
+
+// interruptedExport returns the state to leave a repository in whose
+// export a restart cut short after its bundle was written: cold if some of
+// its content was already removed from hot storage, else archived. An
+// export whose manifest cannot be read is dropped.
+func (m *Manager) interruptedExport(archive *Archive) (string, error) {
+	manifest, err := m.Manifest(archive.Repository)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range manifest.Files {
+		exists, err := m.storage.Exists(archive.Repository, file.Path)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return StateCold, nil
+		}
+	}
+	return StateArchived, nil
+}
+
+// Restore copies a cold repository's content back from its bundle,
+// checking every artifact against the manifest, and thaws it. A
+// repository whose content is still in hot storage is just thawed.
+func (m *Manager) Restore(repo string, progress func(completed, total int64)) (*Archive, error) {
+	m.mu.Lock()
+	archive, ok := m.archives[repo]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrNotArchived
+	}
+	switch archive.State {
+	case StateExporting, StateRestoring:
+		m.mu.Unlock()
+		return nil, ErrBusy
+	case StateCold:
+		archive.State = StateRestoring
+		if err := m.save(archive); err != nil {
+			archive.State = StateCold
+			m.mu.Unlock()
+			return nil, err
+		}
+	}
+	restored := *archive
+	m.mu.Unlock()
+
+	if restored.State == StateRestoring {
+		err := m.restoreContent(&restored, progress)
+		if err != nil {
+			restored.State = StateCold
+			if saveErr := m.update(&restored); saveErr != nil {
+				m.logger.WithError(saveErr).WithField("repository", repo).Error("Failed to record failed archive restore")
+			}
+			return nil, err
+		}
+		m.logger.WithField("repository", repo).Infof("Restored %d artifacts from archive", restored.Files)
+	}
+	if err := m.Thaw(repo); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func (m *Manager) restoreContent(archive *Archive, progress func(completed, total int64)) error {
+	manifest, err := m.Manifest(archive.Repository)
+	if err != nil {
+		return err
+	}
+	total := int64(len(manifest.Files))
+	var restored int64
+	return m.readBundle(archive, manifest, func(file File, data io.Reader) error {
+		if err := m.storage.Store(archive.Repository, file.Path, data); err != nil {
+			return err
+		}
+		restored++
+		if progress != nil {
+			progress(restored, total)
+		}
+		return nil
+	})
+}
+
+// Manifest reads the manifest of a repository's bundle from the backend
+func (m *Manager) Manifest(repo string) (*Manifest, error) {
+	archive, err := m.Get(repo)
+	if err != nil {
+		return nil, err
+	}
+	if archive.Manifest == "" {
+		return nil, ErrBusy
+	}
+
+	file, err := m.backend.Retrieve(repo, archive.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive manifest: %w", err)
+	}
+	defer file.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrCorrupt, err)
+	}
+	return &manifest, nil
+}
+
+// Thaw makes an archived repository writable again, forgetting its
+// archive. Its bundle stays on the backend.
+func (m *Manager) Thaw(repo string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.remove(repo)
+}
+
+// update saves an archive the manager still holds, which the repository
+// being thawed or deleted meanwhile would have dropped
+func (m *Manager) update(archive *Archive) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.archives[archive.Repository]; !ok {
+		return ErrNotArchived
+	}
+	if err := m.save(archive); err != nil {
+		return err
+	}
+	copied := *archive
+	m.archives[archive.Repository] = &copied
+	return nil
+}
+
+func (m *Manager) save(archive *Archive) error {
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("failed to marshal archive: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketArchives).Put([]byte(archive.Repository), data)
+	})
+}
+
+func (m *Manager) remove(repo string) error {
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketArchives).Delete([]byte(repo))
+	})
+	if err != nil {
+		return err
+	}
+	delete(m.archives, repo)
+	return nil
+}
+
+// frozenStorage refuses to change the content of archived repositories
+type frozenStorage struct {
+	storage.Storage
+	frozen func(repo string) bool
+}
+
+func (s *frozenStorage) Store(repo, path string, reader io.Reader) error {
+	if s.frozen(repo) {
+		return fmt.Errorf("%w: %s", ErrArchived, repo)
+	}
+	return s.Storage.Store(repo, path, reader)
+}
+
+func (s *frozenStorage) Delete(repo, path string) error {
+	if s.frozen(repo) {
+		return fmt.Errorf("%w: %s", ErrArchived, repo)
+	}
+	return s.Storage.Delete(repo, path)
+}
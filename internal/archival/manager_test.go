@@ -0,0 +1,249 @@
+package archival
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+type testEnv struct {
+	db      *bbolt.DB
+	store   storage.Storage
+	coldDir string
+	backend storage.Storage
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "archives.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	coldDir := filepath.Join(dir, "cold")
+	return &testEnv{
+		db:      db,
+		store:   storage.NewFileStorage(filepath.Join(dir, "artifacts")),
+		coldDir: coldDir,
+		backend: storage.NewFileStorage(coldDir),
+	}
+}
+
+func (e *testEnv) manager(t *testing.T) *Manager {
+	m, err := NewManager(e.db, e.store, e.backend, logrus.New())
+	require.NoError(t, err)
+	return m
+}
+
+func readArtifact(t *testing.T, store storage.Storage, repo, path string) string {
+	file, err := store.Retrieve(repo, path)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	env := newTestEnv(t)
+	m := env.manager(t)
+	repo := &models.Repository{Name: "releases", Type: models.RepositoryTypeRaw}
+	require.NoError(t, env.store.Store("releases", "app/1.0/app.bin", strings.NewReader("release 1.0")))
+	require.NoError(t, env.store.Store("releases", "app/2.0/app.bin", strings.NewReader("release 2.0")))
+
+	_, err := m.Freeze("releases")
+	require.NoError(t, err)
+	_, err = m.Freeze("releases")
+	assert.ErrorIs(t, err, ErrArchived)
+
+	// Archived repositories are read-only
+	guarded := m.Guard(env.store)
+	assert.ErrorIs(t, guarded.Store("releases", "app/3.0/app.bin", strings.NewReader("3.0")), ErrArchived)
+	assert.ErrorIs(t, guarded.Delete("releases", "app/1.0/app.bin"), ErrArchived)
+	assert.NoError(t, guarded.Store("other", "file.txt", strings.NewReader("other")))
+
+	archive, err := m.Export(repo, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, StateCold, archive.State)
+	assert.Equal(t, 2, archive.Files)
+	assert.Equal(t, int64(22), archive.Size)
+	assert.True(t, m.Cold("releases"))
+
+	files, err := env.store.List("releases", "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	manifest, err := m.Manifest("releases")
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 2)
+	assert.Equal(t, "app/1.0/app.bin", manifest.Files[0].Path)
+	assert.Len(t, manifest.Files[0].SHA256, 64)
+	assert.Equal(t, "releases", manifest.Repository.Name)
+
+	// Archives survive a restart
+	m = env.manager(t)
+	var progress int64
+	_, err = m.Restore("releases", func(completed, total int64) { progress = completed })
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), progress)
+	assert.False(t, m.Frozen("releases"))
+	assert.Equal(t, "release 1.0", readArtifact(t, env.store, "releases", "app/1.0/app.bin"))
+	assert.Equal(t, "release 2.0", readArtifact(t, env.store, "releases", "app/2.0/app.bin"))
+
+	// The bundle is kept in cold storage
+	_, err = os.Stat(filepath.Join(env.coldDir, "releases", archive.Bundle))
+	assert.NoError(t, err)
+}
+
+func TestArchiveKeepingContent(t *testing.T) {
+	env := newTestEnv(t)
+	m := env.manager(t)
+	repo := &models.Repository{Name: "releases", Type: models.RepositoryTypeRaw}
+	require.NoError(t, env.store.Store("releases", "app.bin", strings.NewReader("content")))
+
+	_, err := m.Freeze("releases")
+	require.NoError(t, err)
+	archive, err := m.Export(repo, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, StateArchived, archive.State)
+	assert.False(t, m.Cold("releases"))
+	assert.Equal(t, "content", readArtifact(t, env.store, "releases", "app.bin"))
+
+	// The bundle holds the artifacts and the manifest
+	bundle, err := env.backend.Retrieve("releases", archive.Bundle)
+	require.NoError(t, err)
+	defer bundle.Close()
+	var names []string
+	tr := tar.NewReader(bundle)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"files/app.bin", "manifest.json"}, names)
+
+	_, err = m.Restore("releases", nil)
+	require.NoError(t, err)
+	_, err = m.Get("releases")
+	assert.ErrorIs(t, err, ErrNotArchived)
+}
+
+func TestRestoreCorruptBundle(t *testing.T) {
+	env := newTestEnv(t)
+	m := env.manager(t)
+	repo := &models.Repository{Name: "releases", Type: models.RepositoryTypeRaw}
+	require.NoError(t, env.store.Store("releases", "app.bin", strings.NewReader("original")))
+
+	_, err := m.Freeze("releases")
+	require.NoError(t, err)
+	archive, err := m.Export(repo, true, nil)
+	require.NoError(t, err)
+
+	// Swap the bundle for one whose artifact no longer matches
+	bundlePath := filepath.Join(env.coldDir, "releases", archive.Bundle)
+	file, err := os.Create(bundlePath)
+	require.NoError(t, err)
+	tw := tar.NewWriter(file)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "files/app.bin", Mode: 0644, Size: 8}))
+	_, err = tw.Write([]byte("tampered"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, file.Close())
+
+	_, err = m.Restore("releases", nil)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	exists, err := env.store.Exists("releases", "app.bin")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// The repository stays cold, to be restored once the bundle is fixed
+	archive, err = m.Get("releases")
+	require.NoError(t, err)
+	assert.Equal(t, StateCold, archive.State)
+}
+
+func TestInterruptedExport(t *testing.T) {
+	env := newTestEnv(t)
+	m := env.manager(t)
+	_, err := m.Freeze("releases")
+	require.NoError(t, err)
+
+	m = env.manager(t)
+	assert.False(t, m.Frozen("releases"))
+}
+
+func TestInterruptedRemoval(t *testing.T) {
+	env := newTestEnv(t)
+	m := env.manager(t)
+	repo := &models.Repository{Name: "releases", Type: models.RepositoryTypeRaw}
+	require.NoError(t, env.store.Store("releases", "app/1.0/app.bin", strings.NewReader("release 1.0")))
+	require.NoError(t, env.store.Store("releases", "app/2.0/app.bin", strings.NewReader("release 2.0")))
+	_, err := m.Freeze("releases")
+	require.NoError(t, err)
+	archive, err := m.Export(repo, false, nil)
+	require.NoError(t, err)
+
+	// A restart while the content was being removed: nothing removed yet
+	// leaves it archived, anything removed leaves it cold
+	archive.State = StateExporting
+	require.NoError(t, m.update(archive))
+	m = env.manager(t)
+	archive, err = m.Get("releases")
+	require.NoError(t, err)
+	assert.Equal(t, StateArchived, archive.State)
+
+	archive.State = StateExporting
+	require.NoError(t, m.update(archive))
+	require.NoError(t, env.store.Delete("releases", "app/1.0/app.bin"))
+	m = env.manager(t)
+	assert.True(t, m.Cold("releases"))
+	_, err = m.Restore("releases", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "release 1.0", readArtifact(t, env.store, "releases", "app/1.0/app.bin"))
+}
+
+// restoringStorage tries to restore a repository while its content is
+// being removed
+type restoringStorage struct {
+	storage.Storage
+	manager *Manager
+	err     error
+}
+
+func (s *restoringStorage) Delete(repo, path string) error {
+	if s.err == nil {
+		_, s.err = s.manager.Restore(repo, nil)
+	}
+	return s.Storage.Delete(repo, path)
+}
+
+func TestRestoreDuringRemoval(t *testing.T) {
+	env := newTestEnv(t)
+	store := &restoringStorage{Storage: env.store}
+	m, err := NewManager(env.db, store, env.backend, logrus.New())
+	require.NoError(t, err)
+	store.manager = m
+	repo := &models.Repository{Name: "releases", Type: models.RepositoryTypeRaw}
+	require.NoError(t, env.store.Store("releases", "app/1.0/app.bin", strings.NewReader("release 1.0")))
+
+	_, err = m.Freeze("releases")
+	require.NoError(t, err)
+	archive, err := m.Export(repo, true, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.err, ErrBusy)
+	assert.Equal(t, StateCold, archive.State)
+	assert.True(t, m.Cold("releases"))
+}
@@ -0,0 +1,195 @@
+package buildcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/storage"
+)
+
+// ErrNotFound is returned for entries that are not in the cache
+var ErrNotFound = errors.New("cache entry not found")
+
+// Entry is a cached build output
+type Entry struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// EvictionReport describes an eviction from a build cache repository.
+// Size is the bytes cached once it is done.
+type EvictionReport struct {
+	Repository string   `json:"repository"`
+	Evicted    []string `json:"evicted"`
+	Freed      int64    `json:"freed"`
+	Size       int64    `json:"size"`
+}
+
+// Cache stores the entries of build cache repositories, keyed by the paths
+// build tools address them with, and evicts the least recently used ones.
+// When an entry was last read is only kept in memory; entries not read
+// since depot started were last used when they were written.
+type Cache struct {
+	storage storage.Storage
+	logger  *logrus.Logger
+
+	mu   sync.Mutex
+	used map[string]map[string]time.Time
+}
+
+// New creates a cache keeping its entries in storage
+func New(storage storage.Storage, logger *logrus.Logger) *Cache {
+	return &Cache{
+		storage: storage,
+		logger:  logger,
+		used:    make(map[string]map[string]time.Time),
+	}
+}
+
+// Get opens an entry of a repository and marks it used
+func (c *Cache) Get(repo, key string) (io.ReadCloser, *storage.FileInfo, error) {
+	info, err := c.Stat(repo, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := c.storage.Retrieve(repo, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, info, nil
+}
+
+// Stat returns the size of an entry of a repository and marks it used, as
+// build tools check for entries before fetching them
+func (c *Cache) Stat(repo, key string) (*storage.FileInfo, error) {
+	info, err := c.storage.Stat(repo, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.touch(repo, key)
+	return info, nil
+}
+
+// Put stores an entry in a repository, replacing any entry with the same
+// key. If sha256 is not empty the content must have that digest, or
+// checksum.ErrMismatch is returned and nothing is stored.
+func (c *Cache) Put(repo, key string, content io.Reader, sha256 string) error {
+	var expected checksum.Expected
+	if sha256 != "" {
+		expected = checksum.Expected{"sha256": sha256}
+	}
+	if err := c.storage.Store(repo, key, checksum.NewReader(content, expected)); err != nil {
+		return err
+	}
+	c.touch(repo, key)
+	return nil
+}
+
+// Entries lists the entries of a repository with when they were last used
+func (c *Cache) Entries(repo string) ([]Entry, error) {
+	files, err := c.storage.List(repo, "")
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	used := c.used[repo]
+	entries := make([]Entry, 0, len(files))
+	for _, file := range files {
+		entry := Entry{Key: file.Path, Size: file.Size, LastUsed: file.ModTime}
+		if last := used[file.Path]; last.After(entry.LastUsed) {
+			entry.LastUsed = last
+		}
+		entries = append(entries, entry)
+	}
+	c.mu.Unlock()
+	return entries, nil
+}
+
+// Evict deletes the entries of a repository not used for ttl, then the
+// least recently used entries until it holds at most maxSize bytes. A ttl
+// or maxSize of 0 does not limit the cache.
+func (c *Cache) Evict(repo string, maxSize int64, ttl time.Duration) (*EvictionReport, error) {
+	entries, err := c.Entries(repo)
+	if err != nil {
+		return nil, err
+	}
+	report := &EvictionReport{Repository: repo, Evicted: []string{}}
+	for _, entry := range entries {
+		report.Size += entry.Size
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+	expired := time.Now().Add(-ttl)
+	for _, entry := range entries {
+		overSize := maxSize > 0 && report.Size > maxSize
+		if !overSize && (ttl <= 0 || !entry.LastUsed.Before(expired)) {
+			break
+		}
+		if err := c.Delete(repo, entry.Key); err != nil {
+			return report, fmt.Errorf("failed to evict %s: %w", entry.Key, err)
+		}
+		report.Evicted = append(report.Evicted, entry.Key)
+		report.Freed += entry.Size
+		report.Size -= entry.Size
+	}
+	if len(report.Evicted) == 0 {
+		return report, nil
+	}
+
+	c.logger.WithField("repository", repo).Infof("Evicted %d build cache entries, freeing %d bytes", len(report.Evicted), report.Freed)
+	return report, nil
+}
+
+// Forget deletes every entry of a repository
+func (c *Cache) Forget(repo string) error {
+	files, err := c.storage.List(repo, "")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := c.storage.Delete(repo, file.Path); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.used, repo)
+	c.mu.Unlock()
+	return nil
+}
+
+// Delete removes an entry of a repository, which need not exist
+func (c *Cache) Delete(repo, key string) error {
+	if err := c.storage.Delete(repo, key); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	delete(c.used[repo], key)
+	c.mu.Unlock()
+	return nil
+}
+
+// touch marks an entry used now
+func (c *Cache) touch(repo, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used[repo] == nil {
+		c.used[repo] = make(map[string]time.Time)
+	}
+	c.used[repo][key] = time.Now()
+}
@@ -0,0 +1,136 @@
+package buildcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/storage"
+)
+
+func newTestCache(t *testing.T) (*Cache, string) {
+	dir := t.TempDir()
+	return New(storage.NewFileStorage(dir), logrus.New()), dir
+}
+
+func TestResolve(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		path   string
+		key    string
+		digest string
+		ok     bool
+	}{
+		{path: "ac/" + hash, key: "ac/" + hash, ok: true},
+		{path: "cas/" + hash, key: "cas/" + hash, digest: hash, ok: true},
+		{path: "ci/linux/cas/" + hash, key: "ci/linux/cas/" + hash, digest: hash, ok: true},
+		{path: "0123456789abcdef0123456789abcdef", key: "gradle/0123456789abcdef0123456789abcdef", ok: true},
+		{path: "cas/" + strings.ToUpper(hash)},
+		{path: "cas/abc"},
+		{path: "blobs/" + hash},
+		{path: "../cas/" + hash},
+		{path: "ci//cas/" + hash},
+		{path: "not-a-key"},
+		{path: ""},
+	}
+	for _, tt := range tests {
+		key, digest, ok := Resolve(tt.path)
+		assert.Equal(t, tt.ok, ok, tt.path)
+		assert.Equal(t, tt.key, key, tt.path)
+		assert.Equal(t, tt.digest, digest, tt.path)
+	}
+}
+
+func TestResolveObject(t *testing.T) {
+	for _, valid := range []string{"a/b/c/abcdef", ".sccache_check", "ccache/ab/cdef"} {
+		key, ok := ResolveObject(valid)
+		assert.True(t, ok, valid)
+		assert.Equal(t, valid, key)
+	}
+	for _, invalid := range []string{"", "a//b", "../etc/passwd", "a/./b", "a/.upload-123"} {
+		_, ok := ResolveObject(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestPutVerifiesDigest(t *testing.T) {
+	c, _ := newTestCache(t)
+	sum := sha256.Sum256([]byte("object file"))
+	digest := hex.EncodeToString(sum[:])
+
+	err := c.Put("bazel", "cas/"+digest, strings.NewReader("other file"), digest)
+	assert.True(t, errors.Is(err, checksum.ErrMismatch))
+	_, err = c.Stat("bazel", "cas/"+digest)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, c.Put("bazel", "cas/"+digest, strings.NewReader("object file"), digest))
+	reader, info, err := c.Get("bazel", "cas/"+digest)
+	require.NoError(t, err)
+	defer reader.Close()
+	data, _ := io.ReadAll(reader)
+	assert.Equal(t, "object file", string(data))
+	assert.Equal(t, int64(11), info.Size)
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(t)
+	for _, key := range []string{"gradle/aa", "gradle/bb", "gradle/cc"} {
+		require.NoError(t, c.Put("gradle", key, strings.NewReader("0123456789"), ""))
+	}
+	c.mu.Lock()
+	now := time.Now()
+	c.used["gradle"]["gradle/aa"] = now.Add(-time.Minute)
+	c.used["gradle"]["gradle/bb"] = now.Add(time.Minute)
+	c.used["gradle"]["gradle/cc"] = now.Add(2 * time.Minute)
+	c.mu.Unlock()
+
+	report, err := c.Evict("gradle", 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, report.Evicted)
+	assert.Equal(t, int64(30), report.Size)
+
+	report, err = c.Evict("gradle", 20, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gradle/aa"}, report.Evicted)
+	assert.Equal(t, int64(10), report.Freed)
+	assert.Equal(t, int64(20), report.Size)
+
+	_, err = c.Stat("gradle", "gradle/aa")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Stat("gradle", "gradle/bb")
+	assert.NoError(t, err)
+
+	require.NoError(t, c.Forget("gradle"))
+	entries, err := c.Entries("gradle")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestEvictExpired(t *testing.T) {
+	c, dir := newTestCache(t)
+	for _, key := range []string{"a/old", "a/recent"} {
+		require.NoError(t, c.Put("sccache", key, strings.NewReader("object"), ""))
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	c.mu.Lock()
+	c.used["sccache"]["a/old"] = old
+	c.mu.Unlock()
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "sccache", "a", "old"), old, old))
+
+	report, err := c.Evict("sccache", 0, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/old"}, report.Evicted)
+	assert.Equal(t, int64(6), report.Size)
+}
@@ -0,0 +1,70 @@
+package buildcache
+
+import (
+	"strings"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+// gradlePrefix holds the entries of the Gradle HTTP build cache, so they
+// never collide with Bazel's
+const gradlePrefix = "gradle/"
+
+// Resolve maps the path of a request to a build cache repository, relative
+// to the repository, to the key of the entry it addresses. The paths are
+// those of the Bazel remote cache HTTP protocol,
+// [<instance name>/]ac/<sha256> for action results and
+// [<instance name>/]cas/<sha256> for content, and of the Gradle HTTP build
+// cache, <cache key>. digest is the SHA-256 content stored at the key must
+// have, which only CAS entries are addressed by.
+func Resolve(requestPath string) (key, digest string, ok bool) {
+	segments := strings.Split(requestPath, "/")
+	if len(segments) == 1 {
+		if !isHex(segments[0], 16, 128) {
+			return "", "", false
+		}
+		return gradlePrefix + segments[0], "", true
+	}
+
+	n := len(segments)
+	hash, kind := segments[n-1], segments[n-2]
+	if !isHex(hash, 64, 64) || (kind != "ac" && kind != "cas") {
+		return "", "", false
+	}
+	for _, segment := range segments[:n-2] {
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return "", "", false
+		}
+	}
+	if kind == "cas" {
+		digest = hash
+	}
+	return requestPath, digest, true
+}
+
+// ResolveObject maps the path of a request to an object cache repository
+// to the key of the object it addresses, which is the path itself. Paths
+// must not have empty, . or .. segments; segments may otherwise start with
+// a dot, as in sccache's .sccache_check, unless storage reserves the name.
+func ResolveObject(requestPath string) (string, bool) {
+	for _, segment := range strings.Split(requestPath, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.HasPrefix(segment, storage.UploadPrefix) {
+			return "", false
+		}
+	}
+	return requestPath, true
+}
+
+// isHex reports whether s is lowercase hex of between minLen and maxLen
+// characters
+func isHex(s string, minLen, maxLen int) bool {
+	if len(s) < minLen || len(s) > maxLen {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
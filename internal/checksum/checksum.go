@@ -0,0 +1,179 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrMismatch is returned by a Reader whose content does not match the
+// expected digest
+var ErrMismatch = errors.New("checksum mismatch")
+
+// MismatchError describes content that does not match an expected digest.
+// It matches ErrMismatch.
+type MismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%v: expected %s %s, got %s", ErrMismatch, e.Algorithm, e.Expected, e.Actual)
+}
+
+// Is reports whether target is ErrMismatch
+func (e *MismatchError) Is(target error) bool {
+	return target == ErrMismatch
+}
+
+// Sums holds the hex-encoded digests of a piece of content. SHA512 is only
+// computed when it was expected.
+type Sums struct {
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	SHA512 string `json:"sha512,omitempty"`
+}
+
+// Algorithms lists the supported digests, which are also the file
+// extensions of checksum sidecars
+var Algorithms = []string{"md5", "sha1", "sha256"}
+
+// Verifiable lists the digests a Reader can verify content against
+var Verifiable = []string{"md5", "sha1", "sha256", "sha512"}
+
+// Expected maps algorithms to the hex-encoded digests content must have
+type Expected map[string]string
+
+// Get returns the digest for algorithm, or "" if it is not supported
+func (s Sums) Get(algorithm string) string {
+	switch algorithm {
+	case "md5":
+		return s.MD5
+	case "sha1":
+		return s.SHA1
+	case "sha256":
+		return s.SHA256
+	case "sha512":
+		return s.SHA512
+	}
+	return ""
+}
+
+// Reader computes digests of everything read through it. If digests are
+// expected, reaching EOF with different content returns a *MismatchError
+// instead of io.EOF, so a consumer such as an upload never sees a clean end
+// of stream for corrupt data.
+type Reader struct {
+	reader   io.Reader
+	md5      hash.Hash
+	sha1     hash.Hash
+	sha256   hash.Hash
+	sha512   hash.Hash
+	expected Expected
+}
+
+// NewReader wraps r. expected may be nil; algorithms it does not list in
+// Verifiable are ignored.
+func NewReader(r io.Reader, expected Expected) *Reader {
+	reader := &Reader{
+		reader:   r,
+		md5:      md5.New(),
+		sha1:     sha1.New(),
+		sha256:   sha256.New(),
+		expected: Expected{},
+	}
+	for algorithm, digest := range expected {
+		if digest != "" {
+			reader.expected[algorithm] = strings.ToLower(digest)
+		}
+	}
+	if _, ok := reader.expected["sha512"]; ok {
+		reader.sha512 = sha512.New()
+	}
+	return reader
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.md5.Write(p[:n])
+		r.sha1.Write(p[:n])
+		r.sha256.Write(p[:n])
+		if r.sha512 != nil {
+			r.sha512.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && len(r.expected) > 0 {
+		sums := r.Sums()
+		for _, algorithm := range Verifiable {
+			expected, ok := r.expected[algorithm]
+			if ok && sums.Get(algorithm) != expected {
+				return n, &MismatchError{Algorithm: algorithm, Expected: expected, Actual: sums.Get(algorithm)}
+			}
+		}
+	}
+	return n, err
+}
+
+// Sums returns the digests of the data read so far
+func (r *Reader) Sums() Sums {
+	sums := Sums{
+		MD5:    hex.EncodeToString(r.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(r.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(r.sha256.Sum(nil)),
+	}
+	if r.sha512 != nil {
+		sums.SHA512 = hex.EncodeToString(r.sha512.Sum(nil))
+	}
+	return sums
+}
+
+// Verified returns the algorithms the content was checked against, in the
+// order of Verifiable. They only passed if reading reached EOF without
+// error.
+func (r *Reader) Verified() []string {
+	var verified []string
+	for _, algorithm := range Verifiable {
+		if _, ok := r.expected[algorithm]; ok {
+			verified = append(verified, algorithm)
+		}
+	}
+	return verified
+}
+
+// ValidSHA256 reports whether s is a hex-encoded SHA-256 digest
+func ValidSHA256(s string) bool {
+	return Valid("sha256", s)
+}
+
+// Valid reports whether s is a hex-encoded digest of algorithm
+func Valid(algorithm, s string) bool {
+	var size int
+	switch algorithm {
+	case "md5":
+		size = md5.Size
+	case "sha1":
+		size = sha1.Size
+	case "sha256":
+		size = sha256.Size
+	case "sha512":
+		size = sha512.Size
+	default:
+		return false
+	}
+	if len(s) != size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,60 @@
+package checksum
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	const (
+		helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		helloSHA512 = "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"
+	)
+
+	t.Run("Computes Sums", func(t *testing.T) {
+		r := NewReader(strings.NewReader("hello"), nil)
+		_, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		sums := r.Sums()
+		assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", sums.MD5)
+		assert.Equal(t, "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", sums.SHA1)
+		assert.Equal(t, helloSHA256, sums.SHA256)
+		assert.Equal(t, sums.SHA1, sums.Get("sha1"))
+	})
+
+	t.Run("Matching Expected Digest", func(t *testing.T) {
+		r := NewReader(strings.NewReader("hello"), Expected{"sha256": strings.ToUpper(helloSHA256), "sha512": helloSHA512})
+		_, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"sha256", "sha512"}, r.Verified())
+		assert.Equal(t, helloSHA512, r.Sums().SHA512)
+	})
+
+	t.Run("Mismatched Expected Digest", func(t *testing.T) {
+		r := NewReader(strings.NewReader("hellO"), Expected{"sha256": helloSHA256})
+		_, err := io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrMismatch)
+
+		r = NewReader(strings.NewReader("hello"), Expected{"md5": "5d41402abc4b2a76b9719d911017c592", "sha512": strings.Repeat("0", 128)})
+		_, err = io.ReadAll(r)
+		var mismatch *MismatchError
+		require.ErrorAs(t, err, &mismatch)
+		assert.Equal(t, "sha512", mismatch.Algorithm)
+		assert.Equal(t, helloSHA512, mismatch.Actual)
+	})
+
+	t.Run("Validate Digest", func(t *testing.T) {
+		assert.True(t, ValidSHA256(helloSHA256))
+		assert.False(t, ValidSHA256("abc"))
+		assert.False(t, ValidSHA256(strings.Repeat("z", 64)))
+		assert.True(t, Valid("md5", "5d41402abc4b2a76b9719d911017c592"))
+		assert.False(t, Valid("sha1", "5d41402abc4b2a76b9719d911017c592"))
+		assert.False(t, Valid("sha512", helloSHA256))
+		assert.True(t, Valid("sha512", helloSHA512))
+	})
+}
@@ -0,0 +1,347 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Candidate is an artifact selected for deletion by a cleanup policy
+type Candidate struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+	Policy  string    `json:"policy"`
+	Reason  string    `json:"reason"`
+}
+
+// Result summarizes a cleanup run against a single repository
+type Result struct {
+	Repository string      `json:"repository"`
+	DryRun     bool        `json:"dry_run"`
+	Candidates []Candidate `json:"candidates"`
+	Deleted    int         `json:"deleted"`
+	FreedBytes int64       `json:"freed_bytes"`
+}
+
+// Engine evaluates and enforces the cleanup policies of raw and Docker
+// repositories
+type Engine struct {
+	repoMgr   *repository.Manager
+	storage   storage.Storage
+	docker    *docker.Manager // nil leaves Docker repositories alone
+	metadata  *metadata.Store // nil if nothing is recorded for artifacts
+	onDeleted func(*Result)
+	frozen    func(repo string) bool
+	logger    *logrus.Logger
+}
+
+// NewEngine creates a new cleanup policy engine
+func NewEngine(repoMgr *repository.Manager, storage storage.Storage, logger *logrus.Logger) *Engine {
+	return &Engine{
+		repoMgr: repoMgr,
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// SetMetadata sets the store whose records of an artifact, such as its
+// checksums, properties and download counter, are removed with it
+func (e *Engine) SetMetadata(store *metadata.Store) {
+	e.metadata = store
+}
+
+// SetOnDeleted sets a function called with the result of each enforcing
+// run that deleted something
+func (e *Engine) SetOnDeleted(onDeleted func(*Result)) {
+	e.onDeleted = onDeleted
+}
+
+// SetFrozen sets a function reporting the repositories whose content may
+// not change, such as archived ones, which RunAll skips
+func (e *Engine) SetFrozen(frozen func(repo string) bool) {
+	e.frozen = frozen
+}
+
+// Run evaluates the repository's cleanup policies. A dry run evaluates every
+// policy, enabled or not, and deletes nothing; an enforcing run only applies
+// enabled policies. Once ctx is cancelled it stops deleting and returns
+// what it deleted so far with the context's error.
+func (e *Engine) Run(ctx context.Context, repo *models.Repository, dryRun bool) (*Result, error) {
+	if !e.Supports(repo) {
+		return nil, fmt.Errorf("cleanup policies are not supported for %s repositories", repo.Type)
+	}
+
+	candidates, err := e.Evaluate(repo, time.Now(), !dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Repository: repo.Name,
+		DryRun:     dryRun,
+		Candidates: candidates,
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, candidate := range candidates {
+		if ctx.Err() != nil {
+			break
+		}
+		if repo.Type == models.RepositoryTypeDocker {
+			// Blobs are kept, so untagging frees no space
+			if err := e.deleteTag(repo, candidate); err != nil {
+				e.logger.WithError(err).WithFields(logrus.Fields{
+					"repository": repo.Name,
+					"tag":        candidate.Path,
+				}).Error("Failed to delete tag during cleanup")
+				continue
+			}
+			result.Deleted++
+			continue
+		}
+		fields := logrus.Fields{
+			"repository": repo.Name,
+			"path":       candidate.Path,
+		}
+		if err := e.storage.Delete(repo.Name, candidate.Path); err != nil {
+			e.logger.WithError(err).WithFields(fields).Error("Failed to delete artifact during cleanup")
+			continue
+		}
+		if e.metadata != nil {
+			if err := e.metadata.Delete(repo.Name, candidate.Path); err != nil {
+				e.logger.WithError(err).WithFields(fields).Warn("Failed to remove metadata of artifact deleted during cleanup")
+			}
+		}
+		result.Deleted++
+		result.FreedBytes += candidate.Size
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"repository":  repo.Name,
+		"deleted":     result.Deleted,
+		"freed_bytes": result.FreedBytes,
+	}).Info("Cleanup policies enforced")
+
+	if e.onDeleted != nil && result.Deleted > 0 {
+		e.onDeleted(result)
+	}
+	return result, ctx.Err()
+}
+
+// RunAll enforces the enabled cleanup policies of every supported
+// repository, reporting per-repository progress. It stops early if ctx is
+// cancelled.
+func (e *Engine) RunAll(ctx context.Context, progress func(completed, total int64)) ([]*Result, error) {
+	repos, err := e.repoMgr.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	var supported []*models.Repository
+	for _, repo := range repos {
+		// Offline Docker repositories have no registry to clean up
+		if e.frozen != nil && e.frozen(repo.Name) {
+			continue
+		}
+		if e.Supports(repo) && (repo.Type != models.RepositoryTypeDocker || repo.IsEnabled()) {
+			supported = append(supported, repo)
+		}
+	}
+
+	results := []*Result{}
+	for i, repo := range supported {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result, err := e.Run(ctx, repo, false)
+		if errors.Is(err, context.Canceled) {
+			return append(results, result), err
+		}
+		if err != nil {
+			e.logger.WithError(err).Errorf("Cleanup failed for repository %s", repo.Name)
+		} else {
+			results = append(results, result)
+		}
+		progress(int64(i+1), int64(len(supported)))
+	}
+
+	return results, nil
+}
+
+// Evaluate returns the artifacts the repository's policies select for
+// deletion as of now. When enforcedOnly is set, disabled policies are skipped.
+func (e *Engine) Evaluate(repo *models.Repository, now time.Time, enforcedOnly bool) ([]Candidate, error) {
+	if repo.Type == models.RepositoryTypeDocker {
+		return e.evaluateImages(repo, now, enforcedOnly)
+	}
+
+	var config models.RawRepositoryConfig
+	if repo.Config != nil {
+		if err := json.Unmarshal(repo.Config, &config); err != nil {
+			return nil, fmt.Errorf("invalid raw repository configuration: %w", err)
+		}
+	}
+
+	if len(config.CleanupPolicies) == 0 {
+		return []Candidate{}, nil
+	}
+
+	files, err := e.storage.List(repo.Name, "")
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []Candidate{}
+	selected := make(map[string]bool)
+	for _, policy := range config.CleanupPolicies {
+		if enforcedOnly && !policy.Enabled {
+			continue
+		}
+		for _, candidate := range evaluatePolicy(policy, files, now) {
+			if selected[candidate.Path] {
+				continue
+			}
+			selected[candidate.Path] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates, nil
+}
+
+// evaluatePolicy selects the files a policy deletes. Files are grouped by
+// version, as versionOf tells, so "builds/**" groups builds/42/app.jar
+// with the rest of builds/42. A version is as old as its newest file, and KeepLatest and
+// MaxAgeDays select whole versions, never part of one.
+func evaluatePolicy(policy models.CleanupPolicy, files []storage.FileInfo, now time.Time) []Candidate {
+	if policy.MaxAgeDays <= 0 && policy.KeepLatest <= 0 {
+		return nil
+	}
+
+	versions := make(map[string]*version)
+	var ordered []*version
+	for _, file := range files {
+		if policy.PathPattern != "" && !glob.Match(policy.PathPattern, file.Path) {
+			continue
+		}
+		if isExcluded(policy.Exclude, file.Path) {
+			continue
+		}
+		name := versionOf(policy.PathPattern, file.Path)
+		v, exists := versions[name]
+		if !exists {
+			v = &version{name: name}
+			versions[name] = v
+			ordered = append(ordered, v)
+		}
+		v.files = append(v.files, file)
+		if file.ModTime.After(v.modTime) {
+			v.modTime = file.ModTime
+		}
+	}
+
+	// Newest first, so the first KeepLatest versions are retained
+	sort.Slice(ordered, func(i, j int) bool {
+		if !ordered[i].modTime.Equal(ordered[j].modTime) {
+			return ordered[i].modTime.After(ordered[j].modTime)
+		}
+		return ordered[i].name < ordered[j].name
+	})
+
+	cutoff := now.Add(-time.Duration(policy.MaxAgeDays) * 24 * time.Hour)
+
+	var candidates []Candidate
+	for i, v := range ordered {
+		reason, selected := selectReason(policy, i, v.modTime, cutoff)
+		if !selected {
+			continue
+		}
+
+		sort.Slice(v.files, func(i, j int) bool { return v.files[i].Path < v.files[j].Path })
+		for _, file := range v.files {
+			candidates = append(candidates, Candidate{
+				Path:    file.Path,
+				Size:    file.Size,
+				ModTime: file.ModTime,
+				Policy:  policy.Name,
+				Reason:  reason,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// version is the files of one version matched by a policy
+type version struct {
+	name    string
+	modTime time.Time // of the newest file
+	files   []storage.FileInfo
+}
+
+// versionOf returns the version a file matched by pattern belongs to: the
+// directory right below the pattern's leading literal segments, or the
+// file itself if it sits directly in them. Without a literal segment to
+// start from, as with no pattern, it is the directory holding the file.
+func versionOf(pattern, artifactPath string) string {
+	fixed := 0
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if segment == "" || strings.ContainsAny(segment, "*?[\\") {
+			break
+		}
+		fixed++
+	}
+	segments := strings.Split(strings.Trim(artifactPath, "/"), "/")
+	switch {
+	case fixed == 0 && len(segments) > 1:
+		return strings.Join(segments[:len(segments)-1], "/")
+	case fixed == 0 || fixed+1 >= len(segments):
+		return artifactPath
+	}
+	return strings.Join(segments[:fixed+1], "/")
+}
+
+// selectReason reports whether the policy selects the version at index i
+// of those it matches, newest first, and why
+func selectReason(policy models.CleanupPolicy, i int, modTime, cutoff time.Time) (string, bool) {
+	var reasons []string
+	if policy.KeepLatest > 0 {
+		if i < policy.KeepLatest {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("not among %d most recent versions", policy.KeepLatest))
+	}
+	if policy.MaxAgeDays > 0 {
+		if !modTime.Before(cutoff) {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("older than %d days", policy.MaxAgeDays))
+	}
+	return strings.Join(reasons, " and "), true
+}
+
+func isExcluded(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if glob.Match(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
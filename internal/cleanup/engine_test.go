@@ -0,0 +1,122 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestEvaluatePolicy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	files := []storage.FileInfo{
+		{Path: "nightly/1/app.zip", Size: 10, ModTime: now.Add(-40 * day)},
+		{Path: "nightly/2/app.zip", Size: 10, ModTime: now.Add(-20 * day)},
+		{Path: "nightly/3/app.zip", Size: 10, ModTime: now.Add(-10 * day)},
+		{Path: "nightly/4/app.zip", Size: 10, ModTime: now.Add(-1 * day)},
+		{Path: "releases/1.0/app.zip", Size: 10, ModTime: now.Add(-400 * day)},
+	}
+
+	paths := func(candidates []Candidate) []string {
+		var result []string
+		for _, c := range candidates {
+			result = append(result, c.Path)
+		}
+		return result
+	}
+
+	t.Run("Max Age", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "age", MaxAgeDays: 30, Exclude: []string{"releases/**"}}
+		assert.Equal(t, []string{"nightly/1/app.zip"}, paths(evaluatePolicy(policy, files, now)))
+	})
+
+	t.Run("Keep Latest", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "latest", PathPattern: "nightly/**", KeepLatest: 2}
+		assert.Equal(t, []string{"nightly/2/app.zip", "nightly/1/app.zip"}, paths(evaluatePolicy(policy, files, now)))
+	})
+
+	t.Run("Combined Criteria", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "both", PathPattern: "nightly/**", KeepLatest: 1, MaxAgeDays: 15}
+		assert.Equal(t, []string{"nightly/2/app.zip", "nightly/1/app.zip"}, paths(evaluatePolicy(policy, files, now)))
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		// Three files per build; a build is as new as its newest file
+		var builds []storage.FileInfo
+		for i, age := range []int{30, 5, 20} {
+			for j, name := range []string{"app.jar", "app.pom", "app.jar.sha1"} {
+				builds = append(builds, storage.FileInfo{
+					Path:    fmt.Sprintf("builds/%d/%s", i+1, name),
+					Size:    1,
+					ModTime: now.Add(-time.Duration(age)*day + time.Duration(j)*time.Hour),
+				})
+			}
+		}
+		builds = append(builds, storage.FileInfo{Path: "builds/index.html", Size: 1, ModTime: now.Add(-50 * day)})
+
+		policy := models.CleanupPolicy{Name: "builds", PathPattern: "builds/**", KeepLatest: 3}
+		assert.Equal(t, []string{"builds/index.html"}, paths(evaluatePolicy(policy, builds, now)))
+
+		policy.KeepLatest = 1
+		candidates := evaluatePolicy(policy, builds, now)
+		assert.Equal(t, []string{"builds/3/app.jar", "builds/3/app.jar.sha1", "builds/3/app.pom",
+			"builds/1/app.jar", "builds/1/app.jar.sha1", "builds/1/app.pom", "builds/index.html"}, paths(candidates))
+		assert.Equal(t, "not among 1 most recent versions", candidates[0].Reason)
+
+		policy = models.CleanupPolicy{Name: "old", PathPattern: "builds/*/app.*", MaxAgeDays: 25}
+		assert.Equal(t, []string{"builds/1/app.jar", "builds/1/app.jar.sha1", "builds/1/app.pom"}, paths(evaluatePolicy(policy, builds, now)))
+	})
+
+	t.Run("No Criteria", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "none"}
+		assert.Empty(t, evaluatePolicy(policy, files, now))
+	})
+}
+
+func TestRunRemovesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := metadata.NewStore(db)
+	files := storage.NewFileStorage(filepath.Join(dir, "artifacts"))
+	engine := NewEngine(nil, files, logrus.New())
+	engine.SetMetadata(store)
+
+	old := time.Now().Add(-time.Hour)
+	for _, p := range []string{"builds/1/app.jar", "builds/2/app.jar"} {
+		require.NoError(t, files.Store("ci", p, strings.NewReader(p)))
+		require.NoError(t, store.Put(&metadata.Artifact{Repository: "ci", Path: p}))
+		require.NoError(t, store.RecordDownload("ci", p, time.Now()))
+	}
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "artifacts", "ci", "builds", "1", "app.jar"), old, old))
+
+	repo := &models.Repository{Name: "ci", Type: models.RepositoryTypeRaw,
+		Config: []byte(`{"cleanup_policies":[{"name":"latest","enabled":true,"path_pattern":"builds/**","keep_latest":1}]}`)}
+	result, err := engine.Run(context.Background(), repo, false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Deleted)
+
+	// The deleted artifact leaves no metadata or download counter behind
+	_, err = store.Get("ci", "builds/1/app.jar")
+	assert.Error(t, err)
+	top, err := store.TopDownloads("ci", 0)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, "builds/2/app.jar", top[0].Path)
+}
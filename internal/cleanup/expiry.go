@@ -0,0 +1,45 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/metadata"
+)
+
+// ExpireArtifacts deletes the raw artifacts whose time to live has passed
+// as of now and returns how many were removed. It stops early if ctx is
+// cancelled.
+func (e *Engine) ExpireArtifacts(ctx context.Context, store *metadata.Store, now time.Time) (int, error) {
+	expired, err := store.Expired(now)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, expiry := range expired {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		fields := logrus.Fields{
+			"repository": expiry.Repository,
+			"path":       expiry.Path,
+		}
+		if err := e.storage.Delete(expiry.Repository, expiry.Path); err != nil {
+			e.logger.WithError(err).WithFields(fields).Error("Failed to delete expired artifact")
+			continue
+		}
+		if err := store.Delete(expiry.Repository, expiry.Path); err != nil {
+			e.logger.WithError(err).WithFields(fields).Warn("Failed to remove metadata of expired artifact")
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		e.logger.WithField("removed", removed).Info("Expired artifacts removed")
+	}
+	return removed, nil
+}
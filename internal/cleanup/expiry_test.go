@@ -0,0 +1,50 @@
+package cleanup
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+)
+
+func TestExpireArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := metadata.NewStore(db)
+	files := storage.NewFileStorage(filepath.Join(dir, "artifacts"))
+	engine := NewEngine(nil, files, logrus.New())
+
+	now := time.Now()
+	require.NoError(t, files.Store("ci", "scratch/old.bin", strings.NewReader("old")))
+	require.NoError(t, files.Store("ci", "scratch/new.bin", strings.NewReader("new")))
+	require.NoError(t, store.SetExpiry("ci", "scratch/old.bin", now.Add(-time.Minute)))
+	require.NoError(t, store.SetExpiry("ci", "scratch/new.bin", now.Add(time.Hour)))
+
+	removed, err := engine.ExpireArtifacts(context.Background(), store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	exists, err := files.Exists("ci", "scratch/old.bin")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = files.Exists("ci", "scratch/new.bin")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The expiry of a removed artifact is cleared with it
+	expired, err := store.Expired(now.Add(-time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+}
@@ -0,0 +1,138 @@
+package cleanup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+// SetDockerManager enables the cleanup policies of Docker repositories,
+// which delete tags of the manager's registries
+func (e *Engine) SetDockerManager(manager *docker.Manager) {
+	e.docker = manager
+}
+
+// Supports reports whether the engine can clean up a repository
+func (e *Engine) Supports(repo *models.Repository) bool {
+	switch repo.Type {
+	case models.RepositoryTypeRaw:
+		return true
+	case models.RepositoryTypeDocker:
+		return e.docker != nil
+	default:
+		return false
+	}
+}
+
+// evaluateImages returns the tags a Docker repository's policies select
+// for deletion, as image:tag
+func (e *Engine) evaluateImages(repo *models.Repository, now time.Time, enforcedOnly bool) ([]Candidate, error) {
+	var config models.DockerRepositoryConfig
+	if repo.Config != nil {
+		if err := json.Unmarshal(repo.Config, &config); err != nil {
+			return nil, fmt.Errorf("invalid Docker repository configuration: %w", err)
+		}
+	}
+
+	if len(config.CleanupPolicies) == 0 {
+		return []Candidate{}, nil
+	}
+
+	tags, err := e.docker.ListTags(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []Candidate{}
+	selected := make(map[string]bool)
+	for _, policy := range config.CleanupPolicies {
+		if enforcedOnly && !policy.Enabled {
+			continue
+		}
+		for _, candidate := range evaluateImagePolicy(policy, tags, now) {
+			if selected[candidate.Path] {
+				continue
+			}
+			selected[candidate.Path] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates, nil
+}
+
+// evaluateImagePolicy applies a policy to the tags of a Docker repository.
+// KeepLatest keeps the most recently pushed tags of each image.
+func evaluateImagePolicy(policy models.CleanupPolicy, tags []*docker.TagInfo, now time.Time) []Candidate {
+	if policy.MaxAgeDays <= 0 && policy.KeepLatest <= 0 {
+		return nil
+	}
+
+	byImage := make(map[string][]*docker.TagInfo)
+	var images []string
+	for _, tag := range tags {
+		reference := tag.Image + ":" + tag.Tag
+		if policy.PathPattern != "" && !glob.Match(policy.PathPattern, reference) {
+			continue
+		}
+		if isExcluded(policy.Exclude, reference) {
+			continue
+		}
+		if !models.HasLabels(tag.Labels, policy.Labels) || hasAnyLabel(tag.Labels, policy.KeepLabels) {
+			continue
+		}
+		if _, exists := byImage[tag.Image]; !exists {
+			images = append(images, tag.Image)
+		}
+		byImage[tag.Image] = append(byImage[tag.Image], tag)
+	}
+	sort.Strings(images)
+
+	cutoff := now.Add(-time.Duration(policy.MaxAgeDays) * 24 * time.Hour)
+
+	var candidates []Candidate
+	for _, image := range images {
+		// Newest first, so the first KeepLatest tags are retained
+		matching := byImage[image]
+		sort.SliceStable(matching, func(i, j int) bool {
+			return matching[i].PushedAt.After(matching[j].PushedAt)
+		})
+
+		for i, tag := range matching {
+			reason, selected := selectReason(policy, i, tag.PushedAt, cutoff)
+			if !selected {
+				continue
+			}
+			candidates = append(candidates, Candidate{
+				Path:    tag.Image + ":" + tag.Tag,
+				Size:    tag.Size,
+				ModTime: tag.PushedAt,
+				Policy:  policy.Name,
+				Reason:  reason,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// hasAnyLabel reports whether labels include one of keep
+func hasAnyLabel(labels, keep map[string]string) bool {
+	for name, value := range keep {
+		if actual, exists := labels[name]; exists && actual == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) deleteTag(repo *models.Repository, candidate Candidate) error {
+	separator := strings.LastIndex(candidate.Path, ":")
+	return e.docker.DeleteTag(repo.Name, candidate.Path[:separator], candidate.Path[separator+1:])
+}
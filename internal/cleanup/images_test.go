@@ -0,0 +1,52 @@
+package cleanup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestEvaluateImagePolicy(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tags := []*docker.TagInfo{
+		{Image: "app", Tag: "pr-1", PushedAt: now.Add(-10 * day), Labels: map[string]string{"ephemeral": "true"}},
+		{Image: "app", Tag: "pr-2", PushedAt: now.Add(-2 * day), Labels: map[string]string{"ephemeral": "true"}},
+		{Image: "app", Tag: "1.0", PushedAt: now.Add(-300 * day), Labels: map[string]string{"release": "true"}},
+		{Image: "app", Tag: "nightly", PushedAt: now.Add(-30 * day)},
+		{Image: "tools/lint", Tag: "old", PushedAt: now.Add(-20 * day), Labels: map[string]string{"ephemeral": "true"}},
+	}
+
+	paths := func(candidates []Candidate) []string {
+		var result []string
+		for _, c := range candidates {
+			result = append(result, c.Path)
+		}
+		return result
+	}
+
+	t.Run("Labels", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "ephemeral", MaxAgeDays: 7, Labels: map[string]string{"ephemeral": "true"}}
+		assert.Equal(t, []string{"app:pr-1", "tools/lint:old"}, paths(evaluateImagePolicy(policy, tags, now)))
+	})
+
+	t.Run("Keep Labels", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "age", MaxAgeDays: 7, KeepLabels: map[string]string{"release": "true"}}
+		assert.Equal(t, []string{"app:pr-1", "app:nightly", "tools/lint:old"}, paths(evaluateImagePolicy(policy, tags, now)))
+	})
+
+	t.Run("Keep Latest Per Image", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "latest", KeepLatest: 1}
+		assert.Equal(t, []string{"app:pr-1", "app:nightly", "app:1.0"}, paths(evaluateImagePolicy(policy, tags, now)))
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		policy := models.CleanupPolicy{Name: "prs", PathPattern: "app:pr-*", MaxAgeDays: 1}
+		assert.Equal(t, []string{"app:pr-2", "app:pr-1"}, paths(evaluateImagePolicy(policy, tags, now)))
+	})
+}
@@ -0,0 +1,384 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// leaseFile is the name of the file in the shared directory that records
+// which node is the leader
+const leaseFile = "leader.json"
+
+var (
+	// ErrLeaseLost is returned when another node took over leadership
+	ErrLeaseLost = errors.New("cluster lease lost")
+
+	// ErrNoLeader is returned when no node holds an unexpired lease
+	ErrNoLeader = errors.New("no cluster leader")
+)
+
+// Roles a node reports
+const (
+	RoleLeader  = "leader"
+	RoleStandby = "standby"
+)
+
+// Lease records the leader of the cluster until it expires
+type Lease struct {
+	NodeID  string    `json:"node_id"`
+	Address string    `json:"address"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// Node is one depot instance in an active/standby cluster. All nodes share
+// a directory, normally next to the shared storage, in which the leader
+// holds a lease it renews. Only the leader opens the database and serves
+// requests; standbys forward requests to it and take over when its lease
+// expires.
+type Node struct {
+	id      string
+	address string
+	dir     string
+	ttl     time.Duration
+	logger  *logrus.Logger
+
+	mu     sync.Mutex
+	leader bool
+}
+
+// NewNode creates a node named id that is reachable by the other nodes at
+// address (e.g. "https://depot-1:8443"). Leases last ttl.
+func NewNode(dir, id, address string, ttl time.Duration, logger *logrus.Logger) (*Node, error) {
+	if id == "" {
+		return nil, errors.New("cluster node ID is required")
+	}
+	if _, err := url.Parse(address); err != nil || address == "" {
+		return nil, fmt.Errorf("invalid cluster address %q", address)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("cluster lease duration must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cluster directory: %w", err)
+	}
+
+	return &Node{id: id, address: address, dir: dir, ttl: ttl, logger: logger}, nil
+}
+
+// ID returns the node's name
+func (n *Node) ID() string {
+	return n.id
+}
+
+// LeaseDuration returns how long a lease lasts without renewal
+func (n *Node) LeaseDuration() time.Duration {
+	return n.ttl
+}
+
+// IsLeader reports whether the node currently holds the lease
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.leader
+}
+
+// Leader returns the current unexpired lease
+func (n *Node) Leader() (*Lease, error) {
+	lease, err := n.readLease()
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil || lease.expired(time.Now()) {
+		return nil, ErrNoLeader
+	}
+	return lease, nil
+}
+
+// TryAcquire takes the lease if no other node holds an unexpired one
+func (n *Node) TryAcquire() (bool, error) {
+	now := time.Now()
+	lease, err := n.readLease()
+	if err != nil {
+		return false, err
+	}
+
+	if lease != nil {
+		if lease.NodeID == n.id {
+			// Our own lease, e.g. from before a restart
+			return n.renew()
+		}
+		if !lease.expired(now) {
+			return false, nil
+		}
+
+		// Move the expired lease aside; of several nodes racing for it
+		// only one rename succeeds
+		stale := filepath.Join(n.dir, fmt.Sprintf("%s.%s.stale", leaseFile, n.id))
+		if err := os.Rename(n.path(), stale); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to expire lease: %w", err)
+		}
+		os.Remove(stale)
+		n.logger.WithField("previous_leader", lease.NodeID).Warn("Cluster lease of previous leader expired")
+	}
+
+	file, err := os.OpenFile(n.path(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create lease: %w", err)
+	}
+	err = json.NewEncoder(file).Encode(n.newLease(now))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(n.path())
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+
+	n.setLeader(true)
+	n.logger.WithField("node", n.id).Info("Acquired cluster leadership")
+	return true, nil
+}
+
+// renew extends the node's own lease, failing with ErrLeaseLost if another
+// node holds it. The lease is first moved out of place, so of this renewal
+// and a standby expiring the lease only one succeeds, and the renewed lease
+// is linked in, which unlike a rename never replaces a lease another node
+// created in the meantime.
+func (n *Node) renew() (bool, error) {
+	held := filepath.Join(n.dir, fmt.Sprintf("%s.%s.renew", leaseFile, n.id))
+	if err := os.Rename(n.path(), held); err != nil {
+		if os.IsNotExist(err) {
+			n.setLeader(false)
+			return false, ErrLeaseLost
+		}
+		return false, fmt.Errorf("failed to renew lease: %w", err)
+	}
+	defer os.Remove(held)
+
+	lease, err := n.readLeaseFile(held)
+	if err != nil || lease == nil || lease.NodeID != n.id {
+		os.Link(held, n.path())
+		if err != nil {
+			return false, err
+		}
+		n.setLeader(false)
+		return false, ErrLeaseLost
+	}
+
+	data, err := json.Marshal(n.newLease(time.Now()))
+	if err != nil {
+		os.Link(held, n.path())
+		return false, err
+	}
+	tmp := filepath.Join(n.dir, fmt.Sprintf("%s.%s.tmp", leaseFile, n.id))
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		os.Link(held, n.path())
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, n.path()); err != nil {
+		if os.IsExist(err) {
+			n.setLeader(false)
+			return false, ErrLeaseLost
+		}
+		os.Link(held, n.path())
+		return false, fmt.Errorf("failed to renew lease: %w", err)
+	}
+
+	n.setLeader(true)
+	return true, nil
+}
+
+// WaitForLeadership tries to take the lease every third of its duration
+// until it succeeds or ctx is cancelled
+func (n *Node) WaitForLeadership(ctx context.Context) error {
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := n.TryAcquire()
+		if err != nil {
+			n.logger.WithError(err).Error("Failed to acquire cluster lease")
+		}
+		if acquired {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Hold renews the lease every third of its duration until ctx is
+// cancelled. It returns ErrLeaseLost if the lease could not be renewed
+// within half its duration, in which case the node must stop serving at
+// once; the other half leaves it time to do so before a standby takes over.
+func (n *Node) Hold(ctx context.Context) error {
+	ticker := time.NewTicker(n.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if _, err := n.renew(); err != nil {
+			if errors.Is(err, ErrLeaseLost) {
+				return err
+			}
+			n.logger.WithError(err).Error("Failed to renew cluster lease")
+			if time.Since(renewed) >= n.ttl/2 {
+				n.setLeader(false)
+				return ErrLeaseLost
+			}
+			continue
+		}
+		renewed = time.Now()
+	}
+}
+
+// Release gives up the lease so a standby can take over without waiting
+// for it to expire
+func (n *Node) Release() error {
+	n.setLeader(false)
+
+	lease, err := n.readLease()
+	if err != nil || lease == nil || lease.NodeID != n.id {
+		return err
+	}
+	if err := os.Remove(n.path()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	n.logger.WithField("node", n.id).Info("Released cluster leadership")
+	return nil
+}
+
+// Status describes the cluster as seen by a node
+type Status struct {
+	NodeID string `json:"node_id"`
+	Role   string `json:"role"`
+	Leader *Lease `json:"leader,omitempty"`
+}
+
+// Status reports the node's role and the current leader
+func (n *Node) Status() *Status {
+	status := &Status{NodeID: n.id, Role: RoleStandby}
+	if n.IsLeader() {
+		status.Role = RoleLeader
+	}
+	status.Leader, _ = n.Leader()
+	return status
+}
+
+// StatusHandler serves the node's Status as JSON
+func (n *Node) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n.Status())
+	})
+}
+
+// Proxy forwards requests to the leader, answering 503 while there is
+// none. Leaders normally present the same certificate as the standby, so
+// verification follows tlsConfig.
+func (n *Node) Proxy(tlsConfig *tls.Config) http.Handler {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/cluster" {
+			n.StatusHandler().ServeHTTP(w, r)
+			return
+		}
+
+		lease, err := n.Leader()
+		if err != nil || lease.NodeID == n.id {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(n.ttl.Seconds())))
+			http.Error(w, "No cluster leader available", http.StatusServiceUnavailable)
+			return
+		}
+		target, err := url.Parse(lease.Address)
+		if err != nil {
+			http.Error(w, "Invalid cluster leader address", http.StatusBadGateway)
+			return
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			n.logger.WithError(err).WithField("leader", lease.NodeID).Warn("Failed to forward request to cluster leader")
+			http.Error(w, "Cluster leader unreachable", http.StatusBadGateway)
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func (n *Node) newLease(now time.Time) *Lease {
+	return &Lease{NodeID: n.id, Address: n.address, Expires: now.Add(n.ttl)}
+}
+
+func (n *Node) readLease() (*Lease, error) {
+	return n.readLeaseFile(n.path())
+}
+
+func (n *Node) readLeaseFile(file string) (*Lease, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read lease: %w", err)
+	}
+
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		// A lease another node is still writing, or never finished writing;
+		// it lasts one lease duration from its creation
+		info, statErr := os.Stat(file)
+		if statErr != nil {
+			return nil, fmt.Errorf("failed to read lease: %w", statErr)
+		}
+		return &Lease{Expires: info.ModTime().Add(n.ttl)}, nil
+	}
+	return &lease, nil
+}
+
+func (n *Node) setLeader(leader bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.leader = leader
+}
+
+func (n *Node) path() string {
+	return filepath.Join(n.dir, leaseFile)
+}
@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNode(t *testing.T, dir, id, address string, ttl time.Duration) *Node {
+	node, err := NewNode(dir, id, address, ttl, logrus.New())
+	require.NoError(t, err)
+	return node
+}
+
+func TestLease(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestNode(t, dir, "a", "https://a:8443", 200*time.Millisecond)
+	b := newTestNode(t, dir, "b", "https://b:8443", 200*time.Millisecond)
+
+	acquired, err := a.TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.True(t, a.IsLeader())
+
+	acquired, err = b.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, acquired, "the lease is held")
+
+	lease, err := b.Leader()
+	require.NoError(t, err)
+	assert.Equal(t, "a", lease.NodeID)
+	assert.Equal(t, "https://a:8443", lease.Address)
+	assert.Equal(t, RoleStandby, b.Status().Role)
+
+	// a stops renewing; b takes over once the lease expires
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, b.WaitForLeadership(ctx))
+	assert.Equal(t, RoleLeader, b.Status().Role)
+
+	_, err = a.renew()
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.False(t, a.IsLeader())
+
+	// Releasing hands over without waiting for expiry
+	require.NoError(t, b.Release())
+	acquired, err = a.TryAcquire()
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestRenewRace(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestNode(t, dir, "a", "https://a:8443", time.Minute)
+	b := newTestNode(t, dir, "b", "https://b:8443", time.Minute)
+
+	acquired, err := a.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// b moves the lease aside as if it had expired, then a renews before b
+	// creates its own
+	require.NoError(t, os.Rename(filepath.Join(dir, leaseFile), filepath.Join(dir, "moved")))
+	_, err = a.renew()
+	assert.ErrorIs(t, err, ErrLeaseLost)
+
+	acquired, err = b.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	_, err = a.renew()
+	assert.ErrorIs(t, err, ErrLeaseLost)
+
+	lease, err := a.Leader()
+	require.NoError(t, err)
+	assert.Equal(t, "b", lease.NodeID)
+	_, err = b.renew()
+	assert.NoError(t, err)
+}
+
+func TestHold(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestNode(t, dir, "a", "https://a:8443", 150*time.Millisecond)
+	b := newTestNode(t, dir, "b", "https://b:8443", 150*time.Millisecond)
+
+	acquired, err := a.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	held := make(chan error, 1)
+	go func() { held <- a.Hold(ctx) }()
+
+	// Renewal keeps b out well past the lease duration
+	time.Sleep(400 * time.Millisecond)
+	acquired, err = b.TryAcquire()
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	cancel()
+	assert.ErrorIs(t, <-held, context.Canceled)
+}
+
+func TestProxy(t *testing.T) {
+	leader := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("leader " + r.URL.Path))
+	}))
+	defer leader.Close()
+
+	dir := t.TempDir()
+	a := newTestNode(t, dir, "a", leader.URL, time.Minute)
+	b := newTestNode(t, dir, "b", "https://b:8443", time.Minute)
+	proxy := b.Proxy(leader.Client().Transport.(*http.Transport).TLSClientConfig)
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/repositories", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "no leader yet")
+
+	acquired, err := a.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/repositories", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, _ := io.ReadAll(w.Body)
+	assert.Equal(t, "leader /api/v1/repositories", string(body))
+
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/cluster", nil))
+	assert.Contains(t, w.Body.String(), `"role":"standby"`)
+	assert.Contains(t, w.Body.String(), `"node_id":"a"`)
+}
@@ -0,0 +1,57 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"name":"repo"}`, 100)
+	handler := func(contentType string) http.Handler {
+		return Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			io.WriteString(w, body)
+		}))
+	}
+
+	t.Run("JSON Compressed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+		w := httptest.NewRecorder()
+		handler("application/json").ServeHTTP(w, req)
+
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	})
+
+	t.Run("Binary Passed Through", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler("application/octet-stream").ServeHTTP(w, req)
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, w.Body.String())
+	})
+
+	t.Run("Not Accepted", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0")
+		w := httptest.NewRecorder()
+		handler("application/json").ServeHTTP(w, req)
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, w.Body.String())
+	})
+}
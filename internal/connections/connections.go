@@ -0,0 +1,127 @@
+// Package connections tunes how a listener's clients connect: whether
+// HTTP/2 is offered, how many requests one HTTP/2 connection has handled
+// at once, TCP keep-alive and the size of request headers. Docker clients
+// pulling many layers at once, and the proxies in front of them, can need
+// these set differently from Go's defaults.
+package connections
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Config holds the connection settings of a listener. Zero values keep
+// Go's defaults: HTTP/2 offered over TLS with up to 250 streams per
+// connection, keep-alive probes every 15 seconds and 1 MB of headers.
+type Config struct {
+	// DisableHTTP2 has TLS clients use HTTP/1.1
+	DisableHTTP2 bool
+
+	// MaxConcurrentStreams caps the requests of one HTTP/2 connection
+	// handled at once; further streams the client opens wait their turn.
+	// It cannot raise the 250 streams clients are allowed to open.
+	MaxConcurrentStreams int
+
+	// KeepAlive is the period of TCP keep-alive probes on accepted
+	// connections; a negative period turns them off
+	KeepAlive time.Duration
+
+	// MaxHeaderBytes caps the size of request headers
+	MaxHeaderBytes int
+}
+
+// streamsKey is the context key of a connection's stream slots
+type streamsKey struct{}
+
+// Apply sets the settings of server. Its handler must be wrapped with
+// Middleware for MaxConcurrentStreams to take effect.
+func (c Config) Apply(server *http.Server) {
+	if c.MaxHeaderBytes > 0 {
+		server.MaxHeaderBytes = c.MaxHeaderBytes
+	}
+	if c.DisableHTTP2 {
+		// A non-nil, empty map is how net/http is told not to offer HTTP/2
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	} else if server.TLSConfig != nil && !slices.Contains(server.TLSConfig.NextProtos, "h2") {
+		// A server handed a TLS listener by Serve only offers HTTP/2 if its
+		// TLS config already does, unlike one started with ServeTLS. The
+		// config may be shared with other servers, so it is copied.
+		server.TLSConfig = server.TLSConfig.Clone()
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, "h2", "http/1.1")
+	}
+	if c.MaxConcurrentStreams > 0 {
+		server.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+			return context.WithValue(ctx, streamsKey{}, make(chan struct{}, c.MaxConcurrentStreams))
+		}
+	}
+}
+
+// Middleware has the requests of an HTTP/2 connection beyond
+// MaxConcurrentStreams wait for one of the connection's other requests to
+// finish
+func (c Config) Middleware(next http.Handler) http.Handler {
+	if c.MaxConcurrentStreams <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slots, ok := r.Context().Value(streamsKey{}).(chan struct{})
+		if !ok || r.ProtoMajor < 2 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Listener wraps l to set the keep-alive period of the TCP connections it
+// accepts. Connections already wrapped by another listener, such as one
+// reading the PROXY protocol, are left alone.
+func (c Config) Listener(l net.Listener) net.Listener {
+	if c.KeepAlive == 0 {
+		return l
+	}
+	return &keepAliveListener{Listener: l, period: c.KeepAlive}
+}
+
+// Listen wraps listen so that the listeners it opens set keep-alive as
+// Listener does
+func (c Config) Listen(listen func(network, address string) (net.Listener, error)) func(network, address string) (net.Listener, error) {
+	return func(network, address string) (net.Listener, error) {
+		l, err := listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return c.Listener(l), nil
+	}
+}
+
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+	if tcp, ok := c.(*net.TCPConn); ok {
+		if l.period < 0 {
+			tcp.SetKeepAlive(false)
+		} else {
+			tcp.SetKeepAlive(true)
+			tcp.SetKeepAlivePeriod(l.period)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,111 @@
+package connections
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves handler over TLS with the settings of c
+func startServer(t *testing.T, c Config, handler http.Handler) *httptest.Server {
+	server := httptest.NewUnstartedServer(c.Middleware(handler))
+	server.EnableHTTP2 = !c.DisableHTTP2
+	c.Apply(server.Config)
+	server.Listener = c.Listener(server.Listener)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	var running, most int32
+	release := make(chan struct{})
+	server := startServer(t, Config{MaxConcurrentStreams: 2}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		<-release
+		w.Write([]byte(r.Proto))
+	}))
+
+	client := server.Client()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if assert.NoError(t, err) {
+				assert.Equal(t, 2, resp.ProtoMajor)
+				resp.Body.Close()
+			}
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&running) == 2 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), most)
+}
+
+func TestDisableHTTP2(t *testing.T) {
+	server := startServer(t, Config{DisableHTTP2: true, KeepAlive: -1}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	resp, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, resp.ProtoMajor)
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	server := startServer(t, Config{MaxHeaderBytes: 1024, KeepAlive: 30 * time.Second}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Client().Transport.(*http.Transport).ForceAttemptHTTP2 = false
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Authorization", "Bearer "+strings.Repeat("x", 8192))
+	resp, err := server.Client().Do(req)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	}
+
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = server.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Same(t, l, Config{}.Listener(l))
+	l.Close()
+
+	listen := Config{KeepAlive: time.Second}.Listen(net.Listen)
+	l, err = listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	assert.IsType(t, &keepAliveListener{}, l)
+
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	require.NoError(t, err)
+	c.Close()
+}
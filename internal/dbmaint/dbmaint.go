@@ -0,0 +1,154 @@
+package dbmaint
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// compactTxMaxSize bounds the size of each write transaction while copying
+// into the compacted file
+const compactTxMaxSize = 64 << 20
+
+// BucketStats describes the space used by one top-level bucket
+type BucketStats struct {
+	Name      string `json:"name"`
+	Keys      int    `json:"keys"`
+	Depth     int    `json:"depth"`
+	InUse     int    `json:"in_use_bytes"`
+	Allocated int    `json:"allocated_bytes"`
+}
+
+// Report describes how much of a database file is in use
+type Report struct {
+	Path         string `json:"path"`
+	FileSize     int64  `json:"file_size"`
+	PageSize     int    `json:"page_size"`
+	FreePages    int    `json:"free_pages"`
+	PendingPages int    `json:"pending_pages"`
+	FreeBytes    int    `json:"free_bytes"`
+	Reclaimable  int64  `json:"reclaimable_bytes"`
+
+	// Fragmentation is the percentage of the file compaction would reclaim
+	Fragmentation float64        `json:"fragmentation_percent"`
+	Buckets       []*BucketStats `json:"buckets"`
+}
+
+// Inspect reports free-page and per-bucket statistics of db
+func Inspect(db *bbolt.DB) (*Report, error) {
+	report := &Report{Path: db.Path(), PageSize: db.Info().PageSize, Buckets: []*BucketStats{}}
+
+	info, err := os.Stat(db.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat database: %w", err)
+	}
+	report.FileSize = info.Size()
+
+	stats := db.Stats()
+	report.FreePages = stats.FreePageN
+	report.PendingPages = stats.PendingPageN
+	report.FreeBytes = stats.FreeAlloc
+
+	var used int64
+	err = db.View(func(tx *bbolt.Tx) error {
+		used = tx.Size()
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			s := b.Stats()
+			report.Buckets = append(report.Buckets, &BucketStats{
+				Name:      string(name),
+				Keys:      s.KeyN,
+				Depth:     s.Depth,
+				InUse:     s.BranchInuse + s.LeafInuse,
+				Allocated: s.BranchAlloc + s.LeafAlloc,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Free pages inside the data plus preallocated space past its end
+	report.Reclaimable = int64(report.FreeBytes)
+	if report.FileSize > used {
+		report.Reclaimable += report.FileSize - used
+	}
+	if report.FileSize > 0 {
+		report.Fragmentation = float64(report.Reclaimable) * 100 / float64(report.FileSize)
+	}
+
+	sort.Slice(report.Buckets, func(i, j int) bool {
+		return report.Buckets[i].Allocated > report.Buckets[j].Allocated
+	})
+	return report, nil
+}
+
+// Check verifies the consistency of every page in db and returns the
+// problems found, if any. It runs in a read transaction, so it is safe on
+// a database in use.
+func Check(db *bbolt.DB) ([]string, error) {
+	problems := []string{}
+	err := db.View(func(tx *bbolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err.Error())
+		}
+		return nil
+	})
+	return problems, err
+}
+
+// CompactResult describes a finished compaction
+type CompactResult struct {
+	SizeBefore int64         `json:"size_before"`
+	SizeAfter  int64         `json:"size_after"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Compact rewrites the database file at path into a fresh file without free
+// pages and replaces the original with it. The database must not be open
+// elsewhere; if it is, Compact fails after timeout.
+func Compact(path string, timeout time.Duration) (*CompactResult, error) {
+	start := time.Now()
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	src, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database (is the server running?): %w", err)
+	}
+	defer src.Close()
+
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	dst, err := bbolt.Open(tmp, info.Mode().Perm(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compacted database: %w", err)
+	}
+
+	if err := bbolt.Compact(dst, src, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to compact database: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to close compacted database: %w", err)
+	}
+
+	// Replace the original while still holding its lock
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat compacted database: %w", err)
+	}
+	return &CompactResult{SizeBefore: info.Size(), SizeAfter: after.Size(), Duration: time.Since(start)}, nil
+}
@@ -0,0 +1,76 @@
+package dbmaint
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestMaintenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "depot.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+
+	value := make([]byte, 1024)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("artifacts"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 4000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("key-%05d", i)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("artifacts"))
+		for i := 0; i < 3900; i++ {
+			if err := b.Delete([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	t.Run("Inspect", func(t *testing.T) {
+		report, err := Inspect(db)
+		require.NoError(t, err)
+		assert.Equal(t, path, report.Path)
+		assert.Greater(t, report.FreePages+report.PendingPages, 0)
+		assert.Greater(t, report.Fragmentation, 50.0)
+		require.Len(t, report.Buckets, 1)
+		assert.Equal(t, "artifacts", report.Buckets[0].Name)
+		assert.Equal(t, 100, report.Buckets[0].Keys)
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		problems, err := Check(db)
+		require.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("Compact", func(t *testing.T) {
+		_, err := Compact(path, 50*time.Millisecond)
+		assert.Error(t, err, "the database is still open")
+
+		require.NoError(t, db.Close())
+		result, err := Compact(path, time.Second)
+		require.NoError(t, err)
+		assert.Less(t, result.SizeAfter, result.SizeBefore)
+
+		db, err := bbolt.Open(path, 0600, nil)
+		require.NoError(t, err)
+		defer db.Close()
+		require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+			assert.Equal(t, 100, tx.Bucket([]byte("artifacts")).Stats().KeyN)
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,103 @@
+package debug
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dumpProfiles are the runtime profiles written by Dump
+var dumpProfiles = []string{"goroutine", "heap"}
+
+// CheckAddress verifies that address only listens on a loopback interface,
+// as the debug endpoints are not authenticated
+func CheckAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid debug address %q: %w", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("debug address %q must be on a loopback interface", address)
+	}
+	return nil
+}
+
+// Handler serves the net/http/pprof profiles under /debug/pprof/, expvar
+// variables at /debug/vars and, on POST /debug/dump, writes goroutine and
+// heap profiles into dumpDir
+func Handler(dumpDir string, logger *logrus.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/dump", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		files, err := Dump(dumpDir)
+		if err != nil {
+			logger.WithError(err).Error("Failed to write debug dump")
+			http.Error(w, "Failed to write debug dump", http.StatusInternalServerError)
+			return
+		}
+		logger.WithField("files", files).Info("Wrote debug dump")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"files": files})
+	})
+	return mux
+}
+
+// Dump writes the goroutine stacks and a heap profile into dir, named after
+// the current time, and returns the paths of the files written
+func Dump(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %w", err)
+	}
+
+	// Profile live objects as of the last completed collection
+	runtime.GC()
+
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	var files []string
+	for _, name := range dumpProfiles {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", stamp, name))
+		if err := writeProfile(name, path); err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+func writeProfile(name, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s profile: %w", name, err)
+	}
+	err = rpprof.Lookup(name).WriteTo(file, 0)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s profile: %w", name, err)
+	}
+	return nil
+}
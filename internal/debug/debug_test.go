@@ -0,0 +1,31 @@
+package debug
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAddress(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:6060", "[::1]:6060", "localhost:0"} {
+		assert.NoError(t, CheckAddress(address), address)
+	}
+	for _, address := range []string{"0.0.0.0:6060", ":6060", "10.0.0.1:6060", "example.com:6060", "6060"} {
+		assert.Error(t, CheckAddress(address), address)
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	files, err := Dump(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.NotZero(t, info.Size())
+	}
+}
@@ -0,0 +1,51 @@
+// Package disconnect tells uploads cut short by their client from uploads
+// that failed on the server's side. A client that goes away mid-upload is
+// not an error worth logging as one, and the request should stop
+// buffering and processing data nobody is waiting for.
+package disconnect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Body is a request body that remembers whether reading it failed because
+// the client went away
+type Body struct {
+	body io.ReadCloser
+	ctx  context.Context
+	err  error
+}
+
+// Watch replaces the body of r with one that fails as soon as the request
+// is cancelled, for instance by an HTTP/2 stream reset, and returns it.
+// Bodies over an http.MaxBytesReader limit do not count as cut short.
+func Watch(r *http.Request) *Body {
+	b := &Body{body: r.Body, ctx: r.Context()}
+	r.Body = b
+	return b
+}
+
+func (b *Body) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		b.err = err
+		return 0, err
+	}
+	n, err := b.body.Read(p)
+	var tooLarge *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &tooLarge) {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *Body) Close() error {
+	return b.body.Close()
+}
+
+// Gone reports whether the client went away before sending the whole body
+func (b *Body) Gone() bool {
+	return b.err != nil
+}
@@ -0,0 +1,52 @@
+package disconnect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, io.ErrUnexpectedEOF }
+
+func TestBody(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/", strings.NewReader("complete"))
+	body := Watch(r)
+	data, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "complete", string(data))
+	assert.False(t, body.Gone())
+
+	r = httptest.NewRequest("PUT", "/", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	body = Watch(r)
+	_, err = io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.True(t, body.Gone())
+}
+
+func TestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("PUT", "/", strings.NewReader("never read")).WithContext(ctx)
+	body := Watch(r)
+	cancel()
+	_, err := io.ReadAll(r.Body)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, body.Gone())
+}
+
+func TestTooLarge(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/", strings.NewReader("too large"))
+	body := Watch(r)
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 3)
+	_, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.False(t, body.Gone())
+}
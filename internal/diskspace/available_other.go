@@ -0,0 +1,11 @@
+//go:build windows || plan9
+
+package diskspace
+
+import "errors"
+
+// Available is not supported on this platform, so every upload is
+// admitted
+func Available(dir string) (int64, error) {
+	return 0, errors.New("disk space is not available on this platform")
+}
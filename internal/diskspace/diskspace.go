@@ -0,0 +1,98 @@
+// Package diskspace admits uploads only while the disk holding the data
+// directory has room for them. An upload that declares its size is
+// refused up front if it would eat into a configured reserve, rather than
+// failing once the disk fills up midway.
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInsufficient is returned for an upload the disk has no room for
+var ErrInsufficient = errors.New("insufficient storage")
+
+// alertInterval is how often a repository's refused uploads are alerted
+// on at most
+const alertInterval = 15 * time.Minute
+
+// Guard checks uploads against the space available in a directory. A nil
+// guard admits every upload.
+type Guard struct {
+	dir       string
+	reserve   int64
+	available func(dir string) (int64, error)
+	onLow     func(repo string, needed, available int64)
+
+	mu      sync.Mutex
+	alerted map[string]time.Time
+}
+
+// New creates a guard for uploads stored under dir that keeps reserve
+// bytes free
+func New(dir string, reserve int64) *Guard {
+	return &Guard{
+		dir:       dir,
+		reserve:   reserve,
+		available: Available,
+		alerted:   make(map[string]time.Time),
+	}
+}
+
+// SetOnLow sets the function told of uploads refused for lack of space,
+// at most once per repository every 15 minutes
+func (g *Guard) SetOnLow(fn func(repo string, needed, available int64)) {
+	g.onLow = fn
+}
+
+// Admit checks that an upload of size bytes to repo leaves the reserve
+// free. An upload of unknown size, -1, only needs the reserve to be free.
+// If the space available cannot be told the upload is admitted.
+func (g *Guard) Admit(repo string, size int64) error {
+	if g == nil {
+		return nil
+	}
+	available, err := g.available(g.dir)
+	if err != nil {
+		return nil
+	}
+	needed := g.reserve
+	if size > 0 {
+		needed += size
+	}
+	if available >= needed {
+		return nil
+	}
+
+	if g.onLow != nil && g.alert(repo) {
+		g.onLow(repo, needed, available)
+	}
+	return fmt.Errorf("%w: upload needs %d bytes free, %d available", ErrInsufficient, needed, available)
+}
+
+// alert reports whether refusals for repo are due to be alerted on again
+func (g *Guard) alert(repo string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if last, ok := g.alerted[repo]; ok && time.Since(last) < alertInterval {
+		return false
+	}
+	g.alerted[repo] = time.Now()
+	return true
+}
+
+// Low reports whether less than the reserve is available, along with the
+// space available
+func (g *Guard) Low() (bool, int64) {
+	if g == nil {
+		return false, 0
+	}
+	available, err := g.available(g.dir)
+	if err != nil {
+		return false, 0
+	}
+	return available < g.reserve, available
+}
@@ -0,0 +1,58 @@
+package diskspace
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmit(t *testing.T) {
+	g := New(t.TempDir(), 100)
+	g.available = func(string) (int64, error) { return 1000, nil }
+
+	var alerts []string
+	g.SetOnLow(func(repo string, needed, available int64) {
+		alerts = append(alerts, repo)
+		assert.Equal(t, int64(1100), needed)
+		assert.Equal(t, int64(1000), available)
+	})
+
+	assert.NoError(t, g.Admit("files", 900))
+	assert.NoError(t, g.Admit("files", -1))
+
+	err := g.Admit("files", 1000)
+	assert.ErrorIs(t, err, ErrInsufficient)
+	assert.Contains(t, err.Error(), "needs 1100 bytes free, 1000 available")
+
+	// Refusals are alerted on once per repository until the interval is up
+	assert.Error(t, g.Admit("files", 1000))
+	assert.Error(t, g.Admit("images", 1000))
+	assert.Equal(t, []string{"files", "images"}, alerts)
+
+	low, _ := g.Low()
+	assert.False(t, low)
+	g.available = func(string) (int64, error) { return 50, nil }
+	assert.ErrorIs(t, g.Admit("files", -1), ErrInsufficient)
+	low, available := g.Low()
+	assert.True(t, low)
+	assert.Equal(t, int64(50), available)
+}
+
+func TestAdmitUnknown(t *testing.T) {
+	g := New(t.TempDir(), 100)
+	g.available = func(string) (int64, error) { return 0, errors.New("unsupported") }
+	assert.NoError(t, g.Admit("files", 1<<40))
+
+	var nilGuard *Guard
+	assert.NoError(t, nilGuard.Admit("files", 1<<40))
+	low, _ := nilGuard.Low()
+	assert.False(t, low)
+}
+
+func TestAvailable(t *testing.T) {
+	available, err := Available(t.TempDir())
+	require.NoError(t, err)
+	assert.Greater(t, available, int64(0))
+}
@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// artifactType returns the type of artifact a manifest describes: its
+// artifactType, or else the media type of its config
+func (m *Manifest) artifactType() string {
+	if m.ArtifactType != "" || m.Config == nil {
+		return m.ArtifactType
+	}
+	return m.Config.MediaType
+}
+
+// isImage reports whether a manifest is a container image or a list of
+// them, as opposed to an artifact such as a signature, SBOM or Helm chart
+func (m *Manifest) isImage() bool {
+	if m.ArtifactType != "" {
+		return false
+	}
+	if m.isList() {
+		return true
+	}
+	return m.Config != nil && (m.Config.MediaType == MediaTypeDockerSchema2Config || m.Config.MediaType == MediaTypeOCIConfig)
+}
+
+// descriptor describes the manifest for an image index, such as the
+// response of the referrers API
+func (m *Manifest) descriptor() Descriptor {
+	return Descriptor{
+		MediaType:    m.MediaType,
+		Size:         int64(len(m.Raw)),
+		Digest:       digestOf(m.Raw),
+		ArtifactType: m.artifactType(),
+		Annotations:  m.Annotations,
+	}
+}
+
+// referrers returns the descriptors of the manifests of an image whose
+// subject is digest, optionally only those of one artifact type. The caller
+// must hold r.mu.
+func (r *Registry) referrers(name, digest, artifactType string) []Descriptor {
+	descriptors := []Descriptor{}
+	for reference, manifest := range r.manifests[name] {
+		// Manifests pushed by tag are also indexed by digest
+		if !strings.HasPrefix(reference, "sha256:") || manifest.Subject == nil || manifest.Subject.Digest != digest {
+			continue
+		}
+		if artifactType != "" && manifest.artifactType() != artifactType {
+			continue
+		}
+		descriptors = append(descriptors, manifest.descriptor())
+	}
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].Digest < descriptors[j].Digest
+	})
+	return descriptors
+}
+
+// handleReferrers handles GET /v2/{name}/referrers/{digest}, listing the
+// manifests that refer to digest as an OCI image index. Unknown digests
+// have no referrers rather than being an error.
+func (r *Registry) handleReferrers(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+	digest := vars["digest"]
+
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		name = target.local
+	}
+
+	if !validDigest(digest) {
+		r.writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest", nil)
+		return
+	}
+	artifactType := req.URL.Query().Get("artifactType")
+
+	r.mu.RLock()
+	descriptors := r.referrers(name, digest, artifactType)
+	r.mu.RUnlock()
+
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", MediaTypeOCIManifestList)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeOCIManifestList,
+		"manifests":     descriptors,
+	})
+}
+
+// ArtifactInfo describes a manifest of an artifact that is not a container
+// image
+type ArtifactInfo struct {
+	Image        string            `json:"image"`
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"media_type"`
+	ArtifactType string            `json:"artifact_type,omitempty"`
+	Tags         []string          `json:"tags"`
+	Subject      string            `json:"subject,omitempty"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ListArtifacts lists the manifests in a repository's registry that are not
+// container images, such as ORAS artifacts, signatures and SBOMs. If
+// artifactType is set only artifacts of that type are listed.
+func (m *Manager) ListArtifacts(repoName, artifactType string) ([]*ArtifactInfo, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	return registry.listArtifacts(artifactType), nil
+}
+
+func (r *Registry) listArtifacts(artifactType string) []*ArtifactInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	artifacts := []*ArtifactInfo{}
+	for image, repoManifests := range r.manifests {
+		byDigest := make(map[string]*ArtifactInfo)
+		for reference, manifest := range repoManifests {
+			if manifest.isImage() || (artifactType != "" && manifest.artifactType() != artifactType) {
+				continue
+			}
+			digest := digestOf(manifest.Raw)
+			info, exists := byDigest[digest]
+			if !exists {
+				info = &ArtifactInfo{
+					Image:        image,
+					Digest:       digest,
+					MediaType:    manifest.MediaType,
+					ArtifactType: manifest.artifactType(),
+					Tags:         []string{},
+					Size:         manifest.imageSize(),
+					Annotations:  manifest.Annotations,
+				}
+				if manifest.Subject != nil {
+					info.Subject = manifest.Subject.Digest
+				}
+				byDigest[digest] = info
+				artifacts = append(artifacts, info)
+			}
+			if !strings.HasPrefix(reference, "sha256:") {
+				info.Tags = append(info.Tags, reference)
+			}
+		}
+	}
+
+	for _, info := range artifacts {
+		sort.Strings(info.Tags)
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		if artifacts[i].Image != artifacts[j].Image {
+			return artifacts[i].Image < artifacts[j].Image
+		}
+		return artifacts[i].Digest < artifacts[j].Digest
+	})
+	return artifacts
+}
@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestORASArtifacts(t *testing.T) {
+	registry := NewRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+
+	serve := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(data string) string {
+		w := serve("POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		digest := digestOf([]byte(data))
+		w = serve("PUT", w.Header().Get("Location")+"?digest="+digest, "application/octet-stream", data)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digest
+	}
+
+	// An image for the artifacts to refer to
+	config := pushBlob(`{"architecture":"amd64"}`)
+	image := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":24,"digest":%q},"layers":[]}`,
+		MediaTypeOCIManifest, MediaTypeOCIConfig, config)
+	w := serve("PUT", "/v2/app/manifests/1.0", MediaTypeOCIManifest, image)
+	require.Equal(t, http.StatusCreated, w.Code)
+	imageDigest := w.Header().Get("Docker-Content-Digest")
+
+	// An ORAS artifact with an unknown config type, and a signature with the
+	// empty config that refers to the image
+	empty := pushBlob("{}")
+	emptyFile := pushBlob("")
+	bundle := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.acme.config.v1+json","size":2,"digest":%q},`+
+		`"layers":[{"mediaType":"text/plain","size":0,"digest":%q,"annotations":{"org.opencontainers.image.title":"empty.txt"}}],`+
+		`"annotations":{ "b": "2",  "a": "1" }}`,
+		MediaTypeOCIManifest, empty, emptyFile)
+	w = serve("PUT", "/v2/app/manifests/bundle", MediaTypeOCIManifest, bundle)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("OCI-Subject"))
+	bundleDigest := w.Header().Get("Docker-Content-Digest")
+
+	signature := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"artifactType":"application/vnd.example.signature",`+
+		`"config":{"mediaType":%q,"size":2,"digest":%q},"layers":[],`+
+		`"subject":{"mediaType":%q,"size":%d,"digest":%q},"annotations":{"created":"now"}}`,
+		MediaTypeOCIManifest, MediaTypeOCIEmpty, empty, MediaTypeOCIManifest, len(image), imageDigest)
+	signatureDigest := digestOf([]byte(signature))
+	w = serve("PUT", "/v2/app/manifests/"+signatureDigest, MediaTypeOCIManifest, signature)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, imageDigest, w.Header().Get("OCI-Subject"))
+
+	t.Run("Byte-for-byte", func(t *testing.T) {
+		w := serve("GET", "/v2/app/manifests/bundle", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, bundle, w.Body.String())
+		assert.Equal(t, bundleDigest, w.Header().Get("Docker-Content-Digest"))
+
+		w = serve("GET", "/v2/app/blobs/"+emptyFile, "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0", w.Header().Get("Content-Length"))
+	})
+
+	t.Run("Referrers", func(t *testing.T) {
+		var index Manifest
+		w := serve("GET", "/v2/app/referrers/"+imageDigest, "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, MediaTypeOCIManifestList, w.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &index))
+		require.Len(t, index.Manifests, 1)
+		assert.Equal(t, signatureDigest, index.Manifests[0].Digest)
+		assert.Equal(t, "application/vnd.example.signature", index.Manifests[0].ArtifactType)
+		assert.Equal(t, map[string]string{"created": "now"}, index.Manifests[0].Annotations)
+
+		w = serve("GET", "/v2/app/referrers/"+imageDigest+"?artifactType=application/vnd.example.sbom", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "artifactType", w.Header().Get("OCI-Filters-Applied"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &index))
+		assert.Empty(t, index.Manifests)
+
+		w = serve("GET", "/v2/app/referrers/"+bundleDigest, "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`, w.Body.String())
+
+		assert.Equal(t, http.StatusBadRequest, serve("GET", "/v2/app/referrers/latest", "", "").Code)
+	})
+
+	t.Run("List artifacts", func(t *testing.T) {
+		artifacts := registry.listArtifacts("")
+		require.Len(t, artifacts, 2)
+		byDigest := map[string]*ArtifactInfo{}
+		for _, artifact := range artifacts {
+			byDigest[artifact.Digest] = artifact
+		}
+		require.Contains(t, byDigest, bundleDigest)
+		assert.Equal(t, "application/vnd.acme.config.v1+json", byDigest[bundleDigest].ArtifactType)
+		assert.Equal(t, []string{"bundle"}, byDigest[bundleDigest].Tags)
+		require.Contains(t, byDigest, signatureDigest)
+		assert.Equal(t, imageDigest, byDigest[signatureDigest].Subject)
+		assert.Empty(t, byDigest[signatureDigest].Tags)
+
+		artifacts = registry.listArtifacts("application/vnd.example.signature")
+		require.Len(t, artifacts, 1)
+		assert.Equal(t, signatureDigest, artifacts[0].Digest)
+	})
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBlobExistsDigests bounds the digests one existence check may ask
+// about, and maxBlobExistsBody the size of its request
+const (
+	maxBlobExistsDigests = 1000
+	maxBlobExistsBody    = 1 << 20
+)
+
+// blobStatus reports whether a blob is stored, with its size if it is
+type blobStatus struct {
+	Digest string `json:"digest"`
+	Exists bool   `json:"exists"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// handleBlobsExist handles POST /v2/{name}/blobs/exists, checking a list of
+// digests in one round trip so clients and replication jobs need not send a
+// HEAD per layer. Blobs are reported in the order asked for. Proxy
+// repositories only report the blobs they have cached.
+func (r *Registry) handleBlobsExist(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		name = target.local
+	}
+
+	var request struct {
+		Digests []string `json:"digests"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBlobExistsBody)).Decode(&request); err != nil {
+		r.writeError(w, http.StatusBadRequest, "UNSUPPORTED", "invalid request body: expected {\"digests\": [...]}", nil)
+		return
+	}
+	if len(request.Digests) > maxBlobExistsDigests {
+		r.writeError(w, http.StatusBadRequest, "UNSUPPORTED", fmt.Sprintf("at most %d digests may be checked at once", maxBlobExistsDigests), nil)
+		return
+	}
+
+	blobs := make([]blobStatus, 0, len(request.Digests))
+	for _, digest := range request.Digests {
+		if !validDigest(digest) {
+			r.writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest "+digest, map[string]interface{}{"digest": digest})
+			return
+		}
+		status := blobStatus{Digest: digest}
+		if info, err := r.storage.Stat(name, path.Join("blobs", digest)); err == nil {
+			status.Exists, status.Size = true, info.Size
+		}
+		blobs = append(blobs, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]blobStatus{"blobs": blobs})
+}
@@ -0,0 +1,49 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestBlobsExist(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	apps, _ := manager.GetRegistry("apps")
+
+	config := `{"architecture":"amd64"}`
+	pushImage(t, apps, "team/web", "1.0", config)
+	stored := digestOf([]byte(config))
+	missing := digestOf([]byte("missing"))
+
+	w := serveRegistry(apps, "POST", "/v2/team/web/blobs/exists", "application/json",
+		fmt.Sprintf(`{"digests":[%q,%q]}`, missing, stored))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"blobs":[{"digest":%q,"exists":false},{"digest":%q,"exists":true,"size":%d}]}`,
+		missing, stored, len(config)), w.Body.String())
+
+	// Blobs of other images are not reported
+	w = serveRegistry(apps, "POST", "/v2/other/blobs/exists", "application/json", fmt.Sprintf(`{"digests":[%q]}`, stored))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"blobs":[{"digest":%q,"exists":false}]}`, stored), w.Body.String())
+
+	w = serveRegistry(apps, "POST", "/v2/team/web/blobs/exists", "application/json", `{"digests":["sha256:nope"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "DIGEST_INVALID")
+
+	w = serveRegistry(apps, "POST", "/v2/team/web/blobs/exists", "application/json",
+		`{"digests":[`+strings.Repeat(fmt.Sprintf("%q,", stored), maxBlobExistsDigests)+fmt.Sprintf("%q", stored)+`]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveRegistry(apps, "POST", "/v2/team/web/blobs/exists", "application/json", `not json`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,273 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrPlatformRequired is returned when comparing a manifest list without
+// choosing which of its images to compare
+var ErrPlatformRequired = errors.New("manifest list requires a platform")
+
+// Statuses of a layer in an image diff
+const (
+	LayerUnchanged = "unchanged"
+	LayerChanged   = "changed"
+	LayerAdded     = "added"
+	LayerRemoved   = "removed"
+)
+
+// configFields are the top-level fields of an image config compared besides
+// those of its runtime config
+var configFields = []string{"architecture", "os", "variant", "author"}
+
+// ImageDiff lists what changed from one image to another
+type ImageDiff struct {
+	From     *ComparedImage `json:"from"`
+	To       *ComparedImage `json:"to"`
+	Platform string         `json:"platform,omitempty"`
+	// SizeDelta is the size of the config and layers of To less that of From
+	SizeDelta int64 `json:"size_delta"`
+	// SharedLayers counts the leading layers both images have in common,
+	// such as those of a shared base image
+	SharedLayers int            `json:"shared_layers"`
+	Layers       []LayerChange  `json:"layers"`
+	Config       []ConfigChange `json:"config"`
+	Labels       []LabelChange  `json:"labels"`
+}
+
+// ComparedImage describes one side of an image diff. For a manifest list
+// it is the image of the platform compared.
+type ComparedImage struct {
+	Image        string `json:"image"`
+	Reference    string `json:"reference"`
+	Digest       string `json:"digest"`
+	ConfigDigest string `json:"config_digest,omitempty"`
+	Size         int64  `json:"size"`
+	Layers       int    `json:"layers"`
+}
+
+// LayerChange compares the layers of two images at the same position
+type LayerChange struct {
+	Index    int    `json:"index"`
+	Status   string `json:"status"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	FromSize int64  `json:"from_size,omitempty"`
+	ToSize   int64  `json:"to_size,omitempty"`
+}
+
+// ConfigChange is a field of the image config that differs, such as
+// config.Env or architecture, with its JSON values. A missing value was
+// not set.
+type ConfigChange struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"`
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+// LabelChange is a config label or manifest annotation that differs
+type LabelChange struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// CompareImages compares image:reference with another image of a
+// repository's registry. Manifest lists are compared by the images they
+// hold for platform, which is required if either side is a list.
+func (m *Manager) CompareImages(repoName, fromImage, fromReference, toImage, toReference string, platform *Platform) (*ImageDiff, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	return registry.compareImages(fromImage, fromReference, toImage, toReference, platform)
+}
+
+func (r *Registry) compareImages(fromImage, fromReference, toImage, toReference string, platform *Platform) (*ImageDiff, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	from, fromSummary, err := r.comparedImage(fromImage, fromReference, platform)
+	if err != nil {
+		return nil, err
+	}
+	to, toSummary, err := r.comparedImage(toImage, toReference, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ImageDiff{
+		From:      fromSummary,
+		To:        toSummary,
+		SizeDelta: toSummary.Size - fromSummary.Size,
+		Layers:    compareLayers(from.Layers, to.Layers),
+		Config:    compareConfigs(r.readConfig(fromImage, from), r.readConfig(toImage, to)),
+		Labels:    compareLabels(r.imageLabels(fromImage, from), r.imageLabels(toImage, to)),
+	}
+	if platform != nil {
+		diff.Platform = platform.String()
+	}
+	for _, layer := range diff.Layers {
+		if layer.Status != LayerUnchanged {
+			break
+		}
+		diff.SharedLayers++
+	}
+	return diff, nil
+}
+
+// comparedImage returns the image manifest of image:reference, resolving a
+// manifest list to the image of platform. The caller must hold r.mu.
+func (r *Registry) comparedImage(image, reference string, platform *Platform) (*Manifest, *ComparedImage, error) {
+	manifest, exists := r.manifests[image][reference]
+	if !exists {
+		return nil, nil, fmt.Errorf("%w: %s:%s", ErrManifestNotFound, image, reference)
+	}
+	digest := digestOf(manifest.Raw)
+	if manifest.isList() {
+		if platform == nil {
+			return nil, nil, fmt.Errorf("%w: %s:%s", ErrPlatformRequired, image, reference)
+		}
+		child, ok := manifest.selectPlatform(platform)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w %s in %s:%s", ErrPlatformNotFound, platform, image, reference)
+		}
+		if manifest, exists = r.manifests[image][child.Digest]; !exists {
+			return nil, nil, fmt.Errorf("%w: %s", ErrManifestNotFound, child.Digest)
+		}
+		digest = child.Digest
+	}
+
+	summary := &ComparedImage{
+		Image:     image,
+		Reference: reference,
+		Digest:    digest,
+		Size:      manifest.imageSize(),
+		Layers:    len(manifest.Layers),
+	}
+	if manifest.Config != nil {
+		summary.ConfigDigest = manifest.Config.Digest
+	}
+	return manifest, summary, nil
+}
+
+func compareLayers(from, to []Descriptor) []LayerChange {
+	changes := []LayerChange{}
+	for i := 0; i < len(from) || i < len(to); i++ {
+		change := LayerChange{Index: i}
+		if i < len(from) {
+			change.From, change.FromSize = from[i].Digest, from[i].Size
+		}
+		if i < len(to) {
+			change.To, change.ToSize = to[i].Digest, to[i].Size
+		}
+		switch {
+		case i >= len(from):
+			change.Status = LayerAdded
+		case i >= len(to):
+			change.Status = LayerRemoved
+		case change.From == change.To:
+			change.Status = LayerUnchanged
+		default:
+			change.Status = LayerChanged
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// readConfig returns the compared fields of an image's config, which is
+// empty if the config is not stored. Labels are compared on their own.
+// The caller must hold r.mu.
+func (r *Registry) readConfig(image string, manifest *Manifest) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if manifest.Config == nil || (manifest.Config.MediaType != MediaTypeDockerSchema2Config && manifest.Config.MediaType != MediaTypeOCIConfig) {
+		return fields
+	}
+	data, err := r.readBlob(image, manifest.Config.Digest)
+	if err != nil {
+		return fields
+	}
+	var config map[string]json.RawMessage
+	if json.Unmarshal(data, &config) != nil {
+		return fields
+	}
+
+	for _, field := range configFields {
+		if value, ok := config[field]; ok {
+			fields[field] = value
+		}
+	}
+	var runtime map[string]json.RawMessage
+	if json.Unmarshal(config["config"], &runtime) == nil {
+		for field, value := range runtime {
+			if field != "Labels" && string(value) != "null" {
+				fields["config."+field] = value
+			}
+		}
+	}
+	return fields
+}
+
+func compareConfigs(from, to map[string]json.RawMessage) []ConfigChange {
+	names := make(map[string]bool, len(from)+len(to))
+	for field := range from {
+		names[field] = true
+	}
+	for field := range to {
+		names[field] = true
+	}
+
+	changes := []ConfigChange{}
+	for _, field := range sortedNames(names) {
+		before, after := compactJSON(from[field]), compactJSON(to[field])
+		if !bytes.Equal(before, after) {
+			changes = append(changes, ConfigChange{Field: field, From: before, To: after})
+		}
+	}
+	return changes
+}
+
+func compareLabels(from, to map[string]string) []LabelChange {
+	names := make(map[string]bool, len(from)+len(to))
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+
+	changes := []LabelChange{}
+	for _, name := range sortedNames(names) {
+		before, existed := from[name]
+		after, exists := to[name]
+		if before != after || existed != exists {
+			changes = append(changes, LabelChange{Name: name, From: before, To: after})
+		}
+	}
+	return changes
+}
+
+func compactJSON(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, value) != nil {
+		return value
+	}
+	return buf.Bytes()
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestCompareImages(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	registry, _ := manager.GetRegistry("docker")
+
+	serve := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(content string) string {
+		w := serve("POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		w = serve("PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(content)), "", content)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(content))
+	}
+	pushImage := func(tag, config string, layers ...string) {
+		var descriptors []string
+		for _, layer := range layers {
+			descriptors = append(descriptors, fmt.Sprintf(`{"mediaType":%q,"size":%d,"digest":%q}`, MediaTypeOCILayer, len(layer), pushBlob(layer)))
+		}
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[%s]}`,
+			MediaTypeOCIManifest, MediaTypeOCIConfig, len(config), pushBlob(config), strings.Join(descriptors, ","))
+		w := serve("PUT", "/v2/app/manifests/"+tag, MediaTypeOCIManifest, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	pushImage("1.0", `{"architecture":"amd64","os":"linux","config":{"Env":["VERSION=1.0"],"Cmd":["app"],"Labels":{"team":"core","stage":"beta"}}}`,
+		"base layer", "app 1.0")
+	pushImage("2.0", `{"architecture":"amd64","os":"linux","config":{"Env":["VERSION=2.0"],"Cmd":["app"],"Labels":{"team":"core","release":"true"}}}`,
+		"base layer", "app 2.0 build", "assets")
+
+	diff, err := manager.CompareImages("docker", "app", "1.0", "app", "2.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, diff.From.Layers)
+	assert.Equal(t, 3, diff.To.Layers)
+	assert.Equal(t, diff.To.Size-diff.From.Size, diff.SizeDelta)
+	assert.Equal(t, 1, diff.SharedLayers)
+
+	require.Len(t, diff.Layers, 3)
+	assert.Equal(t, LayerUnchanged, diff.Layers[0].Status)
+	assert.Equal(t, LayerChanged, diff.Layers[1].Status)
+	assert.Equal(t, digestOf([]byte("app 1.0")), diff.Layers[1].From)
+	assert.Equal(t, digestOf([]byte("app 2.0 build")), diff.Layers[1].To)
+	assert.Equal(t, LayerAdded, diff.Layers[2].Status)
+	assert.Empty(t, diff.Layers[2].From)
+
+	require.Len(t, diff.Config, 1)
+	assert.Equal(t, "config.Env", diff.Config[0].Field)
+	assert.JSONEq(t, `["VERSION=1.0"]`, string(diff.Config[0].From))
+	assert.JSONEq(t, `["VERSION=2.0"]`, string(diff.Config[0].To))
+
+	assert.Equal(t, []LabelChange{
+		{Name: "release", To: "true"},
+		{Name: "stage", From: "beta"},
+	}, diff.Labels)
+
+	// An image compared with itself has nothing to report
+	diff, err = manager.CompareImages("docker", "app", "1.0", "app", "1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, diff.SharedLayers)
+	assert.Empty(t, diff.Config)
+	assert.Empty(t, diff.Labels)
+
+	_, err = manager.CompareImages("docker", "app", "1.0", "app", "3.0", nil)
+	assert.ErrorIs(t, err, ErrManifestNotFound)
+}
@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/internal/diskspace"
+)
+
+// SetDiskGuard sets the guard that blob uploads to registries started
+// afterwards must pass; a nil guard admits them all
+func (m *Manager) SetDiskGuard(guard *diskspace.Guard) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.diskGuard = guard
+}
+
+// SetDiskGuard refuses the registry's blob uploads that the disk has no
+// room for before any of the blob is stored
+func (r *Registry) SetDiskGuard(guard *diskspace.Guard) {
+	if guard == nil {
+		return
+	}
+	r.router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !isBlobUpload(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			if err := guard.Admit(r.repo.Name, req.ContentLength); err != nil {
+				r.writeError(w, http.StatusInsufficientStorage, "UNAVAILABLE", "insufficient storage for this upload", nil)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+}
+
+// isBlobUpload reports whether a request starts, sends a chunk of or
+// completes a blob upload
+func isBlobUpload(req *http.Request) bool {
+	return req.Method != http.MethodGet && req.Method != http.MethodHead && strings.Contains(req.URL.Path, "/blobs/uploads")
+}
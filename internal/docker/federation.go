@@ -0,0 +1,308 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// changeFeedMargin is how far before a change feed was read the next read
+// starts. File times come from a coarse clock, so a tag written just after
+// the feed was read may look older; listing a tag twice is harmless.
+const changeFeedMargin = time.Second
+
+// TagChange is a tag pushed, or moved to another manifest, in a repository
+type TagChange struct {
+	Image     string    `json:"image"`
+	Tag       string    `json:"tag"`
+	Digest    string    `json:"digest"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChangeFeed lists the tags of a repository changed since a point in time.
+// Until is the time to ask for changes since next, on the clock of the
+// depot that answered.
+type ChangeFeed struct {
+	Repository string      `json:"repository"`
+	Since      time.Time   `json:"since"`
+	Until      time.Time   `json:"until"`
+	Changes    []TagChange `json:"changes"`
+}
+
+// FederationReport describes a sync of a proxy repository from the change
+// feeds of its federated upstreams
+type FederationReport struct {
+	Repository string   `json:"repository"`
+	Synced     []string `json:"synced"`
+	Problems   []string `json:"problems"`
+}
+
+// Changes returns the tags of a repository changed since a time, oldest
+// first, telling when a tag changed by when its tag file was written. A
+// zero since lists every tag. Deleted tags are not listed.
+func (m *Manager) Changes(repoName string, since time.Time) (*ChangeFeed, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	feed := &ChangeFeed{Repository: repoName, Since: since, Until: time.Now().Add(-changeFeedMargin).UTC(), Changes: []TagChange{}}
+	for _, tag := range registry.taggedManifests() {
+		info, err := registry.storage.Stat(tag.Image, path.Join(tagsDir, tag.Tag))
+		if err != nil || info.ModTime.Before(since) {
+			continue
+		}
+		tag.UpdatedAt = info.ModTime.UTC()
+		feed.Changes = append(feed.Changes, tag)
+	}
+
+	sort.Slice(feed.Changes, func(i, j int) bool {
+		a, b := feed.Changes[i], feed.Changes[j]
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+		return imageReference(a.Image, a.Tag) < imageReference(b.Image, b.Tag)
+	})
+	return feed, nil
+}
+
+// taggedManifests returns every tag of the registry with its digest
+func (r *Registry) taggedManifests() []TagChange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var tags []TagChange
+	for image, manifests := range r.manifests {
+		for reference, manifest := range manifests {
+			if !strings.HasPrefix(reference, "sha256:") {
+				tags = append(tags, TagChange{Image: image, Tag: reference, Digest: digestOf(manifest.Raw)})
+			}
+		}
+	}
+	return tags
+}
+
+// MintFederationToken creates a token for another depot to mirror every
+// image of a repository and read its change feed until expires
+func (m *Manager) MintFederationToken(repoName string, expires time.Time) (*PullToken, error) {
+	m.mu.RLock()
+	tokens := m.pullTokens
+	m.mu.RUnlock()
+	if tokens == nil {
+		return nil, errors.New("pull tokens are not enabled")
+	}
+	if _, exists := m.GetRegistry(repoName); !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	return tokens.MintFederation(repoName, expires)
+}
+
+// CheckFederationToken verifies that the token sent with a request is a
+// federation token of a repository
+func (m *Manager) CheckFederationToken(repoName string, req *http.Request) error {
+	m.mu.RLock()
+	tokens := m.pullTokens
+	m.mu.RUnlock()
+
+	token := pullTokenFrom(req)
+	switch {
+	case tokens == nil:
+		return ErrInvalidPullToken
+	case token == "":
+		return ErrNoPullToken
+	}
+	claims, err := tokens.parse(token, repoName)
+	if err != nil {
+		return err
+	}
+	if !claims.Federation {
+		return ErrPullTokenScope
+	}
+	return nil
+}
+
+// SyncFederation fetches into a proxy repository the tags changed on the
+// depot repositories its upstreams federate from, with their manifests and
+// blobs, so they are served without waiting for the upstream on first
+// pull. Each upstream's feed is followed from where the last complete sync
+// left off; after a restart every tag is checked again, skipping those
+// already cached at the same digest.
+func (m *Manager) SyncFederation(ctx context.Context, repoName string) (*FederationReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy == nil {
+		return nil, ErrNotProxy
+	}
+
+	report := &FederationReport{Repository: repoName, Synced: []string{}, Problems: []string{}}
+	federated := false
+	for _, ns := range registry.proxy.namespaces {
+		for _, u := range registry.proxy.upstreams[ns] {
+			if u.repo == "" {
+				continue
+			}
+			federated = true
+			if err := registry.syncUpstream(ctx, u, report); err != nil {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s/%s: %v", u.url, u.repo, err))
+			}
+		}
+	}
+	if !federated {
+		return nil, errors.New("no upstream of the repository federates from a depot repository")
+	}
+
+	registry.logger.WithField("repository", repoName).Infof("Synced %d tags from federated upstreams", len(report.Synced))
+	return report, nil
+}
+
+// syncUpstream fetches the tags changed on a federated upstream since its
+// last complete sync
+func (r *Registry) syncUpstream(ctx context.Context, u *upstream, report *FederationReport) error {
+	key := u.url + " " + u.repo
+	r.proxy.mu.Lock()
+	since := r.proxy.synced[key]
+	r.proxy.mu.Unlock()
+
+	feed, err := u.changes(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	complete := true
+	for _, change := range feed.Changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// The feed names storage paths, so it is not trusted
+		if !validImageName(change.Image) {
+			complete = false
+			report.Problems = append(report.Problems, fmt.Sprintf("invalid image name %q", change.Image))
+			continue
+		}
+		target := &proxyTarget{upstreams: []*upstream{u}, local: u.namespace + "/" + change.Image, remote: change.Image}
+		synced, err := r.syncTag(target, change)
+		if err != nil {
+			complete = false
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", imageReference(target.local, change.Tag), err))
+			continue
+		}
+		if synced {
+			report.Synced = append(report.Synced, imageReference(target.local, change.Tag))
+		}
+	}
+
+	// Failed tags are tried again from the same point next time
+	if complete {
+		r.proxy.mu.Lock()
+		r.proxy.synced[key] = feed.Until
+		r.proxy.mu.Unlock()
+	}
+	return nil
+}
+
+// syncTag caches the manifest a changed tag points at with its blobs, and
+// then moves the tag, reporting whether anything was fetched
+func (r *Registry) syncTag(target *proxyTarget, change TagChange) (bool, error) {
+	if !validDigest(change.Digest) {
+		return false, fmt.Errorf("invalid digest %q", change.Digest)
+	}
+	if !validTag(change.Tag) {
+		return false, fmt.Errorf("invalid tag %q", change.Tag)
+	}
+	if cached, exists := r.getManifest(target.local, change.Tag); exists && digestOf(cached.Raw) == change.Digest {
+		return false, nil
+	}
+
+	if err := r.syncManifest(target, change.Digest); err != nil {
+		return false, err
+	}
+	manifest, _ := r.getManifest(target.local, change.Digest)
+
+	// Labels are read from the image config, which was fetched after the
+	// manifest was first indexed
+	tagged := *manifest
+	tagged.labels = nil
+	r.putManifest(target.local, change.Tag, &tagged)
+	if err := r.storeTag(target.local, change.Tag, change.Digest); err != nil {
+		return false, err
+	}
+	if r.proxy != nil && r.proxy.manifestTTL > 0 {
+		r.proxy.markChecked(imageReference(target.local, change.Tag))
+	}
+	return true, nil
+}
+
+// syncManifest caches a manifest by digest with its config and layers, or
+// for a manifest list every image it lists
+func (r *Registry) syncManifest(target *proxyTarget, digest string) error {
+	if err := r.proxyManifest(target, digest); err != nil {
+		return err
+	}
+	manifest, exists := r.getManifest(target.local, digest)
+	if !exists {
+		return fmt.Errorf("manifest %s was not cached", digest)
+	}
+
+	if manifest.isList() {
+		for _, child := range manifest.Manifests {
+			if err := r.syncManifest(target, child.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	blobs := manifest.Layers
+	if manifest.Config != nil {
+		blobs = append([]Descriptor{*manifest.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		// Foreign layers are fetched from their URLs by clients
+		if len(blob.URLs) > 0 {
+			continue
+		}
+		if err := r.proxyBlob(target, blob.Digest); err != nil {
+			return fmt.Errorf("blob %s: %w", blob.Digest, err)
+		}
+	}
+	return nil
+}
+
+// changes reads the change feed of the depot repository the upstream
+// federates from
+func (u *upstream) changes(ctx context.Context, since time.Time) (*ChangeFeed, error) {
+	target := u.url + "/api/v1/repositories/" + url.PathEscape(u.repo) + "/changes"
+	if !since.IsZero() {
+		target += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if username, password := u.credentials(); username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := u.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("change feed responded %s", resp.Status)
+	}
+	var feed ChangeFeed
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid change feed: %w", err)
+	}
+	return &feed, nil
+}
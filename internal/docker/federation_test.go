@@ -0,0 +1,127 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestFederation(t *testing.T) {
+	// The central depot serves a repository that requires pull tokens on
+	// its main port, with the change feed its API would serve
+	central := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	central.SetPullTokens(NewPullTokens([]byte("key")))
+	central.SetPathRouting(true)
+	require.NoError(t, central.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{RequirePullToken: true}))
+	defer central.StopAll()
+	apps, _ := central.GetRegistry("apps")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/repositories/apps/changes" {
+			central.MainPortHandler().ServeHTTP(w, req)
+			return
+		}
+		if err := central.CheckFederationToken("apps", req); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		since, _ := time.Parse(time.RFC3339Nano, req.URL.Query().Get("since"))
+		feed, err := central.Changes("apps", since)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(feed)
+	}))
+	defer server.Close()
+
+	webDigest := pushImage(t, apps, "web", "1.0", `{"config":{"Labels":{"team":"web"}}}`)
+	pushImage(t, apps, "tools/lint", "latest", `{"architecture":"amd64"}`)
+
+	pullToken, err := central.MintPullToken("apps", "web", "", false, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/api/v1/repositories/apps/changes", nil)
+	req.SetBasicAuth("edge", pullToken.Token)
+	assert.ErrorIs(t, central.CheckFederationToken("apps", req), ErrPullTokenScope)
+	token, err := central.MintFederationToken("apps", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, token.Federation)
+
+	edgeStore := storage.NewFileStorage(t.TempDir())
+	edge := NewManager(edgeStore, nil, logrus.New())
+	require.NoError(t, edge.StartRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{
+		Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+			{Namespace: "central", URL: server.URL, Username: "edge", Password: token.Token, Repository: "apps"},
+		}},
+	}))
+	defer edge.StopAll()
+	mirror, _ := edge.GetRegistry("mirror")
+
+	report, err := edge.SyncFederation(context.Background(), "mirror")
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+	assert.ElementsMatch(t, []string{"central/web:1.0", "central/tools/lint:latest"}, report.Synced)
+
+	// Manifests, blobs and labels are cached before the first pull
+	manifest, exists := mirror.getManifest("central/web", "1.0")
+	require.True(t, exists)
+	assert.Equal(t, webDigest, digestOf(manifest.Raw))
+	cached, err := edgeStore.Exists("central/web", path.Join(blobsDir, manifest.Config.Digest))
+	require.NoError(t, err)
+	assert.True(t, cached)
+	assert.Len(t, edge.FindImages("mirror", map[string]string{"team": "web"}), 1)
+
+	// Only what changed since is synced again
+	report, err = edge.SyncFederation(context.Background(), "mirror")
+	require.NoError(t, err)
+	assert.Empty(t, report.Synced)
+	newDigest := pushImage(t, apps, "web", "1.0", `{"config":{"Labels":{"team":"platform"}}}`)
+	report, err = edge.SyncFederation(context.Background(), "mirror")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"central/web:1.0"}, report.Synced)
+	manifest, _ = mirror.getManifest("central/web", "1.0")
+	assert.Equal(t, newDigest, digestOf(manifest.Raw))
+
+	// Pulls through the mirror are federated from the repository too
+	pushImage(t, apps, "api", "2.0", `{"architecture":"arm64"}`)
+	assert.Equal(t, http.StatusOK, serveRegistry(mirror, "GET", "/v2/api/manifests/2.0", "", "").Code)
+
+	_, err = central.SyncFederation(context.Background(), "apps")
+	assert.ErrorIs(t, err, ErrNotProxy)
+}
+
+func TestFederationInvalidNames(t *testing.T) {
+	digest := digestOf([]byte("{}"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(ChangeFeed{Repository: "apps", Until: time.Now(), Changes: []TagChange{
+			{Image: "../../escape", Tag: "1.0", Digest: digest},
+			{Image: "web", Tag: "../../escape", Digest: digest},
+		}})
+	}))
+	defer server.Close()
+
+	store := storage.NewFileStorage(t.TempDir())
+	edge := NewManager(store, nil, logrus.New())
+	require.NoError(t, edge.StartRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{
+		Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+			{Namespace: "central", URL: server.URL, Repository: "apps"},
+		}},
+	}))
+	defer edge.StopAll()
+
+	// Neither change is fetched, and both are reported
+	report, err := edge.SyncFederation(context.Background(), "mirror")
+	require.NoError(t, err)
+	assert.Empty(t, report.Synced)
+	require.Len(t, report.Problems, 2)
+	assert.Contains(t, report.Problems[0], "invalid image name")
+	assert.Contains(t, report.Problems[1], "invalid tag")
+}
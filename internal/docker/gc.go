@@ -0,0 +1,219 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// gcGracePeriod keeps blobs stored recently from garbage collection, as a
+// push uploads its blobs before the manifest referring to them
+const gcGracePeriod = time.Hour
+
+// GCReport describes a garbage collection of a registry's blobs. Images
+// whose manifests could not all be read are skipped rather than risk
+// deleting blobs they refer to.
+type GCReport struct {
+	Repository string   `json:"repository"`
+	Deleted    []string `json:"deleted"`
+	Freed      int64    `json:"freed"`
+	Skipped    []string `json:"skipped"`
+}
+
+// ScrubReport describes a check of a registry's stored manifests and blobs
+// against their digests
+type ScrubReport struct {
+	Repository string   `json:"repository"`
+	Checked    int      `json:"checked"`
+	Corrupt    []string `json:"corrupt"`
+}
+
+// CollectAllGarbage collects garbage in every running registry
+func (m *Manager) CollectAllGarbage(ctx context.Context) ([]*GCReport, error) {
+	reports := []*GCReport{}
+	for _, name := range m.registryNames() {
+		report, err := m.CollectGarbage(ctx, name)
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// CollectGarbage deletes the blobs of a registry's images that no stored
+// manifest refers to any more, such as the layers of deleted manifests and
+// of pushes that never finished
+func (m *Manager) CollectGarbage(ctx context.Context, repoName string) (*GCReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	report := &GCReport{Repository: repoName, Deleted: []string{}, Skipped: []string{}}
+	cutoff := time.Now().Add(-gcGracePeriod)
+	images := registry.imageNames()
+	sort.Strings(images)
+	for _, image := range images {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if err := registry.collectGarbage(image, cutoff, report); err != nil {
+			return report, fmt.Errorf("%s: %w", image, err)
+		}
+	}
+
+	registry.logger.WithField("repository", repoName).Infof("Collected %d unreferenced blobs, freeing %d bytes", len(report.Deleted), report.Freed)
+	return report, nil
+}
+
+// collectGarbage deletes the unreferenced blobs of one image stored before
+// cutoff. The blobs of manifests in the index count as referenced too, as
+// a push indexes its manifest before storing it.
+func (r *Registry) collectGarbage(image string, cutoff time.Time, report *GCReport) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := ReadImage(r.storage, image)
+	if err != nil {
+		return err
+	}
+	files, err := listDir(r.storage, image, manifestsDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, read := stored.Manifests[path.Base(file.Path)]; !read && validDigest(path.Base(file.Path)) {
+			report.Skipped = append(report.Skipped, image)
+			return nil
+		}
+	}
+
+	referenced := make(map[string]bool)
+	for _, manifests := range []map[string]*Manifest{stored.Manifests, r.manifests[image]} {
+		for _, manifest := range manifests {
+			for _, desc := range manifest.blobs() {
+				referenced[desc.Digest] = true
+			}
+		}
+	}
+
+	blobs, err := listDir(r.storage, image, blobsDir)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		digest := path.Base(blob.Path)
+		if referenced[digest] || blob.ModTime.After(cutoff) {
+			continue
+		}
+		if err := r.storage.Delete(image, blob.Path); err != nil {
+			return err
+		}
+		report.Deleted = append(report.Deleted, image+"@"+digest)
+		report.Freed += blob.Size
+	}
+	return nil
+}
+
+// ScrubAll checks the stored content of every running registry
+func (m *Manager) ScrubAll(ctx context.Context) ([]*ScrubReport, error) {
+	reports := []*ScrubReport{}
+	for _, name := range m.registryNames() {
+		report, err := m.Scrub(ctx, name)
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Scrub reads every manifest and blob of a registry's images back from
+// storage and reports those that no longer match their digests, or that
+// manifests refer to but are missing
+func (m *Manager) Scrub(ctx context.Context, repoName string) (*ScrubReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	report := &ScrubReport{Repository: repoName, Corrupt: []string{}}
+	images := registry.imageNames()
+	sort.Strings(images)
+	for _, image := range images {
+		stored, err := ReadImage(registry.storage, image)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", image, err)
+		}
+		report.Checked += len(stored.Manifests)
+		for _, problem := range stored.Problems {
+			report.Corrupt = append(report.Corrupt, image+": "+problem)
+		}
+
+		digests := make([]string, 0, len(stored.Blobs))
+		for digest := range stored.Blobs {
+			digests = append(digests, digest)
+		}
+		sort.Strings(digests)
+		for _, digest := range digests {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			matches, err := registry.blobMatches(image, digest)
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", image, err)
+			}
+			report.Checked++
+			if !matches {
+				report.Corrupt = append(report.Corrupt, fmt.Sprintf("%s: blob %s does not match its digest", image, digest))
+			}
+		}
+	}
+
+	log := registry.logger.WithField("repository", repoName)
+	if len(report.Corrupt) > 0 {
+		log.Warnf("Scrub found %d problems in %d manifests and blobs", len(report.Corrupt), report.Checked)
+	} else {
+		log.Infof("Scrubbed %d manifests and blobs", report.Checked)
+	}
+	return report, nil
+}
+
+// blobMatches reports whether a stored blob hashes to its digest. Blobs
+// with digests of other algorithms are taken as they are.
+func (r *Registry) blobMatches(image, digest string) (bool, error) {
+	if !validDigest(digest) {
+		return true, nil
+	}
+	reader, err := r.storage.Retrieve(image, path.Join(blobsDir, digest))
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	verified := &verifyingReader{reader: reader, hash: sha256.New(), digest: digest}
+	_, err = io.Copy(io.Discard, verified)
+	var mismatch *digestMismatchError
+	if errors.As(err, &mismatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// registryNames lists the repositories with a running registry
+func (m *Manager) registryNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.registries))
+	for name := range m.registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestCollectGarbageAndScrub(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewManager(storage.NewFileStorage(dir), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	registry, _ := manager.GetRegistry("apps")
+
+	old := `{"architecture":"amd64","os":"linux"}`
+	current := `{"architecture":"arm64","os":"linux"}`
+	oldDigest := pushImage(t, registry, "app", "1.0", old)
+	pushImage(t, registry, "app", "2.0", current)
+	blob := func(config string) string {
+		return filepath.Join(dir, "app", blobsDir, digestOf([]byte(config)))
+	}
+
+	// Blobs stored within the grace period are kept even when unreferenced
+	for _, reference := range []string{"1.0", oldDigest} {
+		require.Equal(t, http.StatusAccepted, serveRegistry(registry, "DELETE", "/v2/app/manifests/"+reference, "", "").Code)
+	}
+	report, err := manager.CollectGarbage(context.Background(), "apps")
+	require.NoError(t, err)
+	assert.Empty(t, report.Deleted)
+
+	past := time.Now().Add(-2 * gcGracePeriod)
+	for _, config := range []string{old, current} {
+		require.NoError(t, os.Chtimes(blob(config), past, past))
+	}
+	report, err = manager.CollectGarbage(context.Background(), "apps")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app@" + digestOf([]byte(old))}, report.Deleted)
+	assert.Equal(t, int64(len(old)), report.Freed)
+	assert.NoFileExists(t, blob(old))
+	assert.FileExists(t, blob(current))
+
+	scrubbed, err := manager.Scrub(context.Background(), "apps")
+	require.NoError(t, err)
+	assert.Equal(t, 2, scrubbed.Checked)
+	assert.Empty(t, scrubbed.Corrupt)
+
+	require.NoError(t, os.WriteFile(blob(current), []byte(`{"architecture":"riscv64"}`), 0644))
+	scrubbed, err = manager.Scrub(context.Background(), "apps")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app: blob " + digestOf([]byte(current)) + " does not match its digest"}, scrubbed.Corrupt)
+}
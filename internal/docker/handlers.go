@@ -14,6 +14,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/disconnect"
+	"github.com/depot/depot/internal/httpcache"
 )
 
 // handleBase handles GET /v2/
@@ -25,16 +29,8 @@ func (r *Registry) handleBase(w http.ResponseWriter, req *http.Request) {
 
 // handleCatalog handles GET /v2/_catalog
 func (r *Registry) handleCatalog(w http.ResponseWriter, req *http.Request) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	repos := make([]string, 0, len(r.manifests))
-	for repo := range r.manifests {
-		repos = append(repos, repo)
-	}
-
 	response := map[string]interface{}{
-		"repositories": repos,
+		"repositories": r.imageNames(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -46,6 +42,22 @@ func (r *Registry) handleTagsList(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	name := vars["name"]
 
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		name = target.local
+		tags, err := r.proxyTags(target)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": vars["name"], "tags": tags})
+			return
+		}
+		r.logger.WithError(err).WithField("image", name).Warn("Listing cached tags, upstream failed")
+		w.Header().Set("Warning", staleWarning)
+	}
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -60,7 +72,7 @@ func (r *Registry) handleTagsList(w http.ResponseWriter, req *http.Request) {
 	}
 
 	response := map[string]interface{}{
-		"name": name,
+		"name": vars["name"],
 		"tags": tags,
 	}
 
@@ -74,6 +86,36 @@ func (r *Registry) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 	name := vars["name"]
 	reference := vars["reference"]
 
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		// The image is not evicted while it is served
+		defer r.proxy.pull(target.local)()
+	}
+	if target != nil && r.readOnly() {
+		// Maintenance keeps the cache as it is
+		name = target.local
+		if _, cached := r.getManifest(name, reference); !cached {
+			r.passThrough(w, req, target, "manifests/"+reference, http.Header{"Accept": {upstreamAccept}}, "MANIFEST_UNKNOWN", "manifest")
+			return
+		}
+	} else if target != nil {
+		name = target.local
+		if err := r.proxyManifest(target, reference); err != nil {
+			if _, cached := r.getManifest(name, reference); !cached {
+				r.writeUpstreamError(w, err, "MANIFEST_UNKNOWN", "manifest")
+				return
+			}
+			r.logger.WithError(err).WithField("image", imageReference(name, reference)).Warn("Serving cached manifest, upstream failed")
+			w.Header().Set("Warning", staleWarning)
+		}
+		if platform := req.URL.Query().Get("platform"); platform != "" {
+			r.proxyPlatform(target, reference, platform)
+		}
+	}
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -89,9 +131,59 @@ func (r *Registry) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// ?platform=os/arch[/variant] resolves a manifest list to the image for
+	// that platform; image manifests are served as they are
+	if value := req.URL.Query().Get("platform"); value != "" && manifest.isList() {
+		platform, err := ParsePlatform(value)
+		if err != nil {
+			r.writeError(w, http.StatusBadRequest, "UNSUPPORTED", err.Error(), nil)
+			return
+		}
+		child, found := manifest.selectPlatform(platform)
+		if !found {
+			r.writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "no manifest for platform "+platform.String(), nil)
+			return
+		}
+		manifest, exists = repoManifests[child.Digest]
+		if !exists {
+			r.writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest not found", nil)
+			return
+		}
+	}
+
 	// Calculate digest
 	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest.Raw))
 
+	// Signatures and other artifacts stay pullable so clients can verify
+	if r.verifier != nil && manifest.isImage() {
+		if err := r.verifySignature(r.verifier, name, digest); err != nil {
+			log := r.logger.WithFields(logrus.Fields{"repository": r.repo.Name, "image": imageReference(name, reference), "digest": digest})
+			if r.verifier.audit {
+				log.WithError(err).Warn("Pulled image without a trusted signature")
+			} else {
+				log.WithError(err).Warn("Refused pull of image without a trusted signature")
+				r.writeError(w, http.StatusForbidden, "DENIED", "image is not signed by a trusted certificate", nil)
+				return
+			}
+		}
+	}
+
+	// Manifest lists and buildx attestations are only indexes and evidence;
+	// the images they list are checked when pulled
+	if r.config.ProvenancePolicy != nil && manifest.isImage() && !manifest.isList() && !manifest.isAttestation() {
+		if err := r.checkProvenance(name, digest); err != nil {
+			r.logger.WithFields(logrus.Fields{"repository": r.repo.Name, "image": imageReference(name, reference), "digest": digest}).
+				WithError(err).Warn("Refused pull of image without acceptable build provenance")
+			r.writeError(w, http.StatusForbidden, "DENIED", "image has no acceptable build provenance: "+err.Error(), nil)
+			return
+		}
+	}
+
+	// The digest identifies the manifest content, so it doubles as the ETag
+	if httpcache.NotModified(w, req, httpcache.ETag(digest), time.Time{}) {
+		return
+	}
+
 	// Set headers
 	w.Header().Set("Content-Type", manifest.MediaType)
 	w.Header().Set("Docker-Content-Digest", digest)
@@ -103,7 +195,18 @@ func (r *Registry) handleManifestGet(w http.ResponseWriter, req *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write(manifest.Raw)
+	if _, err := w.Write(manifest.Raw); err == nil && r.onDownload != nil {
+		r.onDownload(r.repo.Name, imageReference(name, reference))
+	}
+}
+
+// imageReference formats an image and tag or digest the way clients write
+// them, e.g. "app:1.0" or "app@sha256:..."
+func imageReference(name, reference string) string {
+	if strings.Contains(reference, ":") {
+		return name + "@" + reference
+	}
+	return name + ":" + reference
 }
 
 // handleManifestPut handles PUT /v2/{name}/manifests/{reference}
@@ -112,9 +215,18 @@ func (r *Registry) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 	name := vars["name"]
 	reference := vars["reference"]
 
+	limit := r.uploadLimit()
+	if !r.limitBody(w, req, limit, 0, "MANIFEST_INVALID") {
+		return
+	}
+
 	// Read manifest body
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
+		if isTooLarge(err) {
+			r.writeSizeError(w, limit, "MANIFEST_INVALID")
+			return
+		}
 		r.writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", "failed to read manifest", nil)
 		return
 	}
@@ -136,22 +248,8 @@ func (r *Registry) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 	}
 	manifest.MediaType = contentType
 
-	// Calculate digest
-	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
-
-	r.mu.Lock()
-	if _, exists := r.manifests[name]; !exists {
-		r.manifests[name] = make(map[string]*Manifest)
-	}
-	
-	// Store by reference (tag or digest)
-	r.manifests[name][reference] = &manifest
-	
-	// Also store by digest if reference is a tag
-	if !strings.HasPrefix(reference, "sha256:") {
-		r.manifests[name][digest] = &manifest
-	}
-	r.mu.Unlock()
+	// Index manifest by reference and digest
+	digest := r.putManifest(name, reference, &manifest)
 
 	// Store manifest in storage backend
 	manifestPath := path.Join("manifests", digest)
@@ -159,11 +257,24 @@ func (r *Registry) handleManifestPut(w http.ResponseWriter, req *http.Request) {
 		r.writeError(w, http.StatusInternalServerError, "MANIFEST_BLOB_UNKNOWN", "failed to store manifest", nil)
 		return
 	}
+	if err := r.storeTag(name, reference, digest); err != nil {
+		r.writeError(w, http.StatusInternalServerError, "MANIFEST_BLOB_UNKNOWN", "failed to store tag", nil)
+		return
+	}
 
 	// Set headers
 	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, digest))
 	w.Header().Set("Docker-Content-Digest", digest)
+	if manifest.Subject != nil {
+		// Tells clients the referrers API lists this manifest, so they
+		// need not maintain a referrers tag
+		w.Header().Set("OCI-Subject", manifest.Subject.Digest)
+	}
 	w.WriteHeader(http.StatusCreated)
+
+	if r.onPush != nil && !strings.HasPrefix(reference, "sha256:") {
+		r.onPush(r.repo.Name, imageReference(name, reference))
+	}
 }
 
 // handleManifestDelete handles DELETE /v2/{name}/manifests/{reference}
@@ -172,6 +283,14 @@ func (r *Registry) handleManifestDelete(w http.ResponseWriter, req *http.Request
 	name := vars["name"]
 	reference := vars["reference"]
 
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		name = target.local
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -189,8 +308,12 @@ func (r *Registry) handleManifestDelete(w http.ResponseWriter, req *http.Request
 	delete(repoManifests, reference)
 
 	// Delete from storage
-	manifestPath := path.Join("manifests", reference)
-	_ = r.storage.Delete(name, manifestPath)
+	if strings.HasPrefix(reference, "sha256:") {
+		manifestPath := path.Join("manifests", reference)
+		_ = r.storage.Delete(name, manifestPath)
+	} else {
+		_ = r.storage.Delete(name, path.Join(tagsDir, reference))
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -201,21 +324,40 @@ func (r *Registry) handleBlobGet(w http.ResponseWriter, req *http.Request) {
 	name := vars["name"]
 	digest := vars["digest"]
 
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		// The image is not evicted while it is served
+		defer r.proxy.pull(target.local)()
+	}
+	if target != nil && r.readOnly() {
+		// Maintenance keeps the cache as it is
+		name = target.local
+		if cached, err := r.storage.Exists(name, path.Join(blobsDir, digest)); (err != nil || !cached) && validDigest(digest) {
+			r.passThrough(w, req, target, "blobs/"+digest, nil, "BLOB_UNKNOWN", "blob")
+			return
+		}
+	} else if target != nil {
+		name = target.local
+		if err := r.proxyBlob(target, digest); err != nil {
+			r.writeUpstreamError(w, err, "BLOB_UNKNOWN", "blob")
+			return
+		}
+	}
+
 	blobPath := path.Join("blobs", digest)
 	
 	// Check if blob exists
-	exists, err := r.storage.Exists(name, blobPath)
-	if err != nil || !exists {
+	info, err := r.storage.Stat(name, blobPath)
+	if err != nil {
 		r.writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob not found", nil)
 		return
 	}
 
-	if req.Method == "HEAD" {
-		// For HEAD request, just return headers
-		// In a real implementation, we'd store blob metadata
-		w.Header().Set("Docker-Content-Digest", digest)
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.WriteHeader(http.StatusOK)
+	// Blobs are content-addressed and never change once written
+	if httpcache.NotModified(w, req, httpcache.ETag(digest), time.Time{}) {
 		return
 	}
 
@@ -231,9 +373,8 @@ func (r *Registry) handleBlobGet(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Docker-Content-Digest", digest)
 	w.Header().Set("Content-Type", "application/octet-stream")
 
-	// Copy blob to response
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, reader)
+	// Serve the blob, including HEAD and range requests
+	httpcache.ServeContent(w, req, time.Time{}, reader, info.Size)
 }
 
 // handleBlobDelete handles DELETE /v2/{name}/blobs/{digest}
@@ -242,6 +383,14 @@ func (r *Registry) handleBlobDelete(w http.ResponseWriter, req *http.Request) {
 	name := vars["name"]
 	digest := vars["digest"]
 
+	target, ok := r.proxyTarget(w, req, name)
+	if !ok {
+		return
+	}
+	if target != nil {
+		name = target.local
+	}
+
 	blobPath := path.Join("blobs", digest)
 	
 	if err := r.storage.Delete(name, blobPath); err != nil {
@@ -269,6 +418,7 @@ func (r *Registry) handleBlobUploadPost(w http.ResponseWriter, req *http.Request
 	r.mu.Lock()
 	r.uploads[uploadUUID] = upload
 	r.mu.Unlock()
+	r.persistUpload(upload, 0, nil)
 
 	// Set headers
 	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uploadUUID)
@@ -285,32 +435,76 @@ func (r *Registry) handleBlobUploadPatch(w http.ResponseWriter, req *http.Reques
 	uploadUUID := vars["uuid"]
 
 	r.mu.Lock()
-	upload, exists := r.uploads[uploadUUID]
+	upload, exists := r.lookupUpload(uploadUUID)
 	if !exists {
 		r.mu.Unlock()
 		r.writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload not found", nil)
 		return
 	}
+	used := upload.received()
+	size := upload.Size
 	r.mu.Unlock()
+	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uploadUUID)
+
+	// A chunk with a Content-Range goes at its offset, so the chunks of a
+	// blob may be sent in parallel; one without follows the data so far
+	start, end, ranged, err := parseContentRange(req.Header.Get("Content-Range"))
+	if err != nil || (ranged && req.ContentLength >= 0 && req.ContentLength != end-start+1) {
+		r.writeRangeError(w, location, size)
+		return
+	}
+
+	limit := r.uploadLimit()
+	if !r.limitBody(w, req, limit, used, "BLOB_UPLOAD_INVALID") {
+		r.abandonUpload(uploadUUID)
+		return
+	}
+	body := disconnect.Watch(req)
 
 	// Read chunk data
 	chunk, err := io.ReadAll(req.Body)
 	if err != nil {
+		if isTooLarge(err) {
+			r.abandonUpload(uploadUUID)
+			r.writeSizeError(w, limit, "BLOB_UPLOAD_INVALID")
+			return
+		}
+		if r.uploadAborted(w, req, body) {
+			return
+		}
 		r.writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", "failed to read chunk", nil)
 		return
 	}
+	if ranged && int64(len(chunk)) != end-start+1 {
+		r.writeRangeError(w, location, size)
+		return
+	}
 
-	// Append to upload data
+	// Add to upload data
 	r.mu.Lock()
-	upload.Data = append(upload.Data, chunk...)
-	upload.Size = int64(len(upload.Data))
+	offset := upload.Size
+	if ranged {
+		offset = start
+	}
+	if err := upload.addChunk(offset, chunk, limit); err != nil {
+		size := upload.Size
+		r.mu.Unlock()
+		if err == errUploadTooLarge {
+			r.abandonUpload(uploadUUID)
+			r.writeSizeError(w, limit, "BLOB_UPLOAD_INVALID")
+			return
+		}
+		r.writeRangeError(w, location, size)
+		return
+	}
+	session := *upload
 	r.mu.Unlock()
+	r.persistUpload(&session, offset, chunk)
 
 	// Set headers
-	location := fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uploadUUID)
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Upload-UUID", uploadUUID)
-	w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.Size-1))
+	w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", session.Size-1))
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -327,8 +521,10 @@ func (r *Registry) handleBlobUploadPut(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	limit := r.uploadLimit()
+
 	r.mu.Lock()
-	upload, exists := r.uploads[uploadUUID]
+	upload, exists := r.lookupUpload(uploadUUID)
 	if !exists {
 		r.mu.Unlock()
 		r.writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload not found", nil)
@@ -336,14 +532,55 @@ func (r *Registry) handleBlobUploadPut(w http.ResponseWriter, req *http.Request)
 	}
 
 	// Read any remaining data
+	offset := upload.Size
+	var chunk []byte
 	if req.ContentLength > 0 {
-		chunk, err := io.ReadAll(req.Body)
+		if !r.limitBody(w, req, limit, upload.received(), "BLOB_UPLOAD_INVALID") {
+			delete(r.uploads, uploadUUID)
+			r.mu.Unlock()
+			r.removeUpload(uploadUUID)
+			return
+		}
+		body := disconnect.Watch(req)
+		var err error
+		chunk, err = io.ReadAll(req.Body)
 		if err != nil {
+			if isTooLarge(err) {
+				delete(r.uploads, uploadUUID)
+				r.mu.Unlock()
+				r.removeUpload(uploadUUID)
+				r.writeSizeError(w, limit, "BLOB_UPLOAD_INVALID")
+				return
+			}
 			r.mu.Unlock()
+			if r.uploadAborted(w, req, body) {
+				return
+			}
 			r.writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", "failed to read chunk", nil)
 			return
 		}
-		upload.Data = append(upload.Data, chunk...)
+		if err := upload.addChunk(offset, chunk, limit); err != nil {
+			if err == errUploadTooLarge {
+				delete(r.uploads, uploadUUID)
+				r.mu.Unlock()
+				r.removeUpload(uploadUUID)
+				r.writeSizeError(w, limit, "BLOB_UPLOAD_INVALID")
+				return
+			}
+			size := upload.Size
+			r.mu.Unlock()
+			r.writeRangeError(w, fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, uploadUUID), size)
+			return
+		}
+	}
+	// Chunks sent in parallel must all have arrived. The session is kept,
+	// so the missing ones can still be sent.
+	if len(upload.Parts) > 0 {
+		session := *upload
+		r.mu.Unlock()
+		r.persistUpload(&session, offset, chunk)
+		r.writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", fmt.Sprintf("upload is missing data at offset %d", session.Size), nil)
+		return
 	}
 
 	// Calculate actual digest
@@ -357,6 +594,7 @@ func (r *Registry) handleBlobUploadPut(w http.ResponseWriter, req *http.Request)
 	// Remove from uploads
 	delete(r.uploads, uploadUUID)
 	r.mu.Unlock()
+	r.removeUpload(uploadUUID)
 
 	// Store blob
 	blobPath := path.Join("blobs", digest)
@@ -372,14 +610,22 @@ func (r *Registry) handleBlobUploadPut(w http.ResponseWriter, req *http.Request)
 	w.WriteHeader(http.StatusCreated)
 }
 
+// abandonUpload discards an upload session that can no longer complete
+func (r *Registry) abandonUpload(uploadUUID string) {
+	r.mu.Lock()
+	delete(r.uploads, uploadUUID)
+	r.mu.Unlock()
+	r.removeUpload(uploadUUID)
+}
+
 // handleBlobUploadGet handles GET /v2/{name}/blobs/uploads/{uuid}
 func (r *Registry) handleBlobUploadGet(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	uploadUUID := vars["uuid"]
 
-	r.mu.RLock()
-	upload, exists := r.uploads[uploadUUID]
-	r.mu.RUnlock()
+	r.mu.Lock()
+	upload, exists := r.lookupUpload(uploadUUID)
+	r.mu.Unlock()
 
 	if !exists {
 		r.writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload not found", nil)
@@ -396,9 +642,23 @@ func (r *Registry) handleBlobUploadDelete(w http.ResponseWriter, req *http.Reque
 	vars := mux.Vars(req)
 	uploadUUID := vars["uuid"]
 
-	r.mu.Lock()
-	delete(r.uploads, uploadUUID)
-	r.mu.Unlock()
+	r.abandonUpload(uploadUUID)
 
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// uploadAborted answers a blob upload whose client went away before
+// sending the whole chunk, and counts it. The partial chunk is dropped and
+// the session keeps what it held, so the push can resume. It returns false
+// if the client did not go away.
+func (r *Registry) uploadAborted(w http.ResponseWriter, req *http.Request, body *disconnect.Body) bool {
+	if !body.Gone() {
+		return false
+	}
+	r.logger.WithField("repository", r.repo.Name).Infof("Client disconnected during blob upload %s", mux.Vars(req)["uuid"])
+	if r.metrics != nil {
+		r.metrics.RecordAbortedUpload(r.repo.Name, "blob")
+	}
+	r.writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", "upload cut short by the client", nil)
+	return true
+}
@@ -0,0 +1,97 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrManifestNotFound is returned when an image reference is unknown
+	ErrManifestNotFound = errors.New("manifest not found")
+	// ErrPlatformNotFound is returned when a manifest list has no image for
+	// the requested platform
+	ErrPlatformNotFound = errors.New("no manifest for platform")
+)
+
+// ImageInfo describes an image manifest, or a manifest list and the image
+// of each platform it contains
+type ImageInfo struct {
+	Image     string `json:"image"`
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+	// Size is the size of the config and layers, counting blobs shared by
+	// several platforms once
+	Size      int64           `json:"size"`
+	Layers    int             `json:"layers,omitempty"`
+	Platforms []*PlatformInfo `json:"platforms,omitempty"`
+}
+
+// PlatformInfo describes the image of one platform of a manifest list
+type PlatformInfo struct {
+	Platform  *Platform `json:"platform"`
+	Digest    string    `json:"digest"`
+	MediaType string    `json:"media_type"`
+	Size      int64     `json:"size"`
+	Layers    int       `json:"layers"`
+}
+
+// InspectImage describes the manifest of image:reference in a repository's
+// registry. If platform is set, only the images of a manifest list matching
+// it are included.
+func (m *Manager) InspectImage(repoName, image, reference string, platform *Platform) (*ImageInfo, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	return registry.inspectImage(image, reference, platform)
+}
+
+func (r *Registry) inspectImage(image, reference string, platform *Platform) (*ImageInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	manifest, exists := r.manifests[image][reference]
+	if !exists {
+		return nil, ErrManifestNotFound
+	}
+	info := &ImageInfo{
+		Image:     image,
+		Reference: reference,
+		Digest:    digestOf(manifest.Raw),
+		MediaType: manifest.MediaType,
+	}
+	if !manifest.isList() {
+		info.Size = manifest.imageSize()
+		info.Layers = len(manifest.Layers)
+		return info, nil
+	}
+
+	counted := make(map[string]bool)
+	for _, child := range manifest.Manifests {
+		if child.Platform == nil || (platform != nil && !child.Platform.Matches(platform)) {
+			continue
+		}
+		childManifest, exists := r.manifests[image][child.Digest]
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrManifestNotFound, child.Digest)
+		}
+		info.Platforms = append(info.Platforms, &PlatformInfo{
+			Platform:  child.Platform,
+			Digest:    child.Digest,
+			MediaType: childManifest.MediaType,
+			Size:      childManifest.imageSize(),
+			Layers:    len(childManifest.Layers),
+		})
+		for _, desc := range childManifest.blobs() {
+			if !counted[desc.Digest] {
+				counted[desc.Digest] = true
+				info.Size += desc.Size
+			}
+		}
+	}
+	if platform != nil && len(info.Platforms) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrPlatformNotFound, platform)
+	}
+	return info, nil
+}
@@ -0,0 +1,235 @@
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// OnMainPort reports whether a repository with this configuration has no
+// listener of its own and is only served on the main server port
+func OnMainPort(config *models.DockerRepositoryConfig) bool {
+	return config.HTTPPort == 0 && config.HTTPSPort == 0
+}
+
+// SetPathRouting serves every registry on the main server port with the
+// repository name as the first component of every image name. Without it
+// the main port serves the one registry without a port of its own as is.
+func (m *Manager) SetPathRouting(all bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pathRouting = all
+}
+
+// SetExternalURL makes the Location headers of registries on the main
+// server port absolute URLs below externalURL, e.g.
+// https://depot.example.com/v2/<repo-name>/...
+func (m *Manager) SetExternalURL(externalURL string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.externalURL = externalURL
+}
+
+// RoutedOnMainPort reports whether a repository's registry is reachable on
+// the main server port under /v2/<repo-name>/
+func (m *Manager) RoutedOnMainPort(repoName string) bool {
+	_, ok := m.mainPortRegistry(repoName)
+	return ok
+}
+
+func (m *Manager) mainPortRegistry(repoName string) (*Registry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	registry, exists := m.registries[repoName]
+	if !exists || !m.pathRouting {
+		return nil, false
+	}
+	return registry, true
+}
+
+// MainPortRepository returns the repository a request path on the main
+// server port is for, or "" if it is for none
+func (m *Manager) MainPortRepository(path string) string {
+	rest, found := strings.CutPrefix(path, "/v2/")
+	if !found {
+		return ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.pathRouting {
+		name, _ := m.unroutedRegistry()
+		return name
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name
+}
+
+// unroutedRegistry returns the repository without a port of its own and
+// its registry, which is nil while the repository is disabled. Only one
+// such repository can be created without path routing; of those created
+// with it the first by name is served. m.mu must be held.
+func (m *Manager) unroutedRegistry() (string, *Registry) {
+	var found string
+	for name, registry := range m.registries {
+		if OnMainPort(registry.config) && (found == "" || name < found) {
+			found = name
+		}
+	}
+	for name, registry := range m.disabled {
+		if registry != nil && OnMainPort(registry.config) && (found == "" || name < found) {
+			found = name
+		}
+	}
+	return found, m.registries[found]
+}
+
+func (m *Manager) isDisabled(repoName string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, disabled := m.disabled[repoName]
+	return disabled
+}
+
+// MainPortHandler serves registries on the main server port. With path
+// routing the repository name is the first component of every image name,
+// so /v2/<repo-name>/<image>/manifests/latest reaches the registry of
+// repo-name as /v2/<image>/manifests/latest, and Locations in responses
+// are rewritten to match. Without it the registry of the repository
+// without a port of its own is served at /v2/ unchanged.
+func (m *Manager) MainPortHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m.mu.RLock()
+		pathRouting := m.pathRouting
+		m.mu.RUnlock()
+		if !pathRouting {
+			m.serveUnrouted(w, req)
+			return
+		}
+
+		rest := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/v2"), "/")
+		switch rest {
+		case "":
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			// Ask for credentials so docker login stores pull tokens and
+			// docker sends them; clients without any still pull from the
+			// repositories that do not require them
+			if req.Header.Get("Authorization") == "" && m.requirePullTokens() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="depot"`)
+				writeErrorResponse(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required", nil)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("{}"))
+			return
+		case "_catalog":
+			m.serveCatalog(w)
+			return
+		}
+
+		repoName, image, _ := strings.Cut(rest, "/")
+		if m.isDisabled(repoName) {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			writeErrorResponse(w, http.StatusServiceUnavailable, "UNAVAILABLE", "repository is disabled",
+				map[string]interface{}{"name": repoName})
+			return
+		}
+		registry, ok := m.mainPortRegistry(repoName)
+		if !ok || image == "" {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			writeErrorResponse(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry",
+				map[string]interface{}{"name": rest})
+			return
+		}
+
+		m.mu.RLock()
+		prefix := m.externalURL + "/v2/" + repoName + "/"
+		m.mu.RUnlock()
+
+		routed := req.Clone(req.Context())
+		routed.URL.Path = "/v2/" + image
+		routed.URL.RawPath = ""
+		registry.GetRouter().ServeHTTP(&locationRewriter{ResponseWriter: w, prefix: prefix}, routed)
+	})
+}
+
+// serveUnrouted serves the one registry without a port of its own, as
+// registries on the main port were served before path routing
+func (m *Manager) serveUnrouted(w http.ResponseWriter, req *http.Request) {
+	m.mu.RLock()
+	name, registry := m.unroutedRegistry()
+	prefix := m.externalURL + "/v2/"
+	m.mu.RUnlock()
+
+	if registry == nil {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		if name != "" {
+			writeErrorResponse(w, http.StatusServiceUnavailable, "UNAVAILABLE", "repository is disabled",
+				map[string]interface{}{"name": name})
+			return
+		}
+		writeErrorResponse(w, http.StatusNotFound, "NAME_UNKNOWN", "no repository is served on this port", nil)
+		return
+	}
+	registry.GetRouter().ServeHTTP(&locationRewriter{ResponseWriter: w, prefix: prefix}, req)
+}
+
+// serveCatalog lists the images of every registry, each prefixed with its
+// repository name. Registries requiring pull tokens are left out.
+func (m *Manager) serveCatalog(w http.ResponseWriter) {
+	m.mu.RLock()
+	images := []string{}
+	for name, registry := range m.registries {
+		if registry.config.RequirePullToken {
+			continue
+		}
+		for _, image := range registry.imageNames() {
+			images = append(images, name+"/"+image)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(images)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"repositories": images,
+	})
+}
+
+// locationRewriter puts the repository name back into the registry's
+// Location headers
+type locationRewriter struct {
+	http.ResponseWriter
+	prefix      string
+	wroteHeader bool
+}
+
+func (l *locationRewriter) WriteHeader(code int) {
+	if !l.wroteHeader {
+		l.wroteHeader = true
+		if location := l.Header().Get("Location"); strings.HasPrefix(location, "/v2/") {
+			l.Header().Set("Location", l.prefix+strings.TrimPrefix(location, "/v2/"))
+		}
+	}
+	l.ResponseWriter.WriteHeader(code)
+}
+
+func (l *locationRewriter) Write(p []byte) (int, error) {
+	if !l.wroteHeader {
+		l.WriteHeader(http.StatusOK)
+	}
+	return l.ResponseWriter.Write(p)
+}
+
+func (l *locationRewriter) Flush() {
+	if f, ok := l.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
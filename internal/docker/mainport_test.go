@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestMainPortHandler(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	manager.SetPathRouting(true)
+
+	for _, name := range []string{"team-a", "team-b"} {
+		repo := &models.Repository{Name: name, Type: models.RepositoryTypeDocker}
+		require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+	}
+	handler := manager.MainPortHandler()
+
+	serve := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if method == "PUT" {
+			req.Header.Set("Content-Type", MediaTypeDockerSchema2Manifest)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Base Endpoint", func(t *testing.T) {
+		w := serve("GET", "/v2/", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "registry/2.0", w.Header().Get("Docker-Distribution-API-Version"))
+	})
+
+	t.Run("Upload Location", func(t *testing.T) {
+		w := serve("POST", "/v2/team-a/app/blobs/uploads/", nil)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/v2/team-a/app/blobs/uploads/"+w.Header().Get("Docker-Upload-UUID"), w.Header().Get("Location"))
+	})
+
+	t.Run("Repositories Are Separate", func(t *testing.T) {
+		manifest, err := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeDockerSchema2Manifest})
+		require.NoError(t, err)
+
+		w := serve("PUT", "/v2/team-a/app/manifests/1.0", manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Header().Get("Location"), "/v2/team-a/app/manifests/")
+
+		assert.Equal(t, http.StatusOK, serve("GET", "/v2/team-a/app/manifests/1.0", nil).Code)
+		assert.Equal(t, http.StatusNotFound, serve("GET", "/v2/team-b/app/manifests/1.0", nil).Code)
+
+		w = serve("GET", "/v2/_catalog", nil)
+		var catalog struct {
+			Repositories []string `json:"repositories"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&catalog))
+		assert.Equal(t, []string{"team-a/app"}, catalog.Repositories)
+	})
+
+	t.Run("Unknown Repository", func(t *testing.T) {
+		w := serve("GET", "/v2/missing/app/tags/list", nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "NAME_UNKNOWN")
+	})
+
+	t.Run("Own Port", func(t *testing.T) {
+		manager.mu.Lock()
+		manager.registries["team-c"] = NewRegistry(&models.Repository{Name: "team-c"},
+			&models.DockerRepositoryConfig{HTTPPort: 5999}, manager.storage, manager.logger)
+		manager.mu.Unlock()
+
+		assert.True(t, manager.RoutedOnMainPort("team-c"))
+		assert.Equal(t, http.StatusOK, serve("GET", "/v2/team-c/app/tags/list", nil).Code)
+		assert.Equal(t, "team-c", manager.MainPortRepository("/v2/team-c/app/tags/list"))
+	})
+}
+
+func TestMainPortHandlerUnrouted(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	handler := manager.MainPortHandler()
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/v2/").Code)
+
+	repo := &models.Repository{Name: "images", Type: models.RepositoryTypeDocker}
+	require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+
+	// The registry is served as it is, without its repository's name
+	assert.Equal(t, http.StatusOK, serve("GET", "/v2/").Code)
+	w := serve("POST", "/v2/app/blobs/uploads/")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/v2/app/blobs/uploads/"+w.Header().Get("Docker-Upload-UUID"), w.Header().Get("Location"))
+	assert.False(t, manager.RoutedOnMainPort("images"))
+	assert.Equal(t, "images", manager.MainPortRepository("/v2/app/tags/list"))
+
+	// It has the main port to itself
+	inUse, name := manager.IsPortInUse(&models.DockerRepositoryConfig{})
+	assert.True(t, inUse)
+	assert.Equal(t, "images", name)
+	inUse, _ = manager.IsPortInUse(&models.DockerRepositoryConfig{HTTPPort: 5999})
+	assert.False(t, inUse)
+}
@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"net/http"
+
+	"github.com/depot/depot/internal/maintenance"
+)
+
+// SetMaintenance sets the maintenance switch that registries started
+// afterwards obey
+func (m *Manager) SetMaintenance(switcher *maintenance.Manager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maintenance = switcher
+}
+
+// SetMaintenance has the registry refuse pushes, or every request, while
+// the server is in maintenance
+func (r *Registry) SetMaintenance(switcher *maintenance.Manager) {
+	if switcher == nil {
+		return
+	}
+	r.maintenance = switcher
+	r.router.Use(switcher.Middleware(func(w http.ResponseWriter, req *http.Request, state *maintenance.State) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		r.writeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", state.Describe(), nil)
+	}))
+}
+
+// readOnly reports whether maintenance keeps storage as it is, so proxy
+// repositories pass through what they have not cached rather than cache it
+func (r *Registry) readOnly() bool {
+	return r.maintenance != nil && r.maintenance.Active()
+}
@@ -3,35 +3,182 @@ package docker
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/depot/depot/internal/connections"
+	"github.com/depot/depot/internal/diskspace"
+	"github.com/depot/depot/internal/fairqueue"
+	"github.com/depot/depot/internal/logging"
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/metrics"
+	"github.com/depot/depot/internal/policy"
 	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/timeouts"
 	"github.com/depot/depot/pkg/models"
 )
 
+// Default range of the ports given to registries with "http_port": "auto"
+const (
+	DefaultPortMin = 5000
+	DefaultPortMax = 5999
+)
+
 // Manager manages Docker registry instances
 type Manager struct {
-	registries map[string]*Registry
-	storage    storage.Storage
-	tlsConfig  *tls.Config
-	logger     *logrus.Logger
-	mu         sync.RWMutex
+	registries    map[string]*Registry
+	disabled      map[string]*Registry // stopped registries of repositories taken offline
+	reserved      map[string]*models.DockerRepositoryConfig
+	storage       storage.Storage
+	tlsConfig     *tls.Config
+	maxUploadSize int64
+	onDownload    func(repository, artifact string)
+	onPush        func(repository, artifact string)
+	metrics       *metrics.Recorder
+	pathRouting   bool
+	externalURL   string
+	portMin       int
+	portMax       int
+	certDir       string
+	pullTokens    *PullTokens
+	policy        *policy.Engine
+	maintenance   *maintenance.Manager
+	uploadQueue   *fairqueue.Queue
+	fetchQueue    *fairqueue.Queue
+	diskGuard     *diskspace.Guard
+	listen        func(network, address string) (net.Listener, error)
+	timeouts      timeouts.Config
+	connections   connections.Config
+	sampling      *logging.AccessSampling
+	middleware    []func(http.Handler) http.Handler
+	logger        *logrus.Logger
+	mu            sync.RWMutex
 }
 
 // NewManager creates a new Docker registry manager
 func NewManager(storage storage.Storage, tlsConfig *tls.Config, logger *logrus.Logger) *Manager {
 	return &Manager{
 		registries: make(map[string]*Registry),
+		disabled:   make(map[string]*Registry),
+		reserved:   make(map[string]*models.DockerRepositoryConfig),
 		storage:    storage,
 		tlsConfig:  tlsConfig,
+		portMin:    DefaultPortMin,
+		portMax:    DefaultPortMax,
 		logger:     logger,
 	}
 }
 
+// SetTLSConfig sets the TLS configuration used by registries started with an HTTPS port
+func (m *Manager) SetTLSConfig(tlsConfig *tls.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tlsConfig = tlsConfig
+}
+
+// SetMaxUploadSize sets the server-wide upload limit applied to registries
+// started afterwards
+func (m *Manager) SetMaxUploadSize(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.maxUploadSize = size
+}
+
+// SetDownloadRecorder sets the function that counts manifest pulls in
+// registries started afterwards
+func (m *Manager) SetDownloadRecorder(record func(repository, artifact string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onDownload = record
+}
+
+// SetPushRecorder sets the function told of images pushed by tag to
+// registries started afterwards
+func (m *Manager) SetPushRecorder(record func(repository, artifact string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onPush = record
+}
+
+// SetListenFunc sets the function registries started afterwards use to
+// open their listeners, e.g. to take them over from a previous process
+func (m *Manager) SetListenFunc(listen func(network, address string) (net.Listener, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.listen = listen
+}
+
+// SetConnections sets the connection settings of registries started
+// afterwards
+func (m *Manager) SetConnections(config connections.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.connections = config
+}
+
+// SetTimeouts sets the timeouts of registries started afterwards; unset
+// timeouts keep the registry defaults
+func (m *Manager) SetTimeouts(config timeouts.Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.timeouts = config
+}
+
+// SetAccessSampling sets which requests of registries started afterwards
+// are logged
+func (m *Manager) SetAccessSampling(sampling *logging.AccessSampling) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sampling = sampling
+}
+
+// SetMiddleware sets middleware wrapping the requests of registries
+// started afterwards on ports of their own, in order. Registries on the
+// main server port are served through its router and its middleware.
+func (m *Manager) SetMiddleware(middleware ...func(http.Handler) http.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.middleware = middleware
+}
+
+// ErrAutoPortWithTLS is returned for a registry with "http_port": "auto"
+// and an HTTPS port; only the HTTP port can be picked
+var ErrAutoPortWithTLS = errors.New(`"http_port": "auto" cannot be combined with https_port`)
+
+// SetPortRange sets the range, inclusive, from which registries with
+// "http_port": "auto" are given a port
+func (m *Manager) SetPortRange(min, max int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.portMin, m.portMax = min, max
+}
+
+// SetMetrics sets the recorder that tracks the traffic of registries
+// started afterwards
+func (m *Manager) SetMetrics(recorder *metrics.Recorder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.metrics = recorder
+}
+
 // StartRegistry starts a Docker registry for the given repository
 func (m *Manager) StartRegistry(repo *models.Repository, config *models.DockerRepositoryConfig) error {
 	m.mu.Lock()
@@ -41,55 +188,145 @@ func (m *Manager) StartRegistry(repo *models.Repository, config *models.DockerRe
 	if _, exists := m.registries[repo.Name]; exists {
 		return fmt.Errorf("registry already running for repository %s", repo.Name)
 	}
-
-	// Validate port configuration
-	if config.HTTPPort == 0 && config.HTTPSPort == 0 {
-		return fmt.Errorf("either HTTPPort or HTTPSPort must be specified")
+	if config.HTTPPort == models.PortAuto && config.HTTPSPort > 0 {
+		return ErrAutoPortWithTLS
 	}
 
 	// Check for port conflicts
 	for name, reg := range m.registries {
-		if (config.HTTPPort > 0 && config.HTTPPort == reg.config.HTTPPort) ||
-			(config.HTTPSPort > 0 && config.HTTPSPort == reg.config.HTTPSPort) {
+		if portsConflict(config, reg.config) {
 			return fmt.Errorf("port conflict with repository %s", name)
 		}
 	}
 
 	// Create new registry
 	registry := NewRegistry(repo, config, m.storage, m.logger)
+	registry.SetMaxUploadSize(m.maxUploadSize)
+	registry.SetDownloadRecorder(m.onDownload)
+	registry.SetPushRecorder(m.onPush)
+	registry.SetMetrics(m.metrics)
+	registry.SetPolicy(m.policy)
+	registry.SetMaintenance(m.maintenance)
+	registry.SetDiskGuard(m.diskGuard)
+	registry.SetQueues(m.uploadQueue, m.fetchQueue)
+	registry.pullTokens = m.pullTokens
+	registry.listen = m.listen
+	registry.timeouts = m.timeouts
+	registry.connections = m.connections
+	registry.sampling = m.sampling
+	registry.middleware = m.middleware
+	// A repository brought back online keeps the images it had; otherwise
+	// the images stored for it are loaded
+	if stopped := m.disabled[repo.Name]; stopped != nil {
+		registry.manifests = stopped.manifests
+	} else {
+		registry.loadStored()
+		registry.purgeUploads()
+	}
+
+	// Without a port of its own the registry is only served by MainPortHandler
+	if OnMainPort(config) {
+		registry.startBackground()
+		m.registries[repo.Name] = registry
+		delete(m.disabled, repo.Name)
+		delete(m.reserved, repo.Name)
+		m.logger.WithField("repository", repo.Name).Info("Docker registry mounted on main server port")
+		return nil
+	}
 
 	// Determine which server to start
 	var tlsConfig *tls.Config
 	if config.HTTPSPort > 0 {
-		tlsConfig = m.tlsConfig
+		var err error
+		if tlsConfig, err = m.registryTLSConfig(config); err != nil {
+			return fmt.Errorf("failed to start registry: %w", err)
+		}
 	}
 
-	// Start registry in background
-	errCh := make(chan error, 1)
+	// Bind now so a port conflict is reported, then serve in background
+	var listener net.Listener
+	var err error
+	if config.HTTPPort == models.PortAuto {
+		listener, err = m.listenAuto(registry, tlsConfig)
+	} else {
+		listener, err = registry.Listen(tlsConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start registry: %w", err)
+	}
 	go func() {
-		if err := registry.Start(tlsConfig); err != nil {
+		if err := registry.Serve(listener); err != nil && err != http.ErrServerClosed {
 			m.logger.WithFields(logrus.Fields{
 				"repository": repo.Name,
 				"error":      err,
-			}).Error("Registry failed to start")
-			errCh <- err
+			}).Error("Registry failed")
 		}
 	}()
 
-	// Wait briefly to check if server started successfully
-	select {
-	case err := <-errCh:
-		return fmt.Errorf("failed to start registry: %w", err)
-	default:
-		// Registry started successfully
-		m.registries[repo.Name] = registry
-		m.logger.WithFields(logrus.Fields{
-			"repository": repo.Name,
-			"http_port":  config.HTTPPort,
-			"https_port": config.HTTPSPort,
-		}).Info("Docker registry started")
-		return nil
+	registry.startBackground()
+	m.registries[repo.Name] = registry
+	delete(m.disabled, repo.Name)
+	delete(m.reserved, repo.Name)
+	m.logger.WithFields(logrus.Fields{
+		"repository": repo.Name,
+		"http_port":  config.HTTPPort,
+		"https_port": config.HTTPSPort,
+	}).Info("Docker registry started")
+	return nil
+}
+
+// listenAuto opens the listener of a registry with "http_port": "auto" on
+// the first free port of the port range and sets the registry's HTTPPort
+// to it. A port some other process holds is skipped. The caller must hold
+// m.mu.
+func (m *Manager) listenAuto(registry *Registry, tlsConfig *tls.Config) (net.Listener, error) {
+	config := registry.config
+	for port := m.portMin; port <= m.portMax; port++ {
+		if m.portTaken(port) {
+			continue
+		}
+		config.HTTPPort = port
+		if listener, err := registry.Listen(tlsConfig); err == nil {
+			return listener, nil
+		}
 	}
+	config.HTTPPort = models.PortAuto
+	return nil, fmt.Errorf("no free port in range %d-%d", m.portMin, m.portMax)
+}
+
+// portTaken reports whether a registry is configured with port: a running
+// one, or one of a repository that is offline or failed to start, which
+// gets its port back when it starts. The caller must hold m.mu.
+func (m *Manager) portTaken(port int) bool {
+	var configs []*models.DockerRepositoryConfig
+	for _, reg := range m.registries {
+		configs = append(configs, reg.config)
+	}
+	for _, reg := range m.disabled {
+		if reg != nil {
+			configs = append(configs, reg.config)
+		}
+	}
+	for _, config := range m.reserved {
+		configs = append(configs, config)
+	}
+	for _, config := range configs {
+		if config.HTTPPort == port || config.HTTPSPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservePorts keeps the ports of a repository whose registry is not
+// running, because it is offline or failed to start, from being given to
+// a repository with "http_port": "auto". Starting or stopping the
+// repository's registry releases them.
+func (m *Manager) ReservePorts(repoName string, config *models.DockerRepositoryConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reserved[repoName] = config
 }
 
 // StopRegistry stops a Docker registry
@@ -97,6 +334,8 @@ func (m *Manager) StopRegistry(repoName string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	delete(m.disabled, repoName)
+	delete(m.reserved, repoName)
 	registry, exists := m.registries[repoName]
 	if !exists {
 		return fmt.Errorf("no registry running for repository %s", repoName)
@@ -115,6 +354,25 @@ func (m *Manager) StopRegistry(repoName string) error {
 	return nil
 }
 
+// DisableRegistry stops the registry of a repository taken offline, if it
+// is running. Until the registry is started again, requests for it on the
+// main server port are answered with 503.
+func (m *Manager) DisableRegistry(repoName string) error {
+	registry, exists := m.GetRegistry(repoName)
+	if exists {
+		if err := m.StopRegistry(repoName); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.disabled[repoName] = registry
+	m.logger.WithField("repository", repoName).Info("Docker registry disabled")
+	return nil
+}
+
 // GetRegistry returns the registry for a repository
 func (m *Manager) GetRegistry(repoName string) (*Registry, bool) {
 	m.mu.RLock()
@@ -124,6 +382,23 @@ func (m *Manager) GetRegistry(repoName string) (*Registry, bool) {
 	return registry, exists
 }
 
+// Addresses returns the listen address of every running registry with a
+// port of its own, keyed by repository name
+func (m *Manager) Addresses() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	addrs := make(map[string]string, len(m.registries))
+	for name, registry := range m.registries {
+		if OnMainPort(registry.config) {
+			continue
+		}
+		useTLS := registry.config.HTTPSPort > 0 && (m.tlsConfig != nil || registry.config.TLS != nil)
+		addrs[name] = registry.listenAddr(useTLS)
+	}
+	return addrs
+}
+
 // StopAll stops all running registries
 func (m *Manager) StopAll() error {
 	m.mu.Lock()
@@ -148,16 +423,61 @@ func (m *Manager) StopAll() error {
 	return nil
 }
 
-// IsPortInUse checks if a port is already in use by a registry
-func (m *Manager) IsPortInUse(httpPort, httpsPort int) (bool, string) {
+// CloseAll closes the listeners and connections of all running registries
+// at once, cutting off requests in flight. StopAll still stops them.
+func (m *Manager) CloseAll() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, registry := range m.registries {
+		registry.mu.RLock()
+		server := registry.server
+		registry.mu.RUnlock()
+		if server != nil {
+			server.Close()
+		}
+	}
+}
+
+// IsPortInUse checks if a port of config is already in use by a registry
+// bound to the same address
+func (m *Manager) IsPortInUse(config *models.DockerRepositoryConfig) (bool, string) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	for name, reg := range m.registries {
-		if (httpPort > 0 && httpPort == reg.config.HTTPPort) ||
-			(httpsPort > 0 && httpsPort == reg.config.HTTPSPort) {
+		if portsConflict(config, reg.config) {
+			return true, name
+		}
+	}
+	// Without path routing the main port serves a single registry
+	if !m.pathRouting && OnMainPort(config) {
+		if name, _ := m.unroutedRegistry(); name != "" {
 			return true, name
 		}
 	}
 	return false, ""
+}
+
+// portsConflict reports whether two registries would listen on the same
+// port of the same address. A registry without a bind address listens on
+// every interface.
+func portsConflict(a, b *models.DockerRepositoryConfig) bool {
+	if a.BindAddress != "" && b.BindAddress != "" && a.BindAddress != b.BindAddress {
+		return false
+	}
+	return (a.HTTPPort > 0 && a.HTTPPort == b.HTTPPort) ||
+		(a.HTTPSPort > 0 && a.HTTPSPort == b.HTTPSPort)
+}
+
+// ValidateBindAddress checks the address a registry listens on, which is
+// an IP address or a host name without a port
+func ValidateBindAddress(addr string) error {
+	if addr == "" || net.ParseIP(addr) != nil {
+		return nil
+	}
+	if strings.ContainsAny(addr, ":/ ") {
+		return fmt.Errorf("bind_address %q must be an IP address or host name without a port", addr)
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,238 @@
+package docker
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"path"
+	"time"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// Notation signature media types
+const (
+	ArtifactTypeNotationSignature = "application/vnd.cncf.notary.signature"
+	MediaTypeJWSEnvelope          = "application/jose+json"
+	mediaTypeNotationPayload      = "application/vnd.cncf.notary.payload.v1+json"
+)
+
+// maxEnvelopeSize bounds the signature envelopes read from storage
+const maxEnvelopeSize = 1 << 20
+
+// SignatureVerifier checks Notation signatures against a trust store
+type SignatureVerifier struct {
+	roots *x509.CertPool
+	audit bool
+}
+
+// NewSignatureVerifier creates a verifier for a repository's signature
+// policy
+func NewSignatureVerifier(policy *models.SignaturePolicy) (*SignatureVerifier, error) {
+	switch policy.Level {
+	case "", models.SignaturePolicyEnforce, models.SignaturePolicyAudit:
+	default:
+		return nil, fmt.Errorf("unknown signature policy level %q", policy.Level)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(policy.TrustedCertificates)) {
+		return nil, errors.New("trusted_certificates contains no PEM certificates")
+	}
+	return &SignatureVerifier{roots: roots, audit: policy.Level == models.SignaturePolicyAudit}, nil
+}
+
+// jwsEnvelope is a Notation signature in JWS JSON serialization
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		CertificateChain [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+// jwsProtectedHeader holds the signed header fields that are checked
+type jwsProtectedHeader struct {
+	Algorithm   string     `json:"alg"`
+	ContentType string     `json:"cty"`
+	Expiry      *time.Time `json:"io.cncf.notary.expiry,omitempty"`
+}
+
+// notationPayload names the manifest a signature is for
+type notationPayload struct {
+	TargetArtifact Descriptor `json:"targetArtifact"`
+}
+
+// Verify checks that a JWS signature envelope signs the manifest digest
+// with a certificate that chains to the trust store
+func (v *SignatureVerifier) Verify(envelope []byte, digest string) error {
+	var jws jwsEnvelope
+	if err := json.Unmarshal(envelope, &jws); err != nil {
+		return fmt.Errorf("invalid signature envelope: %w", err)
+	}
+	if len(jws.Header.CertificateChain) == 0 {
+		return errors.New("signature has no certificate chain")
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	var header jwsProtectedHeader
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return fmt.Errorf("invalid protected header: %w", err)
+	}
+	if header.ContentType != mediaTypeNotationPayload {
+		return fmt.Errorf("unexpected payload type %q", header.ContentType)
+	}
+	if header.Expiry != nil && time.Now().After(*header.Expiry) {
+		return fmt.Errorf("signature expired at %s", header.Expiry.Format(time.RFC3339))
+	}
+
+	chain := make([]*x509.Certificate, len(jws.Header.CertificateChain))
+	for i, der := range jws.Header.CertificateChain {
+		if chain[i], err = x509.ParseCertificate(der); err != nil {
+			return fmt.Errorf("invalid certificate in chain: %w", err)
+		}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("untrusted signing certificate: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if err := verifyJWS(header.Algorithm, chain[0].PublicKey, []byte(jws.Protected+"."+jws.Payload), signature); err != nil {
+		return err
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	var payload notationPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	if payload.TargetArtifact.Digest != digest {
+		return fmt.Errorf("signature is for %s, not %s", payload.TargetArtifact.Digest, digest)
+	}
+	return nil
+}
+
+// verifyJWS checks a JWS signature with one of the algorithms Notation
+// signs with
+func verifyJWS(algorithm string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+	h := hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm[0] != 'P' {
+			return fmt.Errorf("algorithm %s does not match an RSA key", algorithm)
+		}
+		if err := rsa.VerifyPSS(key, hash, hashed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}); err != nil {
+			return errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if algorithm[0] != 'E' {
+			return fmt.Errorf("algorithm %s does not match an ECDSA key", algorithm)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, hashed, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return nil
+}
+
+// verifySignature reports whether the manifest digest of an image, or a
+// manifest list containing it, has a Notation signature that verifier
+// accepts. The caller must hold r.mu.
+func (r *Registry) verifySignature(verifier *SignatureVerifier, name, digest string) error {
+	err := r.verifyReferrers(verifier, name, digest)
+	if err == nil {
+		return nil
+	}
+	// Clients pull the images of a signed manifest list by digest
+	for reference, manifest := range r.manifests[name] {
+		if !manifest.isList() || reference != digestOf(manifest.Raw) {
+			continue
+		}
+		for _, child := range manifest.Manifests {
+			if child.Digest == digest && r.verifyReferrers(verifier, name, reference) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// verifyReferrers verifies the Notation signatures referring to digest
+// until one is valid
+func (r *Registry) verifyReferrers(verifier *SignatureVerifier, name, digest string) error {
+	err := errors.New("no signature")
+	for _, desc := range r.referrers(name, digest, ArtifactTypeNotationSignature) {
+		signature := r.manifests[name][desc.Digest]
+		for _, layer := range signature.Layers {
+			if layer.MediaType != MediaTypeJWSEnvelope {
+				err = fmt.Errorf("unsupported signature envelope %s", layer.MediaType)
+				continue
+			}
+			envelope, readErr := r.readBlob(name, layer.Digest)
+			if readErr != nil {
+				err = readErr
+				continue
+			}
+			if err = verifier.Verify(envelope, digest); err == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// readBlob reads a small blob such as a signature envelope
+func (r *Registry) readBlob(name, digest string) ([]byte, error) {
+	reader, err := r.storage.Retrieve(name, path.Join(blobsDir, digest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxEnvelopeSize))
+}
@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// testSigner issues a code signing certificate from its own CA
+type testSigner struct {
+	caPEM string
+	key   *ecdsa.PrivateKey
+	chain [][]byte
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return &testSigner{
+		caPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		key:   key,
+		chain: [][]byte{leafDER, caDER},
+	}
+}
+
+// sign returns a JWS envelope signing the manifest digest
+func (s *testSigner) sign(t *testing.T, digest string) []byte {
+	encode := base64.RawURLEncoding.EncodeToString
+	protected := encode([]byte(`{"alg":"ES256","cty":"application/vnd.cncf.notary.payload.v1+json"}`))
+	payload := encode([]byte(fmt.Sprintf(`{"targetArtifact":{"mediaType":%q,"digest":%q,"size":1}}`, MediaTypeOCIManifest, digest)))
+	hashed := sha256.Sum256([]byte(protected + "." + payload))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, hashed[:])
+	require.NoError(t, err)
+	signature := append(r.FillBytes(make([]byte, 32)), sig.FillBytes(make([]byte, 32))...)
+
+	envelope, err := json.Marshal(map[string]interface{}{
+		"payload":   payload,
+		"protected": protected,
+		"header":    map[string]interface{}{"x5c": s.chain},
+		"signature": encode(signature),
+	})
+	require.NoError(t, err)
+	return envelope
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	trusted := newTestSigner(t)
+	untrusted := newTestSigner(t)
+
+	newRegistry := func(level string) *Registry {
+		config := &models.DockerRepositoryConfig{SignaturePolicy: &models.SignaturePolicy{Level: level, TrustedCertificates: trusted.caPEM}}
+		return NewRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, config, storage.NewFileStorage(t.TempDir()), logrus.New())
+	}
+	serve := func(registry *Registry, method, url, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(string(body)))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushImage := func(registry *Registry, tag string) string {
+		image := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":2,"digest":%q},"layers":[],"annotations":{"tag":%q}}`,
+			MediaTypeOCIManifest, MediaTypeOCIConfig, digestOf([]byte("{}")), tag)
+		w := serve(registry, "PUT", "/v2/app/manifests/"+tag, MediaTypeOCIManifest, []byte(image))
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+	pushSignature := func(registry *Registry, digest string, envelope []byte) string {
+		w := serve(registry, "POST", "/v2/app/blobs/uploads/", "", nil)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		envelopeDigest := digestOf(envelope)
+		w = serve(registry, "PUT", w.Header().Get("Location")+"?digest="+envelopeDigest, "application/octet-stream", envelope)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		signature := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"artifactType":%q,"config":{"mediaType":%q,"size":2,"digest":%q},`+
+			`"layers":[{"mediaType":%q,"size":%d,"digest":%q}],"subject":{"mediaType":%q,"size":1,"digest":%q}}`,
+			MediaTypeOCIManifest, ArtifactTypeNotationSignature, MediaTypeOCIEmpty, digestOf([]byte("{}")),
+			MediaTypeJWSEnvelope, len(envelope), envelopeDigest, MediaTypeOCIManifest, digest)
+		signatureDigest := digestOf([]byte(signature))
+		w = serve(registry, "PUT", "/v2/app/manifests/"+signatureDigest, MediaTypeOCIManifest, []byte(signature))
+		require.Equal(t, http.StatusCreated, w.Code)
+		return signatureDigest
+	}
+
+	t.Run("Enforce", func(t *testing.T) {
+		registry := newRegistry("")
+		unsigned := pushImage(registry, "unsigned")
+		signed := pushImage(registry, "signed")
+		signatureDigest := pushSignature(registry, signed, trusted.sign(t, signed))
+		foreign := pushImage(registry, "foreign")
+		pushSignature(registry, foreign, untrusted.sign(t, foreign))
+		// A valid signature for a different image
+		misplaced := pushImage(registry, "misplaced")
+		pushSignature(registry, misplaced, trusted.sign(t, unsigned))
+
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/signed", "", nil).Code)
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/"+signed, "", nil).Code)
+		assert.Equal(t, http.StatusForbidden, serve(registry, "GET", "/v2/app/manifests/unsigned", "", nil).Code)
+		assert.Equal(t, http.StatusForbidden, serve(registry, "GET", "/v2/app/manifests/foreign", "", nil).Code)
+		assert.Equal(t, http.StatusForbidden, serve(registry, "GET", "/v2/app/manifests/misplaced", "", nil).Code)
+
+		// Signatures themselves can be pulled for verification
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/"+signatureDigest, "", nil).Code)
+	})
+
+	t.Run("Signed manifest list", func(t *testing.T) {
+		registry := newRegistry("")
+		child := pushImage(registry, "amd64")
+		list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"size":1,"digest":%q,"platform":{"architecture":"amd64","os":"linux"}}]}`,
+			MediaTypeOCIManifestList, MediaTypeOCIManifest, child)
+		w := serve(registry, "PUT", "/v2/app/manifests/1.0", MediaTypeOCIManifestList, []byte(list))
+		require.Equal(t, http.StatusCreated, w.Code)
+		listDigest := w.Header().Get("Docker-Content-Digest")
+
+		assert.Equal(t, http.StatusForbidden, serve(registry, "GET", "/v2/app/manifests/"+child, "", nil).Code)
+		pushSignature(registry, listDigest, trusted.sign(t, listDigest))
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/1.0", "", nil).Code)
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/"+child, "", nil).Code)
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		registry := newRegistry(models.SignaturePolicyAudit)
+		pushImage(registry, "unsigned")
+		assert.Equal(t, http.StatusOK, serve(registry, "GET", "/v2/app/manifests/unsigned", "", nil).Code)
+	})
+
+	t.Run("Invalid policy", func(t *testing.T) {
+		_, err := NewSignatureVerifier(&models.SignaturePolicy{TrustedCertificates: "none"})
+		assert.Error(t, err)
+		_, err = NewSignatureVerifier(&models.SignaturePolicy{Level: "strict", TrustedCertificates: trusted.caPEM})
+		assert.Error(t, err)
+	})
+}
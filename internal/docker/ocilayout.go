@@ -0,0 +1,487 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Files of an OCI image layout
+const (
+	ociLayoutFile    = "oci-layout"
+	ociIndexFile     = "index.json"
+	ociLayoutVersion = "1.0.0"
+	// ociRefName is the annotation naming the tag of a manifest in
+	// index.json
+	ociRefName = "org.opencontainers.image.ref.name"
+)
+
+var (
+	// ErrInvalidLayout is returned when an upload is not a usable OCI
+	// image layout
+	ErrInvalidLayout = errors.New("invalid OCI image layout")
+	// ErrReadOnly is returned when importing into a proxy repository
+	ErrReadOnly = errors.New("proxy repositories are read-only")
+)
+
+// ImportReport describes an OCI image layout imported into a repository.
+// Imported lists the tags imported, as image:tag, and the untagged
+// manifests, as image@digest.
+type ImportReport struct {
+	Repository string   `json:"repository"`
+	Image      string   `json:"image"`
+	Imported   []string `json:"imported"`
+	Blobs      int      `json:"blobs"`
+}
+
+// ImportOCILayout imports the images of an OCI image layout, as a tar or
+// tar.gz archive such as skopeo or BuildKit write, into an image of a
+// repository. Each manifest in index.json is tagged with its
+// org.opencontainers.image.ref.name annotation; tag names the manifest if
+// the index has a single one without the annotation. Blobs must match their
+// digest and every referenced blob and manifest must be present, otherwise
+// nothing is imported.
+func (m *Manager) ImportOCILayout(repoName, image, tag string, layout io.Reader) (*ImportReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy != nil {
+		return nil, ErrReadOnly
+	}
+	if _, _, err := ParseImageReference(image); err != nil || strings.ContainsAny(image, ":@") {
+		return nil, fmt.Errorf("invalid image name %q", image)
+	}
+	return registry.importLayout(image, tag, layout)
+}
+
+// layoutImport tracks what an import wrote, so a failed import can be
+// rolled back
+type layoutImport struct {
+	registry *Registry
+	image    string
+	blobs    map[string]bool // digests stored or already present
+	written  []string        // blobs and manifests stored
+	undo     []func()        // restore the tags and index entries replaced
+	claimed  bool            // the image was new to the registry
+}
+
+func (r *Registry) importLayout(image, tag string, layout io.Reader) (*ImportReport, error) {
+	imp := &layoutImport{registry: r, image: image, blobs: make(map[string]bool)}
+	index, err := imp.readArchive(layout)
+	if err == nil {
+		var manifests []ociManifest
+		if manifests, err = imp.manifests(index, tag); err == nil {
+			var report *ImportReport
+			if report, err = imp.publish(manifests); err == nil {
+				return report, nil
+			}
+		}
+	}
+	imp.rollback()
+	return nil, err
+}
+
+// readArchive stores the blobs of a layout archive, returning its index
+func (imp *layoutImport) readArchive(layout io.Reader) (*Manifest, error) {
+	buffered := bufio.NewReader(layout)
+	var reader io.Reader = buffered
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var index *Manifest
+	versioned := false
+	limit := imp.registry.uploadLimit()
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLayout, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		switch {
+		case name == ociLayoutFile:
+			var marker struct {
+				Version string `json:"imageLayoutVersion"`
+			}
+			if err := json.NewDecoder(io.LimitReader(archive, maxUpstreamManifestSize)).Decode(&marker); err != nil || marker.Version != ociLayoutVersion {
+				return nil, fmt.Errorf("%w: unsupported %s", ErrInvalidLayout, ociLayoutFile)
+			}
+			versioned = true
+		case name == ociIndexFile:
+			data, err := io.ReadAll(io.LimitReader(archive, maxUpstreamManifestSize+1))
+			if err != nil {
+				return nil, err
+			}
+			index = &Manifest{}
+			if len(data) > maxUpstreamManifestSize || json.Unmarshal(data, index) != nil {
+				return nil, fmt.Errorf("%w: unreadable %s", ErrInvalidLayout, ociIndexFile)
+			}
+		case strings.HasPrefix(name, "blobs/"):
+			if limit > 0 && header.Size > limit {
+				return nil, fmt.Errorf("blob %s exceeds maximum upload size of %d bytes", name, limit)
+			}
+			if err := imp.storeBlob(name, archive); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if !versioned || index == nil {
+		return nil, fmt.Errorf("%w: %s and %s are required", ErrInvalidLayout, ociLayoutFile, ociIndexFile)
+	}
+	return index, nil
+}
+
+// storeBlob stores a blobs/<algorithm>/<hex> entry, checking it against
+// its digest
+func (imp *layoutImport) storeBlob(name string, data io.Reader) error {
+	algorithm, encoded, _ := strings.Cut(strings.TrimPrefix(name, "blobs/"), "/")
+	digest := algorithm + ":" + encoded
+	if !validDigest(digest) {
+		return fmt.Errorf("%w: unsupported blob %s", ErrInvalidLayout, name)
+	}
+
+	blobPath := path.Join(blobsDir, digest)
+	if exists, err := imp.registry.storage.Exists(imp.image, blobPath); err != nil {
+		return err
+	} else if !exists {
+		verified := &verifyingReader{reader: data, hash: sha256.New(), digest: digest}
+		if err := imp.registry.storage.Store(imp.image, blobPath, verified); err != nil {
+			return fmt.Errorf("%w: blob %s: %v", ErrInvalidLayout, digest, err)
+		}
+		imp.written = append(imp.written, blobPath)
+	}
+	imp.blobs[digest] = true
+	return nil
+}
+
+// ociManifest is a manifest of a layout to publish, with the tag it is
+// given, if any
+type ociManifest struct {
+	digest   string
+	tag      string
+	manifest *Manifest
+}
+
+// manifests reads the manifests the index refers to, children before the
+// manifest lists that hold them, checking every blob they need is stored
+func (imp *layoutImport) manifests(index *Manifest, tag string) ([]ociManifest, error) {
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("%w: %s lists no manifests", ErrInvalidLayout, ociIndexFile)
+	}
+
+	var manifests []ociManifest
+	seen := make(map[string]bool)
+	var read func(desc Descriptor, tag string) error
+	read = func(desc Descriptor, tag string) error {
+		if seen[desc.Digest] {
+			if tag != "" {
+				manifests = append(manifests, ociManifest{digest: desc.Digest, tag: tag})
+			}
+			return nil
+		}
+		seen[desc.Digest] = true
+
+		if !imp.blobs[desc.Digest] {
+			return fmt.Errorf("%w: manifest %s is missing", ErrInvalidLayout, desc.Digest)
+		}
+		data, err := readAll(imp.registry.storage, imp.image, path.Join(blobsDir, desc.Digest))
+		if err != nil {
+			return err
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%w: manifest %s: %v", ErrInvalidLayout, desc.Digest, err)
+		}
+		manifest.Raw = data
+		if manifest.MediaType == "" {
+			manifest.MediaType = desc.MediaType
+		}
+
+		for _, child := range manifest.Manifests {
+			if err := read(child.Descriptor, ""); err != nil {
+				return err
+			}
+		}
+		for _, blob := range manifest.blobs() {
+			if len(blob.URLs) == 0 && !imp.blobs[blob.Digest] {
+				return fmt.Errorf("%w: blob %s of manifest %s is missing", ErrInvalidLayout, blob.Digest, desc.Digest)
+			}
+		}
+		manifests = append(manifests, ociManifest{digest: desc.Digest, tag: tag, manifest: &manifest})
+		return nil
+	}
+
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations[ociRefName]
+		if ref == "" && len(index.Manifests) == 1 {
+			ref = tag
+		}
+		if strings.ContainsAny(ref, "/:@") {
+			// A full reference such as docker.io/library/nginx:1.25
+			if _, ref, _ = ParseImageReference(ref); strings.HasPrefix(ref, "sha256:") {
+				ref = ""
+			}
+		}
+		if ref != "" && !validTag(ref) {
+			return nil, fmt.Errorf("%w: invalid tag %q for manifest %s", ErrInvalidLayout, ref, desc.Digest)
+		}
+		if err := read(desc.Descriptor, ref); err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// publish stores the manifests of an import and indexes them and their
+// tags
+func (imp *layoutImport) publish(manifests []ociManifest) (*ImportReport, error) {
+	r := imp.registry
+	report := &ImportReport{Repository: r.repo.Name, Image: imp.image, Imported: []string{}, Blobs: len(imp.blobs)}
+	stored := make(map[string]*Manifest)
+	r.mu.RLock()
+	_, known := r.manifests[imp.image]
+	r.mu.RUnlock()
+	imp.claimed = !known
+	for _, m := range manifests {
+		if m.manifest != nil {
+			manifestPath := path.Join(manifestsDir, m.digest)
+			exists, err := r.storage.Exists(imp.image, manifestPath)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				if err := r.storage.Store(imp.image, manifestPath, bytes.NewReader(m.manifest.Raw)); err != nil {
+					return nil, fmt.Errorf("failed to store manifest %s: %w", m.digest, err)
+				}
+				imp.written = append(imp.written, manifestPath)
+			}
+			imp.index(m.digest)
+			r.putManifest(imp.image, m.digest, m.manifest)
+			stored[m.digest] = m.manifest
+		}
+
+		reference := m.digest
+		if m.tag != "" {
+			reference = m.tag
+			if err := imp.keepTag(m.tag); err != nil {
+				return nil, err
+			}
+			imp.index(m.tag)
+			r.putManifest(imp.image, m.tag, stored[m.digest])
+		}
+		if err := r.storeTag(imp.image, reference, m.digest); err != nil {
+			return nil, fmt.Errorf("failed to store tag %s: %w", reference, err)
+		}
+		if m.tag != "" || m.manifest != nil {
+			report.Imported = append(report.Imported, imageReference(imp.image, reference))
+		}
+	}
+
+	if r.onPush != nil {
+		for _, imported := range report.Imported {
+			if !strings.Contains(imported, "@") {
+				r.onPush(r.repo.Name, imported)
+			}
+		}
+	}
+	r.logger.WithField("repository", r.repo.Name).Infof("Imported %d manifests of OCI image layout into %s", len(stored), imp.image)
+	return report, nil
+}
+
+// index records how to restore the index entry of a reference that
+// publish is about to replace
+func (imp *layoutImport) index(reference string) {
+	r := imp.registry
+	previous, existed := r.getManifest(imp.image, reference)
+	imp.undo = append(imp.undo, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if existed {
+			r.manifests[imp.image][reference] = previous
+		} else if manifests, ok := r.manifests[imp.image]; ok {
+			delete(manifests, reference)
+		}
+	})
+}
+
+// keepTag records how to restore a tag in storage that publish is about
+// to move
+func (imp *layoutImport) keepTag(tag string) error {
+	store, tagPath := imp.registry.storage, path.Join(tagsDir, tag)
+	exists, err := store.Exists(imp.image, tagPath)
+	if err != nil {
+		return err
+	}
+	var previous []byte
+	if exists {
+		if previous, err = readAll(store, imp.image, tagPath); err != nil {
+			return err
+		}
+	}
+	imp.undo = append(imp.undo, func() {
+		if exists {
+			_ = store.Store(imp.image, tagPath, bytes.NewReader(previous))
+		} else {
+			_ = store.Delete(imp.image, tagPath)
+		}
+	})
+	return nil
+}
+
+// rollback undoes what a failed import published, then removes the blobs
+// and manifests it stored
+func (imp *layoutImport) rollback() {
+	r := imp.registry
+	for i := len(imp.undo) - 1; i >= 0; i-- {
+		imp.undo[i]()
+	}
+	for _, written := range imp.written {
+		_ = r.storage.Delete(imp.image, written)
+	}
+	if imp.claimed {
+		r.mu.Lock()
+		if len(r.manifests[imp.image]) == 0 {
+			delete(r.manifests, imp.image)
+		}
+		r.mu.Unlock()
+		_ = r.releaseImage(imp.image)
+	}
+}
+
+// LayoutExport is an image to write as an OCI image layout
+type LayoutExport struct {
+	registry *Registry
+	image    string
+	index    []byte
+	blobs    []Descriptor // manifests, configs and layers, each once
+}
+
+// ExportOCILayout prepares an image of a repository, by tag or digest, for
+// writing as an OCI image layout with every manifest and blob it needs.
+// Foreign layers, which clients fetch from their URLs, are left out.
+func (m *Manager) ExportOCILayout(repoName, image, reference string) (*LayoutExport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	manifest, exists := registry.getManifest(image, reference)
+	if !exists {
+		return nil, ErrManifestNotFound
+	}
+
+	export := &LayoutExport{registry: registry, image: image}
+	seen := make(map[string]bool)
+	add := func(desc Descriptor) {
+		if !seen[desc.Digest] {
+			seen[desc.Digest] = true
+			export.blobs = append(export.blobs, desc)
+		}
+	}
+	var collect func(manifest *Manifest) error
+	collect = func(manifest *Manifest) error {
+		add(Descriptor{MediaType: manifest.MediaType, Digest: digestOf(manifest.Raw), Size: int64(len(manifest.Raw))})
+		for _, child := range manifest.Manifests {
+			childManifest, exists := registry.getManifest(image, child.Digest)
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrManifestNotFound, child.Digest)
+			}
+			if err := collect(childManifest); err != nil {
+				return err
+			}
+		}
+		for _, blob := range manifest.blobs() {
+			if len(blob.URLs) > 0 {
+				continue
+			}
+			if exists, err := registry.storage.Exists(image, path.Join(blobsDir, blob.Digest)); err != nil || !exists {
+				return fmt.Errorf("blob %s is missing", blob.Digest)
+			}
+			add(blob)
+		}
+		return nil
+	}
+	if err := collect(manifest); err != nil {
+		return nil, err
+	}
+
+	root := ManifestDescriptor{Descriptor: Descriptor{MediaType: manifest.MediaType, Digest: digestOf(manifest.Raw), Size: int64(len(manifest.Raw))}}
+	if !strings.HasPrefix(reference, "sha256:") {
+		root.Annotations = map[string]string{ociRefName: reference}
+	}
+	index, err := json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifestList, Manifests: []ManifestDescriptor{root}})
+	if err != nil {
+		return nil, err
+	}
+	export.index = index
+	return export, nil
+}
+
+// Write writes the layout as a tar archive
+func (e *LayoutExport) Write(w io.Writer) error {
+	archive := tar.NewWriter(w)
+	now := time.Now()
+	writeFile := func(name string, data []byte) error {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+
+	if err := writeFile(ociLayoutFile, []byte(`{"imageLayoutVersion":"`+ociLayoutVersion+`"}`)); err != nil {
+		return err
+	}
+	if err := writeFile(ociIndexFile, e.index); err != nil {
+		return err
+	}
+	for _, blob := range e.blobs {
+		name := "blobs/" + strings.Replace(blob.Digest, ":", "/", 1)
+		if manifest, exists := e.registry.getManifest(e.image, blob.Digest); exists {
+			if err := writeFile(name, manifest.Raw); err != nil {
+				return err
+			}
+			continue
+		}
+
+		reader, err := e.registry.storage.Retrieve(e.image, path.Join(blobsDir, blob.Digest))
+		if err != nil {
+			return err
+		}
+		info, err := e.registry.storage.Stat(e.image, path.Join(blobsDir, blob.Digest))
+		if err == nil {
+			err = archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size, ModTime: info.ModTime, Typeflag: tar.TypeReg})
+		}
+		if err == nil {
+			_, err = io.Copy(archive, reader)
+		}
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
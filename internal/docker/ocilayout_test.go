@@ -0,0 +1,164 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestOCILayoutRoundTrip(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	for _, name := range []string{"builds", "airgap"} {
+		require.NoError(t, manager.StartRegistry(&models.Repository{Name: name, Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	}
+	builds, _ := manager.GetRegistry("builds")
+	airgap, _ := manager.GetRegistry("airgap")
+	config := `{"architecture":"amd64","os":"linux"}`
+	digest := pushImage(t, builds, "team/web", "1.0", config)
+
+	_, err := manager.ExportOCILayout("builds", "team/web", "2.0")
+	assert.ErrorIs(t, err, ErrManifestNotFound)
+	export, err := manager.ExportOCILayout("builds", "team/web", "1.0")
+	require.NoError(t, err)
+	var layout bytes.Buffer
+	require.NoError(t, export.Write(&layout))
+
+	// The layout names the tag and holds the manifest and its config
+	files := make(map[string]string)
+	archive := tar.NewReader(bytes.NewReader(layout.Bytes()))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(archive)
+		require.NoError(t, err)
+		files[header.Name] = string(data)
+	}
+	assert.JSONEq(t, `{"imageLayoutVersion":"1.0.0"}`, files[ociLayoutFile])
+	assert.Contains(t, files[ociIndexFile], `"org.opencontainers.image.ref.name":"1.0"`)
+	assert.Equal(t, config, files["blobs/sha256/"+digestOf([]byte(config))[len("sha256:"):]])
+	assert.Contains(t, files, "blobs/sha256/"+digest[len("sha256:"):])
+
+	// Imported into another repository, gzipped, the image pulls as pushed
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(layout.Bytes())
+	require.NoError(t, gz.Close())
+	report, err := manager.ImportOCILayout("airgap", "mirrored/web", "", &compressed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirrored/web:1.0"}, report.Imported)
+	assert.Equal(t, 2, report.Blobs)
+
+	w := serveRegistry(airgap, "GET", "/v2/mirrored/web/manifests/1.0", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+	w = serveRegistry(airgap, "GET", "/v2/mirrored/web/blobs/"+digestOf([]byte(config)), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, config, w.Body.String())
+}
+
+func TestImportOCILayoutRejectsIncompleteLayouts(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "airgap", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	airgap, _ := manager.GetRegistry("airgap")
+
+	// layout builds an archive of the given files
+	layout := func(files map[string]string) io.Reader {
+		var buf bytes.Buffer
+		archive := tar.NewWriter(&buf)
+		for name, data := range files {
+			require.NoError(t, archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+			archive.Write([]byte(data))
+		}
+		require.NoError(t, archive.Close())
+		return &buf
+	}
+	blob := func(data string) string { return "blobs/sha256/" + digestOf([]byte(data))[len("sha256:"):] }
+
+	config := `{"architecture":"arm64"}`
+	manifest := `{"schemaVersion":2,"mediaType":"` + MediaTypeOCIManifest + `","config":{"mediaType":"application/vnd.oci.image.config.v1+json","size":24,"digest":"` + digestOf([]byte(config)) + `"},"layers":[]}`
+	index := `{"schemaVersion":2,"manifests":[{"mediaType":"` + MediaTypeOCIManifest + `","size":1,"digest":"` + digestOf([]byte(manifest)) + `"}]}`
+	marker := `{"imageLayoutVersion":"1.0.0"}`
+
+	for name, files := range map[string]map[string]string{
+		"missing marker": {ociIndexFile: index, blob(manifest): manifest, blob(config): config},
+		"missing config": {ociLayoutFile: marker, ociIndexFile: index, blob(manifest): manifest},
+		"corrupt blob":   {ociLayoutFile: marker, ociIndexFile: index, blob(manifest): manifest, blob(config): config + " "},
+	} {
+		_, err := manager.ImportOCILayout("airgap", "web", "1.0", layout(files))
+		assert.True(t, errors.Is(err, ErrInvalidLayout), "%s: %v", name, err)
+		stored, err := airgap.storage.List("web", "")
+		require.NoError(t, err)
+		assert.Empty(t, stored, "%s: blobs of a failed import were kept", name)
+	}
+
+	// Without a ref.name annotation the single manifest takes the given tag
+	report, err := manager.ImportOCILayout("airgap", "web", "1.0", layout(map[string]string{
+		"./" + ociLayoutFile: marker, ociIndexFile: index, blob(manifest): manifest, blob(config): config,
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web:1.0"}, report.Imported)
+	assert.Equal(t, http.StatusOK, serveRegistry(airgap, "GET", "/v2/web/manifests/1.0", "", "").Code)
+
+	// Tags from annotations must be valid
+	annotated := func(entries ...string) string {
+		return `{"schemaVersion":2,"manifests":[` + strings.Join(entries, ",") + `]}`
+	}
+	entry := func(data, tag string) string {
+		return `{"mediaType":"` + MediaTypeOCIManifest + `","size":1,"digest":"` + digestOf([]byte(data)) + `","annotations":{"` + ociRefName + `":"` + tag + `"}}`
+	}
+	_, err = manager.ImportOCILayout("airgap", "web", "", layout(map[string]string{
+		ociLayoutFile: marker, ociIndexFile: annotated(entry(manifest, "-bad")), blob(manifest): manifest, blob(config): config,
+	}))
+	assert.ErrorIs(t, err, ErrInvalidLayout)
+
+	// A failure while publishing takes back the manifests and tags already
+	// published, and web:1.0 keeps pointing where it did
+	other := strings.Replace(manifest, `"layers":[]`, `"layers":[],"annotations":{"build":"2"}`, 1)
+	airgap.storage = &failingStorage{Storage: airgap.storage, path: path.Join(tagsDir, "2.0")}
+	_, err = manager.ImportOCILayout("airgap", "web", "", layout(map[string]string{
+		ociLayoutFile: marker, ociIndexFile: annotated(entry(other, "1.0"), entry(other, "2.0")),
+		blob(other): other, blob(config): config,
+	}))
+	require.Error(t, err)
+	w := serveRegistry(airgap, "GET", "/v2/web/manifests/1.0", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digestOf([]byte(manifest)), w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, http.StatusNotFound, serveRegistry(airgap, "GET", "/v2/web/manifests/"+digestOf([]byte(other)), "", "").Code)
+	exists, err := airgap.storage.Exists("web", path.Join(manifestsDir, digestOf([]byte(other))))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	tag, err := readAll(airgap.storage, "web", path.Join(tagsDir, "1.0"))
+	require.NoError(t, err)
+	assert.Equal(t, digestOf([]byte(manifest)), string(tag))
+}
+
+// failingStorage fails to store one path
+type failingStorage struct {
+	storage.Storage
+	path string
+}
+
+func (s *failingStorage) Store(name, p string, data io.Reader) error {
+	if p == s.path {
+		return errors.New("disk full")
+	}
+	return s.Storage.Store(name, p, data)
+}
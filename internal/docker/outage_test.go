@@ -0,0 +1,110 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestProxyOutage(t *testing.T) {
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[]}`, MediaTypeDockerSchema2Manifest)
+
+	var down atomic.Bool
+	var requests, missing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch {
+		case down.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasSuffix(req.URL.Path, "/manifests/latest"):
+			w.Header().Set("Content-Type", MediaTypeDockerSchema2Manifest)
+			w.Write([]byte(manifest))
+		case strings.HasSuffix(req.URL.Path, "/tags/list"):
+			w.Write([]byte(`{"tags":["latest"]}`))
+		default:
+			atomic.AddInt32(&missing, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{
+		Upstreams:      []models.DockerUpstream{{Namespace: "docker.io", URL: server.URL}},
+		NotFoundTTL:    "1h",
+		CircuitBreaker: &models.CircuitBreaker{Threshold: 2, Cooldown: "1h"},
+	}}
+	require.NoError(t, ValidateProxy(config.Proxy))
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config, storage.NewFileStorage(t.TempDir()), logrus.New())
+	u := mirror.proxy.upstreams["docker.io"][0]
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mirror.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	t.Run("Not Found Is Cached", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/v2/library/app/manifests/missing").Code)
+		assert.Equal(t, http.StatusNotFound, get("/v2/library/app/manifests/missing").Code)
+		blob := "/v2/library/app/blobs/" + digestOf([]byte("missing"))
+		assert.Equal(t, http.StatusNotFound, get(blob).Code)
+		assert.Equal(t, http.StatusNotFound, get(blob).Code)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&missing))
+	})
+
+	t.Run("Stale Content During Outage", func(t *testing.T) {
+		w := get("/v2/library/app/manifests/latest")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Warning"))
+
+		down.Store(true)
+		w = get("/v2/library/app/manifests/latest")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, staleWarning, w.Header().Get("Warning"))
+		w = get("/v2/library/app/tags/list")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, staleWarning, w.Header().Get("Warning"))
+	})
+
+	t.Run("Circuit Opens", func(t *testing.T) {
+		status := u.status()
+		assert.Equal(t, CircuitOpen, status.Circuit)
+		require.NotNil(t, status.OpenUntil)
+		assert.True(t, status.OpenUntil.After(time.Now().Add(59*time.Minute)))
+
+		// The upstream is no longer asked; cached content is still served
+		before := atomic.LoadInt32(&requests)
+		w := get("/v2/library/app/manifests/latest")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, staleWarning, w.Header().Get("Warning"))
+		assert.Equal(t, http.StatusBadGateway, get("/v2/library/other/manifests/latest").Code)
+		assert.Equal(t, before, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Circuit Closes After Cooldown", func(t *testing.T) {
+		u.mu.Lock()
+		failedAt := time.Now().Add(-2 * time.Hour)
+		u.health.FailedAt = &failedAt
+		u.mu.Unlock()
+		assert.Equal(t, CircuitHalfOpen, u.status().Circuit)
+
+		down.Store(false)
+		w := get("/v2/library/app/manifests/latest")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("Warning"))
+		status := u.status()
+		assert.Equal(t, CircuitClosed, status.Circuit)
+		assert.Nil(t, status.OpenUntil)
+	})
+}
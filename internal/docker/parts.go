@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errRangeInvalid is returned for a chunk whose Content-Range does not
+// match its length or overlaps data the upload already has
+var errRangeInvalid = errors.New("invalid content range")
+
+// errUploadTooLarge is returned for a chunk that would take an upload past
+// the size limit
+var errUploadTooLarge = errors.New("upload exceeds the size limit")
+
+// parseContentRange parses the Content-Range of a chunk, "<start>-<end>"
+// with end inclusive, also accepting the "bytes <start>-<end>/<total>"
+// form. ok is false if the header is absent.
+func parseContentRange(header string) (start, end int64, ok bool, err error) {
+	if header == "" {
+		return 0, 0, false, nil
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(header, "bytes"))
+	value = strings.TrimPrefix(value, "=")
+	value, _, _ = strings.Cut(value, "/")
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, true, errRangeInvalid
+	}
+	start, err = strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, true, errRangeInvalid
+	}
+	end, err = strconv.ParseInt(strings.TrimSpace(last), 10, 64)
+	if err != nil || end < start {
+		return 0, 0, true, errRangeInvalid
+	}
+	return start, end, true, nil
+}
+
+// received returns the bytes the upload holds, including chunks that
+// arrived ahead of the rest
+func (u *Upload) received() int64 {
+	n := u.Size
+	for _, part := range u.Parts {
+		n += int64(len(part))
+	}
+	return n
+}
+
+// addChunk adds a chunk at offset. A chunk continuing the data is appended
+// along with any parts it joins up with; one further ahead is kept as a
+// part. Chunks may not overlap what the upload holds, nor take it past
+// limit unless limit is 0. Chunks sent in parallel are each checked
+// against the limit before they are read, so it is checked again here.
+func (u *Upload) addChunk(offset int64, chunk []byte, limit int64) error {
+	end := offset + int64(len(chunk))
+	if offset < u.Size {
+		return errRangeInvalid
+	}
+	for start, part := range u.Parts {
+		if offset < start+int64(len(part)) && start < end {
+			return errRangeInvalid
+		}
+	}
+	if limit > 0 && (u.received()+int64(len(chunk)) > limit || end > limit) {
+		return errUploadTooLarge
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	if offset > u.Size {
+		if u.Parts == nil {
+			u.Parts = make(map[int64][]byte)
+		}
+		u.Parts[offset] = chunk
+		return nil
+	}
+	u.Data = append(u.Data, chunk...)
+	for {
+		part, ok := u.Parts[int64(len(u.Data))]
+		if !ok {
+			break
+		}
+		delete(u.Parts, int64(len(u.Data)))
+		u.Data = append(u.Data, part...)
+	}
+	u.Size = int64(len(u.Data))
+	return nil
+}
+
+// writeRangeError refuses a chunk that does not fit the upload, telling
+// the client how much of the blob has arrived from its start
+func (r *Registry) writeRangeError(w http.ResponseWriter, location string, size int64) {
+	w.Header().Set("Location", location)
+	w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	r.writeError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", "chunk does not fit the upload", nil)
+}
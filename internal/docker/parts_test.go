@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestParseContentRange(t *testing.T) {
+	for header, want := range map[string][2]int64{
+		"0-9":             {0, 9},
+		"10-19":           {10, 19},
+		"bytes 10-19/100": {10, 19},
+		"bytes=5-5":       {5, 5},
+	} {
+		start, end, ok, err := parseContentRange(header)
+		require.NoError(t, err, header)
+		assert.True(t, ok, header)
+		assert.Equal(t, want, [2]int64{start, end}, header)
+	}
+	for _, header := range []string{"10", "a-b", "9-0", "-1-5"} {
+		_, _, ok, err := parseContentRange(header)
+		assert.True(t, ok, header)
+		assert.ErrorIs(t, err, errRangeInvalid, header)
+	}
+	_, _, ok, err := parseContentRange("")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestAddChunk(t *testing.T) {
+	upload := &Upload{}
+	require.NoError(t, upload.addChunk(10, []byte("0123456789"), 0))
+	require.NoError(t, upload.addChunk(25, []byte("xyz"), 0))
+	assert.Equal(t, int64(0), upload.Size)
+	assert.Equal(t, int64(13), upload.received())
+
+	// Overlapping what arrived already
+	assert.ErrorIs(t, upload.addChunk(15, []byte("abc"), 0), errRangeInvalid)
+	assert.ErrorIs(t, upload.addChunk(5, []byte("abcdefgh"), 0), errRangeInvalid)
+
+	// Filling the first gap joins up the data up to the next one
+	require.NoError(t, upload.addChunk(0, []byte("abcdefghij"), 0))
+	assert.Equal(t, "abcdefghij0123456789", string(upload.Data))
+	assert.Equal(t, int64(20), upload.Size)
+	assert.Len(t, upload.Parts, 1)
+	assert.ErrorIs(t, upload.addChunk(3, []byte("a"), 0), errRangeInvalid)
+
+	require.NoError(t, upload.addChunk(20, []byte("vwxy"), 0))
+	require.NoError(t, upload.addChunk(24, []byte("!"), 0))
+	assert.Equal(t, "abcdefghij0123456789vwxy!xyz", string(upload.Data))
+	assert.Empty(t, upload.Parts)
+
+	// Neither the data held nor a chunk's end may pass the limit
+	limited := &Upload{}
+	require.NoError(t, limited.addChunk(4, []byte("4567"), 8))
+	assert.ErrorIs(t, limited.addChunk(0, []byte("01234"), 8), errRangeInvalid)
+	assert.ErrorIs(t, limited.addChunk(8, []byte("8"), 8), errUploadTooLarge)
+	require.NoError(t, limited.addChunk(0, []byte("0123"), 8))
+	assert.Equal(t, "01234567", string(limited.Data))
+}
+
+func TestParallelChunks(t *testing.T) {
+	store := storage.NewFileStorage(t.TempDir())
+	repo := &models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}
+	start := func() (*Manager, *Registry) {
+		manager := NewManager(store, nil, logrus.New())
+		require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+		registry, _ := manager.GetRegistry("apps")
+		return manager, registry
+	}
+	patch := func(registry *Registry, location string, offset int, chunk string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", location, strings.NewReader(chunk))
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+len(chunk)-1))
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	manager, apps := start()
+	w := serveRegistry(apps, "POST", "/v2/web/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	location := w.Header().Get("Location")
+
+	blob := strings.Repeat("layer data ", 100)
+	chunks := []int{0, 300, 600, 900}
+	var wg sync.WaitGroup
+	for _, offset := range chunks[1:] {
+		offset := offset
+		end := offset + 300
+		if end > len(blob) {
+			end = len(blob)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, http.StatusAccepted, patch(apps, location, offset, blob[offset:end]).Code)
+		}()
+	}
+	wg.Wait()
+
+	// Nothing has arrived from the start yet
+	w = serveRegistry(apps, "GET", location, "", "")
+	assert.Equal(t, "bytes=0--1", w.Header().Get("Range"))
+	w = patch(apps, location, 250, blob[250:350])
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+	w = serveRegistry(apps, "PUT", location+"?digest="+digestOf([]byte(blob)), "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "missing data at offset 0")
+
+	// Chunks sent ahead survive a restart
+	manager.StopAll()
+	manager, apps = start()
+	defer manager.StopAll()
+
+	w = patch(apps, location, 0, blob[:300])
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, fmt.Sprintf("bytes=0-%d", len(blob)-1), w.Header().Get("Range"))
+
+	w = serveRegistry(apps, "PUT", location+"?digest="+digestOf([]byte(blob)), "", "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = serveRegistry(apps, "GET", "/v2/web/blobs/"+digestOf([]byte(blob)), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, blob, w.Body.String())
+}
@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParsePlatform parses a platform written as os/arch or os/arch/variant,
+// e.g. "linux/arm64" or "linux/arm/v7"
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+		}
+	}
+	platform := &Platform{OS: strings.ToLower(parts[0]), Architecture: strings.ToLower(parts[1])}
+	if len(parts) == 3 {
+		platform.Variant = strings.ToLower(parts[2])
+	}
+	return platform, nil
+}
+
+// String formats the platform the way ParsePlatform reads it
+func (p *Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Matches reports whether p satisfies the requested platform. The variant
+// is only compared if one was requested; arm64 without a variant is v8.
+func (p *Platform) Matches(want *Platform) bool {
+	if !strings.EqualFold(p.OS, want.OS) || !strings.EqualFold(p.Architecture, want.Architecture) {
+		return false
+	}
+	if want.Variant == "" {
+		return true
+	}
+	return strings.EqualFold(normalizeVariant(p.Architecture, p.Variant), normalizeVariant(want.Architecture, want.Variant))
+}
+
+func normalizeVariant(arch, variant string) string {
+	if variant == "" && strings.EqualFold(arch, "arm64") {
+		return "v8"
+	}
+	return variant
+}
+
+// isList reports whether the manifest is a manifest list or image index
+func (m *Manifest) isList() bool {
+	return m.MediaType == MediaTypeDockerSchema2ManifestList || m.MediaType == MediaTypeOCIManifestList || len(m.Manifests) > 0
+}
+
+// selectPlatform returns the first entry of a manifest list matching the
+// requested platform
+func (m *Manifest) selectPlatform(want *Platform) (*ManifestDescriptor, bool) {
+	for i := range m.Manifests {
+		child := &m.Manifests[i]
+		if child.Platform != nil && child.Platform.Matches(want) {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+// imageSize returns the size of the config and layers of an image manifest,
+// which is what a client downloads to pull it
+func (m *Manifest) imageSize() int64 {
+	var size int64
+	for _, desc := range m.blobs() {
+		size += desc.Size
+	}
+	return size
+}
@@ -0,0 +1,112 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestParsePlatform(t *testing.T) {
+	platform, err := ParsePlatform("linux/arm/v7")
+	require.NoError(t, err)
+	assert.Equal(t, &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, platform)
+	assert.Equal(t, "linux/arm/v7", platform.String())
+
+	for _, invalid := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/x"} {
+		_, err := ParsePlatform(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	arm64 := &Platform{OS: "linux", Architecture: "arm64"}
+	assert.True(t, arm64.Matches(&Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}))
+	assert.False(t, arm64.Matches(&Platform{OS: "linux", Architecture: "amd64"}))
+	assert.False(t, (&Platform{OS: "linux", Architecture: "arm", Variant: "v6"}).Matches(&Platform{OS: "linux", Architecture: "arm", Variant: "v7"}))
+}
+
+func TestPlatformSelection(t *testing.T) {
+	registry := NewRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+
+	put := func(reference, mediaType, body string) string {
+		req := httptest.NewRequest("PUT", "/v2/app/manifests/"+reference, strings.NewReader(body))
+		req.Header.Set("Content-Type", mediaType)
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+
+	config := digestOf([]byte("config"))
+	image := func(layer string, size int) string {
+		return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":10,"digest":%q},"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+			MediaTypeDockerSchema2Manifest, MediaTypeDockerSchema2Config, config, MediaTypeDockerSchema2Layer, size, digestOf([]byte(layer)))
+	}
+	amd64 := put(digestOf([]byte(image("amd64", 100))), MediaTypeDockerSchema2Manifest, image("amd64", 100))
+	arm64 := put(digestOf([]byte(image("arm64", 90))), MediaTypeDockerSchema2Manifest, image("arm64", 90))
+	list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[`+
+		`{"mediaType":%q,"size":1,"digest":%q,"platform":{"architecture":"amd64","os":"linux"}},`+
+		`{"mediaType":%q,"size":1,"digest":%q,"platform":{"architecture":"arm64","os":"linux","variant":"v8"}}]}`,
+		MediaTypeDockerSchema2ManifestList, MediaTypeDockerSchema2Manifest, amd64, MediaTypeDockerSchema2Manifest, arm64)
+	listDigest := put("1.0", MediaTypeDockerSchema2ManifestList, list)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	t.Run("Manifest list", func(t *testing.T) {
+		w := get("/v2/app/manifests/1.0")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, listDigest, w.Header().Get("Docker-Content-Digest"))
+	})
+
+	t.Run("Platform", func(t *testing.T) {
+		w := get("/v2/app/manifests/1.0?platform=linux/arm64")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, arm64, w.Header().Get("Docker-Content-Digest"))
+		assert.Equal(t, MediaTypeDockerSchema2Manifest, w.Header().Get("Content-Type"))
+		assert.Equal(t, image("arm64", 90), w.Body.String())
+	})
+
+	t.Run("Unknown platform", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/v2/app/manifests/1.0?platform=windows/amd64").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/v2/app/manifests/1.0?platform=linux").Code)
+	})
+
+	t.Run("Image manifest", func(t *testing.T) {
+		w := get("/v2/app/manifests/" + amd64 + "?platform=linux/arm64")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, amd64, w.Header().Get("Docker-Content-Digest"))
+	})
+
+	t.Run("Inspect", func(t *testing.T) {
+		info, err := registry.inspectImage("app", "1.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, listDigest, info.Digest)
+		require.Len(t, info.Platforms, 2)
+		assert.Equal(t, int64(110), info.Platforms[0].Size)
+		assert.Equal(t, int64(100), info.Platforms[1].Size)
+		// The shared config is counted once
+		assert.Equal(t, int64(200), info.Size)
+
+		info, err = registry.inspectImage("app", "1.0", &Platform{OS: "linux", Architecture: "arm64"})
+		require.NoError(t, err)
+		require.Len(t, info.Platforms, 1)
+		assert.Equal(t, arm64, info.Platforms[0].Digest)
+
+		_, err = registry.inspectImage("app", "1.0", &Platform{OS: "linux", Architecture: "s390x"})
+		assert.True(t, errors.Is(err, ErrPlatformNotFound))
+		_, err = registry.inspectImage("app", "2.0", nil)
+		assert.True(t, errors.Is(err, ErrManifestNotFound))
+	})
+}
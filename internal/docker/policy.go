@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/policy"
+	"github.com/depot/depot/internal/requestid"
+)
+
+// SetPolicy sets the policy engine that authorizes requests to registries
+// started afterwards
+func (m *Manager) SetPolicy(engine *policy.Engine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.policy = engine
+}
+
+// SetPolicy has engine authorize every request to the registry, on its own
+// ports and the main port alike
+func (r *Registry) SetPolicy(engine *policy.Engine) {
+	if engine == nil {
+		return
+	}
+	r.router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			decision, err := engine.Authorize(req, r.repo.Name, registryPolicyPath(req), registryAction(req))
+			if err != nil {
+				r.logger.WithError(err).WithFields(logrus.Fields{
+					"repository": r.repo.Name,
+					"request_id": requestid.FromContext(req.Context()),
+				}).Error("Policy evaluation failed")
+				w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+				r.writeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", "authorization policy unavailable", nil)
+				return
+			}
+			if !decision.Allow {
+				message := "requested access to the resource is denied"
+				if decision.Reason != "" {
+					message = decision.Reason
+				}
+				w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+				r.writeError(w, http.StatusForbidden, "DENIED", message, nil)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	})
+}
+
+// registryPolicyPath is the path a registry request is for: the image name
+// with what follows it, e.g. "team/app/manifests/1.0", or "" for the base
+// endpoint and the catalog
+func registryPolicyPath(req *http.Request) string {
+	if mux.Vars(req)["name"] == "" {
+		return ""
+	}
+	return strings.TrimPrefix(req.URL.Path, "/v2/")
+}
+
+// registryAction classifies a registry request; checking which blobs
+// exist is a read although it is a POST
+func registryAction(req *http.Request) string {
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/exists") {
+		return policy.ActionRead
+	}
+	return policy.ActionOf(req.Method)
+}
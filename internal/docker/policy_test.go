@@ -0,0 +1,49 @@
+package docker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/policy"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestRegistryPolicy(t *testing.T) {
+	var inputs []policy.Input
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input policy.Input `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		inputs = append(inputs, body.Input)
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": body.Input.Action == policy.ActionRead})
+	}))
+	defer opa.Close()
+	engine, err := policy.NewEngine(opa.URL, "")
+	require.NoError(t, err)
+
+	registry := NewRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	registry.SetPolicy(engine)
+
+	w := httptest.NewRecorder()
+	registry.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v2/team/app/tags/list", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	registry.GetRouter().ServeHTTP(w, httptest.NewRequest("POST", "/v2/team/app/blobs/uploads/", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "DENIED")
+
+	require.Len(t, inputs, 2)
+	assert.Equal(t, "docker", inputs[0].Repository)
+	assert.Equal(t, "team/app/tags/list", inputs[0].Path)
+	assert.Equal(t, policy.ActionRead, inputs[0].Action)
+	assert.Equal(t, policy.ActionWrite, inputs[1].Action)
+}
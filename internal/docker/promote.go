@@ -0,0 +1,284 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// PromoteRequest describes an image copy between two registries. Digest,
+// if set, pins the image: the promotion fails if Reference resolves to
+// another manifest, and Reference defaults to it.
+type PromoteRequest struct {
+	Source      string `json:"source"`
+	Target      string `json:"target"`
+	Image       string `json:"image"`
+	Reference   string `json:"reference"`
+	Digest      string `json:"digest,omitempty"`
+	TargetImage string `json:"target_image,omitempty"`
+	TargetTag   string `json:"target_tag,omitempty"`
+	PromotedBy  string `json:"promoted_by,omitempty"`
+}
+
+// PromoteResult describes a completed promotion. Signed is only checked
+// when the target has a signature policy.
+type PromoteResult struct {
+	Digest  string `json:"digest"`
+	Signed  bool   `json:"signed"`
+	Scanned bool   `json:"scanned"`
+}
+
+// DefaultScanArtifactType is the artifact type of the scan reports a
+// promotion policy requires unless it names others
+const DefaultScanArtifactType = "application/sarif+json"
+
+var (
+	// ErrPromotionDenied is returned when the target's promotion policy
+	// rejects an image
+	ErrPromotionDenied = errors.New("promotion denied")
+	// ErrDigestMismatch is returned when a pinned image has moved
+	ErrDigestMismatch = errors.New("digest mismatch")
+)
+
+// ValidatePromotionPolicy checks the promotion policy of a Docker
+// repository configuration
+func ValidatePromotionPolicy(config *models.DockerRepositoryConfig) error {
+	policy := config.PromotionPolicy
+	if policy.RequireSignature && config.SignaturePolicy == nil {
+		return errors.New("require_signature needs a signature_policy to verify signatures with")
+	}
+	if config.Proxy != nil {
+		return errors.New("proxy repositories cannot be promoted to")
+	}
+	for _, artifactType := range policy.ScanArtifactTypes {
+		if artifactType == "" {
+			return errors.New("scan artifact types cannot be empty")
+		}
+	}
+	return nil
+}
+
+// PromoteImage copies a manifest, any child manifests, every referenced
+// blob and the artifacts referring to them, such as signatures and scan
+// reports, from the source registry to the target registry. The target's
+// promotion policy decides which images may be promoted. All content is
+// written to the target before the manifest is published there, so a failed
+// promotion never leaves the target referencing missing blobs.
+func (m *Manager) PromoteImage(promote *PromoteRequest) (*PromoteResult, error) {
+	source, exists := m.GetRegistry(promote.Source)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", promote.Source)
+	}
+	target, exists := m.GetRegistry(promote.Target)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", promote.Target)
+	}
+	if target.proxy != nil {
+		return nil, fmt.Errorf("repository %s is a read-only proxy", promote.Target)
+	}
+
+	reference := promote.Reference
+	if reference == "" {
+		reference = promote.Digest
+	}
+	targetImage := promote.TargetImage
+	if targetImage == "" {
+		targetImage = promote.Image
+	}
+	targetTag := promote.TargetTag
+	if targetTag == "" {
+		targetTag = reference
+	}
+
+	manifest, exists := source.getManifest(promote.Image, reference)
+	if !exists {
+		return nil, fmt.Errorf("manifest %s:%s not found in repository %s", promote.Image, reference, promote.Source)
+	}
+	digest := digestOf(manifest.Raw)
+	if promote.Digest != "" && promote.Digest != digest {
+		return nil, fmt.Errorf("%w: %s:%s is %s, not %s", ErrDigestMismatch, promote.Image, reference, digest, promote.Digest)
+	}
+
+	result, err := source.checkPromotion(promote, target, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &promotion{
+		source:      source,
+		target:      target,
+		image:       promote.Image,
+		targetImage: targetImage,
+	}
+
+	// Collect child manifests for manifest lists
+	children := make(map[string]*Manifest)
+	for _, child := range manifest.Manifests {
+		childManifest, exists := source.getManifest(promote.Image, child.Digest)
+		if !exists {
+			return nil, fmt.Errorf("child manifest %s not found", child.Digest)
+		}
+		children[child.Digest] = childManifest
+	}
+
+	// Signatures, scan reports and SBOMs follow the image
+	subjects := []string{digest}
+	for childDigest := range children {
+		subjects = append(subjects, childDigest)
+	}
+	referrers := make(map[string]*Manifest)
+	source.mu.RLock()
+	for _, subject := range subjects {
+		for _, desc := range source.referrers(promote.Image, subject, "") {
+			referrers[desc.Digest] = source.manifests[promote.Image][desc.Digest]
+		}
+	}
+	source.mu.RUnlock()
+
+	for _, related := range []map[string]*Manifest{children, referrers} {
+		for _, extra := range related {
+			if err := p.copyBlobs(extra); err != nil {
+				p.rollback()
+				return nil, err
+			}
+		}
+	}
+	if err := p.copyBlobs(manifest); err != nil {
+		p.rollback()
+		return nil, err
+	}
+
+	for _, related := range []map[string]*Manifest{children, referrers} {
+		for extraDigest, extra := range related {
+			if err := p.storeManifest(extra); err != nil {
+				p.rollback()
+				return nil, err
+			}
+			target.putManifest(targetImage, extraDigest, extra)
+		}
+	}
+	if err := p.storeManifest(manifest); err != nil {
+		p.rollback()
+		return nil, err
+	}
+
+	target.putManifest(targetImage, targetTag, manifest)
+	if err := target.storeTag(targetImage, targetTag, digest); err != nil {
+		return nil, fmt.Errorf("failed to store tag %s: %w", targetTag, err)
+	}
+	return result, nil
+}
+
+// checkPromotion reports whether an image in the source registry is signed
+// and scanned, and rejects it if the target's promotion policy requires
+// what it lacks
+func (r *Registry) checkPromotion(promote *PromoteRequest, target *Registry, digest string) (*PromoteResult, error) {
+	policy := target.currentConfig().PromotionPolicy
+	if policy == nil {
+		policy = &models.PromotionPolicy{}
+	}
+	if len(policy.Sources) > 0 && !slices.Contains(policy.Sources, promote.Source) {
+		return nil, fmt.Errorf("%w: repository %s only accepts promotions from %s", ErrPromotionDenied, promote.Target, strings.Join(policy.Sources, ", "))
+	}
+	scanTypes := policy.ScanArtifactTypes
+	if len(scanTypes) == 0 {
+		scanTypes = []string{DefaultScanArtifactType}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := &PromoteResult{Digest: digest}
+	signatureErr := errors.New("no signature policy")
+	if target.verifier != nil {
+		signatureErr = r.verifySignature(target.verifier, promote.Image, digest)
+		result.Signed = signatureErr == nil
+	}
+	for _, artifactType := range scanTypes {
+		if len(r.referrers(promote.Image, digest, artifactType)) > 0 {
+			result.Scanned = true
+		}
+	}
+
+	if policy.RequireSignature && !result.Signed {
+		return nil, fmt.Errorf("%w: %s@%s has no trusted signature: %v", ErrPromotionDenied, promote.Image, digest, signatureErr)
+	}
+	if policy.RequireScan && !result.Scanned {
+		return nil, fmt.Errorf("%w: %s@%s has no scan report", ErrPromotionDenied, promote.Image, digest)
+	}
+	return result, nil
+}
+
+// promotion tracks content written to the target so it can be rolled back
+type promotion struct {
+	source      *Registry
+	target      *Registry
+	image       string
+	targetImage string
+	written     []string
+}
+
+// copyBlobs copies the config and layer blobs of a manifest
+func (p *promotion) copyBlobs(manifest *Manifest) error {
+	for _, desc := range manifest.blobs() {
+		if err := p.copyFile(path.Join("blobs", desc.Digest)); err != nil {
+			return fmt.Errorf("failed to copy blob %s: %w", desc.Digest, err)
+		}
+	}
+	return nil
+}
+
+// storeManifest writes a manifest to the target image's storage
+func (p *promotion) storeManifest(manifest *Manifest) error {
+	digest := digestOf(manifest.Raw)
+	manifestPath := path.Join("manifests", digest)
+	exists, err := p.target.storage.Exists(p.targetImage, manifestPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if err := p.target.storage.Store(p.targetImage, manifestPath, bytes.NewReader(manifest.Raw)); err != nil {
+		return fmt.Errorf("failed to store manifest %s: %w", digest, err)
+	}
+	p.written = append(p.written, manifestPath)
+	return nil
+}
+
+// copyFile copies a single file from the source image to the target image,
+// skipping content the target already holds
+func (p *promotion) copyFile(filePath string) error {
+	exists, err := p.target.storage.Exists(p.targetImage, filePath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	reader, err := p.source.storage.Retrieve(p.image, filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := p.target.storage.Store(p.targetImage, filePath, reader); err != nil {
+		return err
+	}
+	p.written = append(p.written, filePath)
+	return nil
+}
+
+// rollback removes everything written during a failed promotion
+func (p *promotion) rollback() {
+	for _, filePath := range p.written {
+		_ = p.target.storage.Delete(p.targetImage, filePath)
+	}
+}
@@ -0,0 +1,121 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestPromotionPolicy(t *testing.T) {
+	signer := newTestSigner(t)
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	start := func(name string, config *models.DockerRepositoryConfig) *Registry {
+		require.NoError(t, manager.StartRegistry(&models.Repository{Name: name, Type: models.RepositoryTypeDocker}, config))
+		registry, _ := manager.GetRegistry(name)
+		return registry
+	}
+	staging := start("staging", &models.DockerRepositoryConfig{})
+	production := start("production", &models.DockerRepositoryConfig{
+		SignaturePolicy: &models.SignaturePolicy{TrustedCertificates: signer.caPEM},
+		PromotionPolicy: &models.PromotionPolicy{RequireSignature: true, RequireScan: true, Sources: []string{"staging"}},
+	})
+	other := start("other", &models.DockerRepositoryConfig{})
+	defer manager.StopAll()
+
+	serve := func(registry *Registry, method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(registry *Registry, content string) string {
+		w := serve(registry, "POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		w = serve(registry, "PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(content)), "", content)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(content))
+	}
+	pushReferrer := func(registry *Registry, subject, artifactType, mediaType, content string) string {
+		pushBlob(registry, "{}")
+		layer := pushBlob(registry, content)
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"artifactType":%q,"config":{"mediaType":%q,"size":2,"digest":%q},`+
+			`"layers":[{"mediaType":%q,"size":%d,"digest":%q}],"subject":{"mediaType":%q,"size":1,"digest":%q}}`,
+			MediaTypeOCIManifest, artifactType, MediaTypeOCIEmpty, digestOf([]byte("{}")), mediaType, len(content), layer, MediaTypeOCIManifest, subject)
+		w := serve(registry, "PUT", "/v2/app/manifests/"+digestOf([]byte(manifest)), MediaTypeOCIManifest, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(manifest))
+	}
+
+	imageConfig := `{"architecture":"amd64","os":"linux"}`
+	image := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[]}`,
+		MediaTypeOCIManifest, MediaTypeOCIConfig, len(imageConfig), pushBlob(staging, imageConfig))
+	w := serve(staging, "PUT", "/v2/app/manifests/rc1", MediaTypeOCIManifest, image)
+	require.Equal(t, http.StatusCreated, w.Code)
+	digest := w.Header().Get("Docker-Content-Digest")
+
+	promote := &PromoteRequest{Source: "staging", Target: "production", Image: "app", Reference: "rc1", Digest: digest, TargetTag: "1.0"}
+
+	_, err := manager.PromoteImage(promote)
+	assert.ErrorIs(t, err, ErrPromotionDenied)
+	assert.Contains(t, err.Error(), "no trusted signature")
+
+	signature := pushReferrer(staging, digest, ArtifactTypeNotationSignature, MediaTypeJWSEnvelope, string(signer.sign(t, digest)))
+	_, err = manager.PromoteImage(promote)
+	assert.ErrorIs(t, err, ErrPromotionDenied)
+	assert.Contains(t, err.Error(), "no scan report")
+
+	report := pushReferrer(staging, digest, DefaultScanArtifactType, DefaultScanArtifactType, `{"runs":[]}`)
+	_, err = manager.PromoteImage(&PromoteRequest{Source: "staging", Target: "production", Image: "app", Digest: digestOf([]byte("moved"))})
+	assert.Error(t, err)
+	_, err = manager.PromoteImage(&PromoteRequest{Source: "staging", Target: "production", Image: "app", Reference: "rc1", Digest: digestOf([]byte("moved"))})
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+
+	result, err := manager.PromoteImage(promote)
+	require.NoError(t, err)
+	assert.Equal(t, &PromoteResult{Digest: digest, Signed: true, Scanned: true}, result)
+
+	// Retagged, and pullable under the target's signature policy because
+	// the signature came along
+	w = serve(production, "GET", "/v2/app/manifests/1.0", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, http.StatusNotFound, serve(production, "GET", "/v2/app/manifests/rc1", "", "").Code)
+	w = serve(production, "GET", "/v2/app/referrers/"+digest, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), signature)
+	assert.Contains(t, w.Body.String(), report)
+
+	// Only promotions from the listed sources are accepted
+	w = serve(other, "PUT", "/v2/app/manifests/rc1", MediaTypeOCIManifest, image)
+	require.Equal(t, http.StatusCreated, w.Code)
+	_, err = manager.PromoteImage(&PromoteRequest{Source: "other", Target: "production", Image: "app", Reference: "rc1"})
+	assert.ErrorIs(t, err, ErrPromotionDenied)
+
+	// Repositories without a policy take any image
+	result, err = manager.PromoteImage(&PromoteRequest{Source: "production", Target: "other", Image: "app", Reference: "1.0"})
+	require.NoError(t, err)
+	assert.False(t, result.Signed)
+	assert.True(t, result.Scanned)
+}
+
+func TestValidatePromotionPolicy(t *testing.T) {
+	assert.NoError(t, ValidatePromotionPolicy(&models.DockerRepositoryConfig{PromotionPolicy: &models.PromotionPolicy{RequireScan: true}}))
+	assert.Error(t, ValidatePromotionPolicy(&models.DockerRepositoryConfig{PromotionPolicy: &models.PromotionPolicy{RequireSignature: true}}))
+	assert.Error(t, ValidatePromotionPolicy(&models.DockerRepositoryConfig{PromotionPolicy: &models.PromotionPolicy{ScanArtifactTypes: []string{""}}}))
+	assert.Error(t, ValidatePromotionPolicy(&models.DockerRepositoryConfig{
+		PromotionPolicy: &models.PromotionPolicy{},
+		Proxy:           &models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}},
+	}))
+}
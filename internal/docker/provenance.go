@@ -0,0 +1,199 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/depot/depot/internal/provenance"
+)
+
+// ArtifactTypeProvenance is the artifact type of the build provenance
+// attached to images through the API
+const ArtifactTypeProvenance = "application/vnd.depot.provenance.v1+json"
+
+// Annotations buildx sets on the attestation manifests of a manifest list
+const (
+	annotationReferenceType   = "vnd.docker.reference.type"
+	annotationReferenceDigest = "vnd.docker.reference.digest"
+	referenceTypeAttestation  = "attestation-manifest"
+)
+
+// ErrInvalidProvenance is returned when attached provenance has an
+// attestation that cannot be read or is about another artifact
+var ErrInvalidProvenance = errors.New("invalid provenance")
+
+// isAttestation reports whether a manifest holds only in-toto
+// attestations, as the attestation manifests buildx adds to a manifest
+// list do
+func (m *Manifest) isAttestation() bool {
+	for _, layer := range m.Layers {
+		if layer.MediaType != provenance.MediaTypeStatement && layer.MediaType != provenance.MediaTypeDSSE {
+			return false
+		}
+	}
+	return len(m.Layers) > 0
+}
+
+// AttachProvenance stores build provenance for image:reference as a
+// referrer of its manifest and returns it completed from its attestation
+func (m *Manager) AttachProvenance(repoName, image, reference string, p *provenance.Provenance) (*provenance.Provenance, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy != nil {
+		return nil, fmt.Errorf("repository %s is a read-only proxy", repoName)
+	}
+
+	manifest, exists := registry.getManifest(image, reference)
+	if !exists {
+		return nil, ErrManifestNotFound
+	}
+	digest := digestOf(manifest.Raw)
+	if err := p.Complete(digest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProvenance, err)
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now().UTC()
+	}
+
+	content, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal provenance: %w", err)
+	}
+	empty := []byte("{}")
+	for _, blob := range [][]byte{empty, content} {
+		if err := registry.storage.Store(image, path.Join(blobsDir, digestOf(blob)), bytes.NewReader(blob)); err != nil {
+			return nil, fmt.Errorf("failed to store provenance: %w", err)
+		}
+	}
+
+	referrer := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  ArtifactTypeProvenance,
+		Config:        &Descriptor{MediaType: MediaTypeOCIEmpty, Size: int64(len(empty)), Digest: digestOf(empty)},
+		Layers:        []Descriptor{{MediaType: ArtifactTypeProvenance, Size: int64(len(content)), Digest: digestOf(content)}},
+		Subject:       &Descriptor{MediaType: manifest.MediaType, Size: int64(len(manifest.Raw)), Digest: digest},
+		Annotations:   map[string]string{"org.opencontainers.image.created": p.CreatedAt.Format(time.RFC3339)},
+	}
+	if referrer.Raw, err = json.Marshal(referrer); err != nil {
+		return nil, fmt.Errorf("failed to marshal provenance manifest: %w", err)
+	}
+	referrerDigest := digestOf(referrer.Raw)
+	if err := registry.storage.Store(image, path.Join(manifestsDir, referrerDigest), bytes.NewReader(referrer.Raw)); err != nil {
+		return nil, fmt.Errorf("failed to store provenance manifest: %w", err)
+	}
+	registry.putManifest(image, referrerDigest, referrer)
+	return p, nil
+}
+
+// ImageProvenance returns the build provenance of image:reference: that
+// attached through the API, in-toto attestations pushed as referrers, and
+// attestations buildx stored in a manifest list with the image
+func (m *Manager) ImageProvenance(repoName, image, reference string) ([]*provenance.Provenance, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	manifest, exists := registry.manifests[image][reference]
+	if !exists {
+		return nil, ErrManifestNotFound
+	}
+	return registry.provenance(image, digestOf(manifest.Raw)), nil
+}
+
+// provenance collects the build provenance of a manifest digest. The
+// caller must hold r.mu.
+func (r *Registry) provenance(name, digest string) []*provenance.Provenance {
+	provenances := []*provenance.Provenance{}
+	for _, desc := range r.referrers(name, digest, "") {
+		referrer := r.manifests[name][desc.Digest]
+		for _, layer := range referrer.Layers {
+			if p := r.readProvenance(name, layer, digest); p != nil {
+				provenances = append(provenances, p)
+			}
+		}
+	}
+
+	for reference, list := range r.manifests[name] {
+		if !list.isList() || reference != digestOf(list.Raw) {
+			continue
+		}
+		for _, child := range list.Manifests {
+			if child.Annotations[annotationReferenceType] != referenceTypeAttestation || child.Annotations[annotationReferenceDigest] != digest {
+				continue
+			}
+			attestation, exists := r.manifests[name][child.Digest]
+			if !exists {
+				continue
+			}
+			for _, layer := range attestation.Layers {
+				if p := r.readProvenance(name, layer, digest); p != nil {
+					provenances = append(provenances, p)
+				}
+			}
+		}
+	}
+	return provenances
+}
+
+// readProvenance reads a layer holding provenance for digest, returning
+// nil if it holds something else
+func (r *Registry) readProvenance(name string, layer Descriptor, digest string) *provenance.Provenance {
+	switch layer.MediaType {
+	case ArtifactTypeProvenance, provenance.MediaTypeStatement, provenance.MediaTypeDSSE:
+	default:
+		return nil
+	}
+	data, err := r.readBlob(name, layer.Digest)
+	if err != nil {
+		return nil
+	}
+
+	if layer.MediaType == ArtifactTypeProvenance {
+		var p provenance.Provenance
+		if json.Unmarshal(data, &p) != nil {
+			return nil
+		}
+		return &p
+	}
+	statement, err := provenance.ParseStatement(data)
+	if err != nil || !statement.Covers(digest) {
+		return nil
+	}
+	p := provenance.FromStatement(statement)
+	p.Attestation = data
+	p.Digest = digest
+	return p
+}
+
+// checkProvenance reports whether the manifest digest of an image, or a
+// manifest list containing it, has provenance satisfying the registry's
+// provenance policy. The caller must hold r.mu.
+func (r *Registry) checkProvenance(name, digest string) error {
+	policy := r.config.ProvenancePolicy
+	err := provenance.CheckAny(r.provenance(name, digest), policy, digest)
+	if err == nil {
+		return nil
+	}
+	for reference, list := range r.manifests[name] {
+		if !list.isList() || reference != digestOf(list.Raw) {
+			continue
+		}
+		for _, child := range list.Manifests {
+			if child.Digest == digest && provenance.CheckAny(r.provenance(name, reference), policy, reference) == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
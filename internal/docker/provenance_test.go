@@ -0,0 +1,98 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/provenance"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestProvenancePolicy(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "prod", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{
+		ProvenancePolicy: &models.ProvenancePolicy{TrustedBuilders: []string{"https://github.com/actions/runner"}},
+	}))
+	defer manager.StopAll()
+	registry, _ := manager.GetRegistry("prod")
+
+	serve := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(content string) string {
+		w := serve("POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		w = serve("PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(content)), "", content)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(content))
+	}
+	pushManifest := func(reference, manifest string) string {
+		w := serve("PUT", "/v2/app/manifests/"+reference, MediaTypeOCIManifest, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+	image := func(config string) string {
+		return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[]}`,
+			MediaTypeOCIManifest, MediaTypeOCIConfig, len(config), pushBlob(config))
+	}
+
+	digest := pushManifest("1.0", image(`{"architecture":"amd64","os":"linux"}`))
+	assert.Equal(t, http.StatusForbidden, serve("GET", "/v2/app/manifests/1.0", "", "").Code)
+
+	_, err := manager.AttachProvenance("prod", "app", "1.0", &provenance.Provenance{Builder: "laptop"})
+	require.NoError(t, err)
+	w := serve("GET", "/v2/app/manifests/1.0", "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "not trusted")
+
+	_, err = manager.AttachProvenance("prod", "app", "1.0", &provenance.Provenance{Attestation: []byte(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"digest":{"sha256":"0000"}}]}`)})
+	assert.ErrorIs(t, err, ErrInvalidProvenance)
+
+	p, err := manager.AttachProvenance("prod", "app", "1.0", &provenance.Provenance{Builder: "https://github.com/actions/runner", Commit: "abc123"})
+	require.NoError(t, err)
+	assert.Equal(t, digest, p.Digest)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v2/app/manifests/1.0", "", "").Code)
+
+	provenances, err := manager.ImageProvenance("prod", "app", "1.0")
+	require.NoError(t, err)
+	assert.Len(t, provenances, 2)
+	w = serve("GET", "/v2/app/referrers/"+digest+"?artifactType="+url.QueryEscape(ArtifactTypeProvenance), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), `"artifactType":"`+ArtifactTypeProvenance+`"`))
+
+	// Provenance buildx stores in a manifest list with the image
+	arm := image(`{"architecture":"arm64","os":"linux"}`)
+	armDigest := pushManifest(digestOf([]byte(arm)), arm)
+	assert.Equal(t, http.StatusForbidden, serve("GET", "/v2/app/manifests/"+armDigest, "", "").Code)
+
+	statement := fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v0.1","subject":[{"digest":{"sha256":%q}}],"predicateType":%q,"predicate":{"builder":{"id":"https://github.com/actions/runner"}}}`,
+		strings.TrimPrefix(armDigest, "sha256:"), provenance.PredicateSLSAv02)
+	attestation := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":2,"digest":%q},"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		MediaTypeOCIManifest, MediaTypeOCIConfig, pushBlob("{}"), provenance.MediaTypeStatement, len(statement), pushBlob(statement))
+	attestationDigest := pushManifest(digestOf([]byte(attestation)), attestation)
+	list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[`+
+		`{"mediaType":%q,"size":1,"digest":%q,"platform":{"architecture":"arm64","os":"linux"}},`+
+		`{"mediaType":%q,"size":1,"digest":%q,"platform":{"architecture":"unknown","os":"unknown"},"annotations":{"vnd.docker.reference.type":"attestation-manifest","vnd.docker.reference.digest":%q}}]}`,
+		MediaTypeOCIManifestList, MediaTypeOCIManifest, armDigest, MediaTypeOCIManifest, attestationDigest, armDigest)
+	w = serve("PUT", "/v2/app/manifests/2.0", MediaTypeOCIManifestList, list)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	assert.Equal(t, http.StatusOK, serve("GET", "/v2/app/manifests/2.0", "", "").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v2/app/manifests/"+armDigest, "", "").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v2/app/manifests/"+attestationDigest, "", "").Code)
+}
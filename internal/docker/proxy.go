@@ -0,0 +1,609 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// upstreamAccept lists the manifest types requested from upstreams
+var upstreamAccept = strings.Join([]string{
+	MediaTypeOCIManifestList,
+	MediaTypeDockerSchema2ManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerSchema2Manifest,
+}, ", ")
+
+const (
+	// maxUpstreamManifestSize bounds the manifests read from upstreams
+	maxUpstreamManifestSize = 4 << 20
+	// upstreamResponseTimeout bounds the wait for an upstream's response
+	// headers; bodies such as large layers may take longer
+	upstreamResponseTimeout = 30 * time.Second
+	// missingPruneSize is the number of negative cache entries beyond which
+	// expired ones are dropped
+	missingPruneSize = 1024
+)
+
+// errUpstreamNotFound is returned when an upstream does not have the
+// requested content
+var errUpstreamNotFound = errors.New("not found upstream")
+
+// staleWarning is the Warning header of cached content served because the
+// upstream could not be asked for the current one (RFC 7234, 5.5.1)
+const staleWarning = `110 - "Response is Stale"`
+
+// ValidateProxy checks the upstreams of a proxy repository
+func ValidateProxy(proxy *models.DockerProxy) error {
+	if len(proxy.Upstreams) == 0 {
+		return errors.New("proxy needs at least one upstream")
+	}
+	seen := make(map[string]bool)
+	for _, upstream := range proxy.Upstreams {
+		if upstream.Namespace == "" || strings.ContainsAny(upstream.Namespace, "/?#") {
+			return fmt.Errorf("invalid upstream namespace %q", upstream.Namespace)
+		}
+		parsed, err := url.Parse(upstream.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid upstream URL %q", upstream.URL)
+		}
+		key := upstream.Namespace + " " + strings.TrimSuffix(upstream.URL, "/")
+		if seen[key] {
+			return fmt.Errorf("duplicate upstream %s for namespace %s", upstream.URL, upstream.Namespace)
+		}
+		seen[key] = true
+		if upstream.Password != "" && upstream.Username == "" {
+			return fmt.Errorf("upstream %s has a password but no username", upstream.URL)
+		}
+		if strings.ContainsAny(upstream.Repository, "/?#") || strings.HasPrefix(upstream.Repository, ".") {
+			return fmt.Errorf("invalid upstream repository %q", upstream.Repository)
+		}
+		if err := validateUpstreamTLS(upstream); err != nil {
+			return err
+		}
+	}
+	if proxy.ManifestTTL != "" {
+		if _, err := models.ParseTTL(proxy.ManifestTTL); err != nil {
+			return fmt.Errorf("invalid manifest_ttl: %w", err)
+		}
+	}
+	if proxy.NotFoundTTL != "" {
+		if _, err := models.ParseTTL(proxy.NotFoundTTL); err != nil {
+			return fmt.Errorf("invalid not_found_ttl: %w", err)
+		}
+	}
+	if breaker := proxy.CircuitBreaker; breaker != nil {
+		if breaker.Threshold < 0 {
+			return errors.New("circuit_breaker threshold must not be negative")
+		}
+		if breaker.Cooldown != "" {
+			if _, err := models.ParseTTL(breaker.Cooldown); err != nil {
+				return fmt.Errorf("invalid circuit_breaker cooldown: %w", err)
+			}
+		}
+	}
+	for _, image := range proxy.Warm {
+		if _, _, err := ParseImageReference(image); err != nil {
+			return fmt.Errorf("invalid warm image: %w", err)
+		}
+	}
+	for _, pattern := range proxy.Pinned {
+		if pattern == "" {
+			return errors.New("pinned patterns must not be empty")
+		}
+	}
+	if proxy.MaxCacheSize < 0 {
+		return errors.New("max_cache_size must not be negative")
+	}
+	return nil
+}
+
+// proxy holds the upstreams of a pull-through cache, grouped by namespace
+// in the order they are tried
+type proxy struct {
+	namespaces  []string // in configuration order; the first is the default
+	upstreams   map[string][]*upstream
+	client      *http.Client // shared by upstreams without trust of their own
+	manifestTTL time.Duration
+	notFoundTTL time.Duration
+	mu          sync.Mutex
+	checked     map[string]time.Time // image:tag -> last agreement with the upstream
+	missing     map[string]time.Time // content not found upstream -> expiry
+	used        map[string]time.Time // cached image -> last pull
+	pulling     map[string]int       // cached image -> pulls in progress
+	evicting    map[string]bool      // cached image -> being evicted
+	evicted     *sync.Cond           // signalled on p.mu when an eviction finishes
+	synced      map[string]time.Time // federated upstream -> change feed position
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// newUpstreamClient creates the HTTP client upstreams are reached with.
+// A nil tlsConfig verifies upstreams against the system's roots.
+func newUpstreamClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: upstreamResponseTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	}}
+}
+
+func newProxy(config *models.DockerProxy) *proxy {
+	p := &proxy{
+		client:    newUpstreamClient(nil),
+		upstreams: make(map[string][]*upstream),
+		checked:   make(map[string]time.Time),
+		missing:   make(map[string]time.Time),
+		used:      make(map[string]time.Time),
+		pulling:   make(map[string]int),
+		evicting:  make(map[string]bool),
+		synced:    make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+	p.evicted = sync.NewCond(&p.mu)
+	if config.ManifestTTL != "" {
+		p.manifestTTL, _ = models.ParseTTL(config.ManifestTTL)
+	}
+	if config.NotFoundTTL != "" {
+		p.notFoundTTL, _ = models.ParseTTL(config.NotFoundTTL)
+	}
+	threshold, cooldown := defaultCircuitThreshold, defaultCircuitCooldown
+	if breaker := config.CircuitBreaker; breaker != nil {
+		if breaker.Threshold > 0 {
+			threshold = breaker.Threshold
+		}
+		if breaker.Cooldown != "" {
+			cooldown, _ = models.ParseTTL(breaker.Cooldown)
+		}
+	}
+	for _, u := range config.Upstreams {
+		if _, exists := p.upstreams[u.Namespace]; !exists {
+			p.namespaces = append(p.namespaces, u.Namespace)
+		}
+		upstream := newUpstream(u, upstreamClient(u, p.client))
+		upstream.threshold, upstream.cooldown = threshold, cooldown
+		p.upstreams[u.Namespace] = append(p.upstreams[u.Namespace], upstream)
+	}
+	return p
+}
+
+// proxyTarget names an image of a proxy repository locally and upstream
+type proxyTarget struct {
+	upstreams []*upstream
+	local     string // name the image is cached under
+	remote    string // name of the image on the upstreams
+}
+
+// resolve picks the upstreams for an image. containerd names the registry
+// it mirrors in the ns query parameter; without it, an image name starting
+// with a namespace, such as docker.io/library/nginx, selects that
+// namespace and anything else goes to the first one.
+func (p *proxy) resolve(req *http.Request, image string) (*proxyTarget, bool) {
+	return p.resolveIn(req.URL.Query().Get("ns"), image)
+}
+
+// resolveIn picks the upstreams for an image in namespace ns, or by the
+// image's name if ns is empty
+func (p *proxy) resolveIn(ns, image string) (*proxyTarget, bool) {
+	if ns != "" {
+		upstreams, exists := p.upstreams[ns]
+		if !exists {
+			return nil, false
+		}
+		return &proxyTarget{upstreams: upstreams, local: ns + "/" + image, remote: image}, true
+	}
+	if first, rest, found := strings.Cut(image, "/"); found {
+		if upstreams, exists := p.upstreams[first]; exists {
+			return &proxyTarget{upstreams: upstreams, local: image, remote: rest}, true
+		}
+	}
+	ns = p.namespaces[0]
+	return &proxyTarget{upstreams: p.upstreams[ns], local: ns + "/" + image, remote: image}, true
+}
+
+// get requests a path below /v2/<image>/ from the first upstream that
+// answers
+func (t *proxyTarget) get(subpath string, header http.Header) (*http.Response, error) {
+	return t.do(http.MethodGet, subpath, header)
+}
+
+// do sends a request for a path below /v2/<image>/ to the first upstream
+// that answers. Healthy upstreams are tried in order before unhealthy ones; an
+// upstream that cannot be reached or fails with a server error is marked
+// unhealthy and the next one is tried. Upstreams whose circuit is open are
+// skipped. Other responses, including 404, are final.
+func (t *proxyTarget) do(method, subpath string, header http.Header) (*http.Response, error) {
+	err := errCircuitOpen
+	for _, u := range byHealth(t.upstreams) {
+		if u.open() {
+			continue
+		}
+		var resp *http.Response
+		resp, err = u.do(method, t.remote, subpath, header)
+		if err == nil && !retryable(resp.StatusCode) {
+			u.succeeded()
+			return resp, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s responded %s", u.url, resp.Status)
+			resp.Body.Close()
+		}
+		u.failed(err)
+	}
+	return nil, err
+}
+
+// retryable reports whether a status means the upstream is unavailable
+// rather than answering the request
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// proxyTarget resolves the image of a request to a proxy repository,
+// answering NAME_UNKNOWN if no upstream serves it. It returns nil and true
+// for other repositories.
+func (r *Registry) proxyTarget(w http.ResponseWriter, req *http.Request, name string) (*proxyTarget, bool) {
+	if r.proxy == nil {
+		return nil, true
+	}
+	target, ok := r.proxy.resolve(req, name)
+	if !ok {
+		r.writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "registry "+req.URL.Query().Get("ns")+" is not mirrored", nil)
+		return nil, false
+	}
+	return target, true
+}
+
+// writable rejects pushes to proxy repositories
+func (r *Registry) writable(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if r.proxy != nil {
+			r.writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "proxy repositories are read-only", nil)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// writeUpstreamError reports a failed fetch of content that is not cached
+func (r *Registry) writeUpstreamError(w http.ResponseWriter, err error, notFoundCode, what string) {
+	if errors.Is(err, errUpstreamNotFound) {
+		r.writeError(w, http.StatusNotFound, notFoundCode, what+" not found", nil)
+		return
+	}
+	if isBusy(err) {
+		r.writeBusy(w)
+		return
+	}
+	r.writeError(w, http.StatusBadGateway, "UNKNOWN", "upstream registry failed: "+err.Error(), nil)
+}
+
+// proxyManifest fetches a manifest from the upstream into the cache.
+// Digests already cached are not fetched again; tags are looked up on
+// every pull so they follow the upstream, unless the proxy has a manifest
+// TTL. Then a cached tag is served without asking the upstream until the
+// TTL runs out, and is revalidated with a HEAD request, which Docker Hub
+// does not count against its pull rate limit, before it is fetched again.
+// Manifests the upstream did not have are not asked for again until the
+// not-found TTL runs out.
+func (r *Registry) proxyManifest(target *proxyTarget, reference string) error {
+	defer r.proxy.pull(target.local)()
+	isDigest := strings.HasPrefix(reference, "sha256:")
+	cached, exists := r.getManifest(target.local, reference)
+	if isDigest && exists {
+		return nil
+	}
+	key := imageReference(target.local, reference)
+	if !isDigest && exists && r.proxy.manifestTTL > 0 {
+		if r.proxy.fresh(key) {
+			return nil
+		}
+		if r.proxy.revalidate(target, reference, digestOf(cached.Raw)) {
+			r.proxy.markChecked(key)
+			return nil
+		}
+	}
+	subpath := "manifests/" + reference
+	if r.proxy.knownMissing(target, subpath) {
+		return errUpstreamNotFound
+	}
+
+	release, err := r.fetch()
+	if err != nil {
+		return err
+	}
+	defer release()
+	resp, err := target.get(subpath, http.Header{"Accept": {upstreamAccept}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := upstreamStatus(resp); err != nil {
+		r.proxy.markMissing(target, subpath, err)
+		return err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamManifestSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(body) > maxUpstreamManifestSize {
+		return errors.New("manifest is too large")
+	}
+	// The manifest must match the digest asked for, and the digest the
+	// upstream says it has
+	digest := digestOf(body)
+	expected := resp.Header.Get("Docker-Content-Digest")
+	if isDigest {
+		expected = reference
+	}
+	if expected != "" && digest != expected {
+		r.quarantine(&QuarantineItem{
+			Image:     target.local,
+			Reference: reference,
+			Kind:      "manifest",
+			Upstream:  upstreamOf(resp),
+			Expected:  expected,
+			Actual:    digest,
+			Size:      int64(len(body)),
+		}, body)
+		return fmt.Errorf("manifest does not match digest %s", expected)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	manifest.Raw = body
+	if contentType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";"); strings.TrimSpace(contentType) != "" {
+		manifest.MediaType = strings.TrimSpace(contentType)
+	}
+
+	if err := r.storage.Store(target.local, path.Join(manifestsDir, digest), bytes.NewReader(body)); err != nil {
+		return fmt.Errorf("failed to cache manifest: %w", err)
+	}
+	r.putManifest(target.local, reference, &manifest)
+	if err := r.storeTag(target.local, reference, digest); err != nil {
+		return err
+	}
+	if !isDigest && r.proxy.manifestTTL > 0 {
+		r.proxy.markChecked(key)
+	}
+	return nil
+}
+
+// fresh reports whether a tag was checked against the upstream within the
+// manifest TTL
+func (p *proxy) fresh(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	checked, exists := p.checked[key]
+	return exists && time.Since(checked) < p.manifestTTL
+}
+
+func (p *proxy) markChecked(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checked[key] = time.Now()
+}
+
+// knownMissing reports whether the upstreams answered 404 for a path of an
+// image within the not-found TTL. Hosted registries syncing from upstreams
+// have no proxy and no negative cache.
+func (p *proxy) knownMissing(target *proxyTarget, subpath string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expiry, exists := p.missing[target.local+"/"+subpath]
+	return exists && time.Now().Before(expiry)
+}
+
+// markMissing remembers for the not-found TTL that the upstreams do not
+// have a path of an image, if err says so
+func (p *proxy) markMissing(target *proxyTarget, subpath string, err error) {
+	if p == nil || p.notFoundTTL <= 0 || !errors.Is(err, errUpstreamNotFound) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if len(p.missing) >= missingPruneSize {
+		for key, expiry := range p.missing {
+			if now.After(expiry) {
+				delete(p.missing, key)
+			}
+		}
+	}
+	p.missing[target.local+"/"+subpath] = now.Add(p.notFoundTTL)
+}
+
+// revalidate reports whether the upstream still has a tag at digest,
+// asking with a HEAD request for its Docker-Content-Digest
+func (p *proxy) revalidate(target *proxyTarget, reference, digest string) bool {
+	resp, err := target.do(http.MethodHead, "manifests/"+reference, http.Header{"Accept": {upstreamAccept}})
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") == digest
+}
+
+// proxyPlatform fetches the image of a cached manifest list for the
+// requested platform, so it can be served in place of the list. Failures
+// are left for the lookup that follows to report.
+func (r *Registry) proxyPlatform(target *proxyTarget, reference, value string) {
+	manifest, exists := r.getManifest(target.local, reference)
+	if !exists || !manifest.isList() {
+		return
+	}
+	platform, err := ParsePlatform(value)
+	if err != nil {
+		return
+	}
+	if child, found := manifest.selectPlatform(platform); found {
+		r.proxyManifest(target, child.Digest)
+	}
+}
+
+// proxyBlob fetches a blob from the upstream into the cache unless it is
+// already there. The blob is only kept if it matches its digest.
+func (r *Registry) proxyBlob(target *proxyTarget, digest string) error {
+	defer r.proxy.pull(target.local)()
+	blobPath := path.Join(blobsDir, digest)
+	if exists, err := r.storage.Exists(target.local, blobPath); err == nil && exists {
+		return nil
+	}
+	if !validDigest(digest) {
+		return errUpstreamNotFound
+	}
+	subpath := "blobs/" + digest
+	if r.proxy.knownMissing(target, subpath) {
+		return errUpstreamNotFound
+	}
+
+	release, err := r.fetch()
+	if err != nil {
+		return err
+	}
+	defer release()
+	resp, err := target.get(subpath, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := upstreamStatus(resp); err != nil {
+		r.proxy.markMissing(target, subpath, err)
+		return err
+	}
+
+	item := &QuarantineItem{Image: target.local, Reference: digest, Kind: "blob", Upstream: upstreamOf(resp), Expected: digest}
+	if advertised := resp.Header.Get("Docker-Content-Digest"); advertised != "" && advertised != digest {
+		item.Actual = advertised
+		r.quarantine(item, nil)
+		return fmt.Errorf("upstream sent blob %s for %s", advertised, digest)
+	}
+
+	verified := &verifyingReader{reader: resp.Body, hash: sha256.New(), digest: digest}
+	if err := r.storage.Store(target.local, blobPath, verified); err != nil {
+		var mismatch *digestMismatchError
+		if errors.As(err, &mismatch) {
+			item.Actual, item.Size = mismatch.actual, mismatch.size
+			r.quarantine(item, nil)
+		}
+		return fmt.Errorf("failed to cache blob: %w", err)
+	}
+	return nil
+}
+
+// passThrough answers a pull of content that is not cached straight from
+// the upstream, without storing it. Clients check the content against its
+// digest, which the proxy cannot do before sending it.
+func (r *Registry) passThrough(w http.ResponseWriter, req *http.Request, target *proxyTarget, subpath string, header http.Header, notFoundCode, what string) {
+	release, err := r.fetch()
+	if err != nil {
+		r.writeUpstreamError(w, err, notFoundCode, what)
+		return
+	}
+	defer release()
+	resp, err := target.do(req.Method, subpath, header)
+	if err == nil {
+		defer resp.Body.Close()
+		err = upstreamStatus(resp)
+	}
+	if err != nil {
+		r.writeUpstreamError(w, err, notFoundCode, what)
+		return
+	}
+
+	for _, key := range []string{"Content-Type", "Content-Length", "Docker-Content-Digest"} {
+		if value := resp.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, resp.Body)
+}
+
+// upstreamOf names the upstream that sent a response, rather than a
+// storage service it redirected to
+func upstreamOf(resp *http.Response) string {
+	req := resp.Request
+	if req == nil {
+		return ""
+	}
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL.Scheme + "://" + req.URL.Host
+}
+
+// proxyTags fetches the tags of an image from the upstream
+func (r *Registry) proxyTags(target *proxyTarget) ([]string, error) {
+	resp, err := target.get("tags/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := upstreamStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid tag list: %w", err)
+	}
+	if list.Tags == nil {
+		list.Tags = []string{}
+	}
+	return list.Tags, nil
+}
+
+func upstreamStatus(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errUpstreamNotFound
+	default:
+		return fmt.Errorf("upstream responded %s", resp.Status)
+	}
+}
+
+// verifyingReader fails at the end of the content with a
+// *digestMismatchError if it does not match the expected digest
+type verifyingReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	digest string
+	size   int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	v.hash.Write(p[:n])
+	v.size += int64(n)
+	if actual := fmt.Sprintf("sha256:%x", v.hash.Sum(nil)); err == io.EOF && actual != v.digest {
+		return n, &digestMismatchError{expected: v.digest, actual: actual, size: v.size}
+	}
+	return n, err
+}
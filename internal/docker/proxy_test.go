@@ -0,0 +1,346 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// newTestUpstream serves a registry that, like Docker Hub, demands a bearer
+// token from its token service for every request
+func newTestUpstream(t *testing.T) (*Registry, *httptest.Server, *int32) {
+	upstream := NewRegistry(&models.Repository{Name: "hub", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	var requests int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			assert.Equal(t, "upstream", req.URL.Query().Get("service"))
+			json.NewEncoder(w).Encode(map[string]string{"token": "secret-" + req.URL.Query().Get("scope")})
+			return
+		}
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer secret-repository:") {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="upstream"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&requests, 1)
+		upstream.GetRouter().ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return upstream, server, &requests
+}
+
+func TestProxyRegistry(t *testing.T) {
+	upstream, server, requests := newTestUpstream(t)
+
+	serve := func(registry *Registry, method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	// Publish an image upstream
+	layer := "layer data"
+	w := serve(upstream, "POST", "/v2/library/nginx/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	w = serve(upstream, "PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(layer)), "", layer)
+	require.Equal(t, http.StatusCreated, w.Code)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		MediaTypeDockerSchema2Manifest, MediaTypeDockerSchema2Layer, len(layer), digestOf([]byte(layer)))
+	w = serve(upstream, "PUT", "/v2/library/nginx/manifests/latest", MediaTypeDockerSchema2Manifest, manifest)
+	require.Equal(t, http.StatusCreated, w.Code)
+	digest := w.Header().Get("Docker-Content-Digest")
+
+	store := storage.NewFileStorage(t.TempDir())
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "docker.io", URL: server.URL},
+		{Namespace: "ghcr.io", URL: "http://127.0.0.1:1"},
+	}}}
+	require.NoError(t, ValidateProxy(config.Proxy))
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config, store, logrus.New())
+
+	t.Run("Pull through with ns", func(t *testing.T) {
+		w := serve(mirror, "GET", "/v2/library/nginx/manifests/latest?ns=docker.io", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, manifest, w.Body.String())
+		assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+		assert.Equal(t, MediaTypeDockerSchema2Manifest, w.Header().Get("Content-Type"))
+
+		w = serve(mirror, "GET", "/v2/library/nginx/blobs/"+digestOf([]byte(layer))+"?ns=docker.io", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, layer, w.Body.String())
+
+		// Cached below the namespace
+		exists, err := store.Exists("docker.io/library/nginx", "blobs/"+digestOf([]byte(layer)))
+		require.NoError(t, err)
+		assert.True(t, exists)
+		exists, err = store.Exists("docker.io/library/nginx", "tags/latest")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Cached digests", func(t *testing.T) {
+		before := atomic.LoadInt32(requests)
+		assert.Equal(t, http.StatusOK, serve(mirror, "GET", "/v2/library/nginx/manifests/"+digest+"?ns=docker.io", "", "").Code)
+		assert.Equal(t, http.StatusOK, serve(mirror, "GET", "/v2/library/nginx/blobs/"+digestOf([]byte(layer))+"?ns=docker.io", "", "").Code)
+		assert.Equal(t, before, atomic.LoadInt32(requests))
+	})
+
+	t.Run("Default and path namespaces", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(mirror, "GET", "/v2/library/nginx/manifests/latest", "", "").Code)
+		assert.Equal(t, http.StatusOK, serve(mirror, "GET", "/v2/docker.io/library/nginx/manifests/latest", "", "").Code)
+		w := serve(mirror, "GET", "/v2/library/nginx/manifests/latest?ns=quay.io", "", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "NAME_UNKNOWN")
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		w := serve(mirror, "GET", "/v2/library/nginx/tags/list?ns=docker.io", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"name":"library/nginx","tags":["latest"]}`, w.Body.String())
+	})
+
+	t.Run("Upstream missing", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve(mirror, "GET", "/v2/library/redis/manifests/latest?ns=docker.io", "", "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(mirror, "GET", "/v2/library/nginx/blobs/"+digestOf([]byte("none"))+"?ns=docker.io", "", "").Code)
+		assert.Equal(t, http.StatusBadGateway, serve(mirror, "GET", "/v2/app/manifests/latest?ns=ghcr.io", "", "").Code)
+	})
+
+	t.Run("Upstream down", func(t *testing.T) {
+		server.Close()
+		w := serve(mirror, "GET", "/v2/library/nginx/manifests/latest?ns=docker.io", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+		w = serve(mirror, "GET", "/v2/library/nginx/tags/list?ns=docker.io", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"name":"library/nginx","tags":["latest"]}`, w.Body.String())
+	})
+
+	t.Run("Read-only", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(mirror, "POST", "/v2/library/nginx/blobs/uploads/", "", "").Code)
+		assert.Equal(t, http.StatusMethodNotAllowed, serve(mirror, "PUT", "/v2/library/nginx/manifests/mine", MediaTypeDockerSchema2Manifest, manifest).Code)
+	})
+}
+
+func TestValidateProxy(t *testing.T) {
+	assert.Error(t, ValidateProxy(&models.DockerProxy{}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "registry-1.docker.io"}}}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io/library", URL: "https://registry-1.docker.io"}}}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "docker.io", URL: "https://registry-1.docker.io"},
+		{Namespace: "docker.io", URL: "https://registry-1.docker.io/"},
+	}}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io", Password: "secret"}}}))
+	assert.NoError(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "docker.io", URL: "https://registry-1.docker.io", Username: "user", Password: "secret"},
+		{Namespace: "docker.io", URL: "https://mirror.gcr.io"},
+	}}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}, ManifestTTL: "-5m"}))
+	assert.NoError(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}, ManifestTTL: "1d"}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}, NotFoundTTL: "soon"}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}, CircuitBreaker: &models.CircuitBreaker{Threshold: -1}}))
+	assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: "https://registry-1.docker.io"}}, CircuitBreaker: &models.CircuitBreaker{Cooldown: "0s"}}))
+}
+
+func TestProxyManifestTTL(t *testing.T) {
+	upstream, server, requests := newTestUpstream(t)
+
+	serve := func(registry *Registry, method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	publish := func(version string) string {
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[],"annotations":{"version":%q}}`, MediaTypeOCIManifest, version)
+		w := serve(upstream, "PUT", "/v2/library/nginx/manifests/latest", MediaTypeOCIManifest, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+	expire := func(mirror *Registry) {
+		mirror.proxy.mu.Lock()
+		defer mirror.proxy.mu.Unlock()
+		for key := range mirror.proxy.checked {
+			mirror.proxy.checked[key] = time.Now().Add(-time.Hour)
+		}
+	}
+
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{
+		Upstreams:   []models.DockerUpstream{{Namespace: "docker.io", URL: server.URL}},
+		ManifestTTL: "10m",
+	}}
+	require.NoError(t, ValidateProxy(config.Proxy))
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config, storage.NewFileStorage(t.TempDir()), logrus.New())
+	pull := func() string {
+		w := serve(mirror, "GET", "/v2/library/nginx/manifests/latest", "", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+
+	first := publish("1")
+	assert.Equal(t, first, pull())
+	before := atomic.LoadInt32(requests)
+
+	// Within the TTL the upstream is not asked, even if the tag moved
+	second := publish("2")
+	assert.Equal(t, first, pull())
+	assert.Equal(t, before, atomic.LoadInt32(requests))
+
+	// Once it runs out, a HEAD request finds the new digest, which is fetched
+	expire(mirror)
+	assert.Equal(t, second, pull())
+	assert.Equal(t, before+2, atomic.LoadInt32(requests))
+
+	// An unchanged tag only costs the HEAD request
+	expire(mirror)
+	assert.Equal(t, second, pull())
+	assert.Equal(t, before+3, atomic.LoadInt32(requests))
+	assert.Equal(t, second, pull())
+	assert.Equal(t, before+3, atomic.LoadInt32(requests))
+}
+
+func TestProxyFailover(t *testing.T) {
+	serve := func(registry *Registry, method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+
+	// An upstream that wants basic authentication
+	upstream := NewRegistry(&models.Repository{Name: "private", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[]}`, MediaTypeDockerSchema2Manifest)
+	require.Equal(t, http.StatusCreated, serve(upstream, "PUT", "/v2/app/manifests/v1", MediaTypeDockerSchema2Manifest, manifest).Code)
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if username, password, ok := req.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="private"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upstream.GetRouter().ServeHTTP(w, req)
+	}))
+	defer private.Close()
+
+	var brokenRequests int32
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&brokenRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "example.com", URL: down.URL},
+		{Namespace: "example.com", URL: broken.URL},
+		{Namespace: "example.com", URL: private.URL, Username: "user", Password: "secret"},
+	}}}
+	require.NoError(t, ValidateProxy(config.Proxy))
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config, storage.NewFileStorage(t.TempDir()), logrus.New())
+
+	w := serve(mirror, "GET", "/v2/app/manifests/v1", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, manifest, w.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&brokenRequests))
+
+	statuses := mirror.proxy.statuses()
+	require.Len(t, statuses, 3)
+	assert.False(t, statuses[0].Healthy)
+	assert.Equal(t, 1, statuses[0].Failures)
+	assert.False(t, statuses[1].Healthy)
+	assert.Contains(t, statuses[1].LastError, "503")
+	assert.True(t, statuses[2].Healthy)
+
+	// Unhealthy upstreams are tried last
+	assert.Equal(t, http.StatusOK, serve(mirror, "GET", "/v2/app/manifests/v1", "", "").Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&brokenRequests))
+
+	// Health checks count an authentication challenge as up
+	mirror.proxy.upstreams["example.com"][1].check()
+	assert.False(t, mirror.proxy.statuses()[1].Healthy)
+	mirror.proxy.upstreams["example.com"][2].check()
+	assert.True(t, mirror.proxy.statuses()[2].Healthy)
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull,push"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/nginx:pull,push",
+	}, params)
+}
+
+func TestProxyReadOnly(t *testing.T) {
+	upstream, server, _ := newTestUpstream(t)
+	layer := "layer data"
+	w := serveRegistry(upstream, "POST", "/v2/library/nginx/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	w = serveRegistry(upstream, "PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(layer)), "", layer)
+	require.Equal(t, http.StatusCreated, w.Code)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+		MediaTypeDockerSchema2Manifest, MediaTypeDockerSchema2Layer, len(layer), digestOf([]byte(layer)))
+	require.Equal(t, http.StatusCreated, serveRegistry(upstream, "PUT", "/v2/library/nginx/manifests/latest", MediaTypeDockerSchema2Manifest, manifest).Code)
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	switcher, err := maintenance.NewManager(db)
+	require.NoError(t, err)
+	_, err = switcher.Enable(maintenance.ModeReadOnly, "")
+	require.NoError(t, err)
+
+	store := storage.NewFileStorage(t.TempDir())
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{
+		Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: server.URL}}},
+	}, store, logrus.New())
+	mirror.SetMaintenance(switcher)
+
+	// Pulls are passed through without caching anything
+	w = serveRegistry(mirror, "GET", "/v2/library/nginx/manifests/latest", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, manifest, w.Body.String())
+	assert.Equal(t, digestOf([]byte(manifest)), w.Header().Get("Docker-Content-Digest"))
+	w = serveRegistry(mirror, "GET", "/v2/library/nginx/blobs/"+digestOf([]byte(layer)), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, layer, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, serveRegistry(mirror, "GET", "/v2/library/nginx/manifests/missing", "", "").Code)
+
+	files, err := store.List("docker.io/library/nginx", "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	_, cached := mirror.getManifest("docker.io/library/nginx", "latest")
+	assert.False(t, cached)
+
+	// and cached again after maintenance
+	require.NoError(t, switcher.Disable())
+	require.Equal(t, http.StatusOK, serveRegistry(mirror, "GET", "/v2/library/nginx/manifests/latest", "", "").Code)
+	_, cached = mirror.getManifest("docker.io/library/nginx", "latest")
+	assert.True(t, cached)
+}
@@ -0,0 +1,289 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+// WarmReport describes a warm of a proxy repository
+type WarmReport struct {
+	Repository string   `json:"repository"`
+	Warmed     []string `json:"warmed"`
+	Problems   []string `json:"problems"`
+}
+
+// EvictionReport describes an eviction from the cache of a proxy
+// repository. Size is the bytes cached once it is done.
+type EvictionReport struct {
+	Repository string   `json:"repository"`
+	Evicted    []string `json:"evicted"`
+	Freed      int64    `json:"freed"`
+	Size       int64    `json:"size"`
+}
+
+// ParseImageReference splits an image reference such as nginx:1.25,
+// docker.io/library/nginx or nginx@sha256:... into the image's name and a
+// tag or digest, the tag defaulting to latest
+func ParseImageReference(ref string) (string, string, error) {
+	name, reference := ref, "latest"
+	if at := strings.Index(ref, "@"); at >= 0 {
+		name, reference = ref[:at], ref[at+1:]
+		if !validDigest(reference) {
+			return "", "", fmt.Errorf("invalid digest in %q", ref)
+		}
+	} else if colon := strings.LastIndex(ref, ":"); colon > strings.LastIndex(ref, "/") {
+		name, reference = ref[:colon], ref[colon+1:]
+	}
+	if name == "" || reference == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return "", "", fmt.Errorf("invalid image reference %q", ref)
+	}
+	return name, reference, nil
+}
+
+// WarmProxy fetches the images a proxy repository is configured to warm,
+// with their manifests and layers, so their first pull does not wait for
+// the upstream. Tags are looked up upstream as on a pull.
+func (m *Manager) WarmProxy(ctx context.Context, repoName string) (*WarmReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy == nil {
+		return nil, ErrNotProxy
+	}
+
+	warm := registry.currentConfig().Proxy.Warm
+	report := &WarmReport{Repository: repoName, Warmed: []string{}, Problems: []string{}}
+	for _, ref := range warm {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := registry.warm(ref); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", ref, err))
+			continue
+		}
+		report.Warmed = append(report.Warmed, ref)
+	}
+
+	registry.logger.WithField("repository", repoName).Infof("Warmed %d of %d images", len(report.Warmed), len(warm))
+	return report, nil
+}
+
+// warm caches an image reference with everything it refers to
+func (r *Registry) warm(ref string) error {
+	image, reference, err := ParseImageReference(ref)
+	if err != nil {
+		return err
+	}
+	target, _ := r.proxy.resolveIn("", image)
+	if err := r.proxyManifest(target, reference); err != nil {
+		return err
+	}
+	manifest, exists := r.getManifest(target.local, reference)
+	if !exists {
+		return fmt.Errorf("manifest %s was not cached", reference)
+	}
+	return r.syncManifest(target, digestOf(manifest.Raw))
+}
+
+// EvictProxyCaches evicts from the cache of every proxy repository with a
+// maximum cache size
+func (m *Manager) EvictProxyCaches() ([]*EvictionReport, error) {
+	m.mu.RLock()
+	var names []string
+	for name, registry := range m.registries {
+		if registry.proxy != nil && registry.currentConfig().Proxy.MaxCacheSize > 0 {
+			names = append(names, name)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(names)
+
+	reports := []*EvictionReport{}
+	for _, name := range names {
+		report, err := m.EvictProxyCache(name)
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// cachedImage is an image in the cache of a proxy repository
+type cachedImage struct {
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+// EvictProxyCache deletes the least recently pulled images from the cache
+// of a proxy repository until it fits its maximum cache size. An image was
+// last used when it was last pulled, or if it has not been pulled since
+// depot started, when it was last fetched. Pinned images are never evicted.
+func (m *Manager) EvictProxyCache(repoName string) (*EvictionReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy == nil {
+		return nil, ErrNotProxy
+	}
+
+	images, err := registry.cachedImages()
+	if err != nil {
+		return nil, err
+	}
+	report := &EvictionReport{Repository: repoName, Evicted: []string{}}
+	for _, image := range images {
+		report.Size += image.size
+	}
+	config := registry.currentConfig()
+	limit := config.Proxy.MaxCacheSize
+	if limit <= 0 || report.Size <= limit {
+		return report, nil
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].lastUsed.Before(images[j].lastUsed) })
+	for _, image := range images {
+		if report.Size <= limit {
+			break
+		}
+		if pinned(config.Proxy, image.name) {
+			continue
+		}
+		evicted, err := registry.evict(image.name)
+		if err != nil {
+			return report, fmt.Errorf("failed to evict %s: %w", image.name, err)
+		}
+		if !evicted {
+			continue
+		}
+		report.Evicted = append(report.Evicted, image.name)
+		report.Freed += image.size
+		report.Size -= image.size
+	}
+
+	registry.logger.WithField("repository", repoName).Infof("Evicted %d images, freeing %d bytes", len(report.Evicted), report.Freed)
+	return report, nil
+}
+
+// cachedImages lists the images in the cache with their size and when
+// they were last used
+func (r *Registry) cachedImages() ([]cachedImage, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.manifests))
+	for name := range r.manifests {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+
+	images := make([]cachedImage, 0, len(names))
+	for _, name := range names {
+		files, err := r.storage.List(name, "")
+		if err != nil {
+			return nil, err
+		}
+		image := cachedImage{name: name}
+		for _, file := range files {
+			image.size += file.Size
+			if file.ModTime.After(image.lastUsed) {
+				image.lastUsed = file.ModTime
+			}
+		}
+		if used := r.proxy.lastUsed(name); used.After(image.lastUsed) {
+			image.lastUsed = used
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// pinned reports whether an image matches one of the proxy's pinned
+// patterns
+func pinned(config *models.DockerProxy, image string) bool {
+	for _, pattern := range config.Pinned {
+		if glob.Match(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// evict removes an image from the cache; the next pull fetches it again.
+// An image being pulled is left alone and evict returns false. Pulls of
+// the image that start meanwhile wait for it to be gone.
+func (r *Registry) evict(image string) (bool, error) {
+	if !r.proxy.startEviction(image) {
+		return false, nil
+	}
+	defer r.proxy.finishEviction(image)
+
+	r.mu.Lock()
+	delete(r.manifests, image)
+	r.mu.Unlock()
+
+	if err := r.releaseImage(image); err != nil {
+		return false, err
+	}
+	if err := removeAll(r.storage, image, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pull records that a cached image is being pulled, until the function it
+// returns is called, so it is not evicted meanwhile. Hosted registries,
+// which fetch from upstreams only to sync, have no proxy and record
+// nothing.
+func (p *proxy) pull(image string) func() {
+	if p == nil {
+		return func() {}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.evicting[image] {
+		p.evicted.Wait()
+	}
+	p.pulling[image]++
+	p.used[image] = time.Now()
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.pulling[image]--; p.pulling[image] == 0 {
+			delete(p.pulling, image)
+		}
+	}
+}
+
+// startEviction reports whether an image is not being pulled, and if so
+// holds off its pulls until finishEviction
+func (p *proxy) startEviction(image string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pulling[image] > 0 || p.evicting[image] {
+		return false
+	}
+	p.evicting[image] = true
+	delete(p.used, image)
+	return true
+}
+
+func (p *proxy) finishEviction(image string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.evicting, image)
+	p.evicted.Broadcast()
+}
+
+func (p *proxy) lastUsed(image string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used[image]
+}
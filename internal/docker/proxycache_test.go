@@ -0,0 +1,120 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestParseImageReference(t *testing.T) {
+	for ref, want := range map[string][2]string{
+		"nginx":                {"nginx", "latest"},
+		"library/nginx:1.25":   {"library/nginx", "1.25"},
+		"localhost:5000/nginx": {"localhost:5000/nginx", "latest"},
+		"docker.io/library/nginx@" + digestOf(nil): {"docker.io/library/nginx", digestOf(nil)},
+	} {
+		image, reference, err := ParseImageReference(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, want, [2]string{image, reference}, ref)
+	}
+	for _, ref := range []string{"", ":1.0", "nginx:", "nginx@sha256:short", "/nginx"} {
+		_, _, err := ParseImageReference(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestProxyCache(t *testing.T) {
+	upstream, server, _ := newTestUpstream(t)
+	for _, image := range []string{"library/nginx", "library/redis", "library/alpine"} {
+		pushImage(t, upstream, image, "1.0", `{"architecture":"amd64","image":"`+image+`"}`)
+	}
+
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{
+		Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: server.URL}},
+		Warm:      []string{"library/nginx:1.0", "docker.io/library/redis:1.0", "library/alpine:1.0", "library/missing:1.0"},
+		Pinned:    []string{"docker.io/library/redis"},
+	}}
+	require.NoError(t, ValidateProxy(config.Proxy))
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config))
+	defer manager.StopAll()
+	mirror, _ := manager.GetRegistry("mirror")
+	// resize changes the maximum cache size while the proxy runs
+	resize := func(size int64) {
+		proxy := *config.Proxy
+		proxy.MaxCacheSize = size
+		resized := *config
+		resized.Proxy = &proxy
+		require.NoError(t, manager.Reconfigure("mirror", &resized))
+	}
+
+	report, err := manager.WarmProxy(context.Background(), "mirror")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"library/nginx:1.0", "docker.io/library/redis:1.0", "library/alpine:1.0"}, report.Warmed)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "library/missing:1.0")
+
+	// Warmed images are served with their layers without asking the upstream
+	server.Close()
+	w := serveRegistry(mirror, "GET", "/v2/library/nginx/manifests/1.0", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &manifest))
+	assert.Equal(t, http.StatusOK, serveRegistry(mirror, "GET", "/v2/library/nginx/blobs/"+manifest.Config.Digest, "", "").Code)
+
+	// Without a maximum size nothing is evicted
+	eviction, err := manager.EvictProxyCache("mirror")
+	require.NoError(t, err)
+	assert.Empty(t, eviction.Evicted)
+
+	// Pulls mark images as used; alpine is the least recently used and
+	// redis, pinned, is never evicted
+	mirror.proxy.mu.Lock()
+	mirror.proxy.used["docker.io/library/redis"] = time.Now().Add(-2 * time.Hour)
+	mirror.proxy.used["docker.io/library/alpine"] = time.Now().Add(-time.Hour)
+	mirror.proxy.mu.Unlock()
+	images, err := mirror.cachedImages()
+	require.NoError(t, err)
+	resize(eviction.Size - 1)
+
+	eviction, err = manager.EvictProxyCache("mirror")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/alpine"}, eviction.Evicted)
+	for _, image := range images {
+		if image.name == "docker.io/library/alpine" {
+			assert.Equal(t, image.size, eviction.Freed)
+		}
+	}
+	assert.LessOrEqual(t, eviction.Size, mirror.currentConfig().Proxy.MaxCacheSize)
+	_, cached := mirror.getManifest("docker.io/library/alpine", "1.0")
+	assert.False(t, cached)
+	files, err := mirror.storage.List("docker.io/library/alpine", "")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	// An image being pulled is left for the next eviction
+	resize(1)
+	done := mirror.proxy.pull("docker.io/library/nginx")
+	eviction, err = manager.EvictProxyCache("mirror")
+	require.NoError(t, err)
+	assert.Empty(t, eviction.Evicted)
+	_, cached = mirror.getManifest("docker.io/library/nginx", "1.0")
+	assert.True(t, cached, "image being pulled was evicted")
+	done()
+
+	reports, err := manager.EvictProxyCaches()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, []string{"docker.io/library/nginx"}, reports[0].Evicted)
+	_, cached = mirror.getManifest("docker.io/library/redis", "1.0")
+	assert.True(t, cached, "pinned image was evicted")
+}
@@ -0,0 +1,341 @@
+package docker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrNoPullToken      = errors.New("pull token required")
+	ErrInvalidPullToken = errors.New("invalid pull token")
+	ErrPullTokenExpired = errors.New("pull token has expired")
+	ErrPullTokenScope   = errors.New("pull token does not cover this image")
+	ErrPullTokenSpent   = errors.New("single-use pull token was already used")
+)
+
+// PullToken is a short-lived credential to pull one image, or one tag of
+// it, from a Docker repository that requires pull tokens. A federation
+// token pulls every image of the repository and reads its change feed, for
+// another depot that mirrors it.
+type PullToken struct {
+	Token      string    `json:"token"`
+	Repository string    `json:"repository"`
+	Image      string    `json:"image,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	SingleUse  bool      `json:"single_use,omitempty"`
+	Federation bool      `json:"federation,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// pullClaims is what a token carries, signed
+type pullClaims struct {
+	ID         string `json:"id"`
+	Repository string `json:"r"`
+	Image      string `json:"i"`
+	Tag        string `json:"t,omitempty"`
+	SingleUse  bool   `json:"s,omitempty"`
+	Federation bool   `json:"f,omitempty"`
+	Expires    int64  `json:"e"`
+}
+
+// PullTokens mints pull tokens signed with an HMAC-SHA256 key and checks
+// them. Tokens need no storage; the single-use tokens that were spent are
+// remembered in memory until they expire.
+type PullTokens struct {
+	key   []byte
+	mu    sync.Mutex
+	spent map[string]time.Time // token ID -> expiry
+}
+
+// NewPullTokens creates pull tokens signed with key
+func NewPullTokens(key []byte) *PullTokens {
+	return &PullTokens{key: key, spent: make(map[string]time.Time)}
+}
+
+// Mint creates a token to pull image, or only image:tag if tag is set,
+// from a repository until expires
+func (p *PullTokens) Mint(repository, image, tag string, singleUse bool, expires time.Time) (*PullToken, error) {
+	return p.mint(pullClaims{
+		Repository: repository,
+		Image:      image,
+		Tag:        tag,
+		SingleUse:  singleUse,
+		Expires:    expires.Unix(),
+	})
+}
+
+// MintFederation creates a token to pull any image of a repository and
+// read its change feed until expires
+func (p *PullTokens) MintFederation(repository string, expires time.Time) (*PullToken, error) {
+	return p.mint(pullClaims{
+		Repository: repository,
+		Federation: true,
+		Expires:    expires.Unix(),
+	})
+}
+
+func (p *PullTokens) mint(claims pullClaims) (*PullToken, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	claims.ID = hex.EncodeToString(id)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return &PullToken{
+		Token:      encoded + "." + p.signature(encoded),
+		Repository: claims.Repository,
+		Image:      claims.Image,
+		Tag:        claims.Tag,
+		SingleUse:  claims.SingleUse,
+		Federation: claims.Federation,
+		ExpiresAt:  time.Unix(claims.Expires, 0).UTC(),
+	}, nil
+}
+
+func (p *PullTokens) signature(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte("pull-token\n" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parse verifies a token's signature and expiry and returns its claims
+func (p *PullTokens) parse(token, repository string) (*pullClaims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(p.signature(payload))) {
+		return nil, ErrInvalidPullToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidPullToken
+	}
+	var claims pullClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrInvalidPullToken
+	}
+	if claims.Repository != repository {
+		return nil, ErrPullTokenScope
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, ErrPullTokenExpired
+	}
+	return &claims, nil
+}
+
+// check verifies that a token allows a pull of reference from image,
+// where reference is a tag or digest, or empty for blobs and tag lists.
+// A federation token allows every pull from the repository. A token for a
+// tag allows the tag and content addressed by digest, which the registry
+// limits to the tag's manifests and blobs with inTagTree. A single-use
+// token is spent by its first manifest request by tag; after
+// that it only pulls content addressed by digest, so the pull it started
+// can finish.
+func (p *PullTokens) check(token, repository, image, reference string) error {
+	claims, err := p.parse(token, repository)
+	if err != nil {
+		return err
+	}
+	if claims.Federation {
+		return nil
+	}
+	if claims.Image != image {
+		return ErrPullTokenScope
+	}
+	if reference == "" || strings.HasPrefix(reference, "sha256:") {
+		return nil
+	}
+	if claims.Tag != "" && claims.Tag != reference {
+		return ErrPullTokenScope
+	}
+	if claims.SingleUse {
+		return p.spend(claims)
+	}
+	return nil
+}
+
+func (p *PullTokens) spend(claims *pullClaims) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().Unix()
+	for id, expires := range p.spent {
+		if now > expires.Unix() {
+			delete(p.spent, id)
+		}
+	}
+	if _, spent := p.spent[claims.ID]; spent {
+		return ErrPullTokenSpent
+	}
+	p.spent[claims.ID] = time.Unix(claims.Expires, 0)
+	return nil
+}
+
+// pullTokenFrom returns the token of a request, sent as a bearer token or
+// as the password of basic authentication, as docker login stores it
+func pullTokenFrom(req *http.Request) string {
+	if _, password, ok := req.BasicAuth(); ok {
+		return password
+	}
+	if scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// pullAuthorized refuses pulls without a pull token covering the image
+// when the repository requires one
+func (r *Registry) pullAuthorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !r.currentConfig().RequirePullToken {
+			next(w, req)
+			return
+		}
+
+		var err error
+		token := pullTokenFrom(req)
+		switch {
+		case r.pullTokens == nil:
+			err = ErrInvalidPullToken
+		case token == "":
+			err = ErrNoPullToken
+		default:
+			vars := mux.Vars(req)
+			if vars["name"] == "" {
+				// The base endpoint, which docker login calls, and the
+				// catalog take any token of the repository
+				_, err = r.pullTokens.parse(token, r.repo.Name)
+			} else {
+				reference := vars["reference"]
+				if reference == "" {
+					reference = vars["digest"]
+				}
+				err = r.pullTokens.check(token, r.repo.Name, vars["name"], reference)
+				if err == nil && strings.HasPrefix(reference, "sha256:") {
+					err = r.checkTagScope(token, vars["name"], reference)
+				}
+			}
+		}
+		if err != nil {
+			w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+			w.Header().Set("WWW-Authenticate", `Basic realm="depot"`)
+			r.writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", err.Error(), nil)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// checkTagScope refuses content addressed by digest to a token for a tag
+// unless the tag's manifest refers to it
+func (r *Registry) checkTagScope(token, image, digest string) error {
+	claims, err := r.pullTokens.parse(token, r.repo.Name)
+	if err != nil {
+		return err
+	}
+	if claims.Federation || claims.Tag == "" || r.inTagTree(image, claims.Tag, digest) {
+		return nil
+	}
+	return ErrPullTokenScope
+}
+
+// inTagTree reports whether digest is the manifest a tag points at, or a
+// manifest or blob it refers to, directly or through a manifest list
+func (r *Registry) inTagTree(image, tag, digest string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	manifests := r.manifests[image]
+	seen := make(map[string]bool)
+	var walk func(manifest *Manifest) bool
+	walk = func(manifest *Manifest) bool {
+		current := digestOf(manifest.Raw)
+		if current == digest {
+			return true
+		}
+		if seen[current] {
+			return false
+		}
+		seen[current] = true
+		for _, blob := range manifest.blobs() {
+			if blob.Digest == digest {
+				return true
+			}
+		}
+		for _, child := range manifest.Manifests {
+			if child.Digest == digest {
+				return true
+			}
+			if cached, exists := manifests[child.Digest]; exists && walk(cached) {
+				return true
+			}
+		}
+		return false
+	}
+	root, exists := manifests[tag]
+	return exists && walk(root)
+}
+
+// SetPullTokens sets the signer of the pull tokens that registries
+// requiring them accept
+func (m *Manager) SetPullTokens(tokens *PullTokens) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pullTokens = tokens
+}
+
+// MintPullToken creates a token to pull an image of a repository, or
+// only one tag of it, until expires
+func (m *Manager) MintPullToken(repoName, image, tag string, singleUse bool, expires time.Time) (*PullToken, error) {
+	m.mu.RLock()
+	tokens := m.pullTokens
+	m.mu.RUnlock()
+	if tokens == nil {
+		return nil, errors.New("pull tokens are not enabled")
+	}
+
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	registry.mu.RLock()
+	repoManifests, exists := registry.manifests[image]
+	if exists && tag != "" {
+		_, exists = repoManifests[tag]
+	}
+	registry.mu.RUnlock()
+	// Proxies fetch images on first pull, so they need not be cached yet
+	if !exists && registry.proxy == nil {
+		return nil, ErrManifestNotFound
+	}
+	return tokens.Mint(repoName, image, tag, singleUse, expires)
+}
+
+// requirePullTokens reports whether a registry routed on the main server
+// port requires pull tokens
+func (m *Manager) requirePullTokens() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, registry := range m.registries {
+		if registry.config.RequirePullToken {
+			return true
+		}
+	}
+	return false
+}
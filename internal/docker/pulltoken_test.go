@@ -0,0 +1,77 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestPullTokens(t *testing.T) {
+	tokens := NewPullTokens([]byte("key"))
+	expires := time.Now().Add(time.Minute)
+
+	token, err := tokens.Mint("private", "app", "", false, expires)
+	require.NoError(t, err)
+	assert.NoError(t, tokens.check(token.Token, "private", "app", "1.0"))
+	assert.NoError(t, tokens.check(token.Token, "private", "app", ""))
+	assert.ErrorIs(t, tokens.check(token.Token, "private", "other", "1.0"), ErrPullTokenScope)
+	assert.ErrorIs(t, tokens.check(token.Token, "public", "app", "1.0"), ErrPullTokenScope)
+	assert.ErrorIs(t, NewPullTokens([]byte("other")).check(token.Token, "private", "app", "1.0"), ErrInvalidPullToken)
+
+	expired, err := tokens.Mint("private", "app", "", false, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.ErrorIs(t, tokens.check(expired.Token, "private", "app", "1.0"), ErrPullTokenExpired)
+
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	single, err := tokens.Mint("private", "app", "1.0", true, expires)
+	require.NoError(t, err)
+	assert.ErrorIs(t, tokens.check(single.Token, "private", "app", "2.0"), ErrPullTokenScope)
+	assert.NoError(t, tokens.check(single.Token, "private", "app", "1.0"))
+	assert.ErrorIs(t, tokens.check(single.Token, "private", "app", "1.0"), ErrPullTokenSpent)
+	assert.NoError(t, tokens.check(single.Token, "private", "app", digest))
+}
+
+func TestFederationTokens(t *testing.T) {
+	tokens := NewPullTokens([]byte("key"))
+	token, err := tokens.MintFederation("private", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.NoError(t, tokens.check(token.Token, "private", "app", "1.0"))
+	assert.NoError(t, tokens.check(token.Token, "private", "other", "latest"))
+	assert.NoError(t, tokens.check(token.Token, "private", "other", "latest"))
+	assert.ErrorIs(t, tokens.check(token.Token, "public", "app", "1.0"), ErrPullTokenScope)
+}
+
+func TestPullTokenTagScope(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "private", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{RequirePullToken: true}))
+	private, _ := manager.GetRegistry("private")
+	tokens := NewPullTokens([]byte("key"))
+	private.pullTokens = tokens
+	release := pushImage(t, private, "app", "1.0", `{"architecture":"amd64"}`)
+	internal := pushImage(t, private, "app", "internal", `{"architecture":"arm64"}`)
+
+	token, err := tokens.Mint("private", "app", "1.0", false, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	pull := func(url string) int {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		w := httptest.NewRecorder()
+		private.GetRouter().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The tag's manifest and blobs pull by digest, other content does not
+	assert.Equal(t, http.StatusOK, pull("/v2/app/manifests/"+release))
+	assert.Equal(t, http.StatusOK, pull("/v2/app/blobs/"+digestOf([]byte(`{"architecture":"amd64"}`))))
+	assert.Equal(t, http.StatusUnauthorized, pull("/v2/app/manifests/"+internal))
+	assert.Equal(t, http.StatusUnauthorized, pull("/v2/app/blobs/"+digestOf([]byte(`{"architecture":"arm64"}`))))
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// quarantineNamespace is the hidden storage namespace keeping upstream
+// content that failed verification, below the repository name
+const quarantineNamespace = ".registry-quarantine"
+
+// ErrQuarantineItemNotFound is returned for unknown quarantine items
+var ErrQuarantineItemNotFound = errors.New("quarantine item not found")
+
+// QuarantineItem records upstream content a proxy repository refused to
+// cache because it did not match its digest. Retained is set if the
+// content was kept for inspection, which manifests are; blobs, which may
+// be large, are only recorded.
+type QuarantineItem struct {
+	ID            string    `json:"id"`
+	Repository    string    `json:"repository"`
+	Image         string    `json:"image"`
+	Reference     string    `json:"reference"`
+	Kind          string    `json:"kind"`
+	Upstream      string    `json:"upstream"`
+	Expected      string    `json:"expected"`
+	Actual        string    `json:"actual"`
+	Size          int64     `json:"size"`
+	Retained      bool      `json:"retained"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// digestMismatchError is returned for content that does not match the
+// digest it was expected to have
+type digestMismatchError struct {
+	expected string
+	actual   string
+	size     int64
+}
+
+func (e *digestMismatchError) Error() string {
+	return fmt.Sprintf("content does not match digest %s", e.expected)
+}
+
+// quarantine records upstream content that failed verification, keeping
+// content if it is given
+func (r *Registry) quarantine(item *QuarantineItem, content []byte) {
+	item.ID = uuid.New().String()
+	item.Repository = r.repo.Name
+	item.Retained = content != nil
+	item.QuarantinedAt = time.Now().UTC()
+
+	log := r.logger.WithFields(logrus.Fields{
+		"repository": item.Repository,
+		"image":      item.Image,
+		"reference":  item.Reference,
+		"upstream":   item.Upstream,
+		"expected":   item.Expected,
+		"actual":     item.Actual,
+		"quarantine": item.ID,
+	})
+
+	if content != nil {
+		if err := r.storage.Store(quarantineNamespace, path.Join(item.Repository, item.ID), bytes.NewReader(content)); err != nil {
+			log.WithError(err).Error("Failed to keep quarantined upstream content")
+			item.Retained = false
+		}
+	}
+	data, err := json.Marshal(item)
+	if err == nil {
+		err = r.storage.Store(quarantineNamespace, path.Join(item.Repository, item.ID+".json"), bytes.NewReader(data))
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to record quarantined upstream content")
+		return
+	}
+	log.Warn("Upstream content failed verification and was quarantined")
+}
+
+// UpstreamQuarantine lists the upstream content a repository refused to
+// cache, most recent first
+func (m *Manager) UpstreamQuarantine(repoName string) ([]*QuarantineItem, error) {
+	files, err := m.storage.List(quarantineNamespace, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*QuarantineItem{}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Path, ".json") {
+			continue
+		}
+		data, err := readAll(m.storage, quarantineNamespace, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		var item QuarantineItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("invalid quarantine item %s: %w", file.Path, err)
+		}
+		items = append(items, &item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].QuarantinedAt.After(items[j].QuarantinedAt)
+	})
+	return items, nil
+}
+
+// DeleteUpstreamQuarantine removes a quarantine item and its content
+func (m *Manager) DeleteUpstreamQuarantine(repoName, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrQuarantineItemNotFound
+	}
+	record := path.Join(repoName, id+".json")
+	if exists, err := m.storage.Exists(quarantineNamespace, record); err != nil {
+		return err
+	} else if !exists {
+		return ErrQuarantineItemNotFound
+	}
+
+	if err := m.storage.Delete(quarantineNamespace, path.Join(repoName, id)); err != nil {
+		return err
+	}
+	return m.storage.Delete(quarantineNamespace, record)
+}
@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestUpstreamQuarantine(t *testing.T) {
+	layer := []byte("layer data")
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[]}`, MediaTypeDockerSchema2Manifest)
+
+	// An upstream whose content does not match what it claims
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/manifests/latest"):
+			w.Header().Set("Content-Type", MediaTypeDockerSchema2Manifest)
+			w.Header().Set("Docker-Content-Digest", digestOf([]byte("another manifest")))
+			w.Write([]byte(manifest))
+		case strings.HasSuffix(req.URL.Path, "/blobs/"+digestOf(layer)):
+			w.Write([]byte("tampered layer"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	store := storage.NewFileStorage(t.TempDir())
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{{Namespace: "docker.io", URL: server.URL}}}}
+	mirror := NewRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config, store, logrus.New())
+	m := NewManager(store, nil, logrus.New())
+
+	get := func(url string) int {
+		w := httptest.NewRecorder()
+		mirror.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadGateway, get("/v2/library/app/manifests/latest"))
+	assert.Equal(t, http.StatusBadGateway, get("/v2/library/app/blobs/"+digestOf(layer)))
+
+	// Nothing was cached
+	exists, err := store.Exists("docker.io/library/app", "blobs/"+digestOf(layer))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, cached := mirror.getManifest("docker.io/library/app", "latest")
+	assert.False(t, cached)
+
+	items, err := m.UpstreamQuarantine("mirror")
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	byKind := map[string]*QuarantineItem{}
+	for _, item := range items {
+		byKind[item.Kind] = item
+		assert.Equal(t, "docker.io/library/app", item.Image)
+		assert.Equal(t, server.URL, item.Upstream)
+	}
+
+	kept := byKind["manifest"]
+	require.NotNil(t, kept)
+	assert.True(t, kept.Retained)
+	assert.Equal(t, digestOf([]byte(manifest)), kept.Actual)
+	content, err := readAll(store, quarantineNamespace, "mirror/"+kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, string(content))
+
+	blob := byKind["blob"]
+	require.NotNil(t, blob)
+	assert.False(t, blob.Retained)
+	assert.Equal(t, digestOf(layer), blob.Expected)
+	assert.Equal(t, digestOf([]byte("tampered layer")), blob.Actual)
+	assert.Equal(t, int64(len("tampered layer")), blob.Size)
+
+	require.NoError(t, m.DeleteUpstreamQuarantine("mirror", kept.ID))
+	assert.ErrorIs(t, m.DeleteUpstreamQuarantine("mirror", kept.ID), ErrQuarantineItemNotFound)
+	assert.ErrorIs(t, m.DeleteUpstreamQuarantine("mirror", "../x"), ErrQuarantineItemNotFound)
+
+	require.NoError(t, m.ForgetImages("mirror"))
+	items, err = m.UpstreamQuarantine("mirror")
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
@@ -0,0 +1,62 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/depot/depot/internal/fairqueue"
+)
+
+// SetQueues sets the queues that blob uploads to, and fetches from the
+// upstream of, registries started afterwards wait in; nil queues do not
+// limit them
+func (m *Manager) SetQueues(uploads, fetches *fairqueue.Queue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.uploadQueue = uploads
+	m.fetchQueue = fetches
+}
+
+// SetQueues has the registry's blob uploads wait in the uploads queue, and
+// its fetches from the upstream of a pull-through cache in the fetches
+// queue
+func (r *Registry) SetQueues(uploads, fetches *fairqueue.Queue) {
+	r.fetchQueue = fetches
+	if uploads == nil {
+		return
+	}
+	r.router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !isBlobUpload(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			release, err := uploads.Acquire(req.Context(), r.repo.Name)
+			if err != nil {
+				r.writeBusy(w)
+				return
+			}
+			defer release()
+			next.ServeHTTP(w, req)
+		})
+	})
+}
+
+// fetch waits for a slot to fetch from the upstream. The returned function
+// gives it back.
+func (r *Registry) fetch() (func(), error) {
+	return r.fetchQueue.Acquire(context.Background(), r.repo.Name)
+}
+
+// writeBusy refuses a request that waited too long for a queue slot
+func (r *Registry) writeBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", fairqueue.RetryAfter)
+	r.writeError(w, http.StatusServiceUnavailable, "UNAVAILABLE", fairqueue.ErrBusy.Error(), nil)
+}
+
+// isBusy reports whether an operation failed waiting for a queue slot
+func isBusy(err error) bool {
+	return errors.Is(err, fairqueue.ErrBusy)
+}
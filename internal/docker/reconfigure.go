@@ -0,0 +1,79 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// ErrNotReconfigurable is returned for a configuration change that needs
+// the registry to be recreated
+var ErrNotReconfigurable = errors.New("only cleanup policies, proxy cache settings and the credentials and trusted certificates of proxy upstreams can be changed")
+
+// CheckReconfigure reports whether updated differs from current only in
+// settings a running registry can take without a restart
+func CheckReconfigure(current, updated *models.DockerRepositoryConfig) error {
+	if !reflect.DeepEqual(fixedSettings(current), fixedSettings(updated)) {
+		return ErrNotReconfigurable
+	}
+	return nil
+}
+
+// fixedSettings returns a copy of a configuration without the settings
+// that can change while its registry runs
+func fixedSettings(config *models.DockerRepositoryConfig) *models.DockerRepositoryConfig {
+	var fixed models.DockerRepositoryConfig
+	data, _ := json.Marshal(config)
+	json.Unmarshal(data, &fixed)
+	fixed.CleanupPolicies = nil
+	if fixed.Proxy != nil {
+		fixed.Proxy.Warm = nil
+		fixed.Proxy.Pinned = nil
+		fixed.Proxy.MaxCacheSize = 0
+		for i := range fixed.Proxy.Upstreams {
+			fixed.Proxy.Upstreams[i].Username = ""
+			fixed.Proxy.Upstreams[i].Password = ""
+			fixed.Proxy.Upstreams[i].CACertificates = ""
+			fixed.Proxy.Upstreams[i].PinnedKeys = nil
+		}
+	}
+	return &fixed
+}
+
+// Reconfigure applies a changed configuration, which CheckReconfigure
+// accepted, to the running registry of a repository. A repository without
+// a running registry picks it up when its registry starts.
+func (m *Manager) Reconfigure(repoName string, config *models.DockerRepositoryConfig) error {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil
+	}
+	registry.mu.RLock()
+	current := registry.config
+	registry.mu.RUnlock()
+	if err := CheckReconfigure(current, config); err != nil {
+		return err
+	}
+
+	// Upstreams are in configuration order, which cannot change. A client
+	// is only replaced, dropping its connections, if the trust changed.
+	if registry.proxy != nil {
+		seen := make(map[string]int)
+		for i, u := range config.Proxy.Upstreams {
+			running := registry.proxy.upstreams[u.Namespace][seen[u.Namespace]]
+			seen[u.Namespace]++
+			running.setCredentials(u.Username, u.Password)
+			if !sameTrust(current.Proxy.Upstreams[i], u) {
+				running.setClient(upstreamClient(u, registry.proxy.client))
+			}
+		}
+	}
+
+	registry.mu.Lock()
+	registry.config = config
+	registry.mu.Unlock()
+	registry.logger.WithField("repository", repoName).Info("Applied configuration change")
+	return nil
+}
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestReconfigure(t *testing.T) {
+	// The upstream takes one password at a time
+	var password atomic.Value
+	password.Store("old")
+	upstream := NewRegistry(&models.Repository{Name: "hub", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "mirror" || pass != password.Load() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hub"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		upstream.GetRouter().ServeHTTP(w, req)
+	}))
+	defer server.Close()
+	pushImage(t, upstream, "app", "1.0", `{"architecture":"amd64"}`)
+
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "hub", URL: server.URL, Username: "mirror", Password: "old"},
+	}}}
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config))
+	defer manager.StopAll()
+	mirror, _ := manager.GetRegistry("mirror")
+
+	// The upstream's password is rotated, then the mirror's
+	password.Store("new")
+	assert.Equal(t, http.StatusBadGateway, serveRegistry(mirror, "GET", "/v2/app/manifests/1.0", "", "").Code)
+	rotated := *config
+	rotated.Proxy = &models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "hub", URL: server.URL, Username: "mirror", Password: "new"},
+	}}
+	require.NoError(t, manager.Reconfigure("mirror", &rotated))
+	assert.Equal(t, http.StatusOK, serveRegistry(mirror, "GET", "/v2/app/manifests/1.0", "", "").Code)
+
+	// Cleanup policies are read by each cleanup run
+	retained := rotated
+	retained.CleanupPolicies = []models.CleanupPolicy{{Name: "ephemeral", Enabled: true, MaxAgeDays: 7}}
+	require.NoError(t, manager.Reconfigure("mirror", &retained))
+	assert.Equal(t, retained.CleanupPolicies, mirror.config.CleanupPolicies)
+
+	// Anything else needs the registry recreated
+	moved := rotated
+	moved.Proxy = &models.DockerProxy{Upstreams: []models.DockerUpstream{
+		{Namespace: "hub", URL: "https://elsewhere.example.com", Username: "mirror", Password: "new"},
+	}}
+	assert.ErrorIs(t, manager.Reconfigure("mirror", &moved), ErrNotReconfigurable)
+	moved = rotated
+	moved.V1Enabled = true
+	assert.ErrorIs(t, CheckReconfigure(&rotated, &moved), ErrNotReconfigurable)
+
+	// Repositories without a running registry have nothing to apply
+	assert.NoError(t, manager.Reconfigure("missing", &rotated))
+}
@@ -2,31 +2,60 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 
+	"github.com/depot/depot/internal/compress"
+	"github.com/depot/depot/internal/connections"
+	"github.com/depot/depot/internal/fairqueue"
+	"github.com/depot/depot/internal/logging"
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/metrics"
+	"github.com/depot/depot/internal/requestid"
 	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/timeouts"
 	"github.com/depot/depot/pkg/models"
 )
 
 // Registry represents a Docker registry instance
 type Registry struct {
-	repo     *models.Repository
-	config   *models.DockerRepositoryConfig
-	storage  storage.Storage
-	server   *http.Server
-	router   *mux.Router
-	logger   *logrus.Logger
-	mu       sync.RWMutex
-	manifests map[string]map[string]*Manifest // repo -> tag/digest -> manifest
-	uploads   map[string]*Upload               // uuid -> upload session
+	repo          *models.Repository
+	config        *models.DockerRepositoryConfig
+	storage       storage.Storage
+	server        *http.Server
+	router        *mux.Router
+	logger        *logrus.Logger
+	mu            sync.RWMutex
+	manifests     map[string]map[string]*Manifest // repo -> tag/digest -> manifest
+	uploads       map[string]*Upload              // uuid -> upload session
+	maxUploadSize int64                           // server-wide limit, 0 for none
+	listen        func(network, address string) (net.Listener, error)
+	timeouts      timeouts.Config
+	connections   connections.Config
+	metrics       *metrics.Recorder
+	sampling      *logging.AccessSampling
+	middleware    []func(http.Handler) http.Handler // wraps the router on a port of its own
+	onDownload    func(repository, artifact string)
+	onPush        func(repository, artifact string)
+	verifier      *SignatureVerifier // nil without a signature policy
+	proxy         *proxy             // nil unless a pull-through cache
+	fetchQueue    *fairqueue.Queue   // nil without a limit on fetches
+	pullTokens    *PullTokens
+	maintenance   *maintenance.Manager
 }
 
 // Manifest represents a Docker manifest
@@ -36,17 +65,21 @@ type Manifest struct {
 	Config        *Descriptor            `json:"config,omitempty"`
 	Layers        []Descriptor           `json:"layers,omitempty"`
 	Manifests     []ManifestDescriptor   `json:"manifests,omitempty"` // For manifest lists
+	ArtifactType  string                 `json:"artifactType,omitempty"`
+	Subject       *Descriptor            `json:"subject,omitempty"` // The manifest this one refers to
 	Annotations   map[string]string      `json:"annotations,omitempty"`
 	Raw           []byte                 `json:"-"`
+	labels        map[string]string      // annotations and image config labels, set by putManifest
 }
 
 // Descriptor represents a content descriptor
 type Descriptor struct {
-	MediaType string            `json:"mediaType"`
-	Size      int64             `json:"size"`
-	Digest    string            `json:"digest"`
-	URLs      []string          `json:"urls,omitempty"`
-	Annotations map[string]string `json:"annotations,omitempty"`
+	MediaType    string            `json:"mediaType"`
+	Size         int64             `json:"size"`
+	Digest       string            `json:"digest"`
+	URLs         []string          `json:"urls,omitempty"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
 }
 
 // ManifestDescriptor extends Descriptor with platform information
@@ -64,13 +97,17 @@ type Platform struct {
 	Variant      string   `json:"variant,omitempty"`
 }
 
-// Upload represents an in-progress blob upload
+// Upload represents an in-progress blob upload. All but its data is
+// persisted as the session of the upload. Data holds the blob from its
+// start; chunks sent ahead of it in parallel wait in Parts, keyed by
+// offset, until the data before them arrives.
 type Upload struct {
-	UUID      string
-	RepoName  string
-	StartedAt time.Time
-	Size      int64
-	Data      []byte
+	UUID      string           `json:"uuid"`
+	RepoName  string           `json:"repo_name"`
+	StartedAt time.Time        `json:"started_at"`
+	Size      int64            `json:"size"`
+	Data      []byte           `json:"-"`
+	Parts     map[int64][]byte `json:"-"`
 }
 
 // MediaTypes for Docker/OCI content
@@ -83,6 +120,7 @@ const (
 	MediaTypeOCIConfig                 = "application/vnd.oci.image.config.v1+json"
 	MediaTypeDockerSchema2Layer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
 	MediaTypeOCILayer                  = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCIEmpty                  = "application/vnd.oci.empty.v1+json"
 )
 
 // NewRegistry creates a new Docker registry instance
@@ -96,42 +134,127 @@ func NewRegistry(repo *models.Repository, config *models.DockerRepositoryConfig,
 		uploads:   make(map[string]*Upload),
 	}
 
+	if config.SignaturePolicy != nil {
+		verifier, err := NewSignatureVerifier(config.SignaturePolicy)
+		if err != nil {
+			// Fail closed: with an empty trust store no signature verifies
+			logger.WithError(err).Errorf("Invalid signature policy for repository %s", repo.Name)
+			verifier = &SignatureVerifier{roots: x509.NewCertPool()}
+		}
+		r.verifier = verifier
+	}
+
+	if config.Proxy != nil && len(config.Proxy.Upstreams) > 0 {
+		r.proxy = newProxy(config.Proxy)
+	}
+
 	r.setupRoutes()
 	return r
 }
 
 // Start starts the registry server
 func (r *Registry) Start(tlsConfig *tls.Config) error {
-	addr := fmt.Sprintf(":%d", r.config.HTTPSPort)
-	if r.config.HTTPPort > 0 && tlsConfig == nil {
-		addr = fmt.Sprintf(":%d", r.config.HTTPPort)
+	listener, err := r.Listen(tlsConfig)
+	if err != nil {
+		return err
 	}
+	return r.Serve(listener)
+}
+
+// registryTimeouts are the timeouts of registry ports not configured
+// otherwise
+var registryTimeouts = timeouts.Config{
+	Read:     30 * time.Second,
+	Write:    30 * time.Second,
+	Idle:     120 * time.Second,
+	Transfer: timeouts.DefaultTransfer,
+}
+
+// isBlobTransfer reports whether a request uploads or downloads a blob,
+// which may take far longer than the registry's timeouts
+func isBlobTransfer(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, "/blobs/")
+}
+
+// Listen opens the registry's listener and prepares its server, so that a
+// bind failure is reported before serving starts in the background
+func (r *Registry) Listen(tlsConfig *tls.Config) (net.Listener, error) {
+	addr := r.listenAddr(tlsConfig != nil)
 
+	listen := r.listen
+	if listen == nil {
+		listen = net.Listen
+	}
+	listener, err := listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	listener = r.connections.Listener(listener)
+	config := r.timeouts.Or(registryTimeouts)
+	handler := http.Handler(r.router)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
 	r.server = &http.Server{
-		Addr:         addr,
-		Handler:      r.router,
-		TLSConfig:    tlsConfig,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:      addr,
+		Handler:   r.connections.Middleware(config.Middleware(isBlobTransfer)(requestid.Middleware(handler))),
+		TLSConfig: tlsConfig,
 	}
+	config.Apply(r.server)
+	r.connections.Apply(r.server)
+	r.mu.Unlock()
 
 	r.logger.WithFields(logrus.Fields{
 		"repository": r.repo.Name,
 		"address":    addr,
 		"tls":        tlsConfig != nil,
 	}).Info("Starting Docker registry")
+	return listener, nil
+}
+
+// Serve serves the registry on a listener from Listen until it is stopped
+func (r *Registry) Serve(listener net.Listener) error {
+	r.mu.RLock()
+	server := r.server
+	r.mu.RUnlock()
+
+	if server.TLSConfig != nil {
+		return server.ServeTLS(listener, "", "")
+	}
+	return server.Serve(listener)
+}
 
-	if tlsConfig != nil {
-		return r.server.ListenAndServeTLS("", "")
+// listenAddr returns the address the registry listens on with or without TLS
+func (r *Registry) listenAddr(useTLS bool) string {
+	port := r.config.HTTPSPort
+	if r.config.HTTPPort > 0 && !useTLS {
+		port = r.config.HTTPPort
+	}
+	return net.JoinHostPort(r.config.BindAddress, strconv.Itoa(port))
+}
+
+// startBackground starts the registry's background work, such as probing
+// the upstreams of a proxy
+func (r *Registry) startBackground() {
+	if r.proxy != nil {
+		r.proxy.startHealthChecks(r.logger)
 	}
-	return r.server.ListenAndServe()
 }
 
 // Stop stops the registry server
 func (r *Registry) Stop(ctx context.Context) error {
-	if r.server != nil {
-		return r.server.Shutdown(ctx)
+	if r.proxy != nil {
+		r.proxy.stopHealthChecks()
+	}
+
+	r.mu.RLock()
+	server := r.server
+	r.mu.RUnlock()
+
+	if server != nil {
+		return server.Shutdown(ctx)
 	}
 	return nil
 }
@@ -141,32 +264,96 @@ func (r *Registry) GetRouter() *mux.Router {
 	return r.router
 }
 
+// imageNames returns the names of the images with manifests
+func (r *Registry) imageNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.manifests))
+	for name := range r.manifests {
+		names = append(names, name)
+	}
+	return names
+}
+
+// getManifest returns the manifest stored under a tag or digest
+func (r *Registry) getManifest(name, reference string) (*Manifest, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	repoManifests, exists := r.manifests[name]
+	if !exists {
+		return nil, false
+	}
+	manifest, exists := repoManifests[reference]
+	return manifest, exists
+}
+
+// putManifest indexes a manifest under its reference and, for tags, its
+// digest, and its labels for FindImages
+func (r *Registry) putManifest(name, reference string, manifest *Manifest) string {
+	digest := digestOf(manifest.Raw)
+	if manifest.labels == nil {
+		manifest.labels = r.imageLabels(name, manifest)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.manifests[name]; !exists {
+		r.manifests[name] = make(map[string]*Manifest)
+	}
+
+	// Store by reference (tag or digest)
+	r.manifests[name][reference] = manifest
+
+	// Also store by digest if reference is a tag
+	if !strings.HasPrefix(reference, "sha256:") {
+		r.manifests[name][digest] = manifest
+	}
+	return digest
+}
+
+// digestOf returns the sha256 content digest of data
+func digestOf(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
 // setupRoutes configures the Docker Registry V2 API routes
 func (r *Registry) setupRoutes() {
 	r.router = mux.NewRouter()
 
-	// Add logging middleware
+	// Add request ID and logging middleware
+	r.router.Use(requestid.Middleware)
 	r.router.Use(r.loggingMiddleware)
+	r.router.Use(compress.Middleware)
 
 	// Docker Registry V2 API endpoints
-	r.router.HandleFunc("/v2/", r.handleBase).Methods("GET")
-	r.router.HandleFunc("/v2/_catalog", r.handleCatalog).Methods("GET")
-	r.router.HandleFunc("/v2/{name:.*}/tags/list", r.handleTagsList).Methods("GET")
-	r.router.HandleFunc("/v2/{name:.*}/manifests/{reference}", r.handleManifestGet).Methods("GET", "HEAD")
-	r.router.HandleFunc("/v2/{name:.*}/manifests/{reference}", r.handleManifestPut).Methods("PUT")
+	r.router.HandleFunc("/v2/", r.pullAuthorized(r.handleBase)).Methods("GET")
+	r.router.HandleFunc("/v2/_catalog", r.pullAuthorized(r.handleCatalog)).Methods("GET")
+	r.router.HandleFunc("/v2/{name:.*}/tags/list", r.pullAuthorized(r.handleTagsList)).Methods("GET")
+	r.router.HandleFunc("/v2/{name:.*}/manifests/{reference}", r.pullAuthorized(r.handleManifestGet)).Methods("GET", "HEAD")
+	r.router.HandleFunc("/v2/{name:.*}/manifests/{reference}", r.writable(r.handleManifestPut)).Methods("PUT")
 	r.router.HandleFunc("/v2/{name:.*}/manifests/{reference}", r.handleManifestDelete).Methods("DELETE")
-	r.router.HandleFunc("/v2/{name:.*}/blobs/{digest}", r.handleBlobGet).Methods("GET", "HEAD")
+	r.router.HandleFunc("/v2/{name:.*}/referrers/{digest}", r.pullAuthorized(r.handleReferrers)).Methods("GET")
+	r.router.HandleFunc("/v2/{name:.*}/blobs/exists", r.pullAuthorized(r.handleBlobsExist)).Methods("POST")
+	r.router.HandleFunc("/v2/{name:.*}/blobs/{digest}", r.pullAuthorized(r.handleBlobGet)).Methods("GET", "HEAD")
 	r.router.HandleFunc("/v2/{name:.*}/blobs/{digest}", r.handleBlobDelete).Methods("DELETE")
-	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/", r.handleBlobUploadPost).Methods("POST")
-	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.handleBlobUploadPatch).Methods("PATCH")
-	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.handleBlobUploadPut).Methods("PUT")
+	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/", r.writable(r.handleBlobUploadPost)).Methods("POST")
+	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.writable(r.handleBlobUploadPatch)).Methods("PATCH")
+	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.writable(r.handleBlobUploadPut)).Methods("PUT")
 	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.handleBlobUploadGet).Methods("GET")
 	r.router.HandleFunc("/v2/{name:.*}/blobs/uploads/{uuid}", r.handleBlobUploadDelete).Methods("DELETE")
 }
 
-// loggingMiddleware logs HTTP requests
+// loggingMiddleware logs HTTP requests, or the share of them the
+// repository's access log sampling selects
 func (r *Registry) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.sampling.Sample(r.repo.Name) {
+			next.ServeHTTP(w, req)
+			return
+		}
 		start := time.Now()
 		
 		// Create a response writer wrapper to capture status code
@@ -175,10 +362,11 @@ func (r *Registry) loggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapped, req)
 		
 		r.logger.WithFields(logrus.Fields{
-			"method":   req.Method,
-			"path":     req.URL.Path,
-			"status":   wrapped.statusCode,
-			"duration": time.Since(start),
+			"method":     req.Method,
+			"path":       req.URL.Path,
+			"status":     wrapped.statusCode,
+			"duration":   time.Since(start),
+			"request_id": requestid.FromContext(req.Context()),
 		}).Info("Docker registry request")
 	})
 }
@@ -194,9 +382,15 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// ReadFrom lets blobs reach the connection's sendfile support
+func (rw *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(rw.ResponseWriter, r)
+}
+
 // errorResponse represents a Docker registry error response
 type errorResponse struct {
-	Errors []registryError `json:"errors"`
+	Errors    []registryError `json:"errors"`
+	RequestID string          `json:"request_id,omitempty"`
 }
 
 // registryError represents a single error in the response
@@ -206,8 +400,98 @@ type registryError struct {
 	Detail  map[string]interface{} `json:"detail,omitempty"`
 }
 
+// SetMaxUploadSize sets the server-wide upload limit in bytes. The
+// repository's own max_layer_size applies if it is lower.
+func (r *Registry) SetMaxUploadSize(size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxUploadSize = size
+}
+
+// SetDownloadRecorder sets a function called for every manifest pulled,
+// with the image and tag (or digest) as the artifact
+func (r *Registry) SetDownloadRecorder(record func(repository, artifact string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onDownload = record
+}
+
+// SetPushRecorder sets a function called for every manifest pushed by
+// tag, with the image and tag as the artifact. Pushes by digest, such as
+// the images of a multi-platform push, are not reported.
+func (r *Registry) SetPushRecorder(record func(repository, artifact string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onPush = record
+}
+
+// SetMetrics records the registry's traffic with recorder
+func (r *Registry) SetMetrics(recorder *metrics.Recorder) {
+	if recorder == nil {
+		return
+	}
+	r.metrics = recorder
+	r.router.Use(recorder.Middleware(func(*http.Request) string {
+		return r.repo.Name
+	}))
+}
+
+// currentConfig returns the configuration of the registry, which
+// Reconfigure may replace while it runs
+func (r *Registry) currentConfig() *models.DockerRepositoryConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+// uploadLimit returns the effective per-upload limit in bytes, or 0
+func (r *Registry) uploadLimit() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return models.SizeLimit(r.maxUploadSize, r.config.MaxLayerSize)
+}
+
+// limitBody caps the request body at what remains of the upload limit after
+// used bytes. If the declared Content-Length already exceeds it, a 413 is
+// written and false is returned.
+func (r *Registry) limitBody(w http.ResponseWriter, req *http.Request, limit, used int64, errorCode string) bool {
+	if limit == 0 {
+		return true
+	}
+
+	remaining := limit - used
+	if req.ContentLength > remaining {
+		r.writeSizeError(w, limit, errorCode)
+		return false
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, remaining)
+	return true
+}
+
+// writeSizeError reports an upload that exceeded the size limit
+func (r *Registry) writeSizeError(w http.ResponseWriter, limit int64, errorCode string) {
+	r.writeError(w, http.StatusRequestEntityTooLarge, errorCode,
+		fmt.Sprintf("upload exceeds maximum size of %d bytes", limit), nil)
+}
+
+// isTooLarge reports whether err came from reading past a MaxBytesReader limit
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
 // writeError writes an error response
 func (r *Registry) writeError(w http.ResponseWriter, code int, errorCode, message string, detail map[string]interface{}) {
+	writeErrorResponse(w, code, errorCode, message, detail)
+}
+
+// writeErrorResponse writes a registry error response outside of a registry
+func writeErrorResponse(w http.ResponseWriter, code int, errorCode, message string, detail map[string]interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	
@@ -219,6 +503,7 @@ func (r *Registry) writeError(w http.ResponseWriter, code int, errorCode, messag
 				Detail:  detail,
 			},
 		},
+		RequestID: w.Header().Get(requestid.Header),
 	}
 	
 	// Encode response (ignoring error for simplicity)
@@ -42,6 +42,14 @@ func TestDockerRegistryV2API(t *testing.T) {
 
 	// Create registry
 	registry := NewRegistry(repo, config, testStorage, logger)
+	var pulled []string
+	registry.SetDownloadRecorder(func(repository, artifact string) {
+		pulled = append(pulled, repository+"/"+artifact)
+	})
+	var pushed []string
+	registry.SetPushRecorder(func(repository, artifact string) {
+		pushed = append(pushed, repository+"/"+artifact)
+	})
 
 	t.Run("Base Endpoint", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/v2/", nil)
@@ -100,6 +108,23 @@ func TestDockerRegistryV2API(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
 		assert.Equal(t, blobData, w.Body.Bytes())
+
+		// HEAD reports the size, and ranges of the blob can be fetched
+		req = httptest.NewRequest("HEAD", fmt.Sprintf("/v2/test-image/blobs/%s", digest), nil)
+		w = httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprint(len(blobData)), w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Body.Bytes())
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/v2/test-image/blobs/%s", digest), nil)
+		req.Header.Set("Range", "bytes=1-3")
+		w = httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, blobData[1:4], w.Body.Bytes())
 	})
 
 	t.Run("Upload and Retrieve Manifest", func(t *testing.T) {
@@ -149,6 +174,10 @@ func TestDockerRegistryV2API(t *testing.T) {
 		registry.GetRouter().ServeHTTP(w, req)
 		
 		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Both pulls are counted, by tag and by digest
+		assert.Equal(t, []string{"test-docker/test-image:v1.0", "test-docker/test-image@" + digest}, pulled)
+		assert.Equal(t, []string{"test-docker/test-image:v1.0"}, pushed)
 	})
 
 	t.Run("Multi-arch Manifest List", func(t *testing.T) {
@@ -341,6 +370,59 @@ func TestDockerRegistryV2API(t *testing.T) {
 		
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
+
+	t.Run("Request ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v2/nonexistent/manifests/latest", nil)
+		req.Header.Set("X-Request-ID", "push-1234")
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "push-1234", w.Header().Get("X-Request-ID"))
+
+		var response errorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "push-1234", response.RequestID)
+	})
+
+	t.Run("Upload Size Limit", func(t *testing.T) {
+		limited := NewRegistry(repo, &models.DockerRepositoryConfig{MaxLayerSize: 16}, testStorage, logger)
+		limited.SetMaxUploadSize(1024)
+
+		startUpload := func() string {
+			req := httptest.NewRequest("POST", "/v2/limited-image/blobs/uploads/", nil)
+			w := httptest.NewRecorder()
+			limited.GetRouter().ServeHTTP(w, req)
+			require.Equal(t, http.StatusAccepted, w.Code)
+			return w.Header().Get("Docker-Upload-UUID")
+		}
+
+		// Declared length over the remaining allowance is rejected up front
+		uploadUUID := startUpload()
+		req := httptest.NewRequest("PATCH", "/v2/limited-image/blobs/uploads/"+uploadUUID, bytes.NewReader([]byte("First chunk")))
+		w := httptest.NewRecorder()
+		limited.GetRouter().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		req = httptest.NewRequest("PATCH", "/v2/limited-image/blobs/uploads/"+uploadUUID, bytes.NewReader([]byte("Second chunk")))
+		w = httptest.NewRecorder()
+		limited.GetRouter().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+		// The session is discarded
+		req = httptest.NewRequest("GET", "/v2/limited-image/blobs/uploads/"+uploadUUID, nil)
+		w = httptest.NewRecorder()
+		limited.GetRouter().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		// Bodies without a declared length are cut off while streaming
+		uploadUUID = startUpload()
+		req = httptest.NewRequest("PATCH", "/v2/limited-image/blobs/uploads/"+uploadUUID, strings.NewReader(strings.Repeat("x", 32)))
+		req.ContentLength = -1
+		w = httptest.NewRecorder()
+		limited.GetRouter().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
 }
 
 func TestDockerRegistryManager(t *testing.T) {
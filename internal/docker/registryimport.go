@@ -0,0 +1,493 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+// distributionRoot is where registry:2 keeps its content below the root
+// directory of its filesystem storage driver
+const distributionRoot = "docker/registry/v2"
+
+// catalogPageSize is the number of images asked for per catalog page
+const catalogPageSize = 1000
+
+// RegistryImport names an existing Docker distribution registry to copy
+// into a repository: its API at URL, authenticated with Username and
+// Password and trusted through CACertificates and PinnedKeys if given, or
+// its storage, DataDir being the root directory of registry:2's filesystem
+// driver on this server, such as /var/lib/registry. Only images matching
+// one of Images are imported if it is set.
+type RegistryImport struct {
+	URL            string   `json:"url,omitempty"`
+	DataDir        string   `json:"data_dir,omitempty"`
+	Username       string   `json:"username,omitempty"`
+	Password       string   `json:"password,omitempty"`
+	CACertificates string   `json:"ca_certificates,omitempty"`
+	PinnedKeys     []string `json:"pinned_keys,omitempty"`
+	Images         []string `json:"images,omitempty"`
+}
+
+// RegistryImportReport describes a registry import: the tags imported, as
+// image:tag, the number of tags skipped because they already pointed at
+// the same manifest, and the images or tags that could not be imported
+type RegistryImportReport struct {
+	Repository string   `json:"repository"`
+	Source     string   `json:"source"`
+	Images     int      `json:"images"`
+	Imported   []string `json:"imported"`
+	Skipped    int      `json:"skipped"`
+	Problems   []string `json:"problems"`
+}
+
+// upstream returns the registry imported from as an upstream
+func (s *RegistryImport) upstream() models.DockerUpstream {
+	return models.DockerUpstream{
+		URL:            s.URL,
+		Username:       s.Username,
+		Password:       s.Password,
+		CACertificates: s.CACertificates,
+		PinnedKeys:     s.PinnedKeys,
+	}
+}
+
+// ValidateRegistryImport checks the source of a registry import
+func ValidateRegistryImport(source *RegistryImport) error {
+	if (source.URL == "") == (source.DataDir == "") {
+		return errors.New("give either a registry url or a data_dir")
+	}
+	if source.URL != "" {
+		parsed, err := url.Parse(source.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid registry URL %q", source.URL)
+		}
+	}
+	if source.DataDir != "" {
+		if !filepath.IsAbs(source.DataDir) {
+			return fmt.Errorf("data_dir %q must be an absolute path", source.DataDir)
+		}
+		if source.Username != "" || source.Password != "" {
+			return errors.New("credentials are only used with a registry url")
+		}
+		if source.CACertificates != "" || len(source.PinnedKeys) > 0 {
+			return errors.New("ca_certificates and pinned_keys are only used with a registry url")
+		}
+	}
+	if source.Password != "" && source.Username == "" {
+		return errors.New("a password needs a username")
+	}
+	if source.URL != "" {
+		if err := validateUpstreamTLS(source.upstream()); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range source.Images {
+		if pattern == "" {
+			return errors.New("image patterns must not be empty")
+		}
+	}
+	return nil
+}
+
+// registrySource is a registry images are imported from
+type registrySource interface {
+	name() string
+	images() ([]string, error)
+	tags(r *Registry, image string) ([]string, error)
+	// copyTag copies the manifest a tag points at and what it references,
+	// reporting whether the tag was set or moved
+	copyTag(r *Registry, image, tag string) (bool, error)
+}
+
+// RegistryImportJob is a registry import ready to run
+type RegistryImportJob struct {
+	registry *Registry
+	source   registrySource
+	patterns []string
+}
+
+// PrepareRegistryImport checks that a registry can be imported into a
+// repository. Proxy repositories cannot be imported into, and a data
+// directory must hold registry:2 content.
+func (m *Manager) PrepareRegistryImport(repoName string, source *RegistryImport) (*RegistryImportJob, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy != nil {
+		return nil, ErrReadOnly
+	}
+	if err := ValidateRegistryImport(source); err != nil {
+		return nil, err
+	}
+
+	job := &RegistryImportJob{registry: registry, patterns: source.Images}
+	if source.URL != "" {
+		config := source.upstream()
+		u := newUpstream(config, upstreamClient(config, newUpstreamClient(nil)))
+		job.source = &remoteRegistry{upstream: u}
+		return job, nil
+	}
+	dir, err := openRegistryDir(source.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	job.source = dir
+	return job, nil
+}
+
+// Run copies every tag of the matching images of the source registry into
+// the repository, with the manifests and blobs they need, reporting
+// progress as images done out of those found. Manifests and blobs must
+// match their digests. Tags that already point at the manifest they have
+// on the source are left alone, so an interrupted import can be run again.
+func (j *RegistryImportJob) Run(ctx context.Context, progress func(done, total int64)) (*RegistryImportReport, error) {
+	r := j.registry
+	report := &RegistryImportReport{Repository: r.repo.Name, Source: j.source.name(), Imported: []string{}, Problems: []string{}}
+
+	all, err := j.source.images()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	var images []string
+	for _, image := range all {
+		if j.matches(image) {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	report.Images = len(images)
+
+	total := int64(len(images))
+	for i, image := range images {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if progress != nil {
+			progress(int64(i), total)
+		}
+		if err := j.importImage(ctx, image, report); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", image, err))
+		}
+	}
+	if progress != nil {
+		progress(total, total)
+	}
+
+	r.logger.WithField("repository", r.repo.Name).Infof("Imported %d tags of %d images from %s", len(report.Imported), len(images), report.Source)
+	return report, nil
+}
+
+// importImage copies the tags of one image
+func (j *RegistryImportJob) importImage(ctx context.Context, image string, report *RegistryImportReport) error {
+	r := j.registry
+	if name, _, err := ParseImageReference(image); err != nil || name != image || !validImageName(image) {
+		return errors.New("invalid image name")
+	}
+	tags, err := j.source.tags(r, image)
+	if err != nil {
+		return err
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ref := imageReference(image, tag)
+		// Tags become storage paths, so a source's list is not trusted
+		if !validTag(tag) {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: invalid tag %q", image, tag))
+			continue
+		}
+		imported, err := j.source.copyTag(r, image, tag)
+		switch {
+		case err != nil:
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", ref, err))
+		case imported:
+			report.Imported = append(report.Imported, ref)
+			if r.onPush != nil {
+				r.onPush(r.repo.Name, ref)
+			}
+		default:
+			report.Skipped++
+		}
+	}
+	return nil
+}
+
+// matches reports whether an image is to be imported
+func (j *RegistryImportJob) matches(image string) bool {
+	if len(j.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range j.patterns {
+		if glob.Match(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteRegistry imports from a registry's API, as image sync does
+type remoteRegistry struct {
+	upstream *upstream
+}
+
+func (s *remoteRegistry) name() string {
+	return s.upstream.url
+}
+
+func (s *remoteRegistry) target(image string) *proxyTarget {
+	return &proxyTarget{upstreams: []*upstream{s.upstream}, local: image, remote: image}
+}
+
+// images lists the registry's catalog, following its pages
+func (s *remoteRegistry) images() ([]string, error) {
+	var images []string
+	next := fmt.Sprintf("%s/v2/_catalog?n=%d", s.upstream.url, catalogPageSize)
+	for next != "" {
+		resp, err := s.upstream.request(http.MethodGet, next, "registry:catalog:*", nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		err = upstreamStatus(resp)
+		if err == nil {
+			if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				err = fmt.Errorf("invalid catalog: %w", err)
+			}
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, page.Repositories...)
+
+		next = ""
+		if link := nextLink(resp.Header.Get("Link")); link != "" {
+			resolved, err := resp.Request.URL.Parse(link)
+			if err != nil {
+				return nil, fmt.Errorf("invalid catalog link %q", link)
+			}
+			next = resolved.String()
+		}
+	}
+	return images, nil
+}
+
+func (s *remoteRegistry) tags(r *Registry, image string) ([]string, error) {
+	return r.proxyTags(s.target(image))
+}
+
+func (s *remoteRegistry) copyTag(r *Registry, image, tag string) (bool, error) {
+	target := s.target(image)
+	digest, err := remoteDigest(target, tag)
+	if err != nil {
+		return false, err
+	}
+	return r.syncTag(target, TagChange{Image: image, Tag: tag, Digest: digest})
+}
+
+// nextLink returns the target of the rel="next" link of a Link header, as
+// registries paginate their catalog
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, _ := strings.Cut(strings.TrimSpace(link), ";")
+		if strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
+
+// registryDir imports from the storage of a registry:2, laid out as
+// repositories/<image>/_manifests/tags/<tag>/current/link holding the
+// digest of a tag's manifest, and blobs/sha256/<xx>/<hex>/data holding
+// manifests and blobs by digest
+type registryDir struct {
+	root string
+}
+
+// openRegistryDir finds the content of a registry:2 in its root directory,
+// or in the docker/registry/v2 directory itself
+func openRegistryDir(dir string) (*registryDir, error) {
+	for _, root := range []string{filepath.Join(dir, filepath.FromSlash(distributionRoot)), dir} {
+		if info, err := os.Stat(filepath.Join(root, "repositories")); err == nil && info.IsDir() {
+			return &registryDir{root: root}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s does not hold registry content: no %s/repositories directory", dir, distributionRoot)
+}
+
+func (d *registryDir) name() string {
+	return d.root
+}
+
+// images finds the directories holding _manifests below repositories.
+// Directories starting with an underscore belong to the registry.
+func (d *registryDir) images() ([]string, error) {
+	base := filepath.Join(d.root, "repositories")
+	var images []string
+	err := filepath.WalkDir(base, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() || p == base {
+			return nil
+		}
+		if entry.Name() == "_manifests" {
+			image, err := filepath.Rel(base, filepath.Dir(p))
+			if err != nil {
+				return err
+			}
+			images = append(images, filepath.ToSlash(image))
+		}
+		if strings.HasPrefix(entry.Name(), "_") {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return images, err
+}
+
+func (d *registryDir) tags(r *Registry, image string) ([]string, error) {
+	entries, err := os.ReadDir(d.tagsDir(image))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			tags = append(tags, entry.Name())
+		}
+	}
+	return tags, nil
+}
+
+func (d *registryDir) tagsDir(image string) string {
+	return filepath.Join(d.root, "repositories", filepath.FromSlash(image), "_manifests", "tags")
+}
+
+// blobPath returns where the registry keeps the content of a digest
+func (d *registryDir) blobPath(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(d.root, "blobs", "sha256", hex[:2], hex, "data")
+}
+
+func (d *registryDir) copyTag(r *Registry, image, tag string) (bool, error) {
+	link, err := os.ReadFile(filepath.Join(d.tagsDir(image), tag, "current", "link"))
+	if err != nil {
+		return false, fmt.Errorf("failed to read tag: %w", err)
+	}
+	digest := strings.TrimSpace(string(link))
+	if !validDigest(digest) {
+		return false, fmt.Errorf("unsupported digest %q", digest)
+	}
+	if cached, exists := r.getManifest(image, tag); exists && digestOf(cached.Raw) == digest {
+		return false, nil
+	}
+
+	if err := d.copyManifest(r, image, digest); err != nil {
+		return false, err
+	}
+	manifest, _ := r.getManifest(image, digest)
+	r.putManifest(image, tag, manifest)
+	if err := r.storeTag(image, tag, digest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// copyManifest copies a manifest by digest after its config and layers, or
+// for a manifest list every image it lists, so it is only indexed once
+// everything it needs is stored
+func (d *registryDir) copyManifest(r *Registry, image, digest string) error {
+	if _, exists := r.getManifest(image, digest); exists {
+		return nil
+	}
+	data, err := os.ReadFile(d.blobPath(digest))
+	if err != nil {
+		return fmt.Errorf("manifest %s: %w", digest, err)
+	}
+	if len(data) > maxUpstreamManifestSize {
+		return fmt.Errorf("manifest %s is too large", digest)
+	}
+	if digestOf(data) != digest {
+		return fmt.Errorf("manifest %s does not match its digest", digest)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", digest, err)
+	}
+	manifest.Raw = data
+	manifest.MediaType = manifestMediaType(&manifest)
+
+	for _, child := range manifest.Manifests {
+		if !validDigest(child.Digest) {
+			return fmt.Errorf("manifest %s lists unsupported digest %q", digest, child.Digest)
+		}
+		if err := d.copyManifest(r, image, child.Digest); err != nil {
+			return err
+		}
+	}
+	for _, blob := range manifest.blobs() {
+		// Foreign layers are fetched from their URLs by clients
+		if len(blob.URLs) > 0 {
+			continue
+		}
+		if err := d.copyBlob(r, image, blob.Digest); err != nil {
+			return fmt.Errorf("blob %s: %w", blob.Digest, err)
+		}
+	}
+
+	if err := r.storage.Store(image, path.Join(manifestsDir, digest), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to store manifest %s: %w", digest, err)
+	}
+	r.putManifest(image, digest, &manifest)
+	return r.storeTag(image, digest, digest)
+}
+
+// copyBlob copies a blob unless the image already has it. The blob is
+// only kept if it matches its digest.
+func (d *registryDir) copyBlob(r *Registry, image, digest string) error {
+	if !validDigest(digest) {
+		return errors.New("unsupported digest")
+	}
+	blobPath := path.Join(blobsDir, digest)
+	if exists, err := r.storage.Exists(image, blobPath); err == nil && exists {
+		return nil
+	}
+
+	file, err := os.Open(d.blobPath(digest))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err == nil {
+		if limit := r.uploadLimit(); limit > 0 && info.Size() > limit {
+			return fmt.Errorf("exceeds maximum upload size of %d bytes", limit)
+		}
+	}
+	verified := &verifyingReader{reader: file, hash: sha256.New(), digest: digest}
+	return r.storage.Store(image, blobPath, verified)
+}
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestValidateRegistryImport(t *testing.T) {
+	require.NoError(t, ValidateRegistryImport(&RegistryImport{URL: "https://registry.example.com", Username: "admin", Password: "secret"}))
+	require.NoError(t, ValidateRegistryImport(&RegistryImport{DataDir: "/var/lib/registry", Images: []string{"team/*"}}))
+
+	for _, source := range []RegistryImport{
+		{},
+		{URL: "https://registry.example.com", DataDir: "/var/lib/registry"},
+		{URL: "ftp://registry.example.com"},
+		{DataDir: "var/lib/registry"},
+		{DataDir: "/var/lib/registry", Username: "admin"},
+		{URL: "https://registry.example.com", Password: "secret"},
+		{URL: "https://registry.example.com", Images: []string{""}},
+	} {
+		assert.Error(t, ValidateRegistryImport(&source), source)
+	}
+}
+
+func newImportTarget(t *testing.T) (*Manager, *Registry) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	t.Cleanup(func() { manager.StopAll() })
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	registry, _ := manager.GetRegistry("apps")
+	return manager, registry
+}
+
+func TestImportRegistryFromAPI(t *testing.T) {
+	source := NewRegistry(&models.Repository{Name: "old", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	server := httptest.NewServer(source.GetRouter())
+	defer server.Close()
+	digest := pushImage(t, source, "team/api", "1.0", `{"architecture":"amd64","version":"1.0"}`)
+	pushImage(t, source, "team/api", "2.0", `{"architecture":"amd64","version":"2.0"}`)
+	pushImage(t, source, "tools/lint", "latest", `{"architecture":"amd64"}`)
+
+	manager, apps := newImportTarget(t)
+	job, err := manager.PrepareRegistryImport("apps", &RegistryImport{URL: server.URL, Images: []string{"team/*"}})
+	require.NoError(t, err)
+	var done, total int64
+	report, err := job.Run(context.Background(), func(d, t int64) { done, total = d, t })
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Images)
+	assert.Equal(t, []string{"team/api:1.0", "team/api:2.0"}, report.Imported)
+	assert.Empty(t, report.Problems)
+	assert.Equal(t, int64(1), done)
+	assert.Equal(t, int64(1), total)
+
+	server.Close()
+	w := serveRegistry(apps, "GET", "/v2/team/api/manifests/1.0", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, http.StatusNotFound, serveRegistry(apps, "GET", "/v2/tools/lint/manifests/latest", "", "").Code)
+}
+
+// writeDistributionLayout writes an image the way registry:2 stores it,
+// returning the manifest digest
+func writeDistributionLayout(t *testing.T, root, image, tag, config string) string {
+	writeBlob := func(data string) string {
+		digest := digestOf([]byte(data))
+		hex := strings.TrimPrefix(digest, "sha256:")
+		dir := filepath.Join(root, "docker/registry/v2/blobs/sha256", hex[:2], hex)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "data"), []byte(data), 0644))
+		return digest
+	}
+	configDigest := writeBlob(config)
+	manifest := writeBlob(fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[]}`,
+		MediaTypeDockerSchema2Manifest, MediaTypeDockerSchema2Config, len(config), configDigest))
+
+	link := filepath.Join(root, "docker/registry/v2/repositories", image, "_manifests/tags", tag, "current")
+	require.NoError(t, os.MkdirAll(link, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(link, "link"), []byte(manifest), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "docker/registry/v2/repositories", image, "_layers"), 0755))
+	return manifest
+}
+
+func TestImportRegistryFromDataDir(t *testing.T) {
+	root := t.TempDir()
+	digest := writeDistributionLayout(t, root, "library/nginx", "1.25", `{"architecture":"amd64"}`)
+	writeDistributionLayout(t, root, "team/app", "stable", `{"architecture":"arm64"}`)
+	// A blob whose content does not match its digest is not imported
+	broken := writeDistributionLayout(t, root, "team/broken", "latest", `{"architecture":"amd64","broken":true}`)
+	hex := strings.TrimPrefix(broken, "sha256:")
+	manifest, err := os.ReadFile(filepath.Join(root, "docker/registry/v2/blobs/sha256", hex[:2], hex, "data"))
+	require.NoError(t, err)
+	configDigest := strings.Split(strings.Split(string(manifest), `"digest":"`)[1], `"`)[0]
+	configHex := strings.TrimPrefix(configDigest, "sha256:")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "docker/registry/v2/blobs/sha256", configHex[:2], configHex, "data"), []byte("tampered"), 0644))
+
+	manager, apps := newImportTarget(t)
+	_, err = manager.PrepareRegistryImport("apps", &RegistryImport{DataDir: t.TempDir()})
+	assert.Error(t, err, "directory without registry content")
+
+	job, err := manager.PrepareRegistryImport("apps", &RegistryImport{DataDir: root})
+	require.NoError(t, err)
+	report, err := job.Run(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Images)
+	assert.Equal(t, []string{"library/nginx:1.25", "team/app:stable"}, report.Imported)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "team/broken:latest")
+	assert.Equal(t, http.StatusNotFound, serveRegistry(apps, "GET", "/v2/team/broken/manifests/latest", "", "").Code)
+
+	w := serveRegistry(apps, "GET", "/v2/library/nginx/manifests/1.25", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, digest, w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, MediaTypeDockerSchema2Manifest, w.Header().Get("Content-Type"))
+	imported, _ := apps.getManifest("library/nginx", "1.25")
+	assert.Equal(t, http.StatusOK, serveRegistry(apps, "GET", "/v2/library/nginx/blobs/"+imported.Config.Digest, "", "").Code)
+
+	// Tags already imported are skipped when the import runs again
+	report, err = job.Run(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, report.Imported)
+	assert.Equal(t, 2, report.Skipped)
+}
+
+func TestImportRegistryInvalidTags(t *testing.T) {
+	source := NewRegistry(&models.Repository{Name: "old", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	pushImage(t, source, "team/api", "1.0", `{"architecture":"amd64"}`)
+	// The source lists a tag that would escape the image's directory
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/tags/list") {
+			w.Write([]byte(`{"name":"team/api","tags":["1.0","../../../escape"]}`))
+			return
+		}
+		source.GetRouter().ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	manager, _ := newImportTarget(t)
+	job, err := manager.PrepareRegistryImport("apps", &RegistryImport{URL: server.URL})
+	require.NoError(t, err)
+	report, err := job.Run(context.Background(), func(int64, int64) {})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/api:1.0"}, report.Imported)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "invalid tag")
+}
@@ -0,0 +1,307 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+// ReindexReport describes a rebuild of a registry's manifest index from
+// storage: the images indexed with their tags, the images dropped because
+// nothing is stored for them any more, and the stored images left alone
+// because another registry serves them
+type ReindexReport struct {
+	Repository string         `json:"repository"`
+	Images     []*StoredImage `json:"images"`
+	Removed    []string       `json:"removed"`
+	Skipped    []string       `json:"skipped"`
+	Problems   []string       `json:"problems"`
+}
+
+// ReindexRegistry rebuilds the manifest index of a repository's registry
+// from the manifests and tags in storage, recomputing every digest. With
+// images it rebuilds only those; otherwise it indexes every stored image
+// no other registry serves and drops the images no longer stored. As
+// storage is shared by image name, an image served by several registries
+// must be named to be reindexed.
+func (m *Manager) ReindexRegistry(repoName string, images []string) (*ReindexReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	report := &ReindexReport{
+		Repository: repoName,
+		Images:     []*StoredImage{},
+		Removed:    []string{},
+		Skipped:    []string{},
+		Problems:   []string{},
+	}
+
+	rebuildAll := len(images) == 0
+	if rebuildAll {
+		stored, err := storedImages(m.storage)
+		if err != nil {
+			return nil, err
+		}
+		claimed := m.indexedElsewhere(repoName)
+		for _, image := range stored {
+			if claimed[image] {
+				report.Skipped = append(report.Skipped, image)
+				continue
+			}
+			images = append(images, image)
+		}
+	}
+
+	index := make(map[string]map[string]*Manifest, len(images))
+	for _, image := range images {
+		stored, err := ReadImage(m.storage, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %s: %w", image, err)
+		}
+		for _, problem := range stored.Problems {
+			report.Problems = append(report.Problems, image+": "+problem)
+		}
+		if len(stored.Manifests) == 0 {
+			report.Problems = append(report.Problems, image+": no manifests stored")
+			continue
+		}
+		index[image] = registry.imageIndex(stored)
+		report.Images = append(report.Images, stored)
+	}
+
+	registry.mu.Lock()
+	if rebuildAll {
+		for image := range registry.manifests {
+			if _, exists := index[image]; !exists {
+				delete(registry.manifests, image)
+				report.Removed = append(report.Removed, image)
+			}
+		}
+	}
+	for image, manifests := range index {
+		registry.manifests[image] = manifests
+	}
+	registry.mu.Unlock()
+
+	// Record the images so they are loaded when the registry next starts
+	for image := range index {
+		if err := registry.claimImage(image); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: failed to record image: %v", image, err))
+		}
+	}
+	for _, image := range report.Removed {
+		if err := registry.releaseImage(image); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: failed to remove image record: %v", image, err))
+		}
+	}
+
+	sort.Strings(report.Removed)
+	sort.Slice(report.Images, func(i, j int) bool {
+		return report.Images[i].Name < report.Images[j].Name
+	})
+	registry.logger.WithField("repository", repoName).Infof("Reindexed %d images from storage", len(report.Images))
+	return report, nil
+}
+
+// imageIndex builds the manifest index of a stored image, by digest and
+// by tag
+func (r *Registry) imageIndex(stored *StoredImage) map[string]*Manifest {
+	index := make(map[string]*Manifest, len(stored.Manifests)+len(stored.Tags))
+	for digest, manifest := range stored.Manifests {
+		manifest.MediaType = manifestMediaType(manifest)
+		manifest.labels = r.imageLabels(stored.Name, manifest)
+		index[digest] = manifest
+	}
+	for tag, digest := range stored.Tags {
+		index[tag] = stored.Manifests[digest]
+	}
+	return index
+}
+
+// manifestMediaType returns the media type of a stored manifest. Pushes
+// keep the Content-Type they were sent with, which is not stored, so it is
+// taken from the manifest itself or inferred from its content.
+func manifestMediaType(manifest *Manifest) string {
+	switch {
+	case manifest.MediaType != "":
+		return manifest.MediaType
+	case manifest.Manifests != nil:
+		return MediaTypeOCIManifestList
+	case manifest.Config != nil && manifest.Config.MediaType == MediaTypeDockerSchema2Config:
+		return MediaTypeDockerSchema2Manifest
+	default:
+		return MediaTypeOCIManifest
+	}
+}
+
+// indexedElsewhere returns the images the registries of other repositories
+// index, including those of repositories taken offline
+func (m *Manager) indexedElsewhere(repoName string) map[string]bool {
+	m.mu.RLock()
+	registries := make([]*Registry, 0, len(m.registries)+len(m.disabled))
+	for name, registry := range m.registries {
+		if name != repoName {
+			registries = append(registries, registry)
+		}
+	}
+	for name, registry := range m.disabled {
+		if name != repoName {
+			registries = append(registries, registry)
+		}
+	}
+	m.mu.RUnlock()
+
+	claimed := make(map[string]bool)
+	for _, registry := range registries {
+		registry.mu.RLock()
+		for image := range registry.manifests {
+			claimed[image] = true
+		}
+		registry.mu.RUnlock()
+	}
+	return claimed
+}
+
+// storedImages lists the images that have manifests in storage. Hidden
+// top-level directories are internal namespaces and hold no images.
+func storedImages(store storage.Storage) ([]string, error) {
+	files, err := store.List("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	images := []string{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Path, ".") {
+			continue
+		}
+		if image, ok := IsImagePath(file.Path); ok && image != "" && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// imagesNamespace is the hidden storage namespace recording which images
+// each repository's registry holds, as images are stored by name alone.
+// An image's marker is <repository>/<image>/_image; "_image" is not a
+// valid image name component, so nested image names do not collide.
+const (
+	imagesNamespace = ".registry-images"
+	imageMarker     = "_image"
+)
+
+// claimImage records in storage that an image belongs to the registry, so
+// it is loaded when the registry next starts
+func (r *Registry) claimImage(name string) error {
+	return ClaimImage(r.storage, r.repo.Name, name)
+}
+
+// ClaimImage records in storage that an image belongs to a repository, so
+// its registry loads the image when it starts
+func ClaimImage(store storage.Storage, repoName, image string) error {
+	marker := path.Join(repoName, image, imageMarker)
+	if exists, err := store.Exists(imagesNamespace, marker); err != nil || exists {
+		return err
+	}
+	return store.Store(imagesNamespace, marker, strings.NewReader(image))
+}
+
+// ImageClaims returns the images recorded in storage as belonging to each
+// repository, which survive the loss of the database
+func ImageClaims(store storage.Storage) (map[string][]string, error) {
+	files, err := store.List(imagesNamespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string][]string)
+	for _, file := range files {
+		if path.Base(file.Path) != imageMarker {
+			continue
+		}
+		if repoName, image, found := strings.Cut(path.Dir(file.Path), "/"); found {
+			claims[repoName] = append(claims[repoName], image)
+		}
+	}
+	for _, images := range claims {
+		sort.Strings(images)
+	}
+	return claims, nil
+}
+
+// releaseImage removes the record that an image belongs to the registry
+func (r *Registry) releaseImage(name string) error {
+	return r.storage.Delete(imagesNamespace, path.Join(r.repo.Name, name, imageMarker))
+}
+
+// claimedImages lists the images recorded as belonging to a repository
+func claimedImages(store storage.Storage, repoName string) ([]string, error) {
+	files, err := store.List(imagesNamespace, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []string{}
+	for _, file := range files {
+		if path.Base(file.Path) != imageMarker {
+			continue
+		}
+		image := strings.TrimPrefix(path.Dir(file.Path), repoName+"/")
+		if image != path.Dir(file.Path) {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// loadStored seeds the manifest index with the images recorded as
+// belonging to the registry, so tags and the catalog survive a restart.
+// Images pushed before images were recorded are only found by a reindex.
+func (r *Registry) loadStored() {
+	log := r.logger.WithField("repository", r.repo.Name)
+	images, err := claimedImages(r.storage, r.repo.Name)
+	if err != nil {
+		log.WithError(err).Error("Failed to list stored images")
+		return
+	}
+
+	for _, image := range images {
+		stored, err := ReadImage(r.storage, image)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to read stored image %s", image)
+			continue
+		}
+		for _, problem := range stored.Problems {
+			log.Warnf("Stored image %s: %s", image, problem)
+		}
+		if len(stored.Manifests) > 0 {
+			r.manifests[image] = r.imageIndex(stored)
+		}
+	}
+	if len(r.manifests) > 0 {
+		log.Infof("Loaded %d images from storage", len(r.manifests))
+	}
+}
+
+// ForgetImages removes the record of which images belong to a deleted
+// repository, its unfinished uploads, its sync configuration and its
+// quarantined upstream content, so a repository later created with the
+// same name starts empty. The images themselves stay in storage.
+func (m *Manager) ForgetImages(repoName string) error {
+	for _, namespace := range []string{imagesNamespace, uploadsNamespace, syncNamespace, quarantineNamespace} {
+		if err := removeAll(m.storage, namespace, repoName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
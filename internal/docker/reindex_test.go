@@ -0,0 +1,144 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestReindexRegistry(t *testing.T) {
+	store := storage.NewFileStorage(t.TempDir())
+	manager := NewManager(store, nil, logrus.New())
+	for _, name := range []string{"apps", "tools"} {
+		require.NoError(t, manager.StartRegistry(&models.Repository{Name: name, Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	}
+	defer manager.StopAll()
+	apps, _ := manager.GetRegistry("apps")
+	tools, _ := manager.GetRegistry("tools")
+	serve := serveRegistry
+	push := func(registry *Registry, image, tag, config string) string {
+		return pushImage(t, registry, image, tag, config)
+	}
+
+	webDigest := push(apps, "web", "1.0", `{"config":{"Labels":{"team":"web"}}}`)
+	push(apps, "team/api", "2.0", `{"architecture":"arm64"}`)
+	push(tools, "lint", "latest", `{"architecture":"amd64"}`)
+
+	// The index is lost, as after a restart
+	apps.mu.Lock()
+	apps.manifests = map[string]map[string]*Manifest{"gone": {}}
+	apps.mu.Unlock()
+	assert.Equal(t, http.StatusNotFound, serve(apps, "GET", "/v2/web/manifests/1.0", "", "").Code)
+
+	report, err := manager.ReindexRegistry("apps", nil)
+	require.NoError(t, err)
+	require.Len(t, report.Images, 2)
+	assert.Equal(t, "team/api", report.Images[0].Name)
+	assert.Equal(t, map[string]string{"1.0": webDigest}, report.Images[1].Tags)
+	assert.Equal(t, []string{"gone"}, report.Removed)
+	assert.Equal(t, []string{"lint"}, report.Skipped)
+	assert.Empty(t, report.Problems)
+
+	w := serve(apps, "GET", "/v2/web/manifests/1.0", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, webDigest, w.Header().Get("Docker-Content-Digest"))
+	assert.Equal(t, MediaTypeDockerSchema2Manifest, w.Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusOK, serve(apps, "GET", "/v2/team/api/manifests/2.0", "", "").Code)
+	assert.Len(t, manager.FindImages("apps", map[string]string{"team": "web"}), 1)
+
+	// An image served by another registry is only reindexed by name
+	report, err = manager.ReindexRegistry("apps", []string{"lint", "missing"})
+	require.NoError(t, err)
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, []string{"missing: no manifests stored"}, report.Problems)
+	assert.Equal(t, http.StatusOK, serve(apps, "GET", "/v2/lint/manifests/latest", "", "").Code)
+	assert.Equal(t, http.StatusOK, serve(apps, "GET", "/v2/web/manifests/1.0", "", "").Code)
+
+	_, err = manager.ReindexRegistry("nonexistent", nil)
+	assert.Error(t, err)
+}
+
+func TestLoadStoredImages(t *testing.T) {
+	store := storage.NewFileStorage(t.TempDir())
+	start := func(names ...string) *Manager {
+		manager := NewManager(store, nil, logrus.New())
+		for _, name := range names {
+			require.NoError(t, manager.StartRegistry(&models.Repository{Name: name, Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+		}
+		return manager
+	}
+
+	manager := start("apps", "tools")
+	apps, _ := manager.GetRegistry("apps")
+	tools, _ := manager.GetRegistry("tools")
+	webDigest := pushImage(t, apps, "web", "1.0", `{"config":{"Labels":{"team":"web"}}}`)
+	pushImage(t, apps, "web", "1.1", `{"architecture":"arm64"}`)
+	pushImage(t, apps, "team/api", "2.0", `{"architecture":"amd64"}`)
+	pushImage(t, tools, "lint", "latest", `{"architecture":"amd64"}`)
+	manager.StopAll()
+
+	// A restarted registry serves the images pushed to it, and only those
+	manager = start("apps")
+	apps, _ = manager.GetRegistry("apps")
+	var list struct {
+		Repositories []string `json:"repositories"`
+		Tags         []string `json:"tags"`
+	}
+	require.NoError(t, json.Unmarshal(serveRegistry(apps, "GET", "/v2/_catalog", "", "").Body.Bytes(), &list))
+	assert.ElementsMatch(t, []string{"team/api", "web"}, list.Repositories)
+	require.NoError(t, json.Unmarshal(serveRegistry(apps, "GET", "/v2/web/tags/list", "", "").Body.Bytes(), &list))
+	assert.ElementsMatch(t, []string{"1.0", "1.1"}, list.Tags)
+	w := serveRegistry(apps, "GET", "/v2/web/manifests/1.0", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, webDigest, w.Header().Get("Docker-Content-Digest"))
+	assert.Len(t, manager.FindImages("apps", map[string]string{"team": "web"}), 1)
+
+	// A deleted tag stays deleted
+	assert.Equal(t, http.StatusAccepted, serveRegistry(apps, "DELETE", "/v2/web/manifests/1.1", "", "").Code)
+	manager.StopAll()
+	manager = start("apps")
+	apps, _ = manager.GetRegistry("apps")
+	assert.JSONEq(t, `{"name":"web","tags":["1.0"]}`, serveRegistry(apps, "GET", "/v2/web/tags/list", "", "").Body.String())
+
+	// A repository recreated after being deleted starts empty
+	require.NoError(t, manager.StopRegistry("apps"))
+	require.NoError(t, manager.ForgetImages("apps"))
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	apps, _ = manager.GetRegistry("apps")
+	assert.JSONEq(t, `{"repositories":[]}`, serveRegistry(apps, "GET", "/v2/_catalog", "", "").Body.String())
+}
+
+func serveRegistry(registry *Registry, method, url, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	registry.GetRouter().ServeHTTP(w, req)
+	return w
+}
+
+// pushImage pushes an image with a config blob and no layers, returning
+// the manifest digest
+func pushImage(t *testing.T, registry *Registry, image, tag, config string) string {
+	w := serveRegistry(registry, "POST", "/v2/"+image+"/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	w = serveRegistry(registry, "PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(config)), "", config)
+	require.Equal(t, http.StatusCreated, w.Code)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[]}`,
+		MediaTypeDockerSchema2Config, len(config), digestOf([]byte(config)))
+	w = serveRegistry(registry, "PUT", "/v2/"+image+"/manifests/"+tag, MediaTypeDockerSchema2Manifest, manifest)
+	require.Equal(t, http.StatusCreated, w.Code)
+	return w.Header().Get("Docker-Content-Digest")
+}
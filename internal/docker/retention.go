@@ -0,0 +1,109 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TagInfo describes a tag of a Docker repository for cleanup policies
+type TagInfo struct {
+	Image  string
+	Tag    string
+	Digest string
+	// Size is the size of the config and layers of the image, or of the
+	// images of a manifest list
+	Size     int64
+	PushedAt time.Time
+	Labels   map[string]string
+}
+
+// ListTags lists every tag of a repository with its labels and when it was
+// last pushed, ordered by image and tag
+func (m *Manager) ListTags(repoName string) ([]*TagInfo, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+
+	registry.mu.RLock()
+	var tags []*TagInfo
+	for image, repoManifests := range registry.manifests {
+		for reference, manifest := range repoManifests {
+			if strings.HasPrefix(reference, "sha256:") {
+				continue
+			}
+			tags = append(tags, &TagInfo{
+				Image:  image,
+				Tag:    reference,
+				Digest: digestOf(manifest.Raw),
+				Size:   registry.totalSize(image, manifest),
+				Labels: registry.labelsOf(image, manifest),
+			})
+		}
+	}
+	registry.mu.RUnlock()
+
+	for _, tag := range tags {
+		if info, err := registry.storage.Stat(tag.Image, path.Join(tagsDir, tag.Tag)); err == nil {
+			tag.PushedAt = info.ModTime
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Image != tags[j].Image {
+			return tags[i].Image < tags[j].Image
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+// totalSize returns the size of an image, or of the images of a manifest
+// list counting shared blobs once. The caller must hold r.mu.
+func (r *Registry) totalSize(image string, manifest *Manifest) int64 {
+	if !manifest.isList() {
+		return manifest.imageSize()
+	}
+	var size int64
+	counted := make(map[string]bool)
+	for _, child := range manifest.Manifests {
+		childManifest, exists := r.manifests[image][child.Digest]
+		if !exists {
+			continue
+		}
+		for _, desc := range childManifest.blobs() {
+			if !counted[desc.Digest] {
+				counted[desc.Digest] = true
+				size += desc.Size
+			}
+		}
+	}
+	return size
+}
+
+// DeleteTag removes a tag from a repository. The manifest it pointed at
+// stays available by digest.
+func (m *Manager) DeleteTag(repoName, image, tag string) error {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if strings.HasPrefix(tag, "sha256:") {
+		return fmt.Errorf("%s is not a tag", tag)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, exists := registry.manifests[image][tag]; !exists {
+		return ErrManifestNotFound
+	}
+	// A tag that cannot be deleted from storage would come back on restart
+	if err := registry.storage.Delete(image, path.Join(tagsDir, tag)); err != nil {
+		return fmt.Errorf("failed to delete tag %s: %w", tag, err)
+	}
+	delete(registry.manifests[image], tag)
+	return nil
+}
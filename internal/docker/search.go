@@ -0,0 +1,234 @@
+package docker
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// Kinds of content found by FindDigest
+const (
+	DigestKindManifest = "manifest"
+	DigestKindBlob     = "blob"
+)
+
+// DigestMatch is a manifest or blob with a given digest in an image of a
+// Docker repository. Tags are those of a matching manifest; ReferencedBy
+// lists the manifests that use a matching blob as config or layer.
+type DigestMatch struct {
+	Repository   string   `json:"repository"`
+	Image        string   `json:"image"`
+	Kind         string   `json:"kind"`
+	MediaType    string   `json:"media_type"`
+	Size         int64    `json:"size"`
+	Tags         []string `json:"tags,omitempty"`
+	ReferencedBy []string `json:"referenced_by,omitempty"`
+}
+
+// FindDigest finds the manifests and blobs with digest in every Docker
+// repository, ordered by repository and image. Blobs are found through the
+// manifests referencing them, so blobs that were uploaded but never used
+// are not.
+func (m *Manager) FindDigest(digest string) []*DigestMatch {
+	m.mu.RLock()
+	registries := make([]*Registry, 0, len(m.registries))
+	for _, registry := range m.registries {
+		registries = append(registries, registry)
+	}
+	m.mu.RUnlock()
+
+	matches := []*DigestMatch{}
+	for _, registry := range registries {
+		matches = append(matches, registry.findDigest(digest)...)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Repository != matches[j].Repository {
+			return matches[i].Repository < matches[j].Repository
+		}
+		if matches[i].Image != matches[j].Image {
+			return matches[i].Image < matches[j].Image
+		}
+		return matches[i].Kind < matches[j].Kind
+	})
+	return matches
+}
+
+func (r *Registry) findDigest(digest string) []*DigestMatch {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*DigestMatch
+	for image, repoManifests := range r.manifests {
+		var manifestMatch, blobMatch *DigestMatch
+		for reference, manifest := range repoManifests {
+			manifestDigest := digestOf(manifest.Raw)
+			if manifestDigest == digest {
+				if manifestMatch == nil {
+					manifestMatch = &DigestMatch{
+						Repository: r.repo.Name,
+						Image:      image,
+						Kind:       DigestKindManifest,
+						MediaType:  manifest.MediaType,
+						Size:       int64(len(manifest.Raw)),
+					}
+				}
+				if !strings.HasPrefix(reference, "sha256:") {
+					manifestMatch.Tags = append(manifestMatch.Tags, reference)
+				}
+			}
+			// Manifests pushed by tag are also indexed by digest, so each is
+			// visited once under its digest
+			if reference != manifestDigest {
+				continue
+			}
+			for _, desc := range manifest.blobs() {
+				if desc.Digest != digest {
+					continue
+				}
+				if blobMatch == nil {
+					blobMatch = &DigestMatch{
+						Repository: r.repo.Name,
+						Image:      image,
+						Kind:       DigestKindBlob,
+						MediaType:  desc.MediaType,
+						Size:       desc.Size,
+					}
+				}
+				blobMatch.ReferencedBy = append(blobMatch.ReferencedBy, manifestDigest)
+				break
+			}
+		}
+		for _, match := range []*DigestMatch{manifestMatch, blobMatch} {
+			if match != nil {
+				sort.Strings(match.Tags)
+				sort.Strings(match.ReferencedBy)
+				matches = append(matches, match)
+			}
+		}
+	}
+	return matches
+}
+
+// ImageMatch is a tagged image of a Docker repository whose labels match
+// a search. Labels are the manifest's annotations and the labels of its
+// image config; for a manifest list, those of its images and its own
+// annotations.
+type ImageMatch struct {
+	Repository string            `json:"repository"`
+	Image      string            `json:"image"`
+	Digest     string            `json:"digest"`
+	MediaType  string            `json:"media_type"`
+	Tags       []string          `json:"tags"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// FindImages finds the tagged images with every label in filter, in one
+// repository or, if repoName is empty, every Docker repository, ordered
+// by repository and image
+func (m *Manager) FindImages(repoName string, filter map[string]string) []*ImageMatch {
+	m.mu.RLock()
+	registries := make([]*Registry, 0, len(m.registries))
+	for name, registry := range m.registries {
+		if repoName == "" || name == repoName {
+			registries = append(registries, registry)
+		}
+	}
+	m.mu.RUnlock()
+
+	matches := []*ImageMatch{}
+	for _, registry := range registries {
+		matches = append(matches, registry.findImages(filter)...)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Repository != matches[j].Repository {
+			return matches[i].Repository < matches[j].Repository
+		}
+		if matches[i].Image != matches[j].Image {
+			return matches[i].Image < matches[j].Image
+		}
+		return matches[i].Tags[0] < matches[j].Tags[0]
+	})
+	return matches
+}
+
+func (r *Registry) findImages(filter map[string]string) []*ImageMatch {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*ImageMatch
+	for image, repoManifests := range r.manifests {
+		byDigest := make(map[string]*ImageMatch)
+		for reference, manifest := range repoManifests {
+			if strings.HasPrefix(reference, "sha256:") {
+				continue
+			}
+			digest := digestOf(manifest.Raw)
+			if match, exists := byDigest[digest]; exists {
+				match.Tags = append(match.Tags, reference)
+				continue
+			}
+			labels := r.labelsOf(image, manifest)
+			if !models.HasLabels(labels, filter) {
+				continue
+			}
+			match := &ImageMatch{
+				Repository: r.repo.Name,
+				Image:      image,
+				Digest:     digest,
+				MediaType:  manifest.MediaType,
+				Tags:       []string{reference},
+				Labels:     labels,
+			}
+			byDigest[digest] = match
+			matches = append(matches, match)
+		}
+		for _, match := range byDigest {
+			sort.Strings(match.Tags)
+		}
+	}
+	return matches
+}
+
+// labelsOf returns the labels of a manifest, merging in those of the
+// images of a manifest list. The caller must hold r.mu.
+func (r *Registry) labelsOf(image string, manifest *Manifest) map[string]string {
+	labels := make(map[string]string)
+	for _, child := range manifest.Manifests {
+		if childManifest, exists := r.manifests[image][child.Digest]; exists {
+			for name, value := range childManifest.labels {
+				labels[name] = value
+			}
+		}
+	}
+	for name, value := range manifest.labels {
+		labels[name] = value
+	}
+	return labels
+}
+
+// imageLabels reads the annotations of a manifest and, for images, the
+// labels of its config. A config that is not stored, as with proxies that
+// fetch blobs on first pull, contributes no labels.
+func (r *Registry) imageLabels(name string, manifest *Manifest) map[string]string {
+	labels := make(map[string]string)
+	if manifest.Config != nil && (manifest.Config.MediaType == MediaTypeDockerSchema2Config || manifest.Config.MediaType == MediaTypeOCIConfig) {
+		if data, err := r.readBlob(name, manifest.Config.Digest); err == nil {
+			var config struct {
+				Config struct {
+					Labels map[string]string `json:"Labels"`
+				} `json:"config"`
+			}
+			if json.Unmarshal(data, &config) == nil {
+				for label, value := range config.Config.Labels {
+					labels[label] = value
+				}
+			}
+		}
+	}
+	for annotation, value := range manifest.Annotations {
+		labels[annotation] = value
+	}
+	return labels
+}
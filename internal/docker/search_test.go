@@ -0,0 +1,124 @@
+package docker
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestFindDigest(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	registry, _ := manager.GetRegistry("docker")
+
+	serve := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(content string) string {
+		w := serve("POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		w = serve("PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(content)), "", content)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(content))
+	}
+	pushImage := func(tag, config, layer string) string {
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[{"mediaType":%q,"size":%d,"digest":%q}]}`,
+			MediaTypeOCIManifest, MediaTypeOCIConfig, len(config), pushBlob(config), MediaTypeOCILayer, len(layer), pushBlob(layer))
+		w := serve("PUT", "/v2/app/manifests/"+tag, MediaTypeOCIManifest, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+
+	first := pushImage("1.0", `{"architecture":"amd64","os":"linux"}`, "shared layer")
+	second := pushImage("2.0", `{"architecture":"arm64","os":"linux"}`, "shared layer")
+	serve("PUT", "/v2/app/manifests/latest", MediaTypeOCIManifest, serve("GET", "/v2/app/manifests/2.0", "", "").Body.String())
+
+	matches := manager.FindDigest(digestOf([]byte("shared layer")))
+	require.Len(t, matches, 1)
+	assert.Equal(t, DigestKindBlob, matches[0].Kind)
+	assert.Equal(t, "app", matches[0].Image)
+	assert.Equal(t, int64(len("shared layer")), matches[0].Size)
+	assert.ElementsMatch(t, []string{first, second}, matches[0].ReferencedBy)
+
+	matches = manager.FindDigest(second)
+	require.Len(t, matches, 1)
+	assert.Equal(t, DigestKindManifest, matches[0].Kind)
+	assert.Equal(t, []string{"2.0", "latest"}, matches[0].Tags)
+
+	assert.Empty(t, manager.FindDigest(digestOf([]byte("unknown"))))
+}
+
+func TestFindImages(t *testing.T) {
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	registry, _ := manager.GetRegistry("docker")
+
+	serve := func(method, url, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		return w
+	}
+	pushBlob := func(content string) string {
+		w := serve("POST", "/v2/app/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		w = serve("PUT", w.Header().Get("Location")+"?digest="+digestOf([]byte(content)), "", content)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return digestOf([]byte(content))
+	}
+	pushManifest := func(reference, mediaType, manifest string) string {
+		w := serve("PUT", "/v2/app/manifests/"+reference, mediaType, manifest)
+		require.Equal(t, http.StatusCreated, w.Code)
+		return w.Header().Get("Docker-Content-Digest")
+	}
+	image := func(config, annotations string) string {
+		return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"size":%d,"digest":%q},"layers":[],"annotations":%s}`,
+			MediaTypeOCIManifest, MediaTypeOCIConfig, len(config), pushBlob(config), annotations)
+	}
+
+	payments := pushManifest("1.0", MediaTypeOCIManifest, image(`{"architecture":"amd64","os":"linux","config":{"Labels":{"team":"payments"}}}`,
+		`{"org.opencontainers.image.source":"https://github.com/example/app"}`))
+	pushManifest("2.0", MediaTypeOCIManifest, image(`{"architecture":"amd64","os":"linux","config":{"Labels":{"team":"search"}}}`, `{}`))
+
+	// A manifest list has the labels of its images
+	arm := image(`{"architecture":"arm64","os":"linux","config":{"Labels":{"team":"payments","arch":"arm64"}}}`, `{}`)
+	armDigest := pushManifest(digestOf([]byte(arm)), MediaTypeOCIManifest, arm)
+	list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"size":%d,"digest":%q,"platform":{"architecture":"arm64","os":"linux"}}]}`,
+		MediaTypeOCIManifestList, MediaTypeOCIManifest, len(arm), armDigest)
+	listDigest := pushManifest("multi", MediaTypeOCIManifestList, list)
+
+	matches := manager.FindImages("", map[string]string{"team": "payments"})
+	require.Len(t, matches, 2)
+	assert.Equal(t, payments, matches[0].Digest)
+	assert.Equal(t, []string{"1.0"}, matches[0].Tags)
+	assert.Equal(t, "https://github.com/example/app", matches[0].Labels["org.opencontainers.image.source"])
+	assert.Equal(t, listDigest, matches[1].Digest)
+	assert.Equal(t, "arm64", matches[1].Labels["arch"])
+
+	matches = manager.FindImages("docker", map[string]string{"team": "payments", "org.opencontainers.image.source": "https://github.com/example/app"})
+	require.Len(t, matches, 1)
+	assert.Equal(t, payments, matches[0].Digest)
+
+	assert.Empty(t, manager.FindImages("other", map[string]string{"team": "payments"}))
+	assert.Empty(t, manager.FindImages("", map[string]string{"team": "billing"}))
+}
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+// Directories of an image in the storage backend
+const (
+	manifestsDir = "manifests"
+	blobsDir     = "blobs"
+	tagsDir      = "tags"
+)
+
+// StoredImage is the content the storage backend holds for one image:
+// its manifests by digest, the tags pointing at them and the sizes of its
+// blobs. Problems lists what was skipped or found missing while reading.
+type StoredImage struct {
+	Name      string               `json:"name"`
+	Manifests map[string]*Manifest `json:"-"`
+	Tags      map[string]string    `json:"tags"`
+	Blobs     map[string]int64     `json:"-"`
+	Problems  []string             `json:"problems,omitempty"`
+}
+
+// IsImagePath reports whether a path inside a storage repository is a
+// manifest of an image, returning the image's path relative to the
+// repository ("" for the repository itself)
+func IsImagePath(p string) (string, bool) {
+	dir, name := path.Split(p)
+	dir = strings.TrimSuffix(dir, "/")
+	if path.Base(dir) != manifestsDir || !validDigest(name) {
+		return "", false
+	}
+	image := path.Dir(dir)
+	if image == "." {
+		image = ""
+	}
+	return image, true
+}
+
+// ReadImage reads the manifests and tags stored for an image. Manifests
+// that do not match their digest and tags pointing at unknown manifests
+// are skipped; blobs and child manifests that are referenced but missing
+// are reported.
+func ReadImage(store storage.Storage, name string) (*StoredImage, error) {
+	image := &StoredImage{
+		Name:      name,
+		Manifests: make(map[string]*Manifest),
+		Tags:      make(map[string]string),
+		Blobs:     make(map[string]int64),
+	}
+
+	manifests, err := listDir(store, name, manifestsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range manifests {
+		digest := path.Base(file.Path)
+		if !validDigest(digest) {
+			continue
+		}
+		data, err := readAll(store, name, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if digestOf(data) != digest {
+			image.problem("manifest %s does not match its digest", digest)
+			continue
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			image.problem("manifest %s is not valid JSON", digest)
+			continue
+		}
+		manifest.Raw = data
+		image.Manifests[digest] = &manifest
+	}
+
+	blobs, err := listDir(store, name, blobsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range blobs {
+		image.Blobs[path.Base(file.Path)] = file.Size
+	}
+
+	tags, err := listDir(store, name, tagsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range tags {
+		tag := path.Base(file.Path)
+		data, err := readAll(store, name, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		digest := strings.TrimSpace(string(data))
+		if _, exists := image.Manifests[digest]; !exists {
+			image.problem("tag %s points at unknown manifest %s", tag, digest)
+			continue
+		}
+		image.Tags[tag] = digest
+	}
+
+	digests := make([]string, 0, len(image.Manifests))
+	for digest := range image.Manifests {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	for _, digest := range digests {
+		manifest := image.Manifests[digest]
+		for _, desc := range manifest.blobs() {
+			if _, exists := image.Blobs[desc.Digest]; !exists {
+				image.problem("manifest %s references missing blob %s", digest, desc.Digest)
+			}
+		}
+		for _, child := range manifest.Manifests {
+			if _, exists := image.Manifests[child.Digest]; !exists {
+				image.problem("manifest %s references missing manifest %s", digest, child.Digest)
+			}
+		}
+	}
+	return image, nil
+}
+
+// blobs returns the config and layer descriptors of a manifest
+func (m *Manifest) blobs() []Descriptor {
+	var descriptors []Descriptor
+	if m.Config != nil {
+		descriptors = append(descriptors, *m.Config)
+	}
+	return append(descriptors, m.Layers...)
+}
+
+func (i *StoredImage) problem(format string, args ...interface{}) {
+	i.Problems = append(i.Problems, fmt.Sprintf(format, args...))
+}
+
+// storeTag records in storage which manifest a tag points at, and that the
+// image belongs to the registry, so tags can be recovered when the
+// in-memory index is lost
+func (r *Registry) storeTag(name, tag, digest string) error {
+	if err := r.claimImage(name); err != nil {
+		return err
+	}
+	if strings.HasPrefix(tag, "sha256:") {
+		return nil
+	}
+	return r.storage.Store(name, path.Join(tagsDir, tag), strings.NewReader(digest))
+}
+
+// listDir lists the files directly inside one of an image's directories
+func listDir(store storage.Storage, name, dir string) ([]storage.FileInfo, error) {
+	files, err := store.List(name, dir)
+	if err != nil {
+		return nil, err
+	}
+	direct := files[:0]
+	for _, file := range files {
+		if path.Dir(file.Path) == dir {
+			direct = append(direct, file)
+		}
+	}
+	return direct, nil
+}
+
+func readAll(store storage.Storage, name, p string) ([]byte, error) {
+	reader, err := store.Retrieve(name, p)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Tags and image names as the distribution spec defines them. Names and
+// tags taken from upstreams are checked against these before they become
+// storage paths.
+var (
+	tagPattern  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+	namePattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+)
+
+// validTag reports whether s is a valid tag
+func validTag(s string) bool {
+	return tagPattern.MatchString(s)
+}
+
+// validImageName reports whether s is a valid image name
+func validImageName(s string) bool {
+	return len(s) <= 255 && namePattern.MatchString(s)
+}
+
+// validDigest reports whether s is a sha256 digest
+func validDigest(s string) bool {
+	hex := strings.TrimPrefix(s, "sha256:")
+	if len(hex) != 64 || hex == s {
+		return false
+	}
+	for _, c := range hex {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestReadImage(t *testing.T) {
+	store := storage.NewFileStorage(t.TempDir())
+	registry := NewRegistry(&models.Repository{Name: "docker", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, store, logrus.New())
+
+	layer := []byte("layer")
+	layerDigest := digestOf(layer)
+	require.NoError(t, store.Store("library/app", "blobs/"+layerDigest, bytes.NewReader(layer)))
+
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"layers":[{"mediaType":%q,"size":5,"digest":%q}]}`,
+		MediaTypeDockerSchema2Manifest, MediaTypeDockerSchema2Layer, layerDigest)
+	req := httptest.NewRequest("PUT", "/v2/library/app/manifests/v1", strings.NewReader(manifest))
+	req.Header.Set("Content-Type", MediaTypeDockerSchema2Manifest)
+	w := httptest.NewRecorder()
+	registry.GetRouter().ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	digest := w.Header().Get("Docker-Content-Digest")
+
+	t.Run("Tags and manifests", func(t *testing.T) {
+		image, err := ReadImage(store, "library/app")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"v1": digest}, image.Tags)
+		require.Contains(t, image.Manifests, digest)
+		assert.Equal(t, []byte(manifest), image.Manifests[digest].Raw)
+		assert.Equal(t, int64(5), image.Blobs[layerDigest])
+		assert.Empty(t, image.Problems)
+	})
+
+	t.Run("Problems", func(t *testing.T) {
+		require.NoError(t, store.Delete("library/app", "blobs/"+layerDigest))
+		require.NoError(t, store.Store("library/app", "tags/broken", strings.NewReader(digestOf([]byte("none")))))
+		tampered := digestOf([]byte("tampered"))
+		require.NoError(t, store.Store("library/app", "manifests/"+tampered, strings.NewReader("{}")))
+
+		image, err := ReadImage(store, "library/app")
+		require.NoError(t, err)
+		assert.NotContains(t, image.Tags, "broken")
+		assert.NotContains(t, image.Manifests, tampered)
+		assert.Len(t, image.Problems, 3)
+	})
+
+	t.Run("Tag deletion", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/v2/library/app/manifests/v1", nil)
+		w := httptest.NewRecorder()
+		registry.GetRouter().ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		exists, err := store.Exists("library/app", "tags/v1")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		exists, err = store.Exists("library/app", "manifests/"+digest)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
+
+func TestIsImagePath(t *testing.T) {
+	digest := digestOf([]byte("x"))
+	image, ok := IsImagePath("app/manifests/" + digest)
+	assert.True(t, ok)
+	assert.Equal(t, "app", image)
+
+	image, ok = IsImagePath("manifests/" + digest)
+	assert.True(t, ok)
+	assert.Equal(t, "", image)
+
+	_, ok = IsImagePath("deploy/manifests/service.yaml")
+	assert.False(t, ok)
+	_, ok = IsImagePath("app/blobs/" + digest)
+	assert.False(t, ok)
+}
+
+func TestValidNames(t *testing.T) {
+	for _, tag := range []string{"latest", "1.0", "v1.2.3-rc.1", "_build", "A_b"} {
+		assert.True(t, validTag(tag), tag)
+	}
+	for _, tag := range []string{"", "..", "../x", ".hidden", "-x", "a/b", "a b", strings.Repeat("a", 129)} {
+		assert.False(t, validTag(tag), tag)
+	}
+	for _, name := range []string{"app", "library/app", "my-org/my.app", "a__b/c_d"} {
+		assert.True(t, validImageName(name), name)
+	}
+	for _, name := range []string{"", "../app", "app/..", "App", "/app", "app/", "a//b", "a/./b"} {
+		assert.False(t, validImageName(name), name)
+	}
+}
@@ -0,0 +1,321 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+const (
+	// syncNamespace holds the sync configuration of each repository
+	syncNamespace = ".registry-sync"
+	syncFile      = "sync.json"
+	// dockerHubRegistry is where images named docker.io/... are fetched
+	dockerHubRegistry = "https://registry-1.docker.io"
+)
+
+// SyncConfig lists the images a Docker repository copies from other
+// registries, like skopeo sync
+type SyncConfig struct {
+	Images []SyncImage `json:"images"`
+}
+
+// SyncImage copies the tags of an image on a registry that match one of
+// Tags, or every tag without patterns, into the repository as Destination.
+// Source names the registry host and the image, as in
+// docker.io/library/nginx; Destination defaults to the image without the
+// host. The registry is reached at https://<host>, Docker Hub's at
+// registry-1.docker.io, unless URL says otherwise. Username and Password
+// authenticate to it, and CACertificates and PinnedKeys are trusted as a
+// proxy upstream's are.
+type SyncImage struct {
+	Source         string   `json:"source"`
+	Destination    string   `json:"destination,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	URL            string   `json:"url,omitempty"`
+	Username       string   `json:"username,omitempty"`
+	Password       string   `json:"password,omitempty"`
+	CACertificates string   `json:"ca_certificates,omitempty"`
+	PinnedKeys     []string `json:"pinned_keys,omitempty"`
+}
+
+// SyncReport describes a sync of a repository: the tags copied or moved,
+// as image:tag, and the tags or images that could not be synced
+type SyncReport struct {
+	Repository string   `json:"repository"`
+	Synced     []string `json:"synced"`
+	Problems   []string `json:"problems"`
+}
+
+// ValidateSyncConfig checks the images of a sync configuration
+func ValidateSyncConfig(config *SyncConfig) error {
+	for _, image := range config.Images {
+		if _, _, err := image.source(); err != nil {
+			return err
+		}
+		if image.URL != "" {
+			parsed, err := url.Parse(image.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("invalid registry URL %q", image.URL)
+			}
+		}
+		if image.Destination != "" {
+			if name, _, err := ParseImageReference(image.Destination); err != nil || name != image.Destination || strings.HasPrefix(image.Destination, ".") {
+				return fmt.Errorf("invalid destination %q", image.Destination)
+			}
+		}
+		for _, pattern := range image.Tags {
+			if pattern == "" {
+				return errors.New("tag patterns must not be empty")
+			}
+		}
+		if image.Password != "" && image.Username == "" {
+			return fmt.Errorf("source %s has a password but no username", image.Source)
+		}
+		if err := validateUpstreamTLS(image.upstream()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// source splits the source of an image into the registry host and the
+// image's name on it
+func (s *SyncImage) source() (string, string, error) {
+	host, image, found := strings.Cut(s.Source, "/")
+	if !found || host == "" || strings.ContainsAny(host, "?#@") {
+		return "", "", fmt.Errorf("invalid source %q: it must name a registry and an image, as in docker.io/library/nginx", s.Source)
+	}
+	if name, _, err := ParseImageReference(image); err != nil || name != image {
+		return "", "", fmt.Errorf("invalid source %q: tags are chosen with tag patterns", s.Source)
+	}
+	return host, image, nil
+}
+
+// target resolves an image to sync to its registry and local name
+func (s *SyncImage) target(client *http.Client) *proxyTarget {
+	_, image, _ := s.source()
+	local := s.Destination
+	if local == "" {
+		local = image
+	}
+	config := s.upstream()
+	u := newUpstream(config, upstreamClient(config, client))
+	return &proxyTarget{upstreams: []*upstream{u}, local: local, remote: image}
+}
+
+// upstream returns the registry an image is synced from as an upstream
+func (s *SyncImage) upstream() models.DockerUpstream {
+	host, _, _ := s.source()
+	registryURL := s.URL
+	if registryURL == "" {
+		registryURL = "https://" + host
+		if host == "docker.io" {
+			registryURL = dockerHubRegistry
+		}
+	}
+	return models.DockerUpstream{
+		Namespace:      host,
+		URL:            registryURL,
+		Username:       s.Username,
+		Password:       s.Password,
+		CACertificates: s.CACertificates,
+		PinnedKeys:     s.PinnedKeys,
+	}
+}
+
+// matches reports whether a tag is to be synced
+func (s *SyncImage) matches(tag string) bool {
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, pattern := range s.Tags {
+		if glob.Match(pattern, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the configuration with passwords replaced by
+// models.RedactedSecret
+func (c *SyncConfig) Redacted() *SyncConfig {
+	redacted := &SyncConfig{Images: make([]SyncImage, len(c.Images))}
+	copy(redacted.Images, c.Images)
+	for i := range redacted.Images {
+		if redacted.Images[i].Password != "" {
+			redacted.Images[i].Password = models.RedactedSecret
+		}
+	}
+	return redacted
+}
+
+// RestoreSecrets puts back the passwords of current into images of c that
+// were returned redacted
+func (c *SyncConfig) RestoreSecrets(current *SyncConfig) {
+	for i := range c.Images {
+		image := &c.Images[i]
+		if image.Password != models.RedactedSecret {
+			continue
+		}
+		for _, existing := range current.Images {
+			if existing.Source == image.Source && existing.URL == image.URL && existing.Username == image.Username {
+				image.Password = existing.Password
+			}
+		}
+	}
+}
+
+// SyncConfig returns the sync configuration of a repository, empty if it
+// has none
+func (m *Manager) SyncConfig(repoName string) (*SyncConfig, error) {
+	data, err := readAll(m.storage, syncNamespace, path.Join(repoName, syncFile))
+	if errors.Is(err, storage.ErrNotFound) {
+		return &SyncConfig{Images: []SyncImage{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config SyncConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid sync configuration: %w", err)
+	}
+	if config.Images == nil {
+		config.Images = []SyncImage{}
+	}
+	return &config, nil
+}
+
+// SetSyncConfig replaces the sync configuration of a repository
+func (m *Manager) SetSyncConfig(repoName string, config *SyncConfig) error {
+	if err := ValidateSyncConfig(config); err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return m.storage.Store(syncNamespace, path.Join(repoName, syncFile), bytes.NewReader(data))
+}
+
+// SyncImages copies the tags its sync configuration lists from other
+// registries into a repository, with their manifests and blobs. Tags that
+// already point at the digest they have upstream are left alone; tags that
+// moved upstream are moved. Nothing is deleted.
+func (m *Manager) SyncImages(ctx context.Context, repoName string) (*SyncReport, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy != nil {
+		return nil, ErrReadOnly
+	}
+	config, err := m.SyncConfig(repoName)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Images) == 0 {
+		return nil, errors.New("the repository has no images to sync")
+	}
+
+	report := &SyncReport{Repository: repoName, Synced: []string{}, Problems: []string{}}
+	client := newUpstreamClient(nil)
+	for i := range config.Images {
+		if err := registry.syncImage(ctx, &config.Images[i], client, report); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", config.Images[i].Source, err))
+		}
+	}
+
+	registry.logger.WithField("repository", repoName).Infof("Synced %d tags from %d images", len(report.Synced), len(config.Images))
+	return report, nil
+}
+
+// syncImage copies the matching tags of one image
+func (r *Registry) syncImage(ctx context.Context, image *SyncImage, client *http.Client, report *SyncReport) error {
+	target := image.target(client)
+	tags, err := r.proxyTags(target)
+	if err != nil {
+		return err
+	}
+	sort.Strings(tags)
+
+	matched := 0
+	for _, tag := range tags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !image.matches(tag) {
+			continue
+		}
+		// Tags become storage paths, so a registry's list is not trusted
+		if !validTag(tag) {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: invalid tag %q", target.local, tag))
+			continue
+		}
+		matched++
+		ref := imageReference(target.local, tag)
+		digest, err := remoteDigest(target, tag)
+		if err == nil {
+			var synced bool
+			if synced, err = r.syncTag(target, TagChange{Image: target.local, Tag: tag, Digest: digest}); synced {
+				report.Synced = append(report.Synced, ref)
+				if r.onPush != nil {
+					r.onPush(r.repo.Name, ref)
+				}
+			}
+		}
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", ref, err))
+		}
+	}
+	if matched == 0 {
+		return errors.New("no tags to sync")
+	}
+	return nil
+}
+
+// remoteDigest looks up the digest a tag points at upstream, with a HEAD
+// request if the registry answers it with the digest
+func remoteDigest(target *proxyTarget, tag string) (string, error) {
+	header := http.Header{"Accept": {upstreamAccept}}
+	resp, err := target.do(http.MethodHead, "manifests/"+tag, header)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if err := upstreamStatus(resp); err != nil {
+		return "", err
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); validDigest(digest) {
+		return digest, nil
+	}
+
+	resp, err = target.get("manifests/"+tag, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := upstreamStatus(resp); err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUpstreamManifestSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if len(body) > maxUpstreamManifestSize {
+		return "", errors.New("manifest is too large")
+	}
+	return digestOf(body), nil
+}
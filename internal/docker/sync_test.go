@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestValidateSyncConfig(t *testing.T) {
+	valid := &SyncConfig{Images: []SyncImage{
+		{Source: "docker.io/library/nginx", Tags: []string{"1.*"}},
+		{Source: "ghcr.io/example/app", Destination: "mirrors/app", URL: "https://ghcr.io", Username: "bot", Password: "secret"},
+	}}
+	require.NoError(t, ValidateSyncConfig(valid))
+
+	for _, image := range []SyncImage{
+		{Source: "nginx"},
+		{Source: "docker.io/library/nginx:1.25"},
+		{Source: "docker.io/library/nginx", Destination: "nginx:latest"},
+		{Source: "docker.io/library/nginx", Destination: ".registry-images/nginx"},
+		{Source: "docker.io/library/nginx", URL: "ftp://mirror"},
+		{Source: "docker.io/library/nginx", Tags: []string{""}},
+		{Source: "docker.io/library/nginx", Password: "secret"},
+	} {
+		assert.Error(t, ValidateSyncConfig(&SyncConfig{Images: []SyncImage{image}}), image)
+	}
+
+	// Passwords read back redacted are kept on update
+	redacted := valid.Redacted()
+	assert.Equal(t, models.RedactedSecret, redacted.Images[1].Password)
+	assert.Equal(t, "secret", valid.Images[1].Password)
+	redacted.RestoreSecrets(valid)
+	assert.Equal(t, valid, redacted)
+}
+
+func TestSyncImages(t *testing.T) {
+	upstream, server, _ := newTestUpstream(t)
+	oldDigest := pushImage(t, upstream, "library/nginx", "1.24", `{"architecture":"amd64","version":"1.24"}`)
+	pushImage(t, upstream, "library/nginx", "1.25", `{"architecture":"amd64","version":"1.25"}`)
+	pushImage(t, upstream, "library/nginx", "latest", `{"architecture":"amd64","version":"1.25"}`)
+
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirrors", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	mirrors, _ := manager.GetRegistry("mirrors")
+	var pushed []string
+	mirrors.onPush = func(repository, artifact string) { pushed = append(pushed, artifact) }
+
+	_, err := manager.SyncImages(context.Background(), "mirrors")
+	assert.Error(t, err, "sync without images")
+
+	require.NoError(t, manager.SetSyncConfig("mirrors", &SyncConfig{Images: []SyncImage{
+		{Source: "docker.io/library/nginx", Destination: "nginx", URL: server.URL, Tags: []string{"1.*"}},
+		{Source: "docker.io/library/missing", URL: server.URL},
+	}}))
+	report, err := manager.SyncImages(context.Background(), "mirrors")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nginx:1.24", "nginx:1.25"}, report.Synced)
+	assert.Equal(t, report.Synced, pushed)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "docker.io/library/missing")
+
+	// Synced images are served with their config, without the upstream
+	server.Close()
+	w := serveRegistry(mirrors, "GET", "/v2/nginx/manifests/1.24", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, oldDigest, w.Header().Get("Docker-Content-Digest"))
+	manifest, _ := mirrors.getManifest("nginx", "1.24")
+	w = serveRegistry(mirrors, "GET", "/v2/nginx/blobs/"+manifest.Config.Digest, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusNotFound, serveRegistry(mirrors, "GET", "/v2/nginx/manifests/latest", "", "").Code)
+}
+
+func TestSyncMovesTags(t *testing.T) {
+	upstream, server, _ := newTestUpstream(t)
+	pushImage(t, upstream, "team/app", "stable", `{"build":1}`)
+
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirrors", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	mirrors, _ := manager.GetRegistry("mirrors")
+	require.NoError(t, manager.SetSyncConfig("mirrors", &SyncConfig{Images: []SyncImage{{Source: "registry.example.com/team/app", URL: server.URL}}}))
+
+	report, err := manager.SyncImages(context.Background(), "mirrors")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/app:stable"}, report.Synced)
+
+	// Unchanged tags are not synced again; moved ones follow the upstream
+	report, err = manager.SyncImages(context.Background(), "mirrors")
+	require.NoError(t, err)
+	assert.Empty(t, report.Synced)
+
+	moved := pushImage(t, upstream, "team/app", "stable", `{"build":2}`)
+	report, err = manager.SyncImages(context.Background(), "mirrors")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/app:stable"}, report.Synced)
+	manifest, _ := mirrors.getManifest("team/app", "stable")
+	assert.Equal(t, moved, digestOf(manifest.Raw))
+
+	// Deleting the repository forgets its configuration
+	require.NoError(t, manager.ForgetImages("mirrors"))
+	config, err := manager.SyncConfig("mirrors")
+	require.NoError(t, err)
+	assert.Empty(t, config.Images)
+}
+
+func TestSyncInvalidTags(t *testing.T) {
+	upstream := NewRegistry(&models.Repository{Name: "hub", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}, storage.NewFileStorage(t.TempDir()), logrus.New())
+	pushImage(t, upstream, "team/app", "1.0", `{"build":1}`)
+	// The registry lists a tag that would escape the image's directory
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/tags/list") {
+			w.Write([]byte(`{"name":"team/app","tags":["1.0","../../escape"]}`))
+			return
+		}
+		upstream.GetRouter().ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirrors", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	require.NoError(t, manager.SetSyncConfig("mirrors", &SyncConfig{Images: []SyncImage{{Source: "registry.example.com/team/app", URL: server.URL}}}))
+
+	report, err := manager.SyncImages(context.Background(), "mirrors")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team/app:1.0"}, report.Synced)
+	require.Len(t, report.Problems, 1)
+	assert.Contains(t, report.Problems[0], "invalid tag")
+}
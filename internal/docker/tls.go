@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// SetCertificateDir sets the directory holding the named certificates
+// registries may use instead of the main server's
+func (m *Manager) SetCertificateDir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.certDir = dir
+}
+
+// ValidateTLS checks that the certificate of a registry's TLS settings can
+// be loaded
+func (m *Manager) ValidateTLS(config *models.DockerRepositoryConfig) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, err := m.registryTLSConfig(config)
+	return err
+}
+
+// registryTLSConfig returns the TLS configuration of a registry's HTTPS
+// listener: the main server's, or one with the registry's own certificate.
+// The caller must hold m.mu.
+func (m *Manager) registryTLSConfig(config *models.DockerRepositoryConfig) (*tls.Config, error) {
+	if config.TLS == nil {
+		return m.tlsConfig, nil
+	}
+	if config.HTTPSPort == 0 {
+		return nil, errors.New("tls requires an https_port")
+	}
+
+	certFile, keyFile := config.TLS.CertFile, config.TLS.KeyFile
+	if name := config.TLS.Certificate; name != "" {
+		if certFile != "" || keyFile != "" {
+			return nil, errors.New("tls takes a certificate name or cert_file and key_file, not both")
+		}
+		if m.certDir == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("unknown certificate %q", name)
+		}
+		certFile = filepath.Join(m.certDir, name+".crt")
+		keyFile = filepath.Join(m.certDir, name+".key")
+	} else if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls requires a certificate name or both cert_file and key_file")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if m.tlsConfig != nil {
+		tlsConfig = m.tlsConfig.Clone()
+		tlsConfig.GetCertificate = nil
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return tlsConfig, nil
+}
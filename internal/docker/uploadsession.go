@@ -0,0 +1,178 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+// uploadsNamespace is the hidden storage namespace holding the upload
+// sessions of each repository's registry, so a chunked upload interrupted
+// by a restart resumes where it stopped. A session is
+// <repository>/<uuid>/session.json with its chunks under chunks/, named by
+// their offset.
+const (
+	uploadsNamespace = ".registry-uploads"
+	sessionFile      = "session.json"
+	chunksDir        = "chunks"
+)
+
+// uploadSessionTTL is how long an upload session is kept without data
+// being added to it
+const uploadSessionTTL = 24 * time.Hour
+
+// sessionPath returns the path of a file of an upload session
+func (r *Registry) sessionPath(uploadUUID string, elem ...string) string {
+	return path.Join(append([]string{r.repo.Name, uploadUUID}, elem...)...)
+}
+
+// saveUpload records an upload session in storage along with the chunk
+// appended to it at offset, if any. The chunk is stored first, so a
+// session never claims more data than storage holds.
+func (r *Registry) saveUpload(upload *Upload, offset int64, chunk []byte) error {
+	if len(chunk) > 0 {
+		name := fmt.Sprintf("%020d", offset)
+		if err := r.storage.Store(uploadsNamespace, r.sessionPath(upload.UUID, chunksDir, name), bytes.NewReader(chunk)); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return r.storage.Store(uploadsNamespace, r.sessionPath(upload.UUID, sessionFile), bytes.NewReader(data))
+}
+
+// persistUpload saves an upload session, logging rather than failing the
+// request if it cannot: the upload still completes unless depot restarts
+func (r *Registry) persistUpload(upload *Upload, offset int64, chunk []byte) {
+	if err := r.saveUpload(upload, offset, chunk); err != nil {
+		r.logger.WithError(err).WithField("repository", r.repo.Name).Warnf("Failed to persist upload %s", upload.UUID)
+	}
+}
+
+// lookupUpload returns an upload session, restoring it from storage if it
+// was started before depot last restarted. r.mu must be held for writing.
+// It is released while the session is read from storage, so restoring a
+// large upload does not hold up the registry's other requests.
+func (r *Registry) lookupUpload(uploadUUID string) (*Upload, bool) {
+	if upload, ok := r.uploads[uploadUUID]; ok {
+		return upload, true
+	}
+	if _, err := uuid.Parse(uploadUUID); err != nil {
+		return nil, false
+	}
+
+	r.mu.Unlock()
+	upload, err := r.restoreUpload(uploadUUID)
+	if err != nil {
+		r.logger.WithError(err).WithField("repository", r.repo.Name).Warnf("Failed to restore upload %s", uploadUUID)
+		r.removeUpload(uploadUUID)
+	}
+	r.mu.Lock()
+
+	// Another request for the session may have restored it meanwhile
+	if restored, ok := r.uploads[uploadUUID]; ok {
+		return restored, true
+	}
+	if upload == nil {
+		return nil, false
+	}
+	r.uploads[uploadUUID] = upload
+	return upload, true
+}
+
+// restoreUpload reads an upload session and its data from storage,
+// returning nil if there is none or it has expired
+func (r *Registry) restoreUpload(uploadUUID string) (*Upload, error) {
+	info, err := r.storage.Stat(uploadsNamespace, r.sessionPath(uploadUUID, sessionFile))
+	if err != nil {
+		return nil, nil
+	}
+	if time.Since(info.ModTime) > uploadSessionTTL {
+		r.removeUpload(uploadUUID)
+		return nil, nil
+	}
+	data, err := readAll(r.storage, uploadsNamespace, info.Path)
+	if err != nil {
+		return nil, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("invalid session: %w", err)
+	}
+	if upload.UUID != uploadUUID {
+		return nil, fmt.Errorf("session is for upload %s", upload.UUID)
+	}
+
+	chunks, err := r.storage.List(uploadsNamespace, r.sessionPath(uploadUUID, chunksDir))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Path < chunks[j].Path })
+
+	// Every chunk stored was accepted, while chunks sent in parallel may
+	// have saved the session in any order, so the data is put together from
+	// the chunks rather than trusting the size in the session
+	upload.Data = make([]byte, 0, upload.Size)
+	upload.Size = 0
+	for _, chunk := range chunks {
+		offset, err := strconv.ParseInt(path.Base(chunk.Path), 10, 64)
+		if err != nil {
+			continue
+		}
+		data, err := readAll(r.storage, uploadsNamespace, chunk.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := upload.addChunk(offset, data, 0); err != nil {
+			return nil, fmt.Errorf("chunk at offset %d overlaps another", offset)
+		}
+	}
+	return &upload, nil
+}
+
+// removeUpload deletes an upload session from storage
+func (r *Registry) removeUpload(uploadUUID string) {
+	if err := removeAll(r.storage, uploadsNamespace, r.sessionPath(uploadUUID)); err != nil {
+		r.logger.WithError(err).WithField("repository", r.repo.Name).Warnf("Failed to remove upload %s", uploadUUID)
+	}
+}
+
+// purgeUploads deletes the upload sessions of the registry that expired
+// while it was stopped
+func (r *Registry) purgeUploads() {
+	files, err := r.storage.List(uploadsNamespace, r.repo.Name)
+	if err != nil {
+		r.logger.WithError(err).WithField("repository", r.repo.Name).Warn("Failed to list upload sessions")
+		return
+	}
+	for _, file := range files {
+		if path.Base(file.Path) != sessionFile || time.Since(file.ModTime) <= uploadSessionTTL {
+			continue
+		}
+		r.removeUpload(path.Base(path.Dir(file.Path)))
+	}
+}
+
+// removeAll deletes every file stored below prefix
+func removeAll(store storage.Storage, namespace, prefix string) error {
+	files, err := store.List(namespace, prefix)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := store.Delete(namespace, file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
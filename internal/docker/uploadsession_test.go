@@ -0,0 +1,189 @@
+package docker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/metrics"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestUploadResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewFileStorage(dir)
+	repo := &models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}
+
+	// start returns the registry of a freshly started depot
+	start := func() (*Manager, *Registry) {
+		manager := NewManager(store, nil, logrus.New())
+		require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+		registry, _ := manager.GetRegistry("apps")
+		return manager, registry
+	}
+
+	manager, apps := start()
+	w := serveRegistry(apps, "POST", "/v2/web/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	location := w.Header().Get("Location")
+	w = serveRegistry(apps, "PATCH", location, "", "first chunk, ")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	manager.StopAll()
+
+	manager, apps = start()
+	w = serveRegistry(apps, "GET", location, "", "")
+	require.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "bytes=0-12", w.Header().Get("Range"))
+
+	w = serveRegistry(apps, "PATCH", location, "", "second chunk")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	manager.StopAll()
+
+	// The rest of the blob arrives with the final PUT after another restart
+	manager, apps = start()
+	defer manager.StopAll()
+	blob := []byte("first chunk, second chunk and the end")
+	w = serveRegistry(apps, "PUT", location+"?digest="+digestOf(blob), "", " and the end")
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	w = serveRegistry(apps, "GET", "/v2/web/blobs/"+digestOf(blob), "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(blob), w.Body.String())
+
+	// Completed sessions are removed
+	files, err := store.List(uploadsNamespace, "apps")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	assert.Equal(t, http.StatusNotFound, serveRegistry(apps, "GET", location, "", "").Code)
+}
+
+// slowStorage holds up reading the chunks of upload sessions until release
+// is closed
+type slowStorage struct {
+	storage.Storage
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) Retrieve(name, p string) (io.ReadCloser, error) {
+	if name == uploadsNamespace && strings.Contains(p, "/"+chunksDir+"/") {
+		close(s.reading)
+		<-s.release
+	}
+	return s.Storage.Retrieve(name, p)
+}
+
+func TestUploadRestoredWithoutLock(t *testing.T) {
+	dir := t.TempDir()
+	repo := &models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}
+	manager := NewManager(storage.NewFileStorage(dir), nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+	apps, _ := manager.GetRegistry("apps")
+	location := serveRegistry(apps, "POST", "/v2/web/blobs/uploads/", "", "").Header().Get("Location")
+	require.Equal(t, http.StatusAccepted, serveRegistry(apps, "PATCH", location, "", "first chunk").Code)
+	manager.StopAll()
+
+	slow := &slowStorage{Storage: storage.NewFileStorage(dir), reading: make(chan struct{}), release: make(chan struct{})}
+	manager = NewManager(slow, nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	apps, _ = manager.GetRegistry("apps")
+
+	restored := make(chan int)
+	go func() { restored <- serveRegistry(apps, "GET", location, "", "").Code }()
+	<-slow.reading
+
+	// Other requests are served while the session is read
+	assert.Equal(t, http.StatusAccepted, serveRegistry(apps, "POST", "/v2/web/blobs/uploads/", "", "").Code)
+	close(slow.release)
+	assert.Equal(t, http.StatusNoContent, <-restored)
+	assert.Equal(t, http.StatusNoContent, serveRegistry(apps, "GET", location, "", "").Code)
+}
+
+func TestExpiredUploadSessions(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewFileStorage(dir)
+	repo := &models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}
+
+	manager := NewManager(store, nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+	apps, _ := manager.GetRegistry("apps")
+	var locations []string
+	for i := 0; i < 2; i++ {
+		w := serveRegistry(apps, "POST", "/v2/web/blobs/uploads/", "", "")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		locations = append(locations, w.Header().Get("Location"))
+	}
+	manager.StopAll()
+
+	// The first session has seen no data for longer than sessions are kept
+	stale := time.Now().Add(-uploadSessionTTL - time.Hour)
+	session := filepath.Join(dir, uploadsNamespace, "apps", filepath.Base(locations[0]), sessionFile)
+	require.NoError(t, os.Chtimes(session, stale, stale))
+
+	manager = NewManager(store, nil, logrus.New())
+	require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+	defer manager.StopAll()
+	apps, _ = manager.GetRegistry("apps")
+
+	_, err := os.Stat(session)
+	assert.True(t, os.IsNotExist(err), "expired session was not purged")
+	w := serveRegistry(apps, "GET", locations[0], "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "BLOB_UPLOAD_UNKNOWN")
+	assert.Equal(t, http.StatusNoContent, serveRegistry(apps, "GET", locations[1], "", "").Code)
+
+	// Deleting the repository forgets its sessions
+	require.NoError(t, manager.ForgetImages("apps"))
+	files, err := store.List(uploadsNamespace, "apps")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestUploadAbortedByClient(t *testing.T) {
+	store := storage.NewFileStorage(t.TempDir())
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "metrics.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	recorder, err := metrics.NewRecorder(db, logrus.New())
+	require.NoError(t, err)
+	manager := NewManager(store, nil, logrus.New())
+	manager.SetMetrics(recorder)
+	defer manager.StopAll()
+	require.NoError(t, manager.StartRegistry(&models.Repository{Name: "apps", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+	registry, _ := manager.GetRegistry("apps")
+
+	w := serveRegistry(registry, "POST", "/v2/web/blobs/uploads/", "", "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	location := w.Header().Get("Location")
+	w = serveRegistry(registry, "PATCH", location, "", "first chunk")
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	// The client gives up while sending the second chunk
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("PATCH", location, strings.NewReader("second chunk")).WithContext(ctx)
+	w = httptest.NewRecorder()
+	registry.GetRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// What arrived before is kept for the client to resume from
+	w = serveRegistry(registry, "GET", location, "", "")
+	assert.Equal(t, "bytes=0-10", w.Header().Get("Range"))
+
+	var out strings.Builder
+	recorder.WritePrometheus(&out)
+	assert.Contains(t, out.String(), `depot_uploads_aborted_total{repository="apps",kind="blob"} 1`)
+}
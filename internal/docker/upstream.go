@@ -0,0 +1,409 @@
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+const (
+	// upstreamRetryAfter is how long an unhealthy upstream is tried only
+	// after the healthy ones
+	upstreamRetryAfter = 30 * time.Second
+	// upstreamCheckInterval is how often upstreams are probed
+	upstreamCheckInterval = 30 * time.Second
+	// Circuit breaker defaults: consecutive failures that open the circuit
+	// to an upstream, and how long it stays open
+	defaultCircuitThreshold = 5
+	defaultCircuitCooldown  = time.Minute
+)
+
+// Circuit states of an upstream
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// errCircuitOpen is returned when every upstream of an image has its
+// circuit open
+var errCircuitOpen = errors.New("upstream circuit open after repeated failures")
+
+// upstream is a registry a proxy repository pulls from
+type upstream struct {
+	namespace string
+	url       string
+	repo      string // repository on an upstream depot, if federated
+	mu        sync.Mutex
+	client    *http.Client // as are the client and its trust
+	username  string       // credentials can be changed while running
+	password  string
+	tokens    map[string]string // scope -> bearer token
+	health    UpstreamStatus
+	threshold int           // consecutive failures that open the circuit
+	cooldown  time.Duration // how long the circuit stays open
+}
+
+// UpstreamStatus is the health of an upstream of a proxy repository
+type UpstreamStatus struct {
+	Namespace string     `json:"namespace"`
+	URL       string     `json:"url"`
+	Healthy   bool       `json:"healthy"`
+	Failures  int        `json:"failures,omitempty"` // consecutive
+	LastError string     `json:"last_error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
+	Circuit   string     `json:"circuit"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+func newUpstream(config models.DockerUpstream, client *http.Client) *upstream {
+	u := &upstream{
+		namespace: config.Namespace,
+		url:       strings.TrimSuffix(config.URL, "/"),
+		username:  config.Username,
+		password:  config.Password,
+		repo:      config.Repository,
+		client:    client,
+		tokens:    make(map[string]string),
+		threshold: defaultCircuitThreshold,
+		cooldown:  defaultCircuitCooldown,
+	}
+	u.health = UpstreamStatus{Namespace: u.namespace, URL: u.url, Healthy: true}
+	return u
+}
+
+// do sends a request for a path below /v2/<image>/ to the upstream. Registries
+// such as Docker Hub answer 401 with a bearer token challenge even for
+// anonymous pulls; the token is fetched, cached per scope and the request
+// retried. Registries that ask for basic authentication get the
+// upstream's credentials.
+func (u *upstream) do(method, image, subpath string, header http.Header) (*http.Response, error) {
+	if u.repo != "" {
+		// The main port of a depot serves its repositories by path
+		image = u.repo + "/" + image
+	}
+	return u.request(method, u.url+"/v2/"+image+"/"+subpath, "repository:"+image+":pull", header)
+}
+
+// request sends a request to the upstream, authenticating for scope if it
+// asks for credentials
+func (u *upstream) request(method, target, scope string, header http.Header) (*http.Response, error) {
+	u.mu.Lock()
+	token := u.tokens[scope]
+	u.mu.Unlock()
+
+	resp, err := u.send(method, target, header, token, false)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	scheme, params := parseChallenge(challenge)
+	if username, _ := u.credentials(); strings.EqualFold(scheme, "Basic") && username != "" {
+		return u.send(method, target, header, "", true)
+	}
+	token, err = u.authenticate(scheme, params, scope)
+	if err != nil {
+		return nil, err
+	}
+	return u.send(method, target, header, token, false)
+}
+
+func (u *upstream) send(method, target string, header http.Header, token string, basic bool) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if basic {
+		req.SetBasicAuth(u.credentials())
+	}
+	return u.httpClient().Do(req)
+}
+
+// authenticate fetches a token for a bearer challenge, with the upstream's
+// credentials if it has them
+func (u *upstream) authenticate(scheme string, params map[string]string, scope string) (string, error) {
+	if !strings.EqualFold(scheme, "Bearer") || params["realm"] == "" {
+		return "", fmt.Errorf("upstream requires unsupported authentication %q", scheme)
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", fmt.Errorf("invalid token realm: %w", err)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username, password := u.credentials(); username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := u.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get upstream token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upstream token service responded %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid upstream token response: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("upstream token service returned no token")
+	}
+
+	u.mu.Lock()
+	u.tokens[scope] = token
+	u.mu.Unlock()
+	return token, nil
+}
+
+// credentials returns the username and password the upstream is
+// authenticated to with
+func (u *upstream) credentials() (string, string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.username, u.password
+}
+
+// httpClient returns the client the upstream is reached with
+func (u *upstream) httpClient() *http.Client {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.client
+}
+
+// setClient changes the client the upstream is reached with, for a new CA
+// bundle or pinned keys
+func (u *upstream) setClient(client *http.Client) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.client = client
+}
+
+// setCredentials changes the credentials of the upstream, dropping the
+// tokens fetched with the old ones
+func (u *upstream) setCredentials(username, password string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if username == u.username && password == u.password {
+		return
+	}
+	u.username, u.password = username, password
+	u.tokens = make(map[string]string)
+}
+
+// succeeded records an answer from the upstream
+func (u *upstream) succeeded() {
+	now := time.Now().UTC()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.health.Healthy = true
+	u.health.Failures = 0
+	u.health.CheckedAt = &now
+}
+
+// failed records that the upstream could not answer
+func (u *upstream) failed(err error) {
+	now := time.Now().UTC()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.health.Healthy = false
+	u.health.Failures++
+	u.health.LastError = err.Error()
+	u.health.CheckedAt = &now
+	u.health.FailedAt = &now
+}
+
+// status returns a copy of the upstream's health with its circuit state
+func (u *upstream) status() UpstreamStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	status := u.health
+	status.Circuit = CircuitClosed
+	if status.Failures >= u.threshold {
+		openUntil := status.FailedAt.Add(u.cooldown)
+		if time.Now().Before(openUntil) {
+			status.Circuit = CircuitOpen
+			status.OpenUntil = &openUntil
+		} else {
+			status.Circuit = CircuitHalfOpen
+		}
+	}
+	return status
+}
+
+// open reports whether the upstream's circuit is open, so it must not be
+// tried
+func (u *upstream) open() bool {
+	return u.status().Circuit == CircuitOpen
+}
+
+// preferred reports whether the upstream should be tried before others:
+// it is healthy, or failed long enough ago to be given another chance
+func (u *upstream) preferred() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.health.Healthy || time.Since(*u.health.FailedAt) > upstreamRetryAfter
+}
+
+// byHealth orders upstreams so preferred ones come first, keeping the
+// configured order otherwise
+func byHealth(upstreams []*upstream) []*upstream {
+	ordered := append([]*upstream{}, upstreams...)
+	preferred := make(map[*upstream]bool, len(ordered))
+	for _, u := range ordered {
+		preferred[u] = u.preferred()
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return preferred[ordered[i]] && !preferred[ordered[j]]
+	})
+	return ordered
+}
+
+// check probes the upstream's /v2/ endpoint. Any answer other than a
+// server error, including 401, means it is up.
+func (u *upstream) check() {
+	resp, err := u.httpClient().Get(u.url + "/v2/")
+	if err == nil {
+		resp.Body.Close()
+		if retryable(resp.StatusCode) {
+			err = fmt.Errorf("%s responded %s", u.url, resp.Status)
+		}
+	}
+	if err != nil {
+		u.failed(err)
+		return
+	}
+	u.succeeded()
+}
+
+// startHealthChecks probes every upstream periodically until stopped, so a
+// failed upstream is used again once it recovers and a failing one is
+// skipped before a pull has to wait for it
+func (p *proxy) startHealthChecks(logger *logrus.Logger) {
+	go func() {
+		ticker := time.NewTicker(upstreamCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+			for _, ns := range p.namespaces {
+				for _, u := range p.upstreams[ns] {
+					wasHealthy := u.status().Healthy
+					u.check()
+					if status := u.status(); status.Healthy != wasHealthy {
+						entry := logger.WithFields(logrus.Fields{"namespace": u.namespace, "upstream": u.url})
+						if status.Healthy {
+							entry.Info("Upstream registry recovered")
+						} else {
+							entry.WithField("error", status.LastError).Warn("Upstream registry is unhealthy")
+						}
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stopHealthChecks ends the probes started by startHealthChecks
+func (p *proxy) stopHealthChecks() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// statuses returns the health of every upstream in configuration order
+func (p *proxy) statuses() []UpstreamStatus {
+	var statuses []UpstreamStatus
+	for _, ns := range p.namespaces {
+		for _, u := range p.upstreams[ns] {
+			statuses = append(statuses, u.status())
+		}
+	}
+	return statuses
+}
+
+// ErrNotProxy is returned for upstream lookups on repositories that are not
+// pull-through proxies
+var ErrNotProxy = errors.New("repository is not a proxy")
+
+// UpstreamStatus returns the health of the upstreams of a proxy repository
+func (m *Manager) UpstreamStatus(repoName string) ([]UpstreamStatus, error) {
+	registry, exists := m.GetRegistry(repoName)
+	if !exists {
+		return nil, fmt.Errorf("no registry running for repository %s", repoName)
+	}
+	if registry.proxy == nil {
+		return nil, ErrNotProxy
+	}
+	return registry.proxy.statuses(), nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+// into its scheme and parameters
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key != "" {
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,93 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// pinPrefix starts a pinned key: the SHA-256 of a certificate's
+// SubjectPublicKeyInfo, base64-encoded, as HPKP and curl's --pinnedpubkey
+// write it
+const pinPrefix = "sha256/"
+
+// validateUpstreamTLS checks the CA bundle and pinned keys of an upstream
+func validateUpstreamTLS(upstream models.DockerUpstream) error {
+	if upstream.CACertificates == "" && len(upstream.PinnedKeys) == 0 {
+		return nil
+	}
+	if !strings.HasPrefix(upstream.URL, "https://") {
+		return fmt.Errorf("upstream %s: ca_certificates and pinned_keys need an https URL", upstream.URL)
+	}
+	_, err := upstreamTLSConfig(upstream)
+	return err
+}
+
+// upstreamTLSConfig returns the TLS configuration an upstream is reached
+// with, or nil if it uses the defaults
+func upstreamTLSConfig(upstream models.DockerUpstream) (*tls.Config, error) {
+	if upstream.CACertificates == "" && len(upstream.PinnedKeys) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if upstream.CACertificates != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(upstream.CACertificates)) {
+			return nil, fmt.Errorf("upstream %s: ca_certificates holds no PEM certificates", upstream.URL)
+		}
+		config.RootCAs = roots
+	}
+
+	if len(upstream.PinnedKeys) > 0 {
+		pins := make(map[string]bool, len(upstream.PinnedKeys))
+		for _, pin := range upstream.PinnedKeys {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+			if err != nil || !strings.HasPrefix(pin, pinPrefix) || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("upstream %s: invalid pinned key %q, expected %s<base64 SHA-256>", upstream.URL, pin, pinPrefix)
+			}
+			pins[string(hash)] = true
+		}
+		// Runs after the chain was verified against the trusted roots
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if pins[string(hash[:])] {
+						return nil
+					}
+				}
+			}
+			return errors.New("upstream certificate does not match a pinned key")
+		}
+	}
+	return config, nil
+}
+
+// upstreamClient returns the client an upstream is reached with: shared,
+// unless the upstream has trust of its own. An invalid configuration,
+// rejected when saved, fails closed.
+func upstreamClient(upstream models.DockerUpstream, shared *http.Client) *http.Client {
+	tlsConfig, err := upstreamTLSConfig(upstream)
+	if err != nil {
+		return newUpstreamClient(&tls.Config{RootCAs: x509.NewCertPool()})
+	}
+	if tlsConfig != nil {
+		return newUpstreamClient(tlsConfig)
+	}
+	return shared
+}
+
+// sameTrust reports whether two upstream configurations trust the same
+// certificates
+func sameTrust(a, b models.DockerUpstream) bool {
+	return a.CACertificates == b.CACertificates && slices.Equal(a.PinnedKeys, b.PinnedKeys)
+}
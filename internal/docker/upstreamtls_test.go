@@ -0,0 +1,116 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestUpstreamTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	// A pin of some other key
+	otherKey := pinPrefix + base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	check := func(upstream models.DockerUpstream) UpstreamStatus {
+		upstream.Namespace = "private"
+		upstream.URL = server.URL
+		require.NoError(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{upstream}}))
+		p := newProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{upstream}})
+		u := p.upstreams["private"][0]
+		u.check()
+		return u.status()
+	}
+
+	t.Run("Untrusted Certificate", func(t *testing.T) {
+		status := check(models.DockerUpstream{})
+		assert.False(t, status.Healthy)
+		assert.Contains(t, status.LastError, "certificate")
+	})
+
+	t.Run("CA Bundle", func(t *testing.T) {
+		assert.True(t, check(models.DockerUpstream{CACertificates: ca}).Healthy)
+	})
+
+	t.Run("Pinned Keys", func(t *testing.T) {
+		assert.True(t, check(models.DockerUpstream{CACertificates: ca, PinnedKeys: []string{otherKey, pinnedKey(server.Certificate())}}).Healthy)
+
+		status := check(models.DockerUpstream{CACertificates: ca, PinnedKeys: []string{otherKey}})
+		assert.False(t, status.Healthy)
+		assert.Contains(t, status.LastError, "pinned key")
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+		config := &models.DockerRepositoryConfig{Proxy: &models.DockerProxy{Upstreams: []models.DockerUpstream{
+			{Namespace: "private", URL: server.URL, CACertificates: ca, PinnedKeys: []string{otherKey}},
+		}}}
+		require.NoError(t, manager.StartRegistry(&models.Repository{Name: "mirror", Type: models.RepositoryTypeDocker}, config))
+		defer manager.StopAll()
+		mirror, _ := manager.GetRegistry("mirror")
+		u := mirror.proxy.upstreams["private"][0]
+		u.check()
+		assert.False(t, u.status().Healthy)
+
+		rotated := *config
+		rotated.Proxy = &models.DockerProxy{Upstreams: []models.DockerUpstream{
+			{Namespace: "private", URL: server.URL, CACertificates: ca, PinnedKeys: []string{pinnedKey(server.Certificate())}},
+		}}
+		require.NoError(t, manager.Reconfigure("mirror", &rotated))
+		u.check()
+		assert.True(t, u.status().Healthy)
+	})
+
+	t.Run("Sync and Import Sources", func(t *testing.T) {
+		image := SyncImage{Source: "private/app", URL: server.URL, CACertificates: ca}
+		require.NoError(t, ValidateSyncConfig(&SyncConfig{Images: []SyncImage{image}}))
+		u := image.target(newUpstreamClient(nil)).upstreams[0]
+		u.check()
+		assert.True(t, u.status().Healthy)
+
+		manager := NewManager(storage.NewFileStorage(t.TempDir()), nil, logrus.New())
+		require.NoError(t, manager.StartRegistry(&models.Repository{Name: "hosted", Type: models.RepositoryTypeDocker}, &models.DockerRepositoryConfig{}))
+		defer manager.StopAll()
+		job, err := manager.PrepareRegistryImport("hosted", &RegistryImport{URL: server.URL, PinnedKeys: []string{otherKey}, CACertificates: ca})
+		require.NoError(t, err)
+		u = job.source.(*remoteRegistry).upstream
+		u.check()
+		assert.False(t, u.status().Healthy)
+
+		image.CACertificates = "not a certificate"
+		assert.Error(t, ValidateSyncConfig(&SyncConfig{Images: []SyncImage{image}}))
+		assert.Error(t, ValidateRegistryImport(&RegistryImport{DataDir: "/var/lib/registry", CACertificates: ca}))
+	})
+
+	t.Run("Invalid Settings", func(t *testing.T) {
+		for _, upstream := range []models.DockerUpstream{
+			{Namespace: "private", URL: server.URL, CACertificates: "not a certificate"},
+			{Namespace: "private", URL: server.URL, PinnedKeys: []string{"sha1/abc"}},
+			{Namespace: "private", URL: server.URL, PinnedKeys: []string{"sha256/dG9vIHNob3J0"}},
+			{Namespace: "private", URL: "http://registry.example.com", CACertificates: ca},
+		} {
+			assert.Error(t, ValidateProxy(&models.DockerProxy{Upstreams: []models.DockerUpstream{upstream}}))
+		}
+	})
+}
+
+// pinnedKey returns the pin of a certificate's public key, as pinned_keys
+// lists it
+func pinnedKey(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
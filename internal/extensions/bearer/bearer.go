@@ -0,0 +1,45 @@
+// Package bearer is an example server extension adding authentication: it
+// requires a bearer token on every request that changes something, Docker
+// pushes on registry ports included, while reads stay open. Enable it as "bearer" with its token setting, e.g.
+// DEPOT_EXTENSIONS=bearer and DEPOT_EXT_BEARER_TOKEN=<secret>.
+package bearer
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func init() {
+	server.RegisterExtension("bearer", server.ExtensionFunc(setup))
+}
+
+func setup(host *server.ExtensionHost) error {
+	token := host.Setting("token", "")
+	if token == "" {
+		return errors.New("the token setting is required")
+	}
+	// Leaving reads open lets health checks and anonymous downloads through
+	readsOpen := host.Setting("reads", "open") == "open"
+
+	host.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if readsOpen && (r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				host.Logger().WithField("path", r.URL.Path).Info("Refused request without a valid bearer token")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="depot"`)
+				host.Refuse(w, http.StatusUnauthorized, "A valid bearer token is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	return nil
+}
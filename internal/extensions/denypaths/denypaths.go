@@ -0,0 +1,66 @@
+// Package denypaths is an example server extension adding validation and a
+// route of its own: it refuses uploads of raw artifacts whose path matches
+// one of its patterns in any repository, and lists the patterns at
+// /api/v1/extensions/denypaths. It checks only the path of a PUT or POST
+// under /repository/, so resumable uploads, the entries of an extracted
+// archive and POSTs to content-addressed repositories get past it. A
+// repository's allowed_extensions also covers the first two. Enable it as "denypaths" with its patterns
+// setting, e.g. DEPOT_EXTENSIONS=denypaths and
+// DEPOT_EXT_DENYPATHS_PATTERNS="**/*.exe,**/.env".
+package denypaths
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/internal/server"
+)
+
+func init() {
+	server.RegisterExtension("denypaths", server.ExtensionFunc(setup))
+}
+
+func setup(host *server.ExtensionHost) error {
+	var patterns []string
+	for _, pattern := range strings.Split(host.Setting("patterns", ""), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return errors.New("the patterns setting is required")
+	}
+
+	host.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPut && r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rest, found := strings.CutPrefix(r.URL.Path, "/repository/")
+			if !found {
+				next.ServeHTTP(w, r)
+				return
+			}
+			repo, artifactPath, _ := strings.Cut(rest, "/")
+			for _, pattern := range patterns {
+				if glob.Match(pattern, artifactPath) {
+					host.Logger().WithField("repository", repo).Infof("Refused upload of %s matching %s", artifactPath, pattern)
+					host.Refuse(w, http.StatusForbidden, fmt.Sprintf("Uploads to paths matching %s are not allowed", pattern))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	host.Handle("/api/v1/extensions/denypaths", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"patterns": patterns})
+	})).Methods("GET")
+	return nil
+}
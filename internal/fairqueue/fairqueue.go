@@ -0,0 +1,271 @@
+// Package fairqueue limits how many expensive operations, such as uploads,
+// proxy fetches and background tasks, run at once, and shares that capacity
+// fairly between repositories: a slot that frees up goes to the waiting
+// repository with the fewest operations running, so one busy repository
+// cannot keep the others waiting behind its backlog.
+package fairqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when an operation waited longer than the queue's
+// timeout
+var ErrBusy = errors.New("server busy, too many operations queued")
+
+// RetryAfter is the Retry-After header, in seconds, of requests refused
+// with ErrBusy
+const RetryAfter = "5"
+
+// Stats describe one repository's use of a queue
+type Stats struct {
+	Repository  string  `json:"repository"`
+	Running     int     `json:"running"`
+	Waiting     int     `json:"waiting"`
+	WaitSeconds float64 `json:"wait_seconds_total"`
+	Timeouts    int64   `json:"timeouts_total"`
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// Queue admits up to a number of operations at once and queues the rest
+// per repository. A nil queue admits every operation at once.
+type Queue struct {
+	name     string
+	capacity int
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	running int
+	waiting map[string][]*waiter
+	// order holds the repositories with waiting operations, longest
+	// waiting first, to break ties between them
+	order []string
+	stats map[string]*Stats
+}
+
+// New creates a queue named for its metrics that runs up to capacity
+// operations at once. An operation that waits longer than timeout fails
+// with ErrBusy; with no timeout it waits as long as its context allows.
+func New(name string, capacity int, timeout time.Duration) *Queue {
+	return &Queue{
+		name:     name,
+		capacity: capacity,
+		timeout:  timeout,
+		waiting:  make(map[string][]*waiter),
+		stats:    make(map[string]*Stats),
+	}
+}
+
+// Name returns the name of the queue
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// Acquire waits for a slot to run an operation of repo, and returns the
+// function that gives it back once the operation is done. It fails with
+// ErrBusy if the queue's timeout runs out first, or with the context's
+// error if ctx is done.
+func (q *Queue) Acquire(ctx context.Context, repo string) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.running < q.capacity && len(q.order) == 0 {
+		q.admit(repo)
+		q.mu.Unlock()
+		return q.releaser(repo), nil
+	}
+	w := &waiter{ready: make(chan struct{})}
+	if len(q.waiting[repo]) == 0 {
+		q.order = append(q.order, repo)
+	}
+	q.waiting[repo] = append(q.waiting[repo], w)
+	q.stat(repo).Waiting++
+	q.mu.Unlock()
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrBusy
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stat(repo).WaitSeconds += time.Since(start).Seconds()
+	if w.granted {
+		if err == nil {
+			return q.releaser(repo), nil
+		}
+		// The slot came through while giving up, so it goes to the next
+		q.release(repo)
+	} else {
+		q.withdraw(repo, w)
+	}
+	if err == ErrBusy {
+		q.stat(repo).Timeouts++
+	}
+	return nil, err
+}
+
+// releaser returns the function that gives back a slot of repo, once
+func (q *Queue) releaser(repo string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.release(repo)
+		})
+	}
+}
+
+func (q *Queue) admit(repo string) {
+	q.running++
+	q.stat(repo).Running++
+}
+
+// release frees a slot of repo and hands free slots to waiting
+// operations, first to the repository with the fewest running
+func (q *Queue) release(repo string) {
+	q.running--
+	q.stat(repo).Running--
+
+	for q.running < q.capacity && len(q.order) > 0 {
+		next := 0
+		for i, candidate := range q.order {
+			if q.stats[candidate].Running < q.stats[q.order[next]].Running {
+				next = i
+			}
+		}
+		repo := q.order[next]
+		w := q.waiting[repo][0]
+		q.waiting[repo] = q.waiting[repo][1:]
+		q.order = append(q.order[:next], q.order[next+1:]...)
+		if len(q.waiting[repo]) > 0 {
+			// Repositories equally far behind take turns
+			q.order = append(q.order, repo)
+		} else {
+			delete(q.waiting, repo)
+		}
+
+		q.stat(repo).Waiting--
+		q.admit(repo)
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// withdraw removes an operation that gave up waiting
+func (q *Queue) withdraw(repo string, w *waiter) {
+	waiting := q.waiting[repo]
+	for i := range waiting {
+		if waiting[i] == w {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			break
+		}
+	}
+	q.stat(repo).Waiting--
+	if len(waiting) > 0 {
+		q.waiting[repo] = waiting
+		return
+	}
+	delete(q.waiting, repo)
+	for i, candidate := range q.order {
+		if candidate == repo {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (q *Queue) stat(repo string) *Stats {
+	s := q.stats[repo]
+	if s == nil {
+		s = &Stats{Repository: repo}
+		q.stats[repo] = s
+	}
+	return s
+}
+
+// Stats returns the use of the queue by every repository that used it,
+// ordered by name
+func (q *Queue) Stats() []Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make([]Stats, 0, len(q.stats))
+	for _, s := range q.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Repository < stats[j].Repository })
+	return stats
+}
+
+// WritePrometheus writes the capacity of the queues and the operations
+// running and waiting in them per repository in the Prometheus text
+// exposition format. Nil queues are left out.
+func WritePrometheus(w io.Writer, queues ...*Queue) {
+	var active []*Queue
+	stats := make(map[*Queue][]Stats)
+	for _, q := range queues {
+		if q != nil {
+			active = append(active, q)
+			stats[q] = q.Stats()
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP depot_queue_capacity Operations a queue runs at once.\n# TYPE depot_queue_capacity gauge\n")
+	for _, q := range active {
+		fmt.Fprintf(w, "depot_queue_capacity{queue=\"%s\"} %d\n", escapeLabel(q.name), q.capacity)
+	}
+
+	metrics := []struct {
+		name   string
+		help   string
+		kind   string
+		format func(Stats) string
+	}{
+		{"depot_queue_running", "Operations running per queue and repository.", "gauge", func(s Stats) string { return fmt.Sprint(s.Running) }},
+		{"depot_queue_waiting", "Operations waiting per queue and repository.", "gauge", func(s Stats) string { return fmt.Sprint(s.Waiting) }},
+		{"depot_queue_wait_seconds_total", "Time operations spent waiting per queue and repository.", "counter", func(s Stats) string { return fmt.Sprintf("%g", s.WaitSeconds) }},
+		{"depot_queue_timeouts_total", "Operations that gave up waiting per queue and repository.", "counter", func(s Stats) string { return fmt.Sprint(s.Timeouts) }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, q := range active {
+			for _, s := range stats[q] {
+				fmt.Fprintf(w, "%s{queue=\"%s\",repository=\"%s\"} %s\n", metric.name, escapeLabel(q.name), escapeLabel(s.Repository), metric.format(s))
+			}
+		}
+	}
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
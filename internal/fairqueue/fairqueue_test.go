@@ -0,0 +1,143 @@
+package fairqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitFor polls until repo has n operations waiting
+func waitFor(t *testing.T, q *Queue, repo string, n int) {
+	require.Eventually(t, func() bool {
+		for _, s := range q.Stats() {
+			if s.Repository == repo {
+				return s.Waiting == n
+			}
+		}
+		return n == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFairness(t *testing.T) {
+	q := New("uploads", 2, 0)
+
+	var held []func()
+	for i := 0; i < 2; i++ {
+		release, err := q.Acquire(context.Background(), "hot")
+		require.NoError(t, err)
+		held = append(held, release)
+	}
+	first, second := held[0], held[1]
+
+	var mu sync.Mutex
+	var admitted []string
+	queue := func(repo string, n int) {
+		go func() {
+			release, err := q.Acquire(context.Background(), repo)
+			require.NoError(t, err)
+			mu.Lock()
+			admitted = append(admitted, repo)
+			held = append(held, release)
+			mu.Unlock()
+		}()
+		waitFor(t, q, repo, n)
+	}
+	queue("hot", 1)
+	queue("hot", 2)
+	queue("cold", 1)
+
+	// The repository with nothing running goes first, however long the
+	// other's backlog
+	first()
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(admitted) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"cold"}, admitted)
+
+	second()
+	waitFor(t, q, "hot", 1)
+	mu.Lock()
+	assert.Equal(t, []string{"cold", "hot"}, admitted)
+	mu.Unlock()
+	for _, s := range q.Stats() {
+		assert.Equal(t, 1, s.Running, s.Repository)
+	}
+}
+
+func TestTurns(t *testing.T) {
+	q := New("tasks", 1, 0)
+	release, err := q.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	admitted := make(chan string, 4)
+	for _, repo := range []string{"a", "a", "b", "b"} {
+		repo := repo
+		go func() {
+			release, err := q.Acquire(context.Background(), repo)
+			if err == nil {
+				admitted <- repo
+				time.Sleep(5 * time.Millisecond)
+				release()
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitFor(t, q, "b", 2)
+	release()
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		order = append(order, <-admitted)
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b"}, order)
+}
+
+func TestGiveUp(t *testing.T) {
+	q := New("proxy", 1, 20*time.Millisecond)
+	release, err := q.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	_, err = q.Acquire(context.Background(), "b")
+	assert.True(t, errors.Is(err, ErrBusy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.Acquire(ctx, "b")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Neither is left waiting, so the slot is free again once released
+	release()
+	release()
+	release, err = q.Acquire(context.Background(), "b")
+	require.NoError(t, err)
+	release()
+
+	for _, s := range q.Stats() {
+		assert.Zero(t, s.Running, s.Repository)
+		assert.Zero(t, s.Waiting, s.Repository)
+	}
+	var buf bytes.Buffer
+	WritePrometheus(&buf, q, nil)
+	assert.Contains(t, buf.String(), `depot_queue_capacity{queue="proxy"} 1`)
+	assert.Contains(t, buf.String(), `depot_queue_timeouts_total{queue="proxy",repository="b"} 1`)
+	assert.Contains(t, buf.String(), `depot_queue_waiting{queue="proxy",repository="a"} 0`)
+}
+
+func TestNilQueue(t *testing.T) {
+	var q *Queue
+	release, err := q.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+	release()
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf, q)
+	assert.Empty(t, buf.String())
+}
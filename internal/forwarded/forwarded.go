@@ -0,0 +1,158 @@
+package forwarded
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Headers set by reverse proxies and load balancers
+const (
+	HeaderFor   = "X-Forwarded-For"
+	HeaderProto = "X-Forwarded-Proto"
+	HeaderHost  = "X-Forwarded-Host"
+)
+
+type contextKey struct{}
+
+// Trusted is the set of proxies whose X-Forwarded-* headers and PROXY
+// protocol headers are believed. A nil Trusted trusts no one.
+type Trusted struct {
+	nets []*net.IPNet
+}
+
+// ParseTrusted parses a comma-separated list of IP addresses and CIDR
+// ranges, e.g. "10.0.0.0/8, 192.168.1.10". An empty list returns nil.
+func ParseTrusted(list string) (*Trusted, error) {
+	var t Trusted
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			t.nets = append(t.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		t.nets = append(t.nets, ipNet)
+	}
+	if len(t.nets) == 0 {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// Contains reports whether addr, an IP address with or without a port, is
+// a trusted proxy
+func (t *Trusted) Contains(addr string) bool {
+	if t == nil {
+		return false
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range t.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware applies the X-Forwarded-* headers of requests from trusted
+// proxies: the client address in X-Forwarded-For replaces RemoteAddr,
+// X-Forwarded-Host replaces Host, and X-Forwarded-Proto is returned by
+// Scheme. Headers from anyone else are ignored.
+func (t *Trusted) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.Contains(r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		if client := t.client(r.Header.Values(HeaderFor)); client != "" {
+			port := "0"
+			if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				port = p
+			}
+			r.RemoteAddr = net.JoinHostPort(client, port)
+		}
+		if host := first(r.Header.Get(HeaderHost)); host != "" && !strings.ContainsAny(host, "/\\ @") {
+			r.Host = host
+		}
+		if proto := strings.ToLower(first(r.Header.Get(HeaderProto))); proto == "http" || proto == "https" {
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, proto))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// client returns the address of the client in X-Forwarded-For: the last
+// address not added by a trusted proxy, or the first if all were
+func (t *Trusted) client(values []string) string {
+	var addrs []string
+	for _, value := range values {
+		for _, addr := range strings.Split(value, ",") {
+			if addr = strings.TrimSpace(addr); net.ParseIP(addr) == nil {
+				return ""
+			}
+			addrs = append(addrs, strings.TrimSpace(addr))
+		}
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !t.Contains(addrs[i]) || i == 0 {
+			return addrs[i]
+		}
+	}
+	return ""
+}
+
+// Scheme returns the scheme the client used: the X-Forwarded-Proto of a
+// trusted proxy, or that of the connection
+func Scheme(r *http.Request) string {
+	if proto, ok := r.Context().Value(contextKey{}).(string); ok {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// BaseURL returns the scheme and host the client addressed, for absolute
+// URLs in responses
+func BaseURL(r *http.Request) string {
+	return Scheme(r) + "://" + r.Host
+}
+
+// ClientIP returns the IP address of the client of a request
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func first(value string) string {
+	value, _, _ = strings.Cut(value, ",")
+	return strings.TrimSpace(value)
+}
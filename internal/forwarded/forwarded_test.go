@@ -0,0 +1,73 @@
+package forwarded
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8, 192.168.1.10,::1")
+	require.NoError(t, err)
+	assert.True(t, trusted.Contains("10.1.2.3:4567"))
+	assert.True(t, trusted.Contains("192.168.1.10"))
+	assert.True(t, trusted.Contains("[::1]:80"))
+	assert.False(t, trusted.Contains("192.168.1.11"))
+
+	trusted, err = ParseTrusted(" ")
+	require.NoError(t, err)
+	assert.Nil(t, trusted)
+	assert.False(t, trusted.Contains("10.1.2.3"))
+
+	_, err = ParseTrusted("10.0.0.0/40")
+	assert.Error(t, err)
+	_, err = ParseTrusted("proxy.example.com")
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8")
+	require.NoError(t, err)
+
+	var seen *http.Request
+	handler := trusted.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+	serve := func(remote string, headers map[string]string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	forwarded := map[string]string{
+		HeaderFor:   "198.51.100.1, 203.0.113.7, 10.0.0.2",
+		HeaderHost:  "depot.example.com",
+		HeaderProto: "https",
+	}
+
+	t.Run("Trusted", func(t *testing.T) {
+		serve("10.0.0.1:5555", forwarded)
+		assert.Equal(t, "203.0.113.7", ClientIP(seen))
+		assert.Equal(t, "depot.example.com", seen.Host)
+		assert.Equal(t, "https://depot.example.com", BaseURL(seen))
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		serve("203.0.113.9:5555", forwarded)
+		assert.Equal(t, "203.0.113.9", ClientIP(seen))
+		assert.Equal(t, "example.com", seen.Host)
+		assert.Equal(t, "http", Scheme(seen))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		serve("10.0.0.1:5555", map[string]string{HeaderFor: "not-an-ip", HeaderHost: "evil.com/path", HeaderProto: "gopher"})
+		assert.Equal(t, "10.0.0.1", ClientIP(seen))
+		assert.Equal(t, "example.com", seen.Host)
+		assert.Equal(t, "http", Scheme(seen))
+	})
+}
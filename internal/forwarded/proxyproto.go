@@ -0,0 +1,178 @@
+package forwarded
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a proxy may take to send the PROXY
+// protocol header of a connection
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV2Signature starts a binary (version 2) PROXY protocol header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalidProxyHeader is returned when reading from a connection of a
+// trusted proxy that did not start with a valid PROXY protocol header
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// Listen wraps listen so that the listeners it opens read the PROXY
+// protocol header connections from trusted proxies start with, and report
+// the client address it carries as the connection's remote address
+func (t *Trusted) Listen(listen func(network, address string) (net.Listener, error)) func(network, address string) (net.Listener, error) {
+	return func(network, address string) (net.Listener, error) {
+		l, err := listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return t.ProxyListener(l), nil
+	}
+}
+
+// ProxyListener wraps l to read the PROXY protocol header of connections
+// from trusted proxies. Other connections are returned as accepted.
+func (t *Trusted) ProxyListener(l net.Listener) net.Listener {
+	return &proxyListener{Listener: l, trusted: t}
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted *Trusted
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil || !l.trusted.Contains(c.RemoteAddr().String()) {
+		return c, err
+	}
+	// The header is read on first use, by the connection's own goroutine,
+	// so a slow proxy does not hold up Accept
+	return &proxyConn{Conn: c, reader: bufio.NewReader(c)}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a version 1 or 2 PROXY protocol header, returning
+// the client address, or nil for connections the proxy made itself, such
+// as health checks
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, ErrInvalidProxyHeader
+}
+
+// readProxyV1 reads a text header: PROXY TCP4 <src> <dst> <sport> <dport>
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// A version 1 header is at most 107 bytes
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidProxyHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 reads a binary header
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	version, command, family := header[12]>>4, header[12]&0x0f, header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if version != 2 || command > 1 {
+		return nil, ErrInvalidProxyHeader
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	// LOCAL connections are the proxy's own
+	if command == 0 {
+		return nil, nil
+	}
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	default:
+		// Other families, such as UNIX sockets, carry no IP address
+		return nil, nil
+	}
+}
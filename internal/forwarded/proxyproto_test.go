@@ -0,0 +1,80 @@
+package forwarded
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	read := func(header string) (net.Addr, string, error) {
+		r := bufio.NewReader(strings.NewReader(header + "GET / HTTP/1.1\r\n"))
+		addr, err := readProxyHeader(r)
+		rest, _ := io.ReadAll(r)
+		return addr, string(rest), err
+	}
+
+	addr, rest, err := read("PROXY TCP4 198.51.100.1 10.0.0.1 40000 443\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.1:40000", addr.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	addr, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 40000 443\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:40000", addr.String())
+
+	addr, _, err = read("PROXY UNKNOWN\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, addr)
+
+	v2 := append([]byte{}, proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12, 198, 51, 100, 1, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 40000)
+	v2 = binary.BigEndian.AppendUint16(v2, 443)
+	addr, rest, err = read(string(v2))
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.1:40000", addr.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0, 0)
+	addr, _, err = read(string(local))
+	require.NoError(t, err)
+	assert.Nil(t, addr)
+
+	for _, header := range []string{"", "PROXY TCP4 garbage\r\n", "PROXY TCP4 198.51.100.1 10.0.0.1 40000 443\n"} {
+		_, _, err = read(header)
+		assert.ErrorIs(t, err, ErrInvalidProxyHeader, header)
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	trusted, err := ParseTrusted("127.0.0.1")
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := trusted.ProxyListener(l)
+	defer listener.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 198.51.100.1 127.0.0.1 40000 443\r\nhello"))
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "198.51.100.1:40000", conn.RemoteAddr().String())
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
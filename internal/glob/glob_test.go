@@ -0,0 +1,33 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"releases/**", "releases/app/1.0/app.jar", true},
+		{"releases/**", "releases", true},
+		{"releases/**", "snapshots/app.jar", false},
+		{"**/*.jar", "app.jar", true},
+		{"**/*.jar", "a/b/c/app.jar", true},
+		{"**/*.jar", "a/b/c/app.zip", false},
+		{"app/*/app.tar.gz", "app/1.0/app.tar.gz", true},
+		{"app/*/app.tar.gz", "app/1.0/extra/app.tar.gz", false},
+		{"nightly/**/build-?.log", "nightly/2024/01/build-1.log", true},
+		{"*.txt", "dir/file.txt", false},
+		{"[", "x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.match, Match(tt.pattern, tt.name))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// envListeners names the environment variable through which a parent
+// process tells its replacement which addresses the inherited file
+// descriptors, starting at 3, are listening on
+const envListeners = "DEPOT_INHERITED_LISTENERS"
+
+// firstInheritedFD is the descriptor of the first entry in ExtraFiles
+const firstInheritedFD = 3
+
+// Handoff hands listening sockets over to a replacement process, so a new
+// binary can start accepting connections on the same ports without a
+// window in which they are refused. Connections that arrive while the new
+// process starts up wait in the socket's backlog. Nothing else is handed
+// over: state a process keeps only in memory, such as a Docker upload
+// session it could not persist, is lost and the client has to start over.
+type Handoff struct {
+	mu        sync.Mutex
+	active    map[string]*listener
+	inherited map[string]net.Listener
+	adopted   bool
+}
+
+// New creates a Handoff, adopting the listeners passed down by a parent
+// process if this process was started by Upgrade
+func New() (*Handoff, error) {
+	h := &Handoff{
+		active:    make(map[string]*listener),
+		inherited: make(map[string]net.Listener),
+	}
+
+	value := os.Getenv(envListeners)
+	if value == "" {
+		return h, nil
+	}
+	os.Unsetenv(envListeners)
+	h.adopted = true
+
+	for i, addr := range strings.Split(value, ",") {
+		file := os.NewFile(uintptr(firstInheritedFD+i), addr)
+		if file == nil {
+			return nil, fmt.Errorf("inherited listener %s is not open", addr)
+		}
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to adopt inherited listener %s: %w", addr, err)
+		}
+		h.inherited[addr] = l
+	}
+	return h, nil
+}
+
+// Inherited reports whether this process took over listeners from a parent
+func (h *Handoff) Inherited() bool {
+	return h.adopted
+}
+
+// Listen returns the listener inherited for address, or a new one. Either
+// way it is passed on by the next Upgrade until it is closed.
+func (h *Handoff) Listen(network, address string) (net.Listener, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.active[address]; exists {
+		return nil, fmt.Errorf("already listening on %s", address)
+	}
+
+	l, ok := h.inherited[address]
+	if ok {
+		delete(h.inherited, address)
+	} else {
+		var err error
+		if l, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+
+	tracked := &listener{Listener: l, handoff: h, address: address}
+	h.active[address] = tracked
+	return tracked, nil
+}
+
+// CloseUnused closes inherited listeners this process has not claimed, e.g.
+// for a registry that was deleted before the upgrade
+func (h *Handoff) CloseUnused() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for address, l := range h.inherited {
+		l.Close()
+		delete(h.inherited, address)
+	}
+}
+
+// Upgrade starts a new instance of the current executable with the same
+// arguments, passing it every open listener. The caller should then stop
+// accepting connections, finish in-flight requests and exit; the new
+// process serves everything that arrives in the meantime.
+func (h *Handoff) Upgrade() (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	h.mu.Lock()
+	addresses := make([]string, 0, len(h.active))
+	files := make([]*os.File, 0, len(h.active))
+	for address, l := range h.active {
+		file, err := l.file()
+		if err != nil {
+			h.mu.Unlock()
+			closeFiles(files)
+			return nil, fmt.Errorf("failed to pass listener %s: %w", address, err)
+		}
+		addresses = append(addresses, address)
+		files = append(files, file)
+	}
+	h.mu.Unlock()
+	defer closeFiles(files)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), envListeners+"="+strings.Join(addresses, ","))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	// Reap the new process should it exit before this one
+	go cmd.Wait()
+	return cmd.Process, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// listener forgets itself when closed so it is not handed over
+type listener struct {
+	net.Listener
+	handoff *Handoff
+	address string
+	once    sync.Once
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.handoff.mu.Lock()
+		if l.handoff.active[l.address] == l {
+			delete(l.handoff.active, l.address)
+		}
+		l.handoff.mu.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+// file duplicates the listening socket's descriptor
+func (l *listener) file() (*os.File, error) {
+	filer, ok := l.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("listener has no file descriptor")
+	}
+	return filer.File()
+}
@@ -0,0 +1,86 @@
+package handoff
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) != "" {
+		runUpgradedChild()
+	}
+	os.Exit(m.Run())
+}
+
+func TestListen(t *testing.T) {
+	h, err := New()
+	require.NoError(t, err)
+	assert.False(t, h.Inherited())
+
+	l, err := h.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = h.Listen("tcp", "127.0.0.1:0")
+	assert.Error(t, err, "an address is only handed out once")
+
+	require.NoError(t, l.Close())
+	assert.Empty(t, h.active)
+
+	l, err = h.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close()
+}
+
+// helperEnv makes the process started by TestUpgrade act as the upgraded
+// server instead of running the tests
+const helperEnv = "DEPOT_HANDOFF_TEST_CHILD"
+
+func TestUpgrade(t *testing.T) {
+	h, err := New()
+	require.NoError(t, err)
+	l, err := h.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+
+	t.Setenv(helperEnv, "1")
+	process, err := h.Upgrade()
+	require.NoError(t, err)
+	defer process.Kill()
+
+	// Stop serving in this process; the socket stays open in the child
+	require.NoError(t, l.Close())
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "upgraded", string(reply))
+}
+
+// runUpgradedChild answers one connection on the inherited listener
+func runUpgradedChild() {
+	h, err := New()
+	if err != nil || !h.Inherited() {
+		os.Exit(2)
+	}
+	l, err := h.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.Exit(3)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(4)
+	}
+	conn.Write([]byte("upgraded"))
+	conn.Close()
+	os.Exit(0)
+}
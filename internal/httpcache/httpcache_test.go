@@ -0,0 +1,96 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	etag := ETag("sha256:abc")
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"No Conditions", "GET", nil, false},
+		{"Matching ETag", "GET", map[string]string{"If-None-Match": `"sha256:abc"`}, true},
+		{"Weak Matching ETag", "HEAD", map[string]string{"If-None-Match": `W/"other", W/"sha256:abc"`}, true},
+		{"Wildcard", "GET", map[string]string{"If-None-Match": "*"}, true},
+		{"Different ETag", "GET", map[string]string{"If-None-Match": `"sha256:def"`}, false},
+		{"ETag Beats Date", "GET", map[string]string{"If-None-Match": `"x"`, "If-Modified-Since": modTime.Format(http.TimeFormat)}, false},
+		{"Not Modified Since", "GET", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, true},
+		{"Modified Since", "GET", map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"Unsafe Method", "PUT", map[string]string{"If-None-Match": `"sha256:abc"`}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			assert.Equal(t, tt.want, NotModified(w, req, etag, modTime))
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+			if tt.want {
+				assert.Equal(t, http.StatusNotModified, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	content := "0123456789"
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Whole", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		complete := ServeContent(w, httptest.NewRequest("GET", "/", nil), modTime, strings.NewReader(content), 10)
+		assert.True(t, complete)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, content, w.Body.String())
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+		assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", "bytes=2-5")
+		w := httptest.NewRecorder()
+		complete := ServeContent(w, req, modTime, strings.NewReader(content), 10)
+		assert.False(t, complete)
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "2345", w.Body.String())
+		assert.Equal(t, "bytes 2-5/10", w.Header().Get("Content-Range"))
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		complete := ServeContent(w, httptest.NewRequest("HEAD", "/", nil), modTime, strings.NewReader(content), 10)
+		assert.False(t, complete)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "10", w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("Not Seekable", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", "bytes=2-5")
+		w := httptest.NewRecorder()
+		complete := ServeContent(w, req, modTime, io.MultiReader(strings.NewReader(content)), 10)
+		assert.True(t, complete)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, content, w.Body.String())
+	})
+}
@@ -0,0 +1,103 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// artifactoryList is the deep file list of the Artifactory storage API
+type artifactoryList struct {
+	Files []struct {
+		URI          string `json:"uri"`
+		Size         int64  `json:"size"`
+		LastModified string `json:"lastModified"`
+		Folder       bool   `json:"folder"`
+		SHA1         string `json:"sha1"`
+		SHA2         string `json:"sha2"`
+	} `json:"files"`
+}
+
+// listArtifactory lists the files of an Artifactory repository below a
+// path in one request
+func (s *Source) listArtifactory(ctx context.Context, prefix string) ([]*Artifact, error) {
+	target := s.base + "/api/storage/" + url.PathEscape(s.config.Repository)
+	if prefix != "" {
+		target += "/" + escapePath(prefix)
+	}
+	resp, err := s.get(ctx, target+"?list&deep=1&listFolders=0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	defer resp.Body.Close()
+	var list artifactoryList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid file list: %w", err)
+	}
+
+	var artifacts []*Artifact
+	for _, file := range list.Files {
+		// File URIs are relative to the listed path
+		artifactPath := strings.Trim(prefix+"/"+strings.TrimPrefix(file.URI, "/"), "/")
+		if file.Folder || artifactPath == "" {
+			continue
+		}
+		artifact := &Artifact{
+			Path:      artifactPath,
+			Size:      file.Size,
+			Checksums: map[string]string{},
+			download:  s.base + "/" + url.PathEscape(s.config.Repository) + "/" + escapePath(artifactPath),
+		}
+		if file.SHA1 != "" {
+			artifact.Checksums["sha1"] = file.SHA1
+		}
+		if file.SHA2 != "" {
+			artifact.Checksums["sha256"] = file.SHA2
+		}
+		if modified, err := time.Parse(time.RFC3339, file.LastModified); err == nil {
+			artifact.LastModified = modified
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Path < artifacts[j].Path
+	})
+	return artifacts, nil
+}
+
+// Properties fetches the properties of an artifact from an Artifactory
+// server, which does not include them in file lists. A property with
+// several values becomes their comma-separated list. Nexus artifacts
+// already carry what they have.
+func (s *Source) Properties(ctx context.Context, artifact *Artifact) (map[string]string, error) {
+	if s.config.Type != TypeArtifactory {
+		return artifact.Properties, nil
+	}
+
+	resp, err := s.get(ctx, s.base+"/api/storage/"+url.PathEscape(s.config.Repository)+"/"+escapePath(artifact.Path)+"?properties")
+	if errors.Is(err, ErrNotFound) {
+		// Artifactory answers 404 for artifacts without properties
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Properties map[string][]string `json:"properties"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid properties: %w", err)
+	}
+	properties := make(map[string]string, len(response.Properties))
+	for name, values := range response.Properties {
+		properties[name] = strings.Join(values, ",")
+	}
+	return properties, nil
+}
@@ -0,0 +1,165 @@
+// Package importer reads the artifacts of a repository on another artifact
+// manager, Sonatype Nexus 3 or JFrog Artifactory, with the metadata it keeps
+// for them, so they can be migrated into a depot repository.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Source types
+const (
+	TypeNexus       = "nexus"
+	TypeArtifactory = "artifactory"
+)
+
+// responseTimeout bounds the wait for the source's response headers;
+// downloads of large artifacts may take longer
+const responseTimeout = 60 * time.Second
+
+// ErrNotFound is returned for a repository or artifact the source does not
+// have
+var ErrNotFound = errors.New("not found on the source")
+
+// Config names a repository on another server and the credentials to read
+// it with: a username and password, which may be an API key or user token,
+// or a bearer token. URL is the server's base URL, such as
+// https://nexus.example.com or https://example.jfrog.io/artifactory. Only
+// artifacts below Path are imported if it is set.
+type Config struct {
+	Type       string `json:"type"`
+	URL        string `json:"url"`
+	Repository string `json:"repository"`
+	Path       string `json:"path,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	Token      string `json:"token,omitempty"`
+}
+
+// Validate checks a source configuration
+func (c *Config) Validate() error {
+	if c.Type != TypeNexus && c.Type != TypeArtifactory {
+		return fmt.Errorf("unknown source type %q, expected %s or %s", c.Type, TypeNexus, TypeArtifactory)
+	}
+	parsed, err := url.Parse(c.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid source URL %q", c.URL)
+	}
+	if c.Repository == "" || strings.ContainsAny(c.Repository, "/?#") {
+		return fmt.Errorf("invalid source repository %q", c.Repository)
+	}
+	if c.Password != "" && c.Username == "" {
+		return errors.New("a password needs a username")
+	}
+	if c.Token != "" && c.Username != "" {
+		return errors.New("give either a token or a username and password")
+	}
+	return nil
+}
+
+// Artifact is a file of the source repository. Checksums holds the
+// hex-encoded digests the source has for it by algorithm, md5, sha1,
+// sha256 or sha512. Size is -1 if the source did not report it, and
+// ContentType is empty.
+type Artifact struct {
+	Path         string
+	Size         int64
+	ContentType  string
+	Checksums    map[string]string
+	LastModified time.Time
+	Properties   map[string]string
+	download     string
+}
+
+// Source reads the artifacts of a repository on a Nexus or Artifactory
+// server
+type Source struct {
+	config Config
+	base   string
+	client *http.Client
+}
+
+// NewSource creates a source for a validated configuration
+func NewSource(config Config) *Source {
+	return &Source{
+		config: config,
+		base:   strings.TrimSuffix(config.URL, "/"),
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: responseTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+		}},
+	}
+}
+
+// Name identifies the source repository in results and logs
+func (s *Source) Name() string {
+	return s.base + "/" + s.config.Repository
+}
+
+// List returns the artifacts of the repository below the configured path
+func (s *Source) List(ctx context.Context) ([]*Artifact, error) {
+	prefix := strings.Trim(s.config.Path, "/")
+	if s.config.Type == TypeArtifactory {
+		return s.listArtifactory(ctx, prefix)
+	}
+	return s.listNexus(ctx, prefix)
+}
+
+// Open downloads an artifact's content
+func (s *Source) Open(ctx context.Context, artifact *Artifact) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, artifact.download)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// get sends an authenticated GET request, failing unless the source
+// answers 200
+func (s *Source) get(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case s.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	case s.config.Username != "":
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return nil, fmt.Errorf("%s responded %s", s.base, resp.Status)
+}
+
+// within reports whether an artifact path is below a path prefix
+func within(artifactPath, prefix string) bool {
+	return prefix == "" || artifactPath == prefix || strings.HasPrefix(artifactPath, prefix+"/")
+}
+
+// escapePath escapes each segment of an artifact path for a URL
+func escapePath(artifactPath string) string {
+	segments := strings.Split(artifactPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Config{Type: TypeNexus, URL: "https://nexus.example.com", Repository: "releases"}
+	assert.NoError(t, valid.Validate())
+
+	for _, config := range []Config{
+		{Type: "gitlab", URL: "https://nexus.example.com", Repository: "releases"},
+		{Type: TypeNexus, URL: "nexus.example.com", Repository: "releases"},
+		{Type: TypeNexus, URL: "https://nexus.example.com", Repository: "a/b"},
+		{Type: TypeNexus, URL: "https://nexus.example.com", Repository: "releases", Password: "secret"},
+		{Type: TypeArtifactory, URL: "https://example.jfrog.io/artifactory", Repository: "libs", Username: "user", Token: "token"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestNexusSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/service/rest/v1/assets":
+			assert.Equal(t, "releases", r.URL.Query().Get("repository"))
+			if r.URL.Query().Get("continuationToken") == "" {
+				w.Write([]byte(`{"items":[
+					{"path":"/com/example/app/1.0/app-1.0.jar","contentType":"application/java-archive","fileSize":3,
+					 "lastModified":"2024-03-01T10:00:00.000+00:00","checksum":{"sha1":"a9993e364706816aba3e25717850c26c9cd0d89d"},
+					 "maven2":{"groupId":"com.example","artifactId":"app","version":"1.0","extension":"jar"}}],
+					"continuationToken":"next"}`))
+				return
+			}
+			w.Write([]byte(`{"items":[{"path":"docs/readme.txt","checksum":{}}],"continuationToken":null}`))
+		case "/repository/releases/com/example/app/1.0/app-1.0.jar":
+			w.Write([]byte("abc"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := NewSource(Config{Type: TypeNexus, URL: server.URL + "/", Repository: "releases", Username: "admin", Password: "secret"})
+	artifacts, err := source.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+
+	jar := artifacts[0]
+	assert.Equal(t, "com/example/app/1.0/app-1.0.jar", jar.Path)
+	assert.Equal(t, int64(3), jar.Size)
+	assert.Equal(t, "application/java-archive", jar.ContentType)
+	assert.Equal(t, "a9993e364706816aba3e25717850c26c9cd0d89d", jar.Checksums["sha1"])
+	assert.True(t, jar.LastModified.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)))
+	properties, err := source.Properties(context.Background(), jar)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"maven.groupId": "com.example", "maven.artifactId": "app", "maven.version": "1.0", "maven.extension": "jar"}, properties)
+	assert.Equal(t, int64(-1), artifacts[1].Size)
+
+	reader, err := source.Open(context.Background(), jar)
+	require.NoError(t, err)
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "abc", string(content))
+
+	_, err = source.Open(context.Background(), artifacts[1])
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	filtered := NewSource(Config{Type: TypeNexus, URL: server.URL, Repository: "releases", Path: "/docs/", Username: "admin", Password: "secret"})
+	artifacts, err = filtered.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, "docs/readme.txt", artifacts[0].Path)
+
+	_, err = NewSource(Config{Type: TypeNexus, URL: server.URL, Repository: "releases"}).List(context.Background())
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestArtifactorySource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/artifactory/api/storage/libs/tools" && r.URL.Query().Has("list"):
+			assert.Equal(t, "1", r.URL.Query().Get("deep"))
+			w.Write([]byte(`{"uri":"https://example/artifactory/api/storage/libs/tools","files":[
+				{"uri":"/cli/2.0/cli.tar.gz","size":5,"lastModified":"2024-03-01T10:00:00.000Z","folder":false,
+				 "sha1":"03de6c570bfe24bfc328ccd7ca46b76eadaf4334","sha2":"1e0b5e2f5d1b5fde0d0ad4cb4cbd2d0f1b7de9cf36f35b91b5e5b2cd5d2a1b7a"},
+				{"uri":"/cli/1.0/cli.tar.gz","size":5,"lastModified":"2023-01-01T00:00:00.000Z","folder":false}]}`))
+		case r.URL.Path == "/artifactory/api/storage/libs/tools/cli/2.0/cli.tar.gz" && r.URL.Query().Has("properties"):
+			w.Write([]byte(`{"properties":{"build.name":["cli"],"os":["linux","darwin"]}}`))
+		case r.URL.Path == "/artifactory/libs/tools/cli/2.0/cli.tar.gz":
+			w.Write([]byte("cli 2"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	source := NewSource(Config{Type: TypeArtifactory, URL: server.URL + "/artifactory", Repository: "libs", Path: "tools", Token: "token"})
+	artifacts, err := source.List(context.Background())
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+	assert.Equal(t, "tools/cli/1.0/cli.tar.gz", artifacts[0].Path)
+	assert.Empty(t, artifacts[0].Checksums)
+
+	latest := artifacts[1]
+	assert.Equal(t, "tools/cli/2.0/cli.tar.gz", latest.Path)
+	assert.Equal(t, int64(5), latest.Size)
+	assert.Equal(t, "03de6c570bfe24bfc328ccd7ca46b76eadaf4334", latest.Checksums["sha1"])
+	assert.Len(t, latest.Checksums["sha256"], 64)
+	assert.Equal(t, 2024, latest.LastModified.Year())
+
+	properties, err := source.Properties(context.Background(), latest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"build.name": "cli", "os": "linux,darwin"}, properties)
+	properties, err = source.Properties(context.Background(), artifacts[0])
+	require.NoError(t, err)
+	assert.Empty(t, properties)
+
+	reader, err := source.Open(context.Background(), latest)
+	require.NoError(t, err)
+	content, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "cli 2", string(content))
+}
@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// nexusAssets is a page of the Nexus 3 assets API
+type nexusAssets struct {
+	Items []struct {
+		Path         string            `json:"path"`
+		ContentType  string            `json:"contentType"`
+		Checksum     map[string]string `json:"checksum"`
+		LastModified string            `json:"lastModified"`
+		FileSize     *int64            `json:"fileSize"`
+		Maven2       map[string]string `json:"maven2"`
+	} `json:"items"`
+	ContinuationToken string `json:"continuationToken"`
+}
+
+// listNexus pages through the assets of a Nexus 3 repository. Maven
+// coordinates, which Nexus reports for the assets of Maven repositories,
+// become properties.
+func (s *Source) listNexus(ctx context.Context, prefix string) ([]*Artifact, error) {
+	var artifacts []*Artifact
+	token := ""
+	for {
+		query := url.Values{"repository": {s.config.Repository}}
+		if token != "" {
+			query.Set("continuationToken", token)
+		}
+		resp, err := s.get(ctx, s.base+"/service/rest/v1/assets?"+query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list assets: %w", err)
+		}
+		var page nexusAssets
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid asset list: %w", err)
+		}
+
+		for _, item := range page.Items {
+			artifactPath := strings.TrimPrefix(item.Path, "/")
+			if artifactPath == "" || strings.HasSuffix(artifactPath, "/") || !within(artifactPath, prefix) {
+				continue
+			}
+			artifact := &Artifact{
+				Path:        artifactPath,
+				Size:        -1,
+				ContentType: item.ContentType,
+				Checksums:   item.Checksum,
+				Properties:  map[string]string{},
+				download:    s.base + "/repository/" + url.PathEscape(s.config.Repository) + "/" + escapePath(artifactPath),
+			}
+			if item.FileSize != nil {
+				artifact.Size = *item.FileSize
+			}
+			if modified, err := time.Parse(time.RFC3339, item.LastModified); err == nil {
+				artifact.LastModified = modified
+			}
+			for _, coordinate := range []string{"groupId", "artifactId", "version", "classifier", "extension"} {
+				if value := item.Maven2[coordinate]; value != "" {
+					artifact.Properties["maven."+coordinate] = value
+				}
+			}
+			artifacts = append(artifacts, artifact)
+		}
+
+		if page.ContinuationToken == "" {
+			return artifacts, nil
+		}
+		token = page.ContinuationToken
+	}
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options describe a log destination
+type Options struct {
+	// Output is "stdout", "stderr", "syslog" for the local syslog daemon
+	// (journald on systemd hosts), "syslog://host:port" or
+	// "syslog+tcp://host:port" for a remote one, or a file path
+	Output string
+
+	// Format is "json" (the default) or "text"
+	Format string
+
+	// Level is the minimum level logged, e.g. "debug" or "warn"; the
+	// default is "info"
+	Level string
+
+	// MaxSize rotates a log file once it reaches this many bytes; 0 never
+	// rotates on size
+	MaxSize int64
+
+	// MaxAge rotates a log file once it has been written to for this long;
+	// 0 never rotates on age
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files kept; 0 keeps all of them
+	MaxBackups int
+}
+
+// New creates a logger writing to the destination in opts. The returned
+// closer releases the destination when the logger is no longer used.
+func New(opts Options) (*logrus.Logger, io.Closer, error) {
+	logger := logrus.New()
+
+	switch opts.Format {
+	case "", "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	default:
+		return nil, nil, fmt.Errorf("invalid log format %q", opts.Format)
+	}
+
+	level := logrus.InfoLevel
+	if opts.Level != "" {
+		var err error
+		if level, err = logrus.ParseLevel(opts.Level); err != nil {
+			return nil, nil, fmt.Errorf("invalid log level %q", opts.Level)
+		}
+	}
+	logger.SetLevel(level)
+
+	var closer io.Closer = nopCloser{}
+	switch output := opts.Output; {
+	case output == "" || output == "stdout":
+		logger.SetOutput(os.Stdout)
+	case output == "stderr":
+		logger.SetOutput(os.Stderr)
+	case output == "syslog" || strings.HasPrefix(output, "syslog://") || strings.HasPrefix(output, "syslog+tcp://"):
+		network, address := "", ""
+		if output != "syslog" {
+			network, address = "udp", strings.TrimPrefix(output, "syslog://")
+			if strings.HasPrefix(output, "syslog+tcp://") {
+				network, address = "tcp", strings.TrimPrefix(output, "syslog+tcp://")
+			}
+		}
+		hook, err := newSyslogHook(network, address)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		logger.AddHook(hook)
+		logger.SetOutput(io.Discard)
+	default:
+		file, err := OpenFile(output, opts.MaxSize, opts.MaxAge, opts.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.SetOutput(file)
+		closer = file
+	}
+
+	return logger, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "depot.log")
+
+	t.Run("Size", func(t *testing.T) {
+		f, err := OpenFile(path, 10, 0, 2)
+		require.NoError(t, err)
+		defer f.Close()
+
+		for i := 0; i < 5; i++ {
+			_, err := f.Write([]byte("12345678\n"))
+			require.NoError(t, err)
+			// Backups are named by time, keep them apart
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		backups, err := f.Backups()
+		require.NoError(t, err)
+		assert.Len(t, backups, 2)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "12345678\n", string(data))
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		f, err := OpenFile(path, 0, 10*time.Millisecond, 0)
+		require.NoError(t, err)
+		defer f.Close()
+
+		before, err := f.Backups()
+		require.NoError(t, err)
+
+		_, err = f.Write([]byte("first\n"))
+		require.NoError(t, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = f.Write([]byte("second\n"))
+		require.NoError(t, err)
+
+		backups, err := f.Backups()
+		require.NoError(t, err)
+		assert.Len(t, backups, len(before)+1)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "second\n", string(data))
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		f, err := OpenFile(path, 0, 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		_, err = f.Write([]byte("late\n"))
+		assert.ErrorIs(t, err, os.ErrClosed)
+	})
+}
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	logger, closer, err := New(Options{Output: path, Format: "text", Level: "warn"})
+	require.NoError(t, err)
+	assert.Equal(t, logrus.WarnLevel, logger.GetLevel())
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	require.NoError(t, closer.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), "msg=shown")
+
+	_, _, err = New(Options{Format: "xml"})
+	assert.Error(t, err)
+
+	_, _, err = New(Options{Level: "loud"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// AccessSetting controls the access log of one repository. A disabled
+// repository logs no requests; otherwise each request is logged with
+// probability SampleRate.
+type AccessSetting struct {
+	Enabled    bool    `json:"enabled"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// DefaultAccessSetting logs every request
+var DefaultAccessSetting = AccessSetting{Enabled: true, SampleRate: 1}
+
+// Validate checks that the sample rate of an enabled setting is a
+// probability above 0
+func (s AccessSetting) Validate() error {
+	if s.Enabled && (s.SampleRate <= 0 || s.SampleRate > 1) {
+		return errors.New("sample_rate must be above 0 and at most 1")
+	}
+	return nil
+}
+
+// AccessSampling decides which requests reach the access log, by the
+// repository they are for. Settings are kept in memory and last until the
+// server restarts. A nil AccessSampling logs every request.
+type AccessSampling struct {
+	mu       sync.RWMutex
+	settings map[string]AccessSetting
+}
+
+// NewAccessSampling creates an AccessSampling logging every request
+func NewAccessSampling() *AccessSampling {
+	return &AccessSampling{settings: make(map[string]AccessSetting)}
+}
+
+// Set changes the setting of a repository
+func (a *AccessSampling) Set(repository string, setting AccessSetting) error {
+	if err := setting.Validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.settings[repository] = setting
+	return nil
+}
+
+// Reset returns a repository to logging every request
+func (a *AccessSampling) Reset(repository string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.settings, repository)
+}
+
+// Get returns the setting of a repository
+func (a *AccessSampling) Get(repository string) AccessSetting {
+	if a == nil {
+		return DefaultAccessSetting
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if setting, exists := a.settings[repository]; exists {
+		return setting
+	}
+	return DefaultAccessSetting
+}
+
+// List returns the repositories with a setting of their own
+func (a *AccessSampling) List() map[string]AccessSetting {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	settings := make(map[string]AccessSetting, len(a.settings))
+	for repository, setting := range a.settings {
+		settings[repository] = setting
+	}
+	return settings
+}
+
+// Sample reports whether a request for a repository should be logged.
+// Requests for no repository ("") are logged unless it has a setting.
+func (a *AccessSampling) Sample(repository string) bool {
+	setting := a.Get(repository)
+	if !setting.Enabled {
+		return false
+	}
+	return setting.SampleRate >= 1 || rand.Float64() < setting.SampleRate
+}
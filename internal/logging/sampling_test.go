@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessSampling(t *testing.T) {
+	var unset *AccessSampling
+	assert.True(t, unset.Sample("proxy"))
+
+	sampling := NewAccessSampling()
+	assert.True(t, sampling.Sample("proxy"))
+	assert.Equal(t, DefaultAccessSetting, sampling.Get("proxy"))
+
+	require.NoError(t, sampling.Set("proxy", AccessSetting{Enabled: false}))
+	for i := 0; i < 100; i++ {
+		assert.False(t, sampling.Sample("proxy"))
+	}
+	assert.True(t, sampling.Sample("builds"))
+
+	require.NoError(t, sampling.Set("proxy", AccessSetting{Enabled: true, SampleRate: 0.1}))
+	logged := 0
+	for i := 0; i < 10000; i++ {
+		if sampling.Sample("proxy") {
+			logged++
+		}
+	}
+	assert.InDelta(t, 1000, logged, 300)
+	assert.Equal(t, map[string]AccessSetting{"proxy": {Enabled: true, SampleRate: 0.1}}, sampling.List())
+
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		assert.Error(t, sampling.Set("proxy", AccessSetting{Enabled: true, SampleRate: rate}))
+	}
+
+	sampling.Reset("proxy")
+	assert.Empty(t, sampling.List())
+	assert.True(t, sampling.Sample("proxy"))
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"log/syslog"
+
+	"github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+// newSyslogHook sends entries to syslog at the priority of their level. An
+// empty network connects to the local daemon.
+func newSyslogHook(network, address string) (logrus.Hook, error) {
+	return lsyslog.NewSyslogHook(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "depot")
+}
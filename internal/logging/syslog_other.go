@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newSyslogHook(network, address string) (logrus.Hook, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,196 @@
+// Package maintenance keeps the server-wide maintenance switch, which puts
+// the server into read-only mode or takes it offline entirely while
+// backups or migrations run
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Modes of maintenance
+const (
+	// ModeReadOnly refuses every request that would change something
+	ModeReadOnly = "read-only"
+	// ModeOffline refuses every request
+	ModeOffline = "offline"
+)
+
+// Header reports the maintenance mode on every response while it is on
+const Header = "X-Depot-Maintenance"
+
+// Path is the admin endpoint that switches maintenance, always served so
+// maintenance can be turned off again
+const Path = "/api/v1/admin/maintenance"
+
+var (
+	bucketMaintenance = []byte("maintenance")
+	stateKey          = []byte("state")
+)
+
+// ErrInvalidMode is returned for a mode other than read-only or offline
+var ErrInvalidMode = errors.New("maintenance mode must be read-only or offline")
+
+// State describes maintenance while it is on
+type State struct {
+	Mode      string    `json:"mode"`
+	Message   string    `json:"message,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Manager holds the maintenance switch. It is stored in the database, so
+// maintenance stays on across restarts until it is turned off.
+type Manager struct {
+	db    *bbolt.DB
+	mu    sync.RWMutex
+	state *State
+}
+
+// NewManager creates a manager, restoring maintenance left on
+func NewManager(db *bbolt.DB) (*Manager, error) {
+	m := &Manager{db: db}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketMaintenance)
+		if err != nil {
+			return err
+		}
+		if data := b.Get(stateKey); data != nil {
+			var state State
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("invalid maintenance state: %w", err)
+			}
+			m.state = &state
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load maintenance state: %w", err)
+	}
+	return m, nil
+}
+
+// Get returns the current maintenance, or nil if the server is not in
+// maintenance
+func (m *Manager) Get() *State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.state == nil {
+		return nil
+	}
+	state := *m.state
+	return &state
+}
+
+// Enable turns maintenance on in a mode, with a message for clients, or
+// changes the mode and message of maintenance already on
+func (m *Manager) Enable(mode, message string) (*State, error) {
+	if mode != ModeReadOnly && mode != ModeOffline {
+		return nil, ErrInvalidMode
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := &State{Mode: mode, Message: message, StartedAt: time.Now().UTC()}
+	if m.state != nil {
+		state.StartedAt = m.state.StartedAt
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketMaintenance).Put(stateKey, data)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store maintenance state: %w", err)
+	}
+	m.state = state
+	copied := *state
+	return &copied, nil
+}
+
+// Disable turns maintenance off
+func (m *Manager) Disable() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketMaintenance).Delete(stateKey)
+	}); err != nil {
+		return fmt.Errorf("failed to store maintenance state: %w", err)
+	}
+	m.state = nil
+	return nil
+}
+
+// Active reports whether the server is in maintenance, which holds off
+// scheduled tasks
+func (m *Manager) Active() bool {
+	return m.Get() != nil
+}
+
+// Refuses reports whether maintenance refuses a request: every request
+// when offline, and every one but reads when read-only. Docker clients ask
+// which blobs exist with a POST that changes nothing, so that is a read.
+func (s *State) Refuses(r *http.Request) bool {
+	if s.Mode == ModeOffline {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		return !strings.HasSuffix(r.URL.Path, "/blobs/exists")
+	}
+	return true
+}
+
+// Describe returns the message clients are given for refused requests
+func (s *State) Describe() string {
+	text := "Server is offline for maintenance"
+	if s.Mode == ModeReadOnly {
+		text = "Server is read-only for maintenance"
+	}
+	if s.Message != "" {
+		text += ": " + s.Message
+	}
+	return text
+}
+
+// Middleware marks responses with the maintenance mode while maintenance
+// is on, and has refuse answer the requests it refuses
+func (m *Manager) Middleware(refuse func(w http.ResponseWriter, r *http.Request, state *State)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := m.Get()
+			if state == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set(Header, state.Mode)
+			if state.Refuses(r) && !Exempt(r) {
+				refuse(w, r, state)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Exempt reports whether a request is answered whatever the maintenance:
+// health checks, readiness probes and the maintenance switch itself
+func Exempt(r *http.Request) bool {
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/readyz", "/api/v1/health", Path:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package maintenance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestMaintenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "depot.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+
+	m, err := NewManager(db)
+	require.NoError(t, err)
+	assert.Nil(t, m.Get())
+	assert.False(t, m.Active())
+
+	_, err = m.Enable("sleepy", "")
+	assert.ErrorIs(t, err, ErrInvalidMode)
+
+	state, err := m.Enable(ModeReadOnly, "backup running")
+	require.NoError(t, err)
+	assert.Equal(t, "Server is read-only for maintenance: backup running", state.Describe())
+	started := state.StartedAt
+
+	state, err = m.Enable(ModeOffline, "migrating storage")
+	require.NoError(t, err)
+	assert.Equal(t, started, state.StartedAt)
+
+	// Maintenance survives a restart
+	require.NoError(t, db.Close())
+	db, err = bbolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	m, err = NewManager(db)
+	require.NoError(t, err)
+	require.NotNil(t, m.Get())
+	assert.Equal(t, ModeOffline, m.Get().Mode)
+	assert.Equal(t, "migrating storage", m.Get().Message)
+
+	require.NoError(t, m.Disable())
+	assert.Nil(t, m.Get())
+}
+
+func TestMiddleware(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	m, err := NewManager(db)
+	require.NoError(t, err)
+
+	handler := m.Middleware(func(w http.ResponseWriter, r *http.Request, state *State) {
+		http.Error(w, state.Describe(), http.StatusServiceUnavailable)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve("PUT", "/repository/releases/app.tar.gz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(Header))
+
+	_, err = m.Enable(ModeReadOnly, "")
+	require.NoError(t, err)
+	w = serve("GET", "/repository/releases/app.tar.gz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ModeReadOnly, w.Header().Get(Header))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("PUT", "/repository/releases/app.tar.gz").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/v2/app/blobs/exists").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve("POST", "/v2/app/blobs/uploads/").Code)
+
+	_, err = m.Enable(ModeOffline, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, serve("GET", "/repository/releases/app.tar.gz").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/readyz").Code)
+	assert.Equal(t, http.StatusOK, serve("DELETE", Path).Code)
+}
@@ -0,0 +1,103 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketAliases    = []byte("aliases")
+	ErrAliasNotFound = errors.New("alias not found")
+)
+
+// Alias is a pointer from a stable path in a raw repository, such as
+// app/latest.tar.gz, to a versioned artifact that is served in its place
+type Alias struct {
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Target     string    `json:"target"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// GetAlias returns the alias at a path
+func (s *Store) GetAlias(repo, path string) (*Alias, error) {
+	var alias Alias
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketAliases).Get(key(repo, path))
+		if data == nil {
+			return ErrAliasNotFound
+		}
+		return json.Unmarshal(data, &alias)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &alias, nil
+}
+
+// PutAlias creates an alias or repoints an existing one, preserving its
+// creation time
+func (s *Store) PutAlias(alias *Alias) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketAliases)
+		k := key(alias.Repository, alias.Path)
+
+		now := time.Now()
+		alias.CreatedAt = now
+		if data := b.Get(k); data != nil {
+			var existing Alias
+			if err := json.Unmarshal(data, &existing); err == nil {
+				alias.CreatedAt = existing.CreatedAt
+			}
+		}
+		alias.UpdatedAt = now
+
+		data, err := json.Marshal(alias)
+		if err != nil {
+			return fmt.Errorf("failed to marshal alias: %w", err)
+		}
+		return b.Put(k, data)
+	})
+}
+
+// DeleteAlias removes an alias
+func (s *Store) DeleteAlias(repo, path string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketAliases)
+		if b.Get(key(repo, path)) == nil {
+			return ErrAliasNotFound
+		}
+		return b.Delete(key(repo, path))
+	})
+}
+
+// ListAliases returns the aliases of a repository ordered by path
+func (s *Store) ListAliases(repo string) ([]*Alias, error) {
+	aliases := []*Alias{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		prefix := key(repo, "")
+		c := tx.Bucket(bucketAliases).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var alias Alias
+			if err := json.Unmarshal(v, &alias); err != nil {
+				return fmt.Errorf("failed to unmarshal alias %q: %w", k, err)
+			}
+			aliases = append(aliases, &alias)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
@@ -0,0 +1,98 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketDownloads = []byte("downloads")
+
+// Downloads counts how often an artifact or image tag was downloaded
+type Downloads struct {
+	Repository     string    `json:"repository"`
+	Path           string    `json:"path"`
+	Count          int64     `json:"count"`
+	LastDownloaded time.Time `json:"last_downloaded"`
+}
+
+// RecordDownload counts a download of an artifact at time at. Concurrent
+// downloads are committed together to keep the cost per download low.
+func (s *Store) RecordDownload(repo, path string, at time.Time) error {
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketDownloads)
+		downloads := Downloads{Repository: repo, Path: path}
+		if data := b.Get(key(repo, path)); data != nil {
+			if err := json.Unmarshal(data, &downloads); err != nil {
+				return fmt.Errorf("failed to unmarshal downloads: %w", err)
+			}
+		}
+
+		downloads.Count++
+		if at.After(downloads.LastDownloaded) {
+			downloads.LastDownloaded = at
+		}
+
+		data, err := json.Marshal(downloads)
+		if err != nil {
+			return fmt.Errorf("failed to marshal downloads: %w", err)
+		}
+		return b.Put(key(repo, path), data)
+	})
+}
+
+// GetDownloads returns the download counter of an artifact, which is zero
+// if it was never downloaded
+func (s *Store) GetDownloads(repo, path string) (*Downloads, error) {
+	downloads := &Downloads{Repository: repo, Path: path}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketDownloads).Get(key(repo, path))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, downloads)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return downloads, nil
+}
+
+// TopDownloads returns the most downloaded artifacts of a repository, most
+// downloaded first. A limit of 0 returns every counter.
+func (s *Store) TopDownloads(repo string, limit int) ([]*Downloads, error) {
+	top := []*Downloads{}
+	prefix := key(repo, "")
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketDownloads).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var downloads Downloads
+			if err := json.Unmarshal(v, &downloads); err != nil {
+				return fmt.Errorf("failed to unmarshal downloads %q: %w", k, err)
+			}
+			top = append(top, &downloads)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].LastDownloaded.After(top[j].LastDownloaded)
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top, nil
+}
@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketExpiry = []byte("expiry")
+
+// Expiry records when an artifact with a time to live is due for removal
+type Expiry struct {
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// GetExpiry returns when an artifact expires, or the zero time if it does
+// not
+func (s *Store) GetExpiry(repo, path string) (time.Time, error) {
+	var expiry Expiry
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketExpiry).Get(key(repo, path))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &expiry)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expiry.ExpiresAt, nil
+}
+
+// SetExpiry sets when an artifact expires. The zero time removes the
+// expiry.
+func (s *Store) SetExpiry(repo, path string, expiresAt time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketExpiry)
+		if expiresAt.IsZero() {
+			return b.Delete(key(repo, path))
+		}
+
+		data, err := json.Marshal(Expiry{Repository: repo, Path: path, ExpiresAt: expiresAt})
+		if err != nil {
+			return fmt.Errorf("failed to marshal expiry: %w", err)
+		}
+		return b.Put(key(repo, path), data)
+	})
+}
+
+// Expired returns the artifacts whose expiry is at or before now
+func (s *Store) Expired(now time.Time) ([]*Expiry, error) {
+	expired := []*Expiry{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketExpiry).ForEach(func(k, v []byte) error {
+			var expiry Expiry
+			if err := json.Unmarshal(v, &expiry); err != nil {
+				return fmt.Errorf("failed to unmarshal expiry %q: %w", k, err)
+			}
+			if !expiry.ExpiresAt.After(now) {
+				expired = append(expired, &expiry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,72 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketPromotions = []byte("promotions")
+
+// Promotion records an image copied into a repository by the promotion API
+type Promotion struct {
+	Source      string    `json:"source"`
+	Target      string    `json:"target"`
+	Image       string    `json:"image"`
+	Reference   string    `json:"reference"`
+	TargetImage string    `json:"target_image"`
+	TargetTag   string    `json:"target_tag,omitempty"`
+	Digest      string    `json:"digest"`
+	PromotedBy  string    `json:"promoted_by"`
+	PromotedAt  time.Time `json:"promoted_at"`
+	Signed      bool      `json:"signed"`
+	Scanned     bool      `json:"scanned"`
+}
+
+// RecordPromotion records a promotion into its target repository, setting
+// its time if unset
+func (s *Store) RecordPromotion(promotion *Promotion) error {
+	if promotion.PromotedAt.IsZero() {
+		promotion.PromotedAt = time.Now().UTC()
+	}
+	data, err := json.Marshal(promotion)
+	if err != nil {
+		return fmt.Errorf("failed to marshal promotion: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		// Keys sort by time within the repository
+		k := key(promotion.Target, fmt.Sprintf("%020d %s", promotion.PromotedAt.UnixNano(), promotion.Digest))
+		return tx.Bucket(bucketPromotions).Put(k, data)
+	})
+}
+
+// ListPromotions returns the promotions into a repository, oldest first,
+// optionally only those of one target image
+func (s *Store) ListPromotions(repo, image string) ([]*Promotion, error) {
+	promotions := []*Promotion{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		prefix := key(repo, "")
+		c := tx.Bucket(bucketPromotions).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var promotion Promotion
+			if err := json.Unmarshal(v, &promotion); err != nil {
+				return fmt.Errorf("failed to unmarshal promotion %q: %w", k, err)
+			}
+			if image != "" && promotion.TargetImage != image {
+				continue
+			}
+			promotions = append(promotions, &promotion)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promotions, nil
+}
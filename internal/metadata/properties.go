@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketProperties = []byte("properties")
+
+// ArtifactProperties are the user-defined key/value properties of an
+// artifact, such as the build number or git commit that produced it
+type ArtifactProperties struct {
+	Repository string            `json:"repository"`
+	Path       string            `json:"path"`
+	Properties map[string]string `json:"properties"`
+}
+
+// GetProperties returns the properties of an artifact, which are empty if
+// none were set
+func (s *Store) GetProperties(repo, path string) (map[string]string, error) {
+	properties := map[string]string{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketProperties).Get(key(repo, path))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &properties)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return properties, nil
+}
+
+// SetProperties replaces the properties of an artifact. Setting no
+// properties removes them.
+func (s *Store) SetProperties(repo, path string, properties map[string]string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketProperties)
+		if len(properties) == 0 {
+			return b.Delete(key(repo, path))
+		}
+
+		data, err := json.Marshal(properties)
+		if err != nil {
+			return fmt.Errorf("failed to marshal properties: %w", err)
+		}
+		return b.Put(key(repo, path), data)
+	})
+}
+
+// FindByProperties returns the artifacts that have every property in
+// filter, ordered by repository and path. An empty repo searches all
+// repositories.
+func (s *Store) FindByProperties(repo string, filter map[string]string) ([]*ArtifactProperties, error) {
+	results := []*ArtifactProperties{}
+
+	var prefix []byte
+	if repo != "" {
+		prefix = key(repo, "")
+	}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketProperties).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var properties map[string]string
+			if err := json.Unmarshal(v, &properties); err != nil {
+				return fmt.Errorf("failed to unmarshal properties %q: %w", k, err)
+			}
+			if !matchProperties(properties, filter) {
+				continue
+			}
+
+			repository, path, _ := bytes.Cut(k, []byte{0})
+			results = append(results, &ArtifactProperties{
+				Repository: string(repository),
+				Path:       string(path),
+				Properties: properties,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func matchProperties(properties, filter map[string]string) bool {
+	for name, value := range filter {
+		if actual, ok := properties[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/provenance"
+)
+
+var bucketProvenance = []byte("provenance")
+
+// GetProvenance returns the build provenance attached to an artifact, or
+// nil if it has none
+func (s *Store) GetProvenance(repo, path string) (*provenance.Provenance, error) {
+	var p *provenance.Provenance
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketProvenance).Get(key(repo, path))
+		if data == nil {
+			return nil
+		}
+		p = &provenance.Provenance{}
+		return json.Unmarshal(data, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetProvenance replaces the build provenance of an artifact. A nil
+// provenance removes it.
+func (s *Store) SetProvenance(repo, path string, p *provenance.Provenance) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketProvenance)
+		if p == nil {
+			return b.Delete(key(repo, path))
+		}
+
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("failed to marshal provenance: %w", err)
+		}
+		return b.Put(key(repo, path), data)
+	})
+}
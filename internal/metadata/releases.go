@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketReleases     = []byte("releases")
+	ErrReleaseNotFound = errors.New("release not found")
+)
+
+// Release is what a release of a project in a raw repository records
+// besides its artifacts, which are stored below <project>/<version>/: a
+// title, release notes and whether it is a prerelease
+type Release struct {
+	Repository string    `json:"repository"`
+	Project    string    `json:"project"`
+	Version    string    `json:"version"`
+	Title      string    `json:"title,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+	Prerelease bool      `json:"prerelease"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// GetRelease returns what is recorded for a release
+func (s *Store) GetRelease(repo, project, version string) (*Release, error) {
+	var release Release
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketReleases).Get(key(repo, project+"/"+version))
+		if data == nil {
+			return ErrReleaseNotFound
+		}
+		return json.Unmarshal(data, &release)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &release, nil
+}
+
+// PutRelease records a release or replaces what is recorded for it,
+// preserving its creation time
+func (s *Store) PutRelease(release *Release) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketReleases)
+		k := key(release.Repository, release.Project+"/"+release.Version)
+
+		now := time.Now()
+		release.CreatedAt = now
+		if data := b.Get(k); data != nil {
+			var existing Release
+			if err := json.Unmarshal(data, &existing); err == nil {
+				release.CreatedAt = existing.CreatedAt
+			}
+		}
+		release.UpdatedAt = now
+
+		data, err := json.Marshal(release)
+		if err != nil {
+			return fmt.Errorf("failed to marshal release: %w", err)
+		}
+		return b.Put(k, data)
+	})
+}
+
+// DeleteRelease removes what is recorded for a release
+func (s *Store) DeleteRelease(repo, project, version string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketReleases)
+		k := key(repo, project+"/"+version)
+		if b.Get(k) == nil {
+			return ErrReleaseNotFound
+		}
+		return b.Delete(k)
+	})
+}
+
+// ListReleases returns the releases recorded for a repository, ordered by
+// project and version text
+func (s *Store) ListReleases(repo string) ([]*Release, error) {
+	releases := []*Release{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		prefix := key(repo, "")
+		c := tx.Bucket(bucketReleases).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var release Release
+			if err := json.Unmarshal(v, &release); err != nil {
+				return fmt.Errorf("failed to unmarshal release %q: %w", k, err)
+			}
+			releases = append(releases, &release)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
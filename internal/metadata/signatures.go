@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketSignatures = []byte("signatures")
+
+// Signature is a detached signature made by the server for a raw artifact.
+// Size and ModTime identify the file version that was signed, so a
+// signature of content since overwritten is not served.
+type Signature struct {
+	Repository  string    `json:"repository"`
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modified"`
+	Key         string    `json:"key"`
+	KeyVersion  int       `json:"key_version"`
+	KeyType     string    `json:"key_type"`
+	Fingerprint string    `json:"fingerprint"`
+	Signature   string    `json:"signature"`
+	SignedAt    time.Time `json:"signed_at"`
+}
+
+// Matches reports whether the signature was made for a file with the given
+// size and modification time
+func (s *Signature) Matches(size int64, modTime time.Time) bool {
+	return s.Size == size && s.ModTime.Equal(modTime)
+}
+
+// GetSignature returns the signature recorded for an artifact, or nil if
+// it has none
+func (s *Store) GetSignature(repo, path string) (*Signature, error) {
+	var sig *Signature
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketSignatures).Get(key(repo, path))
+		if data == nil {
+			return nil
+		}
+		sig = &Signature{}
+		return json.Unmarshal(data, sig)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sig, nil
+}
+
+// PutSignature records the signature of an artifact, replacing any earlier
+// one
+func (s *Store) PutSignature(sig *Signature) error {
+	data, err := json.Marshal(sig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signature: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketSignatures).Put(key(sig.Repository, sig.Path), data)
+	})
+}
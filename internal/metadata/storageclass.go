@@ -0,0 +1,28 @@
+package metadata
+
+import "go.etcd.io/bbolt"
+
+var bucketStorageClasses = []byte("storage_classes")
+
+// GetStorageClass returns the storage class an artifact was uploaded with,
+// or "" if none was recorded
+func (s *Store) GetStorageClass(repo, path string) (string, error) {
+	var class string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		class = string(tx.Bucket(bucketStorageClasses).Get(key(repo, path)))
+		return nil
+	})
+	return class, err
+}
+
+// SetStorageClass records the storage class of an artifact. An empty class
+// removes the record.
+func (s *Store) SetStorageClass(repo, path, class string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketStorageClasses)
+		if class == "" {
+			return b.Delete(key(repo, path))
+		}
+		return b.Put(key(repo, path), []byte(class))
+	})
+}
@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/scan"
+)
+
+var (
+	bucketArtifacts     = []byte("artifacts")
+	ErrArtifactNotFound = errors.New("artifact metadata not found")
+)
+
+// Artifact is the metadata recorded for a stored raw artifact. Size and
+// ModTime identify the file version the metadata was computed from. Scan
+// is set if the upload was virus scanned. Verified lists the algorithms
+// whose digests the uploader supplied and the content matched.
+type Artifact struct {
+	Repository string       `json:"repository"`
+	Path       string       `json:"path"`
+	Size       int64        `json:"size"`
+	ModTime    time.Time    `json:"modified"`
+	Scan       *scan.Result `json:"scan,omitempty"`
+	Verified   []string     `json:"verified,omitempty"`
+	checksum.Sums
+}
+
+// Matches reports whether the metadata describes a file with the given size
+// and modification time
+func (a *Artifact) Matches(size int64, modTime time.Time) bool {
+	return a.Size == size && a.ModTime.Equal(modTime)
+}
+
+// Store persists artifact metadata in bbolt
+type Store struct {
+	db *bbolt.DB
+}
+
+// NewStore creates a metadata store backed by the given database
+func NewStore(db *bbolt.DB) *Store {
+	db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketArtifacts, bucketAliases, bucketProperties, bucketExpiry, bucketDownloads, bucketPromotions, bucketProvenance, bucketStorageClasses, bucketSignatures, bucketReleases} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return &Store{db: db}
+}
+
+// Get returns the metadata for an artifact
+func (s *Store) Get(repo, path string) (*Artifact, error) {
+	var artifact Artifact
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketArtifacts).Get(key(repo, path))
+		if data == nil {
+			return ErrArtifactNotFound
+		}
+		return json.Unmarshal(data, &artifact)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &artifact, nil
+}
+
+// Put records the metadata for an artifact, replacing any previous entry
+func (s *Store) Put(artifact *Artifact) error {
+	data, err := json.Marshal(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact metadata: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketArtifacts).Put(key(artifact.Repository, artifact.Path), data)
+	})
+}
+
+// ForEach calls fn with the metadata of every artifact. fn must not modify
+// the store.
+func (s *Store) ForEach(fn func(*Artifact) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketArtifacts).ForEach(func(k, v []byte) error {
+			var artifact Artifact
+			if err := json.Unmarshal(v, &artifact); err != nil {
+				return fmt.Errorf("failed to unmarshal artifact metadata %q: %w", k, err)
+			}
+			return fn(&artifact)
+		})
+	})
+}
+
+// FindByChecksum returns the artifacts whose recorded algorithm digest,
+// such as sha256, is value, ordered by repository and path. Artifacts
+// whose checksums were never computed are not found.
+func (s *Store) FindByChecksum(algorithm, value string) ([]*Artifact, error) {
+	artifacts := []*Artifact{}
+	err := s.ForEach(func(artifact *Artifact) error {
+		if artifact.Get(algorithm) == value {
+			artifacts = append(artifacts, artifact)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// Delete removes the metadata, properties, expiry, download counter,
+// provenance, storage class and signature of an artifact. Deleting an
+// artifact without metadata is not an error.
+func (s *Store) Delete(repo, path string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketArtifacts, bucketProperties, bucketExpiry, bucketDownloads, bucketProvenance, bucketStorageClasses, bucketSignatures} {
+			if err := tx.Bucket(bucket).Delete(key(repo, path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRepository removes all metadata, aliases, properties, expiries,
+// download counters, promotions, provenance, storage classes, signatures
+// and releases recorded for a repository
+func (s *Store) DeleteRepository(repo string) error {
+	prefix := key(repo, "")
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{bucketArtifacts, bucketAliases, bucketProperties, bucketExpiry, bucketDownloads, bucketPromotions, bucketProvenance, bucketStorageClasses, bucketSignatures, bucketReleases} {
+			c := tx.Bucket(bucket).Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// key separates repository and path with a NUL byte, which cannot appear in
+// either, so a repository's entries share a common prefix
+func key(repo, path string) []byte {
+	return []byte(repo + "\x00" + path)
+}
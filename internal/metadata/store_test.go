@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/provenance"
+)
+
+func newTestStore(t *testing.T) *Store {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "metadata.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewStore(db)
+}
+
+func TestAliases(t *testing.T) {
+	s := newTestStore(t)
+
+	alias := &Alias{Repository: "releases", Path: "app/latest.tar.gz", Target: "app/1.0/app.tar.gz"}
+	require.NoError(t, s.PutAlias(alias))
+	created := alias.CreatedAt
+
+	time.Sleep(time.Millisecond)
+	repointed := &Alias{Repository: "releases", Path: "app/latest.tar.gz", Target: "app/1.1/app.tar.gz"}
+	require.NoError(t, s.PutAlias(repointed))
+
+	got, err := s.GetAlias("releases", "app/latest.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "app/1.1/app.tar.gz", got.Target)
+	assert.True(t, got.CreatedAt.Equal(created))
+	assert.True(t, got.UpdatedAt.After(created))
+
+	// Repositories whose names share a prefix are kept apart
+	require.NoError(t, s.PutAlias(&Alias{Repository: "releases-old", Path: "latest", Target: "v1"}))
+	aliases, err := s.ListAliases("releases")
+	require.NoError(t, err)
+	assert.Len(t, aliases, 1)
+
+	require.NoError(t, s.DeleteAlias("releases", "app/latest.tar.gz"))
+	assert.ErrorIs(t, s.DeleteAlias("releases", "app/latest.tar.gz"), ErrAliasNotFound)
+}
+
+func TestDeleteRepository(t *testing.T) {
+	s := newTestStore(t)
+
+	require.NoError(t, s.Put(&Artifact{Repository: "a", Path: "x"}))
+	require.NoError(t, s.Put(&Artifact{Repository: "a", Path: "y"}))
+	require.NoError(t, s.Put(&Artifact{Repository: "ab", Path: "x"}))
+	require.NoError(t, s.PutAlias(&Alias{Repository: "a", Path: "latest", Target: "x"}))
+
+	require.NoError(t, s.DeleteRepository("a"))
+
+	_, err := s.Get("a", "x")
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+	_, err = s.GetAlias("a", "latest")
+	assert.ErrorIs(t, err, ErrAliasNotFound)
+	_, err = s.Get("ab", "x")
+	assert.NoError(t, err)
+}
+
+func TestProperties(t *testing.T) {
+	s := newTestStore(t)
+
+	require.NoError(t, s.SetProperties("releases", "app/1.0/app.bin", map[string]string{"build": "41", "env": "prod"}))
+	require.NoError(t, s.SetProperties("releases", "app/1.1/app.bin", map[string]string{"build": "42", "env": "prod"}))
+	require.NoError(t, s.SetProperties("releases-old", "app.bin", map[string]string{"env": "prod"}))
+
+	found, err := s.FindByProperties("releases", map[string]string{"env": "prod"})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "app/1.0/app.bin", found[0].Path)
+
+	found, err = s.FindByProperties("", map[string]string{"env": "prod", "build": "42"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "releases", found[0].Repository)
+	assert.Equal(t, "app/1.1/app.bin", found[0].Path)
+
+	found, err = s.FindByProperties("", map[string]string{"env": "prod"})
+	require.NoError(t, err)
+	assert.Len(t, found, 3)
+
+	// Deleting an artifact drops its properties
+	require.NoError(t, s.Delete("releases", "app/1.0/app.bin"))
+	properties, err := s.GetProperties("releases", "app/1.0/app.bin")
+	require.NoError(t, err)
+	assert.Empty(t, properties)
+}
+
+func TestFindByChecksum(t *testing.T) {
+	s := newTestStore(t)
+
+	sums := checksum.Sums{MD5: "m1", SHA1: "s1", SHA256: "abc"}
+	require.NoError(t, s.Put(&Artifact{Repository: "releases", Path: "app.bin", Sums: sums}))
+	require.NoError(t, s.Put(&Artifact{Repository: "mirror", Path: "copy/app.bin", Sums: sums}))
+	require.NoError(t, s.Put(&Artifact{Repository: "releases", Path: "other.bin", Sums: checksum.Sums{SHA256: "def"}}))
+
+	found, err := s.FindByChecksum("sha256", "abc")
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "mirror", found[0].Repository)
+	assert.Equal(t, "releases", found[1].Repository)
+
+	found, err = s.FindByChecksum("sha1", "s1")
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	found, err = s.FindByChecksum("sha256", "000")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestProvenance(t *testing.T) {
+	s := newTestStore(t)
+
+	p, err := s.GetProvenance("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	require.NoError(t, s.SetProvenance("releases", "app.bin", &provenance.Provenance{Builder: "ci", Commit: "abc123"}))
+	p, err = s.GetProvenance("releases", "app.bin")
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.Equal(t, "abc123", p.Commit)
+
+	require.NoError(t, s.Delete("releases", "app.bin"))
+	p, err = s.GetProvenance("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
+
+func TestSignatures(t *testing.T) {
+	s := newTestStore(t)
+	modTime := time.Now().UTC()
+
+	sig, err := s.GetSignature("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Nil(t, sig)
+
+	require.NoError(t, s.PutSignature(&Signature{Repository: "releases", Path: "app.bin", Size: 10, ModTime: modTime, Key: "releases", Signature: "c2ln\n"}))
+	sig, err = s.GetSignature("releases", "app.bin")
+	require.NoError(t, err)
+	require.NotNil(t, sig)
+	assert.True(t, sig.Matches(10, modTime))
+	assert.False(t, sig.Matches(10, modTime.Add(time.Second)))
+
+	require.NoError(t, s.Delete("releases", "app.bin"))
+	sig, err = s.GetSignature("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Nil(t, sig)
+}
+
+func TestExpiry(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	require.NoError(t, s.SetExpiry("ci", "old.bin", now.Add(-time.Hour)))
+	require.NoError(t, s.SetExpiry("ci", "new.bin", now.Add(time.Hour)))
+
+	expired, err := s.Expired(now)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "old.bin", expired[0].Path)
+
+	expiresAt, err := s.GetExpiry("ci", "new.bin")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.Equal(now.Add(time.Hour)))
+
+	// Clearing the expiry, or deleting the artifact, removes it
+	require.NoError(t, s.SetExpiry("ci", "new.bin", time.Time{}))
+	require.NoError(t, s.Delete("ci", "old.bin"))
+	expired, err = s.Expired(now.Add(24 * time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+}
+
+func TestDownloads(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+
+	require.NoError(t, s.RecordDownload("releases", "app.bin", now.Add(-time.Hour)))
+	require.NoError(t, s.RecordDownload("releases", "app.bin", now))
+	require.NoError(t, s.RecordDownload("releases", "tool.bin", now))
+	require.NoError(t, s.RecordDownload("other", "app.bin", now))
+
+	downloads, err := s.GetDownloads("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), downloads.Count)
+	assert.True(t, downloads.LastDownloaded.Equal(now))
+
+	top, err := s.TopDownloads("releases", 1)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, "app.bin", top[0].Path)
+
+	// Deleting the artifact resets its counter
+	require.NoError(t, s.Delete("releases", "app.bin"))
+	downloads, err = s.GetDownloads("releases", "app.bin")
+	require.NoError(t, err)
+	assert.Zero(t, downloads.Count)
+
+	top, err = s.TopDownloads("releases", 0)
+	require.NoError(t, err)
+	require.Len(t, top, 1)
+	assert.Equal(t, "tool.bin", top[0].Path)
+}
+
+func TestPromotions(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now().UTC()
+
+	require.NoError(t, s.RecordPromotion(&Promotion{Source: "staging", Target: "prod", TargetImage: "app", Digest: "sha256:b", PromotedAt: now}))
+	require.NoError(t, s.RecordPromotion(&Promotion{Source: "staging", Target: "prod", TargetImage: "web", Digest: "sha256:a", PromotedAt: now.Add(-time.Hour)}))
+	require.NoError(t, s.RecordPromotion(&Promotion{Source: "staging", Target: "prod-eu", TargetImage: "app", Digest: "sha256:c"}))
+
+	promotions, err := s.ListPromotions("prod", "")
+	require.NoError(t, err)
+	require.Len(t, promotions, 2)
+	assert.Equal(t, "sha256:a", promotions[0].Digest)
+	assert.Equal(t, "sha256:b", promotions[1].Digest)
+
+	promotions, err = s.ListPromotions("prod", "app")
+	require.NoError(t, err)
+	require.Len(t, promotions, 1)
+	assert.True(t, promotions[0].PromotedAt.Equal(now))
+
+	require.NoError(t, s.DeleteRepository("prod"))
+	promotions, err = s.ListPromotions("prod", "")
+	require.NoError(t, err)
+	assert.Empty(t, promotions)
+}
+
+func TestReleases(t *testing.T) {
+	s := newTestStore(t)
+
+	release := &Release{Repository: "tools", Project: "cli", Version: "1.0.0", Notes: "First release"}
+	require.NoError(t, s.PutRelease(release))
+	created := release.CreatedAt
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, s.PutRelease(&Release{Repository: "tools", Project: "cli", Version: "1.0.0", Title: "1.0", Notes: "Fixed notes"}))
+	require.NoError(t, s.PutRelease(&Release{Repository: "tools", Project: "cli", Version: "1.1.0-rc.1", Prerelease: true}))
+	require.NoError(t, s.PutRelease(&Release{Repository: "tools-old", Project: "cli", Version: "0.9"}))
+
+	got, err := s.GetRelease("tools", "cli", "1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "Fixed notes", got.Notes)
+	assert.True(t, got.CreatedAt.Equal(created))
+
+	releases, err := s.ListReleases("tools")
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+	assert.True(t, releases[1].Prerelease)
+
+	require.NoError(t, s.DeleteRelease("tools", "cli", "1.0.0"))
+	assert.ErrorIs(t, s.DeleteRelease("tools", "cli", "1.0.0"), ErrReleaseNotFound)
+	require.NoError(t, s.DeleteRepository("tools"))
+	_, err = s.GetRelease("tools", "cli", "1.1.0-rc.1")
+	assert.ErrorIs(t, err, ErrReleaseNotFound)
+}
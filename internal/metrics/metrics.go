@@ -0,0 +1,487 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var bucketUsage = []byte("usage")
+
+// Operations requests are grouped by
+const (
+	OpDownload = "download"
+	OpUpload   = "upload"
+	OpDelete   = "delete"
+	OpOther    = "other"
+)
+
+// bucketSize is the resolution at which usage is stored
+const bucketSize = time.Hour
+
+// Counters accumulate the traffic of one repository and operation
+type Counters struct {
+	Requests      int64 `json:"requests"`
+	BytesReceived int64 `json:"bytes_received"`
+	BytesServed   int64 `json:"bytes_served"`
+}
+
+func (c *Counters) add(o *Counters) {
+	c.Requests += o.Requests
+	c.BytesReceived += o.BytesReceived
+	c.BytesServed += o.BytesServed
+}
+
+// Usage is a repository's traffic during the time bucket starting at Start
+type Usage struct {
+	Repository string               `json:"repository"`
+	Start      time.Time            `json:"start"`
+	Operations map[string]*Counters `json:"operations"`
+	Total      Counters             `json:"total"`
+}
+
+func (u *Usage) add(operation string, c *Counters) {
+	if u.Operations[operation] == nil {
+		u.Operations[operation] = &Counters{}
+	}
+	u.Operations[operation].add(c)
+	u.Total.add(c)
+}
+
+// CacheLookups counts the reads of a build cache repository that found an
+// entry and those that did not
+type CacheLookups struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+type series struct {
+	repository string
+	operation  string
+}
+
+// Recorder tracks requests and bytes transferred per repository. Totals
+// since startup are exported for Prometheus; hourly usage is buffered in
+// memory and periodically flushed to the database.
+type Recorder struct {
+	db      *bbolt.DB
+	logger  *logrus.Logger
+	mu      sync.Mutex
+	totals  map[series]*Counters
+	pending map[string]*Usage
+	lookups map[string]*CacheLookups
+	aborted map[series]int64
+	extra   []func(io.Writer)
+}
+
+// NewRecorder creates a recorder that keeps usage history in db
+func NewRecorder(db *bbolt.DB, logger *logrus.Logger) (*Recorder, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUsage)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create usage bucket: %w", err)
+	}
+
+	return &Recorder{
+		db:      db,
+		logger:  logger,
+		totals:  make(map[series]*Counters),
+		pending: make(map[string]*Usage),
+		lookups: make(map[string]*CacheLookups),
+		aborted: make(map[series]int64),
+	}, nil
+}
+
+// Record counts a request to a repository
+func (r *Recorder) Record(repo, operation string, received, served int64, at time.Time) {
+	c := &Counters{Requests: 1, BytesReceived: received, BytesServed: served}
+	start := at.UTC().Truncate(bucketSize)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := series{repository: repo, operation: operation}
+	if r.totals[key] == nil {
+		r.totals[key] = &Counters{}
+	}
+	r.totals[key].add(c)
+
+	k := string(usageKey(repo, start))
+	if r.pending[k] == nil {
+		r.pending[k] = &Usage{Repository: repo, Start: start, Operations: make(map[string]*Counters)}
+	}
+	r.pending[k].add(operation, c)
+}
+
+// RecordCacheLookup counts a read of a build cache repository
+func (r *Recorder) RecordCacheLookup(repo string, hit bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lookups[repo] == nil {
+		r.lookups[repo] = &CacheLookups{}
+	}
+	if hit {
+		r.lookups[repo].Hits++
+	} else {
+		r.lookups[repo].Misses++
+	}
+}
+
+// RecordAbortedUpload counts an upload to a repository that its client
+// gave up on before sending all of it; kind is raw, resumable or blob
+func (r *Recorder) RecordAbortedUpload(repo, kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.aborted[series{repository: repo, operation: kind}]++
+}
+
+// CacheLookups returns the reads of a build cache repository since startup
+func (r *Recorder) CacheLookups(repo string) CacheLookups {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if lookups := r.lookups[repo]; lookups != nil {
+		return *lookups
+	}
+	return CacheLookups{}
+}
+
+// Flush writes buffered usage to the database
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*Usage)
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketUsage)
+		for k, usage := range pending {
+			merged := &Usage{Repository: usage.Repository, Start: usage.Start, Operations: make(map[string]*Counters)}
+			if data := b.Get([]byte(k)); data != nil {
+				if err := json.Unmarshal(data, merged); err != nil {
+					return fmt.Errorf("failed to unmarshal usage %q: %w", k, err)
+				}
+			}
+			for operation, c := range usage.Operations {
+				merged.add(operation, c)
+			}
+			data, err := json.Marshal(merged)
+			if err != nil {
+				return fmt.Errorf("failed to marshal usage: %w", err)
+			}
+			if err := b.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Keep the usage for the next attempt rather than losing it
+		r.mu.Lock()
+		for k, usage := range pending {
+			if current := r.pending[k]; current != nil {
+				for operation, c := range current.Operations {
+					usage.add(operation, c)
+				}
+			}
+			r.pending[k] = usage
+		}
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes usage every interval until ctx is cancelled
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				r.logger.WithError(err).Error("Failed to flush usage metrics")
+			}
+		}
+	}
+}
+
+// Usage returns a repository's usage between from and to, summed into
+// buckets of interval, which must be a multiple of an hour. Buckets without
+// traffic are omitted.
+func (r *Recorder) Usage(repo string, from, to time.Time, interval time.Duration) ([]*Usage, error) {
+	buckets := make(map[time.Time]*Usage)
+	collect := func(usage *Usage) {
+		if usage.Start.Before(from.Truncate(bucketSize)) || !usage.Start.Before(to) {
+			return
+		}
+		start := usage.Start.Truncate(interval)
+		if buckets[start] == nil {
+			buckets[start] = &Usage{Repository: repo, Start: start, Operations: make(map[string]*Counters)}
+		}
+		for operation, c := range usage.Operations {
+			buckets[start].add(operation, c)
+		}
+	}
+
+	prefix := []byte(repo + "\x00")
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketUsage).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var usage Usage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return fmt.Errorf("failed to unmarshal usage %q: %w", k, err)
+			}
+			collect(&usage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	for _, usage := range r.pending {
+		if usage.Repository == repo {
+			collect(usage)
+		}
+	}
+	r.mu.Unlock()
+
+	result := make([]*Usage, 0, len(buckets))
+	for _, usage := range buckets {
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
+
+// DeleteRepository forgets the usage history of a repository
+func (r *Recorder) DeleteRepository(repo string) error {
+	r.mu.Lock()
+	for k, usage := range r.pending {
+		if usage.Repository == repo {
+			delete(r.pending, k)
+		}
+	}
+	for key := range r.totals {
+		if key.repository == repo {
+			delete(r.totals, key)
+		}
+	}
+	for key := range r.aborted {
+		if key.repository == repo {
+			delete(r.aborted, key)
+		}
+	}
+	delete(r.lookups, repo)
+	r.mu.Unlock()
+
+	prefix := []byte(repo + "\x00")
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketUsage).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AddCollector has WritePrometheus also write the metrics of another
+// component, after its own
+func (r *Recorder) AddCollector(write func(io.Writer)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.extra = append(r.extra, write)
+}
+
+// WritePrometheus writes the totals in the Prometheus text exposition format
+func (r *Recorder) WritePrometheus(w io.Writer) {
+	r.mu.Lock()
+	extra := r.extra
+	keys := make([]series, 0, len(r.totals))
+	totals := make(map[series]Counters, len(r.totals))
+	for key, c := range r.totals {
+		keys = append(keys, key)
+		totals[key] = *c
+	}
+	caches := make([]string, 0, len(r.lookups))
+	lookups := make(map[string]CacheLookups, len(r.lookups))
+	for repo, l := range r.lookups {
+		caches = append(caches, repo)
+		lookups[repo] = *l
+	}
+	abortedKeys := make([]series, 0, len(r.aborted))
+	aborted := make(map[series]int64, len(r.aborted))
+	for key, n := range r.aborted {
+		abortedKeys = append(abortedKeys, key)
+		aborted[key] = n
+	}
+	r.mu.Unlock()
+	sort.Strings(caches)
+
+	bySeries := func(keys []series) func(i, j int) bool {
+		return func(i, j int) bool {
+			if keys[i].repository != keys[j].repository {
+				return keys[i].repository < keys[j].repository
+			}
+			return keys[i].operation < keys[j].operation
+		}
+	}
+	sort.Slice(keys, bySeries(keys))
+	sort.Slice(abortedKeys, bySeries(abortedKeys))
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(Counters) int64
+	}{
+		{"depot_repository_requests_total", "Requests handled per repository and operation.", func(c Counters) int64 { return c.Requests }},
+		{"depot_repository_received_bytes_total", "Bytes received in request bodies per repository and operation.", func(c Counters) int64 { return c.BytesReceived }},
+		{"depot_repository_served_bytes_total", "Bytes sent in response bodies per repository and operation.", func(c Counters) int64 { return c.BytesServed }},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s{repository=\"%s\",operation=\"%s\"} %d\n", metric.name, escapeLabel(key.repository), escapeLabel(key.operation), metric.value(totals[key]))
+		}
+	}
+
+	cacheMetrics := []struct {
+		name  string
+		help  string
+		value func(CacheLookups) int64
+	}{
+		{"depot_build_cache_hits_total", "Build cache reads that found an entry per repository.", func(l CacheLookups) int64 { return l.Hits }},
+		{"depot_build_cache_misses_total", "Build cache reads that found no entry per repository.", func(l CacheLookups) int64 { return l.Misses }},
+	}
+	for _, metric := range cacheMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for _, repo := range caches {
+			fmt.Fprintf(w, "%s{repository=\"%s\"} %d\n", metric.name, escapeLabel(repo), metric.value(lookups[repo]))
+		}
+	}
+
+	if len(abortedKeys) > 0 {
+		fmt.Fprintf(w, "# HELP depot_uploads_aborted_total Uploads the client gave up on per repository and kind.\n# TYPE depot_uploads_aborted_total counter\n")
+		for _, key := range abortedKeys {
+			fmt.Fprintf(w, "depot_uploads_aborted_total{repository=\"%s\",kind=\"%s\"} %d\n", escapeLabel(key.repository), escapeLabel(key.operation), aborted[key])
+		}
+	}
+
+	for _, write := range extra {
+		write(w)
+	}
+}
+
+// Handler serves the Prometheus metrics
+func (r *Recorder) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// OperationFor classifies a request by its method
+func OperationFor(method string) string {
+	switch method {
+	case http.MethodGet:
+		return OpDownload
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+		return OpUpload
+	case http.MethodDelete:
+		return OpDelete
+	}
+	return OpOther
+}
+
+// Middleware records every request for which repository returns a
+// repository name, with the bytes read from its body and written in its
+// response
+func (r *Recorder) Middleware(repository func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			repo := repository(req)
+			if repo == "" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			body := &countingReader{ReadCloser: req.Body}
+			if req.Body != nil && req.Body != http.NoBody {
+				req.Body = body
+			}
+			counted := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(counted, req)
+
+			r.Record(repo, OperationFor(req.Method), body.n, counted.n, time.Now())
+		})
+	}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(c.ResponseWriter, r)
+	c.n += n
+	return n, err
+}
+
+func (c *countingWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func usageKey(repo string, start time.Time) []byte {
+	return []byte(repo + "\x00" + start.UTC().Format(time.RFC3339))
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func newTestRecorder(t *testing.T) *Recorder {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "metrics.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	r, err := NewRecorder(db, logrus.New())
+	require.NoError(t, err)
+	return r
+}
+
+func TestUsage(t *testing.T) {
+	r := newTestRecorder(t)
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	r.Record("releases", OpDownload, 0, 100, day.Add(time.Hour+time.Minute))
+	r.Record("releases", OpUpload, 50, 0, day.Add(time.Hour+2*time.Minute))
+	require.NoError(t, r.Flush())
+
+	// Usage recorded after a flush is merged with what was stored
+	r.Record("releases", OpDownload, 0, 200, day.Add(time.Hour+3*time.Minute))
+	r.Record("releases", OpDownload, 0, 300, day.Add(5*time.Hour))
+	r.Record("other", OpDownload, 0, 999, day.Add(time.Hour))
+
+	hourly, err := r.Usage("releases", day, day.Add(24*time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, hourly, 2)
+	assert.Equal(t, day.Add(time.Hour), hourly[0].Start)
+	assert.Equal(t, int64(2), hourly[0].Operations[OpDownload].Requests)
+	assert.Equal(t, int64(300), hourly[0].Operations[OpDownload].BytesServed)
+	assert.Equal(t, int64(50), hourly[0].Operations[OpUpload].BytesReceived)
+	assert.Equal(t, int64(3), hourly[0].Total.Requests)
+
+	require.NoError(t, r.Flush())
+	daily, err := r.Usage("releases", day, day.Add(24*time.Hour), 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, daily, 1)
+	assert.Equal(t, int64(4), daily[0].Total.Requests)
+	assert.Equal(t, int64(600), daily[0].Total.BytesServed)
+
+	window, err := r.Usage("releases", day.Add(2*time.Hour), day.Add(24*time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, window, 1)
+	assert.Equal(t, day.Add(5*time.Hour), window[0].Start)
+
+	require.NoError(t, r.DeleteRepository("releases"))
+	daily, err = r.Usage("releases", day, day.Add(24*time.Hour), 24*time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, daily)
+}
+
+func TestPrometheus(t *testing.T) {
+	r := newTestRecorder(t)
+	r.Record("releases", OpDownload, 0, 100, time.Now())
+	r.Record("releases", OpDownload, 0, 20, time.Now())
+	r.Record(`we"ird`, OpUpload, 7, 0, time.Now())
+
+	var out bytes.Buffer
+	r.WritePrometheus(&out)
+	text := out.String()
+
+	assert.Contains(t, text, "# TYPE depot_repository_requests_total counter\n")
+	assert.Contains(t, text, `depot_repository_requests_total{repository="releases",operation="download"} 2`)
+	assert.Contains(t, text, `depot_repository_served_bytes_total{repository="releases",operation="download"} 120`)
+	assert.Contains(t, text, `depot_repository_received_bytes_total{repository="we\"ird",operation="upload"} 7`)
+}
+
+func TestAbortedUploads(t *testing.T) {
+	r := newTestRecorder(t)
+	var out bytes.Buffer
+	r.WritePrometheus(&out)
+	assert.NotContains(t, out.String(), "depot_uploads_aborted_total")
+
+	r.RecordAbortedUpload("releases", "raw")
+	r.RecordAbortedUpload("releases", "raw")
+	r.RecordAbortedUpload("images", "blob")
+	out.Reset()
+	r.WritePrometheus(&out)
+	assert.Contains(t, out.String(), `depot_uploads_aborted_total{repository="images",kind="blob"} 1`)
+	assert.Contains(t, out.String(), `depot_uploads_aborted_total{repository="releases",kind="raw"} 2`)
+
+	require.NoError(t, r.DeleteRepository("releases"))
+	out.Reset()
+	r.WritePrometheus(&out)
+	assert.NotContains(t, out.String(), `repository="releases",kind="raw"`)
+}
+
+func TestCacheLookups(t *testing.T) {
+	r := newTestRecorder(t)
+	r.RecordCacheLookup("sccache", true)
+	r.RecordCacheLookup("sccache", true)
+	r.RecordCacheLookup("sccache", false)
+	assert.Equal(t, CacheLookups{Hits: 2, Misses: 1}, r.CacheLookups("sccache"))
+
+	var out bytes.Buffer
+	r.WritePrometheus(&out)
+	assert.Contains(t, out.String(), `depot_build_cache_hits_total{repository="sccache"} 2`)
+	assert.Contains(t, out.String(), `depot_build_cache_misses_total{repository="sccache"} 1`)
+
+	require.NoError(t, r.DeleteRepository("sccache"))
+	assert.Equal(t, CacheLookups{}, r.CacheLookups("sccache"))
+}
+
+func TestMiddleware(t *testing.T) {
+	r := newTestRecorder(t)
+	handler := r.Middleware(func(req *http.Request) string {
+		if strings.HasPrefix(req.URL.Path, "/repository/releases/") {
+			return "releases"
+		}
+		return ""
+	})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/repository/releases/a.bin", strings.NewReader("upload")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/repository/releases/a.bin", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/repository/unknown/a.bin", nil))
+
+	usage, err := r.Usage("releases", time.Now().Add(-time.Hour), time.Now().Add(time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	assert.Equal(t, int64(6), usage[0].Operations[OpUpload].BytesReceived)
+	assert.Equal(t, int64(5), usage[0].Operations[OpDownload].BytesServed)
+	assert.Equal(t, int64(2), usage[0].Total.Requests)
+}
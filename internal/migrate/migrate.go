@@ -0,0 +1,183 @@
+// Package migrate versions the schema of the metadata database. Each
+// change to how buckets are laid out is a numbered migration; a database
+// records the version it was migrated to, is brought forward on startup,
+// and is refused by a server older than it.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketSchema = []byte("schema")
+	versionKey   = []byte("version")
+)
+
+// ErrNewerSchema is returned for a database migrated by a newer version of
+// the server, which this one must not touch
+var ErrNewerSchema = errors.New("database schema is newer than this server supports")
+
+// Migration is one change to the database schema. Apply runs in the
+// transaction that records Version, so a migration is either applied
+// completely or not at all.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *bbolt.Tx) error
+}
+
+// Status compares the schema version of a database with the migrations
+// this server knows
+type Status struct {
+	Current int
+	Latest  int
+	Pending []Migration
+}
+
+// UpToDate reports whether no migration is pending
+func (s *Status) UpToDate() bool {
+	return s.Current == s.Latest
+}
+
+// Migrator applies a sequence of migrations, ordered by version
+type Migrator struct {
+	migrations []Migration
+	logger     *logrus.Logger
+}
+
+// New creates a migrator for the server's migrations
+func New(logger *logrus.Logger) *Migrator {
+	return NewWith(migrations, logger)
+}
+
+// NewWith creates a migrator for a sequence of migrations, numbered from 1
+// without gaps
+func NewWith(list []Migration, logger *logrus.Logger) *Migrator {
+	for i, migration := range list {
+		if migration.Version != i+1 {
+			panic(fmt.Sprintf("migration %d is numbered %d", i+1, migration.Version))
+		}
+	}
+	return &Migrator{migrations: list, logger: logger}
+}
+
+// Latest returns the schema version the migrations lead to
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Status reports the schema version of db and the migrations it lacks. A
+// database without a recorded version predates versioning and is at 0.
+func (m *Migrator) Status(db *bbolt.DB) (*Status, error) {
+	status := &Status{Latest: m.Latest()}
+	err := db.View(func(tx *bbolt.Tx) error {
+		current, err := version(tx)
+		status.Current = current
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status.Current > status.Latest {
+		return status, fmt.Errorf("%w: database is at version %d, this server knows up to %d", ErrNewerSchema, status.Current, status.Latest)
+	}
+	status.Pending = m.migrations[status.Current:]
+	return status, nil
+}
+
+// Run brings db forward to the latest version. A new, empty database is
+// marked as up to date without running anything. Before migrating an
+// existing database it is copied next to itself as <file>.v<version>.bak
+// if backup is set, so an upgrade can be undone by restoring the copy. It
+// returns the migrations applied.
+func (m *Migrator) Run(db *bbolt.DB, backup bool) ([]Migration, error) {
+	if empty(db) {
+		return nil, db.Update(func(tx *bbolt.Tx) error {
+			return setVersion(tx, m.Latest())
+		})
+	}
+
+	status, err := m.Status(db)
+	if err != nil {
+		return nil, err
+	}
+	if status.UpToDate() {
+		return nil, nil
+	}
+
+	if backup {
+		path := fmt.Sprintf("%s.v%d.bak", db.Path(), status.Current)
+		if err := db.View(func(tx *bbolt.Tx) error {
+			return tx.CopyFile(path, 0600)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		m.logger.WithField("backup", path).Infof("Backed up database at schema version %d", status.Current)
+	}
+
+	var applied []Migration
+	for _, migration := range status.Pending {
+		start := time.Now()
+		err := db.Update(func(tx *bbolt.Tx) error {
+			if err := migration.Apply(tx); err != nil {
+				return err
+			}
+			return setVersion(tx, migration.Version)
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+		m.logger.WithFields(logrus.Fields{
+			"version":  migration.Version,
+			"duration": time.Since(start),
+		}).Infof("Applied database migration: %s", migration.Description)
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// empty reports whether db has no buckets yet
+func empty(db *bbolt.DB) bool {
+	isEmpty := true
+	db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func([]byte, *bbolt.Bucket) error {
+			isEmpty = false
+			return errStop
+		})
+	})
+	return isEmpty
+}
+
+var errStop = errors.New("stop")
+
+// version returns the schema version recorded in a database
+func version(tx *bbolt.Tx) (int, error) {
+	b := tx.Bucket(bucketSchema)
+	if b == nil {
+		return 0, nil
+	}
+	data := b.Get(versionKey)
+	if data == nil {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", data)
+	}
+	return v, nil
+}
+
+// setVersion records the schema version of a database
+func setVersion(tx *bbolt.Tx, v int) error {
+	b, err := tx.CreateBucketIfNotExists(bucketSchema)
+	if err != nil {
+		return err
+	}
+	return b.Put(versionKey, []byte(strconv.Itoa(v)))
+}
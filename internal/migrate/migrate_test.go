@@ -0,0 +1,129 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func openDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// renameBucket is a migration moving every key of one bucket to another
+func renameBucket(from, to string) func(tx *bbolt.Tx) error {
+	return func(tx *bbolt.Tx) error {
+		old := tx.Bucket([]byte(from))
+		if old == nil {
+			return nil
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(to))
+		if err != nil {
+			return err
+		}
+		if err := old.ForEach(func(k, v []byte) error { return b.Put(k, v) }); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(from))
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := openDB(t)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("artifacts"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("releases/app.tar.gz"), []byte("{}"))
+	}))
+
+	list := []Migration{
+		{Version: 1, Description: "baseline", Apply: func(*bbolt.Tx) error { return nil }},
+		{Version: 2, Description: "rename artifacts", Apply: renameBucket("artifacts", "metadata")},
+	}
+	m := NewWith(list, logrus.New())
+
+	status, err := m.Status(db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Current)
+	assert.Len(t, status.Pending, 2)
+
+	applied, err := m.Run(db, true)
+	require.NoError(t, err)
+	assert.Len(t, applied, 2)
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte("artifacts")))
+		assert.NotNil(t, tx.Bucket([]byte("metadata")).Get([]byte("releases/app.tar.gz")))
+		return nil
+	}))
+	_, err = os.Stat(db.Path() + ".v0.bak")
+	assert.NoError(t, err)
+
+	// Nothing is left to do
+	applied, err = m.Run(db, true)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	// An older server refuses the database
+	_, err = NewWith(list[:1], logrus.New()).Run(db, false)
+	assert.ErrorIs(t, err, ErrNewerSchema)
+}
+
+func TestRunFailure(t *testing.T) {
+	db := openDB(t)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("artifacts"))
+		return err
+	}))
+
+	m := NewWith([]Migration{
+		{Version: 1, Description: "baseline", Apply: func(*bbolt.Tx) error { return nil }},
+		{Version: 2, Description: "broken", Apply: func(tx *bbolt.Tx) error {
+			tx.DeleteBucket([]byte("artifacts"))
+			return errors.New("unexpected data")
+		}},
+	}, logrus.New())
+	applied, err := m.Run(db, false)
+	require.Error(t, err)
+	assert.Len(t, applied, 1)
+
+	// The failed migration left nothing behind
+	status, err := m.Status(db)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Current)
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		assert.NotNil(t, tx.Bucket([]byte("artifacts")))
+		return nil
+	}))
+}
+
+func TestRunEmpty(t *testing.T) {
+	db := openDB(t)
+	m := New(logrus.New())
+	applied, err := m.Run(db, true)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	status, err := m.Status(db)
+	require.NoError(t, err)
+	assert.True(t, status.UpToDate())
+	_, err = os.Stat(db.Path() + ".v0.bak")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNumbering(t *testing.T) {
+	assert.Panics(t, func() {
+		NewWith([]Migration{{Version: 2, Description: "gap"}}, logrus.New())
+	})
+	assert.NotPanics(t, func() { New(logrus.New()) })
+}
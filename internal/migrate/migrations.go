@@ -0,0 +1,14 @@
+package migrate
+
+import "go.etcd.io/bbolt"
+
+// migrations are the changes to the database schema, in order. Add new
+// ones at the end with the next version; never renumber or remove one that
+// has shipped.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "start versioning the database schema",
+		Apply:       func(tx *bbolt.Tx) error { return nil },
+	},
+}
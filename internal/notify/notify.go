@@ -0,0 +1,388 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/cleanup"
+	"github.com/depot/depot/internal/scan"
+)
+
+// Events subscriptions can be notified of
+const (
+	EventArtifactPushed = "artifact.pushed"
+	EventCleanupDeleted = "cleanup.deleted"
+	EventScanInfected   = "scan.infected"
+	EventStorageLow     = "storage.low"
+)
+
+// Targets events are sent to
+const (
+	TargetEmail = "email"
+	TargetSlack = "slack"
+	TargetTeams = "teams"
+	TargetHTTP  = "http"
+)
+
+var (
+	bucketSubscriptions     = []byte("notification_subscriptions")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid subscription")
+
+	events  = []string{EventArtifactPushed, EventCleanupDeleted, EventScanInfected, EventStorageLow}
+	targets = []string{TargetEmail, TargetSlack, TargetTeams, TargetHTTP}
+)
+
+// maxListedPaths bounds the artifacts listed in one notification
+const maxListedPaths = 50
+
+// Subscription sends the events of one repository, or of every repository
+// if Repository is empty, to a target: an email address, a Slack or Teams
+// incoming webhook, or any HTTP endpoint. HTTP targets receive the event
+// as JSON, or rendered with Template, a Go text/template of an Event.
+type Subscription struct {
+	ID          string    `json:"id"`
+	Target      string    `json:"target"`
+	Email       string    `json:"email,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Template    string    `json:"template,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Repository  string    `json:"repository,omitempty"`
+	Events      []string  `json:"events"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Event is something that happened to a repository that subscribers are
+// told about
+type Event struct {
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	Artifacts  []string  `json:"artifacts,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Manager keeps notification subscriptions and sends events to the
+// targets subscribed to them
+type Manager struct {
+	db     *bbolt.DB
+	mailer Mailer
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewManager creates a notification manager that sends email with mailer;
+// with a nil mailer email subscriptions are kept but no email is sent
+func NewManager(db *bbolt.DB, mailer Mailer, logger *logrus.Logger) (*Manager, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSubscriptions)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscriptions bucket: %w", err)
+	}
+
+	return &Manager{
+		db:     db,
+		mailer: mailer,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+	}, nil
+}
+
+// Events returns the events that can be subscribed to
+func Events() []string {
+	return append([]string(nil), events...)
+}
+
+// EmailEnabled reports whether events are sent by email
+func (m *Manager) EmailEnabled() bool {
+	return m.mailer != nil
+}
+
+// Subscribe validates and saves a new subscription. A subscription
+// without a target is an email subscription.
+func (m *Manager) Subscribe(sub *Subscription) error {
+	if sub.Target == "" {
+		sub.Target = TargetEmail
+	}
+	if err := validateTarget(sub); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+	if len(sub.Events) == 0 {
+		return fmt.Errorf("%w: no events, expected some of %s", ErrInvalidSubscription, strings.Join(events, ", "))
+	}
+	for _, event := range sub.Events {
+		if !contains(events, event) {
+			return fmt.Errorf("%w: unknown event %q, expected one of %s", ErrInvalidSubscription, event, strings.Join(events, ", "))
+		}
+	}
+
+	sub.ID = uuid.New().String()
+	sub.CreatedAt = time.Now().UTC()
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(sub)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketSubscriptions).Put([]byte(sub.ID), data)
+	})
+}
+
+// List returns the subscriptions ordered by creation time, only those for
+// repo, including those for every repository, if repo is set
+func (m *Manager) List(repo string) ([]*Subscription, error) {
+	subs := []*Subscription{}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketSubscriptions).ForEach(func(k, v []byte) error {
+			var sub Subscription
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return fmt.Errorf("failed to unmarshal subscription %s: %w", k, err)
+			}
+			if sub.Target == "" {
+				// Saved before there were other targets
+				sub.Target = TargetEmail
+			}
+			if repo == "" || sub.Repository == "" || sub.Repository == repo {
+				subs = append(subs, &sub)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+	return subs, nil
+}
+
+// Unsubscribe deletes a subscription
+func (m *Manager) Unsubscribe(id string) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSubscriptions)
+		if b.Get([]byte(id)) == nil {
+			return ErrSubscriptionNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// Notify sends an event to every target subscribed to it. Notifications
+// are sent in the background so a slow mail server or webhook does not
+// hold up the caller; failures are logged.
+func (m *Manager) Notify(event *Event) {
+	subs, err := m.subscribers(event)
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to read notification subscriptions")
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+	event.Time = time.Now().UTC()
+
+	go func() {
+		// Each target gets its own message so subscribers do not see
+		// each other
+		for _, sub := range subs {
+			if err := m.send(sub, event); err != nil {
+				m.logger.WithError(err).WithFields(logrus.Fields{
+					"event":        event.Type,
+					"repository":   event.Repository,
+					"subscription": sub.ID,
+					"target":       sub.Target,
+				}).Error("Failed to send notification")
+			}
+		}
+	}()
+}
+
+// subscribers returns the subscriptions to an event, one per target.
+// Email subscriptions are left out when no mailer is configured.
+func (m *Manager) subscribers(event *Event) ([]*Subscription, error) {
+	subs, err := m.List(event.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var matching []*Subscription
+	for _, sub := range subs {
+		if !contains(sub.Events, event.Type) || (sub.Target == TargetEmail && m.mailer == nil) {
+			continue
+		}
+		// HTTP subscriptions may render the event differently, so only
+		// identical ones are duplicates
+		key := sub.Target + " " + sub.Email + sub.URL + " " + sub.Template
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		matching = append(matching, sub)
+	}
+	return matching, nil
+}
+
+func (m *Manager) send(sub *Subscription, event *Event) error {
+	switch sub.Target {
+	case TargetEmail:
+		return m.mailer.Send(sub.Email, event.Subject, event.Body)
+	case TargetSlack:
+		return m.post(sub.URL, "application/json", slackMessage(event))
+	case TargetTeams:
+		return m.post(sub.URL, "application/json", teamsMessage(event))
+	case TargetHTTP:
+		body, err := render(sub.Template, event)
+		if err != nil {
+			return err
+		}
+		contentType := sub.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		return m.post(sub.URL, contentType, body)
+	}
+	return fmt.Errorf("unknown target %q", sub.Target)
+}
+
+// ArtifactsPushed notifies subscribers of raw artifacts uploaded or images
+// pushed to a repository
+func (m *Manager) ArtifactsPushed(repo string, artifacts ...string) {
+	subject := fmt.Sprintf("Pushed %s to %s", artifacts[0], repo)
+	if len(artifacts) > 1 {
+		subject = fmt.Sprintf("Pushed %d artifacts to %s", len(artifacts), repo)
+	}
+	m.Notify(&Event{
+		Type:       EventArtifactPushed,
+		Repository: repo,
+		Subject:    subject,
+		Body:       fmt.Sprintf("Pushed to repository %s:\n", repo) + listing(artifacts),
+		Artifacts:  artifacts,
+	})
+}
+
+// CleanupDeleted notifies subscribers of the artifacts or tags an
+// enforcing cleanup run deleted
+func (m *Manager) CleanupDeleted(result *cleanup.Result) {
+	if result.DryRun || result.Deleted == 0 {
+		return
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Cleanup policies deleted %d item(s) from repository %s", result.Deleted, result.Repository)
+	if result.FreedBytes > 0 {
+		fmt.Fprintf(&body, ", freeing %d bytes", result.FreedBytes)
+	}
+	body.WriteString(".\n\nSelected for deletion:\n")
+	deleted := make([]string, len(result.Candidates))
+	for i, candidate := range result.Candidates {
+		deleted[i] = fmt.Sprintf("%s (policy %s: %s)", candidate.Path, candidate.Policy, candidate.Reason)
+	}
+	body.WriteString(listing(deleted))
+
+	m.Notify(&Event{
+		Type:       EventCleanupDeleted,
+		Repository: result.Repository,
+		Subject:    fmt.Sprintf("Cleanup deleted %d item(s) from %s", result.Deleted, result.Repository),
+		Body:       body.String(),
+	})
+}
+
+// UploadInfected notifies subscribers of an upload the virus scanner
+// flagged and quarantined
+func (m *Manager) UploadInfected(item *scan.Item) {
+	m.Notify(&Event{
+		Type:       EventScanInfected,
+		Repository: item.Repository,
+		Subject:    fmt.Sprintf("Infected upload quarantined in %s", item.Repository),
+		Artifacts:  []string{item.Path},
+		Body: fmt.Sprintf("An upload to repository %s was rejected by the virus scanner and quarantined.\n\n"+
+			"Path:       %s\nSize:       %d bytes\nScanner:    %s\nSignature:  %s\nQuarantine: %s\nTime:       %s\n",
+			item.Repository, item.Path, item.Size, item.Scanner, item.Signature, item.ID, item.QuarantinedAt.Format(time.RFC3339)),
+	})
+}
+
+// StorageLow notifies subscribers of uploads to a repository refused as
+// the disk has too little space left for them
+func (m *Manager) StorageLow(repo string, needed, available int64) {
+	m.Notify(&Event{
+		Type:       EventStorageLow,
+		Repository: repo,
+		Subject:    fmt.Sprintf("Upload to %s refused, storage is running out", repo),
+		Body: fmt.Sprintf("An upload to repository %s was refused with 507 Insufficient Storage.\n\n"+
+			"Needed:    %d bytes\nAvailable: %d bytes\n\nFurther refusals are not reported for 15 minutes. "+
+			"Free up space, for instance by emptying the trash or running cleanup policies.\n",
+			repo, needed, available),
+	})
+}
+
+// validateTarget checks that a subscription has what its target needs
+func validateTarget(sub *Subscription) error {
+	switch sub.Target {
+	case TargetEmail:
+		address, err := mail.ParseAddress(sub.Email)
+		if err != nil {
+			return fmt.Errorf("invalid email address %q", sub.Email)
+		}
+		sub.Email = address.Address
+		if sub.URL != "" || sub.Template != "" {
+			return fmt.Errorf("email subscriptions take no url or template")
+		}
+		return nil
+	case TargetSlack, TargetTeams, TargetHTTP:
+		u, err := url.Parse(sub.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook URL %q", sub.URL)
+		}
+		if sub.Email != "" {
+			return fmt.Errorf("%s subscriptions take no email", sub.Target)
+		}
+		if sub.Target != TargetHTTP && (sub.Template != "" || sub.ContentType != "") {
+			return fmt.Errorf("only http subscriptions take a template or content type")
+		}
+		if _, err := parseTemplate(sub.Template); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown target %q, expected one of %s", sub.Target, strings.Join(targets, ", "))
+}
+
+// listing formats items one per line, up to maxListedPaths of them
+func listing(items []string) string {
+	var list strings.Builder
+	for i, item := range items {
+		if i == maxListedPaths {
+			fmt.Fprintf(&list, "  ... and %d more\n", len(items)-maxListedPaths)
+			break
+		}
+		fmt.Fprintf(&list, "  %s\n", item)
+	}
+	return list.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/cleanup"
+	"github.com/depot/depot/internal/scan"
+	"github.com/depot/depot/pkg/models"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type fakeMailer chan sentMail
+
+func (f fakeMailer) Send(to, subject, body string) error {
+	f <- sentMail{to, subject, body}
+	return nil
+}
+
+// receive collects n emails, ordered by recipient
+func (f fakeMailer) receive(t *testing.T, n int) []sentMail {
+	var sent []sentMail
+	for len(sent) < n {
+		select {
+		case mail := <-f:
+			sent = append(sent, mail)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d emails", len(sent), n)
+		}
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].to < sent[j].to })
+	return sent
+}
+
+func TestNotify(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "notify.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	mailer := make(fakeMailer, 10)
+	m, err := NewManager(db, mailer, logrus.New())
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Email: "not an address", Events: []string{EventScanInfected}}), ErrInvalidSubscription)
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Email: "ops@example.com"}), ErrInvalidSubscription)
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Email: "ops@example.com", Events: []string{"quota.exceeded"}}), ErrInvalidSubscription)
+
+	ops := &Subscription{Email: "Ops <ops@example.com>", Events: []string{EventCleanupDeleted, EventScanInfected}}
+	require.NoError(t, m.Subscribe(ops))
+	assert.Equal(t, "ops@example.com", ops.Email)
+	require.NoError(t, m.Subscribe(&Subscription{Email: "ops@example.com", Repository: "releases", Events: []string{EventCleanupDeleted}}))
+	require.NoError(t, m.Subscribe(&Subscription{Email: "dev@example.com", Repository: "releases", Events: []string{EventScanInfected}}))
+	require.NoError(t, m.Subscribe(&Subscription{Email: "qa@example.com", Repository: "nightly", Events: []string{EventCleanupDeleted}}))
+
+	subs, err := m.List("releases")
+	require.NoError(t, err)
+	assert.Len(t, subs, 3)
+	subs, err = m.List("")
+	require.NoError(t, err)
+	assert.Len(t, subs, 4)
+
+	// Dry runs and runs that deleted nothing are not reported
+	m.CleanupDeleted(&cleanup.Result{Repository: "releases", DryRun: true, Deleted: 1})
+	m.CleanupDeleted(&cleanup.Result{Repository: "releases"})
+
+	m.CleanupDeleted(&cleanup.Result{
+		Repository: "releases",
+		Deleted:    1,
+		FreedBytes: 1024,
+		Candidates: []cleanup.Candidate{{Path: "app/1.0/app.jar", Policy: "old", Reason: "older than 30 days"}},
+	})
+	sent := mailer.receive(t, 1)
+	assert.Equal(t, "ops@example.com", sent[0].to)
+	assert.Equal(t, "Cleanup deleted 1 item(s) from releases", sent[0].subject)
+	assert.Contains(t, sent[0].body, "app/1.0/app.jar (policy old: older than 30 days)")
+	assert.Contains(t, sent[0].body, "freeing 1024 bytes")
+
+	m.UploadInfected(&scan.Item{ID: "q1", Repository: "releases", Path: "tool.exe", Signature: "Eicar-Test-Signature"})
+	sent = mailer.receive(t, 2)
+	assert.Equal(t, "dev@example.com", sent[0].to)
+	assert.Equal(t, "ops@example.com", sent[1].to)
+	assert.Contains(t, sent[0].body, "Eicar-Test-Signature")
+
+	require.NoError(t, m.Unsubscribe(ops.ID))
+	assert.ErrorIs(t, m.Unsubscribe(ops.ID), ErrSubscriptionNotFound)
+	m.UploadInfected(&scan.Item{ID: "q2", Repository: "nightly", Path: "tool.exe"})
+	select {
+	case mail := <-mailer:
+		t.Fatalf("unexpected email to %s", mail.to)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMessage(t *testing.T) {
+	msg := string(message("depot@example.com", "ops@example.com", "Cleanup\r\nBcc: victim@example.com", "line one\nline two\n"))
+	header, body, found := strings.Cut(msg, "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, header, "Subject: [depot] Cleanup  Bcc: victim@example.com\r\n")
+	assert.NotContains(t, header, "\r\nBcc:")
+	assert.Equal(t, "line one\r\nline two\r\n", body)
+}
+
+func TestWebhooks(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "notify.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	type request struct {
+		path, contentType, body string
+	}
+	received := make(chan request, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{r.URL.Path, r.Header.Get("Content-Type"), string(body)}
+	}))
+	defer webhook.Close()
+
+	// Without a mailer only webhooks are notified
+	m, err := NewManager(db, nil, logrus.New())
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Target: "pager", URL: webhook.URL, Events: []string{EventArtifactPushed}}), ErrInvalidSubscription)
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Target: TargetSlack, URL: "ftp://example.com", Events: []string{EventArtifactPushed}}), ErrInvalidSubscription)
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Target: TargetSlack, URL: webhook.URL, Template: "x", Events: []string{EventArtifactPushed}}), ErrInvalidSubscription)
+	assert.ErrorIs(t, m.Subscribe(&Subscription{Target: TargetHTTP, URL: webhook.URL, Template: "{{", Events: []string{EventArtifactPushed}}), ErrInvalidSubscription)
+
+	slack := &Subscription{Target: TargetSlack, URL: webhook.URL + "/slack/T000/B000/secret", Repository: "images", Events: []string{EventArtifactPushed}}
+	require.NoError(t, m.Subscribe(slack))
+	assert.Equal(t, webhook.URL+"/"+models.RedactedSecret, slack.Redacted().URL)
+	require.NoError(t, m.Subscribe(&Subscription{Target: TargetTeams, URL: webhook.URL + "/teams", Repository: "images", Events: []string{EventArtifactPushed}}))
+	require.NoError(t, m.Subscribe(&Subscription{
+		Target:      TargetHTTP,
+		URL:         webhook.URL + "/http",
+		Template:    `{"summary": {{json .Subject}}, "count": {{len .Artifacts}}}`,
+		Events:      []string{EventArtifactPushed},
+		Repository:  "images",
+		ContentType: "application/vnd.alerts+json",
+	}))
+	require.NoError(t, m.Subscribe(&Subscription{Target: TargetHTTP, URL: webhook.URL + "/raw", Events: []string{EventScanInfected}}))
+	require.NoError(t, m.Subscribe(&Subscription{Email: "ops@example.com", Events: []string{EventArtifactPushed}}))
+
+	m.ArtifactsPushed("images", "app:1.0")
+	requests := map[string]request{}
+	for len(requests) < 3 {
+		select {
+		case r := <-received:
+			requests[strings.Split(r.path, "/")[1]] = r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of 3 webhook requests", len(requests))
+		}
+	}
+	assert.JSONEq(t, `{"text": "*Pushed app:1.0 to images*\n`+"```"+`Pushed to repository images:\n  app:1.0`+"```"+`"}`, requests["slack"].body)
+	assert.Contains(t, requests["teams"].body, `"title":"Pushed app:1.0 to images"`)
+	assert.Equal(t, "application/vnd.alerts+json", requests["http"].contentType)
+	assert.JSONEq(t, `{"summary": "Pushed app:1.0 to images", "count": 1}`, requests["http"].body)
+
+	m.UploadInfected(&scan.Item{ID: "q1", Repository: "releases", Path: "tool.exe", Signature: "Eicar-Test-Signature"})
+	select {
+	case r := <-received:
+		assert.Equal(t, "/raw", r.path)
+		assert.Equal(t, "application/json", r.contentType)
+		var event Event
+		require.NoError(t, json.Unmarshal([]byte(r.body), &event))
+		assert.Equal(t, EventScanInfected, event.Type)
+		assert.Equal(t, []string{"tool.exe"}, event.Artifacts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook request for the infected upload")
+	}
+}
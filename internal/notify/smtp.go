@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends a plain text email to one address
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP sends email through an SMTP server, upgrading the connection with
+// STARTTLS when the server offers it
+type SMTP struct {
+	address  string
+	from     string
+	username string
+	password string
+}
+
+// NewSMTP creates a mailer that sends from from through the server at
+// address ("host:port"), authenticating with username and password if
+// username is set
+func NewSMTP(address, from, username, password string) (*SMTP, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", address, err)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("an SMTP sender address is required")
+	}
+	return &SMTP{
+		address:  address,
+		from:     from,
+		username: username,
+		password: password,
+	}, nil
+}
+
+// Send sends a plain text message
+func (s *SMTP) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, _ := net.SplitHostPort(s.address)
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	return smtp.SendMail(s.address, auth, s.from, []string{to}, message(s.from, to, subject, body))
+}
+
+// message formats an email with the headers mail servers expect
+func message(from, to, subject, body string) []byte {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[depot] "+headerSafe(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Auto-Submitted: auto-generated\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(msg.String())
+}
+
+// headerSafe keeps a value on one header line
+func headerSafe(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+// webhookTimeout bounds a webhook request, so an unresponsive endpoint
+// does not hold up the notifications after it
+const webhookTimeout = 10 * time.Second
+
+// post sends a notification to a webhook, which must answer with a 2xx
+// status
+func (m *Manager) post(target, contentType string, body []byte) error {
+	resp, err := m.client.Post(target, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// slackMessage formats an event for a Slack incoming webhook
+func slackMessage(event *Event) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n```%s```", event.Subject, strings.TrimRight(event.Body, "\n")),
+	})
+	return data
+}
+
+// teamsMessage formats an event as the message card Microsoft Teams
+// incoming webhooks accept
+func teamsMessage(event *Event) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  event.Subject,
+		"title":    event.Subject,
+		// Teams renders markdown, which needs two spaces to break a line
+		"text": strings.ReplaceAll(strings.TrimRight(event.Body, "\n"), "\n", "  \n"),
+	})
+	return data
+}
+
+// parseTemplate parses the template of an HTTP target. Templates can use
+// json to quote a value, e.g. {"text": {{json .Subject}}}.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// render formats an event for an HTTP target, as JSON without a template
+func render(text string, event *Event) ([]byte, error) {
+	if text == "" {
+		return json.Marshal(event)
+	}
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, event); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// Redacted returns a copy of a subscription without the path and query of
+// its webhook URL, which for Slack and Teams is the webhook's secret
+func (s *Subscription) Redacted() *Subscription {
+	redacted := *s
+	if u, err := url.Parse(s.URL); err == nil && s.URL != "" {
+		redacted.URL = u.Scheme + "://" + u.Host + "/" + models.RedactedSecret
+	}
+	return &redacted
+}
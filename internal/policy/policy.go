@@ -0,0 +1,226 @@
+// Package policy authorizes requests with Open Policy Agent: each request
+// is described by its identity, repository, path and action, and a Rego
+// policy evaluated by an OPA server decides whether it is allowed.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/forwarded"
+)
+
+// DefaultDecision is the document evaluated when none is configured
+const DefaultDecision = "depot/authz/allow"
+
+// decisionTimeout bounds a single policy evaluation
+const decisionTimeout = 5 * time.Second
+
+// Actions a request is classified as
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	ActionAdmin  = "admin"
+)
+
+// ErrUnavailable is returned when OPA could not be asked, in which case
+// requests are refused
+var ErrUnavailable = errors.New("policy engine unavailable")
+
+// Identity is who a request claims to come from. Depot does not verify it:
+// the policy decides what the credentials are worth, for example by
+// verifying a JWT in Token.
+type Identity struct {
+	User    string `json:"user,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Input is the request a policy decides on, sent to OPA as its input
+type Input struct {
+	Identity   Identity `json:"identity"`
+	Action     string   `json:"action"`
+	Repository string   `json:"repository,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	Method     string   `json:"method"`
+	URL        string   `json:"url"`
+}
+
+// Decision is a policy's verdict on a request
+type Decision struct {
+	Allow  bool
+	Reason string
+}
+
+// IdentityOf returns the identity a request presents: the user and
+// password of basic authentication, the password as its token, or a bearer
+// token, and the client address
+func IdentityOf(r *http.Request) Identity {
+	var identity Identity
+	if user, password, ok := r.BasicAuth(); ok {
+		identity.User, identity.Token = user, password
+	} else if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		identity.Token = strings.TrimSpace(token)
+	}
+	identity.Address = forwarded.ClientIP(r)
+	return identity
+}
+
+// ActionOf classifies a request method as a read, write or delete
+func ActionOf(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ActionRead
+	case http.MethodDelete:
+		return ActionDelete
+	default:
+		return ActionWrite
+	}
+}
+
+// Engine asks an OPA server for decisions on requests
+type Engine struct {
+	url      string
+	decision string
+	client   *http.Client
+}
+
+// NewEngine creates an engine evaluating the decision document, a path
+// such as "depot/authz/allow", on the OPA server at serverURL
+func NewEngine(serverURL, decision string) (*Engine, error) {
+	parsed, err := url.Parse(serverURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid OPA URL %q", serverURL)
+	}
+	if decision == "" {
+		decision = DefaultDecision
+	}
+	decision = strings.Trim(strings.ReplaceAll(decision, ".", "/"), "/")
+	if decision == "" {
+		return nil, errors.New("invalid OPA decision path")
+	}
+	return &Engine{
+		url:      strings.TrimSuffix(serverURL, "/"),
+		decision: decision,
+		client:   &http.Client{Timeout: decisionTimeout},
+	}, nil
+}
+
+// Decide evaluates the policy for a request. The decision document is
+// either a boolean or an object with an "allow" boolean and an optional
+// "reason"; an undefined decision denies the request.
+func (e *Engine) Decide(ctx context.Context, input *Input) (*Decision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/v1/data/"+e.decision, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: OPA responded %s", ErrUnavailable, resp.Status)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: invalid OPA response: %v", ErrUnavailable, err)
+	}
+	if len(result.Result) == 0 {
+		return &Decision{Reason: "no policy decision for " + e.decision}, nil
+	}
+
+	var allow bool
+	if err := json.Unmarshal(result.Result, &allow); err == nil {
+		return &Decision{Allow: allow}, nil
+	}
+	var object struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(result.Result, &object); err != nil {
+		return nil, fmt.Errorf("%w: decision %s is neither a boolean nor an object", ErrUnavailable, e.decision)
+	}
+	return &Decision{Allow: object.Allow, Reason: object.Reason}, nil
+}
+
+// Authorize decides on a request for a repository and a path within it,
+// either of which may be empty
+func (e *Engine) Authorize(r *http.Request, repository, artifactPath, action string) (*Decision, error) {
+	return e.Decide(r.Context(), &Input{
+		Identity:   IdentityOf(r),
+		Action:     action,
+		Repository: repository,
+		Path:       artifactPath,
+		Method:     r.Method,
+		URL:        r.URL.Path,
+	})
+}
+
+// LoadPolicies uploads the Rego files in dir to the OPA server as policies
+// named depot/<file>, replacing earlier versions, and returns how many
+// were loaded
+func (e *Engine) LoadPolicies(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.rego"))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read policy: %w", err)
+		}
+		id := "depot/" + strings.TrimSuffix(filepath.Base(file), ".rego")
+		if err := e.putPolicy(id, source); err != nil {
+			return 0, fmt.Errorf("failed to load policy %s: %w", filepath.Base(file), err)
+		}
+	}
+	return len(files), nil
+}
+
+// putPolicy creates or replaces a policy module on the OPA server
+func (e *Engine) putPolicy(id string, source []byte) error {
+	req, err := http.NewRequest(http.MethodPut, e.url+"/v1/policies/"+id, bytes.NewReader(source))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// OPA explains compile errors in the message of its response
+		var reply struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &reply) == nil && reply.Message != "" {
+			return fmt.Errorf("OPA responded %s: %s", resp.Status, reply.Message)
+		}
+		return fmt.Errorf("OPA responded %s", resp.Status)
+	}
+	return nil
+}
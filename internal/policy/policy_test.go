@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOPA answers decisions on depot/authz like a policy that lets anyone
+// read and only alice write, explaining its denials, and records the
+// policies loaded into it
+func fakeOPA(t *testing.T, policies map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/policies/"):
+			source, _ := io.ReadAll(r.Body)
+			if strings.Contains(string(source), "syntax error") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"code":"invalid_parameter","message":"error(s) occurred while compiling module(s)"}`))
+				return
+			}
+			policies[strings.TrimPrefix(r.URL.Path, "/v1/policies/")] = string(source)
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/v1/data/depot/authz/allow":
+			var body struct {
+				Input Input `json:"input"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			allow := body.Input.Action == ActionRead || body.Input.Identity.User == "alice"
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": allow})
+		case r.URL.Path == "/v1/data/depot/authz/decision":
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"allow": false, "reason": "frozen"}})
+		case r.URL.Path == "/v1/data/depot/authz/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDecide(t *testing.T) {
+	opa := fakeOPA(t, map[string]string{})
+
+	engine, err := NewEngine(opa.URL, "")
+	require.NoError(t, err)
+
+	read := httptest.NewRequest(http.MethodGet, "/repository/libs/app.jar", nil)
+	decision, err := engine.Authorize(read, "libs", "app.jar", ActionOf(read.Method))
+	require.NoError(t, err)
+	assert.True(t, decision.Allow)
+
+	write := httptest.NewRequest(http.MethodPut, "/repository/libs/app.jar", nil)
+	decision, err = engine.Authorize(write, "libs", "app.jar", ActionOf(write.Method))
+	require.NoError(t, err)
+	assert.False(t, decision.Allow)
+
+	write.SetBasicAuth("alice", "secret")
+	decision, err = engine.Authorize(write, "libs", "app.jar", ActionOf(write.Method))
+	require.NoError(t, err)
+	assert.True(t, decision.Allow)
+
+	engine, err = NewEngine(opa.URL, "depot.authz.decision")
+	require.NoError(t, err)
+	decision, err = engine.Authorize(read, "libs", "app.jar", ActionRead)
+	require.NoError(t, err)
+	assert.False(t, decision.Allow)
+	assert.Equal(t, "frozen", decision.Reason)
+
+	// An undefined decision denies
+	engine, err = NewEngine(opa.URL, "depot/authz/missing")
+	require.NoError(t, err)
+	decision, err = engine.Authorize(read, "libs", "app.jar", ActionRead)
+	require.NoError(t, err)
+	assert.False(t, decision.Allow)
+
+	engine, err = NewEngine(opa.URL, "depot/authz/broken")
+	require.NoError(t, err)
+	_, err = engine.Authorize(read, "libs", "app.jar", ActionRead)
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	_, err = NewEngine("localhost:8181", "")
+	assert.Error(t, err)
+}
+
+func TestIdentityOf(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.7:51234"
+	r.Header.Set("Authorization", "Bearer eyJhbGciOi")
+	assert.Equal(t, Identity{Token: "eyJhbGciOi", Address: "192.0.2.7"}, IdentityOf(r))
+
+	r.SetBasicAuth("ci", "s3cret")
+	assert.Equal(t, Identity{User: "ci", Token: "s3cret", Address: "192.0.2.7"}, IdentityOf(r))
+}
+
+func TestLoadPolicies(t *testing.T) {
+	policies := make(map[string]string)
+	opa := fakeOPA(t, policies)
+	engine, err := NewEngine(opa.URL, "")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "authz.rego"), []byte("package depot.authz\n\ndefault allow := false\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a policy"), 0644))
+
+	loaded, err := engine.LoadPolicies(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Contains(t, policies["depot/authz"], "package depot.authz")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.rego"), []byte("syntax error"), 0644))
+	_, err = engine.LoadPolicies(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error(s) occurred while compiling module(s)")
+}
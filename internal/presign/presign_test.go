@@ -0,0 +1,64 @@
+package presign
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	signed := signer.Sign("/repository/releases/app 1.0.bin", time.Now().Add(time.Hour))
+
+	assert.NoError(t, signer.Verify(httptest.NewRequest("GET", signed, nil)))
+
+	// Extra query parameters do not invalidate the signature
+	assert.NoError(t, signer.Verify(httptest.NewRequest("GET", signed+"&download=1", nil)))
+
+	tampered := strings.Replace(signed, "app%201.0", "app%202.0", 1)
+	assert.ErrorIs(t, signer.Verify(httptest.NewRequest("GET", tampered, nil)), ErrInvalidSignature)
+
+	other := NewSigner([]byte("other"))
+	assert.ErrorIs(t, other.Verify(httptest.NewRequest("GET", signed, nil)), ErrInvalidSignature)
+
+	expired := signer.Sign("/repository/releases/app.bin", time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, signer.Verify(httptest.NewRequest("GET", expired, nil)), ErrExpired)
+
+	assert.ErrorIs(t, signer.Verify(httptest.NewRequest("GET", "/repository/releases/app.bin", nil)), ErrNotSigned)
+}
+
+func TestMiddleware(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	var verdict error
+	handler := signer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verdict = FromContext(r.Context())
+	}))
+
+	signed := signer.Sign("/repository/releases/app.bin", time.Now().Add(time.Hour))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", signed, nil))
+	assert.NoError(t, verdict)
+
+	// A signed URL only grants downloads
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", signed, nil))
+	assert.ErrorIs(t, verdict, ErrNotSigned)
+
+	assert.ErrorIs(t, FromContext(httptest.NewRequest("GET", signed, nil).Context()), ErrNotSigned)
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+
+	key, err := LoadOrCreateKey(path)
+	require.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	again, err := LoadOrCreateKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, key, again)
+}
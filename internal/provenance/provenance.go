@@ -0,0 +1,272 @@
+// Package provenance records how artifacts were built: the builder, the
+// source repository and commit, the workflow run, and optionally an in-toto
+// attestation such as a SLSA provenance document
+package provenance
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/glob"
+	"github.com/depot/depot/pkg/models"
+)
+
+// Media types of in-toto attestations
+const (
+	MediaTypeStatement = "application/vnd.in-toto+json"
+	MediaTypeDSSE      = "application/vnd.dsse.envelope.v1+json"
+)
+
+// SLSA provenance predicate types
+const (
+	PredicateSLSAv02 = "https://slsa.dev/provenance/v0.2"
+	PredicateSLSAv1  = "https://slsa.dev/provenance/v1"
+)
+
+// ErrNoProvenance is returned when an artifact has no build provenance
+var ErrNoProvenance = errors.New("no build provenance")
+
+// Provenance describes the build of an artifact. Digest is the sha256
+// digest of the artifact it was attached to, as sha256:<hex>.
+type Provenance struct {
+	Builder          string          `json:"builder,omitempty"`
+	SourceRepository string          `json:"source_repository,omitempty"`
+	Commit           string          `json:"commit,omitempty"`
+	WorkflowRunURL   string          `json:"workflow_run_url,omitempty"`
+	Attestation      json.RawMessage `json:"attestation,omitempty"`
+	PredicateType    string          `json:"predicate_type,omitempty"`
+	Digest           string          `json:"digest,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+// Statement is an in-toto attestation statement
+type Statement struct {
+	Type          string          `json:"_type"`
+	Subject       []Subject       `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// Subject is an artifact a statement is about
+type Subject struct {
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+// ParseStatement parses an in-toto statement, either bare or wrapped in a
+// DSSE envelope. Envelope signatures are not verified.
+func ParseStatement(data []byte) (*Statement, error) {
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid attestation: %w", err)
+	}
+	if envelope.Payload != "" {
+		if envelope.PayloadType != MediaTypeStatement {
+			return nil, fmt.Errorf("unsupported attestation payload type %q", envelope.PayloadType)
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation payload encoding: %w", err)
+		}
+		data = payload
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, fmt.Errorf("invalid attestation statement: %w", err)
+	}
+	if !strings.HasPrefix(statement.Type, "https://in-toto.io/Statement/") {
+		return nil, fmt.Errorf("unsupported attestation type %q", statement.Type)
+	}
+	if len(statement.Subject) == 0 {
+		return nil, errors.New("attestation has no subject")
+	}
+	return &statement, nil
+}
+
+// Covers reports whether one of the statement's subjects has digest, given
+// as sha256:<hex>
+func (s *Statement) Covers(digest string) bool {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	for _, subject := range s.Subject {
+		if strings.EqualFold(subject.Digest[algorithm], hex) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromStatement describes the build in a statement's SLSA provenance
+// predicate. Other predicates only carry their type.
+func FromStatement(statement *Statement) *Provenance {
+	p := &Provenance{PredicateType: statement.PredicateType}
+	switch statement.PredicateType {
+	case PredicateSLSAv02:
+		var predicate struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Invocation struct {
+				ConfigSource struct {
+					URI    string            `json:"uri"`
+					Digest map[string]string `json:"digest"`
+				} `json:"configSource"`
+			} `json:"invocation"`
+			Metadata struct {
+				BuildInvocationID string `json:"buildInvocationId"`
+			} `json:"metadata"`
+		}
+		if json.Unmarshal(statement.Predicate, &predicate) == nil {
+			p.Builder = predicate.Builder.ID
+			p.SourceRepository = sourceRepository(predicate.Invocation.ConfigSource.URI)
+			p.Commit = predicate.Invocation.ConfigSource.Digest["sha1"]
+			p.WorkflowRunURL = predicate.Metadata.BuildInvocationID
+		}
+	case PredicateSLSAv1:
+		var predicate struct {
+			BuildDefinition struct {
+				ResolvedDependencies []struct {
+					URI    string            `json:"uri"`
+					Digest map[string]string `json:"digest"`
+				} `json:"resolvedDependencies"`
+			} `json:"buildDefinition"`
+			RunDetails struct {
+				Builder struct {
+					ID string `json:"id"`
+				} `json:"builder"`
+				Metadata struct {
+					InvocationID string `json:"invocationId"`
+				} `json:"metadata"`
+			} `json:"runDetails"`
+		}
+		if json.Unmarshal(statement.Predicate, &predicate) == nil {
+			p.Builder = predicate.RunDetails.Builder.ID
+			p.WorkflowRunURL = predicate.RunDetails.Metadata.InvocationID
+			// The first resolved dependency is the source by convention
+			if deps := predicate.BuildDefinition.ResolvedDependencies; len(deps) > 0 {
+				p.SourceRepository = sourceRepository(deps[0].URI)
+				p.Commit = deps[0].Digest["gitCommit"]
+				if p.Commit == "" {
+					p.Commit = deps[0].Digest["sha1"]
+				}
+			}
+		}
+	}
+	return p
+}
+
+// Complete checks the attestation of provenance attached to an artifact
+// with digest. Build details found in the attestation replace those given
+// alongside it, so the policy is checked against what was attested.
+func (p *Provenance) Complete(digest string) error {
+	p.Digest = digest
+	if len(p.Attestation) == 0 {
+		return nil
+	}
+	statement, err := ParseStatement(p.Attestation)
+	if err != nil {
+		return err
+	}
+	if !statement.Covers(digest) {
+		return fmt.Errorf("attestation subject does not include %s", digest)
+	}
+
+	attested := FromStatement(statement)
+	p.PredicateType = attested.PredicateType
+	for _, field := range []struct{ value, attested *string }{
+		{&p.Builder, &attested.Builder},
+		{&p.SourceRepository, &attested.SourceRepository},
+		{&p.Commit, &attested.Commit},
+		{&p.WorkflowRunURL, &attested.WorkflowRunURL},
+	} {
+		if *field.attested != "" {
+			*field.value = *field.attested
+		}
+	}
+	return nil
+}
+
+// Check reports why provenance does not satisfy policy for the artifact
+// with digest, or nil if it does. Provenance recorded for an earlier
+// version of the artifact does not count.
+func (p *Provenance) Check(policy *models.ProvenancePolicy, digest string) error {
+	if p.Digest != digest {
+		return fmt.Errorf("provenance is for %s, not %s", p.Digest, digest)
+	}
+	if policy.RequireAttestation {
+		if len(p.Attestation) == 0 {
+			return errors.New("no attestation")
+		}
+		statement, err := ParseStatement(p.Attestation)
+		if err != nil {
+			return err
+		}
+		if !statement.Covers(digest) {
+			return fmt.Errorf("attestation subject does not include %s", digest)
+		}
+	}
+	if len(policy.TrustedBuilders) > 0 && !matchAny(policy.TrustedBuilders, p.Builder) {
+		return fmt.Errorf("builder %q is not trusted", p.Builder)
+	}
+	if len(policy.SourceRepositories) > 0 && !matchAny(policy.SourceRepositories, p.SourceRepository) {
+		return fmt.Errorf("source repository %q is not allowed", p.SourceRepository)
+	}
+	return nil
+}
+
+// CheckAny reports whether one of provenances satisfies policy, returning
+// why the last one did not otherwise
+func CheckAny(provenances []*Provenance, policy *models.ProvenancePolicy, digest string) error {
+	err := ErrNoProvenance
+	for _, p := range provenances {
+		if err = p.Check(policy, digest); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ValidatePolicy checks the patterns of a provenance policy
+func ValidatePolicy(policy *models.ProvenancePolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string{}, policy.TrustedBuilders...), policy.SourceRepositories...) {
+		if pattern == "" {
+			return errors.New("provenance policy patterns must not be empty")
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if pattern == value || glob.Match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceRepository turns a SLSA source URI such as
+// git+https://github.com/org/repo@refs/heads/main into the repository URL
+func sourceRepository(uri string) string {
+	uri = strings.TrimPrefix(uri, "git+")
+	_, rest, _ := strings.Cut(uri, "://")
+	if start := strings.Index(rest, "/"); start >= 0 {
+		if i := strings.LastIndex(rest[start:], "@"); i >= 0 {
+			uri = uri[:len(uri)-len(rest)+start+i]
+		}
+	}
+	return strings.TrimSuffix(uri, ".git")
+}
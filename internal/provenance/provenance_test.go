@@ -0,0 +1,71 @@
+package provenance
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+const digest = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func slsaStatement(subject string) string {
+	return fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"name":"app.bin","digest":{"sha256":%q}}],`+
+		`"predicateType":"https://slsa.dev/provenance/v1","predicate":{`+
+		`"buildDefinition":{"resolvedDependencies":[{"uri":"git+https://github.com/example/app@refs/heads/main","digest":{"gitCommit":"abc123"}}]},`+
+		`"runDetails":{"builder":{"id":"https://github.com/actions/runner"},"metadata":{"invocationId":"https://github.com/example/app/actions/runs/1"}}}}`, subject)
+}
+
+func TestComplete(t *testing.T) {
+	p := &Provenance{Builder: "claimed", Attestation: []byte(slsaStatement(digest[len("sha256:"):]))}
+	require.NoError(t, p.Complete(digest))
+	assert.Equal(t, "https://github.com/actions/runner", p.Builder)
+	assert.Equal(t, "https://github.com/example/app", p.SourceRepository)
+	assert.Equal(t, "abc123", p.Commit)
+	assert.Equal(t, "https://github.com/example/app/actions/runs/1", p.WorkflowRunURL)
+	assert.Equal(t, PredicateSLSAv1, p.PredicateType)
+	assert.Equal(t, digest, p.Digest)
+
+	// The attestation must be about the artifact
+	p = &Provenance{Attestation: []byte(slsaStatement("0000"))}
+	assert.Error(t, p.Complete(digest))
+
+	// DSSE envelopes are unwrapped
+	envelope := fmt.Sprintf(`{"payloadType":%q,"payload":%q,"signatures":[]}`, MediaTypeStatement,
+		base64.StdEncoding.EncodeToString([]byte(slsaStatement(digest[len("sha256:"):]))))
+	p = &Provenance{Attestation: []byte(envelope)}
+	require.NoError(t, p.Complete(digest))
+	assert.Equal(t, "abc123", p.Commit)
+
+	p = &Provenance{Attestation: []byte(`{"_type":"other"}`)}
+	assert.Error(t, p.Complete(digest))
+}
+
+func TestCheck(t *testing.T) {
+	p := &Provenance{Builder: "https://github.com/actions/runner", SourceRepository: "https://github.com/example/app", Digest: digest}
+
+	assert.NoError(t, p.Check(&models.ProvenancePolicy{}, digest))
+	assert.NoError(t, p.Check(&models.ProvenancePolicy{
+		TrustedBuilders:    []string{"https://github.com/actions/runner"},
+		SourceRepositories: []string{"https://github.com/example/**"},
+	}, digest))
+	assert.Error(t, p.Check(&models.ProvenancePolicy{TrustedBuilders: []string{"https://ci.example.com/*"}}, digest))
+	assert.Error(t, p.Check(&models.ProvenancePolicy{SourceRepositories: []string{"https://github.com/other/**"}}, digest))
+	assert.Error(t, p.Check(&models.ProvenancePolicy{RequireAttestation: true}, digest))
+
+	// Provenance of an earlier version of the artifact does not count
+	assert.Error(t, p.Check(&models.ProvenancePolicy{}, "sha256:0000"))
+
+	assert.ErrorIs(t, CheckAny(nil, &models.ProvenancePolicy{}, digest), ErrNoProvenance)
+	assert.NoError(t, CheckAny([]*Provenance{{Digest: "sha256:0000"}, p}, &models.ProvenancePolicy{}, digest))
+}
+
+func TestSourceRepository(t *testing.T) {
+	assert.Equal(t, "https://github.com/example/app", sourceRepository("git+https://github.com/example/app@refs/heads/main"))
+	assert.Equal(t, "https://github.com/example/app", sourceRepository("git+https://github.com/example/app.git"))
+	assert.Equal(t, "https://git@github.com/example/app", sourceRepository("https://git@github.com/example/app"))
+}
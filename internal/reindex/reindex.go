@@ -0,0 +1,341 @@
+package reindex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+// DefaultDockerRepository names the Docker repository created for images
+// found in storage when no Docker repository exists
+const DefaultDockerRepository = "docker"
+
+// Options controls a rebuild
+type Options struct {
+	// DryRun reports what would be recreated without writing anything
+	DryRun bool
+
+	// VerifyBlobs hashes every Docker blob and reports those that do not
+	// match their digest
+	VerifyBlobs bool
+
+	// DockerRepository names the repository created for images no
+	// repository claims; DefaultDockerRepository if empty
+	DockerRepository string
+}
+
+// Report describes what a rebuild found and recreated
+type Report struct {
+	RepositoriesCreated []string              `json:"repositories_created"`
+	Artifacts           int                   `json:"artifacts"`
+	ArtifactsIndexed    int                   `json:"artifacts_indexed"`
+	Images              []*docker.StoredImage `json:"images"`
+	Manifests           int                   `json:"manifests"`
+	Tags                int                   `json:"tags"`
+	Blobs               int                   `json:"blobs"`
+	Problems            []string              `json:"problems"`
+}
+
+// Rebuilder reconstructs database metadata from the storage tree, the
+// recovery path for a lost or corrupted database. Top-level directories
+// holding raw files become raw repositories whose artifact checksums are
+// recomputed; directories holding only image manifests, blobs and tags
+// are verified. Docker repositories storage records images for are
+// recreated on the main port, and images no repository claims are
+// recorded for a new one.
+type Rebuilder struct {
+	root         string
+	storage      storage.Storage
+	repositories *repository.Manager
+	metadata     *metadata.Store
+	logger       *logrus.Logger
+}
+
+// New creates a Rebuilder for the storage tree at root
+func New(root string, store storage.Storage, repositories *repository.Manager, meta *metadata.Store, logger *logrus.Logger) *Rebuilder {
+	return &Rebuilder{
+		root:         root,
+		storage:      store,
+		repositories: repositories,
+		metadata:     meta,
+		logger:       logger,
+	}
+}
+
+// Rebuild scans the storage tree and recreates missing repositories and
+// artifact metadata. Existing records are kept.
+func (r *Rebuilder) Rebuild(opts Options) (*Report, error) {
+	if opts.DockerRepository == "" {
+		opts.DockerRepository = DefaultDockerRepository
+	}
+	report := &Report{RepositoriesCreated: []string{}, Images: []*docker.StoredImage{}, Problems: []string{}}
+
+	entries, err := os.ReadDir(r.root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		// Hidden directories are internal namespaces, such as the probes of
+		// the diagnostics endpoint
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		files, err := r.storage.List(name, "")
+		if err != nil {
+			return nil, err
+		}
+		images, raw := splitImages(files)
+
+		for _, image := range images {
+			imageName := path.Join(name, image)
+			stored, err := docker.ReadImage(r.storage, imageName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image %s: %w", imageName, err)
+			}
+			if opts.VerifyBlobs {
+				r.verifyBlobs(stored)
+			}
+			report.addImage(stored)
+		}
+
+		// Directories of images that also hold other files are raw
+		// repositories, as they were stored that way
+		if len(raw) > 0 || len(images) == 0 {
+			if err := r.rebuildRaw(name, raw, opts, report); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := r.rebuildDocker(opts, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// rebuildDocker recreates the Docker repositories that storage records
+// images for, which load them when their registries start, and records the
+// images no repository claims for opts.DockerRepository
+func (r *Rebuilder) rebuildDocker(opts Options, report *Report) error {
+	claims, err := docker.ImageClaims(r.storage)
+	if err != nil {
+		return fmt.Errorf("failed to list recorded images: %w", err)
+	}
+	names := make([]string, 0, len(claims))
+	claimed := make(map[string]bool)
+	for name, images := range claims {
+		names = append(names, name)
+		for _, image := range images {
+			claimed[image] = true
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := r.createDocker(name, opts, report); err != nil {
+			return err
+		}
+	}
+
+	var unclaimed []string
+	for _, image := range report.Images {
+		if !claimed[image.Name] && len(image.Manifests) > 0 {
+			unclaimed = append(unclaimed, image.Name)
+		}
+	}
+	if len(unclaimed) == 0 {
+		return nil
+	}
+	ok, err := r.createDocker(opts.DockerRepository, opts, report)
+	if err != nil || !ok || opts.DryRun {
+		return err
+	}
+	for _, image := range unclaimed {
+		if err := docker.ClaimImage(r.storage, opts.DockerRepository, image); err != nil {
+			return fmt.Errorf("failed to record image %s: %w", image, err)
+		}
+	}
+	r.logger.WithField("repository", opts.DockerRepository).Infof("Recorded %d recovered images", len(unclaimed))
+	return nil
+}
+
+// createDocker creates a Docker repository on the main port unless one of
+// that name exists, reporting false if the name is taken by a repository
+// of another type
+func (r *Rebuilder) createDocker(name string, opts Options, report *Report) (bool, error) {
+	if repo, err := r.repositories.Get(name); err == nil {
+		if repo.Type != models.RepositoryTypeDocker {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: images are recorded for it, but it is a %s repository", name, repo.Type))
+			return false, nil
+		}
+		return true, nil
+	}
+
+	config, _ := json.Marshal(&models.DockerRepositoryConfig{})
+	repo := &models.Repository{
+		Name:        name,
+		Type:        models.RepositoryTypeDocker,
+		Description: "Recovered from storage",
+		Config:      config,
+	}
+	return true, r.create(repo, opts, report)
+}
+
+// rebuildRaw recreates a raw repository and the metadata of its files
+func (r *Rebuilder) rebuildRaw(name string, files []storage.FileInfo, opts Options, report *Report) error {
+	repo := &models.Repository{Name: name, Type: models.RepositoryTypeRaw, Description: "Recovered from storage"}
+	if err := r.create(repo, opts, report); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		report.Artifacts++
+		if artifact, err := r.metadata.Get(name, file.Path); err == nil && artifact.Matches(file.Size, file.ModTime) {
+			continue
+		}
+		report.ArtifactsIndexed++
+		if opts.DryRun {
+			continue
+		}
+
+		sums, err := r.checksums(name, file.Path)
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s/%s: %v", name, file.Path, err))
+			continue
+		}
+		artifact := &metadata.Artifact{
+			Repository: name,
+			Path:       file.Path,
+			Size:       file.Size,
+			ModTime:    file.ModTime,
+			Sums:       sums,
+		}
+		if err := r.metadata.Put(artifact); err != nil {
+			return fmt.Errorf("failed to record metadata for %s/%s: %w", name, file.Path, err)
+		}
+	}
+	return nil
+}
+
+// create creates a repository unless one of that name exists
+func (r *Rebuilder) create(repo *models.Repository, opts Options, report *Report) error {
+	if _, err := r.repositories.Get(repo.Name); err == nil {
+		return nil
+	} else if !errors.Is(err, repository.ErrRepositoryNotFound) {
+		return err
+	}
+
+	report.RepositoriesCreated = append(report.RepositoriesCreated, repo.Name)
+	if opts.DryRun {
+		return nil
+	}
+	if err := r.repositories.Create(repo); err != nil {
+		return fmt.Errorf("failed to create repository %s: %w", repo.Name, err)
+	}
+	r.logger.WithFields(logrus.Fields{
+		"repository": repo.Name,
+		"type":       repo.Type,
+	}).Info("Recreated repository from storage")
+	return nil
+}
+
+// verifyBlobs reports the blobs of an image whose content does not match
+// their digest
+func (r *Rebuilder) verifyBlobs(image *docker.StoredImage) {
+	digests := make([]string, 0, len(image.Blobs))
+	for digest := range image.Blobs {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+
+	for _, digest := range digests {
+		sums, err := r.checksums(image.Name, path.Join("blobs", digest))
+		if err != nil {
+			image.Problems = append(image.Problems, fmt.Sprintf("blob %s: %v", digest, err))
+			continue
+		}
+		if "sha256:"+sums.SHA256 != digest {
+			image.Problems = append(image.Problems, fmt.Sprintf("blob %s does not match its digest", digest))
+		}
+	}
+}
+
+func (r *Rebuilder) checksums(repo, filePath string) (checksum.Sums, error) {
+	file, err := r.storage.Retrieve(repo, filePath)
+	if err != nil {
+		return checksum.Sums{}, err
+	}
+	defer file.Close()
+
+	reader := checksum.NewReader(file, nil)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return checksum.Sums{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	return reader.Sums(), nil
+}
+
+func (report *Report) addImage(image *docker.StoredImage) {
+	report.Images = append(report.Images, image)
+	report.Manifests += len(image.Manifests)
+	report.Tags += len(image.Tags)
+	report.Blobs += len(image.Blobs)
+	for _, problem := range image.Problems {
+		report.Problems = append(report.Problems, image.Name+": "+problem)
+	}
+}
+
+// splitImages separates the images found among a directory's files, given
+// as paths relative to it, from the other files
+func splitImages(files []storage.FileInfo) ([]string, []storage.FileInfo) {
+	images := make(map[string]bool)
+	for _, file := range files {
+		if image, ok := docker.IsImagePath(file.Path); ok {
+			images[image] = true
+		}
+	}
+
+	var raw []storage.FileInfo
+	for _, file := range files {
+		if !inImage(file.Path, images) {
+			raw = append(raw, file)
+		}
+	}
+
+	names := make([]string, 0, len(images))
+	for image := range images {
+		names = append(names, image)
+	}
+	sort.Strings(names)
+	return names, raw
+}
+
+// inImage reports whether a file is a manifest, blob or tag of one of images
+func inImage(filePath string, images map[string]bool) bool {
+	dir := path.Dir(filePath)
+	switch path.Base(dir) {
+	case "manifests", "blobs", "tags":
+	default:
+		return false
+	}
+	image := path.Dir(dir)
+	if image == "." {
+		image = ""
+	}
+	return images[image]
+}
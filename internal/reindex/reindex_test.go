@@ -0,0 +1,116 @@
+package reindex
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/pkg/models"
+)
+
+func digest(data string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+}
+
+func TestRebuild(t *testing.T) {
+	root := t.TempDir()
+	store := storage.NewFileStorage(root)
+
+	// A raw repository, including a directory that only looks like an image
+	require.NoError(t, store.Store("releases", "app/v1/app.tar.gz", strings.NewReader("release")))
+	require.NoError(t, store.Store("releases", "deploy/manifests/service.yaml", strings.NewReader("kind: Service")))
+
+	// Internal namespaces are not repositories
+	require.NoError(t, store.Store(".diagnostics", "probe", strings.NewReader("probe")))
+
+	// An image with one tagged manifest and a corrupt blob
+	layer := "layer"
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"layers":[{"size":5,"digest":%q}]}`, digest(layer))
+	require.NoError(t, store.Store("library/app", "blobs/"+digest(layer), strings.NewReader("LAYER")))
+	require.NoError(t, store.Store("library/app", "manifests/"+digest(manifest), strings.NewReader(manifest)))
+	require.NoError(t, store.Store("library/app", "tags/latest", strings.NewReader(digest(manifest))))
+
+	// An image storage records as pushed to a repository of its own
+	web := `{"schemaVersion":2,"layers":[]}`
+	require.NoError(t, store.Store("library/web", "manifests/"+digest(web), strings.NewReader(web)))
+	require.NoError(t, store.Store("library/web", "tags/1.0", strings.NewReader(digest(web))))
+	require.NoError(t, docker.ClaimImage(store, "mirror", "library/web"))
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "depot.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	logger := logrus.New()
+	repositories := repository.NewManager(db, store, logger)
+	meta := metadata.NewStore(db)
+	rebuilder := New(root, store, repositories, meta, logger)
+
+	t.Run("Dry run", func(t *testing.T) {
+		report, err := rebuilder.Rebuild(Options{DryRun: true})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"releases", "docker", "mirror"}, report.RepositoriesCreated)
+		assert.Equal(t, 2, report.ArtifactsIndexed)
+
+		repos, err := repositories.List()
+		require.NoError(t, err)
+		assert.Empty(t, repos)
+	})
+
+	t.Run("Rebuild", func(t *testing.T) {
+		report, err := rebuilder.Rebuild(Options{VerifyBlobs: true})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"releases", "docker", "mirror"}, report.RepositoriesCreated)
+		assert.Equal(t, 2, report.Artifacts)
+		assert.Equal(t, 2, report.Manifests)
+		assert.Equal(t, 2, report.Tags)
+		require.Len(t, report.Images, 2)
+		assert.Equal(t, "library/app", report.Images[0].Name)
+		assert.Equal(t, []string{"library/app: blob " + digest(layer) + " does not match its digest"}, report.Problems)
+
+		repo, err := repositories.Get("releases")
+		require.NoError(t, err)
+		assert.Equal(t, models.RepositoryTypeRaw, repo.Type)
+
+		artifact, err := meta.Get("releases", "app/v1/app.tar.gz")
+		require.NoError(t, err)
+		assert.Equal(t, strings.TrimPrefix(digest("release"), "sha256:"), artifact.SHA256)
+		_, err = meta.Get("releases", "deploy/manifests/service.yaml")
+		assert.NoError(t, err)
+
+		// The registries of the recovered repositories load their images
+		manager := docker.NewManager(store, nil, logger)
+		defer manager.StopAll()
+		for name, image := range map[string]string{"docker": "library/app:latest", "mirror": "library/web:1.0"} {
+			repo, err := repositories.Get(name)
+			require.NoError(t, err)
+			assert.Equal(t, models.RepositoryTypeDocker, repo.Type)
+			require.NoError(t, manager.StartRegistry(repo, &models.DockerRepositoryConfig{}))
+			registry, _ := manager.GetRegistry(name)
+			image, tag, _ := strings.Cut(image, ":")
+			w := httptest.NewRecorder()
+			registry.GetRouter().ServeHTTP(w, httptest.NewRequest("GET", "/v2/"+image+"/manifests/"+tag, nil))
+			assert.Equal(t, http.StatusOK, w.Code, name)
+		}
+	})
+
+	t.Run("Idempotent", func(t *testing.T) {
+		report, err := rebuilder.Rebuild(Options{})
+		require.NoError(t, err)
+		assert.Empty(t, report.RepositoriesCreated)
+		assert.Equal(t, 2, report.Artifacts)
+		assert.Zero(t, report.ArtifactsIndexed)
+	})
+}
@@ -0,0 +1,107 @@
+// Package releases orders the versions of releases published to raw
+// repositories.
+package releases
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compare orders two versions, returning -1, 0 or 1. Versions are compared
+// as semantic versions where they look like them: a leading "v" and build
+// metadata after "+" are ignored, dot-separated parts compare numerically
+// if both are numbers and as text otherwise, and a prerelease such as
+// 1.0.0-rc.1 comes before its release. Other versions still compare in a
+// natural order, so 1.10 comes after 1.9.
+func Compare(a, b string) int {
+	coreA, preA := split(a)
+	coreB, preB := split(b)
+	if c := compareParts(coreA, coreB); c != 0 {
+		return c
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return compareParts(preA, preB)
+}
+
+// IsPrerelease reports whether a version names a prerelease, such as
+// 2.0.0-beta.1
+func IsPrerelease(version string) bool {
+	_, pre := split(version)
+	return pre != ""
+}
+
+// split separates a version into its core and prerelease parts. Only a
+// dotted numeric core takes a prerelease, so a date such as 2024-01-15 is
+// a version of its own.
+func split(version string) (string, string) {
+	version, _, _ = strings.Cut(version, "+")
+	if len(version) > 1 && (version[0] == 'v' || version[0] == 'V') && version[1] >= '0' && version[1] <= '9' {
+		version = version[1:]
+	}
+	core, pre, found := strings.Cut(version, "-")
+	if !found || !numeric(core) {
+		return version, ""
+	}
+	return core, pre
+}
+
+// numeric reports whether a version core is numbers separated by dots, such
+// as 1.0 or 2.0.0
+func numeric(core string) bool {
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// compareParts compares dot-separated parts in turn; a version with more
+// parts comes after one it extends
+func compareParts(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		if c := comparePart(partsA[i], partsB[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(partsA) < len(partsB):
+		return -1
+	case len(partsA) > len(partsB):
+		return 1
+	}
+	return 0
+}
+
+// comparePart compares numbers numerically, which come before text
+func comparePart(a, b string) int {
+	numA, errA := strconv.ParseUint(a, 10, 64)
+	numB, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if numA < numB {
+			return -1
+		}
+		if numA > numB {
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
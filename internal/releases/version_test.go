@@ -0,0 +1,29 @@
+package releases
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	versions := []string{"1.10.0", "v1.2.0", "1.9.1", "2.0.0-rc.1", "2.0.0", "2.0.0-beta.2", "2.0.0-beta.11", "1.9", "0.1.0"}
+	sort.Slice(versions, func(i, j int) bool { return Compare(versions[i], versions[j]) < 0 })
+	assert.Equal(t, []string{"0.1.0", "v1.2.0", "1.9", "1.9.1", "1.10.0", "2.0.0-beta.2", "2.0.0-beta.11", "2.0.0-rc.1", "2.0.0"}, versions)
+
+	assert.Equal(t, 0, Compare("v1.0.0", "1.0.0"))
+	assert.Equal(t, 0, Compare("1.0.0+build.5", "1.0.0"))
+	assert.Equal(t, 1, Compare("2024.03.01", "2024.02.28"))
+	assert.Equal(t, -1, Compare("1.0.alpha", "1.0.beta"))
+	assert.Equal(t, 1, Compare("2024-02-01", "2024-01-15"))
+}
+
+func TestIsPrerelease(t *testing.T) {
+	assert.True(t, IsPrerelease("v2.0.0-rc.1"))
+	assert.True(t, IsPrerelease("1.0-SNAPSHOT"))
+	assert.False(t, IsPrerelease("2.0.0"))
+	assert.False(t, IsPrerelease("2.0.0+linux"))
+	assert.False(t, IsPrerelease("2024-01-15"))
+	assert.False(t, IsPrerelease("build-42"))
+}
@@ -26,7 +26,10 @@ type Manager struct {
 
 func NewManager(db *bbolt.DB, storage storage.Storage, logger *logrus.Logger) *Manager {
 	db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketRepositories)
+		if _, err := tx.CreateBucketIfNotExists(bucketRepositories); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(bucketNamespaces)
 		return err
 	})
 
@@ -47,6 +50,9 @@ func (m *Manager) Create(repo *models.Repository) error {
 		if b.Get([]byte(repo.Name)) != nil {
 			return ErrRepositoryExists
 		}
+		if err := checkNamespace(tx, repo.Namespace); err != nil {
+			return err
+		}
 
 		data, err := json.Marshal(repo)
 		if err != nil {
@@ -101,6 +107,28 @@ func (m *Manager) List() ([]*models.Repository, error) {
 	return repos, nil
 }
 
+func (m *Manager) Update(repo *models.Repository) error {
+	repo.UpdatedAt = time.Now()
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketRepositories)
+
+		if b.Get([]byte(repo.Name)) == nil {
+			return ErrRepositoryNotFound
+		}
+		if err := checkNamespace(tx, repo.Namespace); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(repo)
+		if err != nil {
+			return fmt.Errorf("failed to marshal repository: %w", err)
+		}
+
+		return b.Put([]byte(repo.Name), data)
+	})
+}
+
 func (m *Manager) Delete(name string) error {
 	return m.db.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket(bucketRepositories)
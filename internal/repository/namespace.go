@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+var (
+	bucketNamespaces     = []byte("namespaces")
+	ErrNamespaceExists   = errors.New("namespace already exists")
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	ErrNamespaceNotEmpty = errors.New("namespace has repositories")
+)
+
+// CreateNamespace saves a new namespace
+func (m *Manager) CreateNamespace(ns *models.Namespace) error {
+	ns.CreatedAt = time.Now()
+	ns.UpdatedAt = ns.CreatedAt
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketNamespaces)
+		if b.Get([]byte(ns.Name)) != nil {
+			return ErrNamespaceExists
+		}
+		return putNamespace(b, ns)
+	})
+}
+
+// GetNamespace returns a namespace
+func (m *Manager) GetNamespace(name string) (*models.Namespace, error) {
+	var ns models.Namespace
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketNamespaces).Get([]byte(name))
+		if data == nil {
+			return ErrNamespaceNotFound
+		}
+		return json.Unmarshal(data, &ns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ns, nil
+}
+
+// ListNamespaces returns the namespaces ordered by name
+func (m *Manager) ListNamespaces() ([]*models.Namespace, error) {
+	namespaces := []*models.Namespace{}
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketNamespaces).ForEach(func(k, v []byte) error {
+			var ns models.Namespace
+			if err := json.Unmarshal(v, &ns); err != nil {
+				return fmt.Errorf("failed to unmarshal namespace %s: %w", k, err)
+			}
+			namespaces = append(namespaces, &ns)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// UpdateNamespace saves changes to an existing namespace
+func (m *Manager) UpdateNamespace(ns *models.Namespace) error {
+	ns.UpdatedAt = time.Now()
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketNamespaces)
+		if b.Get([]byte(ns.Name)) == nil {
+			return ErrNamespaceNotFound
+		}
+		return putNamespace(b, ns)
+	})
+}
+
+// DeleteNamespace deletes a namespace that no repository belongs to
+func (m *Manager) DeleteNamespace(name string) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketNamespaces)
+		if b.Get([]byte(name)) == nil {
+			return ErrNamespaceNotFound
+		}
+
+		err := tx.Bucket(bucketRepositories).ForEach(func(k, v []byte) error {
+			var repo models.Repository
+			if err := json.Unmarshal(v, &repo); err != nil {
+				return fmt.Errorf("failed to unmarshal repository %s: %w", k, err)
+			}
+			if repo.Namespace == name {
+				return ErrNamespaceNotEmpty
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+func putNamespace(b *bbolt.Bucket, ns *models.Namespace) error {
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("failed to marshal namespace: %w", err)
+	}
+	return b.Put([]byte(ns.Name), data)
+}
+
+// checkNamespace checks, in the transaction saving a repository, that the
+// namespace it belongs to exists
+func checkNamespace(tx *bbolt.Tx, name string) error {
+	if name != "" && tx.Bucket(bucketNamespaces).Get([]byte(name)) == nil {
+		return ErrNamespaceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header carrying the request ID
+const Header = "X-Request-ID"
+
+const maxLength = 128
+
+type contextKey struct{}
+
+// Middleware assigns every request an ID, reusing a well-formed ID supplied
+// by the client or an upstream proxy. The ID is stored in the request context
+// and echoed in the response header before the handler runs.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if id == "" {
+			id = r.Header.Get(Header)
+			if !valid(id) {
+				id = uuid.New().String()
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+		}
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromContext returns the request ID stored by Middleware, if any
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
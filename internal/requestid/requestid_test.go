@@ -0,0 +1,53 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+
+	t.Run("Generated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.NotEmpty(t, seen)
+		assert.Equal(t, seen, w.Header().Get(Header))
+	})
+
+	t.Run("Propagated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(Header, "client-supplied-id")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, "client-supplied-id", seen)
+		assert.Equal(t, "client-supplied-id", w.Header().Get(Header))
+	})
+
+	t.Run("Invalid Replaced", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(Header, strings.Repeat("x", 500))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.NotEqual(t, strings.Repeat("x", 500), seen)
+		assert.Equal(t, seen, w.Header().Get(Header))
+	})
+
+	t.Run("Nested Middleware Keeps ID", func(t *testing.T) {
+		nested := Middleware(handler)
+		w := httptest.NewRecorder()
+		nested.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, seen, w.Header().Get(Header))
+	})
+}
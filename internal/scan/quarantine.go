@@ -0,0 +1,249 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketQuarantine = []byte("quarantine")
+	ErrItemNotFound  = errors.New("quarantine item not found")
+)
+
+// InfectedError is returned for an upload the scanner flagged. The content
+// has been quarantined rather than stored.
+type InfectedError struct {
+	Item *Item
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("infected upload %s/%s: %s", e.Item.Repository, e.Item.Path, e.Item.Signature)
+}
+
+// Item is a quarantined upload
+type Item struct {
+	ID            string    `json:"id"`
+	Repository    string    `json:"repository"`
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	Scanner       string    `json:"scanner"`
+	Signature     string    `json:"signature"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// Manager scans uploads before they are stored and keeps infected ones in
+// a quarantine directory for inspection
+type Manager struct {
+	db         *bbolt.DB
+	dir        string
+	scanner    Scanner
+	onInfected func(*Item)
+	audit      *logrus.Logger
+}
+
+// NewManager creates a scan manager that quarantines infected uploads in dir
+func NewManager(db *bbolt.DB, dir string, scanner Scanner, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketQuarantine)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quarantine bucket: %w", err)
+	}
+
+	return &Manager{
+		db:      db,
+		dir:     dir,
+		scanner: scanner,
+		audit:   logger,
+	}, nil
+}
+
+// SetAuditLogger sends the result of every scan to logger instead of the
+// application log
+func (m *Manager) SetAuditLogger(logger *logrus.Logger) {
+	m.audit = logger
+}
+
+// SetOnInfected sets a function called with each upload quarantined
+func (m *Manager) SetOnInfected(onInfected func(*Item)) {
+	m.onInfected = onInfected
+}
+
+// Check spools data to disk and scans it. Clean content is returned as a
+// reader the caller must close. Infected content is quarantined and an
+// *InfectedError returned; if the scanner fails the error wraps
+// ErrUnavailable. Errors reading data are returned as is. Every verdict,
+// and every failed scan, is recorded in the audit log.
+func (m *Manager) Check(repo, path string, data io.Reader) (io.ReadCloser, *Result, error) {
+	spool, err := os.CreateTemp(m.dir, ".scan-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create scan file: %w", err)
+	}
+	discard := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	size, err := io.Copy(spool, data)
+	if err != nil {
+		discard()
+		return nil, nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		discard()
+		return nil, nil, err
+	}
+
+	log := m.audit.WithFields(logrus.Fields{
+		"audit":      "scan",
+		"repository": repo,
+		"path":       path,
+		"size":       size,
+	})
+
+	result, err := m.scanner.Scan(spool)
+	if err != nil {
+		discard()
+		log.WithError(err).WithField("verdict", "failed").Error("Virus scan failed, rejecting upload")
+		return nil, nil, err
+	}
+	log = log.WithField("scanner", result.Scanner)
+
+	if !result.Clean {
+		spool.Close()
+		item := &Item{
+			ID:            uuid.New().String(),
+			Repository:    repo,
+			Path:          path,
+			Size:          size,
+			Scanner:       result.Scanner,
+			Signature:     result.Signature,
+			QuarantinedAt: result.ScannedAt,
+		}
+		if err := os.Rename(spool.Name(), m.dataPath(item.ID)); err != nil {
+			os.Remove(spool.Name())
+			return nil, nil, fmt.Errorf("failed to quarantine upload: %w", err)
+		}
+		if err := m.save(item); err != nil {
+			os.Remove(m.dataPath(item.ID))
+			return nil, nil, err
+		}
+		log.WithFields(logrus.Fields{
+			"verdict":    "infected",
+			"signature":  result.Signature,
+			"quarantine": item.ID,
+		}).Warn("Infected upload quarantined")
+		if m.onInfected != nil {
+			m.onInfected(item)
+		}
+		return nil, nil, &InfectedError{Item: item}
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		discard()
+		return nil, nil, err
+	}
+	log.WithField("verdict", "clean").Info("Upload scanned clean")
+	return &spoolFile{File: spool}, result, nil
+}
+
+// Get returns a quarantined item by ID
+func (m *Manager) Get(id string) (*Item, error) {
+	var item Item
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketQuarantine).Get([]byte(id))
+		if data == nil {
+			return ErrItemNotFound
+		}
+		return json.Unmarshal(data, &item)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// List returns quarantined items, most recent first. An empty repo lists
+// every repository's items.
+func (m *Manager) List(repo string) ([]*Item, error) {
+	items := []*Item{}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketQuarantine).ForEach(func(k, v []byte) error {
+			var item Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("failed to unmarshal quarantine item %s: %w", k, err)
+			}
+			if repo == "" || item.Repository == repo {
+				items = append(items, &item)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].QuarantinedAt.After(items[j].QuarantinedAt)
+	})
+	return items, nil
+}
+
+// Delete permanently removes a quarantined item and its content
+func (m *Manager) Delete(id string) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+
+	if err := os.Remove(m.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove quarantined file: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketQuarantine).Delete([]byte(id))
+	})
+}
+
+func (m *Manager) save(item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine item: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketQuarantine).Put([]byte(item.ID), data)
+	})
+}
+
+func (m *Manager) dataPath(id string) string {
+	return filepath.Join(m.dir, id)
+}
+
+// spoolFile removes the scanned copy of an upload once it is closed
+type spoolFile struct {
+	*os.File
+}
+
+func (f *spoolFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
@@ -0,0 +1,167 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable is returned when the scanner could not give a verdict, in
+// which case uploads are rejected rather than stored unscanned
+var ErrUnavailable = errors.New("virus scanner unavailable")
+
+// Result is the outcome of scanning an artifact
+type Result struct {
+	Scanner   string    `json:"scanner"`
+	Clean     bool      `json:"clean"`
+	Signature string    `json:"signature,omitempty"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// Scanner checks content for malware
+type Scanner interface {
+	Scan(r io.Reader) (*Result, error)
+}
+
+// chunkSize is the size of the chunks streamed to clamd
+const chunkSize = 64 * 1024
+
+// ClamAV scans content with a clamd daemon using its INSTREAM command
+type ClamAV struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamAV creates a clamd scanner for an address such as
+// "tcp://127.0.0.1:3310", "unix:///run/clamav/clamd.ctl" or "host:3310"
+func NewClamAV(address string) (*ClamAV, error) {
+	network, addr := "tcp", address
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		addr = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		network, addr = "unix", strings.TrimPrefix(address, "unix://")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", address)
+	}
+
+	return &ClamAV{Network: network, Address: addr, Timeout: 5 * time.Minute}, nil
+}
+
+// Scan streams r to clamd and reports its verdict
+func (c *ClamAV) Scan(r io.Reader) (*Result, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	buf := make([]byte, chunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(append(size, buf[:n]...)); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply interprets "stream: OK" or "stream: <signature> FOUND"
+func parseClamdReply(reply string) (*Result, error) {
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	result := &Result{Scanner: "clamav", ScannedAt: time.Now()}
+
+	switch {
+	case verdict == "OK":
+		result.Clean = true
+	case strings.HasSuffix(verdict, " FOUND"):
+		result.Signature = strings.TrimSuffix(verdict, " FOUND")
+	default:
+		return nil, fmt.Errorf("%w: clamd replied %q", ErrUnavailable, reply)
+	}
+	return result, nil
+}
+
+// Command scans content by piping it to an external program's standard
+// input. Following clamscan, exit status 0 means clean and 1 infected, with
+// the first line of output naming the signature; anything else is an
+// error.
+type Command struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+// NewCommand creates a scanner that runs a command line split on spaces
+func NewCommand(commandLine string) (*Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return nil, errors.New("empty scan command")
+	}
+	return &Command{Path: fields[0], Args: fields[1:], Timeout: 5 * time.Minute}, nil
+}
+
+// Scan runs the command with r as its input
+func (c *Command) Scan(r io.Reader) (*Result, error) {
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.Stdin = r
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	timer := time.AfterFunc(c.Timeout, func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	timer.Stop()
+
+	result := &Result{Scanner: "command", ScannedAt: time.Now()}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.Clean = true
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		signature, _, _ := strings.Cut(strings.TrimSpace(output.String()), "\n")
+		if signature == "" {
+			signature = "unknown"
+		}
+		result.Signature = signature
+	default:
+		return nil, fmt.Errorf("%w: scan command failed: %v: %s", ErrUnavailable, err, strings.TrimSpace(output.String()))
+	}
+	return result, nil
+}
@@ -0,0 +1,171 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers INSTREAM requests, reporting any stream containing the
+// EICAR test string as infected
+func fakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				command, err := reader.ReadString(0)
+				if err != nil || command != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, reader, int64(size)); err != nil {
+						return
+					}
+				}
+
+				if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					conn.Write([]byte("stream: Win.Test.EICAR_HDB-1 FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestClamAV(t *testing.T) {
+	scanner, err := NewClamAV("tcp://" + fakeClamd(t))
+	require.NoError(t, err)
+
+	result, err := scanner.Scan(strings.NewReader("harmless"))
+	require.NoError(t, err)
+	assert.True(t, result.Clean)
+	assert.Equal(t, "clamav", result.Scanner)
+
+	result, err = scanner.Scan(strings.NewReader(eicar))
+	require.NoError(t, err)
+	assert.False(t, result.Clean)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", result.Signature)
+
+	unreachable, err := NewClamAV("127.0.0.1:1")
+	require.NoError(t, err)
+	_, err = unreachable.Scan(strings.NewReader("harmless"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	_, err = NewClamAV("unix://")
+	assert.Error(t, err)
+}
+
+func TestCommand(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nif grep -q EICAR; then echo Eicar-Signature; exit 1; fi\n"), 0755))
+
+	scanner, err := NewCommand(script)
+	require.NoError(t, err)
+
+	result, err := scanner.Scan(strings.NewReader("harmless"))
+	require.NoError(t, err)
+	assert.True(t, result.Clean)
+
+	result, err = scanner.Scan(strings.NewReader(eicar))
+	require.NoError(t, err)
+	assert.False(t, result.Clean)
+	assert.Equal(t, "Eicar-Signature", result.Signature)
+
+	failing := &Command{Path: "sh", Args: []string{"-c", "exit 2"}, Timeout: time.Minute}
+	_, err = failing.Scan(strings.NewReader("harmless"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	_, err = NewCommand(" ")
+	assert.Error(t, err)
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "scan.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	scanner, err := NewClamAV(fakeClamd(t))
+	require.NoError(t, err)
+	m, err := NewManager(db, filepath.Join(dir, "quarantine"), scanner, logrus.New())
+	require.NoError(t, err)
+	audit, hook := logtest.NewNullLogger()
+	m.SetAuditLogger(audit)
+
+	clean, result, err := m.Check("releases", "app.bin", strings.NewReader("harmless"))
+	require.NoError(t, err)
+	assert.True(t, result.Clean)
+	content, err := io.ReadAll(clean)
+	require.NoError(t, err)
+	assert.Equal(t, "harmless", string(content))
+	require.NoError(t, clean.Close())
+
+	_, _, err = m.Check("releases", "evil.bin", strings.NewReader(eicar))
+	var infected *InfectedError
+	require.ErrorAs(t, err, &infected)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", infected.Item.Signature)
+
+	// Every verdict is in the audit log
+	require.Len(t, hook.AllEntries(), 2)
+	for i, verdict := range []string{"clean", "infected"} {
+		entry := hook.AllEntries()[i]
+		assert.Equal(t, "scan", entry.Data["audit"])
+		assert.Equal(t, "releases", entry.Data["repository"])
+		assert.Equal(t, verdict, entry.Data["verdict"])
+	}
+	assert.Equal(t, infected.Item.ID, hook.LastEntry().Data["quarantine"])
+
+	items, err := m.List("releases")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "evil.bin", items[0].Path)
+
+	quarantined, err := os.ReadFile(filepath.Join(dir, "quarantine", infected.Item.ID))
+	require.NoError(t, err)
+	assert.Equal(t, eicar, string(quarantined))
+
+	require.NoError(t, m.Delete(infected.Item.ID))
+	assert.ErrorIs(t, m.Delete(infected.Item.ID), ErrItemNotFound)
+
+	// Spooled copies of clean uploads are removed once closed
+	entries, err := os.ReadDir(filepath.Join(dir, "quarantine"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
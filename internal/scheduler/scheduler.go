@@ -0,0 +1,425 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/tasks"
+)
+
+var (
+	bucketSchedules       = []byte("schedules")
+	ErrScheduleExists     = errors.New("schedule already exists")
+	ErrScheduleNotFound   = errors.New("schedule not found")
+	ErrScheduleBuiltin    = errors.New("built-in schedules are managed by server configuration")
+	ErrUnknownTaskType    = errors.New("unknown task type")
+	ErrInvalidSchedule    = errors.New("invalid schedule")
+	maxSchedulerSleepTime = time.Minute
+)
+
+// Entry is a persisted schedule that periodically submits a task
+type Entry struct {
+	Name       string     `json:"name"`
+	Task       string     `json:"task"`
+	Repository string     `json:"repository,omitempty"`
+	Cron       string     `json:"cron"`
+	Enabled    bool       `json:"enabled"`
+	Builtin    bool       `json:"builtin,omitempty"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastTaskID string     `json:"last_task_id,omitempty"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+}
+
+// JobFunc builds the task body for a scheduled run. repository is empty for
+// global schedules.
+type JobFunc func(repository string) tasks.Func
+
+// Scheduler fires tasks according to cron schedules
+type Scheduler struct {
+	db          *bbolt.DB
+	taskManager *tasks.Manager
+	logger      *logrus.Logger
+	mu          sync.Mutex
+	jobs        map[string]JobFunc
+	nextRuns    map[string]time.Time
+	wake        chan struct{}
+	hold        func() bool
+}
+
+// New creates a scheduler backed by the given database
+func New(db *bbolt.DB, taskManager *tasks.Manager, logger *logrus.Logger) *Scheduler {
+	db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSchedules)
+		return err
+	})
+
+	return &Scheduler{
+		db:          db,
+		taskManager: taskManager,
+		logger:      logger,
+		jobs:        make(map[string]JobFunc),
+		nextRuns:    make(map[string]time.Time),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// SetHold sets a function that holds off scheduled runs while it returns
+// true, e.g. during maintenance. Runs that fall due meanwhile are skipped.
+func (s *Scheduler) SetHold(hold func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hold = hold
+}
+
+// Register makes a task type available to schedules
+func (s *Scheduler) Register(taskType string, job JobFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[taskType] = job
+}
+
+// TaskTypes returns the task types that can be scheduled
+func (s *Scheduler) TaskTypes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types := make([]string, 0, len(s.jobs))
+	for taskType := range s.jobs {
+		types = append(types, taskType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// EnsureBuiltin creates or updates a schedule defined by server
+// configuration, preserving its run history
+func (s *Scheduler) EnsureBuiltin(name, taskType, cron string, enabled bool) error {
+	if _, err := Parse(cron); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", cron, err)
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+
+		entry := Entry{Name: name}
+		if data := b.Get([]byte(name)); data != nil {
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+		}
+		entry.Task = taskType
+		entry.Repository = ""
+		entry.Cron = cron
+		entry.Enabled = enabled
+		entry.Builtin = true
+
+		return putEntry(b, &entry)
+	})
+	if err == nil {
+		s.reschedule()
+	}
+	return err
+}
+
+// Create adds a new schedule
+func (s *Scheduler) Create(entry *Entry) error {
+	if err := s.validate(entry); err != nil {
+		return err
+	}
+	entry.Builtin = false
+	entry.LastRun = nil
+	entry.LastTaskID = ""
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		if b.Get([]byte(entry.Name)) != nil {
+			return ErrScheduleExists
+		}
+		return putEntry(b, entry)
+	})
+	if err == nil {
+		s.reschedule()
+	}
+	return err
+}
+
+// Update replaces the definition of an existing schedule
+func (s *Scheduler) Update(entry *Entry) error {
+	if err := s.validate(entry); err != nil {
+		return err
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		data := b.Get([]byte(entry.Name))
+		if data == nil {
+			return ErrScheduleNotFound
+		}
+
+		var existing Entry
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return err
+		}
+		if existing.Builtin {
+			return ErrScheduleBuiltin
+		}
+		entry.LastRun = existing.LastRun
+		entry.LastTaskID = existing.LastTaskID
+
+		return putEntry(b, entry)
+	})
+	if err == nil {
+		s.reschedule()
+	}
+	return err
+}
+
+// Delete removes a schedule
+func (s *Scheduler) Delete(name string) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		data := b.Get([]byte(name))
+		if data == nil {
+			return ErrScheduleNotFound
+		}
+
+		var existing Entry
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return err
+		}
+		if existing.Builtin {
+			return ErrScheduleBuiltin
+		}
+		return b.Delete([]byte(name))
+	})
+	if err == nil {
+		s.reschedule()
+	}
+	return err
+}
+
+// Get returns a schedule with its next run time filled in
+func (s *Scheduler) Get(name string) (*Entry, error) {
+	var entry Entry
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketSchedules).Get([]byte(name))
+		if data == nil {
+			return ErrScheduleNotFound
+		}
+		return json.Unmarshal(data, &entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.fillNextRun(&entry)
+	return &entry, nil
+}
+
+// List returns every schedule with its next run time filled in
+func (s *Scheduler) List() ([]*Entry, error) {
+	entries := []*Entry{}
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketSchedules).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("failed to unmarshal schedule %s: %w", k, err)
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		s.fillNextRun(entry)
+	}
+	return entries, nil
+}
+
+// Run fires due schedules until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		wait := s.tick(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tick submits every due schedule and returns how long to sleep
+func (s *Scheduler) tick(now time.Time) time.Duration {
+	entries, err := s.List()
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to list schedules")
+		return maxSchedulerSleepTime
+	}
+
+	wait := maxSchedulerSleepTime
+	for _, entry := range entries {
+		if !entry.Enabled {
+			continue
+		}
+		schedule, err := Parse(entry.Cron)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		next, exists := s.nextRuns[entry.Name]
+		if !exists {
+			next = schedule.Next(now)
+			s.nextRuns[entry.Name] = next
+		}
+		s.mu.Unlock()
+
+		if next.IsZero() {
+			continue
+		}
+		if !next.After(now) {
+			if s.held() {
+				s.logger.WithField("schedule", entry.Name).Info("Skipping scheduled task during maintenance")
+			} else {
+				s.fire(entry, now)
+			}
+			next = schedule.Next(now)
+			s.mu.Lock()
+			s.nextRuns[entry.Name] = next
+			s.mu.Unlock()
+		}
+		if d := next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// held reports whether scheduled runs are held off
+func (s *Scheduler) held() bool {
+	s.mu.Lock()
+	hold := s.hold
+	s.mu.Unlock()
+	return hold != nil && hold()
+}
+
+func (s *Scheduler) fire(entry *Entry, now time.Time) {
+	s.mu.Lock()
+	job, exists := s.jobs[entry.Task]
+	s.mu.Unlock()
+
+	if !exists {
+		s.logger.WithField("schedule", entry.Name).Warnf("No job registered for task type %s", entry.Task)
+		return
+	}
+
+	task, err := s.taskManager.Submit(entry.Task, entry.Repository, job(entry.Repository))
+	if err != nil {
+		s.logger.WithError(err).WithField("schedule", entry.Name).Error("Failed to submit scheduled task")
+		return
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"schedule": entry.Name,
+		"task":     task.ID,
+	}).Info("Scheduled task submitted")
+
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketSchedules)
+		data := b.Get([]byte(entry.Name))
+		if data == nil {
+			return nil
+		}
+
+		var current Entry
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		current.LastRun = &now
+		current.LastTaskID = task.ID
+		return putEntry(b, &current)
+	})
+	if err != nil {
+		s.logger.WithError(err).WithField("schedule", entry.Name).Error("Failed to record schedule run")
+	}
+}
+
+func (s *Scheduler) validate(entry *Entry) error {
+	if entry.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSchedule)
+	}
+	if _, err := Parse(entry.Cron); err != nil {
+		return fmt.Errorf("%w: cron expression: %v", ErrInvalidSchedule, err)
+	}
+
+	s.mu.Lock()
+	_, exists := s.jobs[entry.Task]
+	s.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("%w %q", ErrUnknownTaskType, entry.Task)
+	}
+	return nil
+}
+
+func (s *Scheduler) fillNextRun(entry *Entry) {
+	entry.NextRun = nil
+	if !entry.Enabled {
+		return
+	}
+
+	s.mu.Lock()
+	next, exists := s.nextRuns[entry.Name]
+	s.mu.Unlock()
+
+	if !exists {
+		schedule, err := Parse(entry.Cron)
+		if err != nil {
+			return
+		}
+		next = schedule.Next(time.Now())
+	}
+	if !next.IsZero() {
+		entry.NextRun = &next
+	}
+}
+
+// reschedule drops cached activation times and wakes the run loop
+func (s *Scheduler) reschedule() {
+	s.mu.Lock()
+	s.nextRuns = make(map[string]time.Time)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func putEntry(b *bbolt.Bucket, entry *Entry) error {
+	stored := *entry
+	stored.NextRun = nil
+
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+	return b.Put([]byte(entry.Name), data)
+}
@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/tasks"
+)
+
+func TestSchedulerFiresDueEntries(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "schedules.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	taskManager, err := tasks.NewManager(db, logrus.New())
+	require.NoError(t, err)
+	defer taskManager.Shutdown()
+
+	s := New(db, taskManager, logrus.New())
+
+	ran := make(chan string, 1)
+	s.Register("noop", func(repository string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			ran <- repository
+			return nil, nil
+		}
+	})
+
+	assert.ErrorIs(t, s.Create(&Entry{Name: "bad", Task: "missing", Cron: "@daily"}), ErrUnknownTaskType)
+	assert.ErrorIs(t, s.Create(&Entry{Name: "bad", Task: "noop", Cron: "nope"}), ErrInvalidSchedule)
+
+	require.NoError(t, s.Create(&Entry{Name: "every-minute", Task: "noop", Repository: "repo", Cron: "* * * * *", Enabled: true}))
+	require.NoError(t, s.EnsureBuiltin("builtin", "noop", "@daily", true))
+	assert.ErrorIs(t, s.Delete("builtin"), ErrScheduleBuiltin)
+
+	now := time.Now()
+	s.tick(now)
+	s.tick(now.Add(2 * time.Minute))
+
+	select {
+	case repository := <-ran:
+		assert.Equal(t, "repo", repository)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduled task did not run")
+	}
+
+	entry, err := s.Get("every-minute")
+	require.NoError(t, err)
+	require.NotNil(t, entry.LastRun)
+	assert.NotEmpty(t, entry.LastTaskID)
+	assert.NotNil(t, entry.NextRun)
+}
+
+func TestSchedulerHold(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "schedules.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	taskManager, err := tasks.NewManager(db, logrus.New())
+	require.NoError(t, err)
+	defer taskManager.Shutdown()
+
+	s := New(db, taskManager, logrus.New())
+	ran := make(chan string, 1)
+	s.Register("noop", func(repository string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			ran <- repository
+			return nil, nil
+		}
+	})
+	require.NoError(t, s.Create(&Entry{Name: "every-minute", Task: "noop", Repository: "repo", Cron: "* * * * *", Enabled: true}))
+
+	held := true
+	s.SetHold(func() bool { return held })
+
+	now := time.Now()
+	s.tick(now)
+	s.tick(now.Add(2 * time.Minute))
+	entry, err := s.Get("every-minute")
+	require.NoError(t, err)
+	assert.Nil(t, entry.LastRun)
+
+	held = false
+	s.tick(now.Add(4 * time.Minute))
+	select {
+	case repository := <-ran:
+		assert.Equal(t, "repo", repository)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduled task did not run once released")
+	}
+}
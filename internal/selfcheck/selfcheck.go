@@ -0,0 +1,200 @@
+package selfcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severities of problems. Errors make the server not ready; warnings are
+// only reported.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is one inconsistency found by a check
+type Problem struct {
+	Component string `json:"component"`
+	Name      string `json:"name,omitempty"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Repaired  bool   `json:"repaired,omitempty"`
+}
+
+// Report collects the problems found while the server starts. Until
+// Finish is called the server is not ready.
+type Report struct {
+	mu         sync.Mutex
+	repair     bool
+	logger     *logrus.Logger
+	problems   []*Problem
+	finished   bool
+	finishedAt time.Time
+}
+
+// NewReport creates a report. With repair set, checks fix trivial problems
+// instead of only reporting them.
+func NewReport(repair bool, logger *logrus.Logger) *Report {
+	return &Report{repair: repair, logger: logger, problems: []*Problem{}}
+}
+
+// Repair reports whether checks should fix the problems they can
+func (r *Report) Repair() bool {
+	return r.repair
+}
+
+// Add records and logs a problem
+func (r *Report) Add(problem *Problem) {
+	entry := r.logger.WithFields(logrus.Fields{
+		"component": problem.Component,
+		"name":      problem.Name,
+		"repaired":  problem.Repaired,
+	})
+	switch {
+	case problem.Repaired:
+		entry.Info("Repaired: " + problem.Message)
+	case problem.Severity == SeverityError:
+		entry.Error(problem.Message)
+	default:
+		entry.Warn(problem.Message)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.problems = append(r.problems, problem)
+}
+
+// Errorf records an error
+func (r *Report) Errorf(component, name, format string, args ...interface{}) {
+	r.Add(&Problem{Component: component, Name: name, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf records a warning
+func (r *Report) Warnf(component, name, format string, args ...interface{}) {
+	r.Add(&Problem{Component: component, Name: name, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Repairedf records a problem that was fixed
+func (r *Report) Repairedf(component, name, format string, args ...interface{}) {
+	r.Add(&Problem{Component: component, Name: name, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...), Repaired: true})
+}
+
+// Finish marks the startup checks as complete
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finished = true
+	r.finishedAt = time.Now().UTC()
+}
+
+// Ready reports whether the checks are complete and found no unrepaired
+// errors
+func (r *Report) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.finished && !r.hasErrors()
+}
+
+func (r *Report) hasErrors() bool {
+	for _, problem := range r.problems {
+		if problem.Severity == SeverityError && !problem.Repaired {
+			return true
+		}
+	}
+	return false
+}
+
+// Status is the readiness document served by Handler
+type Status struct {
+	Status    string     `json:"status"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	Problems  []*Problem `json:"problems"`
+}
+
+// Handler serves the report, responding 503 until the server is ready.
+// live checks run on every request, so that problems arising after startup,
+// such as a storage directory becoming read-only, are reported too.
+func (r *Report) Handler(live func() []*Problem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		status := &Status{Status: "ready", Problems: append([]*Problem{}, r.problems...)}
+		ready := r.finished && !r.hasErrors()
+		if r.finished {
+			checkedAt := r.finishedAt
+			status.CheckedAt = &checkedAt
+		} else {
+			status.Status = "starting"
+		}
+		r.mu.Unlock()
+
+		if live != nil {
+			for _, problem := range live() {
+				if problem.Severity == SeverityError {
+					ready = false
+				}
+				if !contains(status.Problems, problem) {
+					status.Problems = append(status.Problems, problem)
+				}
+			}
+		}
+
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+			if status.Status == "ready" {
+				status.Status = "not ready"
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// contains reports whether problems include an unrepaired one like problem
+func contains(problems []*Problem, problem *Problem) bool {
+	for _, p := range problems {
+		if !p.Repaired && p.Component == problem.Component && p.Name == problem.Name && p.Message == problem.Message {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckDirectory verifies that dir exists and is writable. A missing
+// directory is recreated if repair is set.
+func CheckDirectory(dir string, repair bool) *Problem {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		if !repair {
+			return &Problem{Component: "storage", Name: dir, Severity: SeverityError, Message: "directory does not exist"}
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return &Problem{Component: "storage", Name: dir, Severity: SeverityError, Message: fmt.Sprintf("failed to recreate missing directory: %v", err)}
+		}
+		return &Problem{Component: "storage", Name: dir, Severity: SeverityWarning, Message: "recreated missing directory", Repaired: true}
+	}
+	if err != nil {
+		return &Problem{Component: "storage", Name: dir, Severity: SeverityError, Message: err.Error()}
+	}
+	if !info.IsDir() {
+		return &Problem{Component: "storage", Name: dir, Severity: SeverityError, Message: "not a directory"}
+	}
+
+	probe, err := os.CreateTemp(dir, ".selfcheck-*")
+	if err != nil {
+		return &Problem{Component: "storage", Name: dir, Severity: SeverityError, Message: fmt.Sprintf("directory is not writable: %v", err)}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
@@ -0,0 +1,80 @@
+package selfcheck
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDirectory(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, CheckDirectory(dir, false))
+
+	missing := filepath.Join(dir, "missing")
+	problem := CheckDirectory(missing, false)
+	require.NotNil(t, problem)
+	assert.Equal(t, SeverityError, problem.Severity)
+	assert.NoDirExists(t, missing)
+
+	problem = CheckDirectory(missing, true)
+	require.NotNil(t, problem)
+	assert.True(t, problem.Repaired)
+	assert.DirExists(t, missing)
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	problem = CheckDirectory(file, true)
+	require.NotNil(t, problem)
+	assert.Equal(t, SeverityError, problem.Severity)
+}
+
+func TestHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	var live []*Problem
+	report := NewReport(false, logger)
+	handler := report.Handler(func() []*Problem { return live })
+
+	probe := func() (int, *Status) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var status Status
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		return w.Code, &status
+	}
+
+	code, status := probe()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "starting", status.Status)
+
+	report.Warnf("uploads", "abc", "upload session expired")
+	report.Finish()
+	code, status = probe()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", status.Status)
+	assert.Len(t, status.Problems, 1)
+
+	live = []*Problem{{Component: "storage", Name: "/data", Severity: SeverityError, Message: "directory is not writable"}}
+	code, status = probe()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not ready", status.Status)
+	assert.Len(t, status.Problems, 2)
+
+	// Live problems already found at startup are listed once
+	live = nil
+	report.Errorf("docker", "registry", "failed to start Docker registry")
+	live = []*Problem{{Component: "docker", Name: "registry", Severity: SeverityError, Message: "failed to start Docker registry"}}
+	code, status = probe()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Len(t, status.Problems, 2)
+	assert.False(t, report.Ready())
+}
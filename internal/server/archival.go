@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// archivalMiddleware keeps archived repositories read-only, and refuses
+// downloads from those whose content is only in cold storage until they
+// are restored. A repository's settings and archive stay manageable, and
+// it can still be deleted.
+func (s *Server) archivalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo, content := archivalTarget(r)
+		if repo == "" || !s.archives.Frozen(repo) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeRefusal(w, http.StatusConflict, fmt.Sprintf("Repository %s is archived and read-only", repo))
+			return
+		}
+		if content && s.archives.Cold(repo) {
+			writeRefusal(w, http.StatusConflict, fmt.Sprintf("Repository %s is archived in cold storage, restore it to download its artifacts", repo))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// archivalTarget returns the repository whose artifacts or metadata a
+// request reads or changes, and whether it is after the artifacts
+// themselves
+func archivalTarget(r *http.Request) (string, bool) {
+	if rest, found := strings.CutPrefix(r.URL.Path, "/repository/"); found {
+		name, _, _ := strings.Cut(rest, "/")
+		return name, true
+	}
+	if rest, found := strings.CutPrefix(r.URL.Path, "/api/v1/repositories/"); found {
+		name, sub, _ := strings.Cut(rest, "/")
+		if sub == "" || sub == "archive" || strings.HasPrefix(sub, "archive/") {
+			return "", false
+		}
+		return name, false
+	}
+	return "", false
+}
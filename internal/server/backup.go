@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// backupsKept is how many database backups the backup task keeps
+const backupsKept = 7
+
+// BackupReport describes a backup of the database
+type BackupReport struct {
+	Path    string   `json:"path"`
+	Size    int64    `json:"size"`
+	Removed []string `json:"removed"`
+}
+
+// backupDatabase writes a consistent copy of the database to BackupDir and
+// removes all but the newest backupsKept copies
+func (s *Server) backupDatabase() (*BackupReport, error) {
+	if err := os.MkdirAll(s.config.BackupDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	backup := filepath.Join(s.config.BackupDir, "depot-"+time.Now().UTC().Format("20060102T150405Z")+".db")
+	temp := backup + ".tmp"
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(temp, 0600)
+	})
+	if err == nil {
+		err = os.Rename(temp, backup)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return nil, fmt.Errorf("failed to back up database: %w", err)
+	}
+	info, err := os.Stat(backup)
+	if err != nil {
+		return nil, err
+	}
+	report := &BackupReport{Path: backup, Size: info.Size(), Removed: []string{}}
+
+	// Backup names sort by the time they were taken
+	backups, err := filepath.Glob(filepath.Join(s.config.BackupDir, "depot-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(backups)
+	for len(backups) > backupsKept {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		report.Removed = append(report.Removed, filepath.Base(backups[0]))
+		backups = backups[1:]
+	}
+
+	s.logger.WithField("backup", backup).Infof("Backed up database, %d bytes", report.Size)
+	return report, nil
+}
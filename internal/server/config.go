@@ -1,5 +1,12 @@
 package server
 
+import (
+	"time"
+
+	"github.com/depot/depot/internal/connections"
+	"github.com/depot/depot/internal/timeouts"
+)
+
 type Config struct {
 	Host         string
 	Port         string
@@ -7,4 +14,154 @@ type Config struct {
 	CertFile     string
 	KeyFile      string
 	DatabasePath string
-}
\ No newline at end of file
+
+	// DatabaseTimeout is how long to wait for another process to release
+	// the database; 0 means one second
+	DatabaseTimeout time.Duration
+
+	// Timeouts of the main port and the Docker registry ports. Unset
+	// timeouts keep each listener's defaults; uploads and downloads of
+	// artifacts are only cut off once they stall for Timeouts.Transfer.
+	Timeouts timeouts.Config
+
+	// Connections tunes HTTP/2, TCP keep-alive and the request header size
+	// of the main port and the Docker registry ports
+	Connections connections.Config
+
+	// CleanupSchedule is the cron expression for enforcing cleanup policies;
+	// "off" disables the built-in schedule
+	CleanupSchedule string
+
+	// GCSchedule, ScrubSchedule and BackupSchedule are the cron expressions
+	// for deleting Docker blobs no manifest refers to, checking stored
+	// content against its checksums and backing up the database; "off" or
+	// empty disables each built-in schedule
+	GCSchedule     string
+	ScrubSchedule  string
+	BackupSchedule string
+
+	// BackupDir is where database backups are written; empty means the
+	// backups directory of DataDir
+	BackupDir string
+
+	// MaxUploadSize caps the size in bytes of any single artifact, blob or
+	// manifest upload; 0 means unlimited. Repositories may set lower limits.
+	MaxUploadSize int64
+
+	// MinFreeSpace is the space in bytes kept free on the disk holding
+	// DataDir: uploads whose declared size would eat into it are refused
+	// with 507 Insufficient Storage. Uploads are checked against the space
+	// left even with no reserve.
+	MinFreeSpace int64
+
+	// ArchiveDir is where the bundles of archived repositories are kept,
+	// typically a mount of cheaper storage; empty means the archive
+	// directory of DataDir
+	ArchiveDir string
+
+	// UploadConcurrency, ProxyFetchConcurrency and TaskConcurrency cap how
+	// many uploads, fetches from upstream registries and background tasks
+	// run at once, sharing the capacity fairly between repositories; 0 means
+	// unlimited. Uploads and fetches waiting longer than QueueTimeout are
+	// refused; 0 means 30 seconds.
+	UploadConcurrency     int
+	ProxyFetchConcurrency int
+	TaskConcurrency       int
+	QueueTimeout          time.Duration
+
+	// ClamdAddress enables virus scanning of raw uploads with clamd, e.g.
+	// "tcp://127.0.0.1:3310" or "unix:///run/clamav/clamd.ctl"
+	ClamdAddress string
+
+	// ScanCommand enables virus scanning of raw uploads with an external
+	// command that reads the artifact on stdin and exits 1 if it is infected.
+	// ClamdAddress takes precedence.
+	ScanCommand string
+
+	// ValidationHooksFile names a JSON file of validation hooks, commands or
+	// HTTP endpoints that see each raw upload and may refuse it
+	ValidationHooksFile string
+
+	// PolicyURL is the address of an Open Policy Agent server that
+	// authorizes every request. PolicyDecision is the document evaluated,
+	// "depot/authz/allow" if empty, and the Rego files in PolicyDir are
+	// loaded into the server at startup.
+	PolicyURL      string
+	PolicyDecision string
+	PolicyDir      string
+
+	// URLSigningKey is the secret for pre-signed download URLs. If empty a
+	// random key is generated and kept in the data directory.
+	URLSigningKey string
+
+	// KeyEncryptionKey is the secret the private signing keys in the
+	// database are encrypted with. If empty a random key is generated and
+	// kept in the data directory.
+	KeyEncryptionKey string
+
+	// DockerPathRouting serves every Docker repository on the main port as
+	// /v2/<repo-name>/<image>/..., not only those without a port of their own
+	DockerPathRouting bool
+
+	// DockerPortMin and DockerPortMax bound the ports given to Docker
+	// repositories created with "http_port": "auto"; 0 means 5000-5999
+	DockerPortMin int
+	DockerPortMax int
+
+	// CertificatesDir holds the named certificates, <name>.crt and
+	// <name>.key, Docker registries may use instead of the main server's;
+	// empty means the certs directory of DataDir
+	CertificatesDir string
+
+	// DebugAddress serves pprof profiles, expvar variables and a profile
+	// dump trigger over plain HTTP, e.g. "127.0.0.1:6060". It must be a
+	// loopback address; empty disables the debug endpoints.
+	DebugAddress string
+
+	// RedirectAddress serves a plain HTTP listener, e.g. ":80", that
+	// redirects to the HTTPS endpoint and answers ACME HTTP-01 challenges;
+	// empty disables it
+	RedirectAddress string
+
+	// ACMEChallengeDir holds the HTTP-01 challenge files an ACME client
+	// such as certbot writes in webroot mode, served by the redirect
+	// listener at /.well-known/acme-challenge/<token>
+	ACMEChallengeDir string
+
+	// HSTSMaxAge sends a Strict-Transport-Security header with this max-age
+	// on every HTTPS response; 0 disables it
+	HSTSMaxAge time.Duration
+
+	// ExternalURL is the URL clients reach the server at, e.g.
+	// "https://depot.example.com", used for Location headers, pre-signed
+	// URLs and other links instead of the address a request arrived at
+	ExternalURL string
+
+	// TrustedProxies lists the IP addresses and CIDR ranges of load
+	// balancers and reverse proxies whose X-Forwarded-For, -Proto and -Host
+	// headers are honored, comma-separated
+	TrustedProxies string
+
+	// ProxyProtocol reads the PROXY protocol header that connections from
+	// trusted proxies start with, on the main port and registry ports
+	ProxyProtocol bool
+
+	// SelfRepair lets the startup consistency check fix trivial problems:
+	// recreate missing directories, abort stale upload sessions and drop
+	// metadata of artifacts missing from storage
+	SelfRepair bool
+
+	// SMTPAddress enables email notifications through the SMTP server at
+	// this "host:port", sent from SMTPFrom and, if SMTPUsername is set,
+	// authenticated with it and SMTPPassword
+	SMTPAddress  string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Extensions enables compiled-in extensions by name, comma-separated,
+	// in the order their middleware runs. ExtensionSettings holds the
+	// settings of each by extension name.
+	Extensions        string
+	ExtensionSettings map[string]map[string]string
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/depot/depot/internal/diskspace"
+	"github.com/depot/depot/internal/selfcheck"
+	"github.com/sirupsen/logrus"
+)
+
+// setupDiskGuard refuses uploads the data directory's disk has no room
+// for, and alerts subscribers to storage.low when it does
+func (s *Server) setupDiskGuard() {
+	s.diskGuard = diskspace.New(s.config.DataDir, s.config.MinFreeSpace)
+	s.diskGuard.SetOnLow(func(repo string, needed, available int64) {
+		s.logger.WithFields(logrus.Fields{
+			"repository": repo,
+			"needed":     needed,
+			"available":  available,
+		}).Warn("Refusing uploads, storage is running out")
+		s.notifier.StorageLow(repo, needed, available)
+	})
+	s.dockerManager.SetDiskGuard(s.diskGuard)
+}
+
+// diskSpaceMiddleware refuses raw uploads and the chunks of resumable
+// uploads that would leave less than the reserve free, before any of the
+// body is stored. Docker pushes are checked by their registry.
+func (s *Server) diskSpaceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo := uploadRepository(r)
+		if repo == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Unknown repositories are refused further on, and must not be
+		// alerted on
+		if _, err := s.repoMgr.Get(repo); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// How much space is left is for the logs and the alert, not clients
+		if err := s.diskGuard.Admit(repo, r.ContentLength); err != nil {
+			writeRefusal(w, http.StatusInsufficientStorage, "Insufficient storage for this upload")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// diskSpaceProblem warns on the readiness endpoint while less than the
+// reserve is free, as uploads are being refused
+func (s *Server) diskSpaceProblem() *selfcheck.Problem {
+	low, available := s.diskGuard.Low()
+	if !low {
+		return nil
+	}
+	return &selfcheck.Problem{
+		Component: "storage",
+		Name:      s.config.DataDir,
+		Severity:  selfcheck.SeverityWarning,
+		Message:   fmt.Sprintf("%d bytes free, less than the %d reserved; uploads are refused", available, s.config.MinFreeSpace),
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// An extension is a package compiled into the server that adds middleware
+// and routes without patching its handlers, to bring custom
+// authentication, validation or routing to a fork. Extension packages
+// register themselves from init, as database/sql drivers do, and are
+// compiled in with a blank import in cmd/depot. A registered extension
+// does nothing until Config.Extensions enables it.
+
+// Extension sets itself up on the server through host. An error stops the
+// server from starting.
+type Extension interface {
+	Setup(host *ExtensionHost) error
+}
+
+// ExtensionFunc adapts a function to an Extension
+type ExtensionFunc func(host *ExtensionHost) error
+
+// Setup calls f(host)
+func (f ExtensionFunc) Setup(host *ExtensionHost) error {
+	return f(host)
+}
+
+var (
+	extensionsMu  sync.RWMutex
+	extensions    = make(map[string]Extension)
+	extensionName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+)
+
+// RegisterExtension makes an extension available under name, which is
+// lowercase letters and digits. It panics if the name is invalid or taken,
+// as registering is done from init.
+func RegisterExtension(name string, ext Extension) {
+	extensionsMu.Lock()
+	defer extensionsMu.Unlock()
+
+	if !extensionName.MatchString(name) {
+		panic(fmt.Sprintf("server: invalid extension name %q", name))
+	}
+	if ext == nil {
+		panic("server: RegisterExtension of nil extension " + name)
+	}
+	if _, dup := extensions[name]; dup {
+		panic("server: RegisterExtension called twice for " + name)
+	}
+	extensions[name] = ext
+}
+
+// Extensions returns the names of the registered extensions, sorted
+func Extensions() []string {
+	extensionsMu.RLock()
+	defer extensionsMu.RUnlock()
+
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExtensionHost is what an extension sees of the server while it sets
+// itself up
+type ExtensionHost struct {
+	name       string
+	settings   map[string]string
+	logger     *logrus.Entry
+	router     *mux.Router
+	middleware []mux.MiddlewareFunc
+}
+
+// Name returns the name the extension was enabled under
+func (h *ExtensionHost) Name() string {
+	return h.name
+}
+
+// Logger returns a logger tagging entries with the extension's name
+func (h *ExtensionHost) Logger() *logrus.Entry {
+	return h.logger
+}
+
+// Setting returns a setting of the extension, or fallback if it is not set
+func (h *ExtensionHost) Setting(key, fallback string) string {
+	if value, ok := h.settings[key]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Use adds middleware wrapping every request the server routes, and the
+// requests of Docker registries on ports of their own. Extension
+// middleware runs after request IDs, logging and compression are set up,
+// in the order extensions are enabled, and before maintenance, archival,
+// policy, disk space and queueing checks, so it can refuse a request
+// before any of them. On a registry's own port it runs after request IDs
+// only, before the registry's logging and its own checks.
+func (h *ExtensionHost) Use(middleware func(http.Handler) http.Handler) {
+	h.middleware = append(h.middleware, middleware)
+}
+
+// Handle routes path to handler. Extension routes are matched before the
+// server's own, so they can also take over a built-in path.
+func (h *ExtensionHost) Handle(path string, handler http.Handler) *mux.Route {
+	return h.router.Handle(path, handler)
+}
+
+// Refuse answers a request with an error in the server's format, carrying
+// the request ID
+func (h *ExtensionHost) Refuse(w http.ResponseWriter, status int, message string) {
+	writeRefusal(w, status, message)
+}
+
+// setupExtensions sets up the extensions enabled in the config, in order.
+// Their routes are added now, their middleware by setupRoutes.
+func (s *Server) setupExtensions() error {
+	for _, name := range strings.Split(s.config.Extensions, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		extensionsMu.RLock()
+		ext, ok := extensions[name]
+		extensionsMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown extension %q, compiled in are: %s", name, strings.Join(Extensions(), ", "))
+		}
+
+		host := &ExtensionHost{
+			name:     name,
+			settings: s.config.ExtensionSettings[name],
+			logger:   s.logger.WithField("extension", name),
+			router:   s.router,
+		}
+		if err := ext.Setup(host); err != nil {
+			return fmt.Errorf("failed to set up extension %s: %w", name, err)
+		}
+		s.extensions = append(s.extensions, host)
+		host.logger.Info("Extension enabled")
+	}
+	return nil
+}
+
+// extensionsHandler lists the enabled extensions
+func (s *Server) extensionsHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.extensions))
+	for _, host := range s.extensions {
+		names = append(names, host.name)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
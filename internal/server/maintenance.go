@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/selfcheck"
+)
+
+// setupMaintenance restores maintenance left on and has the registries
+// and scheduled tasks obey it
+func (s *Server) setupMaintenance() error {
+	var err error
+	s.maintenance, err = maintenance.NewManager(s.db)
+	if err != nil {
+		return err
+	}
+	if state := s.maintenance.Get(); state != nil {
+		s.logger.WithField("message", state.Message).Warnf("Server is still in maintenance, %s", state.Mode)
+	}
+	s.dockerManager.SetMaintenance(s.maintenance)
+	s.scheduler.SetHold(s.maintenance.Active)
+	return nil
+}
+
+// refuseDuringMaintenance answers a request maintenance refuses
+func refuseDuringMaintenance(w http.ResponseWriter, r *http.Request, state *maintenance.State) {
+	writeRefusal(w, http.StatusServiceUnavailable, state.Describe())
+}
+
+// maintenanceProblem reports maintenance on the readiness endpoint: an
+// offline server is not ready, a read-only one is with a warning
+func (s *Server) maintenanceProblem() *selfcheck.Problem {
+	state := s.maintenance.Get()
+	if state == nil {
+		return nil
+	}
+	severity := selfcheck.SeverityWarning
+	if state.Mode == maintenance.ModeOffline {
+		severity = selfcheck.SeverityError
+	}
+	return &selfcheck.Problem{Component: "maintenance", Name: state.Mode, Severity: severity, Message: state.Describe()}
+}
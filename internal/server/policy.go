@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/depot/depot/internal/policy"
+	"github.com/depot/depot/internal/requestid"
+)
+
+// setupPolicy has an OPA server authorize requests if one is configured,
+// first loading the Rego policies of the policy directory into it
+func (s *Server) setupPolicy() error {
+	if s.config.PolicyURL == "" {
+		return nil
+	}
+	engine, err := policy.NewEngine(s.config.PolicyURL, s.config.PolicyDecision)
+	if err != nil {
+		return err
+	}
+	if s.config.PolicyDir != "" {
+		loaded, err := engine.LoadPolicies(s.config.PolicyDir)
+		if err != nil {
+			return err
+		}
+		s.logger.Infof("Loaded %d authorization policies into OPA", loaded)
+	}
+	s.policy = engine
+	s.dockerManager.SetPolicy(engine)
+	return nil
+}
+
+// policyMiddleware refuses requests the authorization policy denies.
+// Docker registry requests are left to the registry, which authorizes them
+// with the repository and image they are for, and health checks are always
+// answered.
+func (s *Server) policyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" || r.URL.Path == "/api/v1/health" || registryPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		repository, artifactPath := policyTarget(r)
+		decision, err := s.policy.Authorize(r, repository, artifactPath, policyAction(r))
+		if err != nil {
+			s.logger.WithError(err).WithField("request_id", requestid.FromContext(r.Context())).Error("Policy evaluation failed")
+			writeRefusal(w, http.StatusServiceUnavailable, "Authorization policy unavailable, try again later")
+			return
+		}
+		if !decision.Allow {
+			message := "Access denied by policy"
+			if decision.Reason != "" {
+				message += ": " + decision.Reason
+			}
+			writeRefusal(w, http.StatusForbidden, message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registryPath reports whether a path on the main port is served by the
+// registry of a repository. The base endpoint and the catalog are answered
+// by the main port itself.
+func registryPath(path string) bool {
+	rest, found := strings.CutPrefix(path, "/v2/")
+	return found && rest != "" && rest != "_catalog"
+}
+
+// policyTarget returns the repository a request is for and the path of the
+// artifact within it, either of which may be empty
+func policyTarget(r *http.Request) (string, string) {
+	if rest, found := strings.CutPrefix(r.URL.Path, "/repository/"); found {
+		name, artifactPath, _ := strings.Cut(rest, "/")
+		return name, artifactPath
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/v1/repositories/") {
+		vars := mux.Vars(r)
+		return vars["name"], vars["path"]
+	}
+	return "", ""
+}
+
+// policyAction classifies a request: changes to the server's configuration
+// and to repositories themselves, and anything under /api/v1/admin, are
+// admin actions; everything else reads, writes or deletes by its method
+func policyAction(r *http.Request) string {
+	action := policy.ActionOf(r.Method)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v1/admin/"):
+		return policy.ActionAdmin
+	case action == policy.ActionRead:
+		return action
+	case r.URL.Path == "/api/v1/repositories" || mux.Vars(r)["name"] != "" && r.URL.Path == "/api/v1/repositories/"+mux.Vars(r)["name"]:
+		return policy.ActionAdmin
+	}
+	for _, prefix := range []string{"/api/v1/namespaces", "/api/v1/schedules", "/api/v1/signing-keys", "/api/v1/notifications/"} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return policy.ActionAdmin
+		}
+	}
+	return action
+}
+
+// writeRefusal answers a request refused before reaching the API, by the
+// policy or maintenance, in the API's error format
+func writeRefusal(w http.ResponseWriter, status int, message string) {
+	response := map[string]string{"error": message}
+	if id := w.Header().Get(requestid.Header); id != "" {
+		response["request_id"] = id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
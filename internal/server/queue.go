@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/depot/depot/internal/fairqueue"
+)
+
+// defaultQueueTimeout is how long an upload or proxy fetch waits for a
+// slot unless configured otherwise
+const defaultQueueTimeout = 30 * time.Second
+
+// setupQueues limits the uploads, proxy fetches and background tasks that
+// run at once to the configured capacities, and exports the queues'
+// depth with the other metrics
+func (s *Server) setupQueues() {
+	timeout := s.config.QueueTimeout
+	if timeout == 0 {
+		timeout = defaultQueueTimeout
+	}
+	if n := s.config.UploadConcurrency; n > 0 {
+		s.uploadQueue = fairqueue.New("uploads", n, timeout)
+	}
+	if n := s.config.ProxyFetchConcurrency; n > 0 {
+		s.fetchQueue = fairqueue.New("proxy-fetches", n, timeout)
+	}
+	if n := s.config.TaskConcurrency; n > 0 {
+		// Tasks run in the background, so they wait as long as it takes
+		s.taskQueue = fairqueue.New("tasks", n, 0)
+	}
+
+	s.dockerManager.SetQueues(s.uploadQueue, s.fetchQueue)
+	s.taskManager.SetQueue(s.taskQueue)
+	s.metrics.AddCollector(func(w io.Writer) {
+		fairqueue.WritePrometheus(w, s.uploadQueue, s.fetchQueue, s.taskQueue)
+	})
+}
+
+// queueMiddleware has raw uploads and the chunks of resumable uploads wait
+// in the uploads queue. Docker pushes wait in their registry.
+func (s *Server) queueMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		repo := uploadRepository(r)
+		if repo == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Unknown repositories are refused without queueing, and must not
+		// show up in the metrics
+		if _, err := s.repoMgr.Get(repo); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, err := s.uploadQueue.Acquire(r.Context(), repo)
+		if err != nil {
+			w.Header().Set("Retry-After", fairqueue.RetryAfter)
+			writeRefusal(w, http.StatusServiceUnavailable, "Server busy, too many uploads queued")
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadRepository returns the repository a raw upload is for, or "" if
+// the request is not one
+func uploadRepository(r *http.Request) string {
+	switch r.Method {
+	case http.MethodPut, http.MethodPost, http.MethodPatch:
+	default:
+		return ""
+	}
+	if rest, found := strings.CutPrefix(r.URL.Path, "/repository/"); found {
+		name, _, _ := strings.Cut(rest, "/")
+		return name
+	}
+	if rest, found := strings.CutPrefix(r.URL.Path, "/api/v1/repositories/"); found {
+		name, rest, _ := strings.Cut(rest, "/")
+		if strings.HasPrefix(rest, "uploads/") && r.Method != http.MethodPost {
+			return name
+		}
+	}
+	return ""
+}
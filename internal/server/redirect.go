@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// acmeChallengePrefix is where an ACME CA fetches HTTP-01 challenge tokens
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeToken matches the base64url tokens of ACME challenges
+var acmeToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// startRedirectServer serves the plain HTTP listener that redirects to the
+// HTTPS endpoint and answers ACME HTTP-01 challenges
+func (s *Server) startRedirectServer(listen func(network, address string) (net.Listener, error)) error {
+	listener, err := listen("tcp", s.config.RedirectAddress)
+	if err != nil {
+		return fmt.Errorf("failed to create redirect listener: %w", err)
+	}
+	s.config.RedirectAddress = listener.Addr().String()
+
+	s.redirectServer = &http.Server{
+		Handler:           s.redirectHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	go func() {
+		s.logger.Infof("Starting HTTP redirect server on %s", s.config.RedirectAddress)
+		if err := s.redirectServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.WithError(err).Error("Redirect server failed")
+		}
+	}()
+	return nil
+}
+
+// redirectHandler redirects every request to the same URL on the HTTPS
+// port, except ACME challenges, which are served from ACMEChallengeDir
+func (s *Server) redirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			s.serveACMEChallenge(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "Host header is required", http.StatusBadRequest)
+			return
+		}
+		if s.config.Port != "443" {
+			host = net.JoinHostPort(host, s.config.Port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+
+		// Other methods keep their method and body across the redirect
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
+
+// serveACMEChallenge serves the key authorization an ACME client, such as
+// certbot in webroot mode, wrote for a challenge token
+func (s *Server) serveACMEChallenge(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+	if s.config.ACMEChallengeDir == "" || !acmeToken.MatchString(token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.config.ACMEChallengeDir, token))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(data)
+}
+
+// hstsMiddleware tells browsers to only use HTTPS for the server's host
+func (s *Server) hstsMiddleware(next http.Handler) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(s.config.HSTSMaxAge/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RedirectAddress returns the address the HTTP redirect listener listens
+// on, if enabled
+func (s *Server) RedirectAddress() string {
+	return s.config.RedirectAddress
+}
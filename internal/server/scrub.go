@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/storage"
+)
+
+// ScrubReport describes a check of stored content against the checksums
+// recorded for it: raw artifacts, and the manifests and blobs of Docker
+// registries
+type ScrubReport struct {
+	Checked int                   `json:"checked"`
+	Corrupt []string              `json:"corrupt"`
+	Missing []string              `json:"missing"`
+	Docker  []*docker.ScrubReport `json:"docker"`
+}
+
+// scrub reads stored content back and compares it with its recorded
+// SHA-256, for one repository or without one for all of them. Artifacts
+// changed since their metadata was recorded are left to the next check.
+func (s *Server) scrub(ctx context.Context, repoName string) (*ScrubReport, error) {
+	var artifacts []*metadata.Artifact
+	err := s.metadata.ForEach(func(artifact *metadata.Artifact) error {
+		if (repoName == "" || artifact.Repository == repoName) && artifact.Get("sha256") != "" {
+			artifacts = append(artifacts, artifact)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		if artifacts[i].Repository != artifacts[j].Repository {
+			return artifacts[i].Repository < artifacts[j].Repository
+		}
+		return artifacts[i].Path < artifacts[j].Path
+	})
+
+	report := &ScrubReport{Corrupt: []string{}, Missing: []string{}, Docker: []*docker.ScrubReport{}}
+	for _, artifact := range artifacts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name := artifact.Repository + "/" + artifact.Path
+		matches, err := s.artifactMatches(artifact)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			report.Missing = append(report.Missing, name)
+		case errors.Is(err, errArtifactChanged):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("failed to check %s: %w", name, err)
+		case !matches:
+			report.Corrupt = append(report.Corrupt, name)
+		}
+		report.Checked++
+	}
+
+	if repoName == "" {
+		report.Docker, err = s.dockerManager.ScrubAll(ctx)
+	} else if _, running := s.dockerManager.GetRegistry(repoName); running {
+		var registry *docker.ScrubReport
+		registry, err = s.dockerManager.Scrub(ctx, repoName)
+		if registry != nil {
+			report.Docker = append(report.Docker, registry)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log := s.logger.WithField("checked", report.Checked)
+	if len(report.Corrupt) > 0 || len(report.Missing) > 0 {
+		log.Warnf("Scrub found %d corrupt and %d missing artifacts", len(report.Corrupt), len(report.Missing))
+	} else {
+		log.Info("Scrubbed artifacts")
+	}
+	return report, nil
+}
+
+// errArtifactChanged is returned for an artifact written since its
+// metadata was recorded
+var errArtifactChanged = errors.New("artifact changed since its metadata was recorded")
+
+// artifactMatches reports whether the stored content of an artifact still
+// has the SHA-256 recorded for it
+func (s *Server) artifactMatches(artifact *metadata.Artifact) (bool, error) {
+	info, err := s.storage.Stat(artifact.Repository, artifact.Path)
+	if err != nil {
+		return false, err
+	}
+	if !artifact.Matches(info.Size, info.ModTime) {
+		return false, errArtifactChanged
+	}
+	file, err := s.storage.Retrieve(artifact.Repository, artifact.Path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := checksum.NewReader(file, nil)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return false, err
+	}
+	return reader.Sums().Get("sha256") == artifact.Get("sha256"), nil
+}
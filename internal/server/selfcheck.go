@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/selfcheck"
+	"github.com/depot/depot/internal/storage"
+)
+
+// storageDirectories returns the directories the server writes to
+func (s *Server) storageDirectories() []string {
+	return []string{
+		s.config.DataDir,
+		filepath.Join(s.config.DataDir, "artifacts"),
+		filepath.Join(s.config.DataDir, "uploads"),
+		filepath.Join(s.config.DataDir, "trash"),
+	}
+}
+
+// checkConsistency runs the startup checks that follow starting the Docker
+// registries, whose failures are already in the report, and marks the
+// server ready. Upload sessions active since started are left alone.
+func (s *Server) checkConsistency(started time.Time) {
+	report := s.readiness
+	for _, dir := range s.storageDirectories() {
+		if problem := selfcheck.CheckDirectory(dir, report.Repair()); problem != nil {
+			report.Add(problem)
+		}
+	}
+
+	if err := s.checkArtifactMetadata(report); err != nil {
+		report.Errorf("metadata", "", "failed to check artifact metadata: %v", err)
+	}
+	if err := s.checkUploadSessions(report, started); err != nil {
+		report.Errorf("uploads", "", "failed to check upload sessions: %v", err)
+	}
+
+	report.Finish()
+	if report.Ready() {
+		s.logger.Info("Startup consistency check complete")
+	} else {
+		s.logger.Error("Startup consistency check found problems, server is not ready")
+	}
+}
+
+// checkArtifactMetadata finds metadata for artifacts that are no longer in
+// storage or whose repository no longer exists, and drops it on repair
+func (s *Server) checkArtifactMetadata(report *selfcheck.Report) error {
+	repos, err := s.repoMgr.List()
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		exists[repo.Name] = true
+	}
+
+	var dangling []*metadata.Artifact
+	err = s.metadata.ForEach(func(artifact *metadata.Artifact) error {
+		if !exists[artifact.Repository] {
+			dangling = append(dangling, artifact)
+			return nil
+		}
+		// The content of repositories archived to cold storage is in
+		// their bundle until restored
+		if s.archives.Cold(artifact.Repository) {
+			return nil
+		}
+		if _, err := s.storage.Stat(artifact.Repository, artifact.Path); errors.Is(err, storage.ErrNotFound) {
+			dangling = append(dangling, artifact)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range dangling {
+		name := artifact.Repository + "/" + artifact.Path
+		if !report.Repair() {
+			report.Warnf("metadata", name, "metadata refers to a missing artifact")
+			continue
+		}
+		if err := s.metadata.Delete(artifact.Repository, artifact.Path); err != nil {
+			report.Warnf("metadata", name, "failed to remove metadata of a missing artifact: %v", err)
+			continue
+		}
+		report.Repairedf("metadata", name, "removed metadata of a missing artifact")
+	}
+
+	for _, repo := range repos {
+		if s.archives.Cold(repo.Name) {
+			continue
+		}
+		aliases, err := s.metadata.ListAliases(repo.Name)
+		if err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			if _, err := s.storage.Stat(repo.Name, alias.Target); errors.Is(err, storage.ErrNotFound) {
+				report.Warnf("metadata", repo.Name+"/"+alias.Path, "alias target %s does not exist", alias.Target)
+			}
+		}
+	}
+	return nil
+}
+
+// checkUploadSessions finds resumable uploads that have expired, belong to
+// a deleted repository or lost their staged data, and aborts them on repair
+func (s *Server) checkUploadSessions(report *selfcheck.Report, started time.Time) error {
+	sessions, err := s.uploads.List()
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if !session.UpdatedAt.Before(started) {
+			continue
+		}
+
+		var reason string
+		if time.Since(session.UpdatedAt) > uploadSessionMaxAge {
+			reason = "upload session expired"
+		} else if _, err := s.repoMgr.Get(session.Repository); errors.Is(err, repository.ErrRepositoryNotFound) {
+			reason = "upload session belongs to a missing repository"
+		} else if err := s.uploads.Verify(session); err != nil {
+			reason = "upload session is broken: " + err.Error()
+		} else {
+			continue
+		}
+
+		if !report.Repair() {
+			report.Warnf("uploads", session.ID, "%s", reason)
+			continue
+		}
+		if err := s.uploads.Abort(session.ID); err != nil {
+			report.Warnf("uploads", session.ID, "%s; failed to abort it: %v", reason, err)
+			continue
+		}
+		report.Repairedf("uploads", session.ID, "aborted: %s", reason)
+	}
+	return nil
+}
+
+// liveChecks are the checks repeated on every readiness probe
+func (s *Server) liveChecks() []*selfcheck.Problem {
+	var problems []*selfcheck.Problem
+	for _, dir := range s.storageDirectories() {
+		if problem := selfcheck.CheckDirectory(dir, false); problem != nil {
+			problems = append(problems, problem)
+		}
+	}
+	if problem := s.maintenanceProblem(); problem != nil {
+		problems = append(problems, problem)
+	}
+	if problem := s.diskSpaceProblem(); problem != nil {
+		problems = append(problems, problem)
+	}
+	return problems
+}
@@ -5,17 +5,50 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/depot/depot/internal/api"
+	"github.com/depot/depot/internal/archival"
+	"github.com/depot/depot/internal/buildcache"
+	"github.com/depot/depot/internal/cleanup"
+	"github.com/depot/depot/internal/cluster"
+	"github.com/depot/depot/internal/compress"
+	"github.com/depot/depot/internal/debug"
+	"github.com/depot/depot/internal/diskspace"
 	"github.com/depot/depot/internal/docker"
+	"github.com/depot/depot/internal/fairqueue"
+	"github.com/depot/depot/internal/forwarded"
+	"github.com/depot/depot/internal/handoff"
+	"github.com/depot/depot/internal/logging"
+	"github.com/depot/depot/internal/maintenance"
+	"github.com/depot/depot/internal/metadata"
+	"github.com/depot/depot/internal/metrics"
+	"github.com/depot/depot/internal/migrate"
+	"github.com/depot/depot/internal/notify"
+	"github.com/depot/depot/internal/policy"
+	"github.com/depot/depot/internal/presign"
 	"github.com/depot/depot/internal/repository"
+	"github.com/depot/depot/internal/requestid"
+	"github.com/depot/depot/internal/scan"
+	"github.com/depot/depot/internal/scheduler"
+	"github.com/depot/depot/internal/selfcheck"
+	"github.com/depot/depot/internal/signing"
+	"github.com/depot/depot/internal/snapshots"
 	"github.com/depot/depot/internal/storage"
+	"github.com/depot/depot/internal/tasks"
+	"github.com/depot/depot/internal/timeouts"
+	"github.com/depot/depot/internal/trash"
+	"github.com/depot/depot/internal/uploads"
+	"github.com/depot/depot/internal/validation"
 	"github.com/depot/depot/pkg/models"
 	"github.com/sirupsen/logrus"
 	"go.etcd.io/bbolt"
@@ -24,37 +57,251 @@ import (
 type Server struct {
 	config          *Config
 	logger          *logrus.Logger
+	accessLogger    *logrus.Logger
+	accessSampling  *logging.AccessSampling
 	router          *mux.Router
 	httpServer      *http.Server
 	db              *bbolt.DB
 	storage         storage.Storage
 	dockerManager   *docker.Manager
+	cleanupEngine   *cleanup.Engine
+	taskManager     *tasks.Manager
+	scheduler       *scheduler.Scheduler
+	uploads         *uploads.Manager
+	trash           *trash.Manager
+	scanner         *scan.Manager
+	validator       *validation.Manager
+	policy          *policy.Engine
+	maintenance     *maintenance.Manager
+	notifier        *notify.Manager
+	diskGuard       *diskspace.Guard
+	archives        *archival.Manager
+	snapshots       *snapshots.Store
+	metadata        *metadata.Store
+	signer          *presign.Signer
+	metrics         *metrics.Recorder
+	repoMgr         *repository.Manager
+	handoff         *handoff.Handoff
+	debugServer     *http.Server
+	trusted         *forwarded.Trusted
+	redirectServer  *http.Server
+	readiness       *selfcheck.Report
+	buildCache      *buildcache.Cache
+	signingKeys     *signing.Manager
+	uploadQueue     *fairqueue.Queue
+	fetchQueue      *fairqueue.Queue
+	taskQueue       *fairqueue.Queue
+	extensions      []*ExtensionHost
+	aborted         atomic.Bool
+	listening       chan struct{} // closed once Start has bound its ports
 }
 
+// uploadSessionMaxAge is how long a resumable upload may sit idle before
+// its staged data is discarded
+const uploadSessionMaxAge = 24 * time.Hour
+
+// taskRetention is how long finished tasks and their results are kept
+const taskRetention = 7 * 24 * time.Hour
+
 func New(config *Config, logger *logrus.Logger) (*Server, error) {
+	if config.DebugAddress != "" {
+		if err := debug.CheckAddress(config.DebugAddress); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	dbTimeout := config.DatabaseTimeout
+	if dbTimeout == 0 {
+		dbTimeout = 1 * time.Second
+	}
 	db, err := bbolt.Open(config.DatabasePath, 0600, &bbolt.Options{
-		Timeout: 1 * time.Second,
+		Timeout: dbTimeout,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	fileStorage := storage.NewFileStorage(filepath.Join(config.DataDir, "artifacts"))
+	// Bring the metadata forward before anything reads it, and refuse a
+	// database a newer server has migrated
+	if _, err := migrate.New(logger).Run(db, true); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	artifactsDir := filepath.Join(config.DataDir, "artifacts")
+	if err := os.MkdirAll(artifactsDir, 0755); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+	hotStorage := storage.NewFileStorage(artifactsDir)
+	if config.BackupDir == "" {
+		config.BackupDir = filepath.Join(config.DataDir, "backups")
+	}
+	if config.ArchiveDir == "" {
+		config.ArchiveDir = filepath.Join(config.DataDir, "archive")
+	}
+	archives, err := archival.NewManager(db, hotStorage, storage.NewFileStorage(config.ArchiveDir), logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	// Everything but the archives goes through a guard keeping archived
+	// repositories read-only
+	fileStorage := archives.Guard(hotStorage)
 	
 	// Initialize Docker registry manager (TLS config will be set later)
 	dockerManager := docker.NewManager(fileStorage, nil, logger)
+	dockerManager.SetMaxUploadSize(config.MaxUploadSize)
+	dockerManager.SetTimeouts(config.Timeouts)
+	dockerManager.SetConnections(config.Connections)
+	dockerManager.SetPathRouting(config.DockerPathRouting)
+	if config.DockerPortMax > 0 {
+		dockerManager.SetPortRange(config.DockerPortMin, config.DockerPortMax)
+	}
+	if config.ExternalURL != "" {
+		u, err := url.Parse(config.ExternalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			db.Close()
+			return nil, fmt.Errorf("invalid external URL %q", config.ExternalURL)
+		}
+		config.ExternalURL = strings.TrimSuffix(config.ExternalURL, "/")
+		dockerManager.SetExternalURL(config.ExternalURL)
+	}
+	trusted, err := forwarded.ParseTrusted(config.TrustedProxies)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if config.ProxyProtocol && trusted == nil {
+		db.Close()
+		return nil, fmt.Errorf("the PROXY protocol requires trusted proxies")
+	}
+	if config.CertificatesDir == "" {
+		config.CertificatesDir = filepath.Join(config.DataDir, "certs")
+	}
+	dockerManager.SetCertificateDir(config.CertificatesDir)
+	taskManager, err := tasks.NewManager(db, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 	
 	s := &Server{
 		config:        config,
 		logger:        logger,
+		accessLogger:  logger,
 		router:        mux.NewRouter(),
 		db:            db,
 		storage:       fileStorage,
 		dockerManager: dockerManager,
+		repoMgr:       repository.NewManager(db, fileStorage, logger),
+		taskManager:   taskManager,
+		metadata:      metadata.NewStore(db),
+		readiness:     selfcheck.NewReport(config.SelfRepair, logger),
+		trusted:       trusted,
+		archives:      archives,
+		listening:     make(chan struct{}),
+	}
+	s.cleanupEngine = cleanup.NewEngine(s.repoMgr, fileStorage, logger)
+	s.cleanupEngine.SetFrozen(archives.Frozen)
+	s.cleanupEngine.SetDockerManager(dockerManager)
+	s.cleanupEngine.SetMetadata(s.metadata)
+	dockerManager.SetDownloadRecorder(s.recordDownload)
+	s.accessSampling = logging.NewAccessSampling()
+	dockerManager.SetAccessSampling(s.accessSampling)
+	s.scheduler = scheduler.New(db, s.taskManager, logger)
+	s.buildCache = buildcache.New(fileStorage, logger)
+
+	s.uploads, err = uploads.NewManager(db, filepath.Join(config.DataDir, "uploads"), logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.trash, err = trash.NewManager(db, filepath.Join(config.DataDir, "trash"), fileStorage, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.snapshots, err = snapshots.NewStore(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.setupNotifications(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.setupDiskGuard()
+
+	if err := s.setupScanner(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.setupValidation(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.setupPolicy(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.setupMaintenance(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	signingKey := []byte(config.URLSigningKey)
+	if len(signingKey) == 0 {
+		signingKey, err = presign.LoadOrCreateKey(filepath.Join(config.DataDir, "url-signing.key"))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	s.signer = presign.NewSigner(signingKey)
+	dockerManager.SetPullTokens(docker.NewPullTokens(signingKey))
+
+	keySecret := []byte(config.KeyEncryptionKey)
+	if len(keySecret) == 0 {
+		keySecret, err = presign.LoadOrCreateKey(filepath.Join(config.DataDir, "key-encryption.key"))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	s.signingKeys, err = signing.NewManager(db, keySecret)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.metrics, err = metrics.NewRecorder(db, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	dockerManager.SetMetrics(s.metrics)
+	s.setupQueues()
+
+	if err := s.setupSchedules(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.setupExtensions(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	s.setupRoutes()
@@ -63,23 +310,294 @@ func New(config *Config, logger *logrus.Logger) (*Server, error) {
 }
 
 func (s *Server) setupRoutes() {
-	apiHandler := api.NewHandler(s.db, s.storage, s.dockerManager, s.logger)
+	if s.trusted != nil {
+		s.router.Use(s.trusted.Middleware)
+	}
+	s.router.Use(requestid.Middleware)
+	s.router.Use(s.loggingMiddleware)
+	if s.config.HSTSMaxAge > 0 {
+		s.router.Use(s.hstsMiddleware)
+	}
+	s.router.Use(compress.Middleware)
+	var extensionMiddleware []func(http.Handler) http.Handler
+	for _, host := range s.extensions {
+		s.router.Use(host.middleware...)
+		for _, middleware := range host.middleware {
+			extensionMiddleware = append(extensionMiddleware, middleware)
+		}
+	}
+	s.dockerManager.SetMiddleware(extensionMiddleware...)
+	s.router.Use(s.maintenance.Middleware(refuseDuringMaintenance))
+	s.router.Use(s.archivalMiddleware)
+	if s.policy != nil {
+		s.router.Use(s.policyMiddleware)
+	}
+	s.router.Use(s.diskSpaceMiddleware)
+	if s.uploadQueue != nil {
+		s.router.Use(s.queueMiddleware)
+	}
+
+	apiHandler := api.NewHandler(s.db, s.storage, s.dockerManager, s.taskManager, s.scheduler, s.uploads, s.trash, s.logger)
+	apiHandler.SetMaxUploadSize(s.config.MaxUploadSize)
+	apiHandler.SetScanner(s.scanner)
+	apiHandler.SetValidator(s.validator)
+	apiHandler.SetMaintenance(s.maintenance)
+	apiHandler.SetNotifier(s.notifier)
+	apiHandler.SetURLSigner(s.signer)
+	apiHandler.SetMetrics(s.metrics)
+	apiHandler.SetExternalURL(s.config.ExternalURL)
+	apiHandler.SetAccessSampling(s.accessSampling)
+	apiHandler.SetBuildCache(s.buildCache)
+	apiHandler.SetSigningKeys(s.signingKeys)
+	apiHandler.SetArchives(s.archives)
+	apiHandler.SetSnapshots(s.snapshots)
 	
+	s.router.Handle("/readyz", s.readiness.Handler(s.liveChecks)).Methods("GET")
+
 	apiRouter := s.router.PathPrefix("/api/v1").Subrouter()
 	apiRouter.HandleFunc("/health", apiHandler.Health).Methods("GET")
+	apiRouter.HandleFunc("/system/diagnostics", apiHandler.Diagnostics).Methods("GET")
+	apiRouter.HandleFunc("/system/extensions", s.extensionsHandler).Methods("GET")
+	apiRouter.HandleFunc("/namespaces", apiHandler.ListNamespaces).Methods("GET")
+	apiRouter.HandleFunc("/namespaces", apiHandler.CreateNamespace).Methods("POST")
+	apiRouter.HandleFunc("/namespaces/{name}", apiHandler.GetNamespace).Methods("GET")
+	apiRouter.HandleFunc("/namespaces/{name}", apiHandler.UpdateNamespace).Methods("PUT")
+	apiRouter.HandleFunc("/namespaces/{name}", apiHandler.DeleteNamespace).Methods("DELETE")
 	apiRouter.HandleFunc("/repositories", apiHandler.ListRepositories).Methods("GET")
 	apiRouter.HandleFunc("/repositories", apiHandler.CreateRepository).Methods("POST")
 	apiRouter.HandleFunc("/repositories/{name}", apiHandler.GetRepository).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}", apiHandler.UpdateRepository).Methods("PUT")
 	apiRouter.HandleFunc("/repositories/{name}", apiHandler.DeleteRepository).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/cleanup", apiHandler.PreviewCleanup).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/cleanup", apiHandler.RunCleanup).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/import", apiHandler.ImportRepository).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/upload-policy", apiHandler.GetUploadPolicy).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/top", apiHandler.TopDownloads).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/usage", apiHandler.GetUsage).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/archive", apiHandler.GetArchive).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/archive", apiHandler.ArchiveRepository).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/archive/manifest", apiHandler.GetArchiveManifest).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/archive/restore", apiHandler.RestoreArchive).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots", apiHandler.ListSnapshots).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots", apiHandler.CreateSnapshot).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots/{snapshot}", apiHandler.GetSnapshot).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots/{snapshot}", apiHandler.DeleteSnapshot).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots/{snapshot}/diff", apiHandler.DiffSnapshot).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/snapshots/{snapshot}/restore", apiHandler.RestoreSnapshot).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/diff", apiHandler.DiffRepository).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/downloads/{path:.+}", apiHandler.GetDownloads).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/images/{image:.+}/manifests/{reference}", apiHandler.InspectImage).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/images/{image:.+}/provenance/{reference}", apiHandler.GetImageProvenance).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/images/{image:.+}/provenance/{reference}", apiHandler.AttachImageProvenance).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/images/{image:.+}/oci-layout", apiHandler.ImportOCILayout).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/images/{image:.+}/oci-layout/{reference}", apiHandler.ExportOCILayout).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/oci-artifacts", apiHandler.ListArtifacts).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/upstreams", apiHandler.ListUpstreams).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/upstream-quarantine", apiHandler.ListUpstreamQuarantine).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/upstream-quarantine/{id}", apiHandler.DeleteUpstreamQuarantine).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/reindex", apiHandler.ReindexDocker).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/pull-tokens", apiHandler.CreatePullToken).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/federation-tokens", apiHandler.CreateFederationToken).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/changes", apiHandler.GetChanges).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/sync", apiHandler.SyncFederation).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/warm", apiHandler.WarmProxy).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/evict", apiHandler.EvictProxyCache).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/cache-stats", apiHandler.BuildCacheStats).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/image-sync", apiHandler.GetImageSync).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/image-sync", apiHandler.SetImageSync).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/image-sync/run", apiHandler.RunImageSync).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/registry-import", apiHandler.ImportRegistry).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/staging", apiHandler.ListStaging).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/staging", apiHandler.CreateStaging).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/close", apiHandler.CloseStaging).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/promote", apiHandler.PromoteStaging).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/drop", apiHandler.DropStaging).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/aliases", apiHandler.ListAliases).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/aliases/{path:.+}", apiHandler.GetAlias).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/aliases/{path:.+}", apiHandler.PutAlias).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/aliases/{path:.+}", apiHandler.DeleteAlias).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/releases", apiHandler.ListReleaseProjects).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/releases/{project}", apiHandler.ListReleases).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/releases/{project}/{version}", apiHandler.GetRelease).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/releases/{project}/{version}", apiHandler.PutRelease).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/releases/{project}/{version}", apiHandler.DeleteRelease).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/releases/{project}/{version:latest}/{asset:.+}", apiHandler.DownloadLatestAsset).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/properties/{path:.+}", apiHandler.GetProperties).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/properties/{path:.+}", apiHandler.SetProperties).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/properties/{path:.+}", apiHandler.UpdateProperties).Methods("PATCH")
+	apiRouter.HandleFunc("/repositories/{name}/properties/{path:.+}", apiHandler.DeleteProperties).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/provenance/{path:.+}", apiHandler.GetProvenance).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/provenance/{path:.+}", apiHandler.SetProvenance).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/provenance/{path:.+}", apiHandler.DeleteProvenance).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/signatures/{path:.+}", apiHandler.GetSignature).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/signatures/{path:.+}", apiHandler.SignArtifact).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/trash", apiHandler.ListTrash).Methods("GET")
+	apiRouter.HandleFunc("/repositories/{name}/trash/{id}/restore", apiHandler.RestoreTrash).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/trash/{id}", apiHandler.DeleteTrash).Methods("DELETE")
+	apiRouter.HandleFunc("/repositories/{name}/uploads", apiHandler.CreateUpload).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/uploads/{id}", apiHandler.GetUpload).Methods("GET", "HEAD")
+	apiRouter.HandleFunc("/repositories/{name}/uploads/{id}", apiHandler.AppendUpload).Methods("PATCH")
+	apiRouter.HandleFunc("/repositories/{name}/uploads/{id}", apiHandler.CompleteUpload).Methods("PUT")
+	apiRouter.HandleFunc("/repositories/{name}/uploads/{id}", apiHandler.AbortUpload).Methods("DELETE")
+	apiRouter.HandleFunc("/archives", apiHandler.ListArchives).Methods("GET")
+	apiRouter.HandleFunc("/quarantine", apiHandler.ListQuarantine).Methods("GET")
+	apiRouter.HandleFunc("/quarantine/{id}", apiHandler.DeleteQuarantine).Methods("DELETE")
+	apiRouter.HandleFunc("/tasks", apiHandler.ListTasks).Methods("GET")
+	apiRouter.HandleFunc("/tasks/{id}", apiHandler.GetTask).Methods("GET")
+	apiRouter.HandleFunc("/tasks/{id}/cancel", apiHandler.CancelTask).Methods("POST")
+	apiRouter.HandleFunc("/notifications/subscriptions", apiHandler.ListSubscriptions).Methods("GET")
+	apiRouter.HandleFunc("/notifications/subscriptions", apiHandler.CreateSubscription).Methods("POST")
+	apiRouter.HandleFunc("/notifications/subscriptions/{id}", apiHandler.DeleteSubscription).Methods("DELETE")
+	apiRouter.HandleFunc("/schedules", apiHandler.ListSchedules).Methods("GET")
+	apiRouter.HandleFunc("/schedules", apiHandler.CreateSchedule).Methods("POST")
+	apiRouter.HandleFunc("/schedules/{name}", apiHandler.GetSchedule).Methods("GET")
+	apiRouter.HandleFunc("/schedules/{name}", apiHandler.UpdateSchedule).Methods("PUT")
+	apiRouter.HandleFunc("/schedules/{name}", apiHandler.DeleteSchedule).Methods("DELETE")
+	apiRouter.HandleFunc("/signing-keys", apiHandler.ListSigningKeys).Methods("GET")
+	apiRouter.HandleFunc("/signing-keys", apiHandler.CreateSigningKey).Methods("POST")
+	apiRouter.HandleFunc("/signing-keys/{name}", apiHandler.GetSigningKey).Methods("GET")
+	apiRouter.HandleFunc("/signing-keys/{name}", apiHandler.DeleteSigningKey).Methods("DELETE")
+	apiRouter.HandleFunc("/signing-keys/{name}/rotate", apiHandler.RotateSigningKey).Methods("POST")
+	apiRouter.HandleFunc("/artifacts/copy", apiHandler.CopyArtifacts).Methods("POST")
+	apiRouter.HandleFunc("/artifacts/move", apiHandler.MoveArtifacts).Methods("POST")
+	apiRouter.HandleFunc("/artifacts/search", apiHandler.SearchArtifacts).Methods("GET")
+	apiRouter.HandleFunc("/search/checksum", apiHandler.SearchChecksum).Methods("GET")
+	apiRouter.HandleFunc("/images/search", apiHandler.SearchImages).Methods("GET")
+	apiRouter.HandleFunc("/images/promote", apiHandler.PromoteImage).Methods("POST")
+	apiRouter.HandleFunc("/repositories/{name}/promotions", apiHandler.ListPromotions).Methods("GET")
+	apiRouter.HandleFunc("/presign", apiHandler.PresignURL).Methods("POST")
+	apiRouter.HandleFunc("/admin/log-level", apiHandler.GetLogLevel).Methods("GET")
+	apiRouter.HandleFunc("/admin/log-level", apiHandler.SetLogLevel).Methods("PUT")
+	apiRouter.HandleFunc("/admin/access-log", apiHandler.ListAccessLogSettings).Methods("GET")
+	apiRouter.HandleFunc("/admin/access-log/{name}", apiHandler.GetAccessLogSetting).Methods("GET")
+	apiRouter.HandleFunc("/admin/access-log/{name}", apiHandler.SetAccessLogSetting).Methods("PUT")
+	apiRouter.HandleFunc("/admin/access-log/{name}", apiHandler.ResetAccessLogSetting).Methods("DELETE")
+	apiRouter.HandleFunc("/admin/database", apiHandler.GetDatabaseStats).Methods("GET")
+	apiRouter.HandleFunc("/admin/database/check", apiHandler.CheckDatabase).Methods("POST")
+	apiRouter.HandleFunc("/admin/maintenance", apiHandler.GetMaintenance).Methods("GET")
+	apiRouter.HandleFunc("/admin/maintenance", apiHandler.StartMaintenance).Methods("PUT")
+	apiRouter.HandleFunc("/admin/maintenance", apiHandler.EndMaintenance).Methods("DELETE")
 	
+	s.router.Handle("/metrics", s.metrics.Handler()).Methods("GET")
+	s.router.HandleFunc("/keys/{name}", apiHandler.PublicSigningKey).Methods("GET")
+
 	repoRouter := s.router.PathPrefix("/repository").Subrouter()
+	repoRouter.Use(s.signer.Middleware)
+	repoRouter.Use(s.metrics.Middleware(s.metricsRepository))
 	repoRouter.PathPrefix("/").HandlerFunc(apiHandler.HandleRepository)
-	
-	// Check if any Docker repository is configured to use port 0 (main server port)
-	s.setupDockerRegistryOnMainPort()
+
+	// Docker repositories without a port of their own, or all of them with
+	// path routing, are served as /v2/<repo-name>/<image>/...
+	s.router.PathPrefix("/v2").Handler(s.dockerManager.MainPortHandler())
+}
+
+// SetCluster reports the cluster status of node at /api/v1/cluster
+func (s *Server) SetCluster(node *cluster.Node) {
+	s.router.Handle("/api/v1/cluster", node.StatusHandler()).Methods("GET")
+}
+
+// SetHandoff takes the server's and registries' listeners from h, and
+// makes them available to the next process on upgrade
+func (s *Server) SetHandoff(h *handoff.Handoff) {
+	s.handoff = h
+	s.dockerManager.SetListenFunc(h.Listen)
+}
+
+// SetAccessLogger sends the request log to logger instead of the
+// application log
+func (s *Server) SetAccessLogger(logger *logrus.Logger) {
+	s.accessLogger = logger
+}
+
+// SetAuditLogger sends the audit log, the results of virus scans, to
+// logger instead of the application log
+func (s *Server) SetAuditLogger(logger *logrus.Logger) {
+	if s.scanner != nil {
+		s.scanner.SetAuditLogger(logger)
+	}
+}
+
+// loggingMiddleware logs every request with its request ID, or the share
+// of them the access log sampling of their repository selects
+func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.accessSampling.Sample(s.accessLogRepository(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+
+		wrapped := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(wrapped, r)
+
+		s.accessLogger.WithFields(logrus.Fields{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"client_ip":  forwarded.ClientIP(r),
+			"status":     wrapped.statusCode,
+			"duration":   time.Since(start),
+			"request_id": requestid.FromContext(r.Context()),
+		}).Info("API request")
+	})
+}
+
+// accessLogRepository returns the repository a request is for by its path,
+// or "" if it is for none
+func (s *Server) accessLogRepository(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/v2/") {
+		return s.dockerManager.MainPortRepository(r.URL.Path)
+	}
+	for _, prefix := range []string{"/repository/", "/api/v1/repositories/"} {
+		if rest, found := strings.CutPrefix(r.URL.Path, prefix); found {
+			name, _, _ := strings.Cut(rest, "/")
+			return name
+		}
+	}
+	return ""
+}
+
+// statusRecorder wraps http.ResponseWriter to capture the status code
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rw *statusRecorder) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// ReadFrom lets downloads reach the connection's sendfile support
+func (rw *statusRecorder) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(rw.ResponseWriter, r)
+}
+
+// mainPortTimeouts are the timeouts of the main port not configured
+// otherwise
+var mainPortTimeouts = timeouts.Config{
+	Read:     15 * time.Second,
+	Write:    15 * time.Second,
+	Idle:     60 * time.Second,
+	Transfer: timeouts.DefaultTransfer,
+}
+
+// isTransfer reports whether a request uploads or downloads an artifact,
+// a Docker blob or a chunk of a resumable upload, any of which may take far
+// longer than the server's timeouts
+func isTransfer(r *http.Request) bool {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/repository/"):
+		return true
+	case strings.HasPrefix(r.URL.Path, "/v2/"):
+		return strings.Contains(r.URL.Path, "/blobs/")
+	case strings.HasPrefix(r.URL.Path, "/api/v1/repositories/"):
+		return strings.Contains(r.URL.Path, "/uploads/")
+	}
+	return false
 }
 
 func (s *Server) Start(ctx context.Context) error {
+	started := time.Now()
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 		CipherSuites: []uint16{
@@ -90,16 +608,23 @@ func (s *Server) Start(ctx context.Context) error {
 		},
 	}
 
+	timeoutConfig := s.config.Timeouts.Or(mainPortTimeouts)
 	s.httpServer = &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", s.config.Host, s.config.Port),
-		Handler:      s.router,
-		TLSConfig:    tlsConfig,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:      fmt.Sprintf("%s:%s", s.config.Host, s.config.Port),
+		Handler:   s.config.Connections.Middleware(timeoutConfig.Middleware(isTransfer)(s.router)),
+		TLSConfig: tlsConfig,
 	}
+	timeoutConfig.Apply(s.httpServer)
+	s.config.Connections.Apply(s.httpServer)
 
-	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	listen := net.Listen
+	if s.handoff != nil {
+		listen = s.handoff.Listen
+	}
+	// Keep-alive is set on the TCP connections themselves, before a PROXY
+	// protocol listener wraps them
+	listen = s.config.Connections.Listen(listen)
+	listener, err := listen("tcp", s.httpServer.Addr)
 	if err != nil {
 		return fmt.Errorf("failed to create listener: %w", err)
 	}
@@ -110,8 +635,33 @@ func (s *Server) Start(ctx context.Context) error {
 		s.logger.Infof("Using dynamic port: %s", s.config.Port)
 	}
 
+	if s.config.ProxyProtocol {
+		listener = s.trusted.ProxyListener(listener)
+		s.dockerManager.SetListenFunc(s.trusted.Listen(listen))
+	}
 	tlsListener := tls.NewListener(listener, s.httpServer.TLSConfig)
 
+	if s.config.DebugAddress != "" {
+		if err := s.startDebugServer(listen); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	if s.config.RedirectAddress != "" {
+		if err := s.startRedirectServer(listen); err != nil {
+			listener.Close()
+			if s.debugServer != nil {
+				s.debugServer.Close()
+			}
+			return err
+		}
+	}
+
+	close(s.listening)
+
+	go s.scheduler.Run(ctx)
+	go s.metrics.Run(ctx, time.Minute)
+
 	errChan := make(chan error, 1)
 
 	go func() {
@@ -128,10 +678,14 @@ func (s *Server) Start(ctx context.Context) error {
 		s.httpServer.TLSConfig.Certificates = []tls.Certificate{cert}
 		
 		// Update Docker manager with the loaded TLS config
-		s.dockerManager = docker.NewManager(s.storage, s.httpServer.TLSConfig, s.logger)
+		s.dockerManager.SetTLSConfig(s.httpServer.TLSConfig)
 		
 		// Start existing Docker repositories
 		s.startExistingDockerRepositories()
+		if s.handoff != nil {
+			s.handoff.CloseUnused()
+		}
+		go s.checkConsistency(started)
 		
 		// Use Serve instead of ServeTLS since we already have a TLS listener
 		if err := s.httpServer.Serve(tlsListener); err != nil && err != http.ErrServerClosed {
@@ -158,19 +712,64 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 }
 
+// startDebugServer serves the debug endpoints on their own loopback port
+func (s *Server) startDebugServer(listen func(network, address string) (net.Listener, error)) error {
+	listener, err := listen("tcp", s.config.DebugAddress)
+	if err != nil {
+		return fmt.Errorf("failed to create debug listener: %w", err)
+	}
+	s.config.DebugAddress = listener.Addr().String()
+
+	s.debugServer = &http.Server{
+		Handler:     debug.Handler(filepath.Join(s.config.DataDir, "debug"), s.logger),
+		IdleTimeout: 60 * time.Second,
+	}
+	go func() {
+		s.logger.Infof("Starting debug server on %s", s.config.DebugAddress)
+		if err := s.debugServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.WithError(err).Error("Debug server failed")
+		}
+	}()
+	return nil
+}
+
+// Abort makes the shutdown that follows cut off requests in flight instead
+// of letting them finish, for a node that lost its cluster lease and must
+// stop serving before a standby takes over
+func (s *Server) Abort() {
+	s.aborted.Store(true)
+}
+
 func (s *Server) shutdown() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	if s.debugServer != nil {
+		s.debugServer.Close()
+	}
+	if s.redirectServer != nil {
+		s.redirectServer.Close()
+	}
+
+	if s.aborted.Load() {
+		s.httpServer.Close()
+		s.dockerManager.CloseAll()
+	} else if err := s.httpServer.Shutdown(ctx); err != nil {
 		s.logger.WithError(err).Error("Failed to shutdown HTTP server")
 	}
 
+	// Cancel running tasks before the database they report to is closed
+	s.taskManager.Shutdown()
+
 	// Stop all Docker registries
 	if err := s.dockerManager.StopAll(); err != nil {
 		s.logger.WithError(err).Error("Failed to stop Docker registries")
 	}
 
+	if err := s.metrics.Flush(); err != nil {
+		s.logger.WithError(err).Error("Failed to flush usage metrics")
+	}
+
 	if err := s.db.Close(); err != nil {
 		s.logger.WithError(err).Error("Failed to close database")
 		return err
@@ -179,69 +778,363 @@ func (s *Server) shutdown() error {
 	return nil
 }
 
-func (s *Server) GetPort() string {
-	return s.config.Port
+// recordDownload counts an image pulled from a Docker registry
+func (s *Server) recordDownload(repo, artifact string) {
+	if err := s.metadata.RecordDownload(repo, artifact, time.Now()); err != nil {
+		s.logger.WithError(err).Warnf("Failed to record download of %s/%s", repo, artifact)
+	}
 }
 
-func (s *Server) startExistingDockerRepositories() {
-	// Create a repository manager to list existing repositories
-	repoMgr := repository.NewManager(s.db, s.storage, s.logger)
-	
-	repos, err := repoMgr.List()
+// metricsRepository names the repository a /repository request is for, so
+// its traffic is attributed to it. Requests for unknown repositories are
+// not recorded.
+func (s *Server) metricsRepository(r *http.Request) string {
+	parts := strings.SplitN(r.URL.Path, "/", 4)
+	if len(parts) < 3 {
+		return ""
+	}
+	if _, err := s.repoMgr.Get(parts[2]); err != nil {
+		return ""
+	}
+	return parts[2]
+}
+
+// setupScanner enables virus scanning of uploads if a scanner is configured
+func (s *Server) setupScanner() error {
+	var scanner scan.Scanner
+	var err error
+	switch {
+	case s.config.ClamdAddress != "":
+		scanner, err = scan.NewClamAV(s.config.ClamdAddress)
+	case s.config.ScanCommand != "":
+		scanner, err = scan.NewCommand(s.config.ScanCommand)
+	default:
+		return nil
+	}
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to list repositories")
-		return
+		return err
 	}
-	
-	for _, repo := range repos {
-		if repo.Type == models.RepositoryTypeDocker {
-			var config models.DockerRepositoryConfig
-			if err := json.Unmarshal(repo.Config, &config); err != nil {
-				s.logger.WithError(err).Errorf("Failed to unmarshal Docker config for %s", repo.Name)
-				continue
+
+	s.scanner, err = scan.NewManager(s.db, filepath.Join(s.config.DataDir, "quarantine"), scanner, s.logger)
+	if err != nil {
+		return err
+	}
+	s.scanner.SetOnInfected(s.notifier.UploadInfected)
+	return nil
+}
+
+// setupValidation enables the validation hooks of raw uploads if a hooks
+// file is configured
+func (s *Server) setupValidation() error {
+	if s.config.ValidationHooksFile == "" {
+		return nil
+	}
+	hooks, err := validation.LoadHooks(s.config.ValidationHooksFile)
+	if err != nil {
+		return err
+	}
+	s.validator, err = validation.NewManager(hooks, filepath.Join(s.config.DataDir, "validation"), s.logger)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Validating raw uploads with %d hooks", len(hooks))
+	return nil
+}
+
+// setupNotifications keeps notification subscriptions and tells
+// subscribers about pushes, cleanup runs and infected uploads; email is
+// only sent if an SMTP server is configured
+func (s *Server) setupNotifications() error {
+	var mailer notify.Mailer
+	if s.config.SMTPAddress != "" {
+		smtp, err := notify.NewSMTP(s.config.SMTPAddress, s.config.SMTPFrom, s.config.SMTPUsername, s.config.SMTPPassword)
+		if err != nil {
+			return err
+		}
+		mailer = smtp
+	}
+
+	var err error
+	s.notifier, err = notify.NewManager(s.db, mailer, s.logger)
+	if err != nil {
+		return err
+	}
+	s.cleanupEngine.SetOnDeleted(s.notifier.CleanupDeleted)
+	s.dockerManager.SetPushRecorder(func(repo, image string) {
+		s.notifier.ArtifactsPushed(repo, image)
+	})
+	return nil
+}
+
+// setupSchedules registers the schedulable task types and the built-in
+// schedules defined by server configuration
+func (s *Server) setupSchedules() error {
+	repoMgr := repository.NewManager(s.db, s.storage, s.logger)
+
+	s.scheduler.Register("cleanup", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return s.cleanupEngine.RunAll(ctx, run.SetProgress)
 			}
-			
-			// Skip if port is 0 (will be served on main port)
-			if config.HTTPPort == 0 && config.HTTPSPort == 0 {
-				continue
+
+			repo, err := repoMgr.Get(repoName)
+			if err != nil {
+				return nil, err
 			}
-			
-			if err := s.dockerManager.StartRegistry(repo, &config); err != nil {
-				s.logger.WithError(err).Errorf("Failed to start Docker registry for %s", repo.Name)
+			return s.cleanupEngine.Run(ctx, repo, false)
+		}
+	})
+
+	cleanupSchedule := s.config.CleanupSchedule
+	enabled := cleanupSchedule != "off" && cleanupSchedule != ""
+	if !enabled {
+		cleanupSchedule = "@hourly"
+	}
+	if err := s.scheduler.EnsureBuiltin("cleanup", "cleanup", cleanupSchedule, enabled); err != nil {
+		return fmt.Errorf("failed to configure cleanup schedule: %w", err)
+	}
+
+	s.scheduler.Register("gc", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return s.dockerManager.CollectAllGarbage(ctx)
 			}
+			return s.dockerManager.CollectGarbage(ctx, repoName)
+		}
+	})
+
+	s.scheduler.Register("scrub", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			return s.scrub(ctx, repoName)
+		}
+	})
+
+	s.scheduler.Register("backup", func(string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			return s.backupDatabase()
+		}
+	})
+
+	for _, builtin := range []struct {
+		task, schedule, fallback string
+	}{
+		{"gc", s.config.GCSchedule, "@daily"},
+		{"scrub", s.config.ScrubSchedule, "@weekly"},
+		{"backup", s.config.BackupSchedule, "@daily"},
+	} {
+		schedule := builtin.schedule
+		enabled := schedule != "off" && schedule != ""
+		if !enabled {
+			schedule = builtin.fallback
+		}
+		if err := s.scheduler.EnsureBuiltin(builtin.task, builtin.task, schedule, enabled); err != nil {
+			return fmt.Errorf("failed to configure %s schedule: %w", builtin.task, err)
 		}
 	}
+
+	s.scheduler.Register("federation-sync", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return nil, fmt.Errorf("federation-sync needs a repository")
+			}
+			return s.dockerManager.SyncFederation(ctx, repoName)
+		}
+	})
+
+	s.scheduler.Register("proxy-warm", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return nil, fmt.Errorf("proxy-warm needs a repository")
+			}
+			return s.dockerManager.WarmProxy(ctx, repoName)
+		}
+	})
+
+	s.scheduler.Register("image-sync", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return nil, fmt.Errorf("image-sync needs a repository")
+			}
+			return s.dockerManager.SyncImages(ctx, repoName)
+		}
+	})
+
+	s.scheduler.Register("proxy-evict", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			if repoName == "" {
+				return s.dockerManager.EvictProxyCaches()
+			}
+			return s.dockerManager.EvictProxyCache(repoName)
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("proxy-evict", "proxy-evict", "@every 15m", true); err != nil {
+		return fmt.Errorf("failed to configure proxy cache eviction schedule: %w", err)
+	}
+
+	s.scheduler.Register("buildcache-evict", func(repoName string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			return s.evictBuildCaches(repoMgr, repoName)
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("buildcache-evict", "buildcache-evict", "@every 15m", true); err != nil {
+		return fmt.Errorf("failed to configure build cache eviction schedule: %w", err)
+	}
+
+	s.scheduler.Register("expire-uploads", func(string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			expired, err := s.uploads.Expire(uploadSessionMaxAge)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"expired": expired}, nil
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("expire-uploads", "expire-uploads", "@hourly", true); err != nil {
+		return fmt.Errorf("failed to configure upload expiry schedule: %w", err)
+	}
+
+	s.scheduler.Register("prune-tasks", func(string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			pruned, err := s.taskManager.Prune(taskRetention)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"pruned": pruned}, nil
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("prune-tasks", "prune-tasks", "@hourly", true); err != nil {
+		return fmt.Errorf("failed to configure task pruning schedule: %w", err)
+	}
+
+	s.scheduler.Register("purge-trash", func(string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			purged, err := s.trash.Purge()
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"purged": purged}, nil
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("purge-trash", "purge-trash", "@hourly", true); err != nil {
+		return fmt.Errorf("failed to configure trash purge schedule: %w", err)
+	}
+
+	s.scheduler.Register("expire-artifacts", func(string) tasks.Func {
+		return func(ctx context.Context, run *tasks.Run) (interface{}, error) {
+			removed, err := s.cleanupEngine.ExpireArtifacts(ctx, s.metadata, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"removed": removed}, nil
+		}
+	})
+	if err := s.scheduler.EnsureBuiltin("expire-artifacts", "expire-artifacts", "@every 15m", true); err != nil {
+		return fmt.Errorf("failed to configure artifact expiry schedule: %w", err)
+	}
+
+	return nil
 }
 
-func (s *Server) setupDockerRegistryOnMainPort() {
+// evictBuildCaches evicts from a build cache repository, or without one
+// from every build cache repository with a maximum cache size or a TTL
+func (s *Server) evictBuildCaches(repoMgr *repository.Manager, repoName string) (interface{}, error) {
+	var repos []*models.Repository
+	if repoName != "" {
+		repo, err := repoMgr.Get(repoName)
+		if err != nil {
+			return nil, err
+		}
+		if repo.Type != models.RepositoryTypeBuildCache {
+			return nil, fmt.Errorf("%s is not a build cache repository", repoName)
+		}
+		repos = append(repos, repo)
+	} else {
+		all, err := repoMgr.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range all {
+			if repo.Type == models.RepositoryTypeBuildCache {
+				repos = append(repos, repo)
+			}
+		}
+	}
+
+	reports := []*buildcache.EvictionReport{}
+	for _, repo := range repos {
+		var config models.BuildCacheConfig
+		if repo.Config != nil {
+			if err := json.Unmarshal(repo.Config, &config); err != nil {
+				return reports, fmt.Errorf("%s: invalid configuration: %w", repo.Name, err)
+			}
+		}
+		if config.MaxCacheSize <= 0 && config.EntryTTL() <= 0 {
+			continue
+		}
+		report, err := s.buildCache.Evict(repo.Name, config.MaxCacheSize, config.EntryTTL())
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", repo.Name, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Listening returns a channel that is closed once Start has bound the main
+// port, and the debug and redirect ports if configured, so that GetPort and
+// DebugAddress report the addresses actually in use
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
+}
+
+func (s *Server) GetPort() string {
+	return s.config.Port
+}
+
+// DebugAddress returns the address the debug endpoints listen on, if enabled
+func (s *Server) DebugAddress() string {
+	return s.config.DebugAddress
+}
+
+func (s *Server) startExistingDockerRepositories() {
 	// Create a repository manager to list existing repositories
 	repoMgr := repository.NewManager(s.db, s.storage, s.logger)
 	
 	repos, err := repoMgr.List()
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to list repositories for main port setup")
+		s.readiness.Errorf("docker", "", "failed to list repositories: %v", err)
 		return
 	}
 	
 	for _, repo := range repos {
-		if repo.Type == models.RepositoryTypeDocker {
+		if repo.Type == models.RepositoryTypeDocker && !repo.IsEnabled() {
+			// Offline repositories keep their registry stopped, and their
+			// ports for when they come back
+			s.dockerManager.DisableRegistry(repo.Name)
+			var config models.DockerRepositoryConfig
+			if err := json.Unmarshal(repo.Config, &config); err == nil {
+				s.dockerManager.ReservePorts(repo.Name, &config)
+			}
+		} else if repo.Type == models.RepositoryTypeDocker {
 			var config models.DockerRepositoryConfig
 			if err := json.Unmarshal(repo.Config, &config); err != nil {
-				s.logger.WithError(err).Errorf("Failed to unmarshal Docker config for %s", repo.Name)
+				s.readiness.Errorf("docker", repo.Name, "invalid Docker configuration: %v", err)
 				continue
 			}
 			
-			// Check if this repository should be served on main port
-			if config.HTTPPort == 0 && config.HTTPSPort == 0 {
-				// Create a registry instance for this repository
-				registry := docker.NewRegistry(repo, &config, s.storage, s.logger)
-				
-				// Mount the Docker registry routes on the main router
-				// The registry's router is already set up with the correct paths
-				s.router.PathPrefix("/v2/").Handler(registry.GetRouter())
-				
-				s.logger.WithField("repository", repo.Name).Info("Docker registry mounted on main server port")
-				break // Only one repository can use the main port
+			// Fails if a configured port is unavailable
+			auto := config.HTTPPort == models.PortAuto
+			if err := s.dockerManager.StartRegistry(repo, &config); err != nil {
+				s.readiness.Errorf("docker", repo.Name, "failed to start Docker registry: %v", err)
+				s.dockerManager.ReservePorts(repo.Name, &config)
+				continue
+			}
+			// A port picked for "http_port": "auto" is kept for the next start
+			if auto {
+				repo.Config, _ = json.Marshal(config)
+				if err := repoMgr.Update(repo); err != nil {
+					s.logger.WithError(err).Errorf("Failed to save Docker registry port for %s", repo.Name)
+				}
 			}
 		}
 	}
@@ -0,0 +1,518 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var bucketKeys = []byte("signing-keys")
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	ErrKeyExists   = errors.New("signing key already exists")
+	ErrInvalidKey  = errors.New("invalid signing key")
+)
+
+// Key types. GPG keys are RSA keys published as OpenPGP keys and sign with
+// OpenPGP signatures; RSA keys are published as PEM and sign with PKCS #1
+// v1.5 signatures over SHA-256. Cosign keys are ECDSA P-256 keys published
+// as PEM that sign as "cosign sign-blob" does, so "cosign verify-blob
+// --key" checks their signatures.
+const (
+	TypeGPG    = "gpg"
+	TypeRSA    = "rsa"
+	TypeCosign = "cosign"
+)
+
+// DefaultBits is the size of generated RSA and GPG keys that do not ask
+// for one. Cosign keys are always 256 bits.
+const DefaultBits = 3072
+
+// SignatureExtension returns the extension of detached signature files made
+// by keys of a type: .asc for armored OpenPGP signatures, .sig otherwise
+func SignatureExtension(keyType string) string {
+	if keyType == TypeGPG {
+		return ".asc"
+	}
+	return ".sig"
+}
+
+var keyNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Key is a version of a named signing key. Rotating a key adds a version
+// that signs from then on; earlier versions are retired but still
+// published, so signatures they made can be checked.
+type Key struct {
+	Name        string     `json:"name"`
+	Version     int        `json:"version"`
+	Type        string     `json:"type"`
+	UserID      string     `json:"user_id,omitempty"`
+	Bits        int        `json:"bits"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	PublicKey   string     `json:"public_key"`
+}
+
+// KeyRequest describes a key to generate or import. GPG keys need a user
+// ID, such as "Depot Releases <releases@example.com>".
+type KeyRequest struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	UserID string `json:"user_id,omitempty"`
+	Bits   int    `json:"bits,omitempty"`
+}
+
+// record is a stored key version with its private key, a sealed PKCS #8
+// document
+type record struct {
+	Key
+	Private []byte `json:"private"`
+}
+
+// Manager keeps signing keys in the database, their private keys
+// encrypted with AES-256-GCM under a key derived from a server secret
+type Manager struct {
+	db   *bbolt.DB
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// NewManager creates a key manager encrypting private keys with secret
+func NewManager(db *bbolt.DB, secret []byte) (*Manager, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("a key encryption secret is required")
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketKeys)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing keys bucket: %w", err)
+	}
+
+	sum := sha256.Sum256(secret)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{db: db, aead: aead}, nil
+}
+
+// Validate checks a key request, defaulting its size
+func (req *KeyRequest) Validate() error {
+	if !keyNamePattern.MatchString(req.Name) {
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidKey, req.Name)
+	}
+	switch req.Type {
+	case TypeGPG:
+		if strings.TrimSpace(req.UserID) == "" {
+			return fmt.Errorf("%w: gpg keys need a user_id", ErrInvalidKey)
+		}
+	case TypeRSA:
+	case TypeCosign:
+		if req.Bits != 0 && req.Bits != 256 {
+			return fmt.Errorf("%w: cosign keys are 256 bits", ErrInvalidKey)
+		}
+		req.Bits = 256
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidKey, req.Type)
+	}
+	if req.Bits == 0 {
+		req.Bits = DefaultBits
+	}
+	if req.Bits != 2048 && req.Bits != 3072 && req.Bits != 4096 {
+		return fmt.Errorf("%w: bits must be 2048, 3072 or 4096", ErrInvalidKey)
+	}
+	return nil
+}
+
+// Generate creates a new named key
+func (m *Manager) Generate(req KeyRequest) (*Key, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	private, err := generateKey(req.Type, req.Bits)
+	if err != nil {
+		return nil, err
+	}
+	return m.add(req, private, false)
+}
+
+// Import stores an existing private key as a new named key: an RSA key,
+// PEM-encoded in PKCS #1 or PKCS #8 form, for GPG and RSA keys, or a P-256
+// ECDSA key, in SEC 1 or PKCS #8 form, for cosign keys
+func (m *Manager) Import(req KeyRequest, privatePEM []byte) (*Key, error) {
+	private, err := parsePrivateKey(privatePEM)
+	if err != nil {
+		return nil, err
+	}
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		if req.Type == TypeCosign {
+			return nil, fmt.Errorf("%w: cosign keys must be P-256 ECDSA keys", ErrInvalidKey)
+		}
+		req.Bits = key.N.BitLen()
+	case *ecdsa.PrivateKey:
+		if req.Type != TypeCosign || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: only cosign keys can be ECDSA keys, on the P-256 curve", ErrInvalidKey)
+		}
+		req.Bits = 256
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	return m.add(req, private, false)
+}
+
+// Rotate generates a new version of a key, of the same type and size, and
+// retires the current one
+func (m *Manager) Rotate(name string) (*Key, error) {
+	current, err := m.Active(name)
+	if err != nil {
+		return nil, err
+	}
+	private, err := generateKey(current.Type, current.Bits)
+	if err != nil {
+		return nil, err
+	}
+	return m.add(KeyRequest{Name: name, Type: current.Type, UserID: current.UserID, Bits: current.Bits}, private, true)
+}
+
+// add stores private as the next version of a key. Unless rotating, the
+// key must not exist yet.
+func (m *Manager) add(req KeyRequest, private crypto.Signer, rotate bool) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions, err := m.records(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !rotate && len(versions) > 0 {
+		return nil, ErrKeyExists
+	}
+	if rotate && len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key := Key{Name: req.Name, Version: 1, Type: req.Type, UserID: req.UserID, Bits: req.Bits, CreatedAt: now}
+	if len(versions) > 0 {
+		key.Version = versions[0].Version + 1
+	}
+	switch key.Type {
+	case TypeGPG:
+		pgp := &pgpKey{private: private.(*rsa.PrivateKey), created: now}
+		key.Fingerprint = pgp.Fingerprint()
+		if key.PublicKey, err = pgp.publicKey(key.UserID); err != nil {
+			return nil, err
+		}
+	default:
+		der, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(der)
+		key.Fingerprint = hex.EncodeToString(sum[:])
+		key.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{Key: key, Private: m.seal(der, recordKey(key.Name, key.Version))}
+
+	err = m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketKeys)
+		for _, previous := range versions {
+			if previous.RetiredAt == nil {
+				previous.RetiredAt = &now
+				if err := putRecord(b, previous); err != nil {
+					return err
+				}
+			}
+		}
+		return putRecord(b, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Key, nil
+}
+
+// List returns the current version of every key, by name
+func (m *Manager) List() ([]*Key, error) {
+	keys := []*Key{}
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketKeys).ForEach(func(k, v []byte) error {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.RetiredAt == nil {
+				keys = append(keys, &rec.Key)
+			}
+			return nil
+		})
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, err
+}
+
+// Versions returns every version of a key, newest first
+func (m *Manager) Versions(name string) ([]*Key, error) {
+	records, err := m.records(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	keys := make([]*Key, len(records))
+	for i, rec := range records {
+		keys[i] = &rec.Key
+	}
+	return keys, nil
+}
+
+// Active returns the version of a key that signs
+func (m *Manager) Active(name string) (*Key, error) {
+	versions, err := m.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	return versions[0], nil
+}
+
+// Delete removes every version of a key
+func (m *Manager) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketKeys).Cursor()
+		prefix := []byte(name + "\x00")
+		found := false
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			found = true
+		}
+		if !found {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+}
+
+// PublicKeys returns the public keys of every version of a key, newest
+// first, and their content type: one armored block per version for GPG
+// keys, one PEM block per version for RSA and cosign keys
+func (m *Manager) PublicKeys(name string) (string, []byte, error) {
+	versions, err := m.Versions(name)
+	if err != nil {
+		return "", nil, err
+	}
+	var data []byte
+	for _, key := range versions {
+		data = append(data, key.PublicKey...)
+	}
+	if versions[0].Type == TypeGPG {
+		return "application/pgp-keys", data, nil
+	}
+	return "application/x-pem-file", data, nil
+}
+
+// Sign signs data with the active version of a key. GPG keys make an
+// armored detached OpenPGP signature; RSA keys a base64 PKCS #1 v1.5
+// signature of its SHA-256, and cosign keys a base64 ASN.1 ECDSA signature
+// of it.
+func (m *Manager) Sign(name string, data []byte) (string, *Key, error) {
+	return m.SignReader(name, bytes.NewReader(data))
+}
+
+// SignReader signs the content read from r, as Sign does, without holding
+// it in memory
+func (m *Manager) SignReader(name string, r io.Reader) (string, *Key, error) {
+	records, err := m.records(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(records) == 0 {
+		return "", nil, ErrKeyNotFound
+	}
+	rec := records[0]
+	private, err := m.privateKey(rec)
+	if err != nil {
+		return "", nil, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", nil, err
+	}
+
+	var value []byte
+	switch rec.Type {
+	case TypeGPG:
+		pgp := &pgpKey{private: private.(*rsa.PrivateKey), created: rec.CreatedAt}
+		signature, err := pgp.sign(h, time.Now())
+		return signature, &rec.Key, err
+	case TypeCosign:
+		value, err = ecdsa.SignASN1(rand.Reader, private.(*ecdsa.PrivateKey), h.Sum(nil))
+	default:
+		value, err = rsa.SignPKCS1v15(rand.Reader, private.(*rsa.PrivateKey), crypto.SHA256, h.Sum(nil))
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return base64.StdEncoding.EncodeToString(value) + "\n", &rec.Key, nil
+}
+
+// records returns the stored versions of a key, newest first
+func (m *Manager) records(name string) ([]*record, error) {
+	var records []*record
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketKeys).Cursor()
+		prefix := []byte(name + "\x00")
+		for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+			var rec record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			records = append(records, &rec)
+		}
+		return nil
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].Version > records[j].Version })
+	return records, err
+}
+
+// privateKey decrypts the private key of a stored version
+func (m *Manager) privateKey(rec *record) (crypto.Signer, error) {
+	der, err := m.open(rec.Private, recordKey(rec.Name, rec.Version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", rec.Name, err)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	return private, nil
+}
+
+// seal encrypts data, bound to the record it is stored in
+func (m *Manager) seal(data, binding []byte) []byte {
+	nonce := make([]byte, m.aead.NonceSize())
+	rand.Read(nonce)
+	return m.aead.Seal(nonce, nonce, data, binding)
+}
+
+func (m *Manager) open(sealed, binding []byte) ([]byte, error) {
+	if len(sealed) < m.aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, data := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	return m.aead.Open(nil, nonce, data, binding)
+}
+
+func putRecord(b *bbolt.Bucket, rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put(recordKey(rec.Name, rec.Version), data)
+}
+
+func recordKey(name string, version int) []byte {
+	return []byte(fmt.Sprintf("%s\x00%08d", name, version))
+}
+
+// generateKey creates a private key for a key type
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	var private crypto.Signer
+	var err error
+	if keyType == TypeCosign {
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		private, err = rsa.GenerateKey(rand.Reader, bits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return private, nil
+}
+
+// parsePrivateKey decodes a PEM-encoded RSA or ECDSA private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		if strings.Contains(string(data), "BEGIN PGP PRIVATE KEY BLOCK") {
+			return nil, fmt.Errorf("%w: OpenPGP private keys cannot be imported; export the RSA key as PEM", ErrInvalidKey)
+		}
+		return nil, fmt.Errorf("%w: expected a PEM-encoded private key", ErrInvalidKey)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return private, nil
+	case "EC PRIVATE KEY":
+		private, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		return private, nil
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		switch private := parsed.(type) {
+		case *rsa.PrivateKey:
+			return private, nil
+		case *ecdsa.PrivateKey:
+			return private, nil
+		}
+		return nil, fmt.Errorf("%w: only RSA and ECDSA keys are supported", ErrInvalidKey)
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		return nil, fmt.Errorf("%w: encrypted cosign keys cannot be imported; import the unencrypted EC key as PEM", ErrInvalidKey)
+	}
+	return nil, fmt.Errorf("%w: unsupported PEM block %q", ErrInvalidKey, block.Type)
+}
@@ -0,0 +1,203 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// OpenPGP packet tags, signature types and algorithms (RFC 4880) used to
+// publish RSA keys and sign with them as GPG does
+const (
+	packetSignature = 2
+	packetPublicKey = 6
+	packetUserID    = 13
+
+	sigBinary            = 0x00
+	sigPositiveCertified = 0x13
+
+	algorithmRSA = 1
+	hashSHA256   = 8
+
+	subpacketCreationTime      = 2
+	subpacketIssuer            = 16
+	subpacketKeyFlags          = 27
+	subpacketIssuerFingerprint = 33
+
+	keyFlagsCertifySign = 0x03
+)
+
+// pgpKey is an RSA key as an OpenPGP v4 primary key
+type pgpKey struct {
+	private *rsa.PrivateKey
+	created time.Time
+}
+
+// body returns the body of the key's public key packet
+func (k *pgpKey) body() []byte {
+	var b bytes.Buffer
+	b.WriteByte(4)
+	binary.Write(&b, binary.BigEndian, uint32(k.created.Unix()))
+	b.WriteByte(algorithmRSA)
+	b.Write(mpi(k.private.N))
+	b.Write(mpi(big.NewInt(int64(k.private.E))))
+	return b.Bytes()
+}
+
+// fingerprint returns the v4 fingerprint of the key
+func (k *pgpKey) fingerprint() []byte {
+	body := k.body()
+	h := sha1.New()
+	h.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Fingerprint returns the fingerprint as upper-case hex, as gpg shows it
+func (k *pgpKey) Fingerprint() string {
+	return strings.ToUpper(hex.EncodeToString(k.fingerprint()))
+}
+
+// publicKey returns the armored public key certified for userID
+func (k *pgpKey) publicKey(userID string) (string, error) {
+	body := k.body()
+	var prefix bytes.Buffer
+	prefix.Write([]byte{0x99, byte(len(body) >> 8), byte(len(body))})
+	prefix.Write(body)
+	prefix.WriteByte(0xb4)
+	binary.Write(&prefix, binary.BigEndian, uint32(len(userID)))
+	prefix.WriteString(userID)
+
+	h := sha256.New()
+	h.Write(prefix.Bytes())
+	sig, err := k.signature(sigPositiveCertified, h, k.created, []byte{subpacketKeyFlags, keyFlagsCertifySign})
+	if err != nil {
+		return "", err
+	}
+
+	var packets bytes.Buffer
+	packets.Write(packet(packetPublicKey, body))
+	packets.Write(packet(packetUserID, []byte(userID)))
+	packets.Write(packet(packetSignature, sig))
+	return armor("PGP PUBLIC KEY BLOCK", packets.Bytes()), nil
+}
+
+// sign returns an armored detached signature of the data written to h, a
+// SHA-256 hash
+func (k *pgpKey) sign(h hash.Hash, at time.Time) (string, error) {
+	sig, err := k.signature(sigBinary, h, at)
+	if err != nil {
+		return "", err
+	}
+	return armor("PGP SIGNATURE", packet(packetSignature, sig)), nil
+}
+
+// signature returns the body of a v4 signature packet over the data
+// written to h, a SHA-256 hash, with the creation time, issuer fingerprint
+// and extra hashed subpackets
+func (k *pgpKey) signature(sigType byte, h hash.Hash, at time.Time, extra ...[]byte) ([]byte, error) {
+	fingerprint := k.fingerprint()
+
+	var hashed bytes.Buffer
+	created := make([]byte, 4)
+	binary.BigEndian.PutUint32(created, uint32(at.Unix()))
+	hashed.Write(subpacket(subpacketCreationTime, created))
+	hashed.Write(subpacket(subpacketIssuerFingerprint, append([]byte{4}, fingerprint...)))
+	for _, sp := range extra {
+		hashed.Write(subpacket(sp[0], sp[1:]))
+	}
+
+	var header bytes.Buffer
+	header.Write([]byte{4, sigType, algorithmRSA, hashSHA256})
+	binary.Write(&header, binary.BigEndian, uint16(hashed.Len()))
+	header.Write(hashed.Bytes())
+
+	h.Write(header.Bytes())
+	h.Write([]byte{4, 0xff})
+	binary.Write(h, binary.BigEndian, uint32(header.Len()))
+	digest := h.Sum(nil)
+
+	value, err := rsa.SignPKCS1v15(rand.Reader, k.private, crypto.SHA256, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	unhashed := subpacket(subpacketIssuer, fingerprint[len(fingerprint)-8:])
+	var sig bytes.Buffer
+	sig.Write(header.Bytes())
+	binary.Write(&sig, binary.BigEndian, uint16(len(unhashed)))
+	sig.Write(unhashed)
+	sig.Write(digest[:2])
+	sig.Write(mpi(new(big.Int).SetBytes(value)))
+	return sig.Bytes(), nil
+}
+
+// packet frames a packet body with a new-format header
+func packet(tag byte, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0xc0 | tag)
+	switch n := len(body); {
+	case n < 192:
+		b.WriteByte(byte(n))
+	case n < 8384:
+		n -= 192
+		b.Write([]byte{byte(n>>8) + 192, byte(n)})
+	default:
+		b.WriteByte(0xff)
+		binary.Write(&b, binary.BigEndian, uint32(n))
+	}
+	b.Write(body)
+	return b.Bytes()
+}
+
+// subpacket frames a signature subpacket, which are all short
+func subpacket(kind byte, data []byte) []byte {
+	return append([]byte{byte(len(data) + 1), kind}, data...)
+}
+
+// mpi encodes a multiprecision integer
+func mpi(n *big.Int) []byte {
+	bits := n.BitLen()
+	return append([]byte{byte(bits >> 8), byte(bits)}, n.Bytes()...)
+}
+
+// armor encodes data in ASCII armor with a CRC-24 checksum
+func armor(kind string, data []byte) string {
+	var b strings.Builder
+	b.WriteString("-----BEGIN " + kind + "-----\n\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 64 {
+		b.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	b.WriteString(encoded + "\n")
+
+	crc := crc24(data)
+	b.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\n")
+	b.WriteString("-----END " + kind + "-----\n")
+	return b.String()
+}
+
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, c := range data {
+		crc ^= uint32(c) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
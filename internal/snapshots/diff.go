@@ -0,0 +1,83 @@
+package snapshots
+
+import "sort"
+
+// Kinds of change
+const (
+	KindArtifact = "artifact"
+	KindTag      = "tag"
+	KindAlias    = "alias"
+)
+
+// Change is an artifact, tag or alias that differs between two states of a
+// repository. From and To are the SHA-256 of an artifact, the digest a tag
+// points at or the target of an alias, empty where it did not exist.
+type Change struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Diff lists what changed between two states of a repository
+type Diff struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Added   []Change `json:"added"`
+	Removed []Change `json:"removed"`
+	Changed []Change `json:"changed"`
+}
+
+// Compare lists what changed from one snapshot to another
+func Compare(from, to *Snapshot) *Diff {
+	diff := &Diff{From: from.Name, To: to.Name, Added: []Change{}, Removed: []Change{}, Changed: []Change{}}
+	diff.compare(KindArtifact, from.artifactIndex(), to.artifactIndex())
+	diff.compare(KindTag, from.tagIndex(), to.tagIndex())
+	diff.compare(KindAlias, from.Aliases, to.Aliases)
+	return diff
+}
+
+func (d *Diff) compare(kind string, from, to map[string]string) {
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		before, existed := from[name]
+		after, exists := to[name]
+		change := Change{Kind: kind, Name: name, From: before, To: after}
+		switch {
+		case !existed:
+			d.Added = append(d.Added, change)
+		case !exists:
+			d.Removed = append(d.Removed, change)
+		case before != after:
+			d.Changed = append(d.Changed, change)
+		}
+	}
+}
+
+// artifactIndex maps the snapshot's artifact paths to their SHA-256
+func (s *Snapshot) artifactIndex() map[string]string {
+	index := make(map[string]string, len(s.Artifacts))
+	for _, artifact := range s.Artifacts {
+		index[artifact.Path] = artifact.SHA256
+	}
+	return index
+}
+
+// tagIndex maps the snapshot's image:tag references to their digests
+func (s *Snapshot) tagIndex() map[string]string {
+	index := make(map[string]string, len(s.Tags))
+	for _, tag := range s.Tags {
+		index[tag.Image+":"+tag.Tag] = tag.Digest
+	}
+	return index
+}
@@ -0,0 +1,187 @@
+// Package snapshots keeps named snapshots of what a repository held at a
+// point in time: the tags of a Docker repository with the digests they
+// pointed at, or the artifacts of a raw repository with their checksums,
+// along with its aliases. Snapshots record metadata only, no content, and
+// can be compared with each other or with the repository as it is now.
+package snapshots
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketSnapshots = []byte("snapshots")
+	ErrNotFound     = errors.New("snapshot not found")
+	ErrExists       = errors.New("snapshot already exists")
+	ErrInvalidName  = errors.New("invalid snapshot name")
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Snapshot is the state of a repository when it was taken
+type Snapshot struct {
+	Repository  string            `json:"repository"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	Artifacts   []Artifact        `json:"artifacts,omitempty"`
+	Tags        []Tag             `json:"tags,omitempty"`
+	Aliases     map[string]string `json:"aliases,omitempty"`
+}
+
+// Artifact is a raw artifact in a snapshot
+type Artifact struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// Tag is a tag of a Docker image in a snapshot
+type Tag struct {
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+}
+
+// Summary describes a snapshot without listing its content
+type Summary struct {
+	Repository  string    `json:"repository"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Artifacts   int       `json:"artifacts"`
+	Tags        int       `json:"tags"`
+	Aliases     int       `json:"aliases"`
+}
+
+// Summary describes the snapshot
+func (s *Snapshot) Summary() *Summary {
+	return &Summary{
+		Repository:  s.Repository,
+		Name:        s.Name,
+		Description: s.Description,
+		CreatedAt:   s.CreatedAt,
+		Artifacts:   len(s.Artifacts),
+		Tags:        len(s.Tags),
+		Aliases:     len(s.Aliases),
+	}
+}
+
+// Store keeps snapshots in bbolt
+type Store struct {
+	db *bbolt.DB
+}
+
+// NewStore creates a snapshot store backed by db
+func NewStore(db *bbolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketSnapshots)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshots bucket: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Save stores a new snapshot. Names are unique within a repository.
+func (s *Store) Save(snapshot *Snapshot) error {
+	if !namePattern.MatchString(snapshot.Name) || len(snapshot.Name) > 128 {
+		return ErrInvalidName
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketSnapshots)
+		k := key(snapshot.Repository, snapshot.Name)
+		if bucket.Get(k) != nil {
+			return ErrExists
+		}
+		return bucket.Put(k, data)
+	})
+}
+
+// Get returns a snapshot of a repository by name
+func (s *Store) Get(repo, name string) (*Snapshot, error) {
+	var snapshot Snapshot
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketSnapshots).Get(key(repo, name))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &snapshot)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// List describes the snapshots of a repository, oldest first
+func (s *Store) List(repo string) ([]*Summary, error) {
+	summaries := []*Summary{}
+
+	prefix := key(repo, "")
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketSnapshots).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var snapshot Snapshot
+			if err := json.Unmarshal(v, &snapshot); err != nil {
+				return fmt.Errorf("failed to unmarshal snapshot %s: %w", k, err)
+			}
+			summaries = append(summaries, snapshot.Summary())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.Before(summaries[j].CreatedAt)
+	})
+	return summaries, nil
+}
+
+// Delete removes a snapshot
+func (s *Store) Delete(repo, name string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketSnapshots)
+		k := key(repo, name)
+		if bucket.Get(k) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete(k)
+	})
+}
+
+// DeleteRepository removes every snapshot of a repository
+func (s *Store) DeleteRepository(repo string) error {
+	prefix := key(repo, "")
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketSnapshots).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func key(repo, name string) []byte {
+	return []byte(repo + "\x00" + name)
+}
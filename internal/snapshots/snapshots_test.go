@@ -0,0 +1,93 @@
+package snapshots
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func newTestStore(t *testing.T) *Store {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "snapshots.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewStore(db)
+	require.NoError(t, err)
+	return store
+}
+
+func TestStore(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now().UTC()
+
+	require.NoError(t, store.Save(&Snapshot{Repository: "prod", Name: "before-deploy", CreatedAt: now, Tags: []Tag{{Image: "web", Tag: "1.0", Digest: "sha256:aaa"}}}))
+	require.NoError(t, store.Save(&Snapshot{Repository: "prod", Name: "after-deploy", CreatedAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Save(&Snapshot{Repository: "prod-eu", Name: "before-deploy", CreatedAt: now}))
+
+	assert.ErrorIs(t, store.Save(&Snapshot{Repository: "prod", Name: "before-deploy"}), ErrExists)
+	assert.ErrorIs(t, store.Save(&Snapshot{Repository: "prod", Name: "../etc"}), ErrInvalidName)
+
+	summaries, err := store.List("prod")
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "before-deploy", summaries[0].Name)
+	assert.Equal(t, 1, summaries[0].Tags)
+	assert.Equal(t, "after-deploy", summaries[1].Name)
+
+	snapshot, err := store.Get("prod", "before-deploy")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:aaa", snapshot.Tags[0].Digest)
+
+	require.NoError(t, store.Delete("prod", "after-deploy"))
+	assert.ErrorIs(t, store.Delete("prod", "after-deploy"), ErrNotFound)
+
+	require.NoError(t, store.DeleteRepository("prod"))
+	_, err = store.Get("prod", "before-deploy")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Get("prod-eu", "before-deploy")
+	assert.NoError(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	from := &Snapshot{
+		Name: "friday",
+		Artifacts: []Artifact{
+			{Path: "app/1.0/app.bin", SHA256: "aaa"},
+			{Path: "app/latest.txt", SHA256: "bbb"},
+		},
+		Tags:    []Tag{{Image: "web", Tag: "1.0", Digest: "sha256:111"}, {Image: "web", Tag: "latest", Digest: "sha256:111"}},
+		Aliases: map[string]string{"app/latest.bin": "app/1.0/app.bin"},
+	}
+	to := &Snapshot{
+		Name: "monday",
+		Artifacts: []Artifact{
+			{Path: "app/1.0/app.bin", SHA256: "aaa"},
+			{Path: "app/2.0/app.bin", SHA256: "ccc"},
+			{Path: "app/latest.txt", SHA256: "ddd"},
+		},
+		Tags:    []Tag{{Image: "web", Tag: "1.0", Digest: "sha256:111"}, {Image: "web", Tag: "latest", Digest: "sha256:222"}, {Image: "web", Tag: "2.0", Digest: "sha256:222"}},
+		Aliases: map[string]string{"app/latest.bin": "app/2.0/app.bin"},
+	}
+
+	diff := Compare(from, to)
+	assert.Equal(t, "friday", diff.From)
+	assert.Equal(t, "monday", diff.To)
+	assert.Equal(t, []Change{
+		{Kind: KindArtifact, Name: "app/2.0/app.bin", To: "ccc"},
+		{Kind: KindTag, Name: "web:2.0", To: "sha256:222"},
+	}, diff.Added)
+	assert.Equal(t, []Change{
+		{Kind: KindArtifact, Name: "app/latest.txt", From: "bbb", To: "ddd"},
+		{Kind: KindTag, Name: "web:latest", From: "sha256:111", To: "sha256:222"},
+		{Kind: KindAlias, Name: "app/latest.bin", From: "app/1.0/app.bin", To: "app/2.0/app.bin"},
+	}, diff.Changed)
+	assert.Empty(t, diff.Removed)
+
+	diff = Compare(to, from)
+	assert.Len(t, diff.Removed, 2)
+	assert.Empty(t, diff.Added)
+}
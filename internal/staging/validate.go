@@ -0,0 +1,190 @@
+// Package staging validates the content of staging repositories before
+// they are promoted into a release repository
+package staging
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/pkg/models"
+)
+
+// signatureHeader starts an ASCII-armored PGP signature
+const signatureHeader = "-----BEGIN PGP SIGNATURE-----"
+
+// checksumExtensions are the checksum files Maven and Gradle upload.
+// SHA-512 files are accepted but not verified.
+var checksumExtensions = []string{"md5", "sha1", "sha256", "sha512"}
+
+// Content gives validation access to the files of a staging repository
+type Content interface {
+	// Read returns a small file such as a checksum, signature or POM
+	Read(artifactPath string) ([]byte, error)
+	// Sums returns the checksums of an artifact
+	Sums(artifactPath string) (checksum.Sums, error)
+}
+
+// Validate checks the files of a staging repository against rules and
+// describes every problem found. Checksum files must always match their
+// artifacts. Signature files are only checked to be ASCII-armored PGP
+// signatures of an artifact present; nothing verifies who signed them.
+func Validate(paths []string, content Content, rules *models.StagingRules) []string {
+	if rules == nil {
+		rules = &models.StagingRules{}
+	}
+	if len(paths) == 0 {
+		return []string{"staging repository is empty"}
+	}
+
+	paths = append([]string{}, paths...)
+	sort.Strings(paths)
+	exists := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		exists[p] = true
+	}
+
+	problems := []string{}
+	for _, p := range paths {
+		ext := strings.TrimPrefix(path.Ext(p), ".")
+		base := strings.TrimSuffix(p, path.Ext(p))
+
+		switch {
+		case isChecksum(ext):
+			if !exists[base] {
+				problems = append(problems, fmt.Sprintf("%s: checksum of missing artifact %s", p, base))
+			} else if err := verifyChecksum(content, p, base, ext); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", p, err))
+			}
+			continue
+		case ext == "asc":
+			if !exists[base] {
+				problems = append(problems, fmt.Sprintf("%s: signature of missing artifact %s", p, base))
+			}
+			data, err := content.Read(p)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", p, err))
+			} else if !strings.HasPrefix(strings.TrimSpace(string(data)), signatureHeader) {
+				problems = append(problems, fmt.Sprintf("%s: not an ASCII-armored PGP signature", p))
+			}
+		default:
+			if rules.RequireSignatureFiles && !exists[p+".asc"] {
+				problems = append(problems, fmt.Sprintf("%s: missing signature %s.asc", p, p))
+			}
+			if rules.ValidatePOMs && ext == "pom" {
+				data, err := content.Read(p)
+				if err == nil {
+					err = ValidatePOM(data, p)
+				}
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", p, err))
+				}
+			}
+		}
+
+		if rules.RequireChecksums && !hasChecksum(exists, p) {
+			problems = append(problems, fmt.Sprintf("%s: missing checksum (.md5, .sha1 or .sha256)", p))
+		}
+	}
+	return problems
+}
+
+func isChecksum(ext string) bool {
+	for _, e := range checksumExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func hasChecksum(exists map[string]bool, p string) bool {
+	for _, algorithm := range checksum.Algorithms {
+		if exists[p+"."+algorithm] {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyChecksum compares a checksum file, which may hold the digest
+// followed by a file name, with the digest of its artifact
+func verifyChecksum(content Content, checksumPath, artifactPath, algorithm string) error {
+	data, err := content.Read(checksumPath)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return errors.New("empty checksum")
+	}
+	sums, err := content.Sums(artifactPath)
+	if err != nil {
+		return err
+	}
+	actual := sums.Get(algorithm)
+	if actual == "" {
+		return nil
+	}
+	if !strings.EqualFold(fields[0], actual) {
+		return fmt.Errorf("%s checksum does not match %s", algorithm, artifactPath)
+	}
+	return nil
+}
+
+// pom holds the coordinates of a Maven POM
+type pom struct {
+	XMLName    xml.Name
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Parent     struct {
+		GroupID string `xml:"groupId"`
+		Version string `xml:"version"`
+	} `xml:"parent"`
+}
+
+// ValidatePOM checks that a Maven POM names a release, with its group,
+// artifact and version inherited from its parent if need be, and is stored
+// at the path those coordinates give it
+func ValidatePOM(data []byte, artifactPath string) error {
+	var project pom
+	if err := xml.Unmarshal(data, &project); err != nil {
+		return fmt.Errorf("invalid POM: %v", err)
+	}
+	if project.XMLName.Local != "project" {
+		return errors.New("invalid POM: root element is not project")
+	}
+
+	groupID := strings.TrimSpace(project.GroupID)
+	if groupID == "" {
+		groupID = strings.TrimSpace(project.Parent.GroupID)
+	}
+	artifactID := strings.TrimSpace(project.ArtifactID)
+	version := strings.TrimSpace(project.Version)
+	if version == "" {
+		version = strings.TrimSpace(project.Parent.Version)
+	}
+
+	for _, field := range []struct{ name, value string }{{"groupId", groupID}, {"artifactId", artifactID}, {"version", version}} {
+		if field.value == "" {
+			return fmt.Errorf("POM has no %s", field.name)
+		}
+		if strings.Contains(field.value, "${") {
+			return fmt.Errorf("POM %s %q has an unresolved property", field.name, field.value)
+		}
+	}
+	if strings.HasSuffix(version, "-SNAPSHOT") {
+		return fmt.Errorf("POM version %s is a snapshot", version)
+	}
+
+	expected := path.Join(strings.ReplaceAll(groupID, ".", "/"), artifactID, version, artifactID+"-"+version+".pom")
+	if artifactPath != expected {
+		return fmt.Errorf("POM for %s:%s:%s should be at %s", groupID, artifactID, version, expected)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package staging
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/checksum"
+	"github.com/depot/depot/pkg/models"
+)
+
+// files is staging content held in memory
+type files map[string]string
+
+func (f files) Read(artifactPath string) ([]byte, error) {
+	data, exists := f[artifactPath]
+	if !exists {
+		return nil, fmt.Errorf("%s not found", artifactPath)
+	}
+	return []byte(data), nil
+}
+
+func (f files) Sums(artifactPath string) (checksum.Sums, error) {
+	data := []byte(f[artifactPath])
+	return checksum.Sums{MD5: fmt.Sprintf("%x", md5.Sum(data)), SHA1: fmt.Sprintf("%x", sha1.Sum(data))}, nil
+}
+
+func (f files) paths() []string {
+	paths := make([]string, 0, len(f))
+	for p := range f {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+const validPOM = `<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>1.0</version></project>`
+
+func TestValidate(t *testing.T) {
+	jar := "jar content"
+	release := files{
+		"com/example/lib/1.0/lib-1.0.jar":          jar,
+		"com/example/lib/1.0/lib-1.0.jar.sha1":     fmt.Sprintf("%x  lib-1.0.jar\n", sha1.Sum([]byte(jar))),
+		"com/example/lib/1.0/lib-1.0.jar.asc":      "-----BEGIN PGP SIGNATURE-----\n...",
+		"com/example/lib/1.0/lib-1.0.jar.asc.md5":  fmt.Sprintf("%x", md5.Sum([]byte("-----BEGIN PGP SIGNATURE-----\n..."))),
+		"com/example/lib/1.0/lib-1.0.pom":          validPOM,
+		"com/example/lib/1.0/lib-1.0.pom.sha1":     fmt.Sprintf("%x", sha1.Sum([]byte(validPOM))),
+		"com/example/lib/1.0/lib-1.0.pom.asc":      "-----BEGIN PGP SIGNATURE-----\n...",
+		"com/example/lib/1.0/lib-1.0.pom.asc.sha1": fmt.Sprintf("%x", sha1.Sum([]byte("-----BEGIN PGP SIGNATURE-----\n..."))),
+	}
+	strict := &models.StagingRules{RequireChecksums: true, RequireSignatureFiles: true, ValidatePOMs: true}
+	assert.Empty(t, Validate(release.paths(), release, strict))
+
+	assert.Equal(t, []string{"staging repository is empty"}, Validate(nil, files{}, nil))
+
+	broken := files{
+		"app.bin":        "app",
+		"app.bin.sha1":   "0000",
+		"app.bin.asc":    "not a signature",
+		"gone.bin.md5":   "0000",
+		"unsigned.bin":   "data",
+		"unsigned.bin.a": "x",
+	}
+	problems := Validate(broken.paths(), broken, &models.StagingRules{RequireChecksums: true, RequireSignatureFiles: true})
+	assert.Equal(t, []string{
+		"app.bin.asc: not an ASCII-armored PGP signature",
+		"app.bin.asc: missing checksum (.md5, .sha1 or .sha256)",
+		"app.bin.sha1: sha1 checksum does not match app.bin",
+		"gone.bin.md5: checksum of missing artifact gone.bin",
+		"unsigned.bin: missing signature unsigned.bin.asc",
+		"unsigned.bin: missing checksum (.md5, .sha1 or .sha256)",
+		"unsigned.bin.a: missing signature unsigned.bin.a.asc",
+		"unsigned.bin.a: missing checksum (.md5, .sha1 or .sha256)",
+	}, problems)
+
+	// Without rules only mismatched checksums are problems
+	problems = Validate(broken.paths(), broken, nil)
+	assert.Len(t, problems, 3)
+}
+
+func TestValidatePOM(t *testing.T) {
+	require.NoError(t, ValidatePOM([]byte(validPOM), "com/example/lib/1.0/lib-1.0.pom"))
+	inherited := `<project><parent><groupId>com.example</groupId><version>2.0</version></parent><artifactId>child</artifactId></project>`
+	require.NoError(t, ValidatePOM([]byte(inherited), "com/example/child/2.0/child-2.0.pom"))
+
+	tests := map[string]string{
+		"not xml":             "POM",
+		`<pom></pom>`:         "root element",
+		`<project></project>`: "no groupId",
+		`<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>${revision}</version></project>`:  "unresolved property",
+		`<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>1.0-SNAPSHOT</version></project>`: "snapshot",
+		`<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>1.1</version></project>`:          "should be at com/example/lib/1.1/lib-1.1.pom",
+	}
+	for pom, message := range tests {
+		err := ValidatePOM([]byte(pom), "com/example/lib/1.0/lib-1.0.pom")
+		require.Error(t, err, pom)
+		assert.Contains(t, err.Error(), message)
+	}
+}
@@ -1,17 +1,33 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
+var ErrNotFound = errors.New("file not found")
+
+// UploadPrefix names in-progress uploads, which List does not report
+const UploadPrefix = ".upload-"
+
 type Storage interface {
 	Store(repo, path string, reader io.Reader) error
 	Retrieve(repo, path string) (io.ReadCloser, error)
 	Delete(repo, path string) error
 	Exists(repo, path string) (bool, error)
+	List(repo, prefix string) ([]FileInfo, error)
+	Stat(repo, path string) (*FileInfo, error)
+}
+
+type FileInfo struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
 }
 
 type FileStorage struct {
@@ -32,18 +48,32 @@ func (fs *FileStorage) Store(repo, path string, reader io.Reader) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	file, err := os.Create(fullPath)
+	// Write to a temporary file and rename it into place, so a failed or
+	// rejected upload never clobbers an existing file
+	file, err := os.CreateTemp(dir, UploadPrefix+"*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer file.Close()
+	tmpPath := file.Name()
 
 	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		os.Remove(fullPath)
+		os.Remove(tmpPath)
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+
 	return nil
 }
 
@@ -52,7 +82,7 @@ func (fs *FileStorage) Retrieve(repo, path string) (io.ReadCloser, error) {
 	file, err := os.Open(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("file not found")
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
@@ -78,4 +108,62 @@ func (fs *FileStorage) Exists(repo, path string) (bool, error) {
 		return false, nil
 	}
 	return false, err
-}
\ No newline at end of file
+}
+
+// Stat returns the size and modification time of a stored file
+func (fs *FileStorage) Stat(repo, path string) (*FileInfo, error) {
+	fullPath := filepath.Join(fs.basePath, repo, path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	return &FileInfo{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// List returns every file stored at or below prefix. Paths are relative to
+// the repository root and always use forward slashes.
+func (fs *FileStorage) List(repo, prefix string) ([]FileInfo, error) {
+	repoRoot := filepath.Join(fs.basePath, repo)
+	root := filepath.Join(repoRoot, prefix)
+
+	var files []FileInfo
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), UploadPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(repoRoot, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileInfo{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return files, nil
+}
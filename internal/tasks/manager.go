@@ -0,0 +1,348 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/fairqueue"
+)
+
+var (
+	bucketTasks       = []byte("tasks")
+	ErrTaskNotFound   = errors.New("task not found")
+	ErrTaskNotRunning = errors.New("task is not running")
+)
+
+// Status is the lifecycle state of a task
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Task is the persisted record of a background job
+type Task struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Repository string          `json:"repository,omitempty"`
+	Status     Status          `json:"status"`
+	Completed  int64           `json:"completed"`
+	Total      int64           `json:"total"`
+	Message    string          `json:"message,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Func is the body of a task. It should return promptly once ctx is
+// cancelled. The returned value is stored as the task result.
+type Func func(ctx context.Context, run *Run) (interface{}, error)
+
+// Manager runs tasks in the background and persists their state
+type Manager struct {
+	db      *bbolt.DB
+	logger  *logrus.Logger
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+	queue   *fairqueue.Queue
+}
+
+// NewManager creates a task manager. Tasks left running by a previous
+// process are marked as failed, since their goroutines no longer exist.
+func NewManager(db *bbolt.DB, logger *logrus.Logger) (*Manager, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketTasks)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return b.ForEach(func(k, v []byte) error {
+			var task Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return nil
+			}
+			if task.Status != StatusPending && task.Status != StatusRunning {
+				return nil
+			}
+			task.Status = StatusFailed
+			task.Error = "interrupted by server restart"
+			task.FinishedAt = &now
+			data, err := json.Marshal(&task)
+			if err != nil {
+				return err
+			}
+			return b.Put(k, data)
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize tasks: %w", err)
+	}
+
+	return &Manager{
+		db:      db,
+		logger:  logger,
+		cancels: make(map[string]context.CancelFunc),
+	}, nil
+}
+
+// SetQueue has tasks wait in queue, by their repository, before they run.
+// A task stays pending while it waits.
+func (m *Manager) SetQueue(queue *fairqueue.Queue) {
+	m.queue = queue
+}
+
+// Submit records a new task and starts it in the background
+func (m *Manager) Submit(taskType, repository string, fn Func) (*Task, error) {
+	task := &Task{
+		ID:         uuid.New().String(),
+		Type:       taskType,
+		Repository: repository,
+		Status:     StatusPending,
+		CreatedAt:  time.Now(),
+	}
+	if err := m.save(task); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.cancels[task.ID] = cancel
+	m.mu.Unlock()
+
+	run := &Run{manager: m, task: *task}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			m.mu.Lock()
+			delete(m.cancels, task.ID)
+			m.mu.Unlock()
+			cancel()
+		}()
+		run.execute(ctx, fn)
+	}()
+
+	return task, nil
+}
+
+// Get returns a task by ID
+func (m *Manager) Get(id string) (*Task, error) {
+	var task Task
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketTasks).Get([]byte(id))
+		if data == nil {
+			return ErrTaskNotFound
+		}
+		return json.Unmarshal(data, &task)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+// List returns all tasks, most recent first
+func (m *Manager) List() ([]*Task, error) {
+	tasks := []*Task{}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTasks).ForEach(func(k, v []byte) error {
+			var task Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return fmt.Errorf("failed to unmarshal task %s: %w", k, err)
+			}
+			tasks = append(tasks, &task)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.After(tasks[j].CreatedAt)
+	})
+	return tasks, nil
+}
+
+// Prune deletes finished tasks that finished longer than maxAge ago and
+// returns how many were deleted
+func (m *Manager) Prune(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	pruned := 0
+
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketTasks)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var task Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				return fmt.Errorf("failed to unmarshal task %s: %w", k, err)
+			}
+			if task.FinishedAt != nil && task.FinishedAt.Before(cutoff) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(expired)
+		return nil
+	})
+	return pruned, err
+}
+
+// Cancel requests cancellation of a pending or running task
+func (m *Manager) Cancel(id string) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	cancel, exists := m.cancels[id]
+	m.mu.Unlock()
+
+	if !exists {
+		return ErrTaskNotRunning
+	}
+	cancel()
+	return nil
+}
+
+// Shutdown cancels every running task and waits for them to finish
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	for _, cancel := range m.cancels {
+		cancel()
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+func (m *Manager) save(task *Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTasks).Put([]byte(task.ID), data)
+	})
+}
+
+// Run is the handle a task body uses to report progress
+type Run struct {
+	manager *Manager
+	mu      sync.Mutex
+	task    Task
+}
+
+// ID returns the ID of the running task
+func (r *Run) ID() string {
+	return r.task.ID
+}
+
+// SetProgress records how many of the task's units of work are complete
+func (r *Run) SetProgress(completed, total int64) {
+	r.update(func(task *Task) {
+		task.Completed = completed
+		task.Total = total
+	})
+}
+
+// SetMessage records a human-readable status message
+func (r *Run) SetMessage(message string) {
+	r.update(func(task *Task) {
+		task.Message = message
+	})
+}
+
+func (r *Run) update(fn func(task *Task)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fn(&r.task)
+	if err := r.manager.save(&r.task); err != nil {
+		r.manager.logger.WithError(err).WithField("task", r.task.ID).Error("Failed to persist task state")
+	}
+}
+
+func (r *Run) execute(ctx context.Context, fn Func) {
+	release, err := r.manager.queue.Acquire(ctx, r.task.Repository)
+	if err != nil {
+		r.update(func(task *Task) {
+			now := time.Now()
+			task.Status = StatusCancelled
+			task.FinishedAt = &now
+		})
+		return
+	}
+	defer release()
+
+	r.update(func(task *Task) {
+		now := time.Now()
+		task.Status = StatusRunning
+		task.StartedAt = &now
+	})
+
+	result, err := fn(ctx, r)
+
+	r.update(func(task *Task) {
+		now := time.Now()
+		task.FinishedAt = &now
+
+		// Work that finished before it noticed a cancellation succeeded
+		switch {
+		case ctx.Err() != nil && errors.Is(err, context.Canceled):
+			task.Status = StatusCancelled
+		case err != nil:
+			task.Status = StatusFailed
+			task.Error = err.Error()
+		default:
+			task.Status = StatusSucceeded
+		}
+
+		if result != nil {
+			if data, err := json.Marshal(result); err == nil {
+				task.Result = data
+			}
+		}
+	})
+
+	entry := r.manager.logger.WithFields(logrus.Fields{
+		"task":   r.task.ID,
+		"type":   r.task.Type,
+		"status": r.task.Status,
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Info("Task finished")
+}
@@ -0,0 +1,175 @@
+package tasks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/fairqueue"
+)
+
+func openTestDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "tasks.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func waitForStatus(t *testing.T, m *Manager, id string, status Status) *Task {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		task, err := m.Get(id)
+		require.NoError(t, err)
+		if task.Status == status {
+			return task
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not reach status %s", id, status)
+	return nil
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	m, err := NewManager(openTestDB(t), logrus.New())
+	require.NoError(t, err)
+	defer m.Shutdown()
+
+	t.Run("Success", func(t *testing.T) {
+		task, err := m.Submit("test", "repo", func(ctx context.Context, run *Run) (interface{}, error) {
+			run.SetProgress(1, 2)
+			run.SetProgress(2, 2)
+			return map[string]int{"deleted": 3}, nil
+		})
+		require.NoError(t, err)
+
+		done := waitForStatus(t, m, task.ID, StatusSucceeded)
+		assert.Equal(t, int64(2), done.Completed)
+		assert.Equal(t, int64(2), done.Total)
+		assert.JSONEq(t, `{"deleted":3}`, string(done.Result))
+		assert.NotNil(t, done.FinishedAt)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		task, err := m.Submit("test", "", func(ctx context.Context, run *Run) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		require.NoError(t, err)
+
+		waitForStatus(t, m, task.ID, StatusRunning)
+		require.NoError(t, m.Cancel(task.ID))
+		waitForStatus(t, m, task.ID, StatusCancelled)
+
+		assert.Equal(t, ErrTaskNotRunning, m.Cancel(task.ID))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		all, err := m.List()
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+		assert.Equal(t, StatusCancelled, all[0].Status)
+	})
+}
+
+func TestInterruptedTasksMarkedFailed(t *testing.T) {
+	db := openTestDB(t)
+	m, err := NewManager(db, logrus.New())
+	require.NoError(t, err)
+
+	task := &Task{ID: "stale", Type: "test", Status: StatusRunning, CreatedAt: time.Now()}
+	require.NoError(t, m.save(task))
+
+	m, err = NewManager(db, logrus.New())
+	require.NoError(t, err)
+	restored, err := m.Get("stale")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, restored.Status)
+	assert.NotEmpty(t, restored.Error)
+}
+
+func TestQueuedTasks(t *testing.T) {
+	m, err := NewManager(openTestDB(t), logrus.New())
+	require.NoError(t, err)
+	defer m.Shutdown()
+	m.SetQueue(fairqueue.New("tasks", 1, 0))
+
+	block := make(chan struct{})
+	first, err := m.Submit("test", "repo", func(ctx context.Context, run *Run) (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	require.NoError(t, err)
+	waitForStatus(t, m, first.ID, StatusRunning)
+
+	second, err := m.Submit("test", "repo", func(ctx context.Context, run *Run) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+	third, err := m.Submit("test", "other", func(ctx context.Context, run *Run) (interface{}, error) {
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	// Queued tasks stay pending and can be cancelled before they start
+	time.Sleep(20 * time.Millisecond)
+	task, err := m.Get(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPending, task.Status)
+	require.NoError(t, m.Cancel(third.ID))
+	cancelled := waitForStatus(t, m, third.ID, StatusCancelled)
+	assert.Nil(t, cancelled.StartedAt)
+
+	close(block)
+	waitForStatus(t, m, first.ID, StatusSucceeded)
+	waitForStatus(t, m, second.ID, StatusSucceeded)
+}
+
+func TestFinishedDespiteCancel(t *testing.T) {
+	m, err := NewManager(openTestDB(t), logrus.New())
+	require.NoError(t, err)
+	defer m.Shutdown()
+
+	// Work that completes without noticing the cancellation succeeded
+	cancelled := make(chan struct{})
+	task, err := m.Submit("test", "", func(ctx context.Context, run *Run) (interface{}, error) {
+		<-cancelled
+		return map[string]int{"deleted": 1}, nil
+	})
+	require.NoError(t, err)
+	waitForStatus(t, m, task.ID, StatusRunning)
+	require.NoError(t, m.Cancel(task.ID))
+	close(cancelled)
+
+	done := waitForStatus(t, m, task.ID, StatusSucceeded)
+	assert.JSONEq(t, `{"deleted":1}`, string(done.Result))
+}
+
+func TestPruneTasks(t *testing.T) {
+	m, err := NewManager(openTestDB(t), logrus.New())
+	require.NoError(t, err)
+
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now()
+	for _, task := range []*Task{
+		{ID: "old", Type: "test", Status: StatusSucceeded, CreatedAt: old, FinishedAt: &old},
+		{ID: "recent", Type: "test", Status: StatusFailed, CreatedAt: recent, FinishedAt: &recent},
+		{ID: "running", Type: "test", Status: StatusRunning, CreatedAt: old},
+	} {
+		require.NoError(t, m.save(task))
+	}
+
+	pruned, err := m.Prune(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	_, err = m.Get("old")
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+	all, err := m.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
@@ -0,0 +1,166 @@
+package timeouts
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultTransfer is how long a transfer may stall before it is cut off
+const DefaultTransfer = time.Minute
+
+// extendEvery limits how often a flowing transfer moves its deadlines
+// forward, as each move is a call into the connection. Short idle timeouts
+// move them more often.
+const extendEvery = time.Second
+
+// transferChunk is how much of a download is handed to the connection
+// between deadline moves, so sendfile stays in use for large files
+const transferChunk = 4 << 20
+
+// Config holds the timeouts of a listener. Read, Write and Idle are those
+// of http.Server and bound whole requests; Transfer is the idle timeout of
+// routes that move artifacts, which run as long as data keeps flowing.
+type Config struct {
+	Read     time.Duration
+	Write    time.Duration
+	Idle     time.Duration
+	Transfer time.Duration
+}
+
+// Or returns c with its unset timeouts taken from defaults
+func (c Config) Or(defaults Config) Config {
+	if c.Read == 0 {
+		c.Read = defaults.Read
+	}
+	if c.Write == 0 {
+		c.Write = defaults.Write
+	}
+	if c.Idle == 0 {
+		c.Idle = defaults.Idle
+	}
+	if c.Transfer == 0 {
+		c.Transfer = defaults.Transfer
+	}
+	return c
+}
+
+// Apply sets the server-wide timeouts of server
+func (c Config) Apply(server *http.Server) {
+	server.ReadTimeout = c.Read
+	server.WriteTimeout = c.Write
+	server.IdleTimeout = c.Idle
+}
+
+// Middleware replaces the server-wide read and write timeouts of the
+// requests transfer selects with deadlines that move forward while the
+// request body is read or the response written, so a multi-gigabyte upload
+// over a slow link is only cut off once it stalls for the Transfer timeout.
+// It must wrap the server's handler directly, as it needs the connection's
+// own ResponseWriter to set deadlines.
+func (c Config) Middleware(transfer func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.Transfer <= 0 || !transfer(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			d := &deadlines{rc: http.NewResponseController(w), idle: c.Transfer}
+			if err := d.extend(true); err != nil {
+				// Deadlines are not supported, e.g. under test
+				next.ServeHTTP(w, r)
+				return
+			}
+			defer func() {
+				// Without a server-wide write timeout nothing would clear
+				// the deadline before the next request on the connection
+				if c.Write == 0 {
+					d.rc.SetWriteDeadline(time.Time{})
+				}
+			}()
+
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = &idleReader{ReadCloser: r.Body, deadlines: d}
+			}
+			next.ServeHTTP(&idleWriter{ResponseWriter: w, deadlines: d}, r)
+		})
+	}
+}
+
+// deadlines moves the read and write deadlines of a connection forward by
+// the idle timeout
+type deadlines struct {
+	rc       *http.ResponseController
+	idle     time.Duration
+	extended time.Time
+}
+
+// extend moves the write deadline, and with read the read deadline, unless
+// they were moved very recently. The response is written after the body is
+// read, so reading keeps the write deadline from passing too.
+func (d *deadlines) extend(read bool) error {
+	now := time.Now()
+	if now.Sub(d.extended) < min(extendEvery, d.idle/4) {
+		return nil
+	}
+	d.extended = now
+	if read {
+		if err := d.rc.SetReadDeadline(now.Add(d.idle)); err != nil {
+			return err
+		}
+	}
+	return d.rc.SetWriteDeadline(now.Add(d.idle))
+}
+
+// idleReader moves the deadlines forward as a request body is read
+type idleReader struct {
+	io.ReadCloser
+	deadlines *deadlines
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	r.deadlines.extend(true)
+	return r.ReadCloser.Read(p)
+}
+
+// idleWriter moves the write deadline forward as a response is written
+type idleWriter struct {
+	http.ResponseWriter
+	deadlines *deadlines
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.deadlines.extend(false)
+	return w.ResponseWriter.Write(p)
+}
+
+// ReadFrom copies in chunks, moving the deadline between them, so the
+// connection's sendfile support is still reached
+func (w *idleWriter) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		w.deadlines.extend(false)
+		n, err := io.CopyN(w.ResponseWriter, r, transferChunk)
+		total += n
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Flush lets streamed responses through
+func (w *idleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets handlers reach the connection's ResponseWriter with
+// http.ResponseController
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,69 @@
+package timeouts
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	config := Config{Read: 200 * time.Millisecond, Write: 200 * time.Millisecond, Transfer: 300 * time.Millisecond}
+	server := httptest.NewUnstartedServer(config.Middleware(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/upload")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, strings.Repeat("x", int(n)))
+	})))
+	config.Apply(server.Config)
+	server.Start()
+	defer server.Close()
+
+	// trickle sends size bytes, one every interval
+	trickle := func(path string, size int, interval time.Duration) (string, error) {
+		body, writer := io.Pipe()
+		go func() {
+			for i := 0; i < size; i++ {
+				time.Sleep(interval)
+				if _, err := writer.Write([]byte("x")); err != nil {
+					return
+				}
+			}
+			writer.Close()
+		}()
+		req, err := http.NewRequest(http.MethodPut, server.URL+path, body)
+		require.NoError(t, err)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return string(data), err
+	}
+
+	t.Run("Flowing Transfer Outlasts Timeouts", func(t *testing.T) {
+		body, err := trickle("/upload", 10, 60*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 10), body)
+	})
+
+	t.Run("Other Routes Keep Timeouts", func(t *testing.T) {
+		body, err := trickle("/api", 10, 60*time.Millisecond)
+		assert.True(t, err != nil || body != strings.Repeat("x", 10), "request outlasted the read timeout")
+	})
+
+	t.Run("Stalled Transfer Cut Off", func(t *testing.T) {
+		body, err := trickle("/upload", 2, 500*time.Millisecond)
+		assert.True(t, err != nil || body != "xx", "stalled transfer was not cut off")
+	})
+}
@@ -0,0 +1,277 @@
+package trash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+var (
+	bucketTrash     = []byte("trash")
+	ErrItemNotFound = errors.New("trash item not found")
+)
+
+// Item is an artifact that was deleted from a repository with a trash. Its
+// content is kept until ExpiresAt so it can be restored.
+type Item struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256,omitempty"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Manager moves deleted artifacts into a recycle area and back out again
+type Manager struct {
+	db      *bbolt.DB
+	dir     string
+	storage storage.Storage
+	logger  *logrus.Logger
+}
+
+// NewManager creates a trash manager that keeps deleted content in dir
+func NewManager(db *bbolt.DB, dir string, storage storage.Storage, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trash directory: %w", err)
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketTrash)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trash bucket: %w", err)
+	}
+
+	return &Manager{
+		db:      db,
+		dir:     dir,
+		storage: storage,
+		logger:  logger,
+	}, nil
+}
+
+// Trash moves an artifact out of its repository into the trash, where it
+// is kept for retention. It returns storage.ErrNotFound if there is no
+// artifact at the path.
+func (m *Manager) Trash(repo, path string, retention time.Duration) (*Item, error) {
+	info, err := m.storage.Stat(repo, path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item := &Item{
+		ID:         uuid.New().String(),
+		Repository: repo,
+		Path:       path,
+		Size:       info.Size,
+		DeletedAt:  now,
+		ExpiresAt:  now.Add(retention),
+	}
+
+	if err := m.copyIn(item); err != nil {
+		os.Remove(m.dataPath(item.ID))
+		return nil, err
+	}
+	if err := m.save(item); err != nil {
+		os.Remove(m.dataPath(item.ID))
+		return nil, err
+	}
+
+	if err := m.storage.Delete(repo, path); err != nil {
+		m.remove(item.ID)
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// Get returns a trash item by ID
+func (m *Manager) Get(id string) (*Item, error) {
+	var item Item
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketTrash).Get([]byte(id))
+		if data == nil {
+			return ErrItemNotFound
+		}
+		return json.Unmarshal(data, &item)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// List returns the trash items of a repository, most recently deleted
+// first. An empty repo lists every repository's items.
+func (m *Manager) List(repo string) ([]*Item, error) {
+	items := []*Item{}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTrash).ForEach(func(k, v []byte) error {
+			var item Item
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("failed to unmarshal trash item %s: %w", k, err)
+			}
+			if repo == "" || item.Repository == repo {
+				items = append(items, &item)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// Restore hands a trashed artifact's content to store and removes it from
+// the trash. The item is kept if store fails.
+func (m *Manager) Restore(id string, store func(item *Item, data io.Reader) error) (*Item, error) {
+	item, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(m.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trashed file: %w", err)
+	}
+	err = store(item, file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return item, m.remove(id)
+}
+
+// Open returns the content of a trashed artifact, leaving it in the trash
+func (m *Manager) Open(id string) (io.ReadCloser, error) {
+	if _, err := m.Get(id); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(m.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trashed file: %w", err)
+	}
+	return file, nil
+}
+
+// Delete permanently removes an item from the trash
+func (m *Manager) Delete(id string) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return m.remove(id)
+}
+
+// DeleteRepository permanently removes every trashed item of a repository
+func (m *Manager) DeleteRepository(repo string) error {
+	items, err := m.List(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := m.remove(item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge permanently removes items whose retention has passed and returns
+// how many were removed
+func (m *Manager) Purge() (int, error) {
+	items, err := m.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, item := range items {
+		if item.ExpiresAt.After(now) {
+			continue
+		}
+		if err := m.remove(item.ID); err != nil {
+			m.logger.WithError(err).WithField("item", item.ID).Warn("Failed to purge trash item")
+			continue
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+func (m *Manager) copyIn(item *Item) error {
+	src, err := m.storage.Retrieve(item.Repository, item.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(m.dataPath(item.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create trash file: %w", err)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), src)
+	item.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write trash file: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) remove(id string) error {
+	if err := os.Remove(m.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove trash file: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTrash).Delete([]byte(id))
+	})
+}
+
+func (m *Manager) save(item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trash item: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketTrash).Put([]byte(item.ID), data)
+	})
+}
+
+func (m *Manager) dataPath(id string) string {
+	return filepath.Join(m.dir, id)
+}
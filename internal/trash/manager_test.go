@@ -0,0 +1,90 @@
+package trash
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/depot/depot/internal/storage"
+)
+
+func newTestManager(t *testing.T) (*Manager, storage.Storage) {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "trash.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store := storage.NewFileStorage(filepath.Join(dir, "artifacts"))
+	m, err := NewManager(db, filepath.Join(dir, "trash"), store, logrus.New())
+	require.NoError(t, err)
+	return m, store
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	m, store := newTestManager(t)
+	require.NoError(t, store.Store("releases", "app/1.0/app.bin", strings.NewReader("release")))
+
+	item, err := m.Trash("releases", "app/1.0/app.bin", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), item.Size)
+
+	exists, err := store.Exists("releases", "app/1.0/app.bin")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	items, err := m.List("releases")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, item.ID, items[0].ID)
+	assert.Equal(t, "a4d451ec23463726f72c43d64c710968f6b602cd653b4de8adee1b556240a829", items[0].SHA256)
+
+	file, err := m.Open(item.ID)
+	require.NoError(t, err)
+	content, _ := io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, "release", string(content))
+
+	_, err = m.Restore(item.ID, func(item *Item, data io.Reader) error {
+		return store.Store(item.Repository, item.Path, data)
+	})
+	require.NoError(t, err)
+
+	file, err = store.Retrieve("releases", "app/1.0/app.bin")
+	require.NoError(t, err)
+	content, _ = io.ReadAll(file)
+	file.Close()
+	assert.Equal(t, "release", string(content))
+
+	_, err = m.Get(item.ID)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	_, err = m.Trash("releases", "missing.bin", time.Hour)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	m, store := newTestManager(t)
+	require.NoError(t, store.Store("releases", "old.bin", strings.NewReader("old")))
+	require.NoError(t, store.Store("releases", "new.bin", strings.NewReader("new")))
+
+	expired, err := m.Trash("releases", "old.bin", -time.Minute)
+	require.NoError(t, err)
+	kept, err := m.Trash("releases", "new.bin", time.Hour)
+	require.NoError(t, err)
+
+	purged, err := m.Purge()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	_, err = m.Get(expired.ID)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	_, err = m.Get(kept.ID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,310 @@
+package uploads
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketUploads     = []byte("uploads")
+	ErrUploadNotFound = errors.New("upload session not found")
+	ErrUploadBusy     = errors.New("upload session is in use by another request")
+)
+
+// OffsetMismatchError is returned when a chunk does not start where the
+// session's data currently ends
+type OffsetMismatchError struct {
+	Offset int64
+}
+
+func (e *OffsetMismatchError) Error() string {
+	return fmt.Sprintf("upload offset mismatch, current offset is %d", e.Offset)
+}
+
+// Session is the persisted state of a resumable upload. The received data
+// is staged on disk until the session is completed.
+type Session struct {
+	ID         string    `json:"id"`
+	Repository string    `json:"repository"`
+	Path       string    `json:"path"`
+	Offset     int64     `json:"offset"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Manager tracks resumable upload sessions. Sessions survive server
+// restarts, so an interrupted client can query the offset and carry on.
+type Manager struct {
+	db     *bbolt.DB
+	dir    string
+	logger *logrus.Logger
+	mu     sync.Mutex
+	busy   map[string]bool
+}
+
+// NewManager creates an upload session manager that stages data in dir
+func NewManager(db *bbolt.DB, dir string, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUploads)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create uploads bucket: %w", err)
+	}
+
+	return &Manager{
+		db:     db,
+		dir:    dir,
+		logger: logger,
+		busy:   make(map[string]bool),
+	}, nil
+}
+
+// Create starts a new upload session for an artifact path
+func (m *Manager) Create(repository, path string) (*Session, error) {
+	now := time.Now()
+	session := &Session{
+		ID:         uuid.New().String(),
+		Repository: repository,
+		Path:       path,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	file, err := os.OpenFile(m.dataPath(session.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	if err := m.save(session); err != nil {
+		os.Remove(m.dataPath(session.ID))
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Get returns an upload session by ID
+func (m *Manager) Get(id string) (*Session, error) {
+	var session Session
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketUploads).Get([]byte(id))
+		if data == nil {
+			return ErrUploadNotFound
+		}
+		return json.Unmarshal(data, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// List returns every open upload session, oldest first
+func (m *Manager) List() ([]*Session, error) {
+	sessions := []*Session{}
+
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketUploads).ForEach(func(k, v []byte) error {
+			var session Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return fmt.Errorf("failed to unmarshal upload session %s: %w", k, err)
+			}
+			sessions = append(sessions, &session)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// Append writes a chunk that must start at offset. Data that was received
+// before an error is kept, so the returned session always reflects what
+// is safely staged and the client can resume from its offset.
+func (m *Manager) Append(id string, offset int64, reader io.Reader) (*Session, error) {
+	if err := m.acquire(id); err != nil {
+		return nil, err
+	}
+	defer m.release(id)
+
+	session, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return session, &OffsetMismatchError{Offset: session.Offset}
+	}
+
+	file, err := os.OpenFile(m.dataPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return session, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	// Discard anything past the recorded offset left by a crash between
+	// writing data and saving the session
+	if err := file.Truncate(session.Offset); err != nil {
+		return session, fmt.Errorf("failed to truncate upload file: %w", err)
+	}
+	if _, err := file.Seek(session.Offset, io.SeekStart); err != nil {
+		return session, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+
+	written, copyErr := io.Copy(file, reader)
+	if err := file.Sync(); err != nil && copyErr == nil {
+		copyErr = fmt.Errorf("failed to sync upload file: %w", err)
+	}
+
+	session.Offset += written
+	session.UpdatedAt = time.Now()
+	if err := m.save(session); err != nil {
+		return session, err
+	}
+
+	return session, copyErr
+}
+
+// Complete hands the staged data to store and removes the session. The
+// session is kept if store fails, so completion can be retried.
+func (m *Manager) Complete(id string, store func(session *Session, data io.Reader) error) (*Session, error) {
+	if err := m.acquire(id); err != nil {
+		return nil, err
+	}
+	defer m.release(id)
+
+	session, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(m.dataPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	err = store(session, io.LimitReader(file, session.Offset))
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return session, m.remove(id)
+}
+
+// Abort discards an upload session and its staged data
+func (m *Manager) Abort(id string) error {
+	if err := m.acquire(id); err != nil {
+		return err
+	}
+	defer m.release(id)
+
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return m.remove(id)
+}
+
+// Verify checks that the data staged for a session is on disk and as long
+// as the session's offset
+func (m *Manager) Verify(session *Session) error {
+	info, err := os.Stat(m.dataPath(session.ID))
+	if err != nil {
+		return fmt.Errorf("staged data is missing: %w", err)
+	}
+	if info.Size() != session.Offset {
+		return fmt.Errorf("staged data is %d bytes, expected %d", info.Size(), session.Offset)
+	}
+	return nil
+}
+
+// Expire aborts sessions that have not received data for longer than
+// maxAge and returns how many were removed
+func (m *Manager) Expire(maxAge time.Duration) (int, error) {
+	sessions, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	expired := 0
+	for _, session := range sessions {
+		if session.UpdatedAt.After(cutoff) {
+			continue
+		}
+		if err := m.Abort(session.ID); err != nil {
+			if !errors.Is(err, ErrUploadBusy) && !errors.Is(err, ErrUploadNotFound) {
+				m.logger.WithError(err).WithField("upload", session.ID).Warn("Failed to expire upload session")
+			}
+			continue
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+func (m *Manager) acquire(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.busy[id] {
+		return ErrUploadBusy
+	}
+	m.busy[id] = true
+	return nil
+}
+
+func (m *Manager) release(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.busy, id)
+}
+
+func (m *Manager) remove(id string) error {
+	if err := os.Remove(m.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload file: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketUploads).Delete([]byte(id))
+	})
+}
+
+func (m *Manager) save(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload session: %w", err)
+	}
+
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketUploads).Put([]byte(session.ID), data)
+	})
+}
+
+func (m *Manager) dataPath(id string) string {
+	return filepath.Join(m.dir, id)
+}
@@ -0,0 +1,104 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func newTestManager(t *testing.T) (*Manager, *bbolt.DB, string) {
+	dir := t.TempDir()
+	db, err := bbolt.Open(filepath.Join(dir, "uploads.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewManager(db, filepath.Join(dir, "staging"), logrus.New())
+	require.NoError(t, err)
+	return m, db, dir
+}
+
+// failingReader returns data and then an error, like a dropped connection
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestResumableUpload(t *testing.T) {
+	m, db, dir := newTestManager(t)
+
+	session, err := m.Create("releases", "app/app.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), session.Offset)
+
+	session, err = m.Append(session.ID, 0, strings.NewReader("hello "))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), session.Offset)
+
+	// An interrupted chunk keeps what was received
+	session, err = m.Append(session.ID, 6, &failingReader{data: "wor"})
+	assert.Error(t, err)
+	assert.Equal(t, int64(9), session.Offset)
+
+	// Retrying from a stale offset is rejected with the current one
+	_, err = m.Append(session.ID, 6, strings.NewReader("world"))
+	var mismatch *OffsetMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, int64(9), mismatch.Offset)
+
+	// Sessions survive a restart
+	m, err = NewManager(db, filepath.Join(dir, "staging"), logrus.New())
+	require.NoError(t, err)
+
+	_, err = m.Append(session.ID, 9, strings.NewReader("ld"))
+	require.NoError(t, err)
+
+	var stored string
+	completed, err := m.Complete(session.ID, func(s *Session, data io.Reader) error {
+		content, err := io.ReadAll(data)
+		stored = string(content)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "app/app.bin", completed.Path)
+	assert.Equal(t, "hello world", stored)
+
+	_, err = m.Get(session.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
+
+func TestExpireUploads(t *testing.T) {
+	m, _, _ := newTestManager(t)
+
+	stale, err := m.Create("releases", "old.bin")
+	require.NoError(t, err)
+	stale.UpdatedAt = time.Now().Add(-48 * time.Hour)
+	require.NoError(t, m.save(stale))
+
+	fresh, err := m.Create("releases", "new.bin")
+	require.NoError(t, err)
+
+	expired, err := m.Expire(24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	_, err = m.Get(stale.ID)
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+	_, err = m.Get(fresh.ID)
+	assert.NoError(t, err)
+}
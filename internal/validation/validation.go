@@ -0,0 +1,351 @@
+// Package validation runs server-side validation hooks on raw uploads:
+// external commands or HTTP endpoints that see each artifact before it is
+// stored and may reject it with a message, for license checks, naming
+// conventions or policy engines such as OPA. Docker registries store
+// pushed images without them.
+package validation
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/depot/depot/internal/glob"
+)
+
+// defaultTimeout bounds a hook that sets no timeout of its own
+const defaultTimeout = 30 * time.Second
+
+// maxMessageSize bounds the rejection message read from a hook
+const maxMessageSize = 4096
+
+// ErrUnavailable is returned when a hook could not give a verdict, in which
+// case the upload is rejected unless the hook fails open
+var ErrUnavailable = errors.New("validation hook unavailable")
+
+// RejectedError is returned for an upload a hook refused
+type RejectedError struct {
+	Hook    string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by %s: %s", e.Hook, e.Message)
+}
+
+// Hook is a validator run on uploads. It runs Command, split on spaces, or
+// calls URL, for uploads to the repositories and paths matching its glob
+// patterns, or every upload without patterns. A hook that cannot be
+// reached rejects uploads unless FailOpen is set. An HTTP hook receives the
+// artifact as the request body unless MetadataOnly is set.
+type Hook struct {
+	Name         string   `json:"name"`
+	Command      string   `json:"command,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+	Paths        []string `json:"paths,omitempty"`
+	Timeout      string   `json:"timeout,omitempty"`
+	FailOpen     bool     `json:"fail_open,omitempty"`
+	MetadataOnly bool     `json:"metadata_only,omitempty"`
+}
+
+// Upload describes the artifact a hook validates
+type Upload struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+// Validate checks a hook's configuration
+func (h *Hook) Validate() error {
+	if h.Name == "" {
+		return errors.New("validation hooks need a name")
+	}
+	if (h.Command == "") == (h.URL == "") {
+		return fmt.Errorf("validation hook %s needs either a command or a url", h.Name)
+	}
+	if h.URL != "" {
+		parsed, err := url.Parse(h.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("validation hook %s has an invalid url %q", h.Name, h.URL)
+		}
+	}
+	if h.Command != "" && h.MetadataOnly {
+		return fmt.Errorf("validation hook %s: metadata_only applies to url hooks", h.Name)
+	}
+	if _, err := h.timeout(); err != nil {
+		return fmt.Errorf("validation hook %s: %w", h.Name, err)
+	}
+	return nil
+}
+
+func (h *Hook) timeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(h.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", h.Timeout)
+	}
+	return timeout, nil
+}
+
+// applies reports whether the hook validates an upload
+func (h *Hook) applies(repo, artifactPath string) bool {
+	return matchesAny(h.Repositories, repo) && matchesAny(h.Paths, artifactPath)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if glob.Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadHooks reads validation hooks from a JSON file holding an array of
+// hooks
+func LoadHooks(path string) ([]Hook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validation hooks: %w", err)
+	}
+	var hooks []Hook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid validation hooks in %s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for i := range hooks {
+		if err := hooks[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[hooks[i].Name] {
+			return nil, fmt.Errorf("duplicate validation hook %s", hooks[i].Name)
+		}
+		names[hooks[i].Name] = true
+	}
+	return hooks, nil
+}
+
+// Manager runs the validation hooks that apply to each upload
+type Manager struct {
+	hooks  []Hook
+	dir    string
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewManager creates a manager for hooks that spools uploads in
+// dir while the hooks run
+func NewManager(hooks []Hook, dir string, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create validation directory: %w", err)
+	}
+	return &Manager{hooks: hooks, dir: dir, client: &http.Client{}, logger: logger}, nil
+}
+
+// Hooks returns the configured hooks
+func (m *Manager) Hooks() []Hook {
+	return m.hooks
+}
+
+// Check runs the hooks that apply to an upload, in order, spooling data to
+// disk so each sees the whole artifact. Accepted content is returned as a
+// reader the caller must close. An upload a hook refuses fails with a
+// *RejectedError, and one a hook could not judge with an error wrapping
+// ErrUnavailable. Errors reading data are returned as is.
+func (m *Manager) Check(repo, artifactPath string, data io.Reader) (io.ReadCloser, error) {
+	var hooks []*Hook
+	for i := range m.hooks {
+		if m.hooks[i].applies(repo, artifactPath) {
+			hooks = append(hooks, &m.hooks[i])
+		}
+	}
+	if len(hooks) == 0 {
+		return io.NopCloser(data), nil
+	}
+
+	spool, err := os.CreateTemp(m.dir, ".validate-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create validation file: %w", err)
+	}
+	accepted := false
+	defer func() {
+		if !accepted {
+			spool.Close()
+			os.Remove(spool.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), data)
+	if err != nil {
+		return nil, err
+	}
+	upload := &Upload{Repository: repo, Path: artifactPath, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	log := m.logger.WithFields(logrus.Fields{"repository": repo, "path": artifactPath, "size": size})
+
+	for _, hook := range hooks {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		err := m.run(hook, upload, spool)
+		var rejected *RejectedError
+		switch {
+		case errors.As(err, &rejected):
+			log.WithField("hook", hook.Name).Warnf("Upload rejected: %s", rejected.Message)
+			return nil, err
+		case err != nil && hook.FailOpen:
+			log.WithError(err).WithField("hook", hook.Name).Warn("Validation hook failed, accepting upload")
+		case err != nil:
+			log.WithError(err).WithField("hook", hook.Name).Error("Validation hook failed, rejecting upload")
+			return nil, err
+		}
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	accepted = true
+	return &spooled{File: spool}, nil
+}
+
+// run asks one hook about an upload
+func (m *Manager) run(hook *Hook, upload *Upload, content io.Reader) error {
+	timeout, _ := hook.timeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if hook.Command != "" {
+		return runCommand(ctx, hook, upload, content)
+	}
+	return m.call(ctx, hook, upload, content)
+}
+
+// runCommand runs a command hook with the artifact on its standard input
+// and the upload described in DEPOT_REPOSITORY, DEPOT_PATH, DEPOT_SIZE and
+// DEPOT_SHA256. Exit status 0 accepts the upload and 1 rejects it with the
+// command's output as the message; anything else is a failure.
+func runCommand(ctx context.Context, hook *Hook, upload *Upload, content io.Reader) error {
+	fields := strings.Fields(hook.Command)
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Stdin = content
+	cmd.Env = append(os.Environ(),
+		"DEPOT_REPOSITORY="+upload.Repository,
+		"DEPOT_PATH="+upload.Path,
+		"DEPOT_SIZE="+strconv.FormatInt(upload.Size, 10),
+		"DEPOT_SHA256="+upload.SHA256,
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %s timed out", ErrUnavailable, hook.Name)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return &RejectedError{Hook: hook.Name, Message: message(output.Bytes())}
+	case output.Len() > 0:
+		return fmt.Errorf("%w: %s failed: %v: %s", ErrUnavailable, hook.Name, err, strings.TrimSpace(output.String()))
+	default:
+		return fmt.Errorf("%w: %s failed: %v", ErrUnavailable, hook.Name, err)
+	}
+}
+
+// call posts an upload to an HTTP hook: the artifact with the upload
+// described in X-Depot-Repository, X-Depot-Path and X-Depot-Sha256
+// headers, or the description as JSON for metadata-only hooks. A 2xx
+// response accepts the upload and a 4xx rejects it, with the "message" of a
+// JSON body or the body itself as the message; anything else is a
+// failure.
+func (m *Manager) call(ctx context.Context, hook *Hook, upload *Upload, content io.Reader) error {
+	// The client closes a request body it is given, so the spool is hidden
+	// behind a reader it cannot close
+	var body io.Reader = io.NopCloser(content)
+	contentType := "application/octet-stream"
+	if hook.MetadataOnly {
+		data, err := json.Marshal(upload)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, body)
+	if err != nil {
+		return err
+	}
+	if !hook.MetadataOnly {
+		req.ContentLength = upload.Size
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Depot-Repository", upload.Repository)
+	req.Header.Set("X-Depot-Path", upload.Path)
+	req.Header.Set("X-Depot-Sha256", upload.SHA256)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, hook.Name, err)
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		var decoded struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(reply, &decoded) == nil && decoded.Message != "" {
+			return &RejectedError{Hook: hook.Name, Message: decoded.Message}
+		}
+		return &RejectedError{Hook: hook.Name, Message: message(reply)}
+	default:
+		return fmt.Errorf("%w: %s responded %s", ErrUnavailable, hook.Name, resp.Status)
+	}
+}
+
+// message trims a hook's output to a rejection message
+func message(output []byte) string {
+	text := strings.TrimSpace(string(output))
+	if len(text) > maxMessageSize {
+		text = text[:maxMessageSize]
+	}
+	if text == "" {
+		return "no reason given"
+	}
+	return text
+}
+
+// spooled is accepted content, removed from disk once closed
+type spooled struct {
+	*os.File
+}
+
+func (s *spooled) Close() error {
+	err := s.File.Close()
+	os.Remove(s.File.Name())
+	return err
+}
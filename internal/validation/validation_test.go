@@ -0,0 +1,147 @@
+package validation
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandHook(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "check.sh")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+case "$DEPOT_PATH" in
+  *.jar) ;;
+  *) echo "$DEPOT_PATH is not a jar"; exit 1 ;;
+esac
+grep -q GPL && { echo "GPL code is not allowed"; exit 1; }
+exit 0
+`), 0755))
+
+	m, err := NewManager([]Hook{{Name: "license", Command: script, Repositories: []string{"libs"}}}, t.TempDir(), logrus.New())
+	require.NoError(t, err)
+
+	accepted, err := m.Check("libs", "app.jar", strings.NewReader("MIT licensed"))
+	require.NoError(t, err)
+	content, err := io.ReadAll(accepted)
+	require.NoError(t, err)
+	assert.Equal(t, "MIT licensed", string(content))
+	require.NoError(t, accepted.Close())
+
+	_, err = m.Check("libs", "app.jar", strings.NewReader("GPL licensed"))
+	var rejected *RejectedError
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "license", rejected.Hook)
+	assert.Equal(t, "GPL code is not allowed", rejected.Message)
+
+	_, err = m.Check("libs", "app.zip", strings.NewReader("MIT licensed"))
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "app.zip is not a jar", rejected.Message)
+
+	// Hooks only see the repositories they are configured for
+	accepted, err = m.Check("other", "app.zip", strings.NewReader("GPL licensed"))
+	require.NoError(t, err)
+	accepted.Close()
+}
+
+func TestFailingHook(t *testing.T) {
+	hooks := []Hook{{Name: "broken", Command: "depot-missing-validator"}}
+	m, err := NewManager(hooks, t.TempDir(), logrus.New())
+	require.NoError(t, err)
+
+	_, err = m.Check("libs", "app.jar", strings.NewReader("data"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	m.hooks[0].FailOpen = true
+	accepted, err := m.Check("libs", "app.jar", strings.NewReader("data"))
+	require.NoError(t, err)
+	accepted.Close()
+
+	entries, err := os.ReadDir(m.dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestHTTPHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			var upload Upload
+			json.NewDecoder(r.Body).Decode(&upload)
+			if !strings.HasPrefix(upload.Path, "com/example/") {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"message": "artifacts belong below com/example/"})
+			}
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "crash"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.Contains(string(body), "secret"):
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte("contains a secret\n"))
+		}
+	}))
+	defer server.Close()
+
+	hooks := []Hook{
+		{Name: "naming", URL: server.URL, MetadataOnly: true, Paths: []string{"**/*.jar"}},
+		{Name: "secrets", URL: server.URL},
+	}
+	m, err := NewManager(hooks, t.TempDir(), logrus.New())
+	require.NoError(t, err)
+
+	accepted, err := m.Check("libs", "com/example/app.jar", strings.NewReader("harmless"))
+	require.NoError(t, err)
+	accepted.Close()
+
+	var rejected *RejectedError
+	_, err = m.Check("libs", "org/other/app.jar", strings.NewReader("harmless"))
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "naming", rejected.Hook)
+	assert.Equal(t, "artifacts belong below com/example/", rejected.Message)
+
+	_, err = m.Check("libs", "notes.txt", strings.NewReader("a secret"))
+	require.ErrorAs(t, err, &rejected)
+	assert.Equal(t, "secrets", rejected.Hook)
+	assert.Equal(t, "contains a secret", rejected.Message)
+
+	_, err = m.Check("libs", "notes.txt", strings.NewReader("crash"))
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestLoadHooks(t *testing.T) {
+	dir := t.TempDir()
+	load := func(config string) ([]Hook, error) {
+		path := filepath.Join(dir, "hooks.json")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+		return LoadHooks(path)
+	}
+
+	hooks, err := load(`[{"name":"opa","url":"http://127.0.0.1:8181/v1/data/depot","metadata_only":true,"timeout":"5s"},{"name":"license","command":"/usr/local/bin/check-license"}]`)
+	require.NoError(t, err)
+	require.Len(t, hooks, 2)
+	assert.True(t, hooks[0].MetadataOnly)
+
+	for _, config := range []string{
+		`[{"command":"check"}]`,
+		`[{"name":"both","command":"check","url":"http://localhost"}]`,
+		`[{"name":"neither"}]`,
+		`[{"name":"bad","url":"ftp://localhost"}]`,
+		`[{"name":"slow","command":"check","timeout":"soon"}]`,
+		`[{"name":"meta","command":"check","metadata_only":true}]`,
+		`[{"name":"twice","command":"a"},{"name":"twice","command":"b"}]`,
+		`{"name":"object"}`,
+	} {
+		_, err := load(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Namespace groups the repositories of an organization or team. Defaults
+// holds, per repository type, configuration that repositories created in
+// the namespace start from; settings given when creating a repository
+// take precedence.
+type Namespace struct {
+	Name        string                             `json:"name"`
+	Description string                             `json:"description,omitempty"`
+	Metadata    map[string]string                  `json:"metadata,omitempty"`
+	Defaults    map[RepositoryType]json.RawMessage `json:"defaults,omitempty"`
+	CreatedAt   time.Time                          `json:"created_at"`
+	UpdatedAt   time.Time                          `json:"updated_at"`
+}
@@ -2,31 +2,510 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type RepositoryType string
 
 const (
-	RepositoryTypeDocker RepositoryType = "docker"
-	RepositoryTypeRaw    RepositoryType = "raw"
+	RepositoryTypeDocker     RepositoryType = "docker"
+	RepositoryTypeRaw        RepositoryType = "raw"
+	RepositoryTypeBuildCache RepositoryType = "buildcache"
 )
 
 type Repository struct {
-	Name        string         `json:"name"`
-	Type        RepositoryType `json:"type"`
-	Description string         `json:"description,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	Config      json.RawMessage `json:"config,omitempty"`
+	Name        string            `json:"name"`
+	Type        RepositoryType    `json:"type"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Description string            `json:"description,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Config      json.RawMessage   `json:"config,omitempty"`
+	Enabled     *bool             `json:"enabled,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+}
+
+// IsEnabled reports whether a repository is online. Repositories are
+// enabled unless taken offline with enabled set to false.
+func (r *Repository) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
 }
 
 type DockerRepositoryConfig struct {
-	HTTPPort  int  `json:"http_port,omitempty"`
-	HTTPSPort int  `json:"https_port,omitempty"`
-	V1Enabled bool `json:"v1_enabled"`
+	HTTPPort         int               `json:"http_port,omitempty"`
+	HTTPSPort        int               `json:"https_port,omitempty"`
+	BindAddress      string            `json:"bind_address,omitempty"`
+	TLS              *DockerTLS        `json:"tls,omitempty"`
+	V1Enabled        bool              `json:"v1_enabled"`
+	MaxLayerSize     int64             `json:"max_layer_size,omitempty"`
+	SignaturePolicy  *SignaturePolicy  `json:"signature_policy,omitempty"`
+	Proxy            *DockerProxy      `json:"proxy,omitempty"`
+	PromotionPolicy  *PromotionPolicy  `json:"promotion_policy,omitempty"`
+	ProvenancePolicy *ProvenancePolicy `json:"provenance_policy,omitempty"`
+	RequirePullToken bool              `json:"require_pull_token,omitempty"`
+	CleanupPolicies  []CleanupPolicy   `json:"cleanup_policies,omitempty"`
+}
+
+// DockerTLS gives the HTTPS listener of a registry a certificate of its own
+// instead of the main server's, for registries exposed on other host
+// names. Certificate names a pair <name>.crt and <name>.key in the server's
+// certificate directory; otherwise CertFile and KeyFile are paths to PEM
+// files.
+type DockerTLS struct {
+	Certificate string `json:"certificate,omitempty"`
+	CertFile    string `json:"cert_file,omitempty"`
+	KeyFile     string `json:"key_file,omitempty"`
+}
+
+// PortAuto is the HTTPPort of a Docker repository configured with
+// "http_port": "auto", which is given a free port from the server's port
+// range when its registry starts
+const PortAuto = -1
+
+// MarshalJSON writes PortAuto as "auto"
+func (c DockerRepositoryConfig) MarshalJSON() ([]byte, error) {
+	type config DockerRepositoryConfig
+	if c.HTTPPort != PortAuto {
+		return json.Marshal(config(c))
+	}
+	return json.Marshal(struct {
+		config
+		HTTPPort string `json:"http_port"`
+	}{config(c), "auto"})
+}
+
+// UnmarshalJSON accepts "auto" as well as a port number for http_port
+func (c *DockerRepositoryConfig) UnmarshalJSON(data []byte) error {
+	type config DockerRepositoryConfig
+	aux := struct {
+		*config
+		HTTPPort json.RawMessage `json:"http_port,omitempty"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	switch string(aux.HTTPPort) {
+	case "", "null":
+	case `"auto"`:
+		c.HTTPPort = PortAuto
+	default:
+		if err := json.Unmarshal(aux.HTTPPort, &c.HTTPPort); err != nil || c.HTTPPort < 0 || c.HTTPPort > 65535 {
+			return fmt.Errorf(`http_port must be a port number or "auto"`)
+		}
+	}
+	return nil
+}
+
+// DockerProxy makes a Docker repository a read-only pull-through cache of
+// other registries. Images are fetched from an upstream on first pull and
+// stored below its namespace, so docker.io/library/nginx and
+// ghcr.io/library/nginx do not collide. ManifestTTL, such as "5m" or "1d",
+// is how long a tag fetched from an upstream is served from the cache
+// before it is checked again; without it tags are checked on every pull.
+// Warm lists images, as name:tag or name@digest, fetched with their layers
+// whenever the proxy is warmed. MaxCacheSize bounds the bytes cached, the
+// least recently pulled images being evicted first, except those whose name
+// matches a Pinned pattern. NotFoundTTL is how long content an upstream
+// did not have is answered with 404 without asking it again.
+// CircuitBreaker stops trying an upstream that keeps failing.
+type DockerProxy struct {
+	Upstreams      []DockerUpstream `json:"upstreams"`
+	ManifestTTL    string           `json:"manifest_ttl,omitempty"`
+	NotFoundTTL    string           `json:"not_found_ttl,omitempty"`
+	Warm           []string         `json:"warm,omitempty"`
+	Pinned         []string         `json:"pinned,omitempty"`
+	MaxCacheSize   int64            `json:"max_cache_size,omitempty"`
+	CircuitBreaker *CircuitBreaker  `json:"circuit_breaker,omitempty"`
+}
+
+// CircuitBreaker opens the circuit to an upstream after Threshold
+// consecutive failures, 5 by default: the upstream is not tried for
+// Cooldown, "1m" by default. A request is then let through, and the
+// circuit closes when it or a health check succeeds.
+type CircuitBreaker struct {
+	Threshold int    `json:"threshold,omitempty"`
+	Cooldown  string `json:"cooldown,omitempty"`
+}
+
+// DockerUpstream is a registry mirrored under Namespace, the registry host
+// containerd names in the ns query parameter, such as "docker.io". Requests
+// without ns go to the namespace of the first upstream. Several upstreams
+// with the same namespace are tried in order, skipping unhealthy ones.
+// Username and Password authenticate to the upstream. Repository names a
+// repository on another depot, whose main port URL is, to federate from:
+// images are pulled from that repository and its change feed can be
+// synced; the password is then a federation token of the repository.
+// CACertificates is a PEM bundle of the certificate authorities trusted for
+// the upstream instead of the system's. PinnedKeys, as sha256/<base64 hash
+// of a certificate's public key>, requires the upstream's certificate chain
+// to include one of those keys.
+type DockerUpstream struct {
+	Namespace      string   `json:"namespace"`
+	URL            string   `json:"url"`
+	Username       string   `json:"username,omitempty"`
+	Password       string   `json:"password,omitempty"`
+	Repository     string   `json:"repository,omitempty"`
+	CACertificates string   `json:"ca_certificates,omitempty"`
+	PinnedKeys     []string `json:"pinned_keys,omitempty"`
+}
+
+// RedactedSecret replaces secrets in repository configurations returned by
+// the API
+const RedactedSecret = "********"
+
+// RedactSecrets replaces upstream passwords with RedactedSecret
+func (c *DockerRepositoryConfig) RedactSecrets() {
+	if c.Proxy == nil {
+		return
+	}
+	for i := range c.Proxy.Upstreams {
+		if c.Proxy.Upstreams[i].Password != "" {
+			c.Proxy.Upstreams[i].Password = RedactedSecret
+		}
+	}
 }
 
+// RestoreSecrets puts back the passwords of current into upstreams of c
+// that were returned redacted
+func (c *DockerRepositoryConfig) RestoreSecrets(current *DockerRepositoryConfig) {
+	if c.Proxy == nil || current.Proxy == nil {
+		return
+	}
+	for i := range c.Proxy.Upstreams {
+		upstream := &c.Proxy.Upstreams[i]
+		if upstream.Password != RedactedSecret {
+			continue
+		}
+		for _, existing := range current.Proxy.Upstreams {
+			if existing.Namespace == upstream.Namespace && existing.URL == upstream.URL {
+				upstream.Password = existing.Password
+			}
+		}
+	}
+}
+
+// Signature policy levels
+const (
+	SignaturePolicyEnforce = "enforce"
+	SignaturePolicyAudit   = "audit"
+)
+
+// SignaturePolicy restricts pulls of images to those with a Notation
+// signature from a certificate issued by one in TrustedCertificates, a PEM
+// bundle. At the audit level unsigned pulls are only logged; the default is
+// to enforce.
+type SignaturePolicy struct {
+	Level               string `json:"level,omitempty"`
+	TrustedCertificates string `json:"trusted_certificates"`
+}
+
+// PromotionPolicy restricts the images promoted into a Docker repository.
+// With RequireSignature an image needs a Notation signature that verifies
+// against the repository's signature policy. With RequireScan it needs a
+// scan report attached as a referrer with one of ScanArtifactTypes
+// (application/sarif+json by default). Sources, if set, lists the only
+// repositories images may be promoted from.
+type PromotionPolicy struct {
+	RequireSignature  bool     `json:"require_signature,omitempty"`
+	RequireScan       bool     `json:"require_scan,omitempty"`
+	ScanArtifactTypes []string `json:"scan_artifact_types,omitempty"`
+	Sources           []string `json:"sources,omitempty"`
+}
+
+// ProvenancePolicy restricts downloads to artifacts and images with build
+// provenance. TrustedBuilders and SourceRepositories are glob patterns the
+// builder ID and source repository must match; either list being empty
+// accepts any. RequireAttestation also requires an in-toto statement, such
+// as a SLSA provenance document, whose subject is the artifact.
+type ProvenancePolicy struct {
+	TrustedBuilders    []string `json:"trusted_builders,omitempty"`
+	SourceRepositories []string `json:"source_repositories,omitempty"`
+	RequireAttestation bool     `json:"require_attestation,omitempty"`
+}
+
+// RawRepositoryConfig configures a raw repository. ContentTypes lists the
+// media types accepted on upload ("image/*" matches any image type) and
+// AllowedExtensions the accepted file name suffixes; either list being
+// empty means no restriction of that kind. With TrashRetentionDays set,
+// deleted artifacts are kept in a trash for that many days. Browsers are
+// shown an HTML index of directories unless DisableDirectoryListing is set.
+// Artifacts matching an ImmutablePaths pattern cannot be overwritten or
+// deleted once written. ExpiryRules give uploads a default time to live.
+// DisableOverwrite makes uploads to existing paths fail unless the content
+// is identical. RequireSignedURLs refuses downloads that do not use a
+// pre-signed URL. StagingRules are checked when a staging repository for
+// this repository is closed; Staging is set on staging repositories.
+// ProvenancePolicy refuses downloads of artifacts without acceptable build
+// provenance. StorageClass is the storage class of uploads that do not name
+// one, and StorageClasses configures each class. A ContentAddressed
+// repository stores each artifact at sha256/<hex digest of its content>.
+// Uploads are signed with the signing key named by SigningKey, and their
+// detached signatures served at <path>.asc or <path>.sig.
 type RawRepositoryConfig struct {
-	ContentTypes []string `json:"content_types,omitempty"`
+	ContentTypes            []string                      `json:"content_types,omitempty"`
+	AllowedExtensions       []string                      `json:"allowed_extensions,omitempty"`
+	ImmutablePaths          []string                      `json:"immutable_paths,omitempty"`
+	CleanupPolicies         []CleanupPolicy               `json:"cleanup_policies,omitempty"`
+	MaxArtifactSize         int64                         `json:"max_artifact_size,omitempty"`
+	TrashRetentionDays      int                           `json:"trash_retention_days,omitempty"`
+	DisableDirectoryListing bool                          `json:"disable_directory_listing,omitempty"`
+	ExpiryRules             []ExpiryRule                  `json:"expiry_rules,omitempty"`
+	DisableOverwrite        bool                          `json:"disable_overwrite,omitempty"`
+	RequireSignedURLs       bool                          `json:"require_signed_urls,omitempty"`
+	StagingRules            *StagingRules                 `json:"staging_rules,omitempty"`
+	Staging                 *Staging                      `json:"staging,omitempty"`
+	ProvenancePolicy        *ProvenancePolicy             `json:"provenance_policy,omitempty"`
+	StorageClass            string                        `json:"storage_class,omitempty"`
+	StorageClasses          map[string]StorageClassConfig `json:"storage_classes,omitempty"`
+	ContentAddressed        bool                          `json:"content_addressed,omitempty"`
+	SigningKey              string                        `json:"signing_key,omitempty"`
+	ResponseHeaders         []ResponseHeaders             `json:"response_headers,omitempty"`
+}
+
+// BuildCacheConfig configures a build cache repository, which serves the
+// Bazel remote cache and Gradle HTTP build cache protocols, or with
+// Protocol set to BuildCacheObjects plain objects at any path, as compiler
+// caches such as sccache and ccache store them. MaxEntrySize caps the size
+// of one entry and MaxCacheSize the size of the whole cache, beyond which
+// the least recently used entries are evicted. Entries not used for TTL
+// are evicted too.
+type BuildCacheConfig struct {
+	Protocol     string `json:"protocol,omitempty"`
+	MaxEntrySize int64  `json:"max_entry_size,omitempty"`
+	MaxCacheSize int64  `json:"max_cache_size,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+}
+
+// BuildCacheObjects is the protocol of build cache repositories that
+// store objects at the paths clients choose
+const BuildCacheObjects = "objects"
+
+// EntryTTL returns how long an entry of the cache may go unused, or zero
+// if entries do not expire
+func (c *BuildCacheConfig) EntryTTL() time.Duration {
+	ttl, _ := ParseTTL(c.TTL)
+	return ttl
+}
+
+// Staging repository states
+const (
+	StagingOpen   = "open"
+	StagingClosed = "closed"
+)
+
+// Staging marks a raw repository as a staging repository for Release.
+// Uploads are accepted while it is open; once closed its content has been
+// validated and can be promoted into Release.
+type Staging struct {
+	Release   string     `json:"release"`
+	State     string     `json:"state"`
+	CreatedAt time.Time  `json:"created_at"`
+	ClosedAt  *time.Time `json:"closed_at,omitempty"`
+}
+
+// StagingRules are the checks a staging repository must pass to be closed,
+// in addition to uploaded checksum files matching their artifacts.
+// RequireChecksums needs an .md5, .sha1 or .sha256 file for every artifact,
+// RequireSignatureFiles an .asc file in the format of an ASCII-armored
+// PGP signature, which is not verified against any key, and ValidatePOMs
+// checks that Maven POMs are complete release coordinates matching their
+// path.
+type StagingRules struct {
+	RequireChecksums      bool `json:"require_checksums,omitempty"`
+	RequireSignatureFiles bool `json:"require_signature_files,omitempty"`
+	ValidatePOMs          bool `json:"validate_poms,omitempty"`
+}
+
+// Storage classes hint how a raw artifact is used. The filesystem backend
+// stores every class alike; a class's TTL expires its artifacts.
+const (
+	StorageClassStandard   = "standard"
+	StorageClassInfrequent = "infrequent"
+	StorageClassEphemeral  = "ephemeral"
+)
+
+// StorageClassNames lists the storage classes
+var StorageClassNames = []string{StorageClassStandard, StorageClassInfrequent, StorageClassEphemeral}
+
+// DefaultEphemeralTTL is the TTL of ephemeral artifacts in repositories
+// that do not configure one
+const DefaultEphemeralTTL = "7d"
+
+// StorageClassConfig configures a storage class in a raw repository. TTL
+// expires artifacts of the class that neither ask for a TTL of their own
+// nor match an expiry rule.
+type StorageClassConfig struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ValidStorageClass reports whether class is a known storage class
+func ValidStorageClass(class string) bool {
+	for _, name := range StorageClassNames {
+		if name == class {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageClassTTL returns the TTL of artifacts of a storage class, or ""
+// if they do not expire
+func (c *RawRepositoryConfig) StorageClassTTL(class string) string {
+	if ttl := c.StorageClasses[class].TTL; ttl != "" {
+		return ttl
+	}
+	if class == StorageClassEphemeral {
+		return DefaultEphemeralTTL
+	}
+	return ""
+}
+
+// ExpiryRule expires artifacts uploaded below PathPattern after TTL, a
+// duration such as "36h" or "7d", unless the upload sets its own
+type ExpiryRule struct {
+	PathPattern string `json:"path_pattern"`
+	TTL         string `json:"ttl"`
+}
+
+// ResponseHeaders sets headers on downloads of the artifacts matching
+// PathPattern, or of every artifact without one. CacheControl is sent as
+// Cache-Control, where "immutable" stands for ImmutableCacheControl.
+// ContentDisposition is "attachment" to have browsers save artifacts under
+// their file name, or "inline". Headers are sent as they are.
+type ResponseHeaders struct {
+	PathPattern        string            `json:"path_pattern,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+}
+
+// ImmutableCacheControl lets caches keep a response for a year without
+// revalidating it, for content that never changes at its URL
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// ParseTTL parses a positive time to live. It accepts Go durations and a
+// "d" suffix for days.
+func ParseTTL(s string) (time.Duration, error) {
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		ttl = d
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("ttl %q must be positive", s)
+	}
+	return ttl, nil
+}
+
+// TrashRetention returns how long deleted artifacts are kept in the trash
+func (c *RawRepositoryConfig) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// AllowsContentType reports whether an upload with the given Content-Type
+// header is accepted. A missing header counts as application/octet-stream.
+func (c *RawRepositoryConfig) AllowsContentType(contentType string) bool {
+	if len(c.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType := "application/octet-stream"
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		mediaType = parsed
+	}
+
+	for _, allowed := range c.ContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == mediaType || allowed == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPath reports whether an artifact path has an allowed extension.
+// Extensions are compared case-insensitively and may span several dots,
+// as in ".tar.gz".
+func (c *RawRepositoryConfig) AllowsPath(artifactPath string) bool {
+	if len(c.AllowedExtensions) == 0 {
+		return true
+	}
+
+	name := strings.ToLower(artifactPath)
+	for _, ext := range c.AllowedExtensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// SizeLimit returns the smallest of the given byte limits, ignoring limits
+// that are zero (unlimited). It returns zero if no limit applies.
+func SizeLimit(limits ...int64) int64 {
+	var limit int64
+	for _, l := range limits {
+		if l > 0 && (limit == 0 || l < limit) {
+			limit = l
+		}
+	}
+	return limit
+}
+
+// HasLabels reports whether labels include every entry of want, as image
+// label filters, cleanup policies and repository metadata filters require
+func HasLabels(labels, want map[string]string) bool {
+	for name, value := range want {
+		if actual, exists := labels[name]; !exists || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CleanupPolicy selects raw artifacts, or tags of Docker images, for
+// deletion. When both MaxAgeDays and KeepLatest are set, an artifact must
+// satisfy both criteria to be removed. Policies that are not enabled can
+// still be previewed with a dry run.
+//
+// In Docker repositories PathPattern and Exclude match image:tag and
+// KeepLatest counts the tags of each image. Only images with every label
+// in Labels are selected, and images with any label in KeepLabels are
+// never deleted; labels are manifest annotations and image config labels.
+type CleanupPolicy struct {
+	Name        string            `json:"name"`
+	Enabled     bool              `json:"enabled"`
+	PathPattern string            `json:"path_pattern,omitempty"`
+	Exclude     []string          `json:"exclude,omitempty"`
+	MaxAgeDays  int               `json:"max_age_days,omitempty"`
+	KeepLatest  int               `json:"keep_latest,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	KeepLabels  map[string]string `json:"keep_labels,omitempty"`
 }
\ No newline at end of file
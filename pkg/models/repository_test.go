@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawUploadPolicy(t *testing.T) {
+	t.Run("Unrestricted", func(t *testing.T) {
+		var config RawRepositoryConfig
+		assert.True(t, config.AllowsContentType("anything/at-all"))
+		assert.True(t, config.AllowsPath("file.exe"))
+	})
+
+	t.Run("Content Types", func(t *testing.T) {
+		config := RawRepositoryConfig{ContentTypes: []string{"application/zip", "image/*", "application/octet-stream"}}
+		assert.True(t, config.AllowsContentType("application/zip"))
+		assert.True(t, config.AllowsContentType("Image/PNG; charset=binary"))
+		assert.True(t, config.AllowsContentType(""))
+		assert.False(t, config.AllowsContentType("text/html"))
+		assert.False(t, config.AllowsContentType("imagex/png"))
+		assert.False(t, config.AllowsContentType("not a media type"))
+	})
+
+	t.Run("Extensions", func(t *testing.T) {
+		config := RawRepositoryConfig{AllowedExtensions: []string{".tar.gz", "zip"}}
+		assert.True(t, config.AllowsPath("dist/app-1.0.TAR.GZ"))
+		assert.True(t, config.AllowsPath("dist/app.zip"))
+		assert.False(t, config.AllowsPath("dist/app.gz"))
+		assert.False(t, config.AllowsPath("dist/zip"))
+	})
+}
+
+func TestStorageClassTTL(t *testing.T) {
+	config := RawRepositoryConfig{StorageClasses: map[string]StorageClassConfig{
+		StorageClassInfrequent: {TTL: "90d"},
+	}}
+	assert.Equal(t, "", config.StorageClassTTL(StorageClassStandard))
+	assert.Equal(t, "90d", config.StorageClassTTL(StorageClassInfrequent))
+	assert.Equal(t, DefaultEphemeralTTL, config.StorageClassTTL(StorageClassEphemeral))
+
+	config.StorageClasses[StorageClassEphemeral] = StorageClassConfig{TTL: "12h"}
+	assert.Equal(t, "12h", config.StorageClassTTL(StorageClassEphemeral))
+	assert.False(t, ValidStorageClass("glacier"))
+}
+
+func TestSizeLimit(t *testing.T) {
+	assert.Equal(t, int64(0), SizeLimit())
+	assert.Equal(t, int64(0), SizeLimit(0, 0))
+	assert.Equal(t, int64(10), SizeLimit(0, 10))
+	assert.Equal(t, int64(5), SizeLimit(10, 5))
+}
+
+func TestParseTTL(t *testing.T) {
+	ttl, err := ParseTTL("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, ttl)
+
+	ttl, err = ParseTTL("90m")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, ttl)
+
+	for _, invalid := range []string{"", "d", "soon", "0d", "-1h"} {
+		_, err := ParseTTL(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDockerPortAuto(t *testing.T) {
+	var config DockerRepositoryConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"http_port":"auto","v1_enabled":true}`), &config))
+	assert.Equal(t, PortAuto, config.HTTPPort)
+	assert.True(t, config.V1Enabled)
+
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"http_port":"auto"`)
+
+	config.HTTPPort = 5001
+	data, err = json.Marshal(config)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"http_port":5001`)
+	config = DockerRepositoryConfig{}
+	require.NoError(t, json.Unmarshal(data, &config))
+	assert.Equal(t, 5001, config.HTTPPort)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"http_port":-1}`), &config))
+	assert.Error(t, json.Unmarshal([]byte(`{"http_port":"any"}`), &config))
+}
+
+func TestHasLabels(t *testing.T) {
+	labels := map[string]string{"team": "web", "tier": "prod"}
+	assert.True(t, HasLabels(labels, nil))
+	assert.True(t, HasLabels(labels, map[string]string{"team": "web"}))
+	assert.False(t, HasLabels(labels, map[string]string{"team": "data"}))
+	assert.False(t, HasLabels(labels, map[string]string{"team": "web", "owner": ""}))
+	assert.False(t, HasLabels(nil, map[string]string{"tier": "prod"}))
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawAliases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"dist","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, version := range []string{"1.0", "1.1"} {
+		resp, err := makeRequest("PUT", baseURL+"/repository/dist/app/"+version+"/app.tar.gz", bytes.NewReader([]byte("app "+version)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	aliasURL := baseURL + "/api/v1/repositories/dist/aliases/app/latest.tar.gz"
+	download := func() (*http.Response, string) {
+		resp, err := makeRequest("GET", baseURL+"/repository/dist/app/latest.tar.gz", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	t.Run("Create and Resolve", func(t *testing.T) {
+		resp, err := makeRequest("PUT", aliasURL, bytes.NewReader([]byte(`{"target":"app/1.0/app.tar.gz"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, body := download()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "app 1.0", body)
+		assert.Equal(t, "/repository/dist/app/1.0/app.tar.gz", resp.Header.Get("Content-Location"))
+
+		resp, err = makeRequest("GET", baseURL+"/repository/dist/app/latest.tar.gz.sha256", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Repoint", func(t *testing.T) {
+		resp, err := makeRequest("PUT", aliasURL, bytes.NewReader([]byte(`{"target":"app/1.1/app.tar.gz"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		_, body := download()
+		assert.Equal(t, "app 1.1", body)
+	})
+
+	t.Run("Invalid Aliases", func(t *testing.T) {
+		resp, err := makeRequest("PUT", aliasURL, bytes.NewReader([]byte(`{"target":"app/9.9/app.tar.gz"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/dist/aliases/app/1.0/app.tar.gz", bytes.NewReader([]byte(`{"target":"app/1.1/app.tar.gz"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, err = makeRequest("PUT", baseURL+"/repository/dist/app/latest.tar.gz", bytes.NewReader([]byte("shadow")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", aliasURL, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, _ = download()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		// The target is untouched
+		resp, err = makeRequest("HEAD", baseURL+"/repository/dist/app/1.1/app.tar.gz", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
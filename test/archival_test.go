@@ -0,0 +1,131 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestRepositoryArchival(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	archiveDir := t.TempDir()
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.ArchiveDir = archiveDir
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"legacy","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	for path, content := range map[string]string{"app/1.0/app.bin": "release 1.0", "app/2.0/app.bin": "release 2.0"} {
+		resp, err := makeRequest("PUT", baseURL+"/repository/legacy/"+path, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	// runTask waits for the task a request started to succeed
+	runTask := func(method, url, body string) {
+		resp, err := makeRequest(method, url, bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		var task struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+
+		deadline := time.Now().Add(10 * time.Second)
+		for task.Status != "succeeded" {
+			require.True(t, time.Now().Before(deadline), "task did not finish, status %s", task.Status)
+			require.NotEqual(t, "failed", task.Status, task.Error)
+			time.Sleep(50 * time.Millisecond)
+			resp, err := makeRequest("GET", baseURL+"/api/v1/tasks/"+task.ID, nil)
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		}
+	}
+
+	t.Run("Archive", func(t *testing.T) {
+		runTask("POST", baseURL+"/api/v1/repositories/legacy/archive", `{"remove":true}`)
+
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/legacy/archive", nil)
+		require.NoError(t, err)
+		var archive struct {
+			State  string `json:"state"`
+			Bundle string `json:"bundle"`
+			Files  int    `json:"files"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&archive))
+		assert.Equal(t, "cold", archive.State)
+		assert.Equal(t, 2, archive.Files)
+		_, err = os.Stat(filepath.Join(archiveDir, "legacy", archive.Bundle))
+		assert.NoError(t, err)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/legacy/archive/manifest", nil)
+		require.NoError(t, err)
+		var manifest struct {
+			Files []struct {
+				Path   string `json:"path"`
+				SHA256 string `json:"sha256"`
+			} `json:"files"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+		require.Len(t, manifest.Files, 2)
+		assert.Len(t, manifest.Files[0].SHA256, 64)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/legacy/archive", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/legacy/app/3.0/app.bin", bytes.NewReader([]byte("release 3.0")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/legacy/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Contains(t, string(body), "restore it")
+
+		// Settings stay manageable
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/legacy", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		runTask("POST", baseURL+"/api/v1/repositories/legacy/archive/restore", "")
+
+		resp, err := makeRequest("GET", baseURL+"/repository/legacy/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "release 1.0", string(body))
+
+		resp, err = makeRequest("PUT", baseURL+"/repository/legacy/app/3.0/app.bin", bytes.NewReader([]byte("release 3.0")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/legacy/archive", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,164 @@
+package test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestArchives(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"site","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	get := func(path string) string {
+		resp, err := makeRequest("GET", baseURL+"/repository/site/"+path, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("Extract Zip", func(t *testing.T) {
+		archive := buildZip(t, map[string]string{"index.html": "<h1>docs</h1>", "css/site.css": "body{}"})
+		resp, err := makeRequest("PUT", baseURL+"/repository/site/docs/1.0?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var result struct {
+			Artifacts []string `json:"artifacts"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		sort.Strings(result.Artifacts)
+		assert.Equal(t, []string{"docs/1.0/css/site.css", "docs/1.0/index.html"}, result.Artifacts)
+
+		assert.Equal(t, "<h1>docs</h1>", get("docs/1.0/index.html"))
+		assert.Equal(t, "body{}", get("docs/1.0/css/site.css"))
+	})
+
+	t.Run("Extract Tar Gz", func(t *testing.T) {
+		archive := buildTarGz(t, map[string]string{"bin/tool": "binary"})
+		resp, err := makeRequest("PUT", baseURL+"/repository/site/tools?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "binary", get("tools/bin/tool"))
+	})
+
+	t.Run("Unsafe Paths Rejected", func(t *testing.T) {
+		archive := buildZip(t, map[string]string{"../escape.txt": "nope"})
+		resp, err := makeRequest("PUT", baseURL+"/repository/site/docs?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = makeRequest("PUT", baseURL+"/repository/site/docs?extract=true", bytes.NewReader([]byte("not an archive")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Content Types Checked Per Entry", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"binaries","type":"raw","config":{"content_types":["application/octet-stream"]}}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		archive := buildZip(t, map[string]string{"bin/tool": "binary", "index.html": "<h1>docs</h1>"})
+		resp, err = makeRequest("PUT", baseURL+"/repository/binaries/1.0?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+		archive = buildZip(t, map[string]string{"bin/tool": "binary"})
+		resp, err = makeRequest("PUT", baseURL+"/repository/binaries/1.0?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("Download Zip", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/site/docs/1.0?archive=zip", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), `filename="1.0.zip"`)
+
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+
+		contents := map[string]string{}
+		for _, f := range zr.File {
+			r, err := f.Open()
+			require.NoError(t, err)
+			body, _ := io.ReadAll(r)
+			r.Close()
+			contents[f.Name] = string(body)
+		}
+		assert.Equal(t, map[string]string{"index.html": "<h1>docs</h1>", "css/site.css": "body{}"}, contents)
+	})
+
+	t.Run("Download Tar Gz", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/site/tools?archive=tar.gz", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		gr, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		tr := tar.NewReader(gr)
+		header, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "bin/tool", header.Name)
+		body, _ := io.ReadAll(tr)
+		assert.Equal(t, "binary", string(body))
+	})
+
+	t.Run("Download Missing Prefix", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/site/missing?archive=zip", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
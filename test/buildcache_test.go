@@ -0,0 +1,161 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCacheRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"builds","type":"buildcache","config":{"max_entry_size":64,"max_cache_size":100}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	send := func(method, url string, body []byte) *http.Response {
+		resp, err := makeRequest(method, baseURL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Gradle Entries", func(t *testing.T) {
+		key := "/repository/builds/0123456789abcdef0123456789abcdef"
+		assert.Equal(t, http.StatusNotFound, send("GET", key, nil).StatusCode)
+		assert.Equal(t, http.StatusCreated, send("PUT", key, []byte("task outputs")).StatusCode)
+		assert.Equal(t, http.StatusOK, send("PUT", key, []byte("task outputs")).StatusCode)
+
+		resp := send("GET", key, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "task outputs", string(body))
+	})
+
+	t.Run("Bazel Action Cache And CAS", func(t *testing.T) {
+		content := []byte("compiled object")
+		sum := sha256.Sum256(content)
+		digest := hex.EncodeToString(sum[:])
+		action := strings.Repeat("1f", 32)
+
+		assert.Equal(t, http.StatusCreated, send("PUT", "/repository/builds/cas/"+digest, content).StatusCode)
+		assert.Equal(t, http.StatusCreated, send("PUT", "/repository/builds/ac/"+action, []byte("action result")).StatusCode)
+		assert.Equal(t, http.StatusCreated, send("PUT", "/repository/builds/ci/ac/"+action, []byte("other result")).StatusCode)
+
+		resp := send("HEAD", "/repository/builds/cas/"+digest, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, fmt.Sprint(len(content)), resp.Header.Get("Content-Length"))
+
+		resp = send("GET", "/repository/builds/ci/ac/"+action, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "other result", string(body))
+
+		other := strings.Repeat("2e", 32)
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/repository/builds/cas/"+other, content).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("HEAD", "/repository/builds/cas/"+other, nil).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/repository/builds/blobs/"+digest, content).StatusCode)
+	})
+
+	t.Run("Entries Over The Size Limit Are Refused", func(t *testing.T) {
+		resp := send("PUT", "/repository/builds/"+strings.Repeat("ab", 16), bytes.Repeat([]byte("x"), 65))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			key := fmt.Sprintf("/repository/builds/%032x", i+100)
+			require.Equal(t, http.StatusCreated, send("PUT", key, bytes.Repeat([]byte("y"), 40)).StatusCode)
+		}
+
+		resp := send("POST", "/api/v1/repositories/builds/evict", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var report struct {
+			Evicted []string `json:"evicted"`
+			Size    int64    `json:"size"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.NotEmpty(t, report.Evicted)
+		assert.LessOrEqual(t, report.Size, int64(100))
+	})
+
+	t.Run("Invalid Configuration", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"bad","type":"buildcache","config":{"max_cache_size":-1}}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestObjectCacheRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"sccache","type":"buildcache","config":{"protocol":"objects","ttl":"7d"}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	send := func(method, url string, body []byte) *http.Response {
+		resp, err := makeRequest(method, baseURL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Objects At Any Path", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("MKCOL", "/repository/sccache/a/b/c/", nil).StatusCode)
+		assert.Equal(t, http.StatusCreated, send("PUT", "/repository/sccache/a/b/c/abc123", []byte("object")).StatusCode)
+		assert.Equal(t, http.StatusCreated, send("PUT", "/repository/sccache/.sccache_check", []byte("Hello, World!")).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/repository/sccache/a/b/c/missing", nil).StatusCode)
+
+		resp := send("GET", "/repository/sccache/a/b/c/abc123", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "object", string(body))
+
+		assert.Equal(t, http.StatusNoContent, send("DELETE", "/repository/sccache/.sccache_check", nil).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/repository/sccache/a/.upload-1", []byte("x")).StatusCode)
+	})
+
+	t.Run("Hit Rate", func(t *testing.T) {
+		resp := send("GET", "/api/v1/repositories/sccache/cache-stats", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var stats struct {
+			Entries int     `json:"entries"`
+			Hits    int64   `json:"hits"`
+			Misses  int64   `json:"misses"`
+			HitRate float64 `json:"hit_rate"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		assert.Equal(t, 1, stats.Entries)
+		assert.Equal(t, int64(1), stats.Hits)
+		assert.Equal(t, int64(1), stats.Misses)
+		assert.Equal(t, 0.5, stats.HitRate)
+	})
+
+	t.Run("Protocol Cannot Change", func(t *testing.T) {
+		resp := send("PUT", "/api/v1/repositories/sccache", []byte(`{"config":{"ttl":"1d"}}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = send("PUT", "/api/v1/repositories/sccache", []byte(`{"config":{"protocol":"objects","ttl":"1d"}}`))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -0,0 +1,241 @@
+package test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumSidecars(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"libs","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	content := []byte("library contents")
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	putVerified := func(path string, body []byte, headers map[string]string) *http.Response {
+		req, err := http.NewRequest("PUT", baseURL+"/repository/libs/"+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	put := func(path string, body []byte, checksum string) *http.Response {
+		if checksum == "" {
+			return putVerified(path, body, nil)
+		}
+		return putVerified(path, body, map[string]string{"X-Checksum-Sha256": checksum})
+	}
+
+	t.Run("Verified Upload", func(t *testing.T) {
+		resp := put("lib/1.0/lib.jar", content, digest)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, digest, resp.Header.Get("X-Checksum-Sha256"))
+	})
+
+	t.Run("Mismatched Upload Rejected", func(t *testing.T) {
+		resp := put("lib/1.0/lib.jar", []byte("tampered"), digest)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = put("lib/1.0/other.jar", content, "not-a-digest")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// The verified artifact is still in place
+		resp, err := makeRequest("GET", baseURL+"/repository/libs/lib/1.0/lib.jar", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, content, body)
+		assert.Equal(t, digest, resp.Header.Get("X-Checksum-Sha256"))
+	})
+
+	t.Run("Other Algorithms", func(t *testing.T) {
+		sha512Digest := fmt.Sprintf("%x", sha512.Sum512(content))
+		resp := putVerified("lib/1.0/lib.zip", content, map[string]string{
+			"X-Checksum-Sha512": sha512Digest,
+			"X-Checksum-Md5":    fmt.Sprintf("%x", md5.Sum(content)),
+		})
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err := makeRequest("GET", baseURL+"/repository/libs/lib/1.0/lib.zip", nil)
+		require.NoError(t, err)
+		assert.Equal(t, sha512Digest, resp.Header.Get("X-Checksum-Sha512"))
+
+		// The verified digests are recorded with the artifact
+		resp, err = makeRequest("GET", baseURL+"/api/v1/search/checksum?sha256="+digest, nil)
+		require.NoError(t, err)
+		var found struct {
+			Artifacts []struct {
+				Path     string   `json:"path"`
+				SHA512   string   `json:"sha512"`
+				Verified []string `json:"verified"`
+			} `json:"artifacts"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&found))
+		verified := map[string][]string{}
+		for _, artifact := range found.Artifacts {
+			verified[artifact.Path] = artifact.Verified
+		}
+		assert.Equal(t, []string{"md5", "sha512"}, verified["lib/1.0/lib.zip"])
+		assert.Equal(t, []string{"sha256"}, verified["lib/1.0/lib.jar"])
+
+		resp = putVerified("lib/1.0/lib.tar", content, map[string]string{"X-Checksum-Sha512": digest})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Structured Mismatch Error", func(t *testing.T) {
+		resp := putVerified("lib/1.0/lib.tar", []byte("truncated"), map[string]string{"X-Checksum-Md5": fmt.Sprintf("%x", md5.Sum(content))})
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		var mismatch map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&mismatch))
+		assert.Equal(t, "md5", mismatch["algorithm"])
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum(content)), mismatch["expected"])
+		assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("truncated"))), mismatch["actual"])
+		assert.NotEmpty(t, mismatch["request_id"])
+
+		resp, err := makeRequest("GET", baseURL+"/repository/libs/lib/1.0/lib.tar", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Sidecars", func(t *testing.T) {
+		expected := map[string]string{
+			"sha256": digest,
+			"sha1":   fmt.Sprintf("%x", sha1.Sum(content)),
+			"md5":    fmt.Sprintf("%x", md5.Sum(content)),
+		}
+		for algorithm, value := range expected {
+			resp, err := makeRequest("GET", baseURL+"/repository/libs/lib/1.0/lib.jar."+algorithm, nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, value, string(body), algorithm)
+		}
+
+		resp, err := makeRequest("GET", baseURL+"/repository/libs/missing.jar.sha256", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Uploaded Sidecar Takes Precedence", func(t *testing.T) {
+		resp := put("lib/1.0/lib.jar.sha1", []byte("custom"), "")
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err := makeRequest("GET", baseURL+"/repository/libs/lib/1.0/lib.jar.sha1", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "custom", string(body))
+	})
+}
+
+func TestChecksumSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"libs","type":"raw"}`,
+		`{"name":"mirror","type":"raw"}`,
+		`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	content := []byte("library contents")
+	digest := fmt.Sprintf("%x", sha256.Sum256(content))
+	for _, url := range []string{"/repository/libs/lib/1.0/lib.jar", "/repository/mirror/lib.jar", "/repository/libs/other.jar"} {
+		body := content
+		if url == "/repository/libs/other.jar" {
+			body = []byte("other contents")
+		}
+		resp, err := makeRequest("PUT", baseURL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	// The same content as an image layer
+	resp, err := makeRequest("POST", baseURL+"/v2/images/app/blobs/uploads/", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+resp.Header.Get("Location")+"?digest=sha256:"+digest, bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.empty.v1+json","size":2,"digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},`+
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar","size":%d,"digest":"sha256:%s"}]}`, len(content), digest)
+	resp, err = makeRequest("PUT", baseURL+"/v2/images/app/manifests/1.0", bytes.NewReader([]byte(manifest)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	search := func(query string) (int, map[string]interface{}) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/search/checksum?"+query, nil)
+		require.NoError(t, err)
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	status, result := search("sha256=" + digest)
+	require.Equal(t, http.StatusOK, status)
+	artifacts := result["artifacts"].([]interface{})
+	require.Len(t, artifacts, 2)
+	assert.Equal(t, "libs", artifacts[0].(map[string]interface{})["repository"])
+	assert.Equal(t, "lib.jar", artifacts[1].(map[string]interface{})["path"])
+	images := result["images"].([]interface{})
+	require.Len(t, images, 1)
+	assert.Equal(t, "images", images[0].(map[string]interface{})["repository"])
+	assert.Equal(t, "blob", images[0].(map[string]interface{})["kind"])
+
+	status, result = search("sha1=" + fmt.Sprintf("%x", sha1.Sum(content)))
+	require.Equal(t, http.StatusOK, status)
+	assert.Len(t, result["artifacts"], 2)
+	assert.Empty(t, result["images"])
+
+	// Deleted artifacts are no longer found
+	resp, err = makeRequest("DELETE", baseURL+"/repository/mirror/lib.jar", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, result = search("sha256=sha256:" + digest)
+	assert.Len(t, result["artifacts"], 1)
+
+	status, _ = search("sha256=abc")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = search("sha256=" + digest + "&md5=" + fmt.Sprintf("%x", md5.Sum(content)))
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = search("")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,43 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/cluster"
+)
+
+func TestClusterStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	node, err := cluster.NewNode(t.TempDir(), "node-1", "https://localhost:"+s.GetPort(), time.Minute, logrus.New())
+	require.NoError(t, err)
+	acquired, err := node.TryAcquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	s.SetCluster(node)
+
+	resp, err := makeRequest("GET", fmt.Sprintf("https://localhost:%s/api/v1/cluster", s.GetPort()), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var status cluster.Status
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "node-1", status.NodeID)
+	assert.Equal(t, cluster.RoleLeader, status.Role)
+	require.NotNil(t, status.Leader)
+	assert.Equal(t, "node-1", status.Leader.NodeID)
+}
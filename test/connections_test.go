@@ -0,0 +1,47 @@
+package test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestHTTP2(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	proto := func(s *server.Server) int {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := client.Get(fmt.Sprintf("https://localhost:%s/api/v1/health", s.GetPort()))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.ProtoMajor
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+			config.Connections.MaxConcurrentStreams = 10
+		})
+		defer cleanup()
+		assert.Equal(t, 2, proto(s))
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+			config.Connections.DisableHTTP2 = true
+		})
+		defer cleanup()
+		assert.Equal(t, 1, proto(s))
+	})
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentAddressedRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"cas","type":"raw","config":{"content_addressed":true}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	content := []byte("model weights")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	send := func(method, url string, body []byte) *http.Response {
+		resp, err := makeRequest(method, baseURL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Upload Returns The Hash Path", func(t *testing.T) {
+		resp := send("POST", "/repository/cas/", content)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var result struct {
+			Path string `json:"path"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.Equal(t, "sha256/"+digest, result.Path)
+		assert.Equal(t, digest, resp.Header.Get("X-Checksum-Sha256"))
+
+		resp = send("GET", "/repository/cas/sha256/"+digest, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, content, body)
+	})
+
+	t.Run("Identical Content Is Deduplicated", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("POST", "/repository/cas/", content).StatusCode)
+		assert.Equal(t, http.StatusOK, send("PUT", "/repository/cas/sha256/"+digest, content).StatusCode)
+		assert.Equal(t, http.StatusOK, send("PUT", "/repository/cas/sha256/"+digest+"?overwrite=true", content).StatusCode)
+	})
+
+	t.Run("Paths Only Hold The Content They Name", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send("PUT", "/repository/cas/models/resnet.bin", content).StatusCode)
+		assert.Equal(t, http.StatusConflict, send("PUT", "/repository/cas/sha256/"+digest, []byte("other weights")).StatusCode)
+
+		other := sha256.Sum256([]byte("expected"))
+		resp := send("PUT", "/repository/cas/sha256/"+hex.EncodeToString(other[:]), []byte("something else"))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = send("GET", "/repository/cas/sha256/"+hex.EncodeToString(other[:]), nil)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Aliases Name Content", func(t *testing.T) {
+		resp := send("PUT", "/api/v1/repositories/cas/aliases/models/resnet/latest", []byte(`{"target":"sha256/`+digest+`"}`))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = send("GET", "/repository/cas/models/resnet/latest", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, content, body)
+
+		other := sha256.Sum256([]byte("other"))
+		resp = send("PUT", "/api/v1/repositories/cas/aliases/sha256/"+hex.EncodeToString(other[:]), []byte(`{"target":"sha256/`+digest+`"}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("POST Needs A Content-Addressed Repository", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"plain","type":"raw"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, http.StatusMethodNotAllowed, send("POST", "/repository/plain/", content).StatusCode)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"bad","type":"raw","config":{"content_addressed":true,"allowed_extensions":[".bin"]}}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
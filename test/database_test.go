@@ -0,0 +1,54 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseMaintenance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s/api/v1/admin/database", s.GetPort())
+
+	t.Run("Stats", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var report struct {
+			FileSize int64 `json:"file_size"`
+			Buckets  []struct {
+				Name string `json:"name"`
+			} `json:"buckets"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Greater(t, report.FileSize, int64(0))
+		assert.NotEmpty(t, report.Buckets)
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/check", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			OK       bool     `json:"ok"`
+			Problems []string `json:"problems"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		assert.True(t, result.OK)
+		assert.Empty(t, result.Problems)
+	})
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DebugAddress = "127.0.0.1:0"
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("http://%s", s.DebugAddress())
+
+	t.Run("Variables", func(t *testing.T) {
+		resp, err := http.Get(baseURL + "/debug/vars")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var vars map[string]json.RawMessage
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&vars))
+		assert.Contains(t, vars, "memstats")
+	})
+
+	t.Run("Goroutine Profile", func(t *testing.T) {
+		resp, err := http.Get(baseURL + "/debug/pprof/goroutine?debug=1")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Dump", func(t *testing.T) {
+		resp, err := http.Post(baseURL+"/debug/dump", "", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Files []string `json:"files"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		require.Len(t, result.Files, 2)
+		for _, file := range result.Files {
+			info, err := os.Stat(file)
+			require.NoError(t, err)
+			assert.NotZero(t, info.Size())
+		}
+	})
+
+	t.Run("Not on Main Port", func(t *testing.T) {
+		resp, err := makeRequest("GET", fmt.Sprintf("https://localhost:%s/debug/vars", s.GetPort()), nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestRepositoryDiff(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	t.Run("Images", func(t *testing.T) {
+		reqBody := []byte(`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		for tag, stage := range map[string]string{"1.0": "beta", "2.0": "stable"} {
+			manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],"annotations":{"stage":"` + stage + `"}}`)
+			resp, err := makeRequest("PUT", baseURL+"/v2/images/app/manifests/"+tag, bytes.NewReader(manifest))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+		}
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/images/diff?from=app:1.0&to=app:2.0", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var diff struct {
+			From struct {
+				Reference string `json:"reference"`
+			} `json:"from"`
+			Labels []struct {
+				Name string `json:"name"`
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"labels"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&diff))
+		assert.Equal(t, "1.0", diff.From.Reference)
+		require.Len(t, diff.Labels, 1)
+		assert.Equal(t, "stage", diff.Labels[0].Name)
+		assert.Equal(t, "beta", diff.Labels[0].From)
+		assert.Equal(t, "stable", diff.Labels[0].To)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/images/diff?from=app:1.0&to=app:3.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/images/diff?from=app:1.0&to=release", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Snapshots", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"files","type":"raw"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, err = makeRequest("PUT", baseURL+"/repository/files/app.bin", bytes.NewReader([]byte("1.0")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/files/snapshots", bytes.NewReader([]byte(`{"name":"v1"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, err = makeRequest("PUT", baseURL+"/repository/files/notes.txt", bytes.NewReader([]byte("notes")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/files/diff?from=v1", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var diff struct {
+			To    string `json:"to"`
+			Added []struct {
+				Name string `json:"name"`
+			} `json:"added"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&diff))
+		assert.Equal(t, "current", diff.To)
+		require.Len(t, diff.Added, 1)
+		assert.Equal(t, "notes.txt", diff.Added[0].Name)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/files/diff?from=app:1.0&to=app:2.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/files/diff", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
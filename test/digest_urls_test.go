@@ -0,0 +1,115 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestURLs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"app","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	upload := func(path, content string) string {
+		resp, err := makeRequest("PUT", baseURL+"/repository/app/"+path, strings.NewReader(content))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Less(t, resp.StatusCode, 300)
+		var result struct {
+			ImmutableURL string `json:"immutable_url"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return baseURL + result.ImmutableURL
+	}
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	get := func(url string) (*http.Response, string) {
+		resp, err := makeRequest("GET", url, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	v1URL := upload("latest/app.tar.gz", "version 1")
+	require.Equal(t, baseURL+"/repository/app/_by-digest/"+digest("version 1")+"/latest/app.tar.gz", v1URL)
+
+	t.Run("Served Immutable", func(t *testing.T) {
+		resp, body := get(v1URL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "version 1", body)
+		assert.Equal(t, "public, max-age=31536000, immutable", resp.Header.Get("Cache-Control"))
+		assert.Equal(t, `"`+digest("version 1")+`"`, resp.Header.Get("ETag"))
+
+		resp, _ = get(baseURL + "/repository/app/latest/app.tar.gz")
+		assert.Equal(t, v1URL, baseURL+resp.Header.Get("X-Immutable-Url"))
+		assert.Empty(t, resp.Header.Get("Cache-Control"))
+	})
+
+	t.Run("Not Modified", func(t *testing.T) {
+		req, err := http.NewRequest("GET", v1URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", `"`+digest("version 1")+`"`)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	})
+
+	t.Run("Overwritten Path", func(t *testing.T) {
+		upload("archive/app-1.tar.gz", "version 1")
+		v2URL := upload("latest/app.tar.gz", "version 2")
+		assert.NotEqual(t, v1URL, v2URL)
+
+		// The old URL still has its content, stored at another path
+		resp, body := get(v1URL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "version 1", body)
+
+		resp, body = get(v2URL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "version 2", body)
+
+		resp, err := makeRequest("DELETE", baseURL+"/repository/app/archive/app-1.tar.gz", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		resp, _ = get(v1URL)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Invalid Digest", func(t *testing.T) {
+		resp, _ := get(baseURL + "/repository/app/_by-digest/md5:abc/latest/app.tar.gz")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Read Only", func(t *testing.T) {
+		resp, err := makeRequest("PUT", v1URL, strings.NewReader("tampered"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+}
@@ -0,0 +1,60 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadAbortedByClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"releases","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Send part of the artifact, then give up
+	body, writer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "PUT", baseURL+"/repository/releases/app.tar", body)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	go func() {
+		writer.Write([]byte(strings.Repeat("partial ", 1024)))
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		writer.CloseWithError(context.Canceled)
+	}()
+	_, err = client.Do(req)
+	require.Error(t, err)
+
+	require.Eventually(t, func() bool {
+		resp, err := makeRequest("GET", baseURL+"/metrics", nil)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		metrics, _ := io.ReadAll(resp.Body)
+		return strings.Contains(string(metrics), `depot_uploads_aborted_total{repository="releases",kind="raw"} 1`)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	resp, err = makeRequest("HEAD", baseURL+"/repository/releases/app.tar", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
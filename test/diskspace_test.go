@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestInsufficientStorage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// No disk has this much to spare, so every upload is refused
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.MinFreeSpace = 1 << 62
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"releases","type":"raw"}`,
+		`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	t.Run("Raw Upload", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/releases/app.tar.gz", strings.NewReader("contents"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "Insufficient storage for this upload", body["error"])
+
+		resp, err = makeRequest("GET", baseURL+"/repository/releases/app.tar.gz", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Blob Upload", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/v2/images/app/blobs/uploads/", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+	})
+
+	t.Run("Unknown Repository", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/missing/app.tar.gz", strings.NewReader("contents"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Readiness Warning", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/readyz", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var status struct {
+			Problems []map[string]interface{} `json:"problems"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		require.Len(t, status.Problems, 1)
+		assert.Equal(t, "storage", status.Problems[0]["component"])
+		assert.Equal(t, "warning", status.Problems[0]["severity"])
+	})
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestDockerPathRouting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, name := range []string{"images-a", "images-b"} {
+		reqBody := []byte(`{"name":"` + name + `","type":"docker","config":{"http_port":0,"https_port":0}}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	resp, err := makeRequest("PUT", baseURL+"/v2/images-a/app/manifests/1.0", bytes.NewReader(manifest))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Location"), "/v2/images-a/app/manifests/")
+
+	resp, err = makeRequest("GET", baseURL+"/v2/images-a/app/tags/list", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequest("GET", baseURL+"/v2/images-b/app/manifests/1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/images-b", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var info map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, fmt.Sprintf("https://localhost:%s/v2/images-b/", s.GetPort()), info["main_port_endpoint"])
+}
+
+func TestDockerMainPort(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	// Without ports the repository gets the default one
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+		`{"name":"later","type":"docker","enabled":false,"config":{"v1_enabled":false}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		Config models.DockerRepositoryConfig `json:"config"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	assert.Equal(t, 5000, created.Config.HTTPPort)
+
+	// Port 0 serves the repository at /v2/ itself, for one repository only
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+		`{"name":"images","type":"docker","config":{"http_port":0}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+		`{"name":"more-images","type":"docker","config":{"http_port":0}}`)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	resp, err = makeRequest("PUT", baseURL+"/v2/app/manifests/1.0", bytes.NewReader(manifest))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("GET", baseURL+"/v2/app/tags/list", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/images", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var info map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, fmt.Sprintf("https://localhost:%s/v2/", s.GetPort()), info["endpoint"])
+	assert.Nil(t, info["main_port_endpoint"])
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestDockerPortAuto(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Occupy the first port of the range so it has to be skipped
+	busy, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer busy.Close()
+	first := busy.Addr().(*net.TCPAddr).Port
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPortMin, config.DockerPortMax = first, first+20
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	// An offline repository keeps its port, and HTTPS cannot be combined
+	// with a picked port
+	parked := first + 1
+	for body, status := range map[string]int{
+		fmt.Sprintf(`{"name":"parked","type":"docker","enabled":false,"config":{"http_port":%d}}`, parked): http.StatusCreated,
+		`{"name":"auto-tls","type":"docker","config":{"http_port":"auto","https_port":5443}}`:              http.StatusBadRequest,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, status, resp.StatusCode, body)
+	}
+
+	ports := map[int]bool{}
+	for _, name := range []string{"auto-a", "auto-b"} {
+		body := `{"name":"` + name + `","type":"docker","config":{"http_port":"auto"}}`
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var created models.Repository
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		resp.Body.Close()
+		var config models.DockerRepositoryConfig
+		require.NoError(t, json.Unmarshal(created.Config, &config))
+		assert.Greater(t, config.HTTPPort, first)
+		assert.LessOrEqual(t, config.HTTPPort, first+20)
+		assert.NotEqual(t, parked, config.HTTPPort)
+		ports[config.HTTPPort] = true
+
+		// The chosen port is persisted
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/"+name, nil)
+		require.NoError(t, err)
+		var stored models.Repository
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stored))
+		resp.Body.Close()
+		assert.JSONEq(t, string(created.Config), string(stored.Config))
+
+		resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v2/", config.HTTPPort))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Len(t, ports, 2)
+}
+
+func TestDockerBindAddress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories",
+		bytes.NewReader([]byte(`{"name":"bad-bind","type":"docker","config":{"http_port":"auto","bind_address":"127.0.0.1:5000"}}`)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories",
+		bytes.NewReader([]byte(`{"name":"loopback","type":"docker","config":{"http_port":"auto","bind_address":"127.0.0.1"}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.Repository
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	var config models.DockerRepositoryConfig
+	require.NoError(t, json.Unmarshal(created.Config, &config))
+
+	resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/v2/", config.HTTPPort))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/loopback", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var info map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d/v2/", config.HTTPPort), info["endpoint"])
+}
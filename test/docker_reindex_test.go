@@ -0,0 +1,70 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestDockerReindex(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`,
+		`{"name":"files","type":"raw"}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+	resp, err := makeRequest("PUT", baseURL+"/v2/images/app/manifests/1.0", bytes.NewReader(manifest))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	digest := resp.Header.Get("Docker-Content-Digest")
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/images/reindex", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var report struct {
+		Images []struct {
+			Name string            `json:"name"`
+			Tags map[string]string `json:"tags"`
+		} `json:"images"`
+		Problems []string `json:"problems"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.Len(t, report.Images, 1)
+	assert.Equal(t, "app", report.Images[0].Name)
+	assert.Equal(t, map[string]string{"1.0": digest}, report.Images[0].Tags)
+	assert.Empty(t, report.Problems)
+
+	resp, err = makeRequest("GET", baseURL+"/v2/images/app/manifests/1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/images/reindex?image=../files", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/files/reindex", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestDockerRegistryCertificate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	certsDir := t.TempDir()
+	require.NoError(t, generateTestCertificate(filepath.Join(certsDir, "registry.crt"), filepath.Join(certsDir, "registry.key")))
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.CertificatesDir = certsDir
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	freePort := func() int {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close()
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+	servedCertificate := func(port int) []byte {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+	fileCertificate := func(path string) []byte {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		block, _ := pem.Decode(data)
+		return block.Bytes
+	}
+
+	// A named certificate from the certificate directory
+	named := freePort()
+	body := fmt.Sprintf(`{"name":"named","type":"docker","config":{"https_port":%d,"tls":{"certificate":"registry"}}}`, named)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, fileCertificate(filepath.Join(certsDir, "registry.crt")), servedCertificate(named))
+
+	// Certificate and key files
+	otherDir := t.TempDir()
+	certFile, keyFile := filepath.Join(otherDir, "other.crt"), filepath.Join(otherDir, "other.key")
+	require.NoError(t, generateTestCertificate(certFile, keyFile))
+	files := freePort()
+	body = fmt.Sprintf(`{"name":"files","type":"docker","config":{"https_port":%d,"tls":{"cert_file":%q,"key_file":%q}}}`, files, certFile, keyFile)
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, fileCertificate(certFile), servedCertificate(files))
+
+	for _, config := range []string{
+		fmt.Sprintf(`{"https_port":%d,"tls":{"certificate":"missing"}}`, freePort()),
+		fmt.Sprintf(`{"https_port":%d,"tls":{"certificate":"../registry"}}`, freePort()),
+		fmt.Sprintf(`{"https_port":%d,"tls":{"cert_file":%q}}`, freePort(), certFile),
+		`{"http_port":0,"https_port":0,"tls":{"certificate":"registry"}}`,
+	} {
+		body := `{"name":"invalid","type":"docker","config":` + config + `}`
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, config)
+	}
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadStatistics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"downloads","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, path := range []string{"popular.bin", "rare.bin", "unused.bin"} {
+		resp, err := makeRequest("PUT", baseURL+"/repository/downloads/"+path, bytes.NewReader([]byte(path)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	download := func(path string) {
+		resp, err := makeRequest("GET", baseURL+"/repository/downloads/"+path, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	download("popular.bin")
+	download("popular.bin")
+	download("popular.bin")
+	download("rare.bin")
+
+	// HEAD requests are not downloads
+	resp, err = makeRequest("HEAD", baseURL+"/repository/downloads/unused.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	t.Run("Artifact Counter", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/downloads/downloads/popular.bin", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var downloads map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&downloads))
+		assert.Equal(t, float64(3), downloads["count"])
+	})
+
+	t.Run("Top Report", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/downloads/top", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var top []map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&top))
+		require.Len(t, top, 2)
+		assert.Equal(t, "popular.bin", top[0]["path"])
+		assert.Equal(t, "rare.bin", top[1]["path"])
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/downloads/top?limit=1", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&top))
+		assert.Len(t, top, 1)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/downloads/top?limit=-1", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryEnabled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"files","type":"raw"}`,
+		`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err := makeRequest("PUT", baseURL+"/repository/files/app.bin", bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	resp, err = makeRequest("PUT", baseURL+"/v2/images/app/manifests/1.0", bytes.NewReader(manifest))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	setEnabled := func(name string, enabled bool) {
+		body := fmt.Sprintf(`{"enabled":%t}`, enabled)
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/repositories/"+name, bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	setEnabled("files", false)
+	setEnabled("images", false)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/files/app.bin", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+"/repository/files/other.bin", bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, err = makeRequest("GET", baseURL+"/v2/images/app/manifests/1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Nor can the other raw repository APIs change it
+	resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/files/properties/app.bin", bytes.NewReader([]byte(`{"team":"web"}`)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/files/aliases/latest.bin", bytes.NewReader([]byte(`{"target":"app.bin"}`)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/files/aliases", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Nothing is deleted while offline
+	setEnabled("files", true)
+	setEnabled("images", true)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/files/app.bin", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = makeRequest("GET", baseURL+"/v2/images/app/manifests/1.0", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
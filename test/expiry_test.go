@@ -0,0 +1,72 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"ci","type":"raw","config":{"immutable_paths":["releases/**"],"expiry_rules":[{"path_pattern":"nightly/**","ttl":"7d"}]}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	expires := func(path string) time.Duration {
+		resp, err := makeRequest("HEAD", baseURL+"/repository/ci/"+path, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		header := resp.Header.Get("X-Artifact-Expires")
+		if header == "" {
+			return 0
+		}
+		expiresAt, err := http.ParseTime(header)
+		require.NoError(t, err)
+		return time.Until(expiresAt)
+	}
+
+	put := func(path string) int {
+		resp, err := makeRequest("PUT", baseURL+"/repository/ci/"+path, bytes.NewReader([]byte("data")))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	t.Run("Requested TTL", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("scratch/build.log?ttl=2h"))
+		assert.InDelta(t, 2*time.Hour, expires("scratch/build.log"), float64(time.Minute))
+	})
+
+	t.Run("Expiry Rule", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("nightly/app.bin"))
+		assert.InDelta(t, 7*24*time.Hour, expires("nightly/app.bin"), float64(time.Minute))
+
+		// A request's own TTL takes precedence over the rule
+		require.Equal(t, http.StatusOK, put("nightly/app.bin?ttl=1d"))
+		assert.InDelta(t, 24*time.Hour, expires("nightly/app.bin"), float64(time.Minute))
+	})
+
+	t.Run("Re-upload Clears Expiry", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, put("scratch/build.log"))
+		assert.Zero(t, expires("scratch/build.log"))
+	})
+
+	t.Run("Invalid TTL Rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, put("scratch/x.bin?ttl=soon"))
+		assert.Equal(t, http.StatusBadRequest, put("releases/1.0/app.bin?ttl=1d"))
+	})
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/depot/depot/internal/extensions/bearer"
+	_ "github.com/depot/depot/internal/extensions/denypaths"
+	"github.com/depot/depot/internal/server"
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestServerExtensions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	assert.Subset(t, server.Extensions(), []string{"bearer", "denypaths"})
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.Extensions = "bearer, denypaths"
+		config.ExtensionSettings = map[string]map[string]string{
+			"bearer":    {"token": "s3cret"},
+			"denypaths": {"patterns": "**/*.exe"},
+		}
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	// request sends an authenticated request unless token is empty
+	request := func(method, url, token, body string) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("Listed", func(t *testing.T) {
+		resp := request("GET", baseURL+"/api/v1/system/extensions", "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var names []string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&names))
+		assert.Equal(t, []string{"bearer", "denypaths"}, names)
+	})
+
+	t.Run("Authentication", func(t *testing.T) {
+		resp := request("POST", baseURL+"/api/v1/repositories", "", `{"name":"files","type":"raw"}`)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+
+		resp = request("POST", baseURL+"/api/v1/repositories", "wrong", `{"name":"files","type":"raw"}`)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = request("POST", baseURL+"/api/v1/repositories", "s3cret", `{"name":"files","type":"raw"}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		// Reads stay open
+		resp = request("GET", baseURL+"/api/v1/repositories/files", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		resp := request("PUT", baseURL+"/repository/files/tools/setup.exe", "s3cret", "MZ")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		var refusal struct {
+			Error     string `json:"error"`
+			RequestID string `json:"request_id"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&refusal))
+		assert.Contains(t, refusal.Error, "**/*.exe")
+		assert.NotEmpty(t, refusal.RequestID)
+
+		resp = request("PUT", baseURL+"/repository/files/tools/setup.sh", "s3cret", "#!/bin/sh")
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("RegistryPort", func(t *testing.T) {
+		resp := request("POST", baseURL+"/api/v1/repositories", "s3cret", `{"name":"images","type":"docker","config":{"http_port":"auto"}}`)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var created models.Repository
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		var config models.DockerRepositoryConfig
+		require.NoError(t, json.Unmarshal(created.Config, &config))
+		registryURL := fmt.Sprintf("http://localhost:%d", config.HTTPPort)
+
+		resp = request("POST", registryURL+"/v2/app/blobs/uploads/", "", "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+
+		resp = request("POST", registryURL+"/v2/app/blobs/uploads/", "s3cret", "")
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		resp = request("GET", registryURL+"/v2/", "", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Route", func(t *testing.T) {
+		resp := request("GET", baseURL+"/api/v1/extensions/denypaths", "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var rules struct {
+			Patterns []string `json:"patterns"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+		assert.Equal(t, []string{"**/*.exe"}, rules.Patterns)
+	})
+}
+
+func TestServerExtensionErrors(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	for name, extensions := range map[string]string{
+		"unknown":          "nosuchextension",
+		"missing settings": "bearer",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			_, err := server.New(&server.Config{
+				Host:         "127.0.0.1",
+				Port:         "0",
+				DataDir:      dir,
+				DatabasePath: filepath.Join(dir, "depot.db"),
+				Extensions:   extensions,
+			}, logrus.New())
+			assert.Error(t, err)
+		})
+	}
+}
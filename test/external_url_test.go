@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestExternalURL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.ExternalURL = "https://depot.example.com/"
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"files","type":"raw"}`,
+		`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, err := makeRequest("PUT", baseURL+"/repository/files/app.bin", bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/presign", strings.NewReader(`{"repository":"files","path":"app.bin"}`))
+	require.NoError(t, err)
+	var presigned map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&presigned))
+	resp.Body.Close()
+	assert.True(t, strings.HasPrefix(presigned["url"].(string), "https://depot.example.com/repository/files/app.bin?"), presigned["url"])
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/files/uploads", strings.NewReader(`{"path":"big.bin"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), "https://depot.example.com/api/v1/repositories/files/uploads/"), resp.Header.Get("Location"))
+
+	resp, err = makeRequest("POST", baseURL+"/v2/images/app/blobs/uploads/", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), "https://depot.example.com/v2/images/app/blobs/uploads/"), resp.Header.Get("Location"))
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestFederationAPI(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", strings.NewReader(
+		`{"name":"central","type":"docker","config":{"http_port":0,"https_port":0,"require_pull_token":true}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+"/v2/central/app/manifests/1.0", bytes.NewReader(
+		[]byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	mint := func(path, body string) (int, map[string]interface{}) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/central/"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+	status, _ := mint("federation-tokens", `{"expires_in":"400d"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, token := mint("federation-tokens", `{"expires_in":"90d"}`)
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, true, token["federation"])
+	status, pullToken := mint("pull-tokens", `{"image":"app"}`)
+	require.Equal(t, http.StatusCreated, status)
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+	changes := func(token string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("GET", baseURL+"/api/v1/repositories/central/changes", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.SetBasicAuth("edge", token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var feed map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&feed)
+		return resp.StatusCode, feed
+	}
+	status, _ = changes("")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = changes(pullToken["token"].(string))
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, feed := changes(token["token"].(string))
+	require.Equal(t, http.StatusOK, status)
+	require.Len(t, feed["changes"], 1)
+	change := feed["changes"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "app", change["image"])
+	assert.Equal(t, "1.0", change["tag"])
+
+	// Only proxy repositories are synced
+	status, _ = mint("sync", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.TrustedProxies = "127.0.0.1, ::1"
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"files","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+"/repository/files/app.bin", bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	req, err := http.NewRequest("POST", baseURL+"/api/v1/presign", strings.NewReader(`{"repository":"files","path":"app.bin"}`))
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "depot.example.com")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.True(t, strings.HasPrefix(result["url"].(string), "https://depot.example.com/repository/files/app.bin?"), result["url"])
+}
+
+func TestProxyProtocol(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.TrustedProxies = "127.0.0.1"
+		config.ProxyProtocol = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://127.0.0.1:%s", s.GetPort())
+
+	// A load balancer passes the client address in a PROXY header
+	proxied := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				_, err = fmt.Fprintf(conn, "PROXY TCP4 198.51.100.1 127.0.0.1 40000 %s\r\n", s.GetPort())
+				return conn, err
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+	resp, err := proxied.Get(baseURL + "/api/v1/health")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A trusted source that does not send one is refused
+	_, err = makeRequest("GET", baseURL+"/api/v1/health", nil)
+	assert.Error(t, err)
+}
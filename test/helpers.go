@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -31,6 +32,16 @@ func startTestServer(t *testing.T) (*server.Server, func()) {
 
 // startTestServerWithDataDir starts a test server with a specific data directory
 func startTestServerWithDataDir(t *testing.T, dataDir string) (*server.Server, func()) {
+	return startConfiguredTestServer(t, dataDir, nil)
+}
+
+// startTestServerWithConfig starts a test server after letting configure
+// adjust its configuration
+func startTestServerWithConfig(t *testing.T, configure func(*server.Config)) (*server.Server, func()) {
+	return startConfiguredTestServer(t, t.TempDir(), configure)
+}
+
+func startConfiguredTestServer(t *testing.T, dataDir string, configure func(*server.Config)) (*server.Server, func()) {
 	certFile := filepath.Join(dataDir, "server.crt")
 	keyFile := filepath.Join(dataDir, "server.key")
 	
@@ -45,6 +56,9 @@ func startTestServerWithDataDir(t *testing.T, dataDir string) (*server.Server, f
 		KeyFile:      keyFile,
 		DatabasePath: filepath.Join(dataDir, "depot.db"),
 	}
+	if configure != nil {
+		configure(config)
+	}
 
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
@@ -62,13 +76,22 @@ func startTestServerWithDataDir(t *testing.T, dataDir string) (*server.Server, f
 		}
 	}()
 
-	// Wait for server to start
+	// Wait for the ports to be bound, then for the startup checks to finish
 	select {
 	case err := <-errChan:
 		cancel()
 		t.Fatalf("Server failed to start: %v", err)
-	case <-time.After(2 * time.Second):
-		// Server started successfully
+	case <-srv.Listening():
+	case <-time.After(30 * time.Second):
+		cancel()
+		t.Fatal("Server did not start listening within timeout")
+	}
+	// A main port expecting PROXY headers cannot be polled directly
+	if !config.ProxyProtocol {
+		if err := waitForReadiness(fmt.Sprintf("https://127.0.0.1:%s", srv.GetPort()), 30*time.Second); err != nil {
+			cancel()
+			t.Fatal(err)
+		}
 	}
 
 	cleanup := func() {
@@ -118,6 +141,30 @@ func waitForServer(address string, timeout time.Duration) error {
 	return fmt.Errorf("server not ready after %v", timeout)
 }
 
+// waitForReadiness waits until the server at address has finished its startup
+// checks, whether or not they found it ready
+func waitForReadiness(address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		resp, err := makeRequest("GET", address+"/readyz", nil)
+		if err == nil {
+			var status struct {
+				Status string `json:"status"`
+			}
+			decodeErr := json.NewDecoder(resp.Body).Decode(&status)
+			resp.Body.Close()
+			if decodeErr == nil && status.Status != "starting" {
+				return nil
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return fmt.Errorf("server still starting after %v", timeout)
+}
+
 // generateTestCertificate generates a self-signed certificate for testing
 func generateTestCertificate(certFile, keyFile string) error {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
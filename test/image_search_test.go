@@ -0,0 +1,54 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestImageSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"images","type":"docker","config":{"http_port":0,"https_port":0}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for tag, team := range map[string]string{"1.0": "payments", "2.0": "search"} {
+		manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],"annotations":{"team":"` + team + `"}}`)
+		resp, err := makeRequest("PUT", baseURL+"/v2/images/app/manifests/"+tag, bytes.NewReader(manifest))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err = makeRequest("GET", baseURL+"/api/v1/images/search?label=team=payments", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var matches []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&matches))
+	require.Len(t, matches, 1)
+	assert.Equal(t, "images", matches[0]["repository"])
+	assert.Equal(t, "app", matches[0]["image"])
+	assert.Equal(t, []interface{}{"1.0"}, matches[0]["tags"])
+
+	resp, err = makeRequest("GET", baseURL+"/api/v1/images/search?label=team", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
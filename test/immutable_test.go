@@ -0,0 +1,75 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImmutablePaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"app","type":"raw","config":{"immutable_paths":["releases/**"]}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	put := func(path, content string) int {
+		resp, err := makeRequest("PUT", baseURL+"/repository/app/"+path, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusCreated, put("releases/1.0/app.bin", "1.0"))
+	require.Equal(t, http.StatusCreated, put("snapshots/app.bin", "snapshot"))
+
+	t.Run("Overwrite Rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, put("releases/1.0/app.bin", "tampered"))
+
+		resp, err := makeRequest("GET", baseURL+"/repository/app/releases/1.0/app.bin", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "1.0", string(body))
+	})
+
+	t.Run("Delete Forbidden", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/repository/app/releases/1.0/app.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Move Out Forbidden", func(t *testing.T) {
+		reqBody := []byte(`{"source":"app","source_path":"releases","destination":"app","destination_path":"archive"}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/artifacts/move", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Mutable Paths Unaffected", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, put("snapshots/app.bin", "snapshot 2"))
+		assert.Equal(t, http.StatusCreated, put("releases/1.1/app.bin", "1.1"))
+
+		resp, err := makeRequest("DELETE", baseURL+"/repository/app/snapshots/app.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("Invalid Pattern Rejected", func(t *testing.T) {
+		reqBody := []byte(`{"name":"broken","type":"raw","config":{"immutable_paths":["releases/["]}}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryImport(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	// An Artifactory repository with two good artifacts and one whose
+	// content does not match the checksum Artifactory has for it
+	files := map[string]string{
+		"app/1.0/app.tar.gz": "release 1.0",
+		"app/2.0/app.tar.gz": "release 2.0",
+		"app/2.0/broken.bin": "corrupted",
+	}
+	artifactory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/artifactory/api/storage/generic-local":
+			var list []map[string]interface{}
+			for path, content := range files {
+				sum := sha256.Sum256([]byte(content))
+				if path == "app/2.0/broken.bin" {
+					sum = sha256.Sum256([]byte("original"))
+				}
+				list = append(list, map[string]interface{}{
+					"uri": "/" + path, "size": len(content), "folder": false,
+					"lastModified": "2024-03-01T10:00:00.000Z", "sha2": fmt.Sprintf("%x", sum),
+				})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"files": list})
+		case "/artifactory/api/storage/generic-local/app/2.0/app.tar.gz":
+			w.Write([]byte(`{"properties":{"build.number":["42"]}}`))
+		default:
+			path := r.URL.Path[len("/artifactory/generic-local/"):]
+			if content, exists := files[path]; exists {
+				w.Write([]byte(content))
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer artifactory.Close()
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"migrated","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	type result struct {
+		Imported int   `json:"imported"`
+		Skipped  int   `json:"skipped"`
+		Bytes    int64 `json:"bytes"`
+		Failed   []struct {
+			Path  string `json:"path"`
+			Error string `json:"error"`
+		} `json:"failed"`
+	}
+	runImport := func(repo, sourceURL string, total int64) result {
+		body := fmt.Sprintf(`{"type":"artifactory","url":%q,"repository":"generic-local","token":"secret"}`, sourceURL+"/artifactory")
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/"+repo+"/import", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		var task struct {
+			ID     string          `json:"id"`
+			Status string          `json:"status"`
+			Type   string          `json:"type"`
+			Total  int64           `json:"total"`
+			Result json.RawMessage `json:"result"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		assert.Equal(t, "import", task.Type)
+
+		deadline := time.Now().Add(10 * time.Second)
+		for task.Status != "succeeded" {
+			require.True(t, time.Now().Before(deadline), "import did not finish, status %s", task.Status)
+			require.NotEqual(t, "failed", task.Status)
+			time.Sleep(50 * time.Millisecond)
+			resp, err := makeRequest("GET", baseURL+"/api/v1/tasks/"+task.ID, nil)
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		}
+		assert.Equal(t, total, task.Total)
+		var r result
+		require.NoError(t, json.Unmarshal(task.Result, &r))
+		return r
+	}
+
+	t.Run("Import", func(t *testing.T) {
+		r := runImport("migrated", artifactory.URL, 3)
+		assert.Equal(t, 2, r.Imported)
+		assert.Equal(t, int64(22), r.Bytes)
+		require.Len(t, r.Failed, 1)
+		assert.Equal(t, "app/2.0/broken.bin", r.Failed[0].Path)
+
+		resp, err := makeRequest("GET", baseURL+"/repository/migrated/app/1.0/app.tar.gz", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "release 1.0", string(body))
+
+		resp, err = makeRequest("GET", baseURL+"/repository/migrated/app/2.0/broken.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/migrated/properties/app/2.0/app.tar.gz", nil)
+		require.NoError(t, err)
+		var properties map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&properties))
+		assert.Equal(t, "42", properties["build.number"])
+		assert.Equal(t, artifactory.URL+"/artifactory/generic-local", properties["import.source"])
+		assert.Equal(t, "2024-03-01T10:00:00Z", properties["import.last_modified"])
+	})
+
+	t.Run("Import Again Skips Existing Artifacts", func(t *testing.T) {
+		r := runImport("migrated", artifactory.URL, 3)
+		assert.Equal(t, 0, r.Imported)
+		assert.Equal(t, 2, r.Skipped)
+		assert.Len(t, r.Failed, 1)
+	})
+
+	t.Run("Write Rules", func(t *testing.T) {
+		// A source that understates the size of an artifact
+		understated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/artifactory/api/storage/generic-local":
+				w.Write([]byte(`{"files":[{"uri":"/large.bin","size":1,"folder":false}]}`))
+			case "/artifactory/api/storage/generic-local/large.bin":
+				w.Write([]byte(`{"properties":{}}`))
+			default:
+				w.Write(bytes.Repeat([]byte("x"), 100))
+			}
+		}))
+		defer understated.Close()
+
+		for name, config := range map[string]string{"limited": `{"max_artifact_size":10}`, "addressed": `{"content_addressed":true}`} {
+			resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"`+name+`","type":"raw","config":`+config+`}`)))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+		}
+
+		r := runImport("limited", understated.URL, 1)
+		assert.Equal(t, 0, r.Imported)
+		require.Len(t, r.Failed, 1)
+		assert.Contains(t, r.Failed[0].Error, "maximum upload size")
+		resp, err := makeRequest("GET", baseURL+"/repository/limited/large.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		r = runImport("addressed", understated.URL, 1)
+		assert.Equal(t, 0, r.Imported)
+		require.Len(t, r.Failed, 1)
+		assert.Contains(t, r.Failed[0].Error, "content-addressed")
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/limited/import?storage_class=frozen", bytes.NewReader([]byte(`{"type":"artifactory","url":"https://artifactory.example.com","repository":"x"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Invalid Source", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/migrated/import", bytes.NewReader([]byte(`{"type":"gitlab","url":"https://gitlab.example.com","repository":"x"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
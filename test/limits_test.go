@@ -0,0 +1,51 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadSizeLimits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"small","type":"raw","config":{"max_artifact_size":10}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/small/file.txt", bytes.NewReader([]byte("tiny")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Oversized Upload Rejected", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/small/file.txt", bytes.NewReader(bytes.Repeat([]byte("x"), 11)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		// The existing artifact is left untouched
+		resp, err = makeRequest("GET", baseURL+"/repository/small/file.txt", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "tiny", string(body))
+	})
+
+	t.Run("Negative Limit Rejected", func(t *testing.T) {
+		reqBody := []byte(`{"name":"broken","type":"raw","config":{"max_artifact_size":-1}}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryListing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	browse := func(path string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", baseURL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for _, name := range []string{"builds", "private"} {
+		config := "{}"
+		if name == "private" {
+			config = `{"disable_directory_listing":true}`
+		}
+		reqBody := []byte(fmt.Sprintf(`{"name":%q,"type":"raw","config":%s}`, name, config))
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		for _, path := range []string{"nightly/2024-01-01/app.bin", "nightly/big.bin", "nightly/small.txt"} {
+			content := "x"
+			if strings.HasSuffix(path, "big.bin") {
+				content = strings.Repeat("x", 100)
+			}
+			resp, err := makeRequest("PUT", fmt.Sprintf("%s/repository/%s/%s", baseURL, name, path), strings.NewReader(content))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	t.Run("Directory Index", func(t *testing.T) {
+		resp, body := browse("/repository/builds/nightly/")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+		assert.Contains(t, body, "Index of /repository/builds/nightly/")
+		assert.Contains(t, body, `<a href="../">`)
+		assert.Contains(t, body, `<a href="2024-01-01/">2024-01-01/</a>`)
+		assert.Contains(t, body, `<a href="big.bin">big.bin</a>`)
+	})
+
+	t.Run("Sorted By Size", func(t *testing.T) {
+		_, body := browse("/repository/builds/nightly/?sort=size&order=desc")
+		// Directories come first, then files from largest to smallest
+		assert.Less(t, strings.Index(body, "2024-01-01/"), strings.Index(body, "big.bin"))
+		assert.Less(t, strings.Index(body, "big.bin"), strings.Index(body, "small.txt"))
+
+		_, body = browse("/repository/builds/nightly/?sort=size&order=asc")
+		assert.Less(t, strings.Index(body, "small.txt"), strings.Index(body, "big.bin"))
+	})
+
+	t.Run("Redirects To Trailing Slash", func(t *testing.T) {
+		resp, _ := browse("/repository/builds/nightly")
+		assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "/repository/builds/nightly/", resp.Header.Get("Location"))
+
+		resp, body := browse("/repository/builds/")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, body, `<a href="nightly/">`)
+	})
+
+	t.Run("API Clients Get 404", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/builds/nightly/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Listing Disabled", func(t *testing.T) {
+		resp, _ := browse("/repository/private/nightly/")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLevel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	url := fmt.Sprintf("https://localhost:%s/api/v1/admin/log-level", s.GetPort())
+
+	level := func() string {
+		resp, err := makeRequest("GET", url, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result["level"]
+	}
+
+	assert.Equal(t, "debug", level())
+
+	resp, err := makeRequest("PUT", url, strings.NewReader(`{"level":"warn"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "warning", level())
+
+	resp, err = makeRequest("PUT", url, strings.NewReader(`{"level":"chatty"}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "warning", level())
+}
+
+func TestAccessLogSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	url := baseURL + "/api/v1/admin/access-log"
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", strings.NewReader(`{"name":"busy","type":"raw"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	setting := func(path string) map[string]interface{} {
+		resp, err := makeRequest("GET", url+path, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	assert.Equal(t, map[string]interface{}{"enabled": true, "sample_rate": 1.0}, setting("/busy"))
+	assert.Empty(t, setting(""))
+
+	// Omitted fields keep their defaults
+	resp, err = makeRequest("PUT", url+"/busy", strings.NewReader(`{"sample_rate":0.05}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"enabled": true, "sample_rate": 0.05}, setting("/busy"))
+
+	resp, err = makeRequest("PUT", url+"/busy", strings.NewReader(`{"enabled":false}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]interface{}{"busy": map[string]interface{}{"enabled": false, "sample_rate": 1.0}}, setting(""))
+
+	resp, err = makeRequest("PUT", url+"/busy", strings.NewReader(`{"sample_rate":2}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", url+"/missing", strings.NewReader(`{"enabled":false}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Requests to a repository without logging still succeed
+	resp, err = makeRequest("PUT", baseURL+"/repository/busy/file.txt", strings.NewReader("data"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("DELETE", url+"/busy", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, setting(""))
+}
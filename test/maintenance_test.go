@@ -0,0 +1,105 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"releases","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = makeRequest("PUT", baseURL+"/repository/releases/app.tar.gz", bytes.NewReader([]byte("v1")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	readiness := func() (int, []map[string]interface{}) {
+		resp, err := makeRequest("GET", baseURL+"/readyz", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var status struct {
+			Problems []map[string]interface{} `json:"problems"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return resp.StatusCode, status.Problems
+	}
+
+	t.Run("Read Only", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/admin/maintenance", bytes.NewReader([]byte(`{"mode":"read-only","message":"nightly backup"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/releases/app.tar.gz", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "read-only", resp.Header.Get("X-Depot-Maintenance"))
+
+		resp, err = makeRequest("PUT", baseURL+"/repository/releases/app-2.tar.gz", bytes.NewReader([]byte("v2")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "Server is read-only for maintenance: nightly backup", body["error"])
+
+		code, problems := readiness()
+		assert.Equal(t, http.StatusOK, code)
+		require.Len(t, problems, 1)
+		assert.Equal(t, "maintenance", problems[0]["component"])
+		assert.Equal(t, "warning", problems[0]["severity"])
+	})
+
+	t.Run("Offline", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/admin/maintenance", bytes.NewReader([]byte(`{"mode":"offline"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/releases/app.tar.gz", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "offline", resp.Header.Get("X-Depot-Maintenance"))
+
+		code, _ := readiness()
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/admin/maintenance", nil)
+		require.NoError(t, err)
+		var status map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		assert.Equal(t, true, status["enabled"])
+		assert.Equal(t, "offline", status["mode"])
+	})
+
+	t.Run("End", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/api/v1/admin/maintenance", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = makeRequest("PUT", baseURL+"/repository/releases/app-2.tar.gz", bytes.NewReader([]byte("v2")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("X-Depot-Maintenance"))
+
+		code, problems := readiness()
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, problems)
+
+		resp, err = makeRequest("PUT", baseURL+"/api/v1/admin/maintenance", bytes.NewReader([]byte(`{"mode":"paused"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
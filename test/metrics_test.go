@@ -0,0 +1,84 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageMetrics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"metered","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/metered/file.bin", bytes.NewReader([]byte("0123456789")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for i := 0; i < 2; i++ {
+		resp, err = makeRequest("GET", baseURL+"/repository/metered/file.bin", nil)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	t.Run("Prometheus", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/metrics", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), `depot_repository_requests_total{repository="metered",operation="download"} 2`)
+		assert.Contains(t, string(body), `depot_repository_served_bytes_total{repository="metered",operation="download"} 20`)
+		assert.Contains(t, string(body), `depot_repository_received_bytes_total{repository="metered",operation="upload"} 10`)
+	})
+
+	t.Run("Usage API", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/metered/usage?interval=day", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var usage []struct {
+			Operations map[string]struct {
+				Requests      int64 `json:"requests"`
+				BytesReceived int64 `json:"bytes_received"`
+				BytesServed   int64 `json:"bytes_served"`
+			} `json:"operations"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+		require.NotEmpty(t, usage)
+
+		var downloads, served, received int64
+		for _, bucket := range usage {
+			downloads += bucket.Operations["download"].Requests
+			served += bucket.Operations["download"].BytesServed
+			received += bucket.Operations["upload"].BytesReceived
+		}
+		assert.Equal(t, int64(2), downloads)
+		assert.Equal(t, int64(20), served)
+		assert.Equal(t, int64(10), received)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/metered/usage?interval=week", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	request := func(method, path, body string) (int, map[string]interface{}) {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		resp, err := makeRequest(method, baseURL+"/api/v1"+path, reader)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+	list := func(query string) []string {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories"+query, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var repos []map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&repos))
+		names := []string{}
+		for _, repo := range repos {
+			names = append(names, repo["name"].(string))
+		}
+		return names
+	}
+
+	status, _ := request("POST", "/namespaces", `{"name":"payments","description":"Payments team","metadata":{"team":"payments"},`+
+		`"defaults":{"raw":{"allowed_extensions":[".jar",".pom"],"max_artifact_size":1048576}}}`)
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = request("POST", "/namespaces", `{"name":"payments"}`)
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = request("POST", "/namespaces", `{"name":"bad/name"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request("POST", "/namespaces", `{"name":"search","defaults":{"npm":{}}}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request("POST", "/namespaces", `{"name":"search","defaults":{"raw":{"max_artifact_size":-1}}}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Repositories created in a namespace start from its defaults
+	status, repo := request("POST", "/repositories", `{"name":"payments-releases","type":"raw","namespace":"payments","config":{"max_artifact_size":2048}}`)
+	require.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, "payments", repo["namespace"])
+	assert.Equal(t, map[string]interface{}{"allowed_extensions": []interface{}{".jar", ".pom"}, "max_artifact_size": float64(2048)}, repo["config"])
+	status, _ = request("POST", "/repositories", `{"name":"payments-builds","type":"raw","namespace":"payments"}`)
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = request("POST", "/repositories", `{"name":"search-builds","type":"raw"}`)
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = request("POST", "/repositories", `{"name":"orphan","type":"raw","namespace":"missing"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	resp, err := makeRequest("PUT", baseURL+"/repository/payments-builds/app.exe", strings.NewReader("binary"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	assert.ElementsMatch(t, []string{"payments-releases", "payments-builds"}, list("?namespace=payments"))
+	assert.Equal(t, []string{"search-builds"}, list("?namespace="))
+	status, ns := request("GET", "/namespaces/payments", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.ElementsMatch(t, []interface{}{"payments-releases", "payments-builds"}, ns["repositories"])
+	assert.Equal(t, "Payments team", ns["description"])
+
+	// A namespace with repositories cannot be deleted
+	status, _ = request("DELETE", "/namespaces/payments", "")
+	assert.Equal(t, http.StatusConflict, status)
+
+	// Repositories move between namespaces with an update
+	status, _ = request("POST", "/namespaces", `{"name":"search"}`)
+	require.Equal(t, http.StatusCreated, status)
+	status, _ = request("PUT", "/repositories/search-builds", `{"namespace":"missing"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request("PUT", "/repositories/search-builds", `{"namespace":"search"}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"search-builds"}, list("?namespace=search"))
+
+	status, ns = request("PUT", "/namespaces/payments", `{"description":"Payments"}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "payments", ns["metadata"].(map[string]interface{})["team"])
+	assert.NotNil(t, ns["defaults"])
+
+	for _, name := range []string{"payments-releases", "payments-builds"} {
+		status, _ = request("DELETE", "/repositories/"+name, "")
+		require.Equal(t, http.StatusNoContent, status)
+	}
+	status, ns = request("GET", "/namespaces/payments", "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []interface{}{}, ns["repositories"])
+	status, _ = request("DELETE", "/namespaces/payments", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request("GET", "/namespaces/payments", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	resp, err = makeRequest("GET", baseURL+"/api/v1/namespaces", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var namespaces []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&namespaces))
+	require.Len(t, namespaces, 1)
+	assert.Equal(t, "search", namespaces[0]["name"])
+}
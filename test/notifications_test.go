@@ -0,0 +1,95 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationSubscriptions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", strings.NewReader(`{"name":"releases","type":"raw"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, body := range []string{
+		`{"email":"ops@example.com","events":["nonsense"]}`,
+		`{"email":"nope","events":["cleanup.deleted"]}`,
+		`{"email":"ops@example.com","repository":"missing","events":["cleanup.deleted"]}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/notifications/subscriptions", strings.NewReader(body))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/notifications/subscriptions", strings.NewReader(`{"email":"ops@example.com","repository":"releases","events":["cleanup.deleted","scan.infected"]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var sub map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sub))
+	resp.Body.Close()
+	require.NotEmpty(t, sub["id"])
+
+	list := func(query string) []map[string]interface{} {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/notifications/subscriptions"+query, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var subs []map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&subs))
+		return subs
+	}
+	assert.Len(t, list(""), 1)
+	assert.Len(t, list("?repository=releases"), 1)
+	assert.Empty(t, list("?repository=other"))
+
+	resp, err = makeRequest("DELETE", baseURL+"/api/v1/notifications/subscriptions/"+sub["id"].(string), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, err = makeRequest("DELETE", baseURL+"/api/v1/notifications/subscriptions/"+sub["id"].(string), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, list(""))
+
+	// Uploads are pushed to webhooks
+	received := make(chan map[string]interface{}, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer webhook.Close()
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/notifications/subscriptions", strings.NewReader(`{"target":"http","url":"`+webhook.URL+`/hooks/secret","repository":"releases","events":["artifact.pushed"]}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	subs := list("")
+	require.Len(t, subs, 1)
+	assert.Equal(t, webhook.URL+"/********", subs[0]["url"])
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/releases/app/1.0/app.jar", strings.NewReader("jar"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	select {
+	case event := <-received:
+		assert.Equal(t, "artifact.pushed", event["type"])
+		assert.Equal(t, "releases", event["repository"])
+		assert.Equal(t, []interface{}{"app/1.0/app.jar"}, event["artifacts"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook request for the upload")
+	}
+}
@@ -0,0 +1,68 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverwriteProtection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, reqBody := range []string{
+		`{"name":"open","type":"raw"}`,
+		`{"name":"guarded","type":"raw","config":{"disable_overwrite":true}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	put := func(url, content string) int {
+		resp, err := makeRequest("PUT", baseURL+url, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	get := func(url string) string {
+		resp, err := makeRequest("GET", baseURL+url, nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("Overwrite Query Parameter", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("/repository/open/app.bin", "v1"))
+		assert.Equal(t, http.StatusOK, put("/repository/open/app.bin?overwrite=false", "v1"))
+		assert.Equal(t, http.StatusConflict, put("/repository/open/app.bin?overwrite=false", "v2"))
+		assert.Equal(t, http.StatusConflict, put("/repository/open/app.bin?overwrite=false", "v1 but longer"))
+		assert.Equal(t, "v1", get("/repository/open/app.bin"))
+
+		// Overwriting stays the default
+		assert.Equal(t, http.StatusOK, put("/repository/open/app.bin", "v2"))
+		assert.Equal(t, "v2", get("/repository/open/app.bin"))
+	})
+
+	t.Run("Repository Default", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("/repository/guarded/app.bin", "v1"))
+		assert.Equal(t, http.StatusOK, put("/repository/guarded/app.bin", "v1"))
+		assert.Equal(t, http.StatusConflict, put("/repository/guarded/app.bin", "v2"))
+		assert.Equal(t, http.StatusOK, put("/repository/guarded/app.bin?overwrite=true", "v2"))
+		assert.Equal(t, "v2", get("/repository/guarded/app.bin"))
+	})
+
+	t.Run("Invalid Parameter", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, put("/repository/open/app.bin?overwrite=maybe", "v3"))
+	})
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/policy"
+	"github.com/depot/depot/internal/server"
+)
+
+func TestAuthorizationPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Stand-in for OPA: the loaded policy lets anyone read, the ci user
+	// write to the releases repository and the admin user do anything
+	var mu sync.Mutex
+	loaded := make(map[string]string)
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var source bytes.Buffer
+			source.ReadFrom(r.Body)
+			mu.Lock()
+			loaded[r.URL.Path] = source.String()
+			mu.Unlock()
+			w.Write([]byte(`{}`))
+			return
+		}
+		var body struct {
+			Input policy.Input `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		input := body.Input
+		allow := input.Action == policy.ActionRead || input.Identity.User == "admin" ||
+			(input.Identity.User == "ci" && input.Repository == "releases" && input.Action != policy.ActionAdmin)
+		result := map[string]interface{}{"allow": allow}
+		if !allow {
+			result["reason"] = fmt.Sprintf("%s may not %s", input.Identity.User, input.Action)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	defer opa.Close()
+
+	policyDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(policyDir, "authz.rego"), []byte("package depot.authz\n"), 0644))
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.PolicyURL = opa.URL
+		config.PolicyDir = policyDir
+	})
+	defer cleanup()
+	assert.Contains(t, loaded["/v1/policies/depot/authz"], "package depot.authz")
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+	request := func(method, path, user, body string) *http.Response {
+		req, err := http.NewRequest(method, baseURL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, "password")
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := request("POST", "/api/v1/repositories", "ci", `{"name":"releases","type":"raw"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	var denied map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&denied))
+	assert.Equal(t, "Access denied by policy: ci may not admin", denied["error"])
+
+	resp = request("POST", "/api/v1/repositories", "admin", `{"name":"releases","type":"raw"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = request("PUT", "/repository/releases/app/1.0/app.tar.gz", "", "content")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = request("PUT", "/repository/releases/app/1.0/app.tar.gz", "ci", "content")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = request("GET", "/repository/releases/app/1.0/app.tar.gz", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = request("DELETE", "/api/v1/repositories/releases", "ci", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Health checks are answered whatever the policy says
+	opa.Close()
+	resp = request("GET", "/api/v1/health", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = request("GET", "/repository/releases/app/1.0/app.tar.gz", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// The main port's registry base and catalog are not a registry's own
+	for _, path := range []string{"/v2/", "/v2/_catalog"} {
+		resp = request("GET", path, "", "")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, path)
+	}
+}
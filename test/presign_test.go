@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresignedURLs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"private","type":"raw","config":{"require_signed_urls":true}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/private/app/1.0/app.bin", bytes.NewReader([]byte("secret build")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	presign := func(body string) (*http.Response, map[string]interface{}) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/presign", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result
+	}
+
+	t.Run("Unsigned Download Refused", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/private/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Signed Download", func(t *testing.T) {
+		resp, result := presign(`{"repository":"private","path":"app/1.0/app.bin","expires_in":"10m"}`)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NotEmpty(t, result["url"])
+		assert.NotEmpty(t, result["expires_at"])
+
+		resp, err := makeRequest("GET", result["url"].(string), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "secret build", string(body))
+
+		// The signature is bound to the path
+		other := strings.Replace(result["url"].(string), "app.bin", "app.bin.sha256", 1)
+		resp, err = makeRequest("GET", other, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		resp, _ := presign(`{"repository":"private","path":"missing.bin"}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, _ = presign(`{"repository":"private","path":"app/1.0/app.bin","expires_in":"30d"}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp, _ = presign(`{"repository":"missing","path":"app.bin"}`)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactProperties(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	reqBody := []byte(`{"name":"builds","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	upload := func(path string, properties ...string) int {
+		req, err := http.NewRequest("PUT", baseURL+"/repository/builds/"+path, bytes.NewReader([]byte(path)))
+		require.NoError(t, err)
+		for _, p := range properties {
+			req.Header.Add("X-Artifact-Property", p)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	search := func(query string) []string {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/artifacts/search?"+query, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var results []struct {
+			Path string `json:"path"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+		paths := []string{}
+		for _, r := range results {
+			paths = append(paths, r.Path)
+		}
+		return paths
+	}
+
+	require.Equal(t, http.StatusCreated, upload("app/41/app.bin", "build=41", "git_sha=abc123", "env=staging"))
+	require.Equal(t, http.StatusCreated, upload("app/42/app.bin", "build=42", "git_sha=def456", "env=staging"))
+
+	t.Run("Invalid Property Header", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, upload("app/43/app.bin", "no-value"))
+	})
+
+	t.Run("Search By Property", func(t *testing.T) {
+		assert.Equal(t, []string{"app/41/app.bin", "app/42/app.bin"}, search("property=env%3Dstaging"))
+		assert.Equal(t, []string{"app/42/app.bin"}, search("property=env%3Dstaging&property=git_sha%3Ddef456"))
+		assert.Empty(t, search("property=env%3Dstaging&path="+url.QueryEscape("lib/**")))
+	})
+
+	t.Run("Update Properties Later", func(t *testing.T) {
+		resp, err := makeRequest("PATCH", baseURL+"/api/v1/repositories/builds/properties/app/42/app.bin",
+			bytes.NewReader([]byte(`{"env":"prod","git_sha":null}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var properties map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&properties))
+		assert.Equal(t, map[string]string{"build": "42", "env": "prod"}, properties)
+
+		assert.Equal(t, []string{"app/42/app.bin"}, search("property=env%3Dprod"))
+	})
+
+	t.Run("Properties Require Artifact", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/repositories/builds/properties/missing.bin",
+			bytes.NewReader([]byte(`{"env":"prod"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Deleted Artifacts Drop Out", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/repository/builds/app/41/app.bin", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		assert.Empty(t, search("property=build%3D41"))
+	})
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactProvenance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+		`{"name":"attested","type":"raw","config":{"provenance_policy":{"trusted_builders":["https://github.com/actions/runner"],"require_attestation":true}}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	content := "release binary"
+	resp, err = makeRequest("PUT", baseURL+"/repository/attested/app/app.bin", bytes.NewReader([]byte(content)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	download := func() int {
+		resp, err := makeRequest("GET", baseURL+"/repository/attested/app/app.bin", nil)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	attach := func(body string) (int, string) {
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/repositories/attested/provenance/app/app.bin", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	statement := func(content string) string {
+		return fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v1","subject":[{"name":"app.bin","digest":{"sha256":"%x"}}],`+
+			`"predicateType":"https://slsa.dev/provenance/v1","predicate":{"runDetails":{"builder":{"id":"https://github.com/actions/runner"}}}}`,
+			sha256.Sum256([]byte(content)))
+	}
+
+	assert.Equal(t, http.StatusForbidden, download())
+
+	// Build details alone do not satisfy a policy requiring an attestation
+	status, _ := attach(`{"builder":"https://github.com/actions/runner","commit":"abc123"}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, http.StatusForbidden, download())
+
+	status, _ = attach(`{"attestation":` + statement("other content") + `}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body := attach(`{"commit":"abc123","workflow_run_url":"https://github.com/example/app/actions/runs/1","attestation":` + statement(content) + `}`)
+	require.Equal(t, http.StatusOK, status, body)
+	assert.Equal(t, http.StatusOK, download())
+
+	resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/attested/provenance/app/app.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var p struct {
+		Builder string `json:"builder"`
+		Commit  string `json:"commit"`
+		Digest  string `json:"digest"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&p))
+	assert.Equal(t, "https://github.com/actions/runner", p.Builder)
+	assert.Equal(t, "abc123", p.Commit)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content))), p.Digest)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/attested/app?archive=zip", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Provenance of an overwritten artifact no longer applies
+	resp, err = makeRequest("PUT", baseURL+"/repository/attested/app/app.bin", bytes.NewReader([]byte("rebuilt binary")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusForbidden, download())
+}
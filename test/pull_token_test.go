@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestPullTokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DockerPathRouting = true
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"private","type":"docker","config":{"http_port":0,"https_port":0,"require_pull_token":true}}`,
+		`{"name":"public","type":"docker","config":{"http_port":0,"https_port":0}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`)
+	for _, target := range []string{"private/app/manifests/1.0", "private/app/manifests/2.0", "private/other/manifests/1.0", "public/app/manifests/1.0"} {
+		resp, err := makeRequest("PUT", baseURL+"/v2/"+target, bytes.NewReader(manifest))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	mint := func(body string) (int, string) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/private/pull-tokens", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		token, _ := result["token"].(string)
+		return resp.StatusCode, token
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+	pull := func(path, token string, basic bool) int {
+		req, err := http.NewRequest("GET", baseURL+"/v2/"+path, nil)
+		require.NoError(t, err)
+		if basic {
+			req.SetBasicAuth("ci", token)
+		} else if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Token Required", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, pull("private/app/manifests/1.0", "", false))
+		assert.Equal(t, http.StatusUnauthorized, pull("private/app/manifests/1.0", "forged.token", false))
+		assert.Equal(t, http.StatusOK, pull("public/app/manifests/1.0", "", false))
+
+		// docker is asked for credentials, and the private images are not listed
+		assert.Equal(t, http.StatusUnauthorized, pull("", "", false))
+		resp, err := makeRequest("GET", baseURL+"/v2/_catalog", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var catalog map[string][]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&catalog))
+		assert.Equal(t, []string{"public/app"}, catalog["repositories"])
+	})
+
+	t.Run("Image Token", func(t *testing.T) {
+		status, token := mint(`{"image":"app","expires_in":"5m"}`)
+		require.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, http.StatusOK, pull("", token, true))
+		assert.Equal(t, http.StatusOK, pull("private/app/manifests/1.0", token, true))
+		assert.Equal(t, http.StatusOK, pull("private/app/manifests/2.0", token, false))
+		assert.Equal(t, http.StatusOK, pull("private/app/tags/list", token, false))
+		assert.Equal(t, http.StatusUnauthorized, pull("private/other/manifests/1.0", token, false))
+	})
+
+	t.Run("Single-Use Tag Token", func(t *testing.T) {
+		status, token := mint(`{"image":"app","tag":"1.0","single_use":true}`)
+		require.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, http.StatusUnauthorized, pull("private/app/manifests/2.0", token, false))
+		assert.Equal(t, http.StatusOK, pull("private/app/manifests/1.0", token, false))
+		assert.Equal(t, http.StatusUnauthorized, pull("private/app/manifests/1.0", token, false))
+
+		// The pull it started can still fetch content by digest
+		resp, err := makeRequest("HEAD", baseURL+"/v2/public/app/manifests/1.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, pull("private/app/manifests/"+resp.Header.Get("Docker-Content-Digest"), token, false))
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		status, _ := mint(`{"image":"missing"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = mint(`{"image":"app","tag":"3.0"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = mint(`{"image":"app","expires_in":"7d"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = mint(`{"image":""}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestUploadQueue(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.UploadConcurrency = 1
+		config.QueueTimeout = 300 * time.Millisecond
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, name := range []string{"hot", "cold"} {
+		reqBody := []byte(`{"name":"` + name + `","type":"raw"}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	metrics := func() string {
+		resp, err := makeRequest("GET", baseURL+"/metrics", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// A slow upload holds the only slot
+	reader, writer := io.Pipe()
+	done := make(chan int)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		req, _ := http.NewRequest("PUT", baseURL+"/repository/hot/big.bin", reader)
+		resp, err := client.Do(req)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	writer.Write([]byte("partial"))
+	require.Eventually(t, func() bool {
+		return strings.Contains(metrics(), `depot_queue_running{queue="uploads",repository="hot"} 1`)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	t.Run("Busy", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/cold/small.bin", strings.NewReader("small"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+		assert.Contains(t, metrics(), `depot_queue_timeouts_total{queue="uploads",repository="cold"} 1`)
+	})
+
+	t.Run("Downloads Not Queued", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/cold/missing.bin", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Admitted Once Free", func(t *testing.T) {
+		writer.Close()
+		assert.Equal(t, http.StatusCreated, <-done)
+
+		resp, err := makeRequest("PUT", baseURL+"/repository/cold/small.bin", strings.NewReader("small"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Contains(t, metrics(), `depot_queue_capacity{queue="uploads"} 1`)
+	})
+}
@@ -53,7 +53,13 @@ func TestRawRepositoryOperations(t *testing.T) {
 	}()
 
 	// Wait for server to start
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-serverErrCh:
+		t.Fatalf("Server failed to start: %v", err)
+	case <-srv.Listening():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not start listening within timeout")
+	}
 
 	// Create HTTP client
 	client := &http.Client{
@@ -152,6 +158,51 @@ func TestRawRepositoryOperations(t *testing.T) {
 		}
 	})
 
+	// Test 4b: Conditional download of an unchanged artifact
+	t.Run("ConditionalDownload", func(t *testing.T) {
+		url := fmt.Sprintf("%s/repository/test-raw-repo/simple.txt", baseURL)
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		etag := resp.Header.Get("ETag")
+		lastModified := resp.Header.Get("Last-Modified")
+		require.NotEmpty(t, etag)
+		require.NotEmpty(t, lastModified)
+
+		for header, value := range map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified} {
+			req, err := http.NewRequest("GET", url, nil)
+			require.NoError(t, err)
+			req.Header.Set(header, value)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotModified, resp.StatusCode, header)
+			assert.Empty(t, body)
+		}
+	})
+
+	// Test 4c: Partial download
+	t.Run("RangeDownload", func(t *testing.T) {
+		url := fmt.Sprintf("%s/repository/test-raw-repo/simple.txt", baseURL)
+		req, err := http.NewRequest("GET", url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", "bytes=7-14")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, "bytes 7-14/23", resp.Header.Get("Content-Range"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "artifact", string(body))
+	})
+
 	// Test 5: Test non-existent artifact
 	t.Run("DownloadNonExistentArtifact", func(t *testing.T) {
 		url := fmt.Sprintf("%s/repository/test-raw-repo/does/not/exist.txt", baseURL)
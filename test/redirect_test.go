@@ -0,0 +1,67 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestHTTPRedirect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	challenges := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(challenges, "tok3n_A-1"), []byte("tok3n_A-1.thumbprint"), 0644))
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.RedirectAddress = "127.0.0.1:0"
+		config.ACMEChallengeDir = challenges
+		config.HSTSMaxAge = 24 * time.Hour
+	})
+	defer cleanup()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	redirectURL := "http://" + s.RedirectAddress()
+
+	resp, err := client.Get(redirectURL + "/repository/files/app.bin?version=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, fmt.Sprintf("https://127.0.0.1:%s/repository/files/app.bin?version=1", s.GetPort()), resp.Header.Get("Location"))
+
+	resp, err = client.Post(redirectURL+"/api/v1/repositories", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusPermanentRedirect, resp.StatusCode)
+
+	resp, err = client.Get(redirectURL + "/.well-known/acme-challenge/tok3n_A-1")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "tok3n_A-1.thumbprint", string(body))
+
+	for _, token := range []string{"missing", "..%2Fsecret"} {
+		resp, err = client.Get(redirectURL + "/.well-known/acme-challenge/" + token)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = makeRequest("GET", fmt.Sprintf("https://localhost:%s/api/v1/health", s.GetPort()), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "max-age=86400", resp.Header.Get("Strict-Transport-Security"))
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleases(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"tools","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, path := range []string{"cli/1.9.0/cli.tar.gz", "cli/1.10.0/cli.tar.gz", "cli/1.10.0/checksums.txt", "cli/2.0.0-rc.1/cli.tar.gz", "cli/README.md", "agent/v0.3/agent.zip"} {
+		resp, err := makeRequest("PUT", baseURL+"/repository/tools/"+path, bytes.NewReader([]byte(path)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	type release struct {
+		Project    string `json:"project"`
+		Version    string `json:"version"`
+		Notes      string `json:"notes"`
+		Prerelease bool   `json:"prerelease"`
+		Assets     []struct {
+			Name   string `json:"name"`
+			SHA256 string `json:"sha256"`
+			URL    string `json:"url"`
+		} `json:"assets"`
+	}
+
+	t.Run("Record Notes", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/api/v1/repositories/tools/releases/cli/1.10.0", bytes.NewReader([]byte(`{"title":"1.10","notes":"Adds sync"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/tools/releases/cli/latest", bytes.NewReader([]byte(`{}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("List Projects", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases", nil)
+		require.NoError(t, err)
+		var projects []struct {
+			Project  string   `json:"project"`
+			Latest   string   `json:"latest"`
+			Versions []string `json:"versions"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&projects))
+		require.Len(t, projects, 2)
+		assert.Equal(t, "agent", projects[0].Project)
+		assert.Equal(t, "cli", projects[1].Project)
+		assert.Equal(t, "1.10.0", projects[1].Latest)
+		assert.Equal(t, []string{"2.0.0-rc.1", "1.10.0", "1.9.0"}, projects[1].Versions)
+	})
+
+	t.Run("Get Release", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases/cli/latest", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var latest release
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&latest))
+		assert.Equal(t, "1.10.0", latest.Version)
+		assert.Equal(t, "Adds sync", latest.Notes)
+		require.Len(t, latest.Assets, 2)
+		assert.Equal(t, "checksums.txt", latest.Assets[0].Name)
+		assert.Len(t, latest.Assets[1].SHA256, 64)
+		assert.Equal(t, baseURL+"/repository/tools/cli/1.10.0/cli.tar.gz", latest.Assets[1].URL)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases/cli", nil)
+		require.NoError(t, err)
+		var list []release
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		require.Len(t, list, 3)
+		assert.True(t, list[0].Prerelease)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases/cli/3.0.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Download Latest", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases/cli/latest/cli.tar.gz", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "cli/1.10.0/cli.tar.gz", string(body))
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/tools/releases/cli/latest/missing.zip", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Delete Notes", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/api/v1/repositories/tools/releases/cli/1.10.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = makeRequest("DELETE", baseURL+"/api/v1/repositories/tools/releases/cli/1.10.0", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryMetadata(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, body := range []string{
+		`{"name":"payments-builds","type":"raw","metadata":{"team":"payments","cost_center":"cc-42"},"notes":"Nightly builds of the payment service"}`,
+		`{"name":"search-builds","type":"raw","metadata":{"team":"search"}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	list := func(query string) []string {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories"+query, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var repos []map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&repos))
+		names := []string{}
+		for _, repo := range repos {
+			names = append(names, repo["name"].(string))
+		}
+		return names
+	}
+	get := func() map[string]interface{} {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/payments-builds", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var repo map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&repo))
+		return repo
+	}
+
+	repo := get()
+	assert.Equal(t, map[string]interface{}{"team": "payments", "cost_center": "cc-42"}, repo["metadata"])
+	assert.Equal(t, "Nightly builds of the payment service", repo["notes"])
+
+	assert.Equal(t, []string{"payments-builds"}, list("?metadata=team=payments"))
+	assert.Equal(t, []string{"payments-builds"}, list("?metadata=team=payments&metadata=cost_center=cc-42"))
+	assert.Empty(t, list("?metadata=team=billing"))
+
+	// An update without metadata keeps it
+	resp, err := makeRequest("PUT", baseURL+"/api/v1/repositories/payments-builds", strings.NewReader(`{"description":"Builds","notes":"Owned by #payments"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	repo = get()
+	assert.Equal(t, "payments", repo["metadata"].(map[string]interface{})["team"])
+	assert.Equal(t, "Owned by #payments", repo["notes"])
+
+	resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/payments-builds", strings.NewReader(`{"metadata":{"team":"billing"}}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"payments-builds"}, list("?metadata=team=billing"))
+
+	resp, err = makeRequest("PUT", baseURL+"/api/v1/repositories/payments-builds", strings.NewReader(`{"metadata":{"bad name":"x"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = makeRequest("GET", baseURL+"/api/v1/repositories?metadata=team", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"app","type":"raw","config":{"response_headers":[
+		{"cache_control":"no-cache","headers":{"X-Served-By":"depot"}},
+		{"path_pattern":"releases/**","cache_control":"immutable","content_disposition":"attachment"}
+	]}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	for _, path := range []string{"releases/1.0/app bundle.tar.gz", "snapshots/app.tar.gz"} {
+		resp, err := makeRequest("PUT", baseURL+"/repository/app/"+path, bytes.NewReader([]byte("content")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	t.Run("Matching Rules", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/app/releases/1.0/app%20bundle.tar.gz", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "public, max-age=31536000, immutable", resp.Header.Get("Cache-Control"))
+		assert.Equal(t, `attachment; filename="app bundle.tar.gz"`, resp.Header.Get("Content-Disposition"))
+		assert.Equal(t, "depot", resp.Header.Get("X-Served-By"))
+
+		resp, err = makeRequest("HEAD", baseURL+"/repository/app/snapshots/app.tar.gz", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+		assert.Empty(t, resp.Header.Get("Content-Disposition"))
+	})
+
+	t.Run("Not Modified", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/repository/app/releases/1.0/app%20bundle.tar.gz", nil)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("GET", baseURL+"/repository/app/releases/1.0/app%20bundle.tar.gz", nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+		resp, err = client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, "public, max-age=31536000, immutable", resp.Header.Get("Cache-Control"))
+	})
+
+	t.Run("Invalid Configuration", func(t *testing.T) {
+		for _, config := range []string{
+			`{"response_headers":[{"content_disposition":"download"}]}`,
+			`{"response_headers":[{"headers":{"Content-Length":"1"}}]}`,
+			`{"response_headers":[{"headers":{"Bad Name":"x"}}]}`,
+			`{"response_headers":[{"path_pattern":"releases/["}]}`,
+		} {
+			reqBody := []byte(`{"name":"broken","type":"raw","config":` + config + `}`)
+			resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, config)
+		}
+	})
+}
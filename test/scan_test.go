@@ -0,0 +1,86 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestVirusScanning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	// Stand-in for clamscan: flags anything containing the EICAR marker
+	script := filepath.Join(t.TempDir(), "scan.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nif grep -q EICAR; then echo Eicar-Test-Signature; exit 1; fi\n"), 0755))
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.ScanCommand = script
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"downloads","type":"raw"}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Clean Upload", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/downloads/tool.bin", bytes.NewReader([]byte("harmless")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = makeRequest("HEAD", baseURL+"/repository/downloads/tool.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("X-Artifact-Scan"), "clean; scanner=command")
+	})
+
+	t.Run("Infected Upload", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/downloads/evil.bin", bytes.NewReader([]byte("EICAR test")))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/downloads/evil.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/quarantine?repository=downloads", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var items []map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+		require.Len(t, items, 1)
+		assert.Equal(t, "evil.bin", items[0]["path"])
+		assert.Equal(t, "Eicar-Test-Signature", items[0]["signature"])
+
+		resp, err = makeRequest("DELETE", baseURL+"/api/v1/quarantine/"+items[0]["id"].(string), nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("Infected Archive Entry", func(t *testing.T) {
+		archive := buildZip(t, map[string]string{"docs/readme.txt": "hello", "docs/evil.txt": "EICAR"})
+		resp, err := makeRequest("PUT", baseURL+"/repository/downloads/bundle?extract=true", bytes.NewReader(archive))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		// Nothing from a rejected archive is published
+		resp, err = makeRequest("GET", baseURL+"/repository/downloads/bundle/docs/readme.txt", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestBuiltinSchedules(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	dir := t.TempDir()
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.DataDir = filepath.Join(dir, "data")
+		config.GCSchedule = "@daily"
+		config.BackupDir = filepath.Join(dir, "backups")
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	type entry struct {
+		Name       string `json:"name"`
+		Task       string `json:"task"`
+		Cron       string `json:"cron"`
+		Enabled    bool   `json:"enabled"`
+		Builtin    bool   `json:"builtin"`
+		LastTaskID string `json:"last_task_id"`
+	}
+	schedule := func(name string) entry {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/schedules/"+name, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var e entry
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+		return e
+	}
+
+	t.Run("Registered", func(t *testing.T) {
+		assert.Equal(t, entry{Name: "gc", Task: "gc", Cron: "@daily", Enabled: true, Builtin: true}, schedule("gc"))
+		assert.Equal(t, entry{Name: "scrub", Task: "scrub", Cron: "@weekly", Builtin: true}, schedule("scrub"))
+		assert.Equal(t, entry{Name: "backup", Task: "backup", Cron: "@daily", Builtin: true}, schedule("backup"))
+	})
+
+	// result runs a task on a schedule of its own and returns its result
+	result := func(task string, v interface{}) {
+		body := fmt.Sprintf(`{"name":"test-%s","task":%q,"cron":"@every 1s","enabled":true}`, task, task)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/schedules", bytes.NewReader([]byte(body)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		defer makeRequest("DELETE", baseURL+"/api/v1/schedules/test-"+task, nil)
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			require.True(t, time.Now().Before(deadline), "%s did not run", task)
+			time.Sleep(100 * time.Millisecond)
+			id := schedule("test-" + task).LastTaskID
+			if id == "" {
+				continue
+			}
+			resp, err := makeRequest("GET", baseURL+"/api/v1/tasks/"+id, nil)
+			require.NoError(t, err)
+			var run struct {
+				Status string          `json:"status"`
+				Error  string          `json:"error"`
+				Result json.RawMessage `json:"result"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&run))
+			require.NotEqual(t, "failed", run.Status, run.Error)
+			if run.Status == "succeeded" {
+				require.NoError(t, json.Unmarshal(run.Result, v))
+				return
+			}
+		}
+	}
+
+	t.Run("Scrub", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"files","type":"raw"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		for _, name := range []string{"good.txt", "bad.txt"} {
+			resp, err = makeRequest("PUT", baseURL+"/repository/files/"+name, bytes.NewReader([]byte("content")))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+		}
+
+		// Rot that keeps the size and modification time
+		bad := filepath.Join(dir, "data", "artifacts", "files", "bad.txt")
+		info, err := os.Stat(bad)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(bad, []byte("CONTENT"), 0644))
+		require.NoError(t, os.Chtimes(bad, info.ModTime(), info.ModTime()))
+
+		var report struct {
+			Checked int      `json:"checked"`
+			Corrupt []string `json:"corrupt"`
+		}
+		result("scrub", &report)
+		assert.Equal(t, 2, report.Checked)
+		assert.Equal(t, []string{"files/bad.txt"}, report.Corrupt)
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		var report struct {
+			Path string `json:"path"`
+		}
+		result("backup", &report)
+		assert.Equal(t, filepath.Join(dir, "backups"), filepath.Dir(report.Path))
+		assert.FileExists(t, report.Path)
+	})
+}
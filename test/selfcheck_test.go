@@ -0,0 +1,124 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+	"github.com/depot/depot/pkg/models"
+)
+
+type readiness struct {
+	Status   string `json:"status"`
+	Problems []struct {
+		Component string `json:"component"`
+		Name      string `json:"name"`
+		Severity  string `json:"severity"`
+		Repaired  bool   `json:"repaired"`
+	} `json:"problems"`
+}
+
+func getReadiness(t *testing.T, s *server.Server) (int, *readiness) {
+	url := fmt.Sprintf("https://localhost:%s/readyz", s.GetPort())
+
+	// The checks run in the background after startup
+	var status readiness
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err := makeRequest("GET", url, nil)
+		require.NoError(t, err)
+		status = readiness{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		resp.Body.Close()
+		if status.Status != "starting" || time.Now().After(deadline) {
+			return resp.StatusCode, &status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStartupConsistencyCheck(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	dataDir := t.TempDir()
+
+	// A raw repository with one artifact and a Docker registry on a port of
+	// its own
+	portListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	registryPort := portListener.Addr().(*net.TCPAddr).Port
+	portListener.Close()
+
+	s1, cleanup1 := startConfiguredTestServer(t, dataDir, nil)
+	baseURL := fmt.Sprintf("https://localhost:%s", s1.GetPort())
+
+	code, status := getReadiness(t, s1)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", status.Status)
+
+	for _, repo := range []models.Repository{
+		{Name: "consistency-raw", Type: models.RepositoryTypeRaw},
+		{Name: "consistency-docker", Type: models.RepositoryTypeDocker, Config: json.RawMessage(fmt.Sprintf(`{"http_port": %d}`, registryPort))},
+	} {
+		body, _ := json.Marshal(repo)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, err := makeRequest("PUT", baseURL+"/repository/consistency-raw/lost.txt", bytes.NewReader([]byte("lost")))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	cleanup1()
+
+	// Lose the artifact and the registry's port
+	require.NoError(t, os.Remove(filepath.Join(dataDir, "data", "artifacts", "consistency-raw", "lost.txt")))
+	blocker, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", registryPort))
+	require.NoError(t, err)
+	defer blocker.Close()
+
+	t.Run("Report", func(t *testing.T) {
+		s, cleanup := startConfiguredTestServer(t, dataDir, nil)
+		defer cleanup()
+
+		code, status := getReadiness(t, s)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "not ready", status.Status)
+
+		found := map[string]bool{}
+		for _, problem := range status.Problems {
+			found[problem.Component+" "+problem.Severity] = true
+			assert.False(t, problem.Repaired)
+		}
+		assert.True(t, found["docker error"], "%+v", status.Problems)
+		assert.True(t, found["metadata warning"], "%+v", status.Problems)
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		blocker.Close()
+
+		s, cleanup := startConfiguredTestServer(t, dataDir, func(config *server.Config) {
+			config.SelfRepair = true
+		})
+		defer cleanup()
+
+		code, status := getReadiness(t, s)
+		assert.Equal(t, http.StatusOK, code)
+		require.Len(t, status.Problems, 1)
+		assert.Equal(t, "metadata", status.Problems[0].Component)
+		assert.Equal(t, "consistency-raw/lost.txt", status.Problems[0].Name)
+		assert.True(t, status.Problems[0].Repaired)
+	})
+}
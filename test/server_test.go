@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"net/http"
 	"path/filepath"
 	"testing"
@@ -46,12 +47,12 @@ func TestServerStartStop(t *testing.T) {
 		serverErrCh <- err
 	}()
 
-	time.Sleep(100 * time.Millisecond)
-
 	select {
 	case err := <-serverErrCh:
 		t.Fatalf("Server failed to start: %v", err)
-	default:
+	case <-srv.Listening():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not start listening within timeout")
 	}
 
 	httpsURL := "https://" + config.Host + ":" + srv.GetPort() + "/api/v1/health"
@@ -84,4 +85,31 @@ func TestServerStartStop(t *testing.T) {
 	case err := <-serverErrCh:
 		assert.NoError(t, err, "Server should shut down without error")
 	}
-}
\ No newline at end of file
+}
+
+func TestSystemDiagnostics(t *testing.T) {
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	resp, err := makeRequest("GET", "https://localhost:"+s.GetPort()+"/api/v1/system/diagnostics", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var diagnostics struct {
+		Status string `json:"status"`
+		Checks []struct {
+			Component string `json:"component"`
+			Status    string `json:"status"`
+		} `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&diagnostics))
+	assert.Equal(t, "healthy", diagnostics.Status)
+
+	components := map[string]string{}
+	for _, check := range diagnostics.Checks {
+		components[check.Component] = check.Status
+	}
+	assert.Equal(t, "ok", components["storage"])
+	assert.Equal(t, "ok", components["database"])
+}
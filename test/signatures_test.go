@@ -0,0 +1,126 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactSigning(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	send := func(method, url string, body []byte) *http.Response {
+		resp, err := makeRequest(method, baseURL+url, bytes.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+	read := func(resp *http.Response) string {
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v1/signing-keys", []byte(`{"name":"releases","type":"cosign"}`)).StatusCode)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v1/signing-keys", []byte(`{"name":"apt","type":"gpg","user_id":"Depot <depot@example.com>","bits":2048}`)).StatusCode)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v1/repositories", []byte(`{"name":"signed","type":"raw","config":{"signing_key":"releases"}}`)).StatusCode)
+	require.Equal(t, http.StatusCreated, send("POST", "/api/v1/repositories", []byte(`{"name":"plain","type":"raw"}`)).StatusCode)
+
+	resp := send("GET", "/keys/releases", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	block, _ := pem.Decode([]byte(read(resp)))
+	require.NotNil(t, block)
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	require.NoError(t, err)
+	public := parsed.(*ecdsa.PublicKey)
+
+	verify := func(content, signature string) bool {
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(content))
+		return ecdsa.VerifyASN1(public, digest[:], value)
+	}
+
+	t.Run("Uploads Are Signed", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, send("PUT", "/repository/signed/app/1.0/app.tar.gz", []byte("release 1.0")).StatusCode)
+
+		resp := send("GET", "/repository/signed/app/1.0/app.tar.gz.sig", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, verify("release 1.0", read(resp)))
+
+		assert.Equal(t, http.StatusOK, send("HEAD", "/repository/signed/app/1.0/app.tar.gz.sig", nil).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/repository/signed/app/1.0/app.tar.gz.asc", nil).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/repository/signed/app/1.0/missing.tar.gz.sig", nil).StatusCode)
+
+		resp = send("GET", "/api/v1/repositories/signed/signatures/app/1.0/app.tar.gz", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var sig struct {
+			Key        string `json:"key"`
+			KeyVersion int    `json:"key_version"`
+			KeyType    string `json:"key_type"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&sig))
+		assert.Equal(t, "releases", sig.Key)
+		assert.Equal(t, 1, sig.KeyVersion)
+		assert.Equal(t, "cosign", sig.KeyType)
+	})
+
+	t.Run("Overwritten Artifacts Are Signed Again", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, send("PUT", "/repository/signed/app/1.0/app.tar.gz", []byte("release 1.0, rebuilt")).StatusCode)
+
+		resp := send("GET", "/repository/signed/app/1.0/app.tar.gz.sig", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, verify("release 1.0, rebuilt", read(resp)))
+	})
+
+	t.Run("Changed Signing Key", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, send("PUT", "/api/v1/repositories/signed", []byte(`{"config":{"signing_key":"apt"}}`)).StatusCode)
+		resp := send("GET", "/repository/signed/app/1.0/app.tar.gz.asc", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, strings.HasPrefix(read(resp), "-----BEGIN PGP SIGNATURE-----\n"))
+		assert.Equal(t, http.StatusNotFound, send("GET", "/repository/signed/app/1.0/app.tar.gz.sig", nil).StatusCode)
+
+		require.Equal(t, http.StatusOK, send("PUT", "/api/v1/repositories/signed", []byte(`{"config":{"signing_key":"releases"}}`)).StatusCode)
+		resp = send("GET", "/repository/signed/app/1.0/app.tar.gz.sig", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, verify("release 1.0, rebuilt", read(resp)))
+	})
+
+	t.Run("Signing On Demand", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, send("PUT", "/repository/plain/tool.bin", []byte("tool")).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("GET", "/repository/plain/tool.bin.asc", nil).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/repositories/plain/signatures/tool.bin", nil).StatusCode)
+		assert.Equal(t, http.StatusBadRequest, send("POST", "/api/v1/repositories/plain/signatures/tool.bin?key=missing", nil).StatusCode)
+		assert.Equal(t, http.StatusNotFound, send("POST", "/api/v1/repositories/plain/signatures/missing.bin?key=apt", nil).StatusCode)
+
+		assert.Equal(t, http.StatusCreated, send("POST", "/api/v1/repositories/plain/signatures/tool.bin?key=apt", nil).StatusCode)
+		resp := send("GET", "/repository/plain/tool.bin.asc", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/pgp-signature", resp.Header.Get("Content-Type"))
+		assert.True(t, strings.HasPrefix(read(resp), "-----BEGIN PGP SIGNATURE-----\n"))
+	})
+
+	t.Run("Unknown Signing Key", func(t *testing.T) {
+		resp := send("POST", "/api/v1/repositories", []byte(`{"name":"bad","type":"raw","config":{"signing_key":"missing"}}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = send("PUT", "/api/v1/repositories/plain", []byte(`{"config":{"signing_key":"missing"}}`))
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	type key struct {
+		Name        string  `json:"name"`
+		Version     int     `json:"version"`
+		Fingerprint string  `json:"fingerprint"`
+		RetiredAt   *string `json:"retired_at"`
+		PublicKey   string  `json:"public_key"`
+		PrivateKey  string  `json:"private_key"`
+	}
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/signing-keys", bytes.NewReader([]byte(`{"name":"apt","type":"gpg","user_id":"Depot <depot@example.com>","bits":2048}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "PRIVATE")
+	var created key
+	require.NoError(t, json.Unmarshal(body, &created))
+	assert.Equal(t, 1, created.Version)
+
+	t.Run("Duplicate And Invalid Keys", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/signing-keys", bytes.NewReader([]byte(`{"name":"apt","type":"gpg","user_id":"Depot"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/signing-keys", bytes.NewReader([]byte(`{"name":"yum","type":"gpg"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Rotate And Publish", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/signing-keys/apt/rotate", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var rotated key
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&rotated))
+		assert.Equal(t, 2, rotated.Version)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/signing-keys/apt", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var versions []key
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&versions))
+		require.Len(t, versions, 2)
+		assert.Nil(t, versions[0].RetiredAt)
+		assert.NotNil(t, versions[1].RetiredAt)
+
+		resp, err = makeRequest("GET", baseURL+"/keys/apt", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/pgp-keys", resp.Header.Get("Content-Type"))
+		public, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, 2, strings.Count(string(public), "BEGIN PGP PUBLIC KEY BLOCK"))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/api/v1/signing-keys/apt", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/keys/apt", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
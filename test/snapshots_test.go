@@ -0,0 +1,210 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositorySnapshots(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"prod","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	put := func(path, content string) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/prod/"+path, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		require.Contains(t, []int{http.StatusCreated, http.StatusOK}, resp.StatusCode)
+	}
+	put("app/1.0/app.bin", "release 1.0")
+	put("app/config.yaml", "replicas: 2")
+
+	type change struct {
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	}
+	type diff struct {
+		Added   []change `json:"added"`
+		Removed []change `json:"removed"`
+		Changed []change `json:"changed"`
+	}
+	getDiff := func(url string) diff {
+		resp, err := makeRequest("GET", url, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var d diff
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&d))
+		return d
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/prod/snapshots", bytes.NewReader([]byte(`{"name":"before-deploy","description":"Friday"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var summary struct {
+			Name      string `json:"name"`
+			Artifacts int    `json:"artifacts"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&summary))
+		assert.Equal(t, "before-deploy", summary.Name)
+		assert.Equal(t, 2, summary.Artifacts)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/prod/snapshots", bytes.NewReader([]byte(`{"name":"before-deploy"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/prod/snapshots", bytes.NewReader([]byte(`{"name":"../bad"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	// Deploy: add a release, change the config and drop the old release
+	put("app/2.0/app.bin", "release 2.0")
+	put("app/config.yaml", "replicas: 5")
+	resp, err = makeRequest("DELETE", baseURL+"/repository/prod/app/1.0/app.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	t.Run("DiffWithCurrent", func(t *testing.T) {
+		d := getDiff(baseURL + "/api/v1/repositories/prod/snapshots/before-deploy/diff")
+		assert.Equal(t, []change{{Kind: "artifact", Name: "app/2.0/app.bin"}}, d.Added)
+		assert.Equal(t, []change{{Kind: "artifact", Name: "app/1.0/app.bin"}}, d.Removed)
+		assert.Equal(t, []change{{Kind: "artifact", Name: "app/config.yaml"}}, d.Changed)
+	})
+
+	t.Run("DiffSnapshots", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/prod/snapshots", bytes.NewReader([]byte(`{"name":"after-deploy"}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		d := getDiff(baseURL + "/api/v1/repositories/prod/snapshots/before-deploy/diff?to=after-deploy")
+		assert.Len(t, d.Added, 1)
+		assert.Len(t, d.Removed, 1)
+		assert.Len(t, d.Changed, 1)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/prod/snapshots", nil)
+		require.NoError(t, err)
+		var summaries []struct {
+			Name string `json:"name"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&summaries))
+		require.Len(t, summaries, 2)
+		assert.Equal(t, "before-deploy", summaries[0].Name)
+	})
+
+	// restore waits for a snapshot restore to succeed and returns its result
+	type restoreResult struct {
+		Restored []string `json:"restored"`
+		Deleted  []string `json:"deleted"`
+		Missing  []string `json:"missing"`
+	}
+	restore := func(snapshot string) restoreResult {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/prod/snapshots/"+snapshot+"/restore", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode)
+		var task struct {
+			ID     string        `json:"id"`
+			Status string        `json:"status"`
+			Error  string        `json:"error"`
+			Result restoreResult `json:"result"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+
+		deadline := time.Now().Add(10 * time.Second)
+		for task.Status != "succeeded" {
+			require.True(t, time.Now().Before(deadline), "task did not finish, status %s", task.Status)
+			require.NotEqual(t, "failed", task.Status, task.Error)
+			time.Sleep(50 * time.Millisecond)
+			resp, err := makeRequest("GET", baseURL+"/api/v1/tasks/"+task.ID, nil)
+			require.NoError(t, err)
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&task))
+		}
+		return task.Result
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		result := restore("before-deploy")
+
+		// Nothing in the server holds the old release or config any more
+		assert.Equal(t, []string{"app/2.0/app.bin"}, result.Deleted)
+		assert.ElementsMatch(t, []string{"app/1.0/app.bin", "app/config.yaml"}, result.Missing)
+		assert.Empty(t, result.Restored)
+	})
+
+	t.Run("RestoreFromCopy", func(t *testing.T) {
+		// Content still held elsewhere in the server is restored from there
+		put("app/2.0/app.bin", "release 2.0")
+		put("mirror/config.yaml", "replicas: 2")
+
+		result := restore("before-deploy")
+		assert.Equal(t, []string{"app/config.yaml"}, result.Restored)
+		assert.ElementsMatch(t, []string{"app/2.0/app.bin", "mirror/config.yaml"}, result.Deleted)
+
+		resp, err := makeRequest("GET", baseURL+"/repository/prod/app/config.yaml", nil)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "replicas: 2", string(data))
+
+		resp, err = makeRequest("GET", baseURL+"/repository/prod/app/2.0/app.bin", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("RestoreFromTrash", func(t *testing.T) {
+		// Content whose only copy is in another repository's trash
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"attic","type":"raw","config":{"trash_retention_days":7}}`)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, err = makeRequest("PUT", baseURL+"/repository/attic/app.bin", bytes.NewReader([]byte("release 1.0")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		resp, err = makeRequest("DELETE", baseURL+"/repository/attic/app.bin", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		result := restore("before-deploy")
+		assert.Equal(t, []string{"app/1.0/app.bin"}, result.Restored)
+		assert.Empty(t, result.Missing)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/prod/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "release 1.0", string(data))
+
+		// The trashed copy stays restorable where it was deleted
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/attic/trash", nil)
+		require.NoError(t, err)
+		var items []struct {
+			Path string `json:"path"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+		assert.Len(t, items, 1)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp, err := makeRequest("DELETE", baseURL+"/api/v1/repositories/prod/snapshots/after-deploy", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/prod/snapshots/after-deploy", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStagingRepositories(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+		`{"name":"maven-releases","type":"raw","config":{"disable_overwrite":true,"staging_rules":{"require_checksums":true,"validate_poms":true}}}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	stage := func() string {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/maven-releases/staging", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var repo struct {
+			Name string `json:"name"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&repo))
+		return repo.Name
+	}
+	upload := func(repo, path, content string) int {
+		resp, err := makeRequest("PUT", baseURL+"/repository/"+repo+"/"+path, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	post := func(repo, action string) (int, string) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/"+repo+"/"+action, nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	jar := "jar content"
+	pom := `<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>1.0</version></project>`
+	dir := "com/example/lib/1.0/"
+
+	t.Run("Close and Promote", func(t *testing.T) {
+		repo := stage()
+		assert.Equal(t, "maven-releases-staging-1", repo)
+
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.jar", jar))
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.pom", pom))
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.pom.sha1", fmt.Sprintf("%x", sha1.Sum([]byte(pom)))))
+
+		// Promoting needs a closed repository, and closing needs checksums
+		status, _ := post(repo, "promote")
+		assert.Equal(t, http.StatusConflict, status)
+		status, body := post(repo, "close")
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Contains(t, body, "lib-1.0.jar: missing checksum")
+
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.jar.sha1", fmt.Sprintf("%x", sha1.Sum([]byte(jar)))))
+		status, body = post(repo, "close")
+		require.Equal(t, http.StatusOK, status, body)
+		assert.Contains(t, body, `"state":"closed"`)
+
+		// Closed repositories take no more changes
+		assert.Equal(t, http.StatusConflict, upload(repo, dir+"lib-1.0-sources.jar", "sources"))
+		resp, err := makeRequest("DELETE", baseURL+"/repository/"+repo+"/"+dir+"lib-1.0.jar", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		status, body = post(repo, "promote")
+		require.Equal(t, http.StatusOK, status, body)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/maven-releases/"+dir+"lib-1.0.jar", nil)
+		require.NoError(t, err)
+		content, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, jar, string(content))
+
+		// The staging repository is gone
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/"+repo, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Invalid POM and Drop", func(t *testing.T) {
+		repo := stage()
+		snapshot := `<project><groupId>com.example</groupId><artifactId>lib</artifactId><version>1.1-SNAPSHOT</version></project>`
+		require.Equal(t, http.StatusCreated, upload(repo, "com/example/lib/1.1/lib-1.1.pom", snapshot))
+		require.Equal(t, http.StatusCreated, upload(repo, "com/example/lib/1.1/lib-1.1.pom.sha1", "0000"))
+
+		status, body := post(repo, "close")
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Contains(t, body, "is a snapshot")
+		assert.Contains(t, body, "sha1 checksum does not match")
+
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/maven-releases/staging", nil)
+		require.NoError(t, err)
+		listing, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(listing), repo)
+
+		status, _ = post(repo, "drop")
+		assert.Equal(t, http.StatusNoContent, status)
+		resp, err = makeRequest("GET", baseURL+"/api/v1/repositories/"+repo, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Conflicting Release", func(t *testing.T) {
+		repo := stage()
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.jar", "different"))
+		require.Equal(t, http.StatusCreated, upload(repo, dir+"lib-1.0.jar.sha1", fmt.Sprintf("%x", sha1.Sum([]byte("different")))))
+		status, _ := post(repo, "close")
+		require.Equal(t, http.StatusOK, status)
+
+		status, _ = post(repo, "promote")
+		assert.Equal(t, http.StatusConflict, status)
+		resp, err := makeRequest("GET", baseURL+"/repository/maven-releases/"+dir+"lib-1.0.jar", nil)
+		require.NoError(t, err)
+		content, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, jar, string(content))
+	})
+
+	t.Run("Staging State Is Managed", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(
+			`{"name":"fake-staging","type":"raw","config":{"staging":{"release":"maven-releases","state":"closed"}}}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		status, _ := post("maven-releases", "close")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageClasses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, reqBody := range []string{
+		`{"name":"ci","type":"raw","config":{"storage_class":"ephemeral","expiry_rules":[{"path_pattern":"logs/**","ttl":"1h"}]}}`,
+		`{"name":"archive","type":"raw","config":{"storage_classes":{"infrequent":{"ttl":"30d"},"ephemeral":{"ttl":"2h"}}}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	for _, reqBody := range []string{
+		`{"name":"bad","type":"raw","config":{"storage_class":"glacier"}}`,
+		`{"name":"bad","type":"raw","config":{"storage_classes":{"ephemeral":{"ttl":"soon"}}}}`,
+	} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(reqBody)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, reqBody)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+	put := func(url, class string) int {
+		req, err := http.NewRequest("PUT", baseURL+url, bytes.NewReader([]byte("data")))
+		require.NoError(t, err)
+		if class != "" {
+			req.Header.Set("X-Storage-Class", class)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	head := func(url string) (time.Duration, string) {
+		resp, err := makeRequest("HEAD", baseURL+url, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var ttl time.Duration
+		if header := resp.Header.Get("X-Artifact-Expires"); header != "" {
+			expiresAt, err := http.ParseTime(header)
+			require.NoError(t, err)
+			ttl = time.Until(expiresAt)
+		}
+		return ttl, resp.Header.Get("X-Storage-Class")
+	}
+
+	t.Run("Repository Default", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("/repository/ci/build/app.bin", ""))
+		ttl, class := head("/repository/ci/build/app.bin")
+		assert.InDelta(t, 7*24*time.Hour, ttl, float64(time.Minute))
+		assert.Equal(t, "ephemeral", class)
+
+		// Expiry rules take precedence over the class
+		require.Equal(t, http.StatusCreated, put("/repository/ci/logs/build.log", ""))
+		ttl, _ = head("/repository/ci/logs/build.log")
+		assert.InDelta(t, time.Hour, ttl, float64(time.Minute))
+	})
+
+	t.Run("Requested Class", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, put("/repository/ci/release/app.bin", "standard"))
+		ttl, class := head("/repository/ci/release/app.bin")
+		assert.Zero(t, ttl)
+		assert.Empty(t, class)
+
+		require.Equal(t, http.StatusCreated, put("/repository/archive/old.tar?storage_class=infrequent", ""))
+		ttl, class = head("/repository/archive/old.tar")
+		assert.InDelta(t, 30*24*time.Hour, ttl, float64(time.Minute))
+		assert.Equal(t, "infrequent", class)
+
+		require.Equal(t, http.StatusCreated, put("/repository/archive/tmp.bin", "Ephemeral"))
+		ttl, _ = head("/repository/archive/tmp.bin")
+		assert.InDelta(t, 2*time.Hour, ttl, float64(time.Minute))
+
+		assert.Equal(t, http.StatusBadRequest, put("/repository/archive/cold.bin", "glacier"))
+	})
+
+	t.Run("Overwrite Replaces Class", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, put("/repository/archive/old.tar", ""))
+		ttl, class := head("/repository/archive/old.tar")
+		assert.Zero(t, ttl)
+		assert.Empty(t, class)
+	})
+}
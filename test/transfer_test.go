@@ -0,0 +1,85 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/pkg/models"
+)
+
+func TestCopyAndMoveArtifacts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, name := range []string{"staging", "releases"} {
+		repo := models.Repository{Name: name, Type: models.RepositoryTypeRaw}
+		reqBody, _ := json.Marshal(repo)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	artifacts := map[string]string{
+		"app/1.0/app.jar": "application",
+		"app/1.0/app.pom": "pom",
+	}
+	for path, content := range artifacts {
+		resp, err := makeRequest("PUT", baseURL+"/repository/staging/"+path, bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	t.Run("Copy Prefix", func(t *testing.T) {
+		reqBody := []byte(`{"source":"staging","source_path":"app/1.0","destination":"releases","destination_path":"app/1.0"}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/artifacts/copy", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		for path, content := range artifacts {
+			resp, err := makeRequest("GET", baseURL+"/repository/releases/"+path, nil)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, content, string(body))
+
+			// Source is left in place
+			resp, err = makeRequest("HEAD", baseURL+"/repository/staging/"+path, nil)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("Move Single Artifact", func(t *testing.T) {
+		reqBody := []byte(`{"source":"staging","source_path":"app/1.0/app.jar","destination":"releases","destination_path":"archive/app.jar"}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/artifacts/move", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("HEAD", baseURL+"/repository/releases/archive/app.jar", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("HEAD", baseURL+"/repository/staging/app/1.0/app.jar", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Reject Path Traversal", func(t *testing.T) {
+		reqBody := []byte(`{"source":"staging","source_path":"../releases","destination":"releases","destination_path":"x"}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/artifacts/copy", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
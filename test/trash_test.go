@@ -0,0 +1,95 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawTrash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	reqBody := []byte(`{"name":"releases","type":"raw","config":{"trash_retention_days":7}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/releases/app/1.0/app.bin", bytes.NewReader([]byte("release 1.0")))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("DELETE", baseURL+"/repository/releases/app/1.0/app.bin", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	trashID := resp.Header.Get("X-Trash-Id")
+	require.NotEmpty(t, trashID)
+
+	resp, err = makeRequest("GET", baseURL+"/repository/releases/app/1.0/app.bin", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	t.Run("List Trash", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/releases/trash", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var items []struct {
+			ID   string `json:"id"`
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+		require.Len(t, items, 1)
+		assert.Equal(t, trashID, items[0].ID)
+		assert.Equal(t, "app/1.0/app.bin", items[0].Path)
+		assert.Equal(t, int64(11), items[0].Size)
+	})
+
+	t.Run("Restore Refused When Path Reused", func(t *testing.T) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/releases/app/1.0/app.bin", bytes.NewReader([]byte("rebuilt")))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/releases/trash/"+trashID+"/restore", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// Deleting the rebuild trashes it too
+		resp, err = makeRequest("DELETE", baseURL+"/repository/releases/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = makeRequest("DELETE", baseURL+"/api/v1/repositories/releases/trash/"+resp.Header.Get("X-Trash-Id"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/releases/trash/"+trashID+"/restore", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/releases/app/1.0/app.bin", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "release 1.0", string(body))
+
+		resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/releases/trash/"+trashID+"/restore", nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	upload := func(path, contentType string) int {
+		req, err := http.NewRequest("PUT", baseURL+"/repository/releases/"+path, bytes.NewReader([]byte("data")))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	reqBody := []byte(`{"name":"releases","type":"raw","config":{"content_types":["application/gzip","application/octet-stream"],"allowed_extensions":[".tar.gz"]}}`)
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Allowed Upload", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, upload("app-1.0.tar.gz", "application/gzip"))
+		assert.Equal(t, http.StatusCreated, upload("app-1.1.tar.gz", ""))
+	})
+
+	t.Run("Disallowed Content Type", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, upload("app-1.2.tar.gz", "text/html"))
+	})
+
+	t.Run("Disallowed Extension", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, upload("app-1.2.zip", "application/gzip"))
+	})
+
+	t.Run("Resumable Upload Checked", func(t *testing.T) {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories/releases/uploads",
+			bytes.NewReader([]byte(`{"path":"app.exe"}`)))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+
+	t.Run("Effective Policy", func(t *testing.T) {
+		resp, err := makeRequest("GET", baseURL+"/api/v1/repositories/releases/upload-policy", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var policy struct {
+			ContentTypes      []string `json:"content_types"`
+			AllowedExtensions []string `json:"allowed_extensions"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+		assert.Equal(t, []string{"application/gzip", "application/octet-stream"}, policy.ContentTypes)
+		assert.Equal(t, []string{".tar.gz"}, policy.AllowedExtensions)
+	})
+
+	t.Run("Invalid Policy Rejected", func(t *testing.T) {
+		reqBody := []byte(`{"name":"broken","type":"raw","config":{"content_types":["not a type"]}}`)
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
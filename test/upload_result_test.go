@@ -0,0 +1,66 @@
+package test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"builds","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	type result struct {
+		Repository string `json:"repository"`
+		Path       string `json:"path"`
+		Size       int64  `json:"size"`
+		Checksums  struct {
+			MD5    string `json:"md5"`
+			SHA1   string `json:"sha1"`
+			SHA256 string `json:"sha256"`
+		} `json:"checksums"`
+	}
+	put := func(content string) (*http.Response, result) {
+		resp, err := makeRequest("PUT", baseURL+"/repository/builds/app/1.0/app.bin", bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var stored result
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stored))
+		return resp, stored
+	}
+
+	resp, stored := put("build 1")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/repository/builds/app/1.0/app.bin", resp.Header.Get("Location"))
+	assert.Equal(t, "builds", stored.Repository)
+	assert.Equal(t, "app/1.0/app.bin", stored.Path)
+	assert.Equal(t, int64(7), stored.Size)
+	assert.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("build 1"))), stored.Checksums.MD5)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("build 1"))), stored.Checksums.SHA256)
+	assert.Equal(t, stored.Checksums.SHA256, resp.Header.Get("X-Checksum-Sha256"))
+	assert.NotEmpty(t, stored.Checksums.SHA1)
+
+	// Overwriting an artifact answers 200 with the new identity
+	resp, stored = put("build 1, rebuilt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/repository/builds/app/1.0/app.bin", resp.Header.Get("Location"))
+	assert.Equal(t, int64(16), stored.Size)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("build 1, rebuilt"))), stored.Checksums.SHA256)
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/uploads"
+)
+
+func TestResumableUploads(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	s, cleanup := startTestServer(t)
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(`{"name":"models","type":"raw"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("POST", baseURL+"/api/v1/repositories/models/uploads", bytes.NewReader([]byte(`{"path":"llm/weights.bin"}`)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var session uploads.Session
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	uploadURL := baseURL + resp.Header.Get("Location")
+
+	patch := func(offset int, data string) *http.Response {
+		req, err := http.NewRequest("PATCH", uploadURL, bytes.NewReader([]byte(data)))
+		require.NoError(t, err)
+		req.Header.Set("Upload-Offset", fmt.Sprint(offset))
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp = patch(0, "first-")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "6", resp.Header.Get("Upload-Offset"))
+
+	t.Run("Stale Offset Rejected", func(t *testing.T) {
+		resp := patch(0, "first-")
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Equal(t, "6", resp.Header.Get("Upload-Offset"))
+	})
+
+	t.Run("Query Offset", func(t *testing.T) {
+		resp, err := makeRequest("HEAD", uploadURL, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "6", resp.Header.Get("Upload-Offset"))
+	})
+
+	resp = patch(6, "second")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	t.Run("Complete", func(t *testing.T) {
+		resp, err := makeRequest("PUT", uploadURL, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = makeRequest("GET", baseURL+"/repository/models/llm/weights.bin", nil)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "first-second", string(body))
+
+		resp, err = makeRequest("GET", uploadURL, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
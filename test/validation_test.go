@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/depot/depot/internal/server"
+)
+
+func TestValidationHooks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "license.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nif grep -q GPL; then echo \"$DEPOT_PATH carries a GPL license\"; exit 1; fi\n"), 0755))
+	hooksFile := filepath.Join(dir, "hooks.json")
+	require.NoError(t, os.WriteFile(hooksFile, []byte(fmt.Sprintf(`[{"name":"license-check","command":%q,"repositories":["vendor"]}]`, script)), 0600))
+
+	s, cleanup := startTestServerWithConfig(t, func(config *server.Config) {
+		config.ValidationHooksFile = hooksFile
+	})
+	defer cleanup()
+
+	baseURL := fmt.Sprintf("https://localhost:%s", s.GetPort())
+
+	for _, name := range []string{"vendor", "scratch"} {
+		resp, err := makeRequest("POST", baseURL+"/api/v1/repositories", bytes.NewReader([]byte(fmt.Sprintf(`{"name":%q,"type":"raw"}`, name))))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, err := makeRequest("PUT", baseURL+"/repository/vendor/lib/mit.tar.gz", bytes.NewReader([]byte("MIT license")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = makeRequest("PUT", baseURL+"/repository/vendor/lib/gpl.tar.gz", bytes.NewReader([]byte("GPL license")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "Upload rejected by license-check: lib/gpl.tar.gz carries a GPL license", body["error"])
+
+	resp, err = makeRequest("GET", baseURL+"/repository/vendor/lib/gpl.tar.gz", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// The hook is limited to the vendor repository
+	resp, err = makeRequest("PUT", baseURL+"/repository/scratch/lib/gpl.tar.gz", bytes.NewReader([]byte("GPL license")))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}